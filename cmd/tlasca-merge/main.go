@@ -0,0 +1,137 @@
+// Package main является точкой входа утилиты tlasca-merge, которая объединяет частичные
+// результаты распределенного (тайлового) расчета go-tlasca в итоговую карту контраста.
+//
+// Использование:
+//
+//	tlasca-merge [--overwrite] <файл.tpart | директория> ...
+//
+// Для директорий используются все файлы *.tpart. Параметры окна, диапазона отображения,
+// масок исключения и дефектных пикселей и выходного файла берутся из go-tlasca.json
+// в текущей директории.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+
+	"github.com/mascotmascot1/go-tlasca/internal/atomicfile"
+	"github.com/mascotmascot1/go-tlasca/internal/config"
+	"github.com/mascotmascot1/go-tlasca/internal/imageutils"
+	"github.com/mascotmascot1/go-tlasca/internal/pathutil"
+	"github.com/mascotmascot1/go-tlasca/internal/render"
+	"github.com/mascotmascot1/go-tlasca/pkg/mask"
+	"github.com/mascotmascot1/go-tlasca/pkg/tlasca"
+)
+
+func main() {
+	logger := log.New(os.Stdout, "[GO-TLASCA] ", log.LstdFlags)
+	overwrite := flag.Bool("overwrite", false, "replace an existing result image")
+	flag.Parse()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	err := run(ctx, logger, flag.Args(), *overwrite)
+	stop()
+	if err != nil {
+		logger.Fatalf("merge failed: %v\n", err)
+	}
+}
+
+// run загружает частичные результаты, объединяет их и сохраняет карту контраста.
+// Существующая карта заменяется только при overwrite; отмена ctx (Ctrl-C) прерывает расчет карты.
+func run(ctx context.Context, logger *log.Logger, args []string, overwrite bool) error {
+	const configPath = "go-tlasca.json"
+	if len(args) == 0 {
+		return fmt.Errorf("usage: tlasca-merge [--overwrite] <file.tpart | directory> ...")
+	}
+
+	cfg, err := config.NewConfig(configPath, logger)
+	if err != nil {
+		return fmt.Errorf("error loading config: %w", err)
+	}
+	normalizer, err := render.NewNormalizer(cfg.Output)
+	if err != nil {
+		return fmt.Errorf("invalid output normalization: %w", err)
+	}
+	newPath := filepath.Join(cfg.Paths.ResultsDir, cfg.Paths.OutputFilename)
+	if _, err := os.Stat(newPath); err == nil && !overwrite {
+		return fmt.Errorf("output '%s' already exists; use --overwrite to replace it", newPath)
+	}
+	if !overwrite {
+		// Карта, появившаяся во время объединения, также не заменяется.
+		defer atomicfile.Protect(cfg.Paths.ResultsDir)()
+	}
+
+	var paths []string
+	for _, arg := range args {
+		arg = pathutil.Native(arg)
+		info, err := os.Stat(arg)
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			paths = append(paths, arg)
+			continue
+		}
+		matches, err := pathutil.ListFiles(arg, []string{"*.tpart"}, nil)
+		if err != nil {
+			return fmt.Errorf("error reading directory '%s': %w", arg, err)
+		}
+		paths = append(paths, matches...)
+	}
+
+	parts := make([]*tlasca.Partial, 0, len(paths))
+	for _, path := range paths {
+		part, err := tlasca.ReadPartial(path)
+		if err != nil {
+			return fmt.Errorf("error loading partial result '%s': %w", path, err)
+		}
+		parts = append(parts, part)
+	}
+	logger.Printf("loaded %d partial results.\n", len(parts))
+	if len(parts) == 0 {
+		return fmt.Errorf("no partial results found")
+	}
+
+	var exclusion *mask.Mask
+	if cfg.Paths.ExclusionMask != "" {
+		exclusion, err = mask.Load(cfg.Paths.ExclusionMask, parts[0].FrameWidth, parts[0].FrameHeight)
+		if err != nil {
+			return fmt.Errorf("error loading exclusion mask '%s': %w", cfg.Paths.ExclusionMask, err)
+		}
+	}
+	if cfg.Paths.BadPixelMap != "" {
+		badPixels, err := mask.Load(cfg.Paths.BadPixelMap, parts[0].FrameWidth, parts[0].FrameHeight)
+		if err != nil {
+			return fmt.Errorf("error loading bad pixel map '%s': %w", cfg.Paths.BadPixelMap, err)
+		}
+		exclusion = mask.Union(exclusion, badPixels)
+	}
+	if cfg.Paths.TissueMask != "" {
+		outside, err := mask.Load(cfg.Paths.TissueMask, parts[0].FrameWidth, parts[0].FrameHeight)
+		if err != nil {
+			return fmt.Errorf("error loading tissue mask '%s': %w", cfg.Paths.TissueMask, err)
+		}
+		exclusion = mask.Union(exclusion, outside.Invert())
+	}
+
+	runner := tlasca.NewRunner(cfg.Algorithm.Params(), logger, nil)
+	result, err := runner.MergePartials(ctx, parts, exclusion)
+	if err != nil {
+		return fmt.Errorf("error merging partial results: %w", err)
+	}
+
+	if err = os.MkdirAll(cfg.Paths.ResultsDir, 0755); err != nil {
+		return fmt.Errorf("error creating results directory '%s': %w", cfg.Paths.ResultsDir, err)
+	}
+	img := render.FillBackground(render.Gray(result, normalizer.Fit(result.Contrast, result.Excluded)), result.Excluded, cfg.Output.Background)
+	if err = imageutils.SaveImage(newPath, img); err != nil {
+		return fmt.Errorf("error saving result image to '%s': %w", newPath, err)
+	}
+	logger.Printf("image saving completed: %s\n", newPath)
+	return nil
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"runtime"
+
+	"github.com/mascotmascot1/go-tlasca/internal/selfbench"
+)
+
+// runBenchmarkCommand выполняет подкоманду benchmark: замер масштабирования расчета
+// по числу рабочих горутин (1, 2, 4, ... до -max-workers) на фиксированной синтетической нагрузке.
+func runBenchmarkCommand(logger *log.Logger, args []string) error {
+	flags := flag.NewFlagSet("benchmark", flag.ContinueOnError)
+	maxWorkers := flags.Int("max-workers", runtime.NumCPU(), "largest number of workers to measure")
+	repeats := flags.Int("repeats", 3, "measurements per worker count (the best one is reported)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *maxWorkers < 1 {
+		return fmt.Errorf("max-workers must be at least 1, got %d", *maxWorkers)
+	}
+
+	counts := selfbench.WorkerCounts(*maxWorkers)
+	logger.Printf("measuring worker scaling for %v workers (%d logical CPUs)...\n", counts, runtime.NumCPU())
+	results := selfbench.Run(counts, *repeats)
+	logger.Println("workers  time        speedup  efficiency")
+	for _, r := range results {
+		logger.Printf("%7d  %-10s  %6.2fx  %9.0f%%\n", r.Workers, r.Duration.Round(100000), r.Speedup, 100*r.Efficiency)
+	}
+	logger.Printf("recommended workers: %d (the fewest within 5%% of the best time).\n", selfbench.Recommend(results))
+	return nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/mascotmascot1/go-tlasca/internal/biospeckle"
+	"github.com/mascotmascot1/go-tlasca/internal/config"
+	"github.com/mascotmascot1/go-tlasca/internal/render"
+	"github.com/mascotmascot1/go-tlasca/internal/telemetry"
+	"github.com/mascotmascot1/go-tlasca/pkg/frame"
+)
+
+// runBiospeckle рассчитывает карты индексов активности биоспеклов IM и AVD
+// последовательности files (кадры берутся из памяти или повторно читаются, как в runContributions).
+func runBiospeckle(rec *telemetry.Recorder, loader *frameLoader, files []string, frames []frame.Frame, chunkSize int) (*biospeckle.Activity, error) {
+	defer rec.Start("biospeckle")()
+	activity, err := biospeckle.Compute(sequenceLoader(loader, files, frames), len(files), chunkSize)
+	if err != nil {
+		return nil, fmt.Errorf("error computing biospeckle activity: %w", err)
+	}
+	return activity, nil
+}
+
+// biospeckleOutputs возвращает сводку индексов активности для отчета и 16-битные
+// карты IM и AVD для сохранения (если их имена заданы): 0 - нет изменений,
+// 65535 - 99-й процентиль карты.
+func biospeckleOutputs(cfg *config.Config, a *biospeckle.Activity) (biospeckle.Summary, []pngOutput) {
+	summary := a.Summarize()
+	var outputs []pngOutput
+	for _, m := range []struct {
+		filename, what string
+		values         []float64
+		max            *float64
+	}{
+		{cfg.Biospeckle.IMFilename, "biospeckle IM map", a.IM, &summary.IMMax},
+		{cfg.Biospeckle.AVDFilename, "biospeckle AVD map", a.AVD, &summary.AVDMax},
+	} {
+		if m.filename == "" {
+			continue
+		}
+		limit := render.Percentile(m.values, nil, 99)
+		if limit <= 0 {
+			// Спекл-картина неподвижна: карта нулевая при любой шкале.
+			limit = 1
+		}
+		*m.max = limit
+		outputs = append(outputs, pngOutput{filepath.Join(cfg.Paths.ResultsDir, m.filename), m.what,
+			render.Gray16Plane(m.values, a.Width, a.Height, render.Range{Min: 0, Max: limit})})
+	}
+	return summary, outputs
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/mascotmascot1/go-tlasca/internal/calibration"
+	"github.com/mascotmascot1/go-tlasca/internal/config"
+	"github.com/mascotmascot1/go-tlasca/internal/imageutils"
+	"github.com/mascotmascot1/go-tlasca/internal/telemetry"
+)
+
+// calibrationWarnError - среднеквадратичное отклонение углов мишени от модели дисторсии
+// в пикселях, начиная с которого калибровка считается ненадежной.
+const calibrationWarnError = 1.0
+
+// runCalibration находит углы шахматной мишени на изображении paths.calibration_image,
+// оценивает дисторсию объектива и подготавливает ее исправление для кадров width x height.
+// Модель и изображение найденных углов сохраняются в директорию результатов.
+// Возвращает исправление, сводку калибровки, пути к сохраненным файлам и текст
+// предупреждения (пустую строку, если калибровка надежна).
+func runCalibration(cfg *config.Config, logger *log.Logger, rec *telemetry.Recorder, width, height int) (*calibration.Remap, *calibration.Summary, []string, string, error) {
+	defer rec.Start("calibration")()
+	path := cfg.Paths.CalibrationImage
+	img, err := imageutils.LoadImage(path)
+	if err != nil {
+		return nil, nil, nil, "", fmt.Errorf("failed to load calibration image '%s': %w", path, err)
+	}
+	target, _ := imageutils.ConvertToFrame(img)
+	if b := target.Bounds(); b.Dx() != width || b.Dy() != height {
+		return nil, nil, nil, "", fmt.Errorf("calibration image size %dx%d does not match frame size %dx%d",
+			b.Dx(), b.Dy(), width, height)
+	}
+	corners, err := calibration.Detect(target, cfg.Calibration.Columns, cfg.Calibration.Rows)
+	if err != nil {
+		return nil, nil, nil, "", fmt.Errorf("calibration target not found in '%s': %w", path, err)
+	}
+	summary, err := calibration.Fit(corners, cfg.Calibration.Columns, cfg.Calibration.Rows, width, height)
+	if err != nil {
+		return nil, nil, nil, "", fmt.Errorf("error fitting lens distortion: %w", err)
+	}
+	logger.Printf("lens distortion: k1 = %.4g, k2 = %.4g, max displacement %.2f px, corner rms error %.3f px.\n",
+		summary.K1, summary.K2, summary.MaxDisplacement, summary.RMSError)
+	var warning string
+	if summary.RMSError > calibrationWarnError {
+		warning = fmt.Sprintf("calibration corners deviate from the distortion model by %.2f px rms (> %.1f px); check the target image",
+			summary.RMSError, calibrationWarnError)
+	}
+	remap, err := calibration.NewRemap(summary.Model, cfg.Calibration.Interpolation)
+	if err != nil {
+		return nil, nil, nil, "", fmt.Errorf("invalid calibration config: %w", err)
+	}
+
+	var outputs []string
+	if cfg.Calibration.ModelFilename != "" || cfg.Calibration.CornersFilename != "" {
+		if err = os.MkdirAll(cfg.Paths.ResultsDir, 0755); err != nil {
+			return nil, nil, nil, "", fmt.Errorf("error creating results directory '%s': %w", cfg.Paths.ResultsDir, err)
+		}
+	}
+	if cfg.Calibration.ModelFilename != "" {
+		modelPath := filepath.Join(cfg.Paths.ResultsDir, cfg.Calibration.ModelFilename)
+		if err = summary.Model.Save(modelPath); err != nil {
+			return nil, nil, nil, "", fmt.Errorf("error saving calibration model to '%s': %w", modelPath, err)
+		}
+		outputs = append(outputs, modelPath)
+	}
+	if cfg.Calibration.CornersFilename != "" {
+		cornersPath := filepath.Join(cfg.Paths.ResultsDir, cfg.Calibration.CornersFilename)
+		if err = imageutils.SavePNG(cornersPath, calibration.Overlay(target, corners)); err != nil {
+			return nil, nil, nil, "", fmt.Errorf("error saving calibration corners to '%s': %w", cornersPath, err)
+		}
+		outputs = append(outputs, cornersPath)
+	}
+	return remap, &summary, outputs, warning, nil
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"slices"
+
+	"github.com/mascotmascot1/go-tlasca/internal/camera"
+	"github.com/mascotmascot1/go-tlasca/internal/config"
+	"github.com/mascotmascot1/go-tlasca/internal/imageutils"
+	"github.com/mascotmascot1/go-tlasca/internal/pathutil"
+	"github.com/mascotmascot1/go-tlasca/pkg/frame"
+)
+
+// runCameraCommand выполняет подкоманду camera с аргументами args.
+//
+//	camera <директория темновых кадров> <файл профиля>
+//
+// оценивает профиль шума камеры по темновым кадрам директории и сохраняет его в файл.
+// Кадры выбираются по шаблонам patterns и exclude из go-tlasca.json, там же задается
+// формат кадров без заголовка (input.raw).
+func runCameraCommand(logger *log.Logger, args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("usage: go-tlasca camera <dark-frames-directory> <profile.tcam>")
+	}
+	dir, path := pathutil.Native(args[0]), pathutil.Native(args[1])
+	cfg, err := config.NewConfig(configPath, logger)
+	if err != nil {
+		return fmt.Errorf("error loading config: %w", err)
+	}
+	if err = registerRawFormat(cfg.Input.Raw); err != nil {
+		return err
+	}
+
+	exclude := append(slices.Clone(cfg.Paths.Exclude), config.DatasetConfigName)
+	files, err := pathutil.ListFiles(dir, cfg.Paths.Patterns, exclude)
+	if err != nil {
+		return fmt.Errorf("error reading dark frames directory '%s': %w", dir, err)
+	}
+	logger.Printf("estimating camera profile from %d dark frames in '%s'...\n", len(files), dir)
+	// Статистики не зависят от порядка кадров, поэтому кадры не сортируются.
+	profile, err := camera.Estimate(len(files), func(i int) (frame.Frame, error) {
+		img, err := imageutils.LoadImage(files[i])
+		if err != nil {
+			return nil, fmt.Errorf("failed to load dark frame '%s': %w", files[i], err)
+		}
+		f, _ := imageutils.ConvertToFrame(img)
+		return f, nil
+	})
+	if err != nil {
+		return err
+	}
+	if err = profile.Save(path); err != nil {
+		return fmt.Errorf("error saving camera profile to '%s': %w", path, err)
+	}
+	summary := profile.Summarize(path)
+	logger.Printf("camera profile saved: %s (%dx%d, %d frames, mean offset %.2f, offset spread %.2f, read noise %.2f).\n",
+		path, profile.Width, profile.Height, summary.Frames, summary.MeanOffset, summary.OffsetSpread, summary.ReadNoise)
+	return nil
+}
+
+// loadCameraProfile загружает профиль шума камеры paths.camera_profile для кадров width x height.
+// Возвращает профиль, дисперсию темнового шума в единицах интенсивности после умножения
+// на коэффициенты gains (tlasca.Options.NoiseVariance) и сводку для отчета о запуске.
+func loadCameraProfile(cfg *config.Config, logger *log.Logger, width, height int, gains []float64) (*camera.Profile, []float64, *camera.Summary, error) {
+	path := cfg.Paths.CameraProfile
+	profile, err := camera.Load(path)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("error loading camera profile '%s': %w", path, err)
+	}
+	if profile.Width != width || profile.Height != height {
+		return nil, nil, nil, fmt.Errorf("camera profile '%s' size %dx%d does not match frame size %dx%d",
+			path, profile.Width, profile.Height, width, height)
+	}
+	// Шум камеры не зависит от экспозиции кадра, поэтому его дисперсия масштабируется
+	// средним квадратом коэффициентов кадров.
+	var scale float64
+	for _, g := range gains {
+		scale += g * g
+	}
+	scale /= float64(len(gains))
+	summary := profile.Summarize(path)
+	logger.Printf("camera profile: %d dark frames, mean offset %.2f, offset spread %.2f, read noise %.2f.\n",
+		summary.Frames, summary.MeanOffset, summary.OffsetSpread, summary.ReadNoise)
+	return profile, profile.NoiseVariance(scale), &summary, nil
+}
+
+// loadFlatField загружает изображение равномерно освещенного поля path и подготавливает
+// поправку неоднородности для кадров width x height (с вычитанием смещения dark, если он задан).
+func loadFlatField(path string, width, height int, dark *camera.Profile) (*camera.FlatField, error) {
+	img, err := imageutils.LoadImage(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load flat field '%s': %w", path, err)
+	}
+	flat, _ := imageutils.ConvertToFrame(img)
+	if b := flat.Bounds(); b.Dx() != width || b.Dy() != height {
+		return nil, fmt.Errorf("flat field '%s' size %dx%d does not match frame size %dx%d", path, b.Dx(), b.Dy(), width, height)
+	}
+	ff, err := camera.NewFlatField(flat, dark)
+	if err != nil {
+		return nil, fmt.Errorf("invalid flat field '%s': %w", path, err)
+	}
+	return ff, nil
+}
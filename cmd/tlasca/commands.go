@@ -0,0 +1,180 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/mascotmascot1/go-tlasca/internal/events"
+	"github.com/mascotmascot1/go-tlasca/internal/imageutils"
+)
+
+// command - подкоманда go-tlasca.
+type command struct {
+	// name - имя подкоманды в командной строке.
+	name string
+	// args - описание аргументов для справки.
+	args string
+	// summary - краткое описание для справки.
+	summary string
+	// failure - начало сообщения об ошибке выполнения подкоманды.
+	failure string
+	// run выполняет подкоманду с аргументами args (после имени подкоманды).
+	run func(logger *log.Logger, args []string) error
+}
+
+// defaultCommand - подкоманда, выполняемая, если имя не указано (go-tlasca [флаги]).
+const defaultCommand = "run"
+
+// commands - подкоманды go-tlasca в порядке вывода справки. Заполняется в init,
+// поскольку подкоманда help сама обращается к списку.
+var commands []command
+
+func init() {
+	commands = []command{
+		{"run", "[--overwrite] [--machine] [--cpuprofile file] [--memprofile file] [--trace file]", "calculate the contrast map for go-tlasca.json (default)", "application failed", runRunCommand},
+		{"validate", "[--overwrite]", "check the config, input frames and outputs without calculating", "validation failed", runValidateCommand},
+		{"generate", "[flags] <directory>", "write a synthetic speckle sequence with a flow region", "generation failed", runGenerateCommand},
+		{"serve", "[--addr host:port]", "accept calculation requests over HTTP", "server failed", runServeCommand},
+		{"diffstats", "[--json file] [--bland-altman file.csv] [--overwrite] <run A> <run B>", "compare the contrast maps of two runs (agreement statistics)", "comparison failed", runDiffstatsCommand},
+		{"config", "migrate [config.json ...]", "update config files to the current schema version", "config command failed", runConfigCommand},
+		{"camera", "<dark-frames-directory> <profile.tcam>", "estimate a camera noise profile from dark frames", "camera profile failed", runCameraCommand},
+		{"benchmark", "[--max-workers n] [--repeats n]", "measure calculation scaling by worker count", "benchmark failed", runBenchmarkCommand},
+		{"help", "", "show this help", "help failed", runHelpCommand},
+	}
+}
+
+// parseCommand возвращает подкоманду и ее аргументы по аргументам командной строки args
+// (без имени программы). Если первый аргумент - флаг или аргументов нет, выполняется
+// подкоманда по умолчанию: go-tlasca --overwrite равносильно go-tlasca run --overwrite.
+func parseCommand(args []string) (*command, []string, error) {
+	name := defaultCommand
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+	for i := range commands {
+		if commands[i].name == name {
+			return &commands[i], args, nil
+		}
+	}
+	return nil, nil, fmt.Errorf("unknown command '%s'; run 'go-tlasca help' for the list of commands", name)
+}
+
+// runHelpCommand выводит список подкоманд.
+func runHelpCommand(_ *log.Logger, _ []string) error {
+	printUsage(os.Stdout)
+	return nil
+}
+
+// printUsage выводит в w список подкоманд с аргументами и описанием.
+func printUsage(w io.Writer) {
+	fmt.Fprintln(w, "usage: go-tlasca [command] [arguments]")
+	fmt.Fprintln(w, "\ncommands:")
+	for _, c := range commands {
+		fmt.Fprintf(w, "  %-10s %s\n", c.name, c.summary)
+		if c.args != "" {
+			fmt.Fprintf(w, "  %-10s   go-tlasca %s %s\n", "", c.name, c.args)
+		}
+	}
+}
+
+// newFlagSet создает набор флагов подкоманды name; справка по флагам (-h) выводится
+// вместе с аргументами подкоманды args.
+func newFlagSet(name, args string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: go-tlasca %s %s\n", name, args)
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+// parseFlags разбирает флаги подкоманды и проверяет, что после них осталось ровно
+// positional аргументов (-1 - любое число).
+func parseFlags(flags *flag.FlagSet, args []string, positional int) error {
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if positional >= 0 && flags.NArg() != positional {
+		flags.Usage()
+		return fmt.Errorf("expected %d arguments, got %d", positional, flags.NArg())
+	}
+	return nil
+}
+
+// runRunCommand выполняет подкоманду run: расчет карты по go-tlasca.json (см. run).
+// С флагами профилирования на время запуска записываются профили CPU и памяти
+// и трассировка выполнения (см. profileFlags).
+func runRunCommand(logger *log.Logger, args []string) (err error) {
+	flags := newFlagSet("run", "[--overwrite] [--machine] [--cpuprofile file] [--memprofile file] [--trace file]")
+	overwrite := flags.Bool("overwrite", false, "replace existing results in the results directory")
+	machine := flags.Bool("machine", false, "read a JSON request from stdin and write JSON lines to stdout (for GUI plugins)")
+	profile := addProfileFlags(flags)
+	if err = parseFlags(flags, args, 0); err != nil {
+		return err
+	}
+	stopProfile, err := profile.start(logger)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		// Ошибка записи профиля не скрывает ошибку расчета.
+		if stopErr := stopProfile(); stopErr != nil {
+			if err != nil {
+				logger.Printf("warn: %v\n", stopErr)
+			} else {
+				err = stopErr
+			}
+		}
+	}()
+	if *machine {
+		return runMachine(logger, os.Stdin, os.Stdout, *overwrite)
+	}
+	bus := events.New()
+	onProgress(bus, newProgressBar(os.Stdout, logger).Report)
+	ctx, stop := interruptContext(logger)
+	defer stop()
+	return run(ctx, logger, bus, runOptions{overwrite: *overwrite})
+}
+
+// runValidateCommand выполняет подкоманду validate: проверки запуска run (конфигурация,
+// входные кадры, выходные файлы, ресурсы) без загрузки кадров и расчета.
+func runValidateCommand(logger *log.Logger, args []string) error {
+	flags := newFlagSet("validate", "[--overwrite]")
+	overwrite := flags.Bool("overwrite", false, "do not report existing results as conflicts")
+	if err := parseFlags(flags, args, 0); err != nil {
+		return err
+	}
+	ctx, stop := interruptContext(logger)
+	defer stop()
+	return run(ctx, logger, events.New(), runOptions{overwrite: *overwrite, validate: true})
+}
+
+// validateFrames проверяет заголовки всех кадров files (без декодирования пикселей):
+// каждый кадр должен читаться и иметь размер width x height. Выводит итог проверки
+// с числом и объемом выходных файлов outputs.
+func validateFrames(logger *log.Logger, files []string, width, height int, outputs []plannedOutput) error {
+	var problems []string
+	for _, file := range files {
+		cfg, err := imageutils.LoadImageConfig(file)
+		switch {
+		case err != nil:
+			problems = append(problems, fmt.Sprintf("failed to read image header '%s': %v", file, err))
+		case cfg.Width != width || cfg.Height != height:
+			problems = append(problems, fmt.Sprintf("frame '%s' size %dx%d differs from the first frame size %dx%d",
+				file, cfg.Width, cfg.Height, width, height))
+		}
+	}
+	for _, problem := range problems {
+		logger.Printf("warn: %s\n", problem)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%d of %d frames are invalid (first: %s)", len(problems), len(files), problems[0])
+	}
+	logger.Printf("validation passed: %d frames %dx%d, %d outputs (%.1f MiB) planned.\n",
+		len(files), width, height, len(outputs), float64(totalSize(outputs))/(1<<20))
+	return nil
+}
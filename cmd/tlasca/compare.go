@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+
+	"github.com/mascotmascot1/go-tlasca/internal/config"
+	"github.com/mascotmascot1/go-tlasca/internal/figure"
+	"github.com/mascotmascot1/go-tlasca/internal/imageutils"
+	"github.com/mascotmascot1/go-tlasca/internal/render"
+	"github.com/mascotmascot1/go-tlasca/pkg/frame"
+	"github.com/mascotmascot1/go-tlasca/pkg/tlasca"
+)
+
+// epoch описывает диапазон кадров [start, end) последовательности для сравнения.
+type epoch struct {
+	label      string
+	start, end int
+}
+
+// parseEpoch проверяет пару [первый, последний] (с единицы, включительно)
+// и преобразует ее в полуинтервал индексов [start, end).
+func parseEpoch(label string, bounds []int, frames int) (epoch, error) {
+	if len(bounds) != 2 {
+		return epoch{}, fmt.Errorf("epoch '%s' must be [first, last], got %v", label, bounds)
+	}
+	first, last := bounds[0], bounds[1]
+	if first < 1 || last > frames || last-first+1 < 2 {
+		return epoch{}, fmt.Errorf("epoch '%s' [%d, %d] must contain at least 2 frames within 1..%d", label, first, last, frames)
+	}
+	return epoch{label: label, start: first - 1, end: last}, nil
+}
+
+// comparison - карты контраста двух эпох последовательности.
+type comparison struct {
+	epochs  [2]epoch
+	results []*tlasca.Result
+}
+
+// runComparison рассчитывает карты контраста для двух эпох. Если кадры всей последовательности
+// уже загружены (frames не nil), эпохи берутся из памяти; иначе кадры эпох загружаются
+// повторно порциями через копию загрузчика.
+func runComparison(ctx context.Context, cfg *config.Config, runner *tlasca.Runner, loader *frameLoader, files []string,
+	frames []frame.Frame, opts tlasca.Options, chunkSize int) (*comparison, error) {
+	a, err := parseEpoch(cfg.Compare.LabelA, cfg.Compare.EpochA, len(files))
+	if err != nil {
+		return nil, err
+	}
+	b, err := parseEpoch(cfg.Compare.LabelB, cfg.Compare.EpochB, len(files))
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]*tlasca.Result, 0, 2)
+	for _, e := range []epoch{a, b} {
+		epochOpts := opts
+		if opts.Gains != nil {
+			epochOpts.Gains = opts.Gains[e.start:e.end]
+		}
+
+		var res *tlasca.Result
+		if frames != nil {
+			res, err = runner.Run(ctx, frames[e.start:e.end], epochOpts)
+		} else {
+			epochLoader := loader.fork()
+			epochFiles := files[e.start:e.end]
+			res, err = runner.RunChunked(ctx, len(epochFiles), chunkSize, func(start, end int) ([]frame.Frame, error) {
+				return epochLoader.load(e.start+start, epochFiles[start:end])
+			}, epochOpts)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error processing epoch '%s': %w", e.label, err)
+		}
+		results = append(results, res)
+	}
+	return &comparison{epochs: [2]epoch{a, b}, results: results}, nil
+}
+
+// saveComparison сохраняет иллюстрацию сравнения эпох c с нормировкой norm
+// и возвращает путь к ней.
+func saveComparison(cfg *config.Config, c *comparison, norm render.Normalizer) (string, error) {
+	a, b := c.epochs[0], c.epochs[1]
+	caption := []string{
+		fmt.Sprintf("%s: frames %d-%d, %s: frames %d-%d", a.label, a.start+1, a.end, b.label, b.start+1, b.end),
+		fmt.Sprintf("window: %dx%d", cfg.Algorithm.WindowSize, cfg.Algorithm.WindowSize),
+	}
+	path := filepath.Join(cfg.Paths.ResultsDir, cfg.Compare.FigureFilename)
+	fig := figure.Comparison(c.results[0], c.results[1], norm, a.label, b.label, caption)
+	if err := imageutils.SavePNG(path, fig); err != nil {
+		return "", fmt.Errorf("error saving comparison figure to '%s': %w", path, err)
+	}
+	return path, nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/mascotmascot1/go-tlasca/internal/atomicfile"
+	"github.com/mascotmascot1/go-tlasca/internal/config"
+	"github.com/mascotmascot1/go-tlasca/internal/pathutil"
+)
+
+// runConfigCommand выполняет подкоманду config с аргументами args.
+//
+//	config migrate [файл ...]
+//
+// обновляет файлы конфигурации (по умолчанию go-tlasca.json) до текущей версии схемы.
+func runConfigCommand(logger *log.Logger, args []string) error {
+	if len(args) == 0 || args[0] != "migrate" {
+		return fmt.Errorf("usage: go-tlasca config migrate [config.json ...]")
+	}
+	paths := args[1:]
+	if len(paths) == 0 {
+		paths = []string{configPath}
+	}
+	for _, path := range paths {
+		if err := migrateConfig(logger, pathutil.Native(path)); err != nil {
+			return fmt.Errorf("error migrating config '%s': %w", path, err)
+		}
+	}
+	return nil
+}
+
+// migrateConfig переписывает файл конфигурации path в текущей версии схемы.
+// Исходный файл сохраняется рядом с суффиксом .bak; файл текущей версии не изменяется.
+func migrateConfig(logger *log.Logger, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	m, err := config.Migrate(pathutil.TrimBOM(data))
+	if err != nil {
+		return err
+	}
+	if m.From == config.CurrentVersion {
+		logger.Printf("config '%s' is already at schema version %d.\n", path, config.CurrentVersion)
+		return nil
+	}
+
+	backup := path + ".bak"
+	if err = atomicfile.WriteFile(backup, data); err != nil {
+		return fmt.Errorf("error saving backup '%s': %w", backup, err)
+	}
+	if err = atomicfile.WriteFile(path, m.Data); err != nil {
+		return err
+	}
+	for _, note := range m.Notes {
+		logger.Printf("config '%s': %s (replaced).\n", path, note)
+	}
+	logger.Printf("config '%s' migrated from schema version %d to %d (backup: %s).\n", path, m.From, config.CurrentVersion, backup)
+	return nil
+}
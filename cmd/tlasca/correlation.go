@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/mascotmascot1/go-tlasca/internal/config"
+	"github.com/mascotmascot1/go-tlasca/internal/crosscorr"
+	"github.com/mascotmascot1/go-tlasca/internal/roi"
+	"github.com/mascotmascot1/go-tlasca/internal/telemetry"
+	"github.com/mascotmascot1/go-tlasca/internal/timestamps"
+	"github.com/mascotmascot1/go-tlasca/pkg/tlasca"
+)
+
+// runCorrelation строит временные ряды областей интереса, вычисляет их взаимную
+// корреляцию с задержками и сохраняет корреляционные функции в CSV.
+// times - времена регистрации кадров в секундах (nil, если временные метки не заданы):
+// ряды приводятся к равномерной сетке с медианным межкадровым интервалом, так что
+// задержки отсчитываются в шагах этой сетки и пересчитываются в секунды.
+// Возвращает пики корреляции пар областей и путь к сохраненному файлу.
+func runCorrelation(cfg *config.Config, rec *telemetry.Recorder, regions []roi.Region, load tlasca.ChunkLoader,
+	total int, opts tlasca.Options, chunkSize int, times []float64) ([]crosscorr.Pair, string, error) {
+	defer rec.Start("correlation")()
+	if len(regions) < 2 {
+		return nil, "", fmt.Errorf("cross-correlation needs at least 2 regions, got %d", len(regions))
+	}
+	if cfg.Correlation.MaxLag < 0 {
+		return nil, "", fmt.Errorf("correlation max_lag must be non-negative, got %d", cfg.Correlation.MaxLag)
+	}
+
+	series, err := roi.Series(load, total, chunkSize, regions, roi.Signal(cfg.Correlation.Signal), opts.Gains)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var interval float64
+	if times != nil {
+		interval = timestamps.Summarize(times).MedianInterval
+		for i := range series {
+			series[i] = timestamps.Resample(times, series[i], interval)
+		}
+	}
+
+	pairs := crosscorr.Analyze(regionNames(regions), series, cfg.Correlation.MaxLag, interval)
+	path := filepath.Join(cfg.Paths.ResultsDir, cfg.Correlation.Filename)
+	if err = crosscorr.WriteCSV(path, pairs, cfg.Correlation.MaxLag, interval); err != nil {
+		return nil, "", fmt.Errorf("error saving cross-correlation to '%s': %w", path, err)
+	}
+	return pairs, path, nil
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/mascotmascot1/go-tlasca/internal/config"
+	"github.com/mascotmascot1/go-tlasca/internal/expr"
+	"github.com/mascotmascot1/go-tlasca/internal/parallel"
+	"github.com/mascotmascot1/go-tlasca/internal/render"
+	"github.com/mascotmascot1/go-tlasca/internal/report"
+	"github.com/mascotmascot1/go-tlasca/pkg/tlasca"
+	"github.com/mascotmascot1/go-tlasca/pkg/units"
+)
+
+// Карты запуска, доступные выражениям производных карт (в геометрии карты).
+const (
+	// derivedContrast - итоговая карта контраста.
+	derivedContrast = "k"
+	// derivedMean и derivedStdDev - средние по окну временное среднее и стандартное
+	// отклонение интенсивности (в долях полной шкалы).
+	derivedMean   = "mean"
+	derivedStdDev = "std"
+	// derivedEpochA и derivedEpochB - карты контраста эпох сравнения compare.
+	derivedEpochA = "k_a"
+	derivedEpochB = "k_b"
+	// derivedStatic и derivedFlow - карты static_scattering.
+	derivedStatic = "static"
+	derivedFlow   = "flow_index"
+)
+
+// mapUnits возвращает единицы карт запуска, доступных выражениям производных карт maps,
+// и самих производных карт.
+func mapUnits(cfg *config.Config, result *tlasca.Result, maps []derivedMap) map[string]units.Unit {
+	known := map[string]units.Unit{
+		derivedContrast: result.Units.Contrast,
+		derivedMean:     result.Units.Intensity,
+		derivedStdDev:   result.Units.Intensity,
+		// Оценки static_scattering - доля интенсивности и отношение T/τc.
+		derivedStatic: units.Dimensionless,
+		derivedFlow:   units.Dimensionless,
+		// Карты эпох - тоже карты контраста.
+		derivedEpochA: result.Units.Contrast,
+		derivedEpochB: result.Units.Contrast,
+	}
+	out := map[string]units.Unit{}
+	for _, name := range availableMaps(cfg) {
+		out[name] = known[name]
+	}
+	if result.StdDev == nil {
+		delete(out, derivedStdDev)
+	}
+	for _, dm := range maps {
+		out[dm.Name] = derivedUnit(dm.DerivedMapConfig)
+	}
+	return out
+}
+
+// derivedUnit возвращает единицу значений производной карты dm.
+func derivedUnit(dm config.DerivedMapConfig) units.Unit {
+	if dm.Unit == "" {
+		return units.Arbitrary
+	}
+	return units.Unit(dm.Unit)
+}
+
+// derivedMap - разобранная производная карта конфигурации.
+type derivedMap struct {
+	config.DerivedMapConfig
+	expression *expr.Expression
+}
+
+// availableMaps возвращает имена карт запуска, которые конфигурация cfg делает доступными
+// выражениям производных карт.
+func availableMaps(cfg *config.Config) []string {
+	names := []string{derivedContrast, derivedMean, derivedStdDev}
+	if len(cfg.Compare.EpochA) > 0 && len(cfg.Compare.EpochB) > 0 {
+		names = append(names, derivedEpochA, derivedEpochB)
+	}
+	if cfg.StaticScattering.Enabled {
+		names = append(names, derivedStatic, derivedFlow)
+	}
+	return names
+}
+
+// parseDerivedMaps проверяет производные карты конфигурации до расчета: имена, выражения
+// и ссылки на карты (выражение может ссылаться на карты запуска и предыдущие
+// производные карты), диапазоны отображения и имена файлов.
+func parseDerivedMaps(cfg *config.Config) ([]derivedMap, error) {
+	known := availableMaps(cfg)
+	reserved := []string{derivedContrast, derivedMean, derivedStdDev, derivedEpochA, derivedEpochB, derivedStatic, derivedFlow}
+	maps := make([]derivedMap, 0, len(cfg.DerivedMaps))
+	for i, dm := range cfg.DerivedMaps {
+		switch {
+		case !expr.IsIdentifier(dm.Name):
+			return nil, fmt.Errorf("invalid derived map %d name '%s': use letters, digits and '_', not starting with a digit", i+1, dm.Name)
+		case expr.IsFunction(dm.Name) || slices.Contains(reserved, dm.Name):
+			return nil, fmt.Errorf("invalid derived map name '%s': the name is reserved", dm.Name)
+		case slices.Contains(known, dm.Name):
+			return nil, fmt.Errorf("invalid derived map name '%s': the name is used by another derived map", dm.Name)
+		case len(dm.Range) != 0 && (len(dm.Range) != 2 || !(dm.Range[0] < dm.Range[1])):
+			return nil, fmt.Errorf("invalid derived map '%s' range %v, expected [min, max] with min < max", dm.Name, dm.Range)
+		}
+		e, err := expr.Parse(dm.Expression)
+		if err != nil {
+			return nil, fmt.Errorf("invalid derived map '%s' expression: %w", dm.Name, err)
+		}
+		for _, name := range e.Variables() {
+			if !slices.Contains(known, name) {
+				return nil, fmt.Errorf("invalid derived map '%s' expression: unknown map '%s' (available: %s)",
+					dm.Name, name, strings.Join(known, ", "))
+			}
+		}
+		dm.Filename = derivedFilename(dm)
+		maps = append(maps, derivedMap{dm, e})
+		known = append(known, dm.Name)
+	}
+	return maps, nil
+}
+
+// derivedFilename возвращает имя файла производной карты dm.
+func derivedFilename(dm config.DerivedMapConfig) string {
+	if dm.Filename == "" {
+		return dm.Name + ".png"
+	}
+	return dm.Filename
+}
+
+// derivedInputs - исходные карты производных карт, вычисляемых после расчета.
+type derivedInputs struct {
+	// epochs - карты контраста эпох сравнения (nil, если сравнение не выполнялось).
+	epochs []*tlasca.Result
+	// static, flow - карты static_scattering (nil, если оценка выключена).
+	static, flow []float64
+}
+
+// evaluateDerivedMaps вычисляет производные карты maps по карте result и картам inputs.
+// Исключенные положения карты и нечисловые значения (например, при делении на ноль)
+// сохраняются как 0. Возвращает изображения для сохранения, сводки для отчета
+// и предупреждения о картах с нечисловыми значениями.
+func evaluateDerivedMaps(cfg *config.Config, maps []derivedMap, result *tlasca.Result, inputs derivedInputs) ([]pngOutput, []report.DerivedMap, []string, error) {
+	n := result.Width * result.Height
+	values := map[string][]float64{derivedContrast: result.Contrast}
+	// Средние по окну вычисляются, только если на них ссылаются выражения.
+	used := map[string]bool{}
+	for _, dm := range maps {
+		for _, name := range dm.expression.Variables() {
+			used[name] = true
+		}
+	}
+	if used[derivedMean] {
+		values[derivedMean] = windowAverage(result.Mean, result, cfg.Algorithm.WindowSize)
+	}
+	if used[derivedStdDev] {
+		if result.StdDev == nil {
+			return nil, nil, nil, fmt.Errorf("derived map '%s' is not available for spatial contrast", derivedStdDev)
+		}
+		values[derivedStdDev] = windowAverage(result.StdDev, result, cfg.Algorithm.WindowSize)
+	}
+	if len(inputs.epochs) == 2 {
+		values[derivedEpochA], values[derivedEpochB] = inputs.epochs[0].Contrast, inputs.epochs[1].Contrast
+	}
+	if inputs.static != nil {
+		values[derivedStatic], values[derivedFlow] = inputs.static, inputs.flow
+	}
+
+	var outputs []pngOutput
+	var summaries []report.DerivedMap
+	var warnings []string
+	for _, dm := range maps {
+		v, err := dm.expression.Eval(values, n)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("error evaluating derived map '%s': %w", dm.Name, err)
+		}
+		summary := report.DerivedMap{Name: dm.Name, Expression: dm.Expression, Unit: derivedUnit(dm.DerivedMapConfig),
+			File: filepath.Join(cfg.Paths.ResultsDir, dm.Filename)}
+		var sum float64
+		valid := 0
+		for i := range v {
+			if result.IsExcluded(i%result.Width, i/result.Width) {
+				v[i] = math.NaN()
+				continue
+			}
+			if math.IsNaN(v[i]) || math.IsInf(v[i], 0) {
+				v[i] = math.NaN()
+				summary.Invalid++
+				continue
+			}
+			sum += v[i]
+			valid++
+		}
+		if valid > 0 {
+			summary.Mean = sum / float64(valid)
+		}
+		if summary.Invalid > 0 {
+			warnings = append(warnings, fmt.Sprintf("derived map '%s' has %d non-numeric values (e.g. division by zero), saved as 0",
+				dm.Name, summary.Invalid))
+		}
+
+		var rng render.Range
+		if len(dm.Range) == 2 {
+			rng = render.Range{Min: dm.Range[0], Max: dm.Range[1]}
+		} else {
+			rng = render.Range{Min: render.Percentile(v, result.Excluded, 1), Max: render.Percentile(v, result.Excluded, 99)}
+			if !(rng.Max > rng.Min) {
+				// Карта почти постоянна (например, условие if): шкала от наименьшего
+				// до наибольшего значения или [значение, значение + 1].
+				rng = render.Range{Min: render.Percentile(v, result.Excluded, 0), Max: render.Percentile(v, result.Excluded, 100)}
+				if !(rng.Max > rng.Min) {
+					rng.Max = rng.Min + 1
+				}
+			}
+		}
+		summary.Range = [2]float64{rng.Min, rng.Max}
+		summaries = append(summaries, summary)
+		outputs = append(outputs, pngOutput{summary.File, fmt.Sprintf("derived map '%s'", dm.Name),
+			render.Gray16Plane(v, result.Width, result.Height, rng)})
+		// Следующие выражения получают NaN в исключенных положениях и положениях без значения.
+		values[dm.Name] = v
+	}
+	return outputs, summaries, warnings, nil
+}
+
+// windowAverage возвращает среднее плоскости plane (в геометрии кадра result) по окну
+// windowSize для каждого положения окна карты result.
+func windowAverage(plane []float64, result *tlasca.Result, windowSize int) []float64 {
+	out := make([]float64, result.Width*result.Height)
+	n := float64(windowSize * windowSize)
+	parallel.Rows(result.Height, func(startY, endY int) {
+		for y := startY; y < endY; y++ {
+			for x := range result.Width {
+				var sum float64
+				for wy := y; wy < y+windowSize; wy++ {
+					for _, v := range plane[wy*result.FrameWidth+x : wy*result.FrameWidth+x+windowSize] {
+						sum += v
+					}
+				}
+				out[y*result.Width+x] = sum / n
+			}
+		}
+	})
+	return out
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"log"
+	"path/filepath"
+	"strings"
+
+	"github.com/mascotmascot1/go-tlasca/internal/config"
+	"github.com/mascotmascot1/go-tlasca/internal/crosscorr"
+	"github.com/mascotmascot1/go-tlasca/internal/diagnostics"
+	"github.com/mascotmascot1/go-tlasca/internal/events"
+	"github.com/mascotmascot1/go-tlasca/internal/framecorr"
+	"github.com/mascotmascot1/go-tlasca/internal/imageutils"
+	"github.com/mascotmascot1/go-tlasca/internal/roi"
+	"github.com/mascotmascot1/go-tlasca/internal/telemetry"
+	"github.com/mascotmascot1/go-tlasca/internal/vasomotion"
+	"github.com/mascotmascot1/go-tlasca/pkg/frame"
+	"github.com/mascotmascot1/go-tlasca/pkg/tlasca"
+)
+
+// runContributions рассчитывает вклад каждого кадра в контраст опорной области,
+// сохраняет его в CSV и возвращает путь к файлу и текст предупреждения об отмеченных
+// кадрах (пустая строка, если таких нет). Если кадры уже загружены (frames не nil),
+// они берутся из памяти; иначе последовательность повторно читается копией загрузчика.
+func runContributions(ctx context.Context, cfg *config.Config, runner *tlasca.Runner, loader *frameLoader, files []string,
+	frames []frame.Frame, frameRect image.Rectangle, opts tlasca.Options, chunkSize int) (string, string, error) {
+	region, err := roi.Parse(cfg.Diagnostics.ReferenceROI, frameRect)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid reference_roi: %w", err)
+	}
+
+	load := sequenceLoader(loader, files, frames)
+	deltas, err := runner.FrameContributions(ctx, region, len(files), chunkSize, load, opts)
+	if err != nil {
+		return "", "", fmt.Errorf("error computing frame contributions: %w", err)
+	}
+
+	contributions := diagnostics.Flag(deltas, cfg.Diagnostics.FlagThreshold)
+	path := filepath.Join(cfg.Paths.ResultsDir, cfg.Diagnostics.ContributionsFilename)
+	if err = diagnostics.WriteCSV(path, contributions, files); err != nil {
+		return "", "", fmt.Errorf("error saving frame contributions to '%s': %w", path, err)
+	}
+
+	flagged := diagnostics.Flagged(contributions)
+	if len(flagged) == 0 {
+		return path, "", nil
+	}
+	names := make([]string, 0, min(len(flagged), 5))
+	for _, c := range flagged[:cap(names)] {
+		names = append(names, fmt.Sprintf("%s (z=%.1f)", filepath.Base(files[c.Frame]), c.Score))
+	}
+	warning := fmt.Sprintf("%d frames dominate the contrast of region %v (|z| > %g): %s",
+		len(flagged), region, cfg.Diagnostics.FlagThreshold, strings.Join(names, ", "))
+	return path, warning, nil
+}
+
+// runConvergence рассчитывает контраст опорной области в зависимости от числа кадров,
+// сохраняет кривую (CSV) и ее график (PNG) и возвращает сводку сходимости и пути
+// к сохраненным файлам. Кадры берутся из памяти или повторно читаются, как в runContributions.
+func runConvergence(ctx context.Context, cfg *config.Config, runner *tlasca.Runner, loader *frameLoader, files []string,
+	frames []frame.Frame, frameRect image.Rectangle, opts tlasca.Options, chunkSize int) (diagnostics.ConvergenceSummary, []string, error) {
+	region, err := roi.Parse(cfg.Diagnostics.ReferenceROI, frameRect)
+	if err != nil {
+		return diagnostics.ConvergenceSummary{}, nil, fmt.Errorf("invalid reference_roi: %w", err)
+	}
+	curve, err := runner.Convergence(ctx, region, len(files), chunkSize, sequenceLoader(loader, files, frames), opts)
+	if err != nil {
+		return diagnostics.ConvergenceSummary{}, nil, fmt.Errorf("error computing contrast convergence: %w", err)
+	}
+
+	var outputs []string
+	if cfg.Diagnostics.ConvergenceFilename != "" {
+		path := filepath.Join(cfg.Paths.ResultsDir, cfg.Diagnostics.ConvergenceFilename)
+		if err = diagnostics.WriteConvergenceCSV(path, curve); err != nil {
+			return diagnostics.ConvergenceSummary{}, nil, fmt.Errorf("error saving contrast convergence to '%s': %w", path, err)
+		}
+		outputs = append(outputs, path)
+	}
+	if cfg.Diagnostics.ConvergencePlotFilename != "" {
+		path := filepath.Join(cfg.Paths.ResultsDir, cfg.Diagnostics.ConvergencePlotFilename)
+		if err = imageutils.SavePNG(path, diagnostics.ConvergencePlot(curve, cfg.Diagnostics.ConvergenceTolerance)); err != nil {
+			return diagnostics.ConvergenceSummary{}, nil, fmt.Errorf("error saving convergence plot to '%s': %w", path, err)
+		}
+		outputs = append(outputs, path)
+	}
+	return diagnostics.SummarizeConvergence(curve, cfg.Diagnostics.ConvergenceTolerance), outputs, nil
+}
+
+// runFrameCorrelation вычисляет корреляции кадров между собой, сохраняет ряд корреляций
+// соседних кадров (CSV) и матрицу попарных корреляций (PNG, если число кадров не превышает
+// frame_correlation_max_frames) и возвращает сводку и пути к сохраненным файлам.
+func runFrameCorrelation(cfg *config.Config, rec *telemetry.Recorder, loader *frameLoader, files []string,
+	frames []frame.Frame, opts tlasca.Options, chunkSize int) (framecorr.Summary, []string, error) {
+	defer rec.Start("framecorr")()
+	signatures, err := framecorr.Signatures(sequenceLoader(loader, files, frames), len(files), chunkSize,
+		opts.Gains, cfg.Diagnostics.FrameCorrelationSize)
+	if err != nil {
+		return framecorr.Summary{}, nil, fmt.Errorf("error computing frame correlation: %w", err)
+	}
+	adjacent := framecorr.Adjacent(signatures)
+
+	var outputs []string
+	if cfg.Diagnostics.FrameCorrelationFilename != "" {
+		path := filepath.Join(cfg.Paths.ResultsDir, cfg.Diagnostics.FrameCorrelationFilename)
+		if err = framecorr.WriteCSV(path, adjacent, files); err != nil {
+			return framecorr.Summary{}, nil, fmt.Errorf("error saving frame correlation to '%s': %w", path, err)
+		}
+		outputs = append(outputs, path)
+	}
+	matrix := len(signatures) <= cfg.Diagnostics.FrameCorrelationMaxFrames
+	if matrix && cfg.Diagnostics.FrameCorrelationMatrixFilename != "" {
+		path := filepath.Join(cfg.Paths.ResultsDir, cfg.Diagnostics.FrameCorrelationMatrixFilename)
+		heatmap := framecorr.Heatmap(framecorr.Matrix(signatures), len(signatures))
+		if err = imageutils.SavePNG(path, heatmap); err != nil {
+			return framecorr.Summary{}, nil, fmt.Errorf("error saving frame correlation matrix to '%s': %w", path, err)
+		}
+		outputs = append(outputs, path)
+	}
+	return framecorr.Summarize(adjacent, matrix), outputs, nil
+}
+
+// runFocus оценивает резкость среднего кадра результата result по тайлам, сохраняет
+// резкость тайлов (CSV) и возвращает сводку и пути к сохраненным файлам.
+func runFocus(cfg *config.Config, result *tlasca.Result) (diagnostics.FocusSummary, []string, error) {
+	if cfg.Diagnostics.FocusTileSize < 3 {
+		return diagnostics.FocusSummary{}, nil, fmt.Errorf("focus_tile_size must be at least 3, got %d", cfg.Diagnostics.FocusTileSize)
+	}
+	tiles := diagnostics.Focus(result.Mean, result.FrameWidth, result.FrameHeight,
+		cfg.Diagnostics.FocusTileSize, cfg.Diagnostics.FocusThreshold)
+	var outputs []string
+	if cfg.Diagnostics.FocusFilename != "" {
+		path := filepath.Join(cfg.Paths.ResultsDir, cfg.Diagnostics.FocusFilename)
+		if err := diagnostics.WriteFocusCSV(path, tiles); err != nil {
+			return diagnostics.FocusSummary{}, nil, fmt.Errorf("error saving focus check to '%s': %w", path, err)
+		}
+		outputs = append(outputs, path)
+	}
+	return diagnostics.SummarizeFocus(tiles, cfg.Diagnostics.FocusTileSize, cfg.Diagnostics.FocusThreshold), outputs, nil
+}
+
+// diagnosticsRun - сводки диагностики качества записи и анализа областей (см. runDiagnostics).
+type diagnosticsRun struct {
+	// outputs - файлы, сохраненные диагностикой.
+	outputs          []string
+	convergence      *diagnostics.ConvergenceSummary
+	focus            *diagnostics.FocusSummary
+	frameCorrelation *framecorr.Summary
+	correlations     []crosscorr.Pair
+	vasomotion       []vasomotion.Peak
+}
+
+// runDiagnostics выполняет включенные в конфигурации диагностики по рассчитанной карте comp
+// и кадрам in: вклад кадров, сходимость контраста, проверку фокуса, корреляцию кадров,
+// взаимную корреляцию и спектры вазомоций областей и отчет о совмещении кадров.
+// Предупреждения о качестве записи сообщаются в шину событий bus.
+func runDiagnostics(ctx context.Context, cfg *config.Config, logger *log.Logger, rec *telemetry.Recorder, bus *events.Bus,
+	runner *tlasca.Runner, in *runInputs, comp *runComputation) (*diagnosticsRun, error) {
+	loader, files, grayImages := in.loader, in.files, comp.grayImages
+	diag := &diagnosticsRun{}
+	if cfg.Diagnostics.FrameContributions {
+		logger.Println("computing frame contributions...")
+		contributionsPath, warning, err := runContributions(ctx, cfg, runner, loader, files, grayImages, in.area, in.opts, in.plan.ChunkSize)
+		if err != nil {
+			return nil, err
+		}
+		diag.outputs = append(diag.outputs, contributionsPath)
+		if warning != "" {
+			bus.Warn(warning)
+		}
+	}
+	if cfg.Diagnostics.Convergence {
+		logger.Println("computing contrast convergence...")
+		summary, convergenceOutputs, err := runConvergence(ctx, cfg, runner, loader, files, grayImages, in.area, in.opts, in.plan.ChunkSize)
+		if err != nil {
+			return nil, err
+		}
+		diag.convergence = &summary
+		diag.outputs = append(diag.outputs, convergenceOutputs...)
+		logger.Printf("reference contrast %.4g stays within %g%% of the final value from %d of %d frames.\n",
+			summary.Final, 100*summary.Tolerance, summary.StableAfter, summary.Frames)
+		if !summary.Converged() {
+			warning := fmt.Sprintf("reference contrast has not converged: it stays within %g%% of the final value only from %d of %d frames; consider a longer recording",
+				100*summary.Tolerance, summary.StableAfter, summary.Frames)
+			bus.Warn(warning)
+		}
+	}
+	if cfg.Diagnostics.Focus {
+		summary, focusOutputs, err := runFocus(cfg, comp.result)
+		if err != nil {
+			return nil, err
+		}
+		diag.focus = &summary
+		diag.outputs = append(diag.outputs, focusOutputs...)
+		if len(summary.Defocused) > 0 {
+			warning := fmt.Sprintf("%d of %d mean frame tiles look defocused (sharpness below %g of the median), first at %v; defocus lowers contrast and mimics high flow",
+				len(summary.Defocused), summary.Tiles, summary.Threshold, summary.Defocused[0])
+			bus.Warn(warning)
+		} else {
+			logger.Printf("focus check: all %d mean frame tiles are sharp.\n", summary.Tiles)
+		}
+	}
+	if cfg.Diagnostics.FrameCorrelation {
+		logger.Println("computing frame-to-frame correlation...")
+		summary, correlationOutputs, err := runFrameCorrelation(cfg, rec, loader, files, grayImages, in.opts, in.plan.ChunkSize)
+		if err != nil {
+			return nil, err
+		}
+		diag.frameCorrelation = &summary
+		diag.outputs = append(diag.outputs, correlationOutputs...)
+		logger.Printf("adjacent frame correlation: mean %.4f, min %.4f (frames %d-%d).\n",
+			summary.MeanAdjacent, summary.MinAdjacent, summary.MinAdjacentFrame, summary.MinAdjacentFrame+1)
+		if !summary.Matrix {
+			logger.Printf("warn: frame correlation matrix skipped: %d frames exceed frame_correlation_max_frames (%d)\n",
+				summary.Frames, cfg.Diagnostics.FrameCorrelationMaxFrames)
+		}
+	}
+	if cfg.Correlation.Enabled || cfg.Vasomotion.Enabled {
+		regions, err := buildRegions(cfg, in.area)
+		if err != nil {
+			return nil, err
+		}
+		load := sequenceLoader(loader, files, grayImages)
+
+		if cfg.Correlation.Enabled {
+			logger.Println("computing cross-correlation between regions...")
+			var correlationPath string
+			diag.correlations, correlationPath, err = runCorrelation(cfg, rec, regions, load, len(files), in.opts, in.plan.ChunkSize, in.frameTimes)
+			if err != nil {
+				return nil, err
+			}
+			for _, pair := range diag.correlations {
+				logger.Printf("cross-correlation %s\n", pair)
+			}
+			diag.outputs = append(diag.outputs, correlationPath)
+		}
+		if cfg.Vasomotion.Enabled {
+			logger.Println("analyzing vasomotion spectra...")
+			var spectraPath string
+			var spectrumWarnings []string
+			diag.vasomotion, spectraPath, spectrumWarnings, err = runVasomotion(cfg, rec, regions, load, len(files), in.opts, in.plan.ChunkSize, in.frameTimes)
+			if err != nil {
+				return nil, err
+			}
+			for _, peak := range diag.vasomotion {
+				logger.Printf("vasomotion '%s': peak %.4g Hz, amplitude %.4g, %.1f%% of power in band\n",
+					peak.Region, peak.Frequency, peak.Amplitude, 100*peak.BandFraction)
+			}
+			for _, warning := range spectrumWarnings {
+				bus.Warn(warning)
+			}
+			diag.outputs = append(diag.outputs, spectraPath)
+		}
+	}
+	if in.aligner != nil {
+		alignOutputs, warning, err := saveAlignmentReport(cfg, in.aligner.Shifts(), files, in.frameTimes)
+		if err != nil {
+			return nil, err
+		}
+		diag.outputs = append(diag.outputs, alignOutputs...)
+		if warning != "" {
+			bus.Warn(warning)
+		}
+	}
+	return diag, nil
+}
@@ -0,0 +1,341 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"image"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/mascotmascot1/go-tlasca/internal/agreement"
+	"github.com/mascotmascot1/go-tlasca/internal/atomicfile"
+	"github.com/mascotmascot1/go-tlasca/internal/config"
+	"github.com/mascotmascot1/go-tlasca/internal/imageutils"
+	"github.com/mascotmascot1/go-tlasca/internal/roi"
+	"github.com/mascotmascot1/go-tlasca/pkg/frame"
+)
+
+// runDiffstatsCommand выполняет подкоманду diffstats: сравнивает итоговые карты контраста
+// двух запусков A и B (директории результатов или файлы отчета о запуске) по одним
+// положениям окна - по всей карте и по областям regions запуска A - и выводит смещение
+// B - A с пределами согласия Бланда-Альтмана, размеры эффекта и корреляцию.
+func runDiffstatsCommand(logger *log.Logger, args []string) error {
+	flags := newFlagSet("diffstats", "[--json file] [--bland-altman file.csv] [--overwrite] <run A> <run B>")
+	jsonPath := flags.String("json", "", "save the comparison as JSON")
+	pointsPath := flags.String("bland-altman", "", "save Bland-Altman points (mean and difference per map position) as CSV")
+	overwrite := flags.Bool("overwrite", false, "replace existing output files")
+	if err := parseFlags(flags, args, 2); err != nil {
+		return err
+	}
+	if !*overwrite {
+		for _, path := range []string{*jsonPath, *pointsPath} {
+			if _, err := os.Stat(path); path != "" && err == nil {
+				return fmt.Errorf("'%s' already exists; use --overwrite to replace it", path)
+			}
+		}
+	}
+
+	a, err := loadRunMap(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	b, err := loadRunMap(flags.Arg(1))
+	if err != nil {
+		return err
+	}
+	if err = a.checkAligned(b); err != nil {
+		return err
+	}
+	differences, err := parameterDifferences(a.cfg, b.cfg)
+	if err != nil {
+		return err
+	}
+	logger.Printf("comparing %dx%d contrast maps: A '%s', B '%s'.\n", a.width, a.height, a.path, b.path)
+	for _, d := range differences {
+		logger.Printf("parameter differs: %s\n", d)
+	}
+	for _, m := range []*runMap{a, b} {
+		if m.maxLevel == 255 {
+			logger.Printf("warn: map '%s' is 8-bit: differences below %.3g are not resolved; set output.result_bit_depth to 16\n",
+				m.file, m.step())
+		}
+	}
+
+	regions, err := a.regions()
+	if err != nil {
+		return err
+	}
+	comparison := diffReport{A: a.path, B: b.path, Differences: differences}
+	var points []diffPoint
+	for _, region := range regions {
+		var va, vb []float64
+		for y := region.Rect.Min.Y; y < region.Rect.Max.Y; y++ {
+			for x := region.Rect.Min.X; x < region.Rect.Max.X; x++ {
+				i := y*a.width + x
+				if !region.Contains(x, y) || !a.valid[i] || !b.valid[i] {
+					continue
+				}
+				va, vb = append(va, a.values[i]), append(vb, b.values[i])
+				if *pointsPath != "" {
+					points = append(points, diffPoint{region.Name, x, y, a.values[i], b.values[i]})
+				}
+			}
+		}
+		if region.Name == diffWholeMap {
+			comparison.Skipped = a.width*a.height - len(va)
+		}
+		s := agreement.Compare(va, vb)
+		comparison.Regions = append(comparison.Regions, diffRegion{Name: region.Name, Positions: region.Rect, Stats: s})
+		if s.N < 2 {
+			logger.Printf("warn: region '%s' has %d compared map positions; statistics need at least 2\n", region.Name, s.N)
+			continue
+		}
+		logger.Printf("%s: n=%d, K A=%.4f B=%.4f, bias %+.4g (%+.1f%%), limits of agreement [%+.4g, %+.4g], r=%.3f, d=%.2f, dz=%.2f\n",
+			region.Name, s.N, s.MeanA, s.MeanB, s.Bias, 100*s.RelativeBias, s.Lower, s.Upper, s.Correlation, s.CohenD, s.PairedD)
+	}
+	if comparison.Skipped > 0 {
+		logger.Printf("%d map positions excluded, clipped by the display range or masked in either run are not compared.\n", comparison.Skipped)
+	}
+
+	if *jsonPath != "" {
+		data, err := json.MarshalIndent(comparison, "", "    ")
+		if err != nil {
+			return err
+		}
+		if err = atomicfile.WriteFile(*jsonPath, data); err != nil {
+			return fmt.Errorf("error saving comparison to '%s': %w", *jsonPath, err)
+		}
+		logger.Printf("comparison saved: %s\n", *jsonPath)
+	}
+	if *pointsPath != "" {
+		if err = writeDiffPoints(*pointsPath, points); err != nil {
+			return fmt.Errorf("error saving Bland-Altman points to '%s': %w", *pointsPath, err)
+		}
+		logger.Printf("Bland-Altman points saved: %s\n", *pointsPath)
+	}
+	return nil
+}
+
+// diffWholeMap - имя области сравнения всей карты.
+const diffWholeMap = "map"
+
+// diffReport - результат сравнения запусков (файл --json).
+type diffReport struct {
+	// A, B - отчеты сравниваемых запусков.
+	A string `json:"a"`
+	B string `json:"b"`
+	// Differences - различия параметров алгоритма запусков.
+	Differences []string `json:"parameter_differences,omitempty"`
+	// Skipped - число положений карты, не учтенных в сравнении.
+	Skipped int `json:"skipped"`
+	// Regions - показатели согласия по всей карте и по областям.
+	Regions []diffRegion `json:"regions"`
+}
+
+// diffRegion - показатели согласия области.
+type diffRegion struct {
+	Name string `json:"name"`
+	// Positions - положения окна карты, лежащие в области.
+	Positions image.Rectangle `json:"positions"`
+	agreement.Stats
+}
+
+// diffPoint - пара значений положения карты для графика Бланда-Альтмана.
+type diffPoint struct {
+	region string
+	x, y   int
+	a, b   float64
+}
+
+// writeDiffPoints сохраняет точки графика Бланда-Альтмана в CSV-файл со столбцами
+// region, x, y, a, b, mean, difference.
+func writeDiffPoints(path string, points []diffPoint) error {
+	return atomicfile.Write(path, func(file io.Writer) error {
+		w := csv.NewWriter(file)
+		if err := w.Write([]string{"region", "x", "y", "a", "b", "mean", "difference"}); err != nil {
+			return err
+		}
+		format := func(v float64) string { return strconv.FormatFloat(v, 'g', 6, 64) }
+		for _, p := range points {
+			record := []string{p.region, strconv.Itoa(p.x), strconv.Itoa(p.y),
+				format(p.a), format(p.b), format((p.a + p.b) / 2), format(p.b - p.a)}
+			if err := w.Write(record); err != nil {
+				return err
+			}
+		}
+		w.Flush()
+		return w.Error()
+	})
+}
+
+// runMap - итоговая карта контраста запуска, восстановленная из изображения по шкале
+// отображения отчета о запуске.
+type runMap struct {
+	// path - путь к отчету о запуске; file - путь к изображению карты.
+	path, file string
+	cfg        *config.Config
+	// width, height - размеры карты.
+	width, height int
+	// values - значения контраста; valid отмечает положения, значение которых
+	// восстанавливается однозначно (не исключенные и не ограниченные шкалой).
+	values []float64
+	valid  []bool
+	// low, high - значения, отображенные в уровни 0 и maxLevel.
+	low, high float64
+	maxLevel  int
+}
+
+// step возвращает разность значений соседних уровней изображения карты.
+func (m *runMap) step() float64 {
+	return (m.high - m.low) / float64(m.maxLevel)
+}
+
+// loadRunMap загружает итоговую карту запуска по пути к отчету о запуске или к директории
+// результатов, содержащей report.json.
+func loadRunMap(path string) (*runMap, error) {
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		path = filepath.Join(path, "report.json")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading run report: %w", err)
+	}
+	var rep struct {
+		Config       *config.Config `json:"config"`
+		DisplayRange []float64      `json:"display_range"`
+	}
+	if err = json.Unmarshal(data, &rep); err != nil || rep.Config == nil {
+		return nil, fmt.Errorf("'%s' is not a run report: %v", path, err)
+	}
+	m := &runMap{path: path, cfg: rep.Config}
+	switch {
+	case len(rep.DisplayRange) == 2:
+		m.low, m.high = rep.DisplayRange[0], rep.DisplayRange[1]
+	case rep.Config.Output.Normalization == "" || rep.Config.Output.Normalization == "fixed":
+		m.low, m.high = rep.Config.Output.ContrastMin, rep.Config.Output.ContrastMax
+	default:
+		return nil, fmt.Errorf("run '%s': the '%s' display scale is not recorded in the report, so contrast values cannot be restored",
+			path, rep.Config.Output.Normalization)
+	}
+
+	// Изображение карты сохраняется в директории результатов рядом с отчетом.
+	m.file = filepath.Join(filepath.Dir(path), rep.Config.Paths.OutputFilename)
+	img, err := imageutils.LoadImage(m.file)
+	if err != nil {
+		return nil, fmt.Errorf("run '%s': %w", path, err)
+	}
+	f, depth := imageutils.ConvertToFrame(img)
+	m.maxLevel = 1<<depth - 1
+	bounds := f.Bounds()
+	m.width, m.height = bounds.Dx(), bounds.Dy()
+	m.values = make([]float64, m.width*m.height)
+	m.valid = make([]bool, m.width*m.height)
+	// Исключенные положения выводятся уровнем output.background.
+	background := int(math.Round(rep.Config.Output.Background * float64(m.maxLevel)))
+	buf := frame.RowBuffer(f)
+	for y := range m.height {
+		for x, level := range f.Row(bounds.Min.Y+y, buf) {
+			i := y*m.width + x
+			// Уровни 0 и maxLevel получают и значения за границами шкалы, и исключенные положения.
+			m.valid[i] = level > 0 && int(level) < m.maxLevel && int(level) != background
+			m.values[i] = m.low + float64(level)*m.step()
+		}
+	}
+	return m, nil
+}
+
+// checkAligned проверяет, что положения карт m и other соответствуют одним окнам кадра:
+// совпадают размеры карт, окно и участок кадра roi.
+func (m *runMap) checkAligned(other *runMap) error {
+	switch {
+	case m.width != other.width || m.height != other.height:
+		return fmt.Errorf("map sizes differ: A %dx%d, B %dx%d", m.width, m.height, other.width, other.height)
+	case m.cfg.Algorithm.WindowSize != other.cfg.Algorithm.WindowSize:
+		return fmt.Errorf("window sizes differ: A %d, B %d; map positions do not correspond",
+			m.cfg.Algorithm.WindowSize, other.cfg.Algorithm.WindowSize)
+	case !slices.Equal(m.cfg.ROI, other.cfg.ROI):
+		return fmt.Errorf("regions of interest differ: A %v, B %v; map positions do not correspond", m.cfg.ROI, other.cfg.ROI)
+	}
+	return nil
+}
+
+// regions возвращает области сравнения в координатах карты: всю карту и области
+// regions конфигурации запуска (положения окна, целиком лежащие в области).
+func (m *runMap) regions() ([]roi.Region, error) {
+	ws := m.cfg.Algorithm.WindowSize
+	area := image.Rect(0, 0, m.width+ws-1, m.height+ws-1)
+	if len(m.cfg.ROI) == 4 {
+		area = area.Add(image.Pt(m.cfg.ROI[0], m.cfg.ROI[1]))
+	}
+	regions, err := buildRegions(m.cfg, area)
+	if err != nil {
+		return nil, fmt.Errorf("run '%s': %w", m.path, err)
+	}
+	out := []roi.Region{{Name: diffWholeMap, Rect: image.Rect(0, 0, m.width, m.height)}}
+	for _, r := range regions {
+		positions := image.Rect(r.Rect.Min.X, r.Rect.Min.Y, r.Rect.Max.X-ws+1, r.Rect.Max.Y-ws+1).Sub(area.Min)
+		if positions.Empty() {
+			positions = image.Rectangle{}
+		}
+		region := roi.Region{Name: r.Name, Rect: positions}
+		if r.Inside != nil {
+			// Для областей произвольной формы окно положения должно целиком лежать в фигуре.
+			region.Inside = make([]bool, positions.Dx()*positions.Dy())
+			for y := positions.Min.Y; y < positions.Max.Y; y++ {
+				for x := positions.Min.X; x < positions.Max.X; x++ {
+					region.Inside[(y-positions.Min.Y)*positions.Dx()+x-positions.Min.X] =
+						windowInside(r, x+area.Min.X, y+area.Min.Y, ws)
+				}
+			}
+		}
+		out = append(out, region)
+	}
+	return out, nil
+}
+
+// windowInside сообщает, лежит ли окно ws x ws с верхним левым углом (x, y) кадра
+// целиком в области r.
+func windowInside(r roi.Region, x, y, ws int) bool {
+	for dy := range ws {
+		for dx := range ws {
+			if !r.Contains(x+dx, y+dy) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// parameterDifferences возвращает различия параметров алгоритма и подавления шума
+// конфигураций a и b в виде "ключ: значение A -> значение B".
+func parameterDifferences(a, b *config.Config) ([]string, error) {
+	settingsA, err := a.Effective()
+	if err != nil {
+		return nil, err
+	}
+	settingsB, err := b.Effective()
+	if err != nil {
+		return nil, err
+	}
+	valuesB := make(map[string]string, len(settingsB))
+	for _, s := range settingsB {
+		valuesB[s.Key] = string(s.Value)
+	}
+	var differences []string
+	for _, s := range settingsA {
+		if !strings.HasPrefix(s.Key, "algorithm.") && !strings.HasPrefix(s.Key, "denoise.") {
+			continue
+		}
+		if value := valuesB[s.Key]; value != string(s.Value) {
+			differences = append(differences, fmt.Sprintf("%s: %s -> %s", s.Key, s.Value, value))
+		}
+	}
+	return differences, nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"log"
+	"slices"
+	"sync"
+
+	"github.com/mascotmascot1/go-tlasca/internal/events"
+	"github.com/mascotmascot1/go-tlasca/pkg/tlasca"
+)
+
+// runWarnings выводит в лог предупреждения запуска (events.Warning) и собирает их
+// для отчета о запуске.
+type runWarnings struct {
+	mu     sync.Mutex
+	logger *log.Logger
+	list   []string
+}
+
+// newRunWarnings создает сборщик предупреждений и подписывает его на шину bus.
+func newRunWarnings(bus *events.Bus, logger *log.Logger) *runWarnings {
+	w := &runWarnings{logger: logger}
+	bus.Subscribe(w.handle)
+	return w
+}
+
+// handle принимает события шины.
+func (w *runWarnings) handle(e events.Event) {
+	if e.Kind != events.Warning {
+		return
+	}
+	w.logger.Printf("warn: %s\n", e.Message)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.list = append(w.list, e.Message)
+}
+
+// Warnings возвращает копию предупреждений в порядке публикации.
+func (w *runWarnings) Warnings() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return slices.Clone(w.list)
+}
+
+// publishProgress возвращает tlasca.ProgressFunc, публикующую выполнение этапов расчета
+// событиями events.Progress.
+func publishProgress(bus *events.Bus) tlasca.ProgressFunc {
+	return func(p tlasca.Progress) {
+		bus.Publish(events.Event{Kind: events.Progress, Stage: p.Stage, Done: p.Done, Total: p.Total})
+	}
+}
+
+// onProgress подписывает fn на выполнение этапов расчета (events.Progress) в шине bus.
+func onProgress(bus *events.Bus, fn tlasca.ProgressFunc) {
+	bus.Subscribe(func(e events.Event) {
+		if e.Kind == events.Progress {
+			fn(tlasca.Progress{Stage: e.Stage, Done: e.Done, Total: e.Total})
+		}
+	})
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"unicode"
+
+	"github.com/mascotmascot1/go-tlasca/internal/config"
+	"github.com/mascotmascot1/go-tlasca/internal/denoise"
+	"github.com/mascotmascot1/go-tlasca/internal/imageutils"
+	"github.com/mascotmascot1/go-tlasca/internal/render"
+	"github.com/mascotmascot1/go-tlasca/internal/telemetry"
+	"github.com/mascotmascot1/go-tlasca/internal/worldfile"
+	"github.com/mascotmascot1/go-tlasca/pkg/frame"
+	"github.com/mascotmascot1/go-tlasca/pkg/tlasca"
+)
+
+// interleaveSkip отмечает в схеме чередования неиспользуемые кадры.
+const interleaveSkip = '-'
+
+// frameChannel - кадры одного канала последовательности: состояния освещения
+// схемы чередования или канала поляризации.
+type frameChannel struct {
+	// name - имя канала (символ состояния в схеме чередования); входит в имя файла карты.
+	name string
+	// frames - индексы кадров состояния в последовательности.
+	frames []int
+}
+
+// interleaveStates разбирает схему чередования pattern (см. config.InputConfig.Interleave)
+// для последовательности из total кадров и возвращает состояния в порядке первого появления.
+// Схема должна содержать не менее двух разных состояний, а каждое состояние - не менее двух кадров.
+func interleaveStates(pattern string, total int) ([]frameChannel, error) {
+	symbols := []rune(pattern)
+	var states []frameChannel
+	index := make(map[rune]int)
+	for _, s := range symbols {
+		if s == interleaveSkip {
+			continue
+		}
+		// Символ состояния входит в имя файла карты.
+		if !unicode.IsLetter(s) && !unicode.IsDigit(s) {
+			return nil, fmt.Errorf("interleave pattern '%s' must contain only letters, digits and '-'", pattern)
+		}
+		if _, ok := index[s]; !ok {
+			index[s] = len(states)
+			states = append(states, frameChannel{name: string(s)})
+		}
+	}
+	if len(states) < 2 {
+		return nil, fmt.Errorf("interleave pattern '%s' must contain at least 2 different states", pattern)
+	}
+	for i := range total {
+		if s := symbols[i%len(symbols)]; s != interleaveSkip {
+			states[index[s]].frames = append(states[index[s]].frames, i)
+		}
+	}
+	for _, state := range states {
+		if len(state.frames) < 2 {
+			return nil, fmt.Errorf("interleave state '%s' has %d of %d frames, at least 2 are required",
+				state.name, len(state.frames), total)
+		}
+	}
+	return states, nil
+}
+
+// runInterleaved рассчитывает карты состояний освещения схемы чередования input.interleave
+// (см. runChannels) и карту отношения контрастов первых двух состояний.
+func runInterleaved(ctx context.Context, cfg *config.Config, logger *log.Logger, rec *telemetry.Recorder, runner *tlasca.Runner,
+	loader *frameLoader, normalizer render.Normalizer, files []string, opts tlasca.Options, chunkSize int, run seriesRun) error {
+	states, err := interleaveStates(cfg.Input.Interleave, len(files))
+	if err != nil {
+		return err
+	}
+	for _, state := range states {
+		logger.Printf("illumination state '%s': %d of %d frames.\n", state.name, len(state.frames), len(files))
+	}
+	return runChannels(ctx, cfg, logger, rec, runner, loader, normalizer, files, opts, chunkSize, run, states,
+		func(results []*tlasca.Result, save saveMap) error {
+			if cfg.Output.RatioFilename == "" {
+				return nil
+			}
+			a, b := results[0], results[1]
+			ratio := make([]float64, len(a.Contrast))
+			for i := range ratio {
+				if b.Contrast[i] > 0 {
+					ratio[i] = a.Contrast[i] / b.Contrast[i]
+				}
+			}
+			logger.Printf("contrast ratio '%s'/'%s': median %.4g.\n", states[0].name, states[1].name,
+				render.Percentile(ratio, a.Excluded, 50))
+			path := filepath.Join(cfg.Paths.ResultsDir, cfg.Output.RatioFilename)
+			img := render.GrayPlane(ratio, a.Width, a.Height, a.Excluded, render.Range{Min: 0, Max: cfg.Output.RatioMax})
+			return save(path, "contrast ratio map", img)
+		})
+}
+
+// saveMap сохраняет изображение карты img (what - описание для сообщения об ошибке)
+// в path вместе с файлом привязки и добавляет файлы в список результатов запуска.
+type saveMap func(path, what string, img image.Image) error
+
+// runChannels рассчитывает карту временного контраста для каждого канала channels
+// по кадрам этого канала и сохраняет карты в директорию результатов (как output_filename
+// с именем канала), затем вызывает combine для производных карт каналов и сохраняет
+// отчет о запуске. Кадры каждого канала загружаются отдельно (порциями по chunkSize,
+// если он задан).
+func runChannels(ctx context.Context, cfg *config.Config, logger *log.Logger, rec *telemetry.Recorder, runner *tlasca.Runner,
+	loader *frameLoader, normalizer render.Normalizer, files []string, opts tlasca.Options, chunkSize int, run seriesRun,
+	channels []frameChannel, combine func(results []*tlasca.Result, save saveMap) error) error {
+	if err := os.MkdirAll(cfg.Paths.ResultsDir, 0755); err != nil {
+		return fmt.Errorf("error creating results directory '%s': %w", cfg.Paths.ResultsDir, err)
+	}
+	transform := stageTransform(cfg, run.area)
+	if transform != nil {
+		// Как и для одной карты, центр пикселя карты смещен на (window_size-1)/2 пикселя кадра.
+		offset := float64(cfg.Algorithm.WindowSize-1) / 2
+		t := transform.Offset(offset, offset)
+		transform = &t
+	}
+
+	outputs := slices.Clone(run.outputs)
+	save := func(path, what string, img image.Image) error {
+		stopSave := rec.Start("save")
+		err := imageutils.SavePNG(path, img)
+		stopSave()
+		if err != nil {
+			return fmt.Errorf("error saving %s to '%s': %w", what, path, err)
+		}
+		outputs = append(outputs, path)
+		if transform != nil {
+			worldPath := worldfile.SidecarPath(path)
+			if err = worldfile.Write(worldPath, *transform); err != nil {
+				return err
+			}
+			outputs = append(outputs, worldPath)
+		}
+		return nil
+	}
+
+	results := make([]*tlasca.Result, len(channels))
+	for c, channel := range channels {
+		logger.Printf("processing channel '%s' (%d frames)...\n", channel.name, len(channel.frames))
+		// Кадры канала загружаются по одному, чтобы индексы пропущенных кадров
+		// оставались индексами всей последовательности.
+		load := func(start, end int) ([]frame.Frame, error) {
+			images := make([]frame.Frame, 0, end-start)
+			for _, i := range channel.frames[start:end] {
+				img, err := loader.load(i, files[i:i+1])
+				if err != nil {
+					return nil, err
+				}
+				images = append(images, img[0])
+			}
+			return images, nil
+		}
+		channelOpts := opts
+		if opts.Gains != nil {
+			channelOpts.Gains = make([]float64, len(channel.frames))
+			for j, i := range channel.frames {
+				channelOpts.Gains[j] = opts.Gains[i]
+			}
+		}
+		chunk := len(channel.frames)
+		if chunkSize > 0 {
+			chunk = chunkSize
+		}
+		result, err := runner.RunChunked(ctx, len(channel.frames), chunk, load, channelOpts)
+		if err != nil {
+			return fmt.Errorf("channel '%s': %w", channel.name, err)
+		}
+		if run.denoiser != nil {
+			ws := cfg.Algorithm.WindowSize
+			stopDenoise := rec.Start("denoise")
+			result.Contrast = run.denoiser.Apply(result.Contrast, result.Width, result.Height, result.Excluded,
+				denoise.RelativeNoise(len(channel.frames), ws*ws))
+			stopDenoise()
+		}
+		results[c] = result
+
+		path := filepath.Join(cfg.Paths.ResultsDir, seriesFilename(cfg.Paths.OutputFilename, channel.name))
+		if err = save(path, "contrast map", contrastImage(outputMap(cfg, result), normalizer, cfg.Output.ResultBitDepth, cfg.Output.Background)); err != nil {
+			return err
+		}
+	}
+
+	if err := combine(results, save); err != nil {
+		return err
+	}
+	return finishSeries(cfg, logger, rec, loader, files, run, outputs)
+}
+
+// contrastImage строит изображение карты контраста result разрядности bitDepth (8 или 16 бит)
+// со шкалой, подобранной normalizer по этой карте, и исключенными положениями уровня background.
+func contrastImage(result *tlasca.Result, normalizer render.Normalizer, bitDepth int, background float64) image.Image {
+	scale := normalizer.Fit(result.Contrast, result.Excluded)
+	if bitDepth == 16 {
+		return render.FillBackground(render.Gray16(result, scale), result.Excluded, background)
+	}
+	return render.FillBackground(render.Gray(result, scale), result.Excluded, background)
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"path/filepath"
+
+	"github.com/mascotmascot1/go-tlasca/internal/config"
+	"github.com/mascotmascot1/go-tlasca/internal/speckle"
+	"github.com/mascotmascot1/go-tlasca/pkg/tlasca"
+)
+
+// checkContrastLimits сравнивает карту result с теоретическими границами контраста
+// по параметрам секции contrast_limits. Возвращает сводку проверки, изображения маски
+// и наложения на карту mapImage для сохранения (если их имена заданы) и предупреждение,
+// если часть положений карты вне границ (пустая строка, если таких нет).
+func checkContrastLimits(cfg *config.Config, result *tlasca.Result, mapImage *image.Gray) (speckle.Summary, []pngOutput, string, error) {
+	limitsCfg := cfg.ContrastLimits
+	model, err := speckle.NewModel(limitsCfg.ExposureTime, limitsCfg.StaticFraction, limitsCfg.Beta)
+	if err != nil {
+		return speckle.Summary{}, nil, "", fmt.Errorf("invalid contrast_limits: %w", err)
+	}
+	if limitsCfg.CorrelationTimeMax > 0 && limitsCfg.CorrelationTimeMax < limitsCfg.CorrelationTimeMin {
+		return speckle.Summary{}, nil, "", fmt.Errorf("invalid contrast_limits: correlation_time_max %g is less than correlation_time_min %g",
+			limitsCfg.CorrelationTimeMax, limitsCfg.CorrelationTimeMin)
+	}
+	if limitsCfg.Tolerance < 0 {
+		return speckle.Summary{}, nil, "", fmt.Errorf("invalid contrast_limits: tolerance must be non-negative, got %g", limitsCfg.Tolerance)
+	}
+
+	limits := model.Limits(limitsCfg.CorrelationTimeMin, limitsCfg.CorrelationTimeMax)
+	summary, mask := speckle.Check(result, model, limits, limitsCfg.Tolerance)
+	var outputs []pngOutput
+	if limitsCfg.MaskFilename != "" {
+		path := filepath.Join(cfg.Paths.ResultsDir, limitsCfg.MaskFilename)
+		outputs = append(outputs, pngOutput{path, "contrast limits mask", mask})
+	}
+	if limitsCfg.OverlayFilename != "" {
+		path := filepath.Join(cfg.Paths.ResultsDir, limitsCfg.OverlayFilename)
+		outputs = append(outputs, pngOutput{path, "contrast limits overlay", speckle.Overlay(mapImage, mask)})
+	}
+	var warning string
+	if summary.Above+summary.Below > 0 {
+		warning = fmt.Sprintf("%d map pixels (%.2f%%) are outside the theoretical contrast limits [%.4g, %.4g]: %d below, %d above; check intensity normalization, saturation, dark offset and frame registration",
+			summary.Above+summary.Below, 100*summary.Fraction(), limits.Min, limits.Max, summary.Below, summary.Above)
+	}
+	return summary, outputs, warning, nil
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"io"
+	"log"
+	"path/filepath"
+
+	"github.com/mascotmascot1/go-tlasca/internal/calibration"
+	"github.com/mascotmascot1/go-tlasca/internal/camera"
+	"github.com/mascotmascot1/go-tlasca/internal/events"
+	"github.com/mascotmascot1/go-tlasca/internal/imageutils"
+	"github.com/mascotmascot1/go-tlasca/internal/registration"
+	"github.com/mascotmascot1/go-tlasca/internal/telemetry"
+	"github.com/mascotmascot1/go-tlasca/pkg/frame"
+	"github.com/mascotmascot1/go-tlasca/pkg/tlasca"
+)
+
+// frameLoader загружает и подготавливает кадры последовательности: декодирование,
+// приведение к кадру frame.Frame с исходными значениями отсчетов, контроль
+// насыщения, вычитание темнового смещения (если задан dark), поправка неоднородности
+// чувствительности (если задан flat), исправление дисторсии объектива (если задан undistort)
+// (если задан aligner) совмещение с опорным кадром и (если задан crop) обрезка до участка roi.
+// Состояние загрузчика (опорный кадр, статистика насыщения) сохраняется между вызовами load,
+// поэтому один загрузчик используется для всех порций последовательности.
+// О каждом подготовленном кадре сообщается в шину bus (events.FrameProcessed).
+type frameLoader struct {
+	logger  *log.Logger
+	rec     *telemetry.Recorder
+	bus     *events.Bus
+	aligner *registration.Aligner
+	// dark - профиль шума камеры, смещение которого вычитается из кадров (nil - без вычитания).
+	dark *camera.Profile
+	// flat - поправка неоднородности чувствительности сенсора (nil - без поправки).
+	flat *camera.FlatField
+	// undistort - исправление дисторсии объектива (nil - без исправления).
+	undistort *calibration.Remap
+	// crop - участок кадра, до которого обрезаются подготовленные кадры (пустой - весь кадр).
+	// Обрезанный кадр сохраняет координаты кадра и не удерживает отсчеты вне участка.
+	crop image.Rectangle
+
+	// containerDepth - разрядность контейнера первого кадра; все кадры должны ей соответствовать.
+	containerDepth int
+	// saturationLevel - значение отсчета, начиная с которого пиксель считается насыщенным.
+	saturationLevel uint16
+	// saturationWarnFraction - доля насыщенных пикселей, при превышении которой кадр считается пересвеченным.
+	saturationWarnFraction float64
+
+	// tolerant включает пропуск нечитаемых кадров вместо прерывания загрузки.
+	tolerant bool
+
+	// saturatedFrames - имена пересвеченных кадров; maxSaturated - наибольшая доля насыщенных пикселей.
+	saturatedFrames []string
+	maxSaturated    float64
+	// skippedFrames - пропущенные нечитаемые кадры (только в режиме tolerant).
+	skippedFrames []skippedFrame
+}
+
+// skippedFrame описывает кадр, пропущенный из-за ошибки чтения.
+type skippedFrame struct {
+	// Index - индекс кадра в последовательности загрузчика.
+	Index int
+	Path  string
+	Err   error
+}
+
+// load обрабатывает список путей к файлам, загружая и конвертируя каждое изображение.
+// По умолчанию функция возвращает ошибку, если хотя бы один из файлов не может быть обработан,
+// так как для алгоритма tLASCA важна целостность и порядок последовательности.
+// В режиме tolerant нечитаемый кадр пропускается с предупреждением: на его месте
+// возвращается nil, а сам кадр учитывается в skippedFrames с индексом first + позиция в paths.
+// Декодирование, подготовка и совмещение каждого кадра фиксируются в телеметрии как отдельные этапы.
+func (l *frameLoader) load(first int, paths []string) ([]frame.Frame, error) {
+	grayImages := make([]frame.Frame, 0, len(paths))
+	for i, filePath := range paths {
+		grayImg, err := l.read(filePath)
+		if err != nil {
+			if !l.tolerant {
+				return nil, err
+			}
+			l.logger.Printf("warn: skipping unreadable frame: %v\n", err)
+			l.skippedFrames = append(l.skippedFrames, skippedFrame{Index: first + i, Path: filePath, Err: err})
+			grayImages = append(grayImages, nil)
+			continue
+		}
+
+		// Темновое смещение относится к пикселям сенсора, поэтому вычитается до геометрических преобразований.
+		if l.dark != nil {
+			stopDark := l.rec.Start("dark")
+			grayImg, err = l.dark.Subtract(grayImg)
+			stopDark()
+			if err != nil {
+				return nil, fmt.Errorf("failed to subtract dark offset from image '%s': %w", filePath, err)
+			}
+		}
+		if l.flat != nil {
+			stopFlat := l.rec.Start("flat_field")
+			grayImg, err = l.flat.Apply(grayImg)
+			stopFlat()
+			if err != nil {
+				return nil, fmt.Errorf("failed to apply flat field to image '%s': %w", filePath, err)
+			}
+		}
+		if l.undistort != nil {
+			stopUndistort := l.rec.Start("undistort")
+			grayImg, err = l.undistort.Apply(grayImg)
+			stopUndistort()
+			if err != nil {
+				return nil, fmt.Errorf("failed to undistort image '%s': %w", filePath, err)
+			}
+		}
+		if l.aligner != nil {
+			stopAlign := l.rec.Start("registration")
+			grayImg = l.aligner.Align(grayImg)
+			stopAlign()
+		}
+		if !l.crop.Empty() {
+			grayImg = frame.Copy(grayImg, l.crop)
+		}
+		grayImages = append(grayImages, grayImg)
+		l.bus.Publish(events.Event{Kind: events.FrameProcessed, Frame: first + i, Path: filePath})
+	}
+	return grayImages, nil
+}
+
+// read декодирует кадр filePath и приводит его к frame.Frame,
+// проверяя разрядность контейнера и учитывая насыщение.
+func (l *frameLoader) read(filePath string) (frame.Frame, error) {
+	stopDecode := l.rec.Start("decode")
+	img, err := imageutils.LoadImage(filePath)
+	stopDecode()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load image '%s': %w", filePath, err)
+	}
+
+	defer l.rec.Start("preprocess")()
+	grayImg, depth := imageutils.ConvertToFrame(img)
+	if depth != l.containerDepth {
+		return nil, fmt.Errorf("image '%s' has %d-bit samples, expected %d-bit like the first frame", filePath, depth, l.containerDepth)
+	}
+	l.checkSaturation(filePath, grayImg)
+	return grayImg, nil
+}
+
+// fork возвращает загрузчик с теми же параметрами и опорным кадром совмещения,
+// но с пустой статистикой: повторная загрузка части последовательности
+// не искажает сведения, собранные при основной загрузке. Копия работает в строгом режиме:
+// пропущенные при основной загрузке кадры к этому моменту исключены из последовательности
+// (см. withoutSkipped), поэтому ошибка чтения при повторной загрузке прерывает запуск.
+func (l *frameLoader) fork() *frameLoader {
+	forked := &frameLoader{
+		logger:                 l.logger,
+		rec:                    l.rec,
+		containerDepth:         l.containerDepth,
+		saturationLevel:        l.saturationLevel,
+		saturationWarnFraction: l.saturationWarnFraction,
+		dark:                   l.dark,
+		flat:                   l.flat,
+		undistort:              l.undistort,
+		crop:                   l.crop,
+	}
+	if l.aligner != nil {
+		forked.aligner = l.aligner.Fork()
+	}
+	return forked
+}
+
+// sequenceLoader возвращает функцию повторного чтения последовательности files
+// для дополнительных проходов (диагностика, анализ областей). Если кадры уже загружены
+// (frames не nil), они берутся из памяти; иначе каждая порция загружается копией
+// загрузчика, чтобы повторная загрузка не искажала статистику основной.
+func sequenceLoader(l *frameLoader, files []string, frames []frame.Frame) tlasca.ChunkLoader {
+	if frames != nil {
+		return func(start, end int) ([]frame.Frame, error) {
+			return frames[start:end], nil
+		}
+	}
+	return func(start, end int) ([]frame.Frame, error) {
+		return l.fork().load(start, files[start:end])
+	}
+}
+
+// frameStream - источник кадров files для tlasca.Runner.RunStream: кадры загружаются
+// загрузчиком по одному (нечитаемые кадры в режиме tolerant выдаются как nil).
+type frameStream struct {
+	loader *frameLoader
+	files  []string
+	next   int
+}
+
+// Next загружает следующий кадр последовательности или возвращает io.EOF.
+func (s *frameStream) Next() (frame.Frame, error) {
+	if s.next >= len(s.files) {
+		return nil, io.EOF
+	}
+	s.next++
+	frames, err := s.loader.load(s.next-1, s.files[s.next-1:s.next])
+	if err != nil {
+		return nil, err
+	}
+	return frames[0], nil
+}
+
+// Len возвращает число кадров источника (для сведений о выполнении RunStream).
+func (s *frameStream) Len() int {
+	return len(s.files)
+}
+
+// checkSaturation учитывает долю насыщенных пикселей кадра в статистике загрузчика.
+func (l *frameLoader) checkSaturation(filePath string, img frame.Frame) {
+	pixels := img.Bounds().Dx() * img.Bounds().Dy()
+	fraction := float64(imageutils.CountAtLeast(img, l.saturationLevel)) / float64(pixels)
+	l.maxSaturated = max(l.maxSaturated, fraction)
+	if fraction > l.saturationWarnFraction {
+		l.saturatedFrames = append(l.saturatedFrames, filePath)
+	}
+}
+
+// saturationWarning возвращает предупреждение о кадрах, доля насыщенных пикселей
+// которых превысила warnFraction, или пустую строку, если таких кадров нет.
+func (l *frameLoader) saturationWarning(warnFraction float64, bitDepth int) string {
+	if len(l.saturatedFrames) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d frames have more than %.1f%% saturated pixels (>= %d of %d-bit full scale), max %.1f%%, first: %s",
+		len(l.saturatedFrames), 100*warnFraction, l.saturationLevel, bitDepth,
+		100*l.maxSaturated, filepath.Base(l.saturatedFrames[0]))
+}
+
+// cropPlane возвращает участок rect плоскости values (построчно, ширины width)
+// в виде плоскости rect.Dx() x rect.Dy(); для nil возвращает nil.
+func cropPlane(values []float64, width int, rect image.Rectangle) []float64 {
+	if values == nil {
+		return nil
+	}
+	out := make([]float64, 0, rect.Dx()*rect.Dy())
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		out = append(out, values[y*width+rect.Min.X:y*width+rect.Max.X]...)
+	}
+	return out
+}
+
+// withoutSkipped возвращает копию values (попадровых данных последовательности загрузчика)
+// без элементов пропущенных кадров. Если пропусков нет, values возвращается без копирования.
+func withoutSkipped[T any](values []T, skipped []skippedFrame) []T {
+	if len(skipped) == 0 || values == nil {
+		return values
+	}
+	kept := make([]T, 0, len(values)-len(skipped))
+	next := 0
+	for i, v := range values {
+		if next < len(skipped) && skipped[next].Index == i {
+			next++
+			continue
+		}
+		kept = append(kept, v)
+	}
+	return kept
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/mascotmascot1/go-tlasca/internal/events"
+	"github.com/mascotmascot1/go-tlasca/internal/report"
+	"github.com/mascotmascot1/go-tlasca/pkg/tlasca"
+)
+
+// machineProtocol - версия протокола режима run --machine. Версия увеличивается только
+// при несовместимых изменениях; новые необязательные поля добавляются без изменения версии.
+const machineProtocol = 1
+
+// machineRequest - запрос режима run --machine (один объект JSON на стандартном вводе).
+type machineRequest struct {
+	// Protocol - версия протокола, на которую рассчитан клиент (0 - текущая).
+	Protocol int `json:"protocol"`
+	// Config - путь к файлу конфигурации (по умолчанию go-tlasca.json рабочей директории).
+	Config string `json:"config"`
+	// DataDir и ResultsDir - директории кадров и результатов (переопределяют paths.data_dir
+	// и paths.results_dir).
+	DataDir    string `json:"data_dir"`
+	ResultsDir string `json:"results_dir"`
+	// Settings - параметры конфигурации в той же схеме, что и go-tlasca.json,
+	// с приоритетом над файлами конфигурации.
+	Settings json.RawMessage `json:"settings"`
+	// Overwrite разрешает заменять существующие результаты.
+	Overwrite bool `json:"overwrite"`
+	// Validate выполняет только проверки (как подкоманда validate).
+	Validate bool `json:"validate"`
+}
+
+// machineEvent - строка JSON стандартного вывода режима run --machine. Поле Event задает
+// вид строки: "started", "log", "progress", "result" (успешное завершение) или "error".
+type machineEvent struct {
+	Event    string `json:"event"`
+	Protocol int    `json:"protocol,omitempty"`
+	// Message - строка журнала ("log") или текст ошибки ("error").
+	Message string `json:"message,omitempty"`
+	// Stage, Done, Total и Percent - выполнение этапа расчета ("progress", см. tlasca.Progress).
+	Stage   string   `json:"stage,omitempty"`
+	Done    *int     `json:"done,omitempty"`
+	Total   *int     `json:"total,omitempty"`
+	Percent *float64 `json:"percent,omitempty"`
+	// Validated сообщает, что выполнялись только проверки ("result").
+	Validated bool `json:"validated,omitempty"`
+	// Report - путь к отчету о запуске (пустой, если сохранение отключено).
+	Report string `json:"report,omitempty"`
+	// Outputs - пути к выходным файлам ("result").
+	Outputs []string `json:"outputs,omitempty"`
+	// Frames - число обработанных кадров ("result").
+	Frames int `json:"frames,omitempty"`
+	// Stats - статистики итоговой карты контраста ("result"; нет для рядов карт).
+	Stats *report.MapStats `json:"stats,omitempty"`
+	// Warnings - предупреждения контроля качества ("result").
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// machineOutput записывает события режима run --machine в стандартный вывод по одному
+// объекту JSON в строке. Вызовы из горутин расчета и журнала упорядочиваются.
+type machineOutput struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+	// stage и step - последний этап и шаг выполнения, о котором было сообщено.
+	stage string
+	step  int
+}
+
+// emit записывает событие e.
+func (m *machineOutput) emit(e machineEvent) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.enc.Encode(e)
+}
+
+// Write принимает строки журнала и выводит их событиями "log".
+func (m *machineOutput) Write(p []byte) (int, error) {
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		m.emit(machineEvent{Event: "log", Message: line})
+	}
+	return len(p), nil
+}
+
+// progress выводит выполнение этапа событием "progress" не чаще, чем на каждый процент
+// (без общего числа единиц работы - на каждые 100 единиц), и по завершении этапа.
+func (m *machineOutput) progress(p tlasca.Progress) {
+	step := p.Done / 100
+	if p.Total > 0 {
+		step = int(p.Percent())
+	}
+	m.mu.Lock()
+	changed := p.Stage != m.stage || step > m.step || (p.Total > 0 && p.Done >= p.Total)
+	m.stage, m.step = p.Stage, step
+	m.mu.Unlock()
+	if changed {
+		percent := p.Percent()
+		m.emit(machineEvent{Event: "progress", Stage: p.Stage, Done: &p.Done, Total: &p.Total, Percent: &percent})
+	}
+}
+
+// runMachine выполняет режим run --machine: читает запрос из in, выполняет расчет
+// (или проверку) и выводит в out события JSON построчно: "started", строки журнала,
+// выполнение этапов и итог - "result" или "error". Режим предназначен для внешних
+// программ (модули napari и Fiji, графические оболочки): стандартный вывод содержит
+// только строки JSON. При ошибке журнал переключается на стандартный поток ошибок.
+func runMachine(logger *log.Logger, in io.Reader, out io.Writer, overwrite bool) (err error) {
+	m := &machineOutput{enc: json.NewEncoder(out)}
+	m.enc.SetEscapeHTML(false)
+	// Строки журнала выводятся без префикса и времени: клиент получает их как события.
+	prefix, flags := logger.Prefix(), logger.Flags()
+	logger.SetOutput(m)
+	logger.SetPrefix("")
+	logger.SetFlags(0)
+	defer func() {
+		if err != nil {
+			m.emit(machineEvent{Event: "error", Message: err.Error()})
+			logger.SetOutput(os.Stderr)
+			logger.SetPrefix(prefix)
+			logger.SetFlags(flags)
+		}
+	}()
+	m.emit(machineEvent{Event: "started", Protocol: machineProtocol})
+
+	var req machineRequest
+	dec := json.NewDecoder(in)
+	dec.DisallowUnknownFields()
+	if err = dec.Decode(&req); err != nil {
+		return fmt.Errorf("invalid machine request: %w", err)
+	}
+	if req.Protocol != 0 && req.Protocol != machineProtocol {
+		return fmt.Errorf("unsupported machine protocol %d, expected %d", req.Protocol, machineProtocol)
+	}
+	settings, err := requestSettings(req)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := interruptContext(logger)
+	defer stop()
+	result := machineEvent{Event: "result", Validated: req.Validate}
+	opts := runOptions{
+		overwrite: overwrite || req.Overwrite,
+		validate:  req.Validate,
+		config:    req.Config,
+		settings:  settings,
+		onReport: func(rep *report.Report) {
+			result.Outputs, result.Frames, result.Stats, result.Warnings = rep.Outputs, rep.Frames, rep.Contrast, rep.Warnings
+			if rep.Config.Paths.ReportFilename != "" {
+				result.Report = filepath.Join(rep.Config.Paths.ResultsDir, rep.Config.Paths.ReportFilename)
+			}
+		},
+	}
+	bus := events.New()
+	onProgress(bus, m.progress)
+	if err = run(ctx, logger, bus, opts); err != nil {
+		return err
+	}
+	m.emit(result)
+	return nil
+}
+
+// requestSettings возвращает документ конфигурации запроса: req.Settings с директориями
+// req.DataDir и req.ResultsDir (если заданы), или nil, если параметров нет.
+func requestSettings(req machineRequest) ([]byte, error) {
+	doc := map[string]any{}
+	if len(req.Settings) > 0 && string(req.Settings) != "null" {
+		if err := json.Unmarshal(req.Settings, &doc); err != nil {
+			return nil, fmt.Errorf("invalid machine request settings: %w", err)
+		}
+	}
+	for key, value := range map[string]string{"data_dir": req.DataDir, "results_dir": req.ResultsDir} {
+		if value == "" {
+			continue
+		}
+		paths, ok := doc["paths"].(map[string]any)
+		if !ok {
+			if doc["paths"] != nil {
+				return nil, fmt.Errorf("invalid machine request settings: paths must be an object")
+			}
+			paths = map[string]any{}
+			doc["paths"] = paths
+		}
+		if _, set := paths[key]; set {
+			return nil, fmt.Errorf("machine request sets both %s and settings.paths.%s", key, key)
+		}
+		paths[key] = value
+	}
+	if len(doc) == 0 {
+		return nil, nil
+	}
+	return json.Marshal(doc)
+}
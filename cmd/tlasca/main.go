@@ -2,114 +2,650 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"image"
+	"io/fs"
 	"log"
+	"math"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"runtime"
+	"slices"
 	"sort"
+	"strings"
+	"time"
 
+	"github.com/mascotmascot1/go-tlasca/internal/atomicfile"
 	"github.com/mascotmascot1/go-tlasca/internal/config"
+	"github.com/mascotmascot1/go-tlasca/internal/events"
+	"github.com/mascotmascot1/go-tlasca/internal/exposure"
 	"github.com/mascotmascot1/go-tlasca/internal/imageutils"
-	"github.com/mascotmascot1/go-tlasca/internal/tlasca"
+	"github.com/mascotmascot1/go-tlasca/internal/parallel"
+	"github.com/mascotmascot1/go-tlasca/internal/priority"
+	"github.com/mascotmascot1/go-tlasca/internal/raw"
+	"github.com/mascotmascot1/go-tlasca/internal/registration"
+	"github.com/mascotmascot1/go-tlasca/internal/render"
+	"github.com/mascotmascot1/go-tlasca/internal/report"
+	"github.com/mascotmascot1/go-tlasca/internal/safeguard"
+	"github.com/mascotmascot1/go-tlasca/internal/telemetry"
+	"github.com/mascotmascot1/go-tlasca/pkg/frame"
+	"github.com/mascotmascot1/go-tlasca/pkg/tlasca"
 )
 
-// main - точка входа. Ее единственная задача - настроить окружение (логгер)
-// и вызвать основную логику приложения в функции run.
+// configPath - путь к файлу конфигурации запуска.
+const configPath = "go-tlasca.json"
+
+// minSeparation - наименьшая разделимость классов сосуды/ткань (см. segment.Summary.Separation),
+// при которой порог между ними считается надежным.
+const minSeparation = 1.0
+
+// bitDepthSamples - число кадров, равномерно распределенных по записи, по которым
+// определяется разрядность входных данных, если input.bit_depth не задан.
+const bitDepthSamples = 8
+
+// main - точка входа. Ее единственная задача - настроить логгер и выполнить подкоманду
+// командной строки (см. commands); без имени подкоманды выполняется расчет run.
 func main() {
 	logger := log.New(os.Stdout, "[GO-TLASCA] ", log.LstdFlags)
+	cmd, args, err := parseCommand(os.Args[1:])
+	if err != nil {
+		logger.Fatalf("%v\n", err)
+	}
+	if err = cmd.run(logger, args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return
+		}
+		logger.Fatalf("%s: %v\n", cmd.failure, err)
+	}
+}
 
-	if err := run(logger); err != nil {
-		logger.Fatalf("application failed: %v\n", err)
+// interruptContext возвращает контекст, который отменяется по первому сигналу прерывания
+// (Ctrl-C): расчет завершается с ошибкой, не дожидаясь конца. После первого сигнала
+// восстанавливается обработка по умолчанию, и повторный Ctrl-C завершает программу сразу.
+// Возвращаемая функция освобождает обработчик сигнала.
+func interruptContext(logger *log.Logger) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt)
+	go func() {
+		select {
+		case <-signals:
+			signal.Stop(signals)
+			logger.Println("warn: interrupt received, cancelling (press Ctrl-C again to exit immediately)...")
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, func() {
+		signal.Stop(signals)
+		cancel()
 	}
 }
 
+// registerRawFormat регистрирует формат кадров без заголовка (если он задан): такие файлы
+// читаются по формату из конфигурации, а не по содержимому.
+func registerRawFormat(rawInput config.RawConfig) error {
+	if rawInput.Width <= 0 {
+		return nil
+	}
+	format, err := raw.NewFormat(rawInput.Width, rawInput.Height, rawInput.BitDepth, rawInput.ByteOrder, rawInput.HeaderBytes)
+	if err != nil {
+		return fmt.Errorf("invalid input raw config: %w", err)
+	}
+	if len(rawInput.Extension) < 2 || rawInput.Extension[0] != '.' {
+		return fmt.Errorf("invalid input raw extension '%s', expected a file extension such as '.raw'", rawInput.Extension)
+	}
+	imageutils.RegisterRaw(rawInput.Extension, format)
+	return nil
+}
+
+// runOptions - параметры запуска run.
+type runOptions struct {
+	// overwrite разрешает заменять существующие результаты.
+	overwrite bool
+	// validate завершает запуск после проверок, выполняемых до загрузки кадров
+	// (подкоманда validate): кадры не загружаются, файлы не сохраняются.
+	validate bool
+	// config - путь к файлу конфигурации (пустая строка - configPath).
+	config string
+	// settings - документ конфигурации, применяемый поверх файлов (см. config.Load).
+	settings []byte
+	// onReport получает отчет о завершенном запуске. Если функция задана, отчет
+	// формируется и тогда, когда его сохранение отключено.
+	onReport func(rep *report.Report)
+}
+
 // run содержит основной рабочий процесс приложения: от загрузки конфига до сохранения результата.
+// Этапы запуска выполняются отдельными функциями: проверка конфигурации (setupRun), поиск
+// и подготовка кадров (discoverInputs, prepareInputs), расчет (computeResult), сохранение
+// (saveResults), диагностика (runDiagnostics) и отчет о запуске.
+// Существующие результаты заменяются только при runOpts.overwrite. Отмена ctx (Ctrl-C)
+// прерывает расчет; уже сохраненные файлы остаются, а частичный результат прерванного
+// расчета карты сохраняется (см. salvagePartial). О ходе запуска сообщается в шину событий bus:
+// этапы (телеметрия), подготовленные кадры, выполнение этапов расчета, предупреждения
+// и завершение запуска; предупреждения выводятся в лог и собираются для отчета о запуске.
 // Возвращает ошибку, если какой-либо из критических шагов не может быть выполнен.
-func run(logger *log.Logger) error {
-	const configPath = "go-tlasca.json"
+// О завершении запуска после проверки конфигурации отправляются уведомления (notifications).
+func run(ctx context.Context, logger *log.Logger, bus *events.Bus, runOpts runOptions) (err error) {
+	startedAt := time.Now()
+	warnings := newRunWarnings(bus, logger)
+	defer func() {
+		bus.Publish(events.Event{Kind: events.RunFinished, Duration: time.Since(startedAt), Err: err})
+	}()
 
-	// Загружаем конфигурацию.
-	cfg, err := config.NewConfig(configPath, logger)
+	setup, err := setupRun(logger, bus, runOpts, startedAt)
 	if err != nil {
-		return fmt.Errorf("error loading config: %w", err)
+		return err
 	}
+	cfg := setup.cfg
 
-	// Инициализируем исполнителя алгоритма.
-	runner := tlasca.NewRunner(cfg, logger)
+	// Инициализируем телеметрию этапов и исполнителя алгоритма.
+	rec := telemetry.NewRecorder(logger, bus)
+	runner := tlasca.NewRunner(cfg.Algorithm.Params(), logger, rec)
 
-	// --- 1. Поиск и сортировка входных файлов ---
-	logger.Println("searching for image files...")
+	// --- 1. Поиск и подготовка входных файлов ---
+	in, cleanup, err := discoverInputs(cfg, logger, rec, runOpts.overwrite)
+	defer cleanup()
+	if err != nil {
+		return err
+	}
+	if runOpts.validate {
+		return validateFrames(logger, in.files, in.frameCfg.Width, in.frameCfg.Height, in.outputPlan)
+	}
+	// Проверка выходных файлов не исключает одновременного запуска в той же директории результатов:
+	// без --overwrite файлы публикуются без замены существующих (см. atomicfile.Protect).
+	if !runOpts.overwrite {
+		defer atomicfile.Protect(cfg.Paths.ResultsDir)()
+		defer func() {
+			if errors.Is(err, fs.ErrExist) {
+				err = fmt.Errorf("%w (the file appeared during the run, probably written by another run into the same results directory; "+
+					"use --overwrite to replace existing results)", err)
+			}
+		}()
+	}
+	if err = prepareInputs(cfg, logger, rec, bus, in); err != nil {
+		return err
+	}
 
-	// Проверяем существование директории с данными, чтобы предоставить пользователю
-	// понятную ошибку в случае неверного пути в конфиге.
-	if _, err := os.Stat(cfg.Paths.DataDir); os.IsNotExist(err) {
-		return fmt.Errorf("data directory '%s' not found", cfg.Paths.DataDir)
+	// В распределенном режиме рассчитываются только статистики участка кадра,
+	// карта строится после объединения частичных результатов.
+	if len(cfg.Partial.Tile) > 0 {
+		return runPartial(ctx, cfg, logger, runner, in.loader, in.files, in.frameRect, in.opts, in.plan)
+	}
+	// В пространственном и пространственно-временном режимах и со скользящим окном
+	// рассчитывается ряд карт (по кадру, группе кадров или положению окна), при чередовании
+	// состояний освещения и для пар каналов поляризации - карта каждого состояния (канала)
+	// по его кадрам; остальные этапы (анализ областей, диагностика, иллюстрации) не выполняются.
+	mapsRun := seriesRun{
+		startedAt: startedAt,
+		bitDepth:  in.bitDepth,
+		area:      in.area,
+		outputs:   in.outputs,
+		plan:      in.outputPlan,
+		bus:       bus,
+		warnings:  warnings,
+		denoiser:  setup.denoiser,
+		template:  setup.template,
+		onReport:  runOpts.onReport,
+	}
+	if length, _ := mapSeries(cfg); length > 0 {
+		return runSeries(ctx, cfg, logger, rec, runner, in.loader, setup.normalizer, in.files, in.opts, mapsRun)
 	}
-	files, err := filepath.Glob(filepath.Join(cfg.Paths.DataDir, "*.png"))
+	if cfg.Input.Interleave != "" {
+		return runInterleaved(ctx, cfg, logger, rec, runner, in.loader, setup.normalizer, in.files, in.opts, in.plan.ChunkSize, mapsRun)
+	}
+	if cfg.Input.CoPolarizedSuffix != "" {
+		return runPolarization(ctx, cfg, logger, rec, runner, in.loader, setup.normalizer, in.files, in.opts, in.plan.ChunkSize, mapsRun)
+	}
+
+	// --- 2-3. Загрузка изображений и выполнение алгоритма tLASCA ---
+	comp, err := computeResult(ctx, cfg, logger, rec, bus, runner, setup, in)
 	if err != nil {
-		return fmt.Errorf("invalid file pattern: %w", err)
+		return err
 	}
-	if len(files) == 0 {
-		return fmt.Errorf("no png files found in '%s'", cfg.Paths.DataDir)
+
+	// --- 4. Сохранение результата и диагностика ---
+	logger.Println("saving result...")
+	stopSave := rec.Start("save")
+	saved, err := saveResults(cfg, logger, bus, setup, in, comp, startedAt)
+	if err != nil {
+		return err
 	}
+	diag, err := runDiagnostics(ctx, cfg, logger, rec, bus, runner, in, comp)
+	if err != nil {
+		return err
+	}
+	outputs := slices.Concat(saved.outputs, diag.outputs)
+	stopSave()
+	logger.Printf("image saving completed: %s\n", saved.path)
 
-	// Сортируем файлы по числовому значению в имени, чтобы гарантировать
-	// правильный временной порядок кадров для анализа (Sort files using natural order).
-	sort.SliceStable(files, func(i, j int) bool {
-		numI, err := imageutils.ExtractNumber(files[i])
-		if err != nil {
-			// Некорректный формат имени файла - это фатальная ошибка в подготовке данных.
-			// Дальнейшее выполнение бессмысленно, поэтому вызываем панику.
-			panic(fmt.Sprintf("invalid filename format: %s -> %v", files[i], err))
+	// --- 5. Телеметрия и отчет о запуске ---
+	warnUnplanned(bus, in.outputPlan, outputs)
+	rec.LogSummary()
+	if cfg.Paths.ReportFilename != "" || setup.template != nil || runOpts.onReport != nil {
+		result := comp.result
+		rep := &report.Report{
+			StartedAt:        startedAt,
+			Duration:         time.Since(startedAt),
+			Config:           cfg,
+			Frames:           len(in.files),
+			Kernel:           tlasca.Kernel(),
+			BitDepth:         in.bitDepth,
+			QuickLook:        in.quickLook,
+			Timing:           in.timing,
+			Exposure:         in.exposure,
+			Calibration:      in.calibration,
+			Camera:           in.camera,
+			DisplayRange:     linearRange(saved.displayScale),
+			Clipping:         &saved.clipping,
+			Contrast:         report.NewMapStats(result.Contrast, result.Excluded, result.Units.Contrast),
+			Perfusion:        perfusionStats(saved.mapResult, result),
+			Convergence:      diag.convergence,
+			Focus:            diag.focus,
+			Segmentation:     saved.segmentation,
+			ContrastLimits:   saved.contrastLimits,
+			StaticScattering: saved.staticScattering,
+			Trend:            saved.trend,
+			Biospeckle:       saved.biospeckle,
+			DerivedMaps:      saved.derived,
+			Units:            mapUnits(cfg, result, setup.derivedMaps),
+			FrameCorrelation: diag.frameCorrelation,
+			Correlations:     diag.correlations,
+			Vasomotion:       diag.vasomotion,
+			Skipped:          comp.skipped,
+			Adjustments:      in.plan.Adjustments,
+			Warnings:         warnings.Warnings(),
+			Outputs:          outputs,
+			Stages:           rec.Stages(),
 		}
-		numJ, err := imageutils.ExtractNumber(files[j])
-		if err != nil {
-			panic(fmt.Sprintf("invalid filename format: %s -> %v", files[j], err))
+		if err = saveRunReport(cfg, logger, rep, setup.template); err != nil {
+			return err
 		}
-		return numI < numJ
-	})
-	logger.Printf("found and sorted %d files.\n", len(files))
+		if runOpts.onReport != nil {
+			runOpts.onReport(rep)
+		}
+	}
+
+	return nil
+}
+
+// logEffectiveConfig выводит в лог параметры итоговой конфигурации и их источники.
+func logEffectiveConfig(logger *log.Logger, settings []config.Setting) {
+	logger.Println("effective configuration:")
+	for _, s := range settings {
+		logger.Printf("  %s = %s (%s)\n", s.Key, s.Value, s.Source)
+	}
+}
 
-	// --- 2. Загрузка и подготовка изображений ---
-	logger.Println("loading and converting images...")
-	grayImages, err := loadAndProcessImages(files)
+// saveEffectiveConfig сохраняет итоговую конфигурацию с источниками значений
+// в директорию результатов и возвращает путь к файлу.
+func saveEffectiveConfig(cfg *config.Config) (string, error) {
+	effective, err := report.NewEffectiveConfig(cfg)
 	if err != nil {
-		// Ошибка на этом этапе фатальна, так как алгоритму требуется полная последовательность.
-		return err
+		return "", fmt.Errorf("error resolving config: %w", err)
+	}
+	if err = os.MkdirAll(cfg.Paths.ResultsDir, 0755); err != nil {
+		return "", fmt.Errorf("error creating results directory '%s': %w", cfg.Paths.ResultsDir, err)
 	}
+	path := filepath.Join(cfg.Paths.ResultsDir, cfg.Paths.EffectiveConfigFilename)
+	if err = effective.Save(path); err != nil {
+		return "", fmt.Errorf("error saving effective config to '%s': %w", path, err)
+	}
+	return path, nil
+}
 
-	// --- 3. Выполнение алгоритма tLASCA ---
-	changeMap := runner.Run(grayImages)
+// templateReportFilename возвращает имя файла отчета по шаблону paths.report_template
+// (пустую строку, если шаблон не задан).
+func templateReportFilename(cfg *config.Config) string {
+	switch {
+	case cfg.Paths.ReportTemplate == "":
+		return ""
+	case cfg.Paths.TemplateReportFilename != "":
+		return cfg.Paths.TemplateReportFilename
+	}
+	return strings.TrimSuffix(filepath.Base(cfg.Paths.ReportTemplate), ".tmpl")
+}
 
-	// --- 4. Сохранение результата ---
-	logger.Println("saving result...")
-	err = os.MkdirAll(cfg.Paths.ResultsDir, 0755)
+// loadReportTemplate разбирает шаблон отчета paths.report_template до расчета, чтобы
+// ошибки шаблона обнаруживались сразу. Возвращает nil, если шаблон не задан.
+func loadReportTemplate(cfg *config.Config) (*report.Template, error) {
+	name := templateReportFilename(cfg)
+	if name == "" {
+		return nil, nil
+	}
+	path := filepath.Join(cfg.Paths.ResultsDir, name)
+	if filepath.Clean(path) == filepath.Clean(cfg.Paths.ReportTemplate) {
+		return nil, fmt.Errorf("template report '%s' would replace its template; set paths.template_report_filename", path)
+	}
+	if name == cfg.Paths.ReportFilename {
+		return nil, fmt.Errorf("template report '%s' would replace the run report; set paths.template_report_filename", path)
+	}
+	ext := strings.ToLower(filepath.Ext(name))
+	tmpl, err := report.LoadTemplate(cfg.Paths.ReportTemplate, ext == ".html" || ext == ".htm")
 	if err != nil {
-		return fmt.Errorf("error creating results directory '%s': %w", cfg.Paths.ResultsDir, err)
+		return nil, fmt.Errorf("invalid report template '%s': %w", cfg.Paths.ReportTemplate, err)
+	}
+	return tmpl, nil
+}
+
+// saveRunReport сохраняет отчет о запуске rep (если задан paths.report_filename)
+// и отчет по шаблону tmpl (если он не nil), который добавляется к выходным файлам отчета.
+func saveRunReport(cfg *config.Config, logger *log.Logger, rep *report.Report, tmpl *report.Template) error {
+	var templatePath string
+	if tmpl != nil {
+		templatePath = filepath.Join(cfg.Paths.ResultsDir, templateReportFilename(cfg))
+		rep.Outputs = append(rep.Outputs, templatePath)
+	}
+	if cfg.Paths.ReportFilename != "" {
+		reportPath := filepath.Join(cfg.Paths.ResultsDir, cfg.Paths.ReportFilename)
+		if err := rep.Save(reportPath); err != nil {
+			return fmt.Errorf("error saving run report to '%s': %w", reportPath, err)
+		}
+		logger.Printf("run report saved: %s\n", reportPath)
+	}
+	if tmpl != nil {
+		if err := tmpl.Save(templatePath, rep); err != nil {
+			return fmt.Errorf("error rendering report template to '%s': %w", templatePath, err)
+		}
+		logger.Printf("template report saved: %s\n", templatePath)
+	}
+	return nil
+}
+
+// saveAlignmentReport сохраняет оценки смещений кадров (CSV) и график дрейфа (PNG)
+// в директорию результатов. Возвращает пути к сохраненным файлам и текст предупреждения,
+// если накопленный дрейф превышает заданную долю размера окна (иначе пустую строку).
+func saveAlignmentReport(cfg *config.Config, shifts []registration.Shift, files []string, times []float64) ([]string, string, error) {
+	threshold := cfg.Registration.DriftWarnFraction * float64(cfg.Algorithm.WindowSize)
+	var outputs []string
+
+	if cfg.Registration.ShiftsFilename != "" {
+		path := filepath.Join(cfg.Paths.ResultsDir, cfg.Registration.ShiftsFilename)
+		if err := registration.WriteCSV(path, shifts, files, times); err != nil {
+			return nil, "", fmt.Errorf("error saving frame shifts to '%s': %w", path, err)
+		}
+		outputs = append(outputs, path)
+	}
+	if cfg.Registration.DriftPlotFilename != "" {
+		path := filepath.Join(cfg.Paths.ResultsDir, cfg.Registration.DriftPlotFilename)
+		if err := imageutils.SavePNG(path, registration.DriftPlot(shifts, threshold)); err != nil {
+			return nil, "", fmt.Errorf("error saving drift plot to '%s': %w", path, err)
+		}
+		outputs = append(outputs, path)
 	}
 
-	newPath := filepath.Join(cfg.Paths.ResultsDir, cfg.Paths.OutputFilename)
-	if err = imageutils.SaveImage(newPath, changeMap); err != nil {
-		return fmt.Errorf("error saving result image to '%s': %w", newPath, err)
+	var warning string
+	if worst := registration.MaxDrift(shifts); worst.Magnitude() > threshold {
+		warning = fmt.Sprintf("accumulated drift %.1f px at frame %d (dx=%d, dy=%d) exceeds %.2f of window size (%.1f px)",
+			worst.Magnitude(), worst.Frame, worst.DX, worst.DY, cfg.Registration.DriftWarnFraction, threshold)
 	}
-	logger.Printf("image saving completed: %s\n", newPath)
+	return outputs, warning, nil
+}
 
+// sortFrames сортирует файлы кадров files по номеру в имени (см. frameNumber), чтобы
+// гарантировать правильный временной порядок кадров для анализа; кадры пары каналов
+// поляризации имеют один номер и упорядочиваются по каналу. Номера извлекаются для всех
+// файлов до сортировки: возвращает ошибку со списком файлов, из имен которых номер
+// не извлекается, или с первой парой файлов с одинаковым номером.
+func sortFrames(input config.InputConfig, files []string) error {
+	type key struct {
+		number  int
+		channel string
+	}
+	keys := make(map[string]key, len(files))
+	var invalid []string
+	for _, file := range files {
+		number, channel, err := frameNumber(input, file)
+		if err != nil {
+			invalid = append(invalid, "'"+filepath.Base(file)+"'")
+			continue
+		}
+		keys[file] = key{number, channel}
+	}
+	if len(invalid) > 0 {
+		const shown = 5
+		names := strings.Join(invalid[:min(len(invalid), shown)], ", ")
+		if len(invalid) > shown {
+			names += fmt.Sprintf(" and %d more", len(invalid)-shown)
+		}
+		return fmt.Errorf("cannot extract a frame number from %d file names: %s; frame file names must end with the frame number "+
+			"(e.g. '0001.png' or 'img_0001.tif'): narrow patterns or add exclude patterns", len(invalid), names)
+	}
+
+	sort.SliceStable(files, func(i, j int) bool {
+		a, b := keys[files[i]], keys[files[j]]
+		if a.number != b.number {
+			return a.number < b.number
+		}
+		return a.channel < b.channel
+	})
+	// В папках со смешанными расширениями один номер кадра может встречаться
+	// в нескольких файлах ("1.png" и "1.tif") - порядок таких кадров не определен.
+	for i := 1; i < len(files); i++ {
+		if keys[files[i-1]] == keys[files[i]] {
+			return fmt.Errorf("frame number %d is used by both '%s' and '%s'; narrow patterns or add exclude patterns",
+				keys[files[i]].number, filepath.Base(files[i-1]), filepath.Base(files[i]))
+		}
+	}
 	return nil
 }
 
-// loadAndProcessImages обрабатывает список путей к файлам, загружая и конвертируя каждое изображение.
-// Функция возвращает ошибку, если хотя бы один из файлов не может быть обработан,
-// так как для алгоритма tLASCA важна целостность и порядок последовательности.
-func loadAndProcessImages(paths []string) ([]*image.Gray, error) {
-	grayImages := make([]*image.Gray, 0, len(paths))
-	for _, filePath := range paths {
-		img, err := imageutils.LoadImage(filePath)
+// frameNumbers возвращает номера кадров (числа из имен файлов) в порядке последовательности.
+// Номер кадра связывает файл с попадровыми метаданными (временные метки, экспозиции);
+// кадры пары каналов поляризации имеют один номер (см. frameNumber).
+func frameNumbers(input config.InputConfig, files []string) ([]int, error) {
+	frames := make([]int, len(files))
+	for i, file := range files {
+		number, _, err := frameNumber(input, file)
 		if err != nil {
-			return nil, fmt.Errorf("failed to load image '%s': %w", filePath, err)
+			return nil, fmt.Errorf("invalid filename format: %s -> %w", file, err)
 		}
-		grayImg := imageutils.ConvertToGray(img)
-		grayImages = append(grayImages, grayImg)
+		frames[i] = number
+	}
+	return frames, nil
+}
+
+// loadExposures возвращает экспозиции кадров files из источника input.exposure_source
+// (CSV-файл exposure_file или теги TIFF) либо nil, если нормировка по экспозиции не задана.
+func loadExposures(cfg *config.Config, files []string) ([]float64, error) {
+	if cfg.Input.ExposureSource == "tiff" {
+		exposures, err := exposure.FromTIFF(files)
+		if err != nil {
+			return nil, fmt.Errorf("error reading exposures from TIFF tags: %w", err)
+		}
+		return exposures, nil
+	}
+	if cfg.Paths.ExposureFile == "" {
+		return nil, nil
+	}
+	frames, err := frameNumbers(cfg.Input, files)
+	if err != nil {
+		return nil, err
+	}
+	exposures, err := exposure.Load(cfg.Paths.ExposureFile, frames)
+	if err != nil {
+		return nil, fmt.Errorf("error loading exposures '%s': %w", cfg.Paths.ExposureFile, err)
+	}
+	return exposures, nil
+}
+
+// sampleFrames загружает до count кадров files, равномерно распределенных по записи от первого
+// до последнего, для оценки разрядности данных. Первый кадр first уже декодирован.
+func sampleFrames(files []string, first frame.Frame, count int) ([]frame.Frame, error) {
+	samples := []frame.Frame{first}
+	count = min(count, len(files))
+	for k := 1; k < count; k++ {
+		path := files[k*(len(files)-1)/(count-1)]
+		img, err := imageutils.LoadImage(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load image '%s': %w", path, err)
+		}
+		f, _ := imageutils.ConvertToFrame(img)
+		samples = append(samples, f)
+	}
+	return samples, nil
+}
+
+// subsample возвращает каждый stride-й элемент paths, начиная с первого.
+func subsample(paths []string, stride int) []string {
+	result := make([]string, 0, (len(paths)+stride-1)/stride)
+	for i := 0; i < len(paths); i += stride {
+		result = append(result, paths[i])
+	}
+	return result
+}
+
+// linearRange возвращает границы шкалы отображения scale, если она линейна, иначе nil.
+func linearRange(scale render.Scale) []float64 {
+	if r, ok := scale.(render.Range); ok {
+		return []float64{r.Min, r.Max}
+	}
+	return nil
+}
+
+// resultImage возвращает изображение итоговой карты разрядности bitDepth: 8-битное
+// изображение mapImage или 16-битное изображение карты по той же шкале scale
+// с исключенными положениями уровня background.
+func resultImage(result *tlasca.Result, scale render.Scale, mapImage *image.Gray, bitDepth int, background float64) image.Image {
+	if bitDepth == 16 {
+		return render.FillBackground(render.Gray16(result, scale), result.Excluded, background)
+	}
+	return mapImage
+}
+
+// pngOutput - изображение, сохраняемое в PNG-файл path; what описывает его для сообщений об ошибках.
+type pngOutput struct {
+	path string
+	what string
+	img  image.Image
+}
+
+// fileOutput - выходной файл path, записываемый функцией write; what описывает его
+// для сообщений об ошибках.
+type fileOutput struct {
+	path  string
+	what  string
+	write func(path string) error
+}
+
+// saveOutputs параллельно записывает выходные файлы outputs. Все файлы строятся
+// по одному результату расчета, поэтому для получения результата в другом формате
+// достаточно добавить выход в конфигурацию, а не повторять расчет.
+func saveOutputs(outputs []fileOutput) error {
+	return parallel.Each(len(outputs), func(i int) error {
+		o := outputs[i]
+		if err := o.write(o.path); err != nil {
+			return fmt.Errorf("error saving %s to '%s': %w", o.what, o.path, err)
+		}
+		return nil
+	})
+}
+
+// pngFiles возвращает выходные файлы изображений outputs.
+func pngFiles(outputs []pngOutput) []fileOutput {
+	files := make([]fileOutput, len(outputs))
+	for i, o := range outputs {
+		files[i] = fileOutput{path: o.path, what: o.what, write: func(path string) error {
+			return imageutils.SavePNG(path, o.img)
+		}}
+	}
+	return files
+}
+
+// savePNGs параллельно кодирует и сохраняет изображения outputs.
+func savePNGs(outputs []pngOutput) error {
+	return saveOutputs(pngFiles(outputs))
+}
+
+// matrixOutputs возвращает выходные файлы карты без квантования values размером
+// width x height: float TIFF с описанием description, CSV и NPY (заданные в output).
+// prefix дополняет описание файлов в сообщениях об ошибках (например, "partial ").
+func matrixOutputs(cfg *config.Config, values []float64, width, height int, description, prefix string) []fileOutput {
+	var outputs []fileOutput
+	if cfg.Output.FloatFilename != "" {
+		outputs = append(outputs, fileOutput{
+			path: filepath.Join(cfg.Paths.ResultsDir, cfg.Output.FloatFilename),
+			what: prefix + "float contrast map",
+			write: func(path string) error {
+				return imageutils.SaveFloatTIFF(path, values, width, height, description)
+			},
+		})
+	}
+	if cfg.Output.CSVFilename != "" {
+		outputs = append(outputs, fileOutput{
+			path: filepath.Join(cfg.Paths.ResultsDir, cfg.Output.CSVFilename),
+			what: prefix + "contrast matrix",
+			write: func(path string) error {
+				return imageutils.SaveMatrixCSV(path, values, width, height)
+			},
+		})
+	}
+	if cfg.Output.NPYFilename != "" {
+		outputs = append(outputs, fileOutput{
+			path: filepath.Join(cfg.Paths.ResultsDir, cfg.Output.NPYFilename),
+			what: prefix + "contrast array",
+			write: func(path string) error {
+				return imageutils.SaveNPY(path, values, width, height)
+			},
+		})
+	}
+	return outputs
+}
+
+// floatContrast возвращает значения карты контраста для сохранения без квантования:
+// исключенные из расчета положения заменяются на NaN.
+func floatContrast(res *tlasca.Result) []float64 {
+	if res.Excluded == nil {
+		return res.Contrast
+	}
+	values := slices.Clone(res.Contrast)
+	for i, excluded := range res.Excluded.Set {
+		if excluded {
+			values[i] = math.NaN()
+		}
+	}
+	return values
+}
+
+// logKernel выводит в лог выбранную реализацию ядер статистик, доступные реализации
+// и обнаруженные возможности процессора.
+func logKernel(kernel string, logger *log.Logger) {
+	features := "none detected"
+	if f := tlasca.CPUFeatures(); len(f) > 0 {
+		features = strings.Join(f, ", ")
+	}
+	logger.Printf("statistics kernel: %s (available: %s; cpu features: %s).\n",
+		kernel, strings.Join(tlasca.Kernels(), ", "), features)
+}
+
+// applyResourceLimits понижает приоритет процесса и ограничивает чтение кадров
+// по параметрам limits и задает число рабочих горутин workers (algorithm.workers).
+// Без заданного числа горутин в cgroup с ограничением CPU оно уменьшается до числа
+// выделенных ядер. Ошибка изменения приоритета (например, на системе без
+// поддержки ionice) не прерывает запуск и выводится предупреждением.
+func applyResourceLimits(limits config.LimitsConfig, workers int, logger *log.Logger) {
+	if limits.Nice > 0 || limits.IOPriority != "" {
+		if err := priority.Lower(limits.Nice, limits.IOPriority); err != nil {
+			logger.Printf("warn: cannot lower process priority: %v\n", err)
+		} else {
+			logger.Printf("process priority lowered: nice %d, io priority '%s'.\n", limits.Nice, limits.IOPriority)
+		}
+	}
+	imageutils.SetMaxReaders(limits.MaxReaders)
+	// Число горутин задается при каждом запуске: в режиме сервера значение предыдущего
+	// запроса не должно сохраняться.
+	parallel.SetWorkers(workers)
+	if workers > 0 {
+		logger.Printf("using %d workers (%d logical CPUs).\n", workers, runtime.NumCPU())
+		return
+	}
+	if cpus, ok := safeguard.CPULimit(); ok && int(math.Ceil(cpus)) < parallel.Workers() {
+		workers := int(math.Ceil(cpus))
+		logger.Printf("cgroup cpu limit %.2g CPUs: using %d workers instead of %d.\n", cpus, workers, parallel.Workers())
+		parallel.SetWorkers(workers)
 	}
-	return grayImages, nil
 }
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/mascotmascot1/go-tlasca/internal/config"
+	"github.com/mascotmascot1/go-tlasca/internal/events"
+	"github.com/mascotmascot1/go-tlasca/internal/notify"
+	"github.com/mascotmascot1/go-tlasca/internal/report"
+)
+
+// notifyWarnings - наибольшее число предупреждений, перечисляемых в уведомлении.
+const notifyWarnings = 5
+
+// runNotifier отправляет уведомления о завершении запуска (секция notifications).
+type runNotifier struct {
+	cfg       *config.Config
+	sinks     []notify.Sink
+	startedAt time.Time
+}
+
+// newRunNotifier проверяет параметры уведомлений cfg до расчета. Возвращает nil,
+// если ни один способ отправки не настроен.
+func newRunNotifier(cfg *config.Config, startedAt time.Time) (*runNotifier, error) {
+	sinks, err := notify.New(cfg.Notifications)
+	if err != nil {
+		return nil, fmt.Errorf("invalid notifications config: %w", err)
+	}
+	if len(sinks) == 0 {
+		return nil, nil
+	}
+	return &runNotifier{cfg: cfg, sinks: sinks, startedAt: startedAt}, nil
+}
+
+// String возвращает перечень способов отправки для логов.
+func (n *runNotifier) String() string {
+	names := make([]string, len(n.sinks))
+	for i, s := range n.sinks {
+		names[i] = s.String()
+	}
+	return strings.Join(names, "; ")
+}
+
+// subscribe подписывает уведомления на завершение запуска (events.RunFinished) в шине bus.
+func (n *runNotifier) subscribe(bus *events.Bus, logger *log.Logger) {
+	bus.Subscribe(func(e events.Event) {
+		if e.Kind == events.RunFinished {
+			n.send(logger, e.Err)
+		}
+	})
+}
+
+// send отправляет уведомление о завершении запуска с ошибкой runErr (nil - успешно),
+// если запуск подходит под notifications.on и min_duration_s. Ошибки отправки
+// выводятся предупреждениями и не меняют итог запуска.
+func (n *runNotifier) send(logger *log.Logger, runErr error) {
+	nc := n.cfg.Notifications
+	elapsed := time.Since(n.startedAt)
+	if (runErr == nil && nc.On == "failure") || (runErr != nil && nc.On == "success") ||
+		elapsed < time.Duration(nc.MinDurationS)*time.Second {
+		return
+	}
+	msg := n.message(runErr, elapsed)
+	for _, sink := range n.sinks {
+		// Отправка не зависит от контекста запуска: о прерванном запуске тоже сообщается.
+		ctx, cancel := context.WithTimeout(context.Background(), time.Duration(nc.TimeoutS)*time.Second)
+		err := sink.Send(ctx, msg)
+		cancel()
+		if err != nil {
+			logger.Printf("warn: error sending notification (%s): %v\n", sink, err)
+			continue
+		}
+		logger.Printf("notification sent: %s\n", sink)
+	}
+}
+
+// message формирует уведомление: итог, длительность и директорию результатов, а для
+// успешного запуска - показатели отчета о запуске (если он сохранен) и сводную иллюстрацию.
+func (n *runNotifier) message(runErr error, elapsed time.Duration) notify.Message {
+	host, _ := os.Hostname()
+	elapsed = elapsed.Round(time.Second)
+	var msg notify.Message
+	var text strings.Builder
+	if runErr != nil {
+		msg.Subject = fmt.Sprintf("go-tlasca run failed after %s on %s", elapsed, host)
+		fmt.Fprintf(&text, "error: %v\n", runErr)
+	} else {
+		msg.Subject = fmt.Sprintf("go-tlasca run completed in %s on %s", elapsed, host)
+	}
+	fmt.Fprintf(&text, "results: %s\n", n.cfg.Paths.ResultsDir)
+	if runErr == nil {
+		n.summarize(&text)
+		if name := n.cfg.Output.FigureFilename; name != "" {
+			if data, err := os.ReadFile(filepath.Join(n.cfg.Paths.ResultsDir, name)); err == nil {
+				msg.Image, msg.ImageName = data, name
+			}
+		}
+	}
+	msg.Text = text.String()
+	return msg
+}
+
+// summarize дописывает в text основные показатели отчета о запуске (если он сохранен).
+func (n *runNotifier) summarize(text *strings.Builder) {
+	if n.cfg.Paths.ReportFilename == "" {
+		return
+	}
+	data, err := os.ReadFile(filepath.Join(n.cfg.Paths.ResultsDir, n.cfg.Paths.ReportFilename))
+	if err != nil {
+		return
+	}
+	var rep report.Report
+	if err = json.Unmarshal(data, &rep); err != nil {
+		return
+	}
+	fmt.Fprintf(text, "frames: %d (%d skipped)\n", rep.Frames, len(rep.Skipped))
+	if len(rep.DisplayRange) == 2 {
+		fmt.Fprintf(text, "display range: K in [%.4g, %.4g]\n", rep.DisplayRange[0], rep.DisplayRange[1])
+	}
+	if rep.Clipping != nil {
+		fmt.Fprintf(text, "clipped map pixels: %.2f%%\n", 100*rep.Clipping.Fraction())
+	}
+	for _, dm := range rep.DerivedMaps {
+		fmt.Fprintf(text, "derived map %s: mean %.4g %s\n", dm.Name, dm.Mean, dm.Unit)
+	}
+	fmt.Fprintf(text, "outputs: %d files\n", len(rep.Outputs))
+	fmt.Fprintf(text, "warnings: %d\n", len(rep.Warnings))
+	for _, w := range rep.Warnings[:min(len(rep.Warnings), notifyWarnings)] {
+		fmt.Fprintf(text, "  - %s\n", w)
+	}
+	if len(rep.Warnings) > notifyWarnings {
+		fmt.Fprintf(text, "  ... and %d more\n", len(rep.Warnings)-notifyWarnings)
+	}
+}
@@ -0,0 +1,341 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"os"
+	"path/filepath"
+
+	"github.com/mascotmascot1/go-tlasca/internal/config"
+	"github.com/mascotmascot1/go-tlasca/internal/deepzoom"
+	"github.com/mascotmascot1/go-tlasca/internal/events"
+	"github.com/mascotmascot1/go-tlasca/internal/imageutils"
+	"github.com/mascotmascot1/go-tlasca/internal/roi"
+	"github.com/mascotmascot1/go-tlasca/internal/tiff"
+	"github.com/mascotmascot1/go-tlasca/internal/worldfile"
+)
+
+const (
+	// figureMaxSize - верхняя оценка размера иллюстраций фиксированного размера
+	// (сводная иллюстрация, сравнение эпох, график дрейфа).
+	figureMaxSize = 4 << 20
+	// csvRowMaxSize - верхняя оценка размера одной строки табличных выходных файлов.
+	csvRowMaxSize = 128
+	// reportMaxSize - верхняя оценка размера отчета о запуске без попадровых списков.
+	reportMaxSize = 64 << 10
+	// worldFileMaxSize - верхняя оценка размера файла привязки.
+	worldFileMaxSize = 256
+)
+
+// plannedOutput - выходной файл (или директория тайлов), который создаст запуск,
+// и верхняя оценка его размера в байтах.
+type plannedOutput struct {
+	path string
+	size uint64
+}
+
+// plannedOutputs возвращает все файлы (и директории тайлов), которые запуск
+// с конфигурацией cfg создаст в директории результатов для последовательности
+// кадров files размером width x height. Набор определяется конфигурацией
+// и размерами данных, поэтому проверки можно выполнить до загрузки кадров.
+func plannedOutputs(cfg *config.Config, width, height int, files []string) []plannedOutput {
+	join := func(name string) string {
+		return filepath.Join(cfg.Paths.ResultsDir, name)
+	}
+	frames := len(files)
+	if len(cfg.Partial.Tile) > 0 {
+		// Участок не больше кадра; на пиксель каждой порции сохраняются mean и M2 в float64.
+		chunks := 1
+		if cfg.Algorithm.ChunkSize > 0 {
+			chunks = (frames + cfg.Algorithm.ChunkSize - 1) / cfg.Algorithm.ChunkSize
+		}
+		return []plannedOutput{{join(cfg.Partial.Filename), uint64(chunks) * uint64(width) * uint64(height) * 16}}
+	}
+
+	mapWidth := width - cfg.Algorithm.WindowSize + 1
+	mapHeight := height - cfg.Algorithm.WindowSize + 1
+	mapSize := imageutils.MaxPNGSize(mapWidth, mapHeight, 1)
+	resultSize := imageutils.MaxPNGSize(mapWidth, mapHeight, cfg.Output.ResultBitDepth/8)
+	length, _ := mapSeries(cfg)
+	if length > 0 || cfg.Input.Interleave != "" || cfg.Input.CoPolarizedSuffix != "" {
+		// Карта ряда (см. mapSeries), состояния освещения или канала поляризации и производные
+		// карты каналов с файлами привязки, итоговая конфигурация и отчет.
+		var maps []plannedOutput
+		if length > 0 {
+			for _, start := range seriesStarts(cfg, len(files)) {
+				maps = append(maps, plannedOutput{join(seriesFilename(cfg.Paths.OutputFilename, files[start])), resultSize})
+			}
+		} else if states, err := interleaveStates(cfg.Input.Interleave, frames); err == nil {
+			for _, state := range states {
+				maps = append(maps, plannedOutput{join(seriesFilename(cfg.Paths.OutputFilename, state.name)), resultSize})
+			}
+			if cfg.Output.RatioFilename != "" {
+				maps = append(maps, plannedOutput{join(cfg.Output.RatioFilename), mapSize})
+			}
+		} else if cfg.Input.Interleave == "" {
+			for _, channel := range []string{coPolarized, crossPolarized} {
+				maps = append(maps, plannedOutput{join(seriesFilename(cfg.Paths.OutputFilename, channel)), resultSize})
+			}
+			if cfg.Output.PolarizationFilename != "" {
+				maps = append(maps, plannedOutput{join(cfg.Output.PolarizationFilename), resultSize})
+			}
+			if cfg.Output.DepolarizationFilename != "" {
+				maps = append(maps, plannedOutput{join(cfg.Output.DepolarizationFilename), mapSize})
+			}
+		}
+		var outputs []plannedOutput
+		for _, m := range maps {
+			outputs = append(outputs, m)
+			if cfg.Stage.PixelSize > 0 {
+				outputs = append(outputs, plannedOutput{worldfile.SidecarPath(m.path), worldFileMaxSize})
+			}
+		}
+		for _, name := range []string{cfg.Paths.EffectiveConfigFilename, cfg.Paths.ReportFilename, templateReportFilename(cfg)} {
+			if name != "" {
+				outputs = append(outputs, plannedOutput{join(name), reportMaxSize + uint64(frames)*csvRowMaxSize})
+			}
+		}
+		return append(outputs, calibrationFiles(cfg, width, height)...)
+	}
+	planeSize := imageutils.MaxPNGSize(width, height, 2)
+
+	// Изображения, для которых при заданном положении столика записываются файлы привязки.
+	georeferenced := []plannedOutput{{join(cfg.Paths.OutputFilename), resultSize}}
+	for _, plane := range []struct {
+		name string
+		size uint64
+	}{
+		{cfg.Output.OutOfRangeMask, mapSize},
+		{cfg.Output.ColormapFilename, imageutils.MaxPNGSize(mapWidth, mapHeight, 4)},
+		{cfg.Output.OverlayFilename, imageutils.MaxPNGSize(mapWidth, mapHeight, 4)},
+		{cfg.Output.FloatFilename, tiff.MaxFloat32Size(mapWidth, mapHeight, 128)},
+		{segmentationMask(cfg), mapSize},
+		{contrastLimitsFile(cfg, cfg.ContrastLimits.MaskFilename), mapSize},
+		{contrastLimitsFile(cfg, cfg.ContrastLimits.OverlayFilename), imageutils.MaxPNGSize(mapWidth, mapHeight, 4)},
+		{staticScatteringFile(cfg, cfg.StaticScattering.StaticFilename), imageutils.MaxPNGSize(mapWidth, mapHeight, 2)},
+		{staticScatteringFile(cfg, cfg.StaticScattering.FlowFilename), imageutils.MaxPNGSize(mapWidth, mapHeight, 2)},
+		{trendFile(cfg, trendContrast, cfg.Trend.Filename), imageutils.MaxPNGSize(mapWidth, mapHeight, 2)},
+		{trendFile(cfg, trendIntensity, cfg.Trend.Filename), planeSize},
+		{biospeckleFile(cfg, cfg.Biospeckle.IMFilename), planeSize},
+		{biospeckleFile(cfg, cfg.Biospeckle.AVDFilename), planeSize},
+		{cfg.Output.MeanFilename, planeSize},
+		{cfg.Output.StdDevFilename, planeSize},
+	} {
+		if plane.name != "" {
+			georeferenced = append(georeferenced, plannedOutput{join(plane.name), plane.size})
+		}
+	}
+	for _, dm := range cfg.DerivedMaps {
+		georeferenced = append(georeferenced, plannedOutput{join(derivedFilename(dm)), imageutils.MaxPNGSize(mapWidth, mapHeight, 2)})
+	}
+	for _, q := range cfg.Output.Quantiles {
+		georeferenced = append(georeferenced, plannedOutput{join(cfg.Output.QuantileFilename(q)), planeSize})
+	}
+	outputs := append([]plannedOutput(nil), georeferenced...)
+	if cfg.Output.CSVFilename != "" {
+		outputs = append(outputs, plannedOutput{join(cfg.Output.CSVFilename), imageutils.MaxMatrixCSVSize(mapWidth, mapHeight)})
+	}
+	if cfg.Output.NPYFilename != "" {
+		outputs = append(outputs, plannedOutput{join(cfg.Output.NPYFilename), imageutils.MaxNPYSize(mapWidth, mapHeight)})
+	}
+	if name := trendFile(cfg, cfg.Trend.Signal, cfg.Trend.FloatFilename); name != "" {
+		trendWidth, trendHeight := width, height
+		if cfg.Trend.Signal == trendContrast {
+			trendWidth, trendHeight = mapWidth, mapHeight
+		}
+		outputs = append(outputs, plannedOutput{join(name), tiff.MaxFloat32Size(trendWidth, trendHeight, 128)})
+	}
+	if cfg.Output.ValidityMaskFilename != "" {
+		// Маска сохраняется только при прерывании расчета, но проверяется заранее вместе с картой.
+		outputs = append(outputs, plannedOutput{join(cfg.Output.ValidityMaskFilename), mapSize})
+	}
+	if cfg.Stage.PixelSize > 0 {
+		for _, o := range georeferenced {
+			outputs = append(outputs, plannedOutput{worldfile.SidecarPath(o.path), worldFileMaxSize})
+		}
+	}
+
+	if cfg.Output.DeepZoomName != "" {
+		pyramidSize := deepzoom.MaxSize(mapWidth, mapHeight, deepzoom.Options{
+			TileSize: cfg.Output.DeepZoomTileSize,
+			Overlap:  cfg.Output.DeepZoomOverlap,
+		})
+		outputs = append(outputs,
+			plannedOutput{join(cfg.Output.DeepZoomName + ".dzi"), 0},
+			plannedOutput{join(cfg.Output.DeepZoomName + "_files"), pyramidSize})
+	}
+
+	regions := uint64(len(cfg.Regions))
+	if cfg.Paths.RegionsFile != "" {
+		// Ошибки чтения файла областей сообщаются при построении областей.
+		shapes, _ := roi.ReadImageJ(cfg.Paths.RegionsFile)
+		regions += uint64(len(shapes))
+	}
+	if regions == 0 {
+		regions = 1
+	}
+	optional := []struct {
+		enabled bool
+		name    string
+		size    uint64
+	}{
+		{true, cfg.Output.FigureFilename, figureMaxSize},
+		{len(cfg.Compare.EpochA) > 0 || len(cfg.Compare.EpochB) > 0, cfg.Compare.FigureFilename, figureMaxSize},
+		{cfg.Diagnostics.FrameContributions, cfg.Diagnostics.ContributionsFilename, uint64(frames+1) * csvRowMaxSize},
+		{cfg.Diagnostics.Convergence, cfg.Diagnostics.ConvergenceFilename, uint64(frames) * csvRowMaxSize},
+		{cfg.Diagnostics.Convergence, cfg.Diagnostics.ConvergencePlotFilename, figureMaxSize},
+		{cfg.Diagnostics.FrameCorrelation, cfg.Diagnostics.FrameCorrelationFilename, uint64(frames) * csvRowMaxSize},
+		{cfg.Diagnostics.Focus, cfg.Diagnostics.FocusFilename, focusTiles(cfg, width, height) * csvRowMaxSize},
+		{cfg.Diagnostics.FrameCorrelation && frames <= cfg.Diagnostics.FrameCorrelationMaxFrames,
+			cfg.Diagnostics.FrameCorrelationMatrixFilename, imageutils.MaxPNGSize(frames, frames, 1)},
+		{cfg.Correlation.Enabled, cfg.Correlation.Filename,
+			(regions*(regions-1)/2*uint64(2*cfg.Correlation.MaxLag+1) + 1) * csvRowMaxSize},
+		{cfg.Vasomotion.Enabled, cfg.Vasomotion.Filename, (regions*uint64(frames/2+1) + 1) * csvRowMaxSize},
+		{cfg.Registration.Enabled, cfg.Registration.ShiftsFilename, uint64(frames+1) * csvRowMaxSize},
+		{cfg.Registration.Enabled, cfg.Registration.DriftPlotFilename, figureMaxSize},
+		{true, cfg.Paths.EffectiveConfigFilename, reportMaxSize},
+		{true, cfg.Paths.ReportFilename, reportMaxSize + uint64(frames)*csvRowMaxSize},
+		{true, templateReportFilename(cfg), reportMaxSize + uint64(frames)*csvRowMaxSize},
+	}
+	for _, o := range optional {
+		if o.enabled && o.name != "" {
+			outputs = append(outputs, plannedOutput{join(o.name), o.size})
+		}
+	}
+	return append(outputs, calibrationFiles(cfg, width, height)...)
+}
+
+// calibrationFiles возвращает файлы калибровки по мишени (модель дисторсии
+// и изображение найденных углов), если задано изображение мишени.
+func calibrationFiles(cfg *config.Config, width, height int) []plannedOutput {
+	if cfg.Paths.CalibrationImage == "" {
+		return nil
+	}
+	var outputs []plannedOutput
+	if cfg.Calibration.ModelFilename != "" {
+		outputs = append(outputs, plannedOutput{filepath.Join(cfg.Paths.ResultsDir, cfg.Calibration.ModelFilename), reportMaxSize})
+	}
+	if cfg.Calibration.CornersFilename != "" {
+		outputs = append(outputs, plannedOutput{filepath.Join(cfg.Paths.ResultsDir, cfg.Calibration.CornersFilename),
+			imageutils.MaxPNGSize(width, height, 4)})
+	}
+	return outputs
+}
+
+// totalSize возвращает суммарную оценку размера выходных файлов.
+func totalSize(outputs []plannedOutput) uint64 {
+	var total uint64
+	for _, o := range outputs {
+		total += o.size
+	}
+	return total
+}
+
+// checkOutputs проверяет, что ни один из выходных файлов еще не существует, чтобы запуск
+// не перезаписал результаты предыдущего. Проверка выполняется до начала расчета:
+// конфликт обнаруживается сразу, а не после многочасовой обработки.
+func checkOutputs(outputs []plannedOutput) error {
+	var existing []string
+	for _, o := range outputs {
+		if _, err := os.Lstat(o.path); err == nil {
+			existing = append(existing, o.path)
+		} else if !os.IsNotExist(err) {
+			return fmt.Errorf("failed to check output '%s': %w", o.path, err)
+		}
+	}
+	switch len(existing) {
+	case 0:
+		return nil
+	case 1:
+		return fmt.Errorf("output '%s' already exists; use --overwrite to replace it", existing[0])
+	default:
+		return fmt.Errorf("%d outputs already exist (first: '%s'); use --overwrite to replace them", len(existing), existing[0])
+	}
+}
+
+// warnUnplanned предупреждает о выходных файлах written, которых нет среди запланированных
+// файлов outputs (файлы директории тайлов относятся к директории). Такие файлы не проверялись
+// до расчета на конфликт с существующими и не учитывались в оценке места на диске, поэтому
+// plannedOutputs нужно дополнить. Замену существующих файлов без --overwrite при этом
+// исключает публикация без замены (см. atomicfile.Protect).
+func warnUnplanned(bus *events.Bus, outputs []plannedOutput, written []string) {
+	planned := make(map[string]bool, len(outputs))
+	for _, o := range outputs {
+		planned[filepath.Clean(o.path)] = true
+	}
+	for _, path := range written {
+		path = filepath.Clean(path)
+		covered := planned[path]
+		for dir := filepath.Dir(path); !covered && dir != filepath.Dir(dir); dir = filepath.Dir(dir) {
+			covered = planned[dir]
+		}
+		if !covered {
+			bus.Warn(fmt.Sprintf("output '%s' is missing from the planned outputs: it was not checked for conflicts and disk space before the run", path))
+		}
+	}
+}
+
+// segmentationMask возвращает имя файла маски сосудов или пустую строку, если маска не сохраняется.
+func segmentationMask(cfg *config.Config) string {
+	if !cfg.Segmentation.Enabled {
+		return ""
+	}
+	return cfg.Segmentation.MaskFilename
+}
+
+// contrastLimitsFile возвращает имя name файла проверки теоретических границ контраста
+// или пустую строку, если проверка выключена.
+func contrastLimitsFile(cfg *config.Config, name string) string {
+	if !cfg.ContrastLimits.Enabled {
+		return ""
+	}
+	return name
+}
+
+// staticScatteringFile возвращает имя name карты разделения статического и динамического
+// рассеяния или пустую строку, если оценка выключена.
+func staticScatteringFile(cfg *config.Config, name string) string {
+	if !cfg.StaticScattering.Enabled {
+		return ""
+	}
+	return name
+}
+
+// trendFile возвращает имя name файла карты тренда величины signal или пустую строку,
+// если расчет тренда выключен или рассчитывается тренд другой величины.
+func trendFile(cfg *config.Config, signal, name string) string {
+	if !cfg.Trend.Enabled || cfg.Trend.Signal != signal {
+		return ""
+	}
+	return name
+}
+
+// biospeckleFile возвращает имя name карты индекса активности биоспеклов или пустую строку,
+// если расчет индексов выключен.
+func biospeckleFile(cfg *config.Config, name string) string {
+	if !cfg.Biospeckle.Enabled {
+		return ""
+	}
+	return name
+}
+
+// focusTiles возвращает верхнюю оценку числа тайлов контроля фокусировки кадра width x height.
+func focusTiles(cfg *config.Config, width, height int) uint64 {
+	size := max(cfg.Diagnostics.FocusTileSize, 1)
+	return uint64((width+size-1)/size) * uint64((height+size-1)/size)
+}
+
+// stageTransform возвращает привязку пикселей участка кадра area к координатам столика
+// (stage) или nil, если размер пикселя не задан: пиксель (0, 0) карт в геометрии кадра
+// соответствует пикселю area.Min кадра.
+func stageTransform(cfg *config.Config, area image.Rectangle) *worldfile.Transform {
+	if cfg.Stage.PixelSize <= 0 {
+		return nil
+	}
+	t := worldfile.Transform{
+		PixelSize: cfg.Stage.PixelSize,
+		OriginX:   cfg.Stage.PositionX,
+		OriginY:   cfg.Stage.PositionY,
+	}.Offset(float64(area.Min.X), float64(area.Min.Y))
+	return &t
+}
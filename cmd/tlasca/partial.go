@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/mascotmascot1/go-tlasca/internal/config"
+	"github.com/mascotmascot1/go-tlasca/internal/roi"
+	"github.com/mascotmascot1/go-tlasca/internal/safeguard"
+	"github.com/mascotmascot1/go-tlasca/pkg/frame"
+	"github.com/mascotmascot1/go-tlasca/pkg/tlasca"
+)
+
+// runPartial рассчитывает частичный результат распределенного режима (участок кадра
+// и диапазон кадров из cfg.Partial) и сохраняет его в директорию результатов.
+// Порции кадров задаются только algorithm.chunk_size: размер, подобранный по памяти
+// конкретной машины (plan), сделал бы результат зависимым от исполнителя.
+func runPartial(ctx context.Context, cfg *config.Config, logger *log.Logger, runner *tlasca.Runner, loader *frameLoader, files []string,
+	frameRect image.Rectangle, opts tlasca.Options, plan safeguard.Plan) error {
+	chunkSize := cfg.Algorithm.ChunkSize
+	if plan.ChunkSize != chunkSize {
+		return fmt.Errorf("partial run needs chunk_size=%d to fit in memory, but chunk sizes must match on all workers; "+
+			"set algorithm.chunk_size explicitly for all workers and the reference run", plan.ChunkSize)
+	}
+	tile, err := roi.Parse(cfg.Partial.Tile, frameRect)
+	if err != nil {
+		return fmt.Errorf("invalid partial tile: %w", err)
+	}
+	first, last := 1, len(files)
+	if len(cfg.Partial.Frames) > 0 {
+		if len(cfg.Partial.Frames) != 2 {
+			return fmt.Errorf("partial frames must be [first, last], got %v", cfg.Partial.Frames)
+		}
+		first, last = cfg.Partial.Frames[0], cfg.Partial.Frames[1]
+		if first < 1 || last > len(files) || last-first+1 < 2 {
+			return fmt.Errorf("partial frames [%d, %d] must contain at least 2 frames within 1..%d", first, last, len(files))
+		}
+		// Опорный кадр совмещения - первый кадр, прочитанный исполнителем.
+		if cfg.Registration.Enabled && first != 1 {
+			return fmt.Errorf("partial frames [%d, %d] with registration enabled: workers must start at frame 1 to share the reference frame", first, last)
+		}
+		if (first > 1 || last < len(files)) && chunkSize <= 0 {
+			return fmt.Errorf("partial frames [%d, %d] require algorithm chunk_size > 0: frame ranges are merged chunk by chunk", first, last)
+		}
+	}
+
+	partFiles := files[first-1 : last]
+	partOpts := opts
+	if opts.Gains != nil {
+		partOpts.Gains = opts.Gains[first-1 : last]
+	}
+	part, err := runner.RunPartial(ctx, tile, first-1, len(partFiles), chunkSize, func(start, end int) ([]frame.Frame, error) {
+		return loader.load(first-1+start, partFiles[start:end])
+	}, partOpts)
+	if err != nil {
+		return err
+	}
+	if len(loader.skippedFrames) > 0 {
+		logger.Printf("warn: %d unreadable frames skipped in frames %d-%d\n", len(loader.skippedFrames), first, last)
+	}
+
+	if err = os.MkdirAll(cfg.Paths.ResultsDir, 0755); err != nil {
+		return fmt.Errorf("error creating results directory '%s': %w", cfg.Paths.ResultsDir, err)
+	}
+	path := filepath.Join(cfg.Paths.ResultsDir, cfg.Partial.Filename)
+	if err = tlasca.WritePartial(path, part); err != nil {
+		return fmt.Errorf("error saving partial result to '%s': %w", path, err)
+	}
+	logger.Printf("partial result saved: %s\n", path)
+	return nil
+}
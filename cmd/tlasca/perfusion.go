@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/mascotmascot1/go-tlasca/internal/config"
+	"github.com/mascotmascot1/go-tlasca/internal/figure"
+	"github.com/mascotmascot1/go-tlasca/internal/report"
+	"github.com/mascotmascot1/go-tlasca/pkg/mask"
+	"github.com/mascotmascot1/go-tlasca/pkg/tlasca"
+	"github.com/mascotmascot1/go-tlasca/pkg/units"
+)
+
+// Величины итоговой карты (algorithm.output).
+const (
+	outputContrast  = "contrast"
+	outputPerfusion = "perfusion"
+)
+
+// checkOutputQuantity проверяет величину итоговой карты algorithm.output и экспозицию
+// для индекса кровотока. Возвращает предупреждение (пустая строка, если его нет), если
+// шкала отображения fixed задана в единицах контраста, а выводится индекс кровотока.
+func checkOutputQuantity(cfg *config.Config) (string, error) {
+	switch cfg.Algorithm.Output {
+	case "", outputContrast:
+		return "", nil
+	case outputPerfusion:
+	default:
+		return "", fmt.Errorf("unknown algorithm output '%s', expected '%s' or '%s'", cfg.Algorithm.Output, outputContrast, outputPerfusion)
+	}
+	if cfg.Algorithm.ExposureTime < 0 || math.IsNaN(cfg.Algorithm.ExposureTime) {
+		return "", fmt.Errorf("algorithm exposure_time must be non-negative, got %g", cfg.Algorithm.ExposureTime)
+	}
+	if (cfg.Output.Normalization == "" || cfg.Output.Normalization == "fixed") && cfg.Output.ContrastMax <= 1 {
+		return fmt.Sprintf("output display range [%g, %g] looks like a contrast range, but the map shows the flow index 1/K² (usually above 1); "+
+			"set output.contrast_min/contrast_max in flow index units or use another normalization",
+			cfg.Output.ContrastMin, cfg.Output.ContrastMax), nil
+	}
+	return "", nil
+}
+
+// outputMap возвращает итоговую карту для сохранения (algorithm.output): саму карту контраста
+// result или ее копию, в которой контраст K заменен индексом кровотока 1/K² (в произвольных
+// единицах) или 1/(2T·K²) (в 1/s при заданной экспозиции T). Положения, где индекс не определен
+// (нулевой или NaN контраст), отмечаются в копии как исключенные вместе с исключенными
+// положениями result и, как они, получают значение 0: на изображении они выводятся фоном,
+// в файлах без квантования - NaN, а в статистики не попадают. Остальные поля копии общие с result.
+func outputMap(cfg *config.Config, result *tlasca.Result) *tlasca.Result {
+	if cfg.Algorithm.Output != outputPerfusion {
+		return result
+	}
+	out := *result
+	out.Contrast = figure.FlowIndex(result)
+	out.Units.Contrast = units.Arbitrary
+	divisor := 1.0
+	if t := cfg.Algorithm.ExposureTime / 1000; t > 0 {
+		divisor = 2 * t
+		out.Units.Contrast = units.PerSecond
+	}
+	var invalid *mask.Mask
+	for i, v := range out.Contrast {
+		if !math.IsNaN(v) {
+			out.Contrast[i] = v / divisor
+			continue
+		}
+		out.Contrast[i] = 0
+		if result.Excluded != nil && result.Excluded.Set[i] {
+			continue
+		}
+		if invalid == nil {
+			invalid = &mask.Mask{Width: result.Width, Height: result.Height, Set: make([]bool, len(out.Contrast))}
+		}
+		invalid.Set[i] = true
+	}
+	if invalid != nil {
+		// Объединение записывается в invalid: маска result используется картой контраста.
+		out.Excluded = mask.Union(invalid, result.Excluded)
+	}
+	return &out
+}
+
+// mapQuantity возвращает название величины итоговой карты для сообщений и описаний файлов.
+func mapQuantity(cfg *config.Config) string {
+	if cfg.Algorithm.Output == outputPerfusion {
+		if cfg.Algorithm.ExposureTime > 0 {
+			return "flow index 1/(2TK^2)"
+		}
+		return "flow index 1/K^2"
+	}
+	return "speckle contrast K"
+}
+
+// perfusionStats возвращает статистики индекса кровотока итоговой карты mapResult для отчета
+// или nil, если итоговая карта - сама карта контраста result.
+func perfusionStats(mapResult, result *tlasca.Result) *report.MapStats {
+	if mapResult == result {
+		return nil
+	}
+	return report.NewMapStats(mapResult.Contrast, mapResult.Excluded, mapResult.Units.Contrast)
+}
@@ -0,0 +1,1002 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/mascotmascot1/go-tlasca/internal/biospeckle"
+	"github.com/mascotmascot1/go-tlasca/internal/calibration"
+	"github.com/mascotmascot1/go-tlasca/internal/camera"
+	"github.com/mascotmascot1/go-tlasca/internal/config"
+	"github.com/mascotmascot1/go-tlasca/internal/decimate"
+	"github.com/mascotmascot1/go-tlasca/internal/deepzoom"
+	"github.com/mascotmascot1/go-tlasca/internal/denoise"
+	"github.com/mascotmascot1/go-tlasca/internal/events"
+	"github.com/mascotmascot1/go-tlasca/internal/exposure"
+	"github.com/mascotmascot1/go-tlasca/internal/figure"
+	"github.com/mascotmascot1/go-tlasca/internal/imageutils"
+	"github.com/mascotmascot1/go-tlasca/internal/pathutil"
+	"github.com/mascotmascot1/go-tlasca/internal/priority"
+	"github.com/mascotmascot1/go-tlasca/internal/registration"
+	"github.com/mascotmascot1/go-tlasca/internal/render"
+	"github.com/mascotmascot1/go-tlasca/internal/report"
+	"github.com/mascotmascot1/go-tlasca/internal/roi"
+	"github.com/mascotmascot1/go-tlasca/internal/safeguard"
+	"github.com/mascotmascot1/go-tlasca/internal/segment"
+	"github.com/mascotmascot1/go-tlasca/internal/speckle"
+	"github.com/mascotmascot1/go-tlasca/internal/telemetry"
+	"github.com/mascotmascot1/go-tlasca/internal/timestamps"
+	"github.com/mascotmascot1/go-tlasca/internal/worldfile"
+	"github.com/mascotmascot1/go-tlasca/pkg/frame"
+	"github.com/mascotmascot1/go-tlasca/pkg/mask"
+	"github.com/mascotmascot1/go-tlasca/pkg/tlasca"
+	"github.com/mascotmascot1/go-tlasca/pkg/units"
+)
+
+// runSetup - проверенная конфигурация запуска и построенные по ней объекты (см. setupRun).
+type runSetup struct {
+	cfg        *config.Config
+	normalizer render.Normalizer
+	colormap   *render.Colormap
+	// denoiser - фильтр подавления шума карты (nil - без фильтрации).
+	denoiser    denoise.Filter
+	derivedMaps []derivedMap
+	// template - шаблон отчета о запуске (nil - без отчета по шаблону).
+	template *report.Template
+}
+
+// setupRun загружает и проверяет конфигурацию запуска до поиска входных файлов:
+// ошибки настроек обнаруживаются сразу, без обращения к данным. Вне режима проверки
+// (runOpts.validate) подписывает уведомления на шину событий bus и применяет ограничения
+// ресурсов и реализацию ядра расчета.
+func setupRun(logger *log.Logger, bus *events.Bus, runOpts runOptions, startedAt time.Time) (*runSetup, error) {
+	// Загружаем конфигурацию.
+	path := runOpts.config
+	if path == "" {
+		path = configPath
+	}
+	cfg, err := config.Load(path, runOpts.settings, logger)
+	if err != nil {
+		return nil, fmt.Errorf("error loading config: %w", err)
+	}
+	// Итоговая конфигурация с источником каждого значения выводится в лог,
+	// чтобы ошибку в настройках можно было найти по логу запуска.
+	settings, err := cfg.Effective()
+	if err != nil {
+		return nil, fmt.Errorf("error resolving config: %w", err)
+	}
+	logEffectiveConfig(logger, settings)
+
+	normalizer, err := render.NewNormalizer(cfg.Output)
+	if err != nil {
+		return nil, fmt.Errorf("invalid output normalization: %w", err)
+	}
+	outputWarning, err := checkOutputQuantity(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if outputWarning != "" {
+		bus.Warn(outputWarning)
+	}
+	denoiser, err := denoise.New(cfg.Denoise)
+	if err != nil {
+		return nil, fmt.Errorf("invalid denoise config: %w", err)
+	}
+	if err = cfg.Algorithm.Params().Validate(); err != nil {
+		return nil, fmt.Errorf("invalid algorithm config: %w", err)
+	}
+	derivedMaps, err := parseDerivedMaps(cfg)
+	if err != nil {
+		return nil, err
+	}
+	reportTemplate, err := loadReportTemplate(cfg)
+	if err != nil {
+		return nil, err
+	}
+	notifier, err := newRunNotifier(cfg, startedAt)
+	if err != nil {
+		return nil, err
+	}
+	if notifier != nil && !runOpts.validate {
+		logger.Printf("notifications: %s.\n", notifier)
+		notifier.subscribe(bus, logger)
+	}
+	if length, _ := mapSeries(cfg); length > 0 && len(cfg.Partial.Tile) > 0 {
+		return nil, fmt.Errorf("partial results are not supported for a series of contrast maps")
+	}
+	if cfg.Input.Interleave != "" {
+		if length, _ := mapSeries(cfg); length > 0 {
+			return nil, fmt.Errorf("input interleave is supported only for temporal contrast without a sliding window")
+		}
+		if len(cfg.Partial.Tile) > 0 {
+			return nil, fmt.Errorf("partial results are not supported for interleaved illumination states")
+		}
+		if cfg.Output.RatioMax <= 0 {
+			return nil, fmt.Errorf("invalid output ratio_max %g, expected a positive value", cfg.Output.RatioMax)
+		}
+	}
+	if cfg.Input.CoPolarizedSuffix != "" || cfg.Input.CrossPolarizedSuffix != "" {
+		switch {
+		case cfg.Input.CoPolarizedSuffix == "" || cfg.Input.CrossPolarizedSuffix == "":
+			return nil, fmt.Errorf("input co_polarized_suffix and cross_polarized_suffix must be set together")
+		case cfg.Input.CoPolarizedSuffix == cfg.Input.CrossPolarizedSuffix:
+			return nil, fmt.Errorf("input co_polarized_suffix and cross_polarized_suffix must differ")
+		case cfg.Input.Interleave != "":
+			return nil, fmt.Errorf("input interleave is not supported for polarization channel pairs")
+		}
+		if length, _ := mapSeries(cfg); length > 0 {
+			return nil, fmt.Errorf("polarization channel pairs are supported only for temporal contrast without a sliding window")
+		}
+		if len(cfg.Partial.Tile) > 0 {
+			return nil, fmt.Errorf("partial results are not supported for polarization channel pairs")
+		}
+	}
+	switch cfg.Input.ExposureSource {
+	case "file":
+	case "tiff":
+		if cfg.Paths.ExposureFile != "" {
+			return nil, fmt.Errorf("input exposure_source 'tiff' conflicts with paths.exposure_file, set only one of them")
+		}
+	default:
+		return nil, fmt.Errorf("unknown input exposure_source '%s', expected 'file' or 'tiff'", cfg.Input.ExposureSource)
+	}
+	if cfg.Input.PlaybackFPS < 0 {
+		return nil, fmt.Errorf("invalid input playback_fps %g, expected 0 (no pacing) or a positive frame rate", cfg.Input.PlaybackFPS)
+	}
+	if cfg.Input.PlaybackFPS > 0 && cfg.Algorithm.ComputeMode != tlasca.ModeStreaming {
+		return nil, fmt.Errorf("input playback_fps requires algorithm compute_mode '%s'", tlasca.ModeStreaming)
+	}
+	if cfg.Output.ResultBitDepth != 8 && cfg.Output.ResultBitDepth != 16 {
+		return nil, fmt.Errorf("invalid output result_bit_depth %d, expected 8 or 16", cfg.Output.ResultBitDepth)
+	}
+	if cfg.Output.Background < 0 || cfg.Output.Background > 1 {
+		return nil, fmt.Errorf("invalid output background %g, expected a display level within [0, 1]", cfg.Output.Background)
+	}
+	colormap, err := render.NewColormap(cfg.Output.Colormap)
+	if err != nil {
+		return nil, fmt.Errorf("invalid output colormap: %w", err)
+	}
+	if cfg.Output.OverlayAlpha < 0 || cfg.Output.OverlayAlpha > 1 {
+		return nil, fmt.Errorf("invalid output overlay_alpha %g, expected an opacity within [0, 1]", cfg.Output.OverlayAlpha)
+	}
+	for _, q := range cfg.Output.Quantiles {
+		if q < 0 || q > 100 {
+			return nil, fmt.Errorf("invalid output quantile %g, expected a percentage within [0, 100]", q)
+		}
+	}
+	if err = priority.Validate(cfg.Limits.Nice, cfg.Limits.IOPriority); err != nil {
+		return nil, fmt.Errorf("invalid limits: %w", err)
+	}
+	if cfg.Algorithm.Workers < 0 {
+		return nil, fmt.Errorf("invalid algorithm workers %d, expected 0 (all logical CPUs) or more", cfg.Algorithm.Workers)
+	}
+	if cfg.Limits.MaxReaders < 0 {
+		return nil, fmt.Errorf("invalid limits max_readers %d, expected 0 (no limit) or more", cfg.Limits.MaxReaders)
+	}
+	kernel, err := tlasca.CheckKernel(cfg.Algorithm.Kernel)
+	if err != nil {
+		return nil, fmt.Errorf("invalid algorithm kernel: %w", err)
+	}
+	if !runOpts.validate {
+		applyResourceLimits(cfg.Limits, cfg.Algorithm.Workers, logger)
+		// Реализация задается при каждом запуске, как и число горутин.
+		tlasca.SetKernel(kernel)
+		logKernel(kernel, logger)
+	}
+	if err = registerRawFormat(cfg.Input.Raw); err != nil {
+		return nil, err
+	}
+	if cfg.Paths.CameraProfile != "" && len(cfg.Partial.Tile) > 0 {
+		return nil, fmt.Errorf("camera profile noise correction is not supported for partial results")
+	}
+	return &runSetup{
+		cfg:         cfg,
+		normalizer:  normalizer,
+		colormap:    colormap,
+		denoiser:    denoiser,
+		derivedMaps: derivedMaps,
+		template:    reportTemplate,
+	}, nil
+}
+
+// runInputs - входные данные запуска: файлы кадров и их геометрия (см. discoverInputs)
+// и подготовленные к расчету загрузчик и параметры (см. prepareInputs).
+type runInputs struct {
+	files []string
+	// quickLook - выборка кадров быстрого предварительного анализа (nil - все кадры).
+	quickLook *decimate.Selection
+	// frameCfg - заголовок первого кадра; frameRect - весь кадр, area - участок кадра roi.
+	frameCfg  image.Config
+	frameRect image.Rectangle
+	area      image.Rectangle
+	plan      safeguard.Plan
+	// outputPlan - выходные файлы, проверенные до расчета (см. plannedOutputs).
+	outputPlan []plannedOutput
+
+	// outputs - файлы, сохраненные до расчета (итоговая конфигурация, калибровка).
+	outputs []string
+	// frameTimes - времена регистрации кадров (nil, если файл времен не задан).
+	frameTimes  []float64
+	timing      *timestamps.Summary
+	exposure    *exposure.Summary
+	calibration *calibration.Summary
+	camera      *camera.Summary
+	bitDepth    int
+	opts        tlasca.Options
+	loader      *frameLoader
+	// aligner - совмещение кадров при загрузке (nil - без совмещения).
+	aligner *registration.Aligner
+}
+
+// discoverInputs находит и сортирует входные файлы (для видеофайла - декодированные
+// во временную директорию кадры), определяет размеры кадра по заголовку первого файла
+// и проверяет ресурсы, выходные файлы и место на диске до загрузки данных.
+// Возвращаемая функция удаляет временную директорию кадров видеофайла; она не nil
+// и должна быть вызвана и при ошибке.
+func discoverInputs(cfg *config.Config, logger *log.Logger, rec *telemetry.Recorder, overwrite bool) (*runInputs, func(), error) {
+	logger.Println("searching for image files...")
+	stopDiscover := rec.Start("discover")
+
+	// Кадры видеофайла декодируются во временную директорию, которая далее
+	// используется как директория с данными.
+	cleanup := func() {}
+	if cfg.Paths.Video != "" {
+		videoDir, err := extractVideo(cfg, logger, rec)
+		if err != nil {
+			return nil, cleanup, err
+		}
+		cleanup = func() {
+			if err := os.RemoveAll(videoDir); err != nil {
+				logger.Printf("warn: failed to remove video frames directory '%s': %v\n", videoDir, err)
+			}
+		}
+		cfg.Paths.DataDir = videoDir
+		cfg.Paths.Patterns = []string{"*.png"}
+	}
+
+	// Проверяем существование директории с данными, чтобы предоставить пользователю
+	// понятную ошибку в случае неверного пути в конфиге.
+	if _, err := os.Stat(cfg.Paths.DataDir); os.IsNotExist(err) {
+		return nil, cleanup, fmt.Errorf("data directory '%s' not found", cfg.Paths.DataDir)
+	}
+	// Файл конфигурации набора данных не является кадром, даже если подходит под шаблоны.
+	exclude := append(slices.Clone(cfg.Paths.Exclude), config.DatasetConfigName)
+	files, err := pathutil.ListFiles(cfg.Paths.DataDir, cfg.Paths.Patterns, exclude)
+	if err != nil {
+		return nil, cleanup, fmt.Errorf("error reading data directory '%s': %w", cfg.Paths.DataDir, err)
+	}
+	if len(files) == 0 {
+		return nil, cleanup, fmt.Errorf("no files matching %v found in '%s'", cfg.Paths.Patterns, cfg.Paths.DataDir)
+	}
+
+	if err = sortFrames(cfg.Input, files); err != nil {
+		return nil, cleanup, err
+	}
+	stopDiscover()
+	logger.Printf("found and sorted %d files.\n", len(files))
+
+	// Быстрый предварительный анализ выполняется по представительному подмножеству кадров;
+	// все последующие этапы (включая проверку ресурсов) работают только с ним.
+	var quickLook *decimate.Selection
+	if cfg.QuickLook.Frames > 0 {
+		stopSelect := rec.Start("quick-look")
+		files, quickLook, err = selectQuickLook(cfg, logger, rec, files)
+		stopSelect()
+		if err != nil {
+			return nil, cleanup, fmt.Errorf("quick-look frame selection failed: %w", err)
+		}
+		if quickLook != nil {
+			logger.Printf("quick-look: using %d of %d frames selected by %s.\n", len(files), quickLook.Total, quickLook.Method)
+		}
+	}
+
+	// --- Проверка ресурсов до загрузки данных ---
+	// Размеры кадра определяются по заголовку первого файла, без декодирования пикселей.
+	frameCfg, err := imageutils.LoadImageConfig(files[0])
+	if err != nil {
+		return nil, cleanup, fmt.Errorf("failed to read image header '%s': %w", files[0], err)
+	}
+	// Расчет выполняется только по участку кадра roi (по умолчанию - весь кадр): кадры
+	// обрезаются при загрузке после поправок, относящихся ко всему сенсору и оптике.
+	frameRect := image.Rect(0, 0, frameCfg.Width, frameCfg.Height)
+	area, err := roi.Parse(cfg.ROI, frameRect)
+	if err != nil {
+		return nil, cleanup, fmt.Errorf("invalid roi: %w", err)
+	}
+	if area != frameRect {
+		if len(cfg.Partial.Tile) > 0 {
+			return nil, cleanup, fmt.Errorf("roi cannot be combined with partial.tile: the tiles of a distributed run cover the full frame")
+		}
+		logger.Printf("region of interest: processing %v of the %dx%d frame.\n", area, frameCfg.Width, frameCfg.Height)
+	}
+	plan, err := safeguard.Check(cfg.Limits, cfg.Algorithm, safeguard.Input{
+		Width:  area.Dx(),
+		Height: area.Dy(),
+		Frames: len(files),
+	}, logger)
+	if err != nil {
+		return nil, cleanup, fmt.Errorf("resource check failed: %w", err)
+	}
+	if plan.FrameStride > 1 {
+		files = subsample(files, plan.FrameStride)
+	}
+
+	// Выходные файлы проверяются до загрузки данных: конфликт с результатами предыдущего
+	// запуска или нехватка места обнаруживаются сразу, а не после многочасового расчета.
+	outputPlan := plannedOutputs(cfg, area.Dx(), area.Dy(), files)
+	if !overwrite {
+		if err = checkOutputs(outputPlan); err != nil {
+			return nil, cleanup, err
+		}
+	}
+	if err = safeguard.CheckDisk(cfg.Paths.ResultsDir, totalSize(outputPlan), logger); err != nil {
+		return nil, cleanup, fmt.Errorf("resource check failed: %w", err)
+	}
+	return &runInputs{
+		files:      files,
+		quickLook:  quickLook,
+		frameCfg:   frameCfg,
+		frameRect:  frameRect,
+		area:       area,
+		plan:       plan,
+		outputPlan: outputPlan,
+	}, cleanup, nil
+}
+
+// prepareInputs подготавливает найденные кадры in к расчету: сохраняет итоговую
+// конфигурацию, загружает времена кадров, экспозиции, маски, калибровку, профиль камеры
+// и плоское поле, определяет разрядность данных и заполняет загрузчик и параметры
+// расчета in. Файлы, сохраненные до расчета, добавляются в in.outputs.
+func prepareInputs(cfg *config.Config, logger *log.Logger, rec *telemetry.Recorder, bus *events.Bus, in *runInputs) error {
+	files := in.files
+	frameCfg := in.frameCfg
+
+	// Итоговая конфигурация сохраняется до расчета: по ней можно воспроизвести запуск,
+	// даже если он завершится ошибкой. Исполнители распределенного расчета ее не сохраняют.
+	if cfg.Paths.EffectiveConfigFilename != "" && len(cfg.Partial.Tile) == 0 {
+		configOutput, err := saveEffectiveConfig(cfg)
+		if err != nil {
+			return err
+		}
+		in.outputs = append(in.outputs, configOutput)
+	}
+
+	// Реальные времена регистрации кадров (для съемки с внешним триггером).
+	if cfg.Paths.TimestampsFile != "" {
+		frames, err := frameNumbers(cfg.Input, files)
+		if err != nil {
+			return err
+		}
+		timeline, err := timestamps.Load(cfg.Paths.TimestampsFile)
+		if err == nil {
+			in.frameTimes, err = timeline.Times(frames)
+		}
+		if err != nil {
+			return fmt.Errorf("error loading timestamps '%s': %w", cfg.Paths.TimestampsFile, err)
+		}
+		summary := timestamps.Summarize(in.frameTimes)
+		in.timing = &summary
+		logger.Printf("frame timing: %d frames over %.3fs, interval mean=%.4fs min=%.4fs max=%.4fs jitter=%.1f%%\n",
+			summary.Frames, summary.Duration, summary.MeanInterval, summary.MinInterval, summary.MaxInterval, 100*summary.Jitter)
+		if summary.Gaps > 0 {
+			warning := fmt.Sprintf("%d inter-frame intervals exceed 1.5x the median interval (possible dropped frames)", summary.Gaps)
+			bus.Warn(warning)
+		}
+	}
+
+	// Нормировка интенсивности по экспозиции кадров (для съемки с автоэкспозицией).
+	var gains []float64
+	exposures, err := loadExposures(cfg, files)
+	if err != nil {
+		return err
+	}
+	if exposures != nil {
+		var summary exposure.Summary
+		gains, summary = exposure.Gains(exposures)
+		in.exposure = &summary
+		logger.Printf("exposure normalization: exposures %g-%g, normalized to %g\n", summary.Min, summary.Max, summary.Reference)
+	}
+
+	// --- Определение разрядности входных данных ---
+	// Первый кадр декодируется заранее: разрядность нужна для нормировки интенсивностей
+	// к полной шкале и для порога насыщения до начала основной загрузки.
+	firstImg, err := imageutils.LoadImage(files[0])
+	if err != nil {
+		return fmt.Errorf("failed to load image '%s': %w", files[0], err)
+	}
+	firstFrame, containerDepth := imageutils.ConvertToFrame(firstImg)
+	bitDepth := cfg.Input.BitDepth
+	if bitDepth == 0 && cfg.Input.Raw.Width > 0 && strings.EqualFold(filepath.Ext(files[0]), cfg.Input.Raw.Extension) {
+		// Разрядность кадров без заголовка задана форматом.
+		bitDepth = cfg.Input.Raw.BitDepth
+		logger.Printf("raw input data: %d-bit samples (%d-bit container).\n", bitDepth, containerDepth)
+	} else if bitDepth == 0 {
+		// Разрядность 8-битного контейнера известна, остальные кадры выборки не нужны.
+		samples := []frame.Frame{firstFrame}
+		if containerDepth > 8 {
+			if samples, err = sampleFrames(files, firstFrame, bitDepthSamples); err != nil {
+				return err
+			}
+		}
+		bitDepth = imageutils.DetectBitDepth(samples, containerDepth)
+		logger.Printf("detected %d-bit input data (%d-bit container) from %d sampled frames.\n", bitDepth, containerDepth, len(samples))
+		if bitDepth < containerDepth {
+			bus.Warn(fmt.Sprintf("input bit depth %d was detected from the brightest of %d sampled frames and is below the %d-bit container; "+
+				"set input.bit_depth to the camera bit depth, otherwise brighter frames may exceed the full scale",
+				bitDepth, len(samples), containerDepth))
+		}
+	} else if bitDepth < 8 || bitDepth > containerDepth {
+		return fmt.Errorf("configured bit depth %d is outside the 8..%d range supported by the input files", bitDepth, containerDepth)
+	}
+	in.bitDepth = bitDepth
+	fullScale := float64(uint32(1)<<bitDepth - 1)
+
+	// Интенсивности нормируются к полной шкале разрядности, поэтому статистики
+	// сопоставимы между камерами с разной разрядностью.
+	if gains == nil {
+		gains = make([]float64, len(files))
+		for i := range gains {
+			gains[i] = 1
+		}
+	}
+	for i := range gains {
+		gains[i] /= fullScale
+	}
+
+	opts := tlasca.Options{Gains: gains, Progress: publishProgress(bus), IntensityUnit: units.FullScale}
+	if cfg.Paths.ExclusionMask != "" {
+		opts.Exclusion, err = mask.Load(cfg.Paths.ExclusionMask, frameCfg.Width, frameCfg.Height)
+		if err != nil {
+			return fmt.Errorf("error loading exclusion mask '%s': %w", cfg.Paths.ExclusionMask, err)
+		}
+		logger.Printf("exclusion mask: %d pixels excluded.\n", opts.Exclusion.Count())
+	}
+	// Дефектные пиксели сенсора исключаются так же, как пиксели маски исключения.
+	if cfg.Paths.BadPixelMap != "" {
+		badPixels, err := mask.Load(cfg.Paths.BadPixelMap, frameCfg.Width, frameCfg.Height)
+		if err != nil {
+			return fmt.Errorf("error loading bad pixel map '%s': %w", cfg.Paths.BadPixelMap, err)
+		}
+		opts.Exclusion = mask.Union(opts.Exclusion, badPixels)
+		logger.Printf("bad pixel map: %d pixels excluded.\n", badPixels.Count())
+	}
+	// Маска ткани отмечает учитываемые пиксели: исключаются пиксели с нулевой яркостью.
+	if cfg.Paths.TissueMask != "" {
+		outside, err := mask.Load(cfg.Paths.TissueMask, frameCfg.Width, frameCfg.Height)
+		if err != nil {
+			return fmt.Errorf("error loading tissue mask '%s': %w", cfg.Paths.TissueMask, err)
+		}
+		outside.Invert()
+		opts.Exclusion = mask.Union(opts.Exclusion, outside)
+		logger.Printf("tissue mask: %d pixels outside the mask excluded.\n", outside.Count())
+	}
+	// Дисторсия оценивается по изображению мишени до загрузки кадров; пиксели исправленного
+	// кадра без исходной точки (углы при подушкообразной дисторсии) исключаются из расчета.
+	var undistort *calibration.Remap
+	if cfg.Paths.CalibrationImage != "" {
+		var calibrationOutputs []string
+		var warning string
+		undistort, in.calibration, calibrationOutputs, warning, err = runCalibration(cfg, logger, rec, frameCfg.Width, frameCfg.Height)
+		if err != nil {
+			return err
+		}
+		in.outputs = append(in.outputs, calibrationOutputs...)
+		if warning != "" {
+			bus.Warn(warning)
+		}
+		if outside := undistort.Outside(); outside != nil {
+			opts.Exclusion = mask.Union(opts.Exclusion, outside)
+			logger.Printf("undistortion: %d pixels outside the frame excluded.\n", outside.Count())
+		}
+	}
+
+	loader := &frameLoader{
+		logger:                 logger,
+		rec:                    rec,
+		bus:                    bus,
+		containerDepth:         containerDepth,
+		saturationLevel:        uint16(min(math.Ceil(cfg.Input.SaturationLevel*fullScale), math.MaxUint16)),
+		saturationWarnFraction: cfg.Input.SaturationWarnFraction,
+		undistort:              undistort,
+	}
+	// Маски задаются в координатах кадра, карта рассчитывается в геометрии участка roi.
+	if in.area != in.frameRect {
+		loader.crop = in.area
+		opts.Exclusion = opts.Exclusion.Crop(in.area)
+	}
+	// Профиль шума камеры: смещение вычитается при загрузке кадров, дисперсия шума -
+	// из временной дисперсии при расчете контраста.
+	if cfg.Paths.CameraProfile != "" {
+		loader.dark, opts.NoiseVariance, in.camera, err = loadCameraProfile(cfg, logger, frameCfg.Width, frameCfg.Height, gains)
+		if err != nil {
+			return err
+		}
+		if in.area != in.frameRect {
+			opts.NoiseVariance = cropPlane(opts.NoiseVariance, frameCfg.Width, in.area)
+		}
+	}
+	if cfg.Paths.FlatField != "" {
+		if loader.flat, err = loadFlatField(cfg.Paths.FlatField, frameCfg.Width, frameCfg.Height, loader.dark); err != nil {
+			return err
+		}
+		logger.Printf("flat field: %s.\n", cfg.Paths.FlatField)
+	}
+	switch cfg.Input.UnreadableFrames {
+	case "", "strict":
+	case "tolerant":
+		loader.tolerant = true
+	default:
+		return fmt.Errorf("unknown unreadable_frames policy '%s', expected 'strict' or 'tolerant'", cfg.Input.UnreadableFrames)
+	}
+	// Совмещение кадров выполняется при загрузке, относительно первого кадра последовательности.
+	if cfg.Registration.Enabled {
+		in.aligner = registration.NewAligner(cfg.Registration.MaxShift)
+		loader.aligner = in.aligner
+	}
+	in.opts = opts
+	in.loader = loader
+	return nil
+}
+
+// runComputation - карта контраста и дополнительные карты, рассчитанные computeResult.
+type runComputation struct {
+	result *tlasca.Result
+	// grayImages - кадры, загруженные в память целиком (nil при потоковой и порционной
+	// обработке: дальнейшие проходы повторно читают последовательность).
+	grayImages []frame.Frame
+	skipped    []report.SkippedFrame
+	quantiles  [][]float64
+	trend      *trendMap
+	activity   *biospeckle.Activity
+	epochs     *comparison
+}
+
+// computeResult загружает кадры in и рассчитывает карту контраста (потоком, порциями
+// или по всей последовательности в памяти), затем подавляет шум карты и выполняет
+// дополнительные проходы: квантили, тренд, индексы биоспекла и сравнение эпох.
+// Нечитаемые кадры исключаются из in (файлы, времена, коэффициенты усиления).
+// Ошибка расчета карты сохраняет частичный результат (см. salvagePartial).
+func computeResult(ctx context.Context, cfg *config.Config, logger *log.Logger, rec *telemetry.Recorder, bus *events.Bus,
+	runner *tlasca.Runner, setup *runSetup, in *runInputs) (*runComputation, error) {
+	loader := in.loader
+	var result *tlasca.Result
+	var grayImages []frame.Frame
+	var err error
+	if cfg.Algorithm.ComputeMode == tlasca.ModeStreaming {
+		// Кадры загружаются по одному и сразу учитываются в статистиках: память
+		// не зависит от длины записи.
+		var src tlasca.FrameSource = &frameStream{loader: loader, files: in.files}
+		// При воспроизведении кадры подаются с частотой съемки, как с камеры.
+		var playback *tlasca.PacedSource
+		if cfg.Input.PlaybackFPS > 0 {
+			if playback, err = tlasca.NewPacedSource(ctx, src, cfg.Input.PlaybackFPS); err != nil {
+				return nil, err
+			}
+			logger.Printf("playback: feeding %d frames at %g fps (%s).\n", len(in.files), cfg.Input.PlaybackFPS,
+				time.Duration(float64(len(in.files))/cfg.Input.PlaybackFPS*float64(time.Second)).Round(time.Millisecond))
+			src = playback
+		}
+		result, err = runner.RunStream(ctx, src, in.opts)
+		if err != nil {
+			return nil, salvagePartial(cfg, logger, setup.normalizer, err)
+		}
+		if playback != nil && playback.MaxLag() > 0 {
+			warning := fmt.Sprintf("processing fell behind the playback rate of %g fps by up to %s",
+				cfg.Input.PlaybackFPS, playback.MaxLag().Round(time.Millisecond))
+			bus.Warn(warning)
+		}
+	} else if in.plan.ChunkSize > 0 {
+		// Длинные записи обрабатываются порциями: кадры каждой порции загружаются
+		// непосредственно перед расчетом и освобождаются после объединения статистик.
+		files := in.files
+		result, err = runner.RunChunked(ctx, len(files), in.plan.ChunkSize, func(start, end int) ([]frame.Frame, error) {
+			return loader.load(start, files[start:end])
+		}, in.opts)
+		if err != nil {
+			return nil, salvagePartial(cfg, logger, setup.normalizer, err)
+		}
+	} else {
+		logger.Println("loading and converting images...")
+		grayImages, err = loader.load(0, in.files)
+		if err != nil {
+			// Ошибка на этом этапе фатальна, так как алгоритму требуется полная последовательность.
+			return nil, err
+		}
+		if len(in.files)-len(loader.skippedFrames) < 2 {
+			return nil, fmt.Errorf("at least 2 readable frames are required, %d of %d frames are unreadable", len(loader.skippedFrames), len(in.files))
+		}
+		result, err = runner.Run(ctx, grayImages, in.opts)
+		if err != nil {
+			return nil, salvagePartial(cfg, logger, setup.normalizer, err)
+		}
+	}
+
+	// Пропущенные кадры исключаются из последовательности: дополнительные проходы
+	// (сравнение эпох, диагностика, анализ областей) и отчет работают только с прочитанными кадрами.
+	var skippedFrames []report.SkippedFrame
+	if len(loader.skippedFrames) > 0 {
+		for _, skipped := range loader.skippedFrames {
+			skippedFrames = append(skippedFrames, report.SkippedFrame{
+				Index: skipped.Index + 1,
+				File:  skipped.Path,
+				Error: skipped.Err.Error(),
+			})
+		}
+		warning := fmt.Sprintf("%d of %d frames were unreadable and skipped, first: %s",
+			len(loader.skippedFrames), len(in.files), filepath.Base(loader.skippedFrames[0].Path))
+		bus.Warn(warning)
+
+		in.files = withoutSkipped(in.files, loader.skippedFrames)
+		if in.frameTimes != nil {
+			// Сводка интервалов пересчитывается по оставшимся кадрам: пропуск кадра
+			// увеличивает интервал между соседними.
+			in.frameTimes = withoutSkipped(in.frameTimes, loader.skippedFrames)
+			summary := timestamps.Summarize(in.frameTimes)
+			in.timing = &summary
+		}
+		grayImages = withoutSkipped(grayImages, loader.skippedFrames)
+		in.opts.Gains = withoutSkipped(in.opts.Gains, loader.skippedFrames)
+	}
+	files, opts, chunkSize := in.files, in.opts, in.plan.ChunkSize
+
+	// Временные анализы сопоставляют времена с кадрами по индексу.
+	if in.frameTimes != nil && len(in.frameTimes) != len(files) {
+		return nil, fmt.Errorf("timestamps cover %d frames, but %d frames remain after skipping unreadable frames", len(in.frameTimes), len(files))
+	}
+
+	if warning := loader.saturationWarning(cfg.Input.SaturationWarnFraction, in.bitDepth); warning != "" {
+		bus.Warn(warning)
+	}
+
+	// Подавление шума применяется к итоговой карте до всех ее дальнейших использований
+	// (сохранение, анализ областей, иллюстрации).
+	if setup.denoiser != nil {
+		logger.Printf("denoising contrast map: %s...\n", setup.denoiser)
+		noise := denoise.RelativeNoise(len(files), cfg.Algorithm.WindowSize*cfg.Algorithm.WindowSize)
+		stopDenoise := rec.Start("denoise")
+		result.Contrast = setup.denoiser.Apply(result.Contrast, result.Width, result.Height, result.Excluded, noise)
+		stopDenoise()
+	}
+
+	// Квантили не объединяются по порциям: при порционной обработке
+	// последовательность повторно читается полосами строк кадра.
+	var quantilePlanes [][]float64
+	if len(cfg.Output.Quantiles) > 0 {
+		logger.Println("computing temporal quantile maps...")
+		quantilePlanes, err = runner.Quantiles(ctx, cfg.Output.Quantiles, len(files), chunkSize,
+			sequenceLoader(loader, files, grayImages), opts)
+		if err != nil {
+			return nil, fmt.Errorf("error computing quantile maps: %w", err)
+		}
+	}
+
+	var trend *trendMap
+	if cfg.Trend.Enabled {
+		logger.Printf("computing %s trend map...\n", cfg.Trend.Signal)
+		trend, err = runTrend(ctx, cfg, runner, loader, files, grayImages, result, opts, chunkSize, in.frameTimes)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var activity *biospeckle.Activity
+	if cfg.Biospeckle.Enabled {
+		logger.Println("computing biospeckle activity indices...")
+		activity, err = runBiospeckle(rec, loader, files, grayImages, chunkSize)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Карты эпох рассчитываются до сохранения: на них могут ссылаться производные карты.
+	var epochs *comparison
+	if len(cfg.Compare.EpochA) > 0 || len(cfg.Compare.EpochB) > 0 {
+		logger.Println("comparing epochs...")
+		epochs, err = runComparison(ctx, cfg, runner, loader, files, grayImages, opts, chunkSize)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &runComputation{
+		result:     result,
+		grayImages: grayImages,
+		skipped:    skippedFrames,
+		quantiles:  quantilePlanes,
+		trend:      trend,
+		activity:   activity,
+		epochs:     epochs,
+	}, nil
+}
+
+// runOutputs - сохраненные saveResults файлы и сводки для отчета о запуске.
+type runOutputs struct {
+	// path - путь к изображению итоговой карты.
+	path string
+	// outputs - сохраненные файлы, включая сохраненные до расчета.
+	outputs []string
+	// mapResult - итоговая карта (см. outputMap), displayScale - ее шкала отображения.
+	mapResult        *tlasca.Result
+	displayScale     render.Scale
+	clipping         render.Clipping
+	segmentation     *segment.Summary
+	contrastLimits   *speckle.Summary
+	staticScattering *speckle.SeparationSummary
+	trend            *report.Trend
+	biospeckle       *biospeckle.Summary
+	derived          []report.DerivedMap
+}
+
+// saveResults сохраняет итоговую карту comp в директорию результатов: изображения карты
+// и их производные (псевдоцвет, наложение, маски, производные карты), промежуточные карты
+// в геометрии кадра, иллюстрацию, матрицы, файлы привязки, пирамиду deep zoom и сравнение
+// эпох. startedAt указывается в подписи иллюстрации.
+func saveResults(cfg *config.Config, logger *log.Logger, bus *events.Bus, setup *runSetup, in *runInputs, comp *runComputation,
+	startedAt time.Time) (*runOutputs, error) {
+	err := os.MkdirAll(cfg.Paths.ResultsDir, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("error creating results directory '%s': %w", cfg.Paths.ResultsDir, err)
+	}
+	result, normalizer, colormap := comp.result, setup.normalizer, setup.colormap
+
+	newPath := filepath.Join(cfg.Paths.ResultsDir, cfg.Paths.OutputFilename)
+	// Итоговая карта - контраст или индекс кровотока (algorithm.output); анализы ниже
+	// используют карту контраста result.
+	mapResult := outputMap(cfg, result)
+	// Шкала отображения строится один раз и используется всеми изображениями карты.
+	displayScale := normalizer.Fit(mapResult.Contrast, mapResult.Excluded)
+	displayRange := displayScale.Bounds()
+	logger.Printf("display scale: %s, %s in [%.4g, %.4g]\n", normalizer, mapQuantity(cfg), displayRange.Min, displayRange.Max)
+	mapImage := render.FillBackground(render.Gray(mapResult, displayScale), mapResult.Excluded, cfg.Output.Background)
+
+	// Контроль потерь динамического диапазона при отображении карты в [0, 255].
+	clipping := render.AnalyzeClipping(mapResult, displayScale)
+	if clipping.Low+clipping.High > 0 {
+		warning := fmt.Sprintf("%d map pixels (%.2f%%) are outside the display range [%.4g, %.4g]: %d below, %d above, within %v",
+			clipping.Low+clipping.High, 100*clipping.Fraction(), displayRange.Min, displayRange.Max,
+			clipping.Low, clipping.High, clipping.Bounds)
+		bus.Warn(warning)
+	}
+	saved := &runOutputs{path: newPath, mapResult: mapResult, displayScale: displayScale, clipping: clipping}
+
+	// Изображения в геометрии карты, промежуточные карты в геометрии кадра и иллюстрация
+	// независимы, поэтому кодируются и записываются параллельно.
+	mapImages := []pngOutput{{newPath, "result image", resultImage(mapResult, displayScale, mapImage, cfg.Output.ResultBitDepth, cfg.Output.Background)}}
+	if cfg.Output.OutOfRangeMask != "" {
+		maskPath := filepath.Join(cfg.Paths.ResultsDir, cfg.Output.OutOfRangeMask)
+		mapImages = append(mapImages, pngOutput{maskPath, "out-of-range mask", render.ClippingMask(mapResult, displayScale)})
+	}
+	if cfg.Output.ColormapFilename != "" {
+		colorPath := filepath.Join(cfg.Paths.ResultsDir, cfg.Output.ColormapFilename)
+		mapImages = append(mapImages, pngOutput{colorPath, fmt.Sprintf("%s pseudo-color map", colormap),
+			render.Colorize(mapResult, displayScale, colormap, cfg.Output.Background)})
+	}
+	if cfg.Output.OverlayFilename != "" {
+		overlayPath := filepath.Join(cfg.Paths.ResultsDir, cfg.Output.OverlayFilename)
+		mapImages = append(mapImages, pngOutput{overlayPath, "mean intensity overlay",
+			render.Overlay(mapResult, displayScale, colormap, cfg.Output.OverlayAlpha)})
+	}
+	if cfg.Segmentation.Enabled {
+		summary, err := segment.Fit(result.Contrast, result.Excluded)
+		if err != nil {
+			return nil, fmt.Errorf("error separating vessels and tissue: %w", err)
+		}
+		saved.segmentation = &summary
+		logger.Printf("vessel/tissue threshold K = %.4g: vessels %.1f%% (K %.4g ± %.4g), tissue %.1f%% (K %.4g ± %.4g).\n",
+			summary.Threshold, 100*summary.Vessel.Fraction, summary.Vessel.Mean, summary.Vessel.StdDev,
+			100*summary.Tissue.Fraction, summary.Tissue.Mean, summary.Tissue.StdDev)
+		if summary.Separation() < minSeparation {
+			warning := fmt.Sprintf("vessel and tissue contrast classes overlap (separation %.2f), the threshold is unreliable",
+				summary.Separation())
+			bus.Warn(warning)
+		}
+		if cfg.Segmentation.MaskFilename != "" {
+			maskPath := filepath.Join(cfg.Paths.ResultsDir, cfg.Segmentation.MaskFilename)
+			mapImages = append(mapImages, pngOutput{maskPath, "vessel mask",
+				segment.Mask(result.Contrast, result.Width, result.Height, result.Excluded, summary.Threshold)})
+		}
+	}
+	if cfg.ContrastLimits.Enabled {
+		summary, limitImages, warning, err := checkContrastLimits(cfg, result, mapImage)
+		if err != nil {
+			return nil, err
+		}
+		saved.contrastLimits = &summary
+		logger.Printf("theoretical contrast limits: K in [%.4g, %.4g] (T = %g ms, static fraction %g, beta %g).\n",
+			summary.Limits.Min, summary.Limits.Max, cfg.ContrastLimits.ExposureTime, summary.Model.StaticFraction, summary.Model.Beta)
+		if warning != "" {
+			bus.Warn(warning)
+		}
+		mapImages = append(mapImages, limitImages...)
+	}
+	var inputs derivedInputs
+	if comp.epochs != nil {
+		inputs.epochs = comp.epochs.results
+	}
+	if cfg.StaticScattering.Enabled {
+		separation, summary, staticImages, err := separateStaticScattering(cfg, result)
+		if err != nil {
+			return nil, err
+		}
+		saved.staticScattering = &summary
+		inputs.static, inputs.flow = separation.Static, separation.Flow
+		logger.Printf("static scattering: mean static fraction %.3f, median flow index T/tau_c %.4g (%d windows, %d frames, beta %g).\n",
+			summary.MeanStatic, summary.MedianFlow, summary.Windows, summary.Frames, summary.Beta)
+		mapImages = append(mapImages, staticImages...)
+	}
+	if len(setup.derivedMaps) > 0 {
+		derivedImages, summaries, derivedWarnings, err := evaluateDerivedMaps(cfg, setup.derivedMaps, result, inputs)
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range summaries {
+			logger.Printf("derived map '%s' = %s: mean %.4g %s, saved range [%.4g, %.4g].\n", s.Name, s.Expression, s.Mean, s.Unit, s.Range[0], s.Range[1])
+		}
+		for _, warning := range derivedWarnings {
+			bus.Warn(warning)
+		}
+		saved.derived = summaries
+		mapImages = append(mapImages, derivedImages...)
+	}
+	// Промежуточные карты в геометрии кадра: значение 65535 соответствует полной шкале разрядности.
+	fullScaleRange := render.Range{Min: 0, Max: 1}
+	var planeImages []pngOutput
+	type namedPlane struct {
+		filename string
+		values   []float64
+	}
+	planes := []namedPlane{
+		{cfg.Output.MeanFilename, result.Mean},
+		{cfg.Output.StdDevFilename, result.StdDev},
+	}
+	for k, q := range cfg.Output.Quantiles {
+		planes = append(planes, namedPlane{cfg.Output.QuantileFilename(q), comp.quantiles[k]})
+	}
+	for _, plane := range planes {
+		if plane.filename == "" {
+			continue
+		}
+		planeImages = append(planeImages, pngOutput{
+			path: filepath.Join(cfg.Paths.ResultsDir, plane.filename),
+			what: "intermediate map",
+			img:  render.Gray16Plane(plane.values, result.FrameWidth, result.FrameHeight, fullScaleRange),
+		})
+	}
+	var trendFiles []fileOutput
+	if comp.trend != nil {
+		summary, trendImages, files, err := comp.trend.outputs(cfg)
+		if err != nil {
+			return nil, err
+		}
+		saved.trend, trendFiles = summary, files
+		logger.Printf("%s trend: median slope %.4g %s, saved range [%.4g, %.4g].\n",
+			summary.Signal, summary.Slope.Median, summary.Slope.Unit, summary.Range[0], summary.Range[1])
+		// Тренд интенсивности - карта в геометрии кадра, тренд контраста - в геометрии карты.
+		if cfg.Trend.Signal == trendIntensity {
+			planeImages = append(planeImages, trendImages...)
+		} else {
+			mapImages = append(mapImages, trendImages...)
+		}
+	}
+	if comp.activity != nil {
+		summary, activityImages := biospeckleOutputs(cfg, comp.activity)
+		saved.biospeckle = &summary
+		logger.Printf("biospeckle activity: IM %.4g, AVD %.4g (%d frame pairs).\n", summary.IM, summary.AVD, summary.Pairs)
+		planeImages = append(planeImages, activityImages...)
+	}
+	var figureImages []pngOutput
+	if cfg.Output.FigureFilename != "" {
+		// Кадры видеофайла находятся во временной директории; в подписи указывается сам файл.
+		source := cfg.Paths.DataDir
+		if cfg.Paths.Video != "" {
+			source = cfg.Paths.Video
+		}
+		caption := []string{
+			"go-tlasca  " + startedAt.Format("2006-01-02 15:04"),
+			"data: " + source,
+			fmt.Sprintf("frames: %d  (%d-bit)", len(in.files), in.bitDepth),
+			fmt.Sprintf("frame size: %dx%d", result.FrameWidth, result.FrameHeight),
+			fmt.Sprintf("window: %dx%d", cfg.Algorithm.WindowSize, cfg.Algorithm.WindowSize),
+			fmt.Sprintf("%s display range: [%.4g, %.4g] (%s)", mapQuantity(cfg), displayRange.Min, displayRange.Max, normalizer),
+			fmt.Sprintf("clipped: %.2f%%", 100*clipping.Fraction()),
+		}
+		if cfg.Algorithm.Preset != "" {
+			caption = append(caption, "preset: "+cfg.Algorithm.Preset)
+		}
+		// Иллюстрация показывает карту контраста: при выводе индекса кровотока ее шкала
+		// строится по диапазону контраста.
+		figureScale := displayScale
+		if mapResult != result {
+			figureScale = render.MinMax{}.Fit(result.Contrast, result.Excluded)
+		}
+		figureImages = append(figureImages, pngOutput{
+			path: filepath.Join(cfg.Paths.ResultsDir, cfg.Output.FigureFilename),
+			what: "figure",
+			img:  figure.Build(result, figureScale, caption),
+		})
+	}
+	// Все выходные файлы (изображения, карта без квантования и матрицы для Python/pandas)
+	// записываются вместе, параллельно, по одному набору рассчитанных данных.
+	imageFiles := pngFiles(slices.Concat(mapImages, planeImages, figureImages))
+	var matrices []fileOutput
+	if cfg.Output.FloatFilename != "" || cfg.Output.CSVFilename != "" || cfg.Output.NPYFilename != "" {
+		description := fmt.Sprintf("go-tlasca %s [%s], window %d, excluded positions NaN",
+			mapQuantity(cfg), mapResult.Units.Contrast, cfg.Algorithm.WindowSize)
+		matrices = matrixOutputs(cfg, floatContrast(mapResult), result.Width, result.Height, description, "")
+	}
+	if err = saveOutputs(slices.Concat(imageFiles, matrices, trendFiles)); err != nil {
+		return nil, err
+	}
+
+	mapFiles := make([]string, 0, len(mapImages)+1)
+	for _, o := range mapImages {
+		mapFiles = append(mapFiles, o.path)
+	}
+	// Карта без квантования (TIFF) получает файл привязки, как и изображения карты.
+	var matrixFiles []string
+	for _, o := range matrices {
+		if o.path == filepath.Join(cfg.Paths.ResultsDir, cfg.Output.FloatFilename) {
+			mapFiles = append(mapFiles, o.path)
+		} else {
+			matrixFiles = append(matrixFiles, o.path)
+		}
+	}
+	for _, o := range trendFiles {
+		matrixFiles = append(matrixFiles, o.path)
+	}
+	outputs := slices.Concat(in.outputs, mapFiles, matrixFiles)
+	frameTransform := stageTransform(cfg, in.area)
+	if frameTransform != nil {
+		// Пиксель карты (x, y) соответствует окну с верхним левым углом (x, y) кадра,
+		// поэтому его центр смещен на (window_size-1)/2 пикселя кадра.
+		offset := float64(cfg.Algorithm.WindowSize-1) / 2
+		transform := frameTransform.Offset(offset, offset)
+		// Файлы привязки записываются для всех изображений в геометрии карты.
+		for _, path := range mapFiles {
+			worldPath := worldfile.SidecarPath(path)
+			if err = worldfile.Write(worldPath, transform); err != nil {
+				return nil, err
+			}
+			outputs = append(outputs, worldPath)
+		}
+	}
+	for _, o := range planeImages {
+		outputs = append(outputs, o.path)
+		if frameTransform != nil {
+			worldPath := worldfile.SidecarPath(o.path)
+			if err = worldfile.Write(worldPath, *frameTransform); err != nil {
+				return nil, err
+			}
+			outputs = append(outputs, worldPath)
+		}
+	}
+	if cfg.Output.DeepZoomName != "" {
+		dziPath, err := deepzoom.Write(cfg.Paths.ResultsDir, cfg.Output.DeepZoomName, mapImage, deepzoom.Options{
+			TileSize: cfg.Output.DeepZoomTileSize,
+			Overlap:  cfg.Output.DeepZoomOverlap,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error saving deep zoom pyramid '%s': %w", cfg.Output.DeepZoomName, err)
+		}
+		outputs = append(outputs, dziPath)
+	}
+	for _, o := range figureImages {
+		outputs = append(outputs, o.path)
+	}
+	if comp.epochs != nil {
+		comparePath, err := saveComparison(cfg, comp.epochs, normalizer)
+		if err != nil {
+			return nil, err
+		}
+		outputs = append(outputs, comparePath)
+	}
+	saved.outputs = outputs
+	return saved, nil
+}
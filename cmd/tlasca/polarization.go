@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+
+	"github.com/mascotmascot1/go-tlasca/internal/config"
+	"github.com/mascotmascot1/go-tlasca/internal/imageutils"
+	"github.com/mascotmascot1/go-tlasca/internal/parallel"
+	"github.com/mascotmascot1/go-tlasca/internal/render"
+	"github.com/mascotmascot1/go-tlasca/internal/telemetry"
+	"github.com/mascotmascot1/go-tlasca/pkg/tlasca"
+)
+
+// Имена каналов поляризации; входят в имена файлов карт каналов.
+const (
+	coPolarized    = "co"
+	crossPolarized = "cross"
+)
+
+// polarizationChannels разбивает кадры files на каналы параллельной ("co") и скрещенной
+// ("cross") поляризации по окончанию имени файла без расширения: coSuffix или crossSuffix.
+// Кадры каналов образуют пары с одинаковой частью имени до окончания ("0001_co.png"
+// и "0001_cross.png"); пары упорядочены по кадру параллельного канала.
+// Возвращает ошибку для файла без окончания канала или без пары.
+func polarizationChannels(files []string, coSuffix, crossSuffix string) ([]frameChannel, error) {
+	pairKey := func(file string) (channel, key string) {
+		return splitChannel(strings.TrimSuffix(file, filepath.Ext(file)), coSuffix, crossSuffix)
+	}
+
+	cross := make(map[string]int)
+	var coFrames []int
+	for i, file := range files {
+		switch channel, key := pairKey(file); channel {
+		case coPolarized:
+			coFrames = append(coFrames, i)
+		case crossPolarized:
+			cross[key] = i
+		default:
+			return nil, fmt.Errorf("frame '%s' ends with neither co-polarized suffix '%s' nor cross-polarized suffix '%s'",
+				file, coSuffix, crossSuffix)
+		}
+	}
+	channels := []frameChannel{{name: coPolarized}, {name: crossPolarized}}
+	for _, i := range coFrames {
+		_, key := pairKey(files[i])
+		j, ok := cross[key]
+		if !ok {
+			return nil, fmt.Errorf("co-polarized frame '%s' has no cross-polarized pair", files[i])
+		}
+		delete(cross, key)
+		channels[0].frames = append(channels[0].frames, i)
+		channels[1].frames = append(channels[1].frames, j)
+	}
+	for _, file := range files {
+		if channel, key := pairKey(file); channel == crossPolarized {
+			if _, ok := cross[key]; ok {
+				return nil, fmt.Errorf("cross-polarized frame '%s' has no co-polarized pair", file)
+			}
+		}
+	}
+	if len(coFrames) < 2 {
+		return nil, fmt.Errorf("found %d polarization frame pairs, at least 2 are required", len(coFrames))
+	}
+	return channels, nil
+}
+
+// splitChannel отделяет от имени stem окончание канала поляризации и возвращает имя канала
+// (пустая строка, если stem не оканчивается ни coSuffix, ни crossSuffix) и остаток имени.
+// Если одно окончание - конец другого, проверяется более длинное.
+func splitChannel(stem, coSuffix, crossSuffix string) (channel, rest string) {
+	suffixes := []struct {
+		channel, suffix string
+	}{{coPolarized, coSuffix}, {crossPolarized, crossSuffix}}
+	if len(crossSuffix) > len(coSuffix) {
+		suffixes[0], suffixes[1] = suffixes[1], suffixes[0]
+	}
+	for _, s := range suffixes {
+		if s.suffix != "" && strings.HasSuffix(stem, s.suffix) {
+			return s.channel, strings.TrimSuffix(stem, s.suffix)
+		}
+	}
+	return "", stem
+}
+
+// frameNumber возвращает номер кадра file (см. imageutils.ExtractNumber) и имя его канала
+// поляризации: при заданных input.co_polarized_suffix и cross_polarized_suffix окончание
+// канала отбрасывается перед разбором номера ("0001_co.png" - кадр 1 канала "co").
+func frameNumber(input config.InputConfig, file string) (int, string, error) {
+	ext := filepath.Ext(file)
+	channel, stem := splitChannel(strings.TrimSuffix(file, ext), input.CoPolarizedSuffix, input.CrossPolarizedSuffix)
+	number, err := imageutils.ExtractNumber(stem + ext)
+	return number, channel, err
+}
+
+// runPolarization рассчитывает карты каналов поляризации (см. runChannels) и комбинированную
+// карту контраста, взвешенную по деполяризации: в каждом положении окна
+// K = (1 - D)·K_co + D·K_cross, где D = I_cross / (I_co + I_cross) - доля интенсивности
+// скрещенного канала (степень деполяризации) по средним интенсивностям пикселей окна.
+// Скрещенный канал отбирает многократно рассеянный свет из глубины ткани, параллельный -
+// преимущественно поверхностное отражение, поэтому вес канала отражает долю света, которую он несет.
+func runPolarization(ctx context.Context, cfg *config.Config, logger *log.Logger, rec *telemetry.Recorder, runner *tlasca.Runner,
+	loader *frameLoader, normalizer render.Normalizer, files []string, opts tlasca.Options, chunkSize int, run seriesRun) error {
+	channels, err := polarizationChannels(files, cfg.Input.CoPolarizedSuffix, cfg.Input.CrossPolarizedSuffix)
+	if err != nil {
+		return err
+	}
+	logger.Printf("paired %d co- and cross-polarized frames.\n", len(channels[0].frames))
+	return runChannels(ctx, cfg, logger, rec, runner, loader, normalizer, files, opts, chunkSize, run, channels,
+		func(results []*tlasca.Result, save saveMap) error {
+			co, cross := results[0], results[1]
+			ws := cfg.Algorithm.WindowSize
+			depolarization := make([]float64, len(co.Contrast))
+			combined := &tlasca.Result{
+				Width: co.Width, Height: co.Height, FrameWidth: co.FrameWidth, FrameHeight: co.FrameHeight,
+				Contrast: make([]float64, len(co.Contrast)), Excluded: co.Excluded, Units: co.Units,
+			}
+			parallel.Rows(co.Height, func(startY, endY int) {
+				for y := startY; y < endY; y++ {
+					for x := range co.Width {
+						i := y*co.Width + x
+						if co.IsExcluded(x, y) {
+							continue
+						}
+						var sumCo, sumCross float64
+						for wy := y; wy < y+ws; wy++ {
+							for wx := x; wx < x+ws; wx++ {
+								sumCo += co.Mean[wy*co.FrameWidth+wx]
+								sumCross += cross.Mean[wy*co.FrameWidth+wx]
+							}
+						}
+						if sumCo+sumCross <= 0 {
+							continue
+						}
+						d := sumCross / (sumCo + sumCross)
+						depolarization[i] = d
+						combined.Contrast[i] = (1-d)*co.Contrast[i] + d*cross.Contrast[i]
+					}
+				}
+			})
+			logger.Printf("depolarization ratio: median %.4g.\n", render.Percentile(depolarization, co.Excluded, 50))
+
+			if cfg.Output.PolarizationFilename != "" {
+				path := filepath.Join(cfg.Paths.ResultsDir, cfg.Output.PolarizationFilename)
+				if err := save(path, "depolarization-weighted contrast map",
+					contrastImage(outputMap(cfg, combined), normalizer, cfg.Output.ResultBitDepth, cfg.Output.Background)); err != nil {
+					return err
+				}
+			}
+			if cfg.Output.DepolarizationFilename != "" {
+				path := filepath.Join(cfg.Paths.ResultsDir, cfg.Output.DepolarizationFilename)
+				img := render.GrayPlane(depolarization, co.Width, co.Height, co.Excluded, render.Range{Min: 0, Max: 1})
+				if err := save(path, "depolarization ratio map", img); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"runtime/trace"
+)
+
+// profileFlags - флаги профилирования подкоманды run: файлы профиля CPU, профиля памяти
+// и трассировки выполнения (пустая строка - не записывать).
+type profileFlags struct {
+	cpu, mem, trace *string
+}
+
+// addProfileFlags добавляет флаги профилирования в набор флагов подкоманды.
+func addProfileFlags(flags *flag.FlagSet) profileFlags {
+	return profileFlags{
+		cpu:   flags.String("cpuprofile", "", "write a CPU profile of the run to `file` (go tool pprof)"),
+		mem:   flags.String("memprofile", "", "write a heap profile at the end of the run to `file` (go tool pprof)"),
+		trace: flags.String("trace", "", "write an execution trace of the run to `file` (go tool trace)"),
+	}
+}
+
+// start начинает профилирование CPU и трассировку (если заданы) и возвращает функцию,
+// которая завершает их, записывает профиль памяти и сообщает в лог о записанных файлах.
+// Ошибка создания файлов возвращается до запуска расчета.
+func (p profileFlags) start(logger *log.Logger) (stop func() error, err error) {
+	var stops []func() error
+	stopAll := func() error {
+		var errs []error
+		for i := len(stops) - 1; i >= 0; i-- {
+			errs = append(errs, stops[i]())
+		}
+		return errors.Join(errs...)
+	}
+	defer func() {
+		if err != nil {
+			stopAll()
+		}
+	}()
+
+	if *p.cpu != "" {
+		f, err := os.Create(*p.cpu)
+		if err != nil {
+			return nil, fmt.Errorf("error creating cpu profile: %w", err)
+		}
+		if err = pprof.StartCPUProfile(f); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("error starting cpu profile: %w", err)
+		}
+		stops = append(stops, func() error {
+			pprof.StopCPUProfile()
+			if err := f.Close(); err != nil {
+				return fmt.Errorf("error writing cpu profile '%s': %w", *p.cpu, err)
+			}
+			logger.Printf("cpu profile saved: %s\n", *p.cpu)
+			return nil
+		})
+	}
+	if *p.trace != "" {
+		f, err := os.Create(*p.trace)
+		if err != nil {
+			return nil, fmt.Errorf("error creating execution trace: %w", err)
+		}
+		if err = trace.Start(f); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("error starting execution trace: %w", err)
+		}
+		stops = append(stops, func() error {
+			trace.Stop()
+			if err := f.Close(); err != nil {
+				return fmt.Errorf("error writing execution trace '%s': %w", *p.trace, err)
+			}
+			logger.Printf("execution trace saved: %s\n", *p.trace)
+			return nil
+		})
+	}
+	if *p.mem != "" {
+		// Файл создается заранее, чтобы ошибка пути обнаружилась до многочасового расчета.
+		f, err := os.Create(*p.mem)
+		if err != nil {
+			return nil, fmt.Errorf("error creating memory profile: %w", err)
+		}
+		stops = append(stops, func() error {
+			// Сборка мусора обновляет статистику кучи на момент завершения расчета.
+			runtime.GC()
+			err := pprof.WriteHeapProfile(f)
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				return fmt.Errorf("error writing memory profile '%s': %w", *p.mem, err)
+			}
+			logger.Printf("memory profile saved: %s\n", *p.mem)
+			return nil
+		})
+	}
+	return stopAll, nil
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/mascotmascot1/go-tlasca/pkg/tlasca"
+)
+
+// progressBarWidth - число символов полосы индикатора выполнения.
+const progressBarWidth = 30
+
+// progressUnits - единицы работы этапов расчета для индикатора выполнения.
+var progressUnits = map[string]string{
+	tlasca.ProgressStatistics:  "rows",
+	tlasca.ProgressFrames:      "frames",
+	tlasca.ProgressWindows:     "windows",
+	tlasca.ProgressContrastMap: "rows",
+}
+
+// progressBar показывает выполнение этапов расчета (см. tlasca.ProgressFunc). Если вывод -
+// терминал, индикатор перерисовывается в последней строке, а строки журнала, записанные
+// через progressBar как io.Writer, выводятся над ним; иначе выполнение этапа записывается
+// в журнал каждые 25%.
+type progressBar struct {
+	mu       sync.Mutex
+	out      io.Writer
+	terminal bool
+	logger   *log.Logger
+	stage    string
+	step     int
+	// line - текущая строка индикатора (пустая, если индикатор не показан).
+	line string
+}
+
+// newProgressBar создает индикатор для вывода out и подключает его как вывод журнала logger.
+func newProgressBar(out *os.File, logger *log.Logger) *progressBar {
+	b := &progressBar{out: out, terminal: isTerminal(out), logger: logger}
+	logger.SetOutput(b)
+	return b
+}
+
+// isTerminal сообщает, выводится ли f на терминал.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// Write выводит p (строку журнала), временно убирая строку индикатора.
+func (b *progressBar) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.line != "" {
+		io.WriteString(b.out, "\r\033[K")
+	}
+	n, err := b.out.Write(p)
+	if b.line != "" {
+		io.WriteString(b.out, b.line)
+	}
+	return n, err
+}
+
+// Report принимает сведения о выполнении этапа расчета.
+func (b *progressBar) Report(p tlasca.Progress) {
+	unit := progressUnits[p.Stage]
+	b.mu.Lock()
+	if p.Stage != b.stage {
+		if b.line != "" {
+			// Этап с неизвестным числом единиц работы завершается началом следующего.
+			io.WriteString(b.out, "\n")
+			b.line = ""
+		}
+		b.stage, b.step = p.Stage, -1
+	}
+	if b.terminal {
+		b.draw(p, unit)
+		b.mu.Unlock()
+		return
+	}
+	// Без общего числа единиц работы о выполнении сообщается каждые 100 единиц.
+	step := p.Done / 100
+	if p.Total > 0 {
+		step = int(p.Percent()) / 25
+	}
+	report := step > max(b.step, 0)
+	b.step = max(b.step, step)
+	b.mu.Unlock()
+
+	if !report {
+		return
+	}
+	if p.Total > 0 {
+		b.logger.Printf("progress: %s %.0f%% (%d of %d %s).\n", p.Stage, p.Percent(), p.Done, p.Total, unit)
+	} else {
+		b.logger.Printf("progress: %s %d %s.\n", p.Stage, p.Done, unit)
+	}
+}
+
+// draw перерисовывает строку индикатора; по завершении этапа строка остается на экране.
+func (b *progressBar) draw(p tlasca.Progress, unit string) {
+	if p.Total > 0 {
+		filled := min(p.Done*progressBarWidth/p.Total, progressBarWidth)
+		b.line = fmt.Sprintf("%-12s [%s%s] %3.0f%% (%d/%d %s)", p.Stage,
+			strings.Repeat("#", filled), strings.Repeat("-", progressBarWidth-filled), p.Percent(), p.Done, p.Total, unit)
+	} else {
+		b.line = fmt.Sprintf("%-12s %d %s", p.Stage, p.Done, unit)
+	}
+	io.WriteString(b.out, "\r\033[K"+b.line)
+	if p.Total > 0 && p.Done >= p.Total {
+		io.WriteString(b.out, "\n")
+		b.line = ""
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/mascotmascot1/go-tlasca/internal/config"
+	"github.com/mascotmascot1/go-tlasca/internal/decimate"
+	"github.com/mascotmascot1/go-tlasca/internal/exposure"
+	"github.com/mascotmascot1/go-tlasca/internal/imageutils"
+	"github.com/mascotmascot1/go-tlasca/internal/telemetry"
+	"github.com/mascotmascot1/go-tlasca/internal/timestamps"
+	"github.com/mascotmascot1/go-tlasca/pkg/frame"
+)
+
+// intensitySampleStep - шаг сетки пикселей, по которой оценивается средняя интенсивность кадра
+// при стратифицированном выборе: для сравнения кадров между собой полной точности не требуется.
+const intensitySampleStep = 4
+
+// selectQuickLook выбирает подмножество кадров files для быстрого анализа по cfg.QuickLook
+// и возвращает выбранные файлы в порядке последовательности и описание выбора для отчета.
+// Если последовательность не длиннее запрошенного подмножества, возвращаются все кадры
+// и nil вместо описания.
+func selectQuickLook(cfg *config.Config, logger *log.Logger, rec *telemetry.Recorder, files []string) ([]string, *decimate.Selection, error) {
+	n := cfg.QuickLook.Frames
+	if n < 2 {
+		return nil, nil, fmt.Errorf("quick_look frames must be at least 2, got %d", n)
+	}
+	if n >= len(files) {
+		logger.Printf("quick-look: sequence has only %d frames, all frames are used.\n", len(files))
+		return files, nil, nil
+	}
+	frames, err := frameNumbers(cfg.Input, files)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	method := decimate.Method(cfg.QuickLook.Method)
+	var indices []int
+	switch method {
+	case decimate.Uniform:
+		var times []float64
+		if cfg.Paths.TimestampsFile != "" {
+			timeline, err := timestamps.Load(cfg.Paths.TimestampsFile)
+			if err == nil {
+				times, err = timeline.Times(frames)
+			}
+			if err != nil {
+				return nil, nil, fmt.Errorf("error loading timestamps '%s': %w", cfg.Paths.TimestampsFile, err)
+			}
+		}
+		indices = decimate.UniformIndices(times, len(files), n)
+	case decimate.Intensity:
+		candidates, values, err := frameIntensities(cfg, logger, rec, files, frames)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, i := range decimate.StratifiedIndices(values, n) {
+			indices = append(indices, candidates[i])
+		}
+	default:
+		return nil, nil, fmt.Errorf("unknown quick_look method '%s', expected '%s' or '%s'", method, decimate.Uniform, decimate.Intensity)
+	}
+
+	selection := &decimate.Selection{Method: method, Total: len(files), Frames: make([]int, len(indices))}
+	selected := make([]string, len(indices))
+	for i, idx := range indices {
+		selected[i] = files[idx]
+		selection.Frames[i] = frames[idx]
+	}
+	return selected, selection, nil
+}
+
+// frameIntensities возвращает среднюю интенсивность кадров files (с учетом нормировки
+// по экспозиции, если она задана) и индексы кадров, к которым относятся значения.
+// Для этого декодируется каждый кадр, поэтому стратифицированный выбор заметно дольше
+// равномерного. При политике unreadable_frames = tolerant нечитаемые кадры не участвуют в выборе.
+func frameIntensities(cfg *config.Config, logger *log.Logger, rec *telemetry.Recorder,
+	files []string, frames []int) ([]int, []float64, error) {
+	var gains []float64
+	exposures, err := loadExposures(cfg, files)
+	if err != nil {
+		return nil, nil, err
+	}
+	if exposures != nil {
+		gains, _ = exposure.Gains(exposures)
+	}
+
+	indices := make([]int, 0, len(files))
+	values := make([]float64, 0, len(files))
+	for i, file := range files {
+		stopDecode := rec.Start("decode")
+		img, err := imageutils.LoadImage(file)
+		stopDecode()
+		if err != nil {
+			err = fmt.Errorf("failed to load image '%s': %w", file, err)
+			if cfg.Input.UnreadableFrames != "tolerant" {
+				return nil, nil, err
+			}
+			logger.Printf("warn: quick-look: excluding unreadable frame: %v\n", err)
+			continue
+		}
+		f, _ := imageutils.ConvertToFrame(img)
+		value := meanIntensity(f)
+		if gains != nil {
+			value *= gains[i]
+		}
+		indices = append(indices, i)
+		values = append(values, value)
+	}
+	return indices, values, nil
+}
+
+// meanIntensity оценивает среднюю интенсивность кадра по сетке пикселей с шагом intensitySampleStep.
+func meanIntensity(f frame.Frame) float64 {
+	bounds := f.Bounds()
+	buf := frame.RowBuffer(f)
+	var sum float64
+	var count int
+	for y := bounds.Min.Y; y < bounds.Max.Y; y += intensitySampleStep {
+		row := f.Row(y, buf)
+		for x := 0; x < len(row); x += intensitySampleStep {
+			sum += float64(row[x])
+			count++
+		}
+	}
+	return sum / float64(count)
+}
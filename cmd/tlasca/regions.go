@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"image"
+
+	"github.com/mascotmascot1/go-tlasca/internal/config"
+	"github.com/mascotmascot1/go-tlasca/internal/roi"
+)
+
+// buildRegions проверяет области интереса конфигурации и сопоставляет им имена:
+// пустое имя заменяется на "roi<номер>". Области файла ImageJ paths.regions_file
+// следуют за областями конфигурации.
+func buildRegions(cfg *config.Config, frame image.Rectangle) ([]roi.Region, error) {
+	regions := make([]roi.Region, len(cfg.Regions))
+	for i, rc := range cfg.Regions {
+		if len(rc.ROI) == 0 {
+			return nil, fmt.Errorf("region %d has no roi", i+1)
+		}
+		rect, err := roi.Parse(rc.ROI, frame)
+		if err != nil {
+			return nil, fmt.Errorf("invalid roi of region %d: %w", i+1, err)
+		}
+		name := rc.Name
+		if name == "" {
+			name = fmt.Sprintf("roi%d", i+1)
+		}
+		regions[i] = roi.Region{Name: name, Rect: rect}
+	}
+	if cfg.Paths.RegionsFile == "" {
+		return regions, nil
+	}
+	shapes, err := roi.ReadImageJ(cfg.Paths.RegionsFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading regions file '%s': %w", cfg.Paths.RegionsFile, err)
+	}
+	for _, shape := range shapes {
+		if !shape.Bounds.In(frame) {
+			return nil, fmt.Errorf("region '%s' of regions file '%s': bounds %v are outside the frame %v",
+				shape.Name, cfg.Paths.RegionsFile, shape.Bounds, frame)
+		}
+		region := shape.Region()
+		if region.Pixels() == 0 {
+			return nil, fmt.Errorf("region '%s' of regions file '%s' contains no pixels", shape.Name, cfg.Paths.RegionsFile)
+		}
+		regions = append(regions, region)
+	}
+	return regions, nil
+}
+
+// regionNames возвращает имена областей в порядке их задания.
+func regionNames(regions []roi.Region) []string {
+	names := make([]string, len(regions))
+	for i, r := range regions {
+		names[i] = r.Name
+	}
+	return names
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/mascotmascot1/go-tlasca/internal/config"
+	"github.com/mascotmascot1/go-tlasca/internal/render"
+	"github.com/mascotmascot1/go-tlasca/pkg/mask"
+	"github.com/mascotmascot1/go-tlasca/pkg/tlasca"
+)
+
+// salvagePartial сохраняет частичный результат расчета, прерванного отменой (Ctrl-C) или
+// ошибкой чтения кадров (см. tlasca.InterruptedError), и возвращает err, дополненную
+// списком сохраненных файлов. Сохраняются итоговая карта (нерассчитанные положения -
+// уровнем фона), карты без квантования (нерассчитанные положения - NaN) и маска
+// рассчитанных положений output.validity_mask_filename; остальные этапы не выполняются.
+// Если err не содержит частичного результата, она возвращается без изменений.
+func salvagePartial(cfg *config.Config, logger *log.Logger, normalizer render.Normalizer, err error) error {
+	var interrupted *tlasca.InterruptedError
+	if !errors.As(err, &interrupted) {
+		return err
+	}
+	result := interrupted.Result
+	incomplete := result.Incomplete.Count()
+	logger.Printf("warn: calculation interrupted: %v; saving partial result (%d of %d frames, %d of %d map positions)\n",
+		interrupted.Err, result.Frames, interrupted.Total, result.Width*result.Height-incomplete, result.Width*result.Height)
+	if mkErr := os.MkdirAll(cfg.Paths.ResultsDir, 0755); mkErr != nil {
+		return fmt.Errorf("%w (partial result not saved: %w)", err, mkErr)
+	}
+
+	// Маска рассчитанных положений строится до объединения: дальше нерассчитанные
+	// положения выводятся так же, как исключенные.
+	var validity *image.Gray
+	if cfg.Output.ValidityMaskFilename != "" {
+		validity = image.NewGray(image.Rect(0, 0, result.Width, result.Height))
+		for i, missing := range result.Incomplete.Set {
+			if !missing {
+				validity.Pix[i] = 255
+			}
+		}
+	}
+	result.Excluded = mask.Union(result.Excluded, result.Incomplete)
+
+	mapResult := outputMap(cfg, result)
+	scale := normalizer.Fit(mapResult.Contrast, mapResult.Excluded)
+	mapImage := render.FillBackground(render.Gray(mapResult, scale), result.Excluded, cfg.Output.Background)
+	images := []pngOutput{{filepath.Join(cfg.Paths.ResultsDir, cfg.Paths.OutputFilename), "partial result image",
+		resultImage(mapResult, scale, mapImage, cfg.Output.ResultBitDepth, cfg.Output.Background)}}
+	if validity != nil {
+		images = append(images, pngOutput{filepath.Join(cfg.Paths.ResultsDir, cfg.Output.ValidityMaskFilename), "validity mask", validity})
+	}
+	description := fmt.Sprintf("go-tlasca %s [%s], window %d, PARTIAL: %d of %d frames, excluded and missing positions NaN",
+		mapQuantity(cfg), mapResult.Units.Contrast, cfg.Algorithm.WindowSize, result.Frames, interrupted.Total)
+	outputs := slices.Concat(pngFiles(images),
+		matrixOutputs(cfg, floatContrast(mapResult), result.Width, result.Height, description, "partial "))
+	if saveErr := saveOutputs(outputs); saveErr != nil {
+		return fmt.Errorf("%w (partial result not saved: %w)", err, saveErr)
+	}
+	saved := make([]string, len(outputs))
+	for i, o := range outputs {
+		saved[i] = o.path
+	}
+	return fmt.Errorf("%w (partial result saved: %s)", err, strings.Join(saved, ", "))
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/mascotmascot1/go-tlasca/internal/config"
+	"github.com/mascotmascot1/go-tlasca/internal/denoise"
+	"github.com/mascotmascot1/go-tlasca/internal/events"
+	"github.com/mascotmascot1/go-tlasca/internal/imageutils"
+	"github.com/mascotmascot1/go-tlasca/internal/render"
+	"github.com/mascotmascot1/go-tlasca/internal/report"
+	"github.com/mascotmascot1/go-tlasca/internal/telemetry"
+	"github.com/mascotmascot1/go-tlasca/internal/worldfile"
+	"github.com/mascotmascot1/go-tlasca/pkg/frame"
+	"github.com/mascotmascot1/go-tlasca/pkg/tlasca"
+)
+
+// seriesFilename возвращает имя файла карты ряда, рассчитанной начиная с кадра file:
+// имя outputFilename с именем кадра ("result.png" и "12.png" дают "result_12.png").
+func seriesFilename(outputFilename, file string) string {
+	ext := filepath.Ext(outputFilename)
+	stem := strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+	return strings.TrimSuffix(outputFilename, ext) + "_" + stem + ext
+}
+
+// mapSeries возвращает число кадров, по которым рассчитывается одна карта ряда, и шаг
+// между первыми кадрами соседних карт: 1 и 1 для пространственного контраста,
+// temporal_depth и temporal_depth для пространственно-временного, temporal_window
+// и temporal_step (0 - равный окну) для скользящего окна временного контраста.
+// Для временного контраста без скользящего окна (одна карта по всей последовательности)
+// возвращает 0, 0.
+func mapSeries(cfg *config.Config) (length, step int) {
+	switch cfg.Algorithm.Mode {
+	case tlasca.ContrastSpatial:
+		return 1, 1
+	case tlasca.ContrastSpatiotemporal:
+		return cfg.Algorithm.TemporalDepth, cfg.Algorithm.TemporalDepth
+	}
+	if cfg.Algorithm.TemporalWindow > 0 {
+		if cfg.Algorithm.TemporalStep > 0 {
+			return cfg.Algorithm.TemporalWindow, cfg.Algorithm.TemporalStep
+		}
+		return cfg.Algorithm.TemporalWindow, cfg.Algorithm.TemporalWindow
+	}
+	return 0, 0
+}
+
+// seriesStarts возвращает индексы первых кадров карт ряда для последовательности из frames кадров.
+func seriesStarts(cfg *config.Config, frames int) []int {
+	length, step := mapSeries(cfg)
+	var starts []int
+	for start := 0; start+length <= frames; start += step {
+		starts = append(starts, start)
+	}
+	return starts
+}
+
+// seriesRun содержит данные запуска, общие с расчетом одной карты, для runSeries.
+type seriesRun struct {
+	startedAt time.Time
+	bitDepth  int
+	// area - участок кадра roi, по которому рассчитываются карты.
+	area image.Rectangle
+	// outputs - файлы, сохраненные до расчета карт (итоговая конфигурация, калибровка).
+	outputs []string
+	// plan - выходные файлы, проверенные до расчета (см. plannedOutputs).
+	plan []plannedOutput
+	// bus - шина событий запуска; warnings - собранные предупреждения (см. run).
+	bus      *events.Bus
+	warnings *runWarnings
+	// denoiser - фильтр подавления шума карт (nil - без фильтрации).
+	denoiser denoise.Filter
+	// template - шаблон отчета о запуске (nil - без отчета по шаблону).
+	template *report.Template
+	// onReport получает отчет о запуске (см. runOptions.onReport).
+	onReport func(rep *report.Report)
+}
+
+// runSeries рассчитывает ряд карт контраста (см. mapSeries): карты пространственного
+// (algorithm.mode = "spatial") контраста каждого кадра files, пространственно-временного
+// ("spatiotemporal") контраста каждой группы из temporal_depth кадров или временного
+// контраста каждого положения скользящего окна temporal_window, и сохраняет их
+// в директорию результатов вместе с отчетом о запуске. Кадры загружаются по одному кадру,
+// группе или окну; кадры после последней полной карты не используются (с предупреждением).
+// Группа с нечитаемым кадром пропускается. Шкала отображения подбирается для каждой
+// карты отдельно (для нормировки "fixed" она одинакова для всех карт).
+func runSeries(ctx context.Context, cfg *config.Config, logger *log.Logger, rec *telemetry.Recorder, runner *tlasca.Runner,
+	loader *frameLoader, normalizer render.Normalizer, files []string, opts tlasca.Options, run seriesRun) error {
+	if err := os.MkdirAll(cfg.Paths.ResultsDir, 0755); err != nil {
+		return fmt.Errorf("error creating results directory '%s': %w", cfg.Paths.ResultsDir, err)
+	}
+	transform := stageTransform(cfg, run.area)
+	if transform != nil {
+		// Как и для одной карты, центр пикселя карты смещен на (window_size-1)/2 пикселя кадра.
+		offset := float64(cfg.Algorithm.WindowSize-1) / 2
+		t := transform.Offset(offset, offset)
+		transform = &t
+	}
+
+	length, _ := mapSeries(cfg)
+	starts := seriesStarts(cfg, len(files))
+	if len(starts) == 0 {
+		return fmt.Errorf("%d frames are not enough for a map of %d frames", len(files), length)
+	}
+	if rest := len(files) - starts[len(starts)-1] - length; rest > 0 {
+		warning := fmt.Sprintf("last %d frames do not form a full map of %d frames and are ignored", rest, length)
+		run.bus.Warn(warning)
+	}
+	// Шум оценки контраста для фильтра: во временном режиме - по кадрам окна с усреднением
+	// окном window_size, в покадровых режимах - по отсчетам окна (объема) одной оценки.
+	ws2 := cfg.Algorithm.WindowSize * cfg.Algorithm.WindowSize
+	noise := denoise.RelativeNoise(ws2*length, 1)
+	if cfg.Algorithm.Mode != tlasca.ContrastSpatial && cfg.Algorithm.Mode != tlasca.ContrastSpatiotemporal {
+		noise = denoise.RelativeNoise(length, ws2)
+	}
+
+	outputs := slices.Clone(run.outputs)
+	maps := 0
+	save := func(start int, result *tlasca.Result) error {
+		if run.denoiser != nil {
+			stopDenoise := rec.Start("denoise")
+			result.Contrast = run.denoiser.Apply(result.Contrast, result.Width, result.Height, result.Excluded, noise)
+			stopDenoise()
+		}
+		path := filepath.Join(cfg.Paths.ResultsDir, seriesFilename(cfg.Paths.OutputFilename, files[start]))
+		stopSave := rec.Start("save")
+		mapResult := outputMap(cfg, result)
+		scale := normalizer.Fit(mapResult.Contrast, mapResult.Excluded)
+		var img image.Image
+		if cfg.Output.ResultBitDepth == 16 {
+			img = render.FillBackground(render.Gray16(mapResult, scale), result.Excluded, cfg.Output.Background)
+		} else {
+			img = render.FillBackground(render.Gray(mapResult, scale), result.Excluded, cfg.Output.Background)
+		}
+		err := imageutils.SavePNG(path, img)
+		stopSave()
+		if err != nil {
+			return fmt.Errorf("error saving contrast map to '%s': %w", path, err)
+		}
+		outputs = append(outputs, path)
+		if transform != nil {
+			worldPath := worldfile.SidecarPath(path)
+			if err = worldfile.Write(worldPath, *transform); err != nil {
+				return err
+			}
+			outputs = append(outputs, worldPath)
+		}
+		maps++
+		if maps%100 == 0 || maps == len(starts) {
+			logger.Printf("processed maps %d of %d.\n", maps, len(starts))
+		}
+		return nil
+	}
+
+	if cfg.Algorithm.TemporalWindow > 0 {
+		if err := runner.RunSliding(ctx, len(files), func(start, end int) ([]frame.Frame, error) {
+			return loader.load(start, files[start:end])
+		}, opts, save); err != nil {
+			return err
+		}
+	} else {
+		logger.Printf("starting %s contrast calculation (%d frames)...\n", cfg.Algorithm.Mode, len(files))
+		for _, start := range starts {
+			if err := ctx.Err(); err != nil {
+				return fmt.Errorf("calculation cancelled: %w", err)
+			}
+			images, err := loader.load(start, files[start:start+length])
+			if err != nil {
+				return err
+			}
+			if slices.Contains(images, nil) {
+				continue
+			}
+			gains := make([]float64, length)
+			for j := range gains {
+				gains[j] = 1.0
+				if opts.Gains != nil {
+					gains[j] = opts.Gains[start+j]
+				}
+			}
+			var result *tlasca.Result
+			if length == 1 {
+				result = runner.RunSpatial(images[0], gains[0], opts.Exclusion)
+			} else {
+				result = runner.RunSpatiotemporal(images, gains, opts.Exclusion)
+			}
+			if opts.IntensityUnit != "" {
+				result.Units.Intensity = opts.IntensityUnit
+			}
+			if err = save(start, result); err != nil {
+				return err
+			}
+		}
+		logger.Println("calculation finished.")
+	}
+
+	return finishSeries(cfg, logger, rec, loader, files, run, outputs)
+}
+
+// finishSeries дополняет предупреждения запуска ряда карт сведениями о пропущенных
+// и пересвеченных кадрах, выводит телеметрию и сохраняет отчет о запуске.
+func finishSeries(cfg *config.Config, logger *log.Logger, rec *telemetry.Recorder, loader *frameLoader,
+	files []string, run seriesRun, outputs []string) error {
+	var skippedFrames []report.SkippedFrame
+	for _, skipped := range loader.skippedFrames {
+		skippedFrames = append(skippedFrames, report.SkippedFrame{
+			Index: skipped.Index + 1,
+			File:  skipped.Path,
+			Error: skipped.Err.Error(),
+		})
+	}
+	if len(skippedFrames) > 0 {
+		warning := fmt.Sprintf("%d of %d frames were unreadable and skipped, first: %s",
+			len(skippedFrames), len(files), filepath.Base(skippedFrames[0].File))
+		run.bus.Warn(warning)
+	}
+
+	if warning := loader.saturationWarning(cfg.Input.SaturationWarnFraction, run.bitDepth); warning != "" {
+		run.bus.Warn(warning)
+	}
+
+	warnUnplanned(run.bus, run.plan, outputs)
+	rec.LogSummary()
+	if cfg.Paths.ReportFilename != "" || run.template != nil || run.onReport != nil {
+		rep := &report.Report{
+			StartedAt: run.startedAt,
+			Duration:  time.Since(run.startedAt),
+			Config:    cfg,
+			Frames:    len(files),
+			BitDepth:  run.bitDepth,
+			Skipped:   skippedFrames,
+			Warnings:  run.warnings.Warnings(),
+			Outputs:   outputs,
+			Stages:    rec.Stages(),
+		}
+		if err := saveRunReport(cfg, logger, rep, run.template); err != nil {
+			return err
+		}
+		if run.onReport != nil {
+			run.onReport(rep)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"time"
+
+	"github.com/mascotmascot1/go-tlasca/internal/events"
+	"github.com/mascotmascot1/go-tlasca/internal/report"
+	"github.com/mascotmascot1/go-tlasca/pkg/tlasca"
+)
+
+// runServeCommand выполняет подкоманду serve: HTTP-сервер, выполняющий расчеты (run)
+// и проверки (validate) по go-tlasca.json рабочей директории по запросу:
+//
+//	POST /run[?overwrite=true]       расчет; ответ - отчет о запуске (JSON)
+//	POST /validate[?overwrite=true]  проверка без расчета
+//	GET  /status                     текущий запрос и выполнение расчета
+//
+// Одновременно выполняется один запрос, остальные получают ответ 409. Отключение клиента
+// отменяет его расчет; Ctrl-C отменяет текущий расчет и останавливает сервер.
+func runServeCommand(logger *log.Logger, args []string) error {
+	flags := newFlagSet("serve", "[--addr host:port]")
+	addr := flags.String("addr", "localhost:8080", "address to listen on")
+	if err := parseFlags(flags, args, 0); err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	s := &server{logger: logger}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /run", s.handleRun(false))
+	mux.HandleFunc("POST /validate", s.handleRun(true))
+	mux.HandleFunc("GET /status", s.handleStatus)
+	srv := &http.Server{
+		Addr:    *addr,
+		Handler: mux,
+		// Запросы наследуют контекст сервера: остановка сервера отменяет текущий расчет.
+		BaseContext: func(net.Listener) context.Context { return ctx },
+	}
+
+	listener, err := net.Listen("tcp", *addr)
+	if err != nil {
+		return err
+	}
+	logger.Printf("serving on http://%s (POST /run, POST /validate, GET /status); press Ctrl-C to stop.\n", listener.Addr())
+	served := make(chan error, 1)
+	go func() { served <- srv.Serve(listener) }()
+	select {
+	case err = <-served:
+		return err
+	case <-ctx.Done():
+	}
+	logger.Println("warn: interrupt received, stopping the server...")
+	if err = srv.Shutdown(context.Background()); err != nil {
+		return err
+	}
+	if err = <-served; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// serverStatus - состояние сервера (ответ GET /status).
+type serverStatus struct {
+	// Command - выполняемый запрос ("run" или "validate"); пустая строка, если сервер свободен.
+	Command string `json:"command,omitempty"`
+	// Started - время начала текущего запроса.
+	Started *time.Time `json:"started,omitempty"`
+	// Progress - последний отчет о выполнении этапа расчета.
+	Progress *tlasca.Progress `json:"progress,omitempty"`
+}
+
+// server выполняет запросы подкоманды serve по одному.
+type server struct {
+	logger *log.Logger
+	mu     sync.Mutex
+	status serverStatus
+}
+
+// handleRun возвращает обработчик запроса расчета (или проверки, если validate).
+func (s *server) handleRun(validate bool) http.HandlerFunc {
+	command := "run"
+	if validate {
+		command = "validate"
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		started := time.Now()
+		s.mu.Lock()
+		if s.status.Command != "" {
+			s.mu.Unlock()
+			s.writeJSON(w, http.StatusConflict, map[string]string{"error": fmt.Sprintf("'%s' is already running", s.status.Command)})
+			return
+		}
+		s.status = serverStatus{Command: command, Started: &started}
+		s.mu.Unlock()
+		defer func() {
+			s.mu.Lock()
+			s.status = serverStatus{}
+			s.mu.Unlock()
+		}()
+
+		s.logger.Printf("%s requested by %s.\n", command, r.RemoteAddr)
+		// Отчет берется у завершенного запуска, а не перечитывается с диска: так он
+		// соответствует конфигурации, с которой запуск выполнен на самом деле.
+		var rep *report.Report
+		opts := runOptions{
+			overwrite: r.URL.Query().Get("overwrite") == "true",
+			validate:  validate,
+			onReport:  func(finished *report.Report) { rep = finished },
+		}
+		bus := events.New()
+		onProgress(bus, s.report)
+		if err := run(r.Context(), s.logger, bus, opts); err != nil {
+			s.logger.Printf("warn: %s failed: %v\n", command, err)
+			s.writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			return
+		}
+		if validate {
+			s.writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+			return
+		}
+		if rep == nil {
+			// Исполнитель распределенного расчета не формирует отчет.
+			s.writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+			return
+		}
+		data, err := json.MarshalIndent(rep, "", "    ")
+		if err != nil {
+			s.writeJSON(w, http.StatusOK, map[string]string{"status": "ok", "report_error": err.Error()})
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if _, err := w.Write(data); err != nil {
+			s.logger.Printf("warn: failed to send the %s report to %s: %v\n", command, r.RemoteAddr, err)
+		}
+	}
+}
+
+// report сохраняет отчет о выполнении этапа расчета для GET /status.
+func (s *server) report(p tlasca.Progress) {
+	s.mu.Lock()
+	s.status.Progress = &p
+	s.mu.Unlock()
+}
+
+// handleStatus отвечает на GET /status.
+func (s *server) handleStatus(w http.ResponseWriter, _ *http.Request) {
+	s.mu.Lock()
+	status := s.status
+	s.mu.Unlock()
+	s.writeJSON(w, http.StatusOK, status)
+}
+
+// writeJSON отвечает кодом code и значением v в формате JSON. Ошибка отправки ответа
+// (например, клиент закрыл соединение) записывается в журнал.
+func (s *server) writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		s.logger.Printf("warn: failed to send response: %v\n", err)
+	}
+}
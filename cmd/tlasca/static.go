@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/mascotmascot1/go-tlasca/internal/config"
+	"github.com/mascotmascot1/go-tlasca/internal/render"
+	"github.com/mascotmascot1/go-tlasca/internal/speckle"
+	"github.com/mascotmascot1/go-tlasca/pkg/tlasca"
+)
+
+// separateStaticScattering разделяет статическое и динамическое рассеяние карты result
+// по параметрам секции static_scattering. Возвращает разделение, сводку и 16-битные карты
+// доли статического рассеяния и индекса движения для сохранения (если их имена заданы).
+func separateStaticScattering(cfg *config.Config, result *tlasca.Result) (*speckle.Separation, speckle.SeparationSummary, []pngOutput, error) {
+	staticCfg := cfg.StaticScattering
+	if staticCfg.FlowMax < 0 {
+		return nil, speckle.SeparationSummary{}, nil, fmt.Errorf("invalid static_scattering: flow_max must be non-negative, got %g", staticCfg.FlowMax)
+	}
+	separation, err := speckle.Separate(result, cfg.Algorithm.WindowSize, staticCfg.Beta)
+	if err != nil {
+		return nil, speckle.SeparationSummary{}, nil, fmt.Errorf("invalid static_scattering: %w", err)
+	}
+	summary := separation.Summarize(staticCfg.Beta, result.Frames)
+
+	var outputs []pngOutput
+	if staticCfg.StaticFilename != "" {
+		path := filepath.Join(cfg.Paths.ResultsDir, staticCfg.StaticFilename)
+		outputs = append(outputs, pngOutput{path, "static fraction map",
+			render.Gray16Plane(separation.Static, separation.Width, separation.Height, render.Range{Min: 0, Max: 1})})
+	}
+	if staticCfg.FlowFilename != "" {
+		flowMax := staticCfg.FlowMax
+		if flowMax == 0 {
+			flowMax = render.Percentile(separation.Flow, separation.Skip, 99)
+		}
+		if flowMax <= 0 {
+			// Движение нигде не обнаружено: карта индекса нулевая при любой шкале.
+			flowMax = 1
+		}
+		summary.FlowMax = flowMax
+		path := filepath.Join(cfg.Paths.ResultsDir, staticCfg.FlowFilename)
+		outputs = append(outputs, pngOutput{path, "flow index map",
+			render.Gray16Plane(separation.Flow, separation.Width, separation.Height, render.Range{Min: 0, Max: flowMax})})
+	}
+	return separation, summary, outputs, nil
+}
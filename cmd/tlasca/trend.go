@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"path/filepath"
+
+	"github.com/mascotmascot1/go-tlasca/internal/config"
+	"github.com/mascotmascot1/go-tlasca/internal/imageutils"
+	"github.com/mascotmascot1/go-tlasca/internal/render"
+	"github.com/mascotmascot1/go-tlasca/internal/report"
+	"github.com/mascotmascot1/go-tlasca/pkg/frame"
+	"github.com/mascotmascot1/go-tlasca/pkg/tlasca"
+	"github.com/mascotmascot1/go-tlasca/pkg/units"
+)
+
+// Величины, тренд которых рассчитывается (trend.signal).
+const (
+	trendIntensity = "intensity"
+	trendContrast  = "contrast"
+)
+
+// trendMap - карта наклона линейного тренда во времени.
+type trendMap struct {
+	// slopes - наклоны построчно, в геометрии кадра (тренд интенсивности)
+	// или карты (тренд контраста); исключенные положения - NaN.
+	slopes        []float64
+	width, height int
+	// unit - единица наклона.
+	unit units.Unit
+}
+
+// runTrend рассчитывает карту наклона линейного тренда величины trend.signal для
+// последовательности files (кадры берутся из памяти или повторно читаются, как
+// в runContributions). times - времена регистрации кадров в секундах: если они заданы,
+// наклон регрессируется по реальному времени и выражается за секунду, иначе - за кадр.
+func runTrend(ctx context.Context, cfg *config.Config, runner *tlasca.Runner, loader *frameLoader, files []string,
+	frames []frame.Frame, result *tlasca.Result, opts tlasca.Options, chunkSize int, times []float64) (*trendMap, error) {
+	load := sequenceLoader(loader, files, frames)
+	opts.Times = times
+	var t trendMap
+	var err error
+	var unit units.Unit
+	switch cfg.Trend.Signal {
+	case trendIntensity:
+		t.width, t.height, unit = result.FrameWidth, result.FrameHeight, result.Units.Intensity
+		t.slopes, err = runner.IntensityTrend(ctx, len(files), chunkSize, load, opts)
+	case trendContrast:
+		t.width, t.height, unit = result.Width, result.Height, result.Units.Contrast
+		t.slopes, err = runner.ContrastTrend(ctx, len(files), cfg.Trend.SegmentFrames, load, opts)
+	default:
+		return nil, fmt.Errorf("unknown trend signal '%s', expected '%s' or '%s'", cfg.Trend.Signal, trendIntensity, trendContrast)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error computing %s trend: %w", cfg.Trend.Signal, err)
+	}
+
+	per := "frame"
+	if times != nil {
+		per = string(units.Second)
+	}
+	if unit == units.Dimensionless {
+		t.unit = units.Unit("1/" + per)
+	} else {
+		t.unit = units.Unit(string(unit) + "/" + per)
+	}
+	return &t, nil
+}
+
+// outputs возвращает сводку карты для отчета, 16-битное изображение карты (если задано
+// trend.filename) и файл карты без квантования (если задано trend.float_filename).
+func (t *trendMap) outputs(cfg *config.Config) (*report.Trend, []pngOutput, []fileOutput, error) {
+	if cfg.Trend.Range < 0 {
+		return nil, nil, nil, fmt.Errorf("invalid trend: range must be non-negative, got %g", cfg.Trend.Range)
+	}
+	limit := cfg.Trend.Range
+	if limit == 0 {
+		magnitudes := make([]float64, len(t.slopes))
+		for i, v := range t.slopes {
+			magnitudes[i] = math.Abs(v)
+		}
+		limit = render.Percentile(magnitudes, nil, 99)
+	}
+	if limit <= 0 {
+		// Тренда нет нигде: карта постоянна при любой шкале.
+		limit = 1
+	}
+	summary := &report.Trend{
+		Signal: cfg.Trend.Signal,
+		Range:  [2]float64{-limit, limit},
+		Slope:  report.NewMapStats(t.slopes, nil, t.unit),
+	}
+	if cfg.Trend.Signal == trendContrast {
+		summary.SegmentFrames = cfg.Trend.SegmentFrames
+	}
+
+	var images []pngOutput
+	if cfg.Trend.Filename != "" {
+		images = append(images, pngOutput{filepath.Join(cfg.Paths.ResultsDir, cfg.Trend.Filename), "trend slope map",
+			render.Gray16Plane(t.slopes, t.width, t.height, render.Range{Min: -limit, Max: limit})})
+	}
+	var files []fileOutput
+	if cfg.Trend.FloatFilename != "" {
+		description := fmt.Sprintf("go-tlasca %s trend slope [%s], excluded positions NaN", cfg.Trend.Signal, t.unit)
+		files = append(files, fileOutput{
+			path: filepath.Join(cfg.Paths.ResultsDir, cfg.Trend.FloatFilename),
+			what: "float trend slope map",
+			write: func(path string) error {
+				return imageutils.SaveFloatTIFF(path, t.slopes, t.width, t.height, description)
+			},
+		})
+	}
+	return summary, images, files, nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/mascotmascot1/go-tlasca/internal/config"
+	"github.com/mascotmascot1/go-tlasca/internal/roi"
+	"github.com/mascotmascot1/go-tlasca/internal/telemetry"
+	"github.com/mascotmascot1/go-tlasca/internal/timestamps"
+	"github.com/mascotmascot1/go-tlasca/internal/vasomotion"
+	"github.com/mascotmascot1/go-tlasca/pkg/tlasca"
+)
+
+// runVasomotion строит ряды индекса кровотока 1/K² областей интереса, рассчитывает их спектры
+// и находит пики в полосе вазомоций. times - времена регистрации кадров в секундах (nil,
+// если временные метки не заданы): ряды приводятся к равномерной сетке с частотой frame_rate
+// или, если она не указана, с медианным межкадровым интервалом. Возвращает найденные пики, путь к CSV-файлу спектров и предупреждения для областей,
+// спектр которых не покрывает полосу.
+func runVasomotion(cfg *config.Config, rec *telemetry.Recorder, regions []roi.Region, load tlasca.ChunkLoader,
+	total int, opts tlasca.Options, chunkSize int, times []float64) ([]vasomotion.Peak, string, []string, error) {
+	defer rec.Start("vasomotion")()
+	sampleRate := cfg.Vasomotion.FrameRate
+	if sampleRate <= 0 && times != nil {
+		sampleRate = 1 / timestamps.Summarize(times).MedianInterval
+	}
+	if sampleRate <= 0 {
+		return nil, "", nil, fmt.Errorf("vasomotion analysis needs frame_rate or timestamps_file")
+	}
+	if len(regions) == 0 {
+		return nil, "", nil, fmt.Errorf("vasomotion analysis needs at least 1 region")
+	}
+
+	series, err := roi.Series(load, total, chunkSize, regions, roi.Contrast, opts.Gains)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	var peaks []vasomotion.Peak
+	var warnings []string
+	spectra := make([]vasomotion.Spectrum, len(regions))
+	for i, region := range regions {
+		flow := vasomotion.FlowIndex(series[i])
+		if times != nil {
+			flow = timestamps.Resample(times, flow, 1/sampleRate)
+		}
+		spectra[i] = vasomotion.PowerSpectrum(flow, sampleRate)
+		peak, ok := vasomotion.FindPeak(region.Name, spectra[i], cfg.Vasomotion.BandMin, cfg.Vasomotion.BandMax)
+		if !ok {
+			warnings = append(warnings, fmt.Sprintf("region '%s': recording of %d samples at %.4g Hz is too short to resolve the vasomotion band [%g, %g] Hz",
+				region.Name, len(flow), sampleRate, cfg.Vasomotion.BandMin, cfg.Vasomotion.BandMax))
+			continue
+		}
+		peaks = append(peaks, peak)
+	}
+
+	path := filepath.Join(cfg.Paths.ResultsDir, cfg.Vasomotion.Filename)
+	if err = vasomotion.WriteCSV(path, regionNames(regions), spectra); err != nil {
+		return nil, "", nil, fmt.Errorf("error saving vasomotion spectra to '%s': %w", path, err)
+	}
+	return peaks, path, warnings, nil
+}
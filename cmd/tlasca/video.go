@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/mascotmascot1/go-tlasca/internal/config"
+	"github.com/mascotmascot1/go-tlasca/internal/telemetry"
+	"github.com/mascotmascot1/go-tlasca/internal/video"
+)
+
+// extractVideo декодирует видеофайл paths.video через ffmpeg во временную директорию
+// кадров и возвращает ее путь; директорию удаляет вызывающий после завершения запуска.
+func extractVideo(cfg *config.Config, logger *log.Logger, rec *telemetry.Recorder) (string, error) {
+	defer rec.Start("video")()
+	if _, err := os.Stat(cfg.Paths.Video); err != nil {
+		return "", fmt.Errorf("video file '%s' not found: %w", cfg.Paths.Video, err)
+	}
+	info, err := video.Probe(cfg.Paths.FFprobe, cfg.Paths.Video)
+	if err != nil {
+		return "", err
+	}
+	logger.Printf("decoding video '%s' (%dx%d, %s) to %d-bit frames...\n",
+		cfg.Paths.Video, info.Width, info.Height, info.PixelFormat, info.BitDepth)
+	dir, err := video.TempDir(cfg.Paths.Video)
+	if err != nil {
+		return "", fmt.Errorf("error creating directory for video frames: %w", err)
+	}
+	frames, err := video.Extract(cfg.Paths.FFmpeg, cfg.Paths.Video, info, dir, func(frames int) {
+		if frames%500 == 0 {
+			logger.Printf("decoded %d frames.\n", frames)
+		}
+	})
+	if err != nil {
+		_ = os.RemoveAll(dir)
+		return "", err
+	}
+	logger.Printf("decoded %d frames from video.\n", frames)
+	return dir, nil
+}
@@ -0,0 +1,82 @@
+// Package agreement сравнивает парные оценки одной величины (например, карты контраста
+// двух запусков по одним положениям окна): смещение и пределы согласия Бланда-Альтмана,
+// размер эффекта и корреляцию. Используется для сравнения методов и сеансов записи.
+package agreement
+
+import "math"
+
+// Stats - показатели согласия парных значений a[i], b[i].
+type Stats struct {
+	// N - число пар.
+	N int `json:"n"`
+	// MeanA, MeanB - средние значения a и b.
+	MeanA float64 `json:"mean_a"`
+	MeanB float64 `json:"mean_b"`
+	// Bias - среднее разностей b - a (смещение Бланда-Альтмана).
+	Bias float64 `json:"bias"`
+	// RelativeBias - смещение в долях MeanA (0, если MeanA равно 0).
+	RelativeBias float64 `json:"relative_bias"`
+	// SD - стандартное отклонение разностей (выборочное, с N-1 в знаменателе).
+	SD float64 `json:"sd"`
+	// RMS - среднеквадратичная разность.
+	RMS float64 `json:"rms"`
+	// Lower, Upper - пределы согласия Bias ∓ 1.96·SD: в них лежит около 95% разностей
+	// при нормальном распределении.
+	Lower float64 `json:"lower_limit"`
+	Upper float64 `json:"upper_limit"`
+	// Correlation - коэффициент корреляции Пирсона a и b.
+	Correlation float64 `json:"correlation"`
+	// CohenD - размер эффекта (MeanB - MeanA) / s, где s - объединенное стандартное
+	// отклонение a и b: различие средних относительно разброса значений.
+	CohenD float64 `json:"cohen_d"`
+	// PairedD - размер эффекта парных разностей Bias / SD (Cohen's dz).
+	PairedD float64 `json:"paired_d"`
+}
+
+// Compare рассчитывает показатели согласия пар (a[i], b[i]). Срезы должны иметь
+// одинаковую длину; при числе пар меньше 2 рассчитываются только средние.
+func Compare(a, b []float64) Stats {
+	s := Stats{N: len(a)}
+	if s.N == 0 {
+		return s
+	}
+	n := float64(s.N)
+	var sumA, sumB float64
+	for i := range a {
+		sumA += a[i]
+		sumB += b[i]
+	}
+	s.MeanA, s.MeanB = sumA/n, sumB/n
+	s.Bias = s.MeanB - s.MeanA
+	if s.MeanA != 0 {
+		s.RelativeBias = s.Bias / s.MeanA
+	}
+	if s.N < 2 {
+		return s
+	}
+
+	// Второй проход по отклонениям от средних устойчивее формулы через суммы квадратов.
+	var varA, varB, cov, varD, sumSqD float64
+	for i := range a {
+		da, db := a[i]-s.MeanA, b[i]-s.MeanB
+		d := b[i] - a[i]
+		varA += da * da
+		varB += db * db
+		cov += da * db
+		varD += (d - s.Bias) * (d - s.Bias)
+		sumSqD += d * d
+	}
+	s.SD = math.Sqrt(varD / (n - 1))
+	s.RMS = math.Sqrt(sumSqD / n)
+	s.Lower, s.Upper = s.Bias-1.96*s.SD, s.Bias+1.96*s.SD
+	if varA > 0 && varB > 0 {
+		s.Correlation = cov / math.Sqrt(varA*varB)
+	}
+	if pooled := math.Sqrt((varA + varB) / (2 * (n - 1))); pooled > 0 {
+		s.CohenD = s.Bias / pooled
+	}
+	if s.SD > 0 {
+		s.PairedD = s.Bias / s.SD
+	}
+	return s
+}
@@ -0,0 +1,143 @@
+// Package atomicfile выполняет атомарную запись файлов: данные записываются во временный
+// файл в той же директории, а затем переименовываются в целевой путь. Прерванный запуск
+// (нехватка места, завершение процесса) не оставляет усеченных файлов, похожих на результат:
+// целевой файл либо не появляется вовсе, либо содержит полностью записанные данные.
+//
+// В директориях, защищенных Protect, существующие файлы не заменяются: файл публикуется
+// созданием нового имени (жесткой ссылкой), и если имя уже занято (например, другим
+// запуском, пишущим в ту же директорию), запись завершается ошибкой fs.ErrExist.
+package atomicfile
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// protected - директории (абсолютные пути), защищенные Protect, с числом активных защит.
+var (
+	protectedMu sync.Mutex
+	protected   = map[string]int{}
+)
+
+// Protect запрещает Write заменять существующие файлы в директории dir и ее поддиректориях
+// до вызова возвращаемой функции. Защиты одной директории (например, одновременных запусков
+// в режиме сервера) суммируются: директория защищена, пока действует хотя бы одна.
+func Protect(dir string) (release func()) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		abs = filepath.Clean(dir)
+	}
+	protectedMu.Lock()
+	protected[abs]++
+	protectedMu.Unlock()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			protectedMu.Lock()
+			if protected[abs]--; protected[abs] == 0 {
+				delete(protected, abs)
+			}
+			protectedMu.Unlock()
+		})
+	}
+}
+
+// isProtected сообщает, находится ли path в директории, защищенной Protect.
+func isProtected(path string) bool {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	protectedMu.Lock()
+	defer protectedMu.Unlock()
+	for dir := range protected {
+		if rel, err := filepath.Rel(dir, abs); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// publishNew публикует временный файл tmp под именем path, только если такого файла нет.
+// Жесткая ссылка создает имя атомарно и завершается ошибкой, если оно занято; на файловых
+// системах без жестких ссылок имя предварительно резервируется созданием пустого файла
+// (O_EXCL), который затем заменяется переименованием.
+func publishNew(tmp, path string) error {
+	err := os.Link(tmp, path)
+	if err == nil {
+		return os.Remove(tmp)
+	}
+	if errors.Is(err, fs.ErrExist) {
+		return &fs.PathError{Op: "publish", Path: path, Err: fs.ErrExist}
+	}
+	placeholder, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	if err = placeholder.Close(); err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(path)
+	}
+	return err
+}
+
+// Write записывает файл path данными, которые fn выводит в переданный (буферизованный) writer.
+// Если fn или запись завершаются ошибкой, временный файл удаляется, а существующий
+// файл path остается без изменений. В защищенной директории (см. Protect) существующий
+// файл path не заменяется: возвращается ошибка, для которой errors.Is(err, fs.ErrExist).
+func Write(path string, fn func(w io.Writer) error) (err error) {
+	dir, name := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+	tmp, err := os.CreateTemp(dir, "."+name+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	w := bufio.NewWriter(tmp)
+	if err = fn(w); err != nil {
+		return err
+	}
+	if err = w.Flush(); err != nil {
+		return err
+	}
+	// Данные сбрасываются на диск до переименования, иначе после сбоя питания
+	// под целевым именем может оказаться пустой файл.
+	if err = tmp.Sync(); err != nil {
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	// os.CreateTemp создает файл с правами 0600; результаты должны быть доступны как обычные файлы.
+	if err = os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	if isProtected(path) {
+		return publishNew(tmp.Name(), path)
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// WriteFile атомарно записывает data в файл path (аналог os.WriteFile).
+func WriteFile(path string, data []byte) error {
+	return Write(path, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
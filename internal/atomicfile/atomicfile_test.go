@@ -0,0 +1,60 @@
+package atomicfile
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestProtect проверяет, что в защищенной директории существующие файлы не заменяются,
+// новые публикуются, а после снятия защиты файлы снова заменяются.
+func TestProtect(t *testing.T) {
+	dir := t.TempDir()
+	existing := filepath.Join(dir, "result.png")
+	if err := WriteFile(existing, []byte("first run")); err != nil {
+		t.Fatal(err)
+	}
+
+	release := Protect(dir)
+	err := WriteFile(existing, []byte("second run"))
+	if !errors.Is(err, fs.ErrExist) {
+		t.Fatalf("WriteFile over an existing file in a protected directory: got %v, want fs.ErrExist", err)
+	}
+	checkContent(t, existing, "first run")
+	fresh := filepath.Join(dir, "tiles", "0.png")
+	if err = os.MkdirAll(filepath.Dir(fresh), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err = WriteFile(fresh, []byte("tile")); err != nil {
+		t.Fatalf("WriteFile of a new file in a protected directory: %v", err)
+	}
+	checkContent(t, fresh, "tile")
+	release()
+
+	if err = WriteFile(existing, []byte("second run")); err != nil {
+		t.Fatalf("WriteFile after release: %v", err)
+	}
+	checkContent(t, existing, "second run")
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("directory has %d entries, want 2 (temporary files must be removed)", len(entries))
+	}
+}
+
+// checkContent сообщает, если содержимое файла path отличается от want.
+func checkContent(t *testing.T, path, want string) {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != want {
+		t.Errorf("%s contains %q, want %q", filepath.Base(path), data, want)
+	}
+}
@@ -0,0 +1,128 @@
+// Package biospeckle вычисляет индексы активности биоспеклов, принятые в анализе
+// биологических и сельскохозяйственных образцов (семена, плоды, покрытия): момент инерции
+// (inertia moment, IM) и среднюю абсолютную разность (absolute value of the differences, AVD)
+// матрицы совпадений (co-occurrence matrix, COM) временной истории спекл-картины (THSP).
+//
+// Элемент COM[i][j] - число переходов интенсивности пикселя из уровня i в уровень j
+// между соседними кадрами. По нормированной на общее число переходов матрице M
+// (Cardoso et al., 2011)
+//
+//	IM  = Σ M[i][j]·(i - j)²,  AVD = Σ M[i][j]·|i - j|,
+//
+// т.е. средние квадрат и модуль изменения интенсивности за кадр. Чем активнее образец
+// (движение рассеивателей, биологические процессы), тем быстрее меняется спекл-картина
+// и тем больше индексы. THSP каждого пикселя дает карту индексов, а переходы всех
+// пикселей - индексы образца в целом (среднее карты). Интенсивность берется в уровнях
+// входных данных (без нормировки к полной шкале и поправки экспозиции), как в литературе.
+package biospeckle
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/mascotmascot1/go-tlasca/internal/parallel"
+	"github.com/mascotmascot1/go-tlasca/pkg/frame"
+	"github.com/mascotmascot1/go-tlasca/pkg/tlasca"
+)
+
+// Activity - карты индексов активности в геометрии кадра (y*Width + x).
+type Activity struct {
+	Width, Height int
+	// IM и AVD - индексы THSP каждого пикселя (уровни² и уровни интенсивности).
+	IM, AVD []float64
+	// Pairs - число пар соседних кадров, по которым рассчитаны индексы.
+	Pairs int
+}
+
+// Summary - индексы активности образца в целом.
+type Summary struct {
+	// Pairs - число пар соседних кадров.
+	Pairs int `json:"pairs"`
+	// IM и AVD - индексы по переходам всех пикселей кадра (уровни² и уровни интенсивности).
+	IM  float64 `json:"im"`
+	AVD float64 `json:"avd"`
+	// IMMax и AVDMax - значения, отображенные в 65535 в PNG-файлах карт (0, если карта не сохранялась).
+	IMMax  float64 `json:"im_max,omitempty"`
+	AVDMax float64 `json:"avd_max,omitempty"`
+}
+
+// Compute читает последовательность из total кадров через load порциями по chunkSize
+// (chunkSize <= 0 - одной порцией) и рассчитывает карты индексов активности.
+// Переходы учитываются только между соседними кадрами последовательности: пропущенный
+// (nil) кадр прерывает THSP, и переходы через него не учитываются. Возвращает ошибку,
+// если загрузка порции завершилась неудачно, размеры кадров различаются или нет ни одной
+// пары соседних читаемых кадров.
+func Compute(load tlasca.ChunkLoader, total, chunkSize int) (*Activity, error) {
+	if chunkSize <= 0 {
+		chunkSize = total
+	}
+	var a *Activity
+	var prev frame.Frame
+	for start := 0; start < total; start += chunkSize {
+		end := min(start+chunkSize, total)
+		images, err := load(start, end)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load chunk [%d, %d): %w", start, end, err)
+		}
+		for i, img := range images {
+			if img == nil {
+				prev = nil
+				continue
+			}
+			bounds := img.Bounds()
+			if a == nil {
+				n := bounds.Dx() * bounds.Dy()
+				a = &Activity{Width: bounds.Dx(), Height: bounds.Dy(), IM: make([]float64, n), AVD: make([]float64, n)}
+			} else if bounds.Dx() != a.Width || bounds.Dy() != a.Height {
+				return nil, fmt.Errorf("frame %d size %v differs from the first frame", start+i, bounds.Size())
+			}
+			if prev != nil {
+				a.add(prev, img)
+			}
+			prev = img
+		}
+	}
+	if a == nil || a.Pairs == 0 {
+		return nil, fmt.Errorf("at least 2 consecutive readable frames are required")
+	}
+	parallel.Rows(a.Height, func(startY, endY int) {
+		for i := startY * a.Width; i < endY*a.Width; i++ {
+			a.IM[i] /= float64(a.Pairs)
+			a.AVD[i] /= float64(a.Pairs)
+		}
+	})
+	return a, nil
+}
+
+// add учитывает переходы интенсивности пикселей от кадра prev к следующему кадру next.
+// Строки обрабатываются параллельно.
+func (a *Activity) add(prev, next frame.Frame) {
+	a.Pairs++
+	prevMin, nextMin := prev.Bounds().Min.Y, next.Bounds().Min.Y
+	parallel.Rows(a.Height, func(startY, endY int) {
+		prevBuf, nextBuf := frame.RowBuffer(prev), frame.RowBuffer(next)
+		for y := startY; y < endY; y++ {
+			from, to := prev.Row(prevMin+y, prevBuf), next.Row(nextMin+y, nextBuf)
+			im, avd := a.IM[y*a.Width:(y+1)*a.Width], a.AVD[y*a.Width:(y+1)*a.Width]
+			for x, v := range to {
+				d := float64(v) - float64(from[x])
+				im[x] += d * d
+				avd[x] += math.Abs(d)
+			}
+		}
+	})
+}
+
+// Summarize возвращает индексы образца в целом: средние карт (каждый пиксель дает
+// одинаковое число переходов, поэтому среднее карты равно индексу по общей COM).
+func (a *Activity) Summarize() Summary {
+	s := Summary{Pairs: a.Pairs}
+	for i := range a.IM {
+		s.IM += a.IM[i]
+		s.AVD += a.AVD[i]
+	}
+	n := float64(len(a.IM))
+	s.IM /= n
+	s.AVD /= n
+	return s
+}
@@ -0,0 +1,287 @@
+// Package calibration выполняет геометрическую калибровку оптики по изображению плоской
+// шахматной мишени: находит внутренние углы клеток, оценивает радиальную дисторсию объектива
+// и исправляет ее на кадрах последовательности, чтобы пространственные измерения
+// не искажались к краям поля зрения.
+//
+// Модель дисторсии - радиальная, с центром в центре кадра:
+//
+//	p_d = c + (p_u - c)·(1 + K1·ρ² + K2·ρ⁴),  ρ = |p_u - c| / R,
+//
+// где p_u - неискаженное положение точки, p_d - наблюдаемое, c - центр кадра,
+// R - половина диагонали кадра. Неискаженные положения углов мишени связаны
+// с узлами ее сетки гомографией (мишень плоская), поэтому коэффициенты K1, K2
+// и гомография оцениваются совместно по одному изображению.
+package calibration
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/mascotmascot1/go-tlasca/internal/atomicfile"
+)
+
+// Point - точка в координатах пикселей кадра (центр пикселя (0, 0) имеет координаты (0, 0)).
+type Point struct {
+	X, Y float64
+}
+
+// Model - модель радиальной дисторсии объектива (см. описание пакета).
+type Model struct {
+	// Width, Height - размеры кадра, для которого оценена модель.
+	Width  int `json:"width"`
+	Height int `json:"height"`
+	// K1, K2 - коэффициенты радиальной дисторсии: отрицательные значения соответствуют
+	// бочкообразной дисторсии, положительные - подушкообразной.
+	K1 float64 `json:"k1"`
+	K2 float64 `json:"k2"`
+}
+
+// center возвращает центр дисторсии c и нормирующий радиус R модели.
+func (m Model) center() (c Point, radius float64) {
+	c = Point{float64(m.Width-1) / 2, float64(m.Height-1) / 2}
+	return c, math.Hypot(c.X, c.Y)
+}
+
+// Distort возвращает наблюдаемое положение точки с неискаженным положением p.
+func (m Model) Distort(p Point) Point {
+	c, radius := m.center()
+	dx, dy := p.X-c.X, p.Y-c.Y
+	r2 := (dx*dx + dy*dy) / (radius * radius)
+	f := 1 + m.K1*r2 + m.K2*r2*r2
+	return Point{c.X + dx*f, c.Y + dy*f}
+}
+
+// Undistort возвращает неискаженное положение точки с наблюдаемым положением p.
+// Обратное преобразование находится итерациями неподвижной точки.
+func (m Model) Undistort(p Point) Point {
+	c, radius := m.center()
+	dx, dy := p.X-c.X, p.Y-c.Y
+	ux, uy := dx, dy
+	for range 20 {
+		r2 := (ux*ux + uy*uy) / (radius * radius)
+		f := 1 + m.K1*r2 + m.K2*r2*r2
+		if f <= 0 {
+			break
+		}
+		ux, uy = dx/f, dy/f
+	}
+	return Point{c.X + ux, c.Y + uy}
+}
+
+// MaxDisplacement возвращает наибольшее смещение точки кадра дисторсией в пикселях
+// (оценивается по краям кадра, где оно максимально для монотонной дисторсии).
+func (m Model) MaxDisplacement() float64 {
+	var worst float64
+	w, h := float64(m.Width-1), float64(m.Height-1)
+	for _, p := range []Point{{0, 0}, {w, 0}, {0, h}, {w, h}, {w / 2, 0}, {0, h / 2}} {
+		d := m.Distort(p)
+		worst = max(worst, math.Hypot(d.X-p.X, d.Y-p.Y))
+	}
+	return worst
+}
+
+// Summary - сводка калибровки.
+type Summary struct {
+	Model
+	// Columns, Rows - число внутренних углов мишени по горизонтали и вертикали.
+	Columns int `json:"columns"`
+	Rows    int `json:"rows"`
+	// RMSError - среднеквадратичное отклонение найденных углов от модели в пикселях.
+	RMSError float64 `json:"rms_error"`
+	// MaxDisplacement - наибольшее смещение точки кадра дисторсией в пикселях.
+	MaxDisplacement float64 `json:"max_displacement"`
+}
+
+// Fit оценивает модель дисторсии кадра width x height по углам corners мишени, упорядоченным
+// построчно (индекс row*columns + col, см. Detect). Гомография сетки и коэффициенты дисторсии
+// уточняются поочередно: при заданных коэффициентах гомография оценивается по исправленным
+// положениям углов, при заданной гомографии коэффициенты - линейным методом наименьших квадратов.
+func Fit(corners []Point, columns, rows, width, height int) (Summary, error) {
+	if len(corners) != columns*rows {
+		return Summary{}, fmt.Errorf("expected %d corners, got %d", columns*rows, len(corners))
+	}
+	if columns < 3 || rows < 3 {
+		return Summary{}, fmt.Errorf("at least 3x3 corners are required, got %dx%d", columns, rows)
+	}
+	grid := make([]Point, len(corners))
+	for i := range grid {
+		grid[i] = Point{float64(i % columns), float64(i / columns)}
+	}
+
+	model := Model{Width: width, Height: height}
+	c, radius := model.center()
+	var h homography
+	undistorted := make([]Point, len(corners))
+	for range 100 {
+		for i, p := range corners {
+			undistorted[i] = model.Undistort(p)
+		}
+		var err error
+		if h, err = fitHomography(grid, undistorted); err != nil {
+			return Summary{}, err
+		}
+		// (p_d - p_u) = (p_u - c)·(K1·ρ² + K2·ρ⁴): два уравнения на угол, линейных по K1, K2.
+		var a [2][2]float64
+		var b [2]float64
+		for i, g := range grid {
+			u := h.apply(g)
+			dx, dy := u.X-c.X, u.Y-c.Y
+			r2 := (dx*dx + dy*dy) / (radius * radius)
+			for _, eq := range [][3]float64{
+				{dx * r2, dx * r2 * r2, corners[i].X - u.X},
+				{dy * r2, dy * r2 * r2, corners[i].Y - u.Y},
+			} {
+				for j := range 2 {
+					for k := range 2 {
+						a[j][k] += eq[j] * eq[k]
+					}
+					b[j] += eq[j] * eq[2]
+				}
+			}
+		}
+		det := a[0][0]*a[1][1] - a[0][1]*a[1][0]
+		if det == 0 {
+			return Summary{}, fmt.Errorf("corners do not constrain the distortion model")
+		}
+		k1 := (b[0]*a[1][1] - b[1]*a[0][1]) / det
+		k2 := (a[0][0]*b[1] - a[1][0]*b[0]) / det
+		converged := math.Abs(k1-model.K1) < 1e-9 && math.Abs(k2-model.K2) < 1e-9
+		model.K1, model.K2 = k1, k2
+		if converged {
+			break
+		}
+	}
+
+	var sum float64
+	for i, g := range grid {
+		d := model.Distort(h.apply(g))
+		sum += (d.X-corners[i].X)*(d.X-corners[i].X) + (d.Y-corners[i].Y)*(d.Y-corners[i].Y)
+	}
+	return Summary{
+		Model:           model,
+		Columns:         columns,
+		Rows:            rows,
+		RMSError:        math.Sqrt(sum / float64(len(corners))),
+		MaxDisplacement: model.MaxDisplacement(),
+	}, nil
+}
+
+// Save сохраняет модель в JSON-файл.
+func (m Model) Save(path string) error {
+	return atomicfile.Write(path, func(w io.Writer) error {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(m)
+	})
+}
+
+// homography - проективное преобразование плоскости (h[8] = 1).
+type homography [9]float64
+
+// apply возвращает образ точки p.
+func (h homography) apply(p Point) Point {
+	w := h[6]*p.X + h[7]*p.Y + h[8]
+	return Point{(h[0]*p.X + h[1]*p.Y + h[2]) / w, (h[3]*p.X + h[4]*p.Y + h[5]) / w}
+}
+
+// fitHomography оценивает гомографию, переводящую точки src в dst, линейным методом
+// наименьших квадратов (DLT) в нормированных координатах.
+func fitHomography(src, dst []Point) (homography, error) {
+	ns, ts := normalization(src)
+	nd, td := normalization(dst)
+	var a [8][8]float64
+	var b [8]float64
+	for i := range src {
+		x, y := (src[i].X-ts.X)*ns, (src[i].Y-ts.Y)*ns
+		u, v := (dst[i].X-td.X)*nd, (dst[i].Y-td.Y)*nd
+		for _, eq := range [][9]float64{
+			{x, y, 1, 0, 0, 0, -x * u, -y * u, u},
+			{0, 0, 0, x, y, 1, -x * v, -y * v, v},
+		} {
+			for j := range 8 {
+				for k := range 8 {
+					a[j][k] += eq[j] * eq[k]
+				}
+				b[j] += eq[j] * eq[8]
+			}
+		}
+	}
+	sol, err := solve(a, b)
+	if err != nil {
+		return homography{}, fmt.Errorf("corners do not define a plane mapping: %w", err)
+	}
+	// Возврат к исходным координатам: H = Td⁻¹ · Hn · Ts.
+	hn := [9]float64{sol[0], sol[1], sol[2], sol[3], sol[4], sol[5], sol[6], sol[7], 1}
+	tsm := [9]float64{ns, 0, -ns * ts.X, 0, ns, -ns * ts.Y, 0, 0, 1}
+	tdi := [9]float64{1 / nd, 0, td.X, 0, 1 / nd, td.Y, 0, 0, 1}
+	return homography(mul3(tdi, mul3(hn, tsm))), nil
+}
+
+// normalization возвращает масштаб и центр, приводящие точки к нулевому центру
+// и среднему расстоянию √2 от него (нормировка Хартли).
+func normalization(points []Point) (scale float64, center Point) {
+	for _, p := range points {
+		center.X += p.X
+		center.Y += p.Y
+	}
+	center.X /= float64(len(points))
+	center.Y /= float64(len(points))
+	var mean float64
+	for _, p := range points {
+		mean += math.Hypot(p.X-center.X, p.Y-center.Y)
+	}
+	mean /= float64(len(points))
+	if mean == 0 {
+		return 1, center
+	}
+	return math.Sqrt2 / mean, center
+}
+
+// mul3 возвращает произведение матриц 3x3, заданных построчно.
+func mul3(a, b [9]float64) [9]float64 {
+	var c [9]float64
+	for i := range 3 {
+		for j := range 3 {
+			for k := range 3 {
+				c[i*3+j] += a[i*3+k] * b[k*3+j]
+			}
+		}
+	}
+	return c
+}
+
+// solve решает систему a·x = b методом Гаусса с выбором ведущего элемента.
+func solve(a [8][8]float64, b [8]float64) ([8]float64, error) {
+	const n = 8
+	for col := range n {
+		pivot := col
+		for row := col + 1; row < n; row++ {
+			if math.Abs(a[row][col]) > math.Abs(a[pivot][col]) {
+				pivot = row
+			}
+		}
+		if math.Abs(a[pivot][col]) < 1e-12 {
+			return b, fmt.Errorf("singular system")
+		}
+		a[col], a[pivot] = a[pivot], a[col]
+		b[col], b[pivot] = b[pivot], b[col]
+		for row := col + 1; row < n; row++ {
+			f := a[row][col] / a[col][col]
+			for k := col; k < n; k++ {
+				a[row][k] -= f * a[col][k]
+			}
+			b[row] -= f * b[col]
+		}
+	}
+	var x [8]float64
+	for row := n - 1; row >= 0; row-- {
+		sum := b[row]
+		for k := row + 1; k < n; k++ {
+			sum -= a[row][k] * x[k]
+		}
+		x[row] = sum / a[row][row]
+	}
+	return x, nil
+}
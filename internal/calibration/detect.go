@@ -0,0 +1,318 @@
+package calibration
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"sort"
+
+	"github.com/mascotmascot1/go-tlasca/internal/parallel"
+	"github.com/mascotmascot1/go-tlasca/pkg/frame"
+)
+
+// Detect находит внутренние углы шахматной мишени (columns x rows углов) на изображении f
+// и возвращает их построчно: индекс row*columns + col.
+//
+// Внутренний угол клеток - седловая точка яркости, поэтому кандидаты находятся как локальные
+// максимумы отклика Ixy² - Ixx·Iyy (минус определитель матрицы Гессе) сглаженного изображения;
+// берутся columns*rows сильнейших, их положения уточняются до долей пикселя. Затем углы
+// упорядочиваются по двум направлениям сетки, определенным по векторам до ближайших соседей.
+// Мишень должна быть видна целиком, а ее строки - располагаться ближе к горизонтали
+// или вертикали кадра, чем к диагонали.
+func Detect(f frame.Frame, columns, rows int) ([]Point, error) {
+	if columns < 3 || rows < 3 {
+		return nil, fmt.Errorf("at least 3x3 inner corners are required, got %dx%d", columns, rows)
+	}
+	bounds := f.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	plane := make([]float64, w*h)
+	buf := frame.RowBuffer(f)
+	for y := range h {
+		for x, v := range f.Row(bounds.Min.Y+y, buf) {
+			plane[y*w+x] = float64(v)
+		}
+	}
+	sigma := max(1.5, float64(min(w, h))/400)
+	plane = blur(plane, w, h, sigma)
+	response := saddleResponse(plane, w, h)
+
+	radius := max(3, int(math.Ceil(2*sigma)))
+	candidates := localMaxima(response, w, h, radius)
+	n := columns * rows
+	if len(candidates) < n {
+		return nil, fmt.Errorf("found %d corner candidates, expected %d inner corners", len(candidates), n)
+	}
+	strongest := response[candidates[n-1]]
+	if len(candidates) > n && response[candidates[n]] > 0.5*strongest {
+		// Следующий по силе кандидат почти так же выражен, как слабейший угол:
+		// на изображении больше углов, чем задано, или мишень частично за кадром.
+		return nil, fmt.Errorf("found more than %d distinct corners; check calibration columns and rows", n)
+	}
+	corners := make([]Point, n)
+	for i, idx := range candidates[:n] {
+		corners[i] = refine(response, w, h, idx)
+	}
+	return orderGrid(corners, columns, rows)
+}
+
+// blur выполняет гауссово сглаживание плоскости w x h со стандартным отклонением sigma.
+func blur(plane []float64, w, h int, sigma float64) []float64 {
+	r := int(math.Ceil(3 * sigma))
+	kernel := make([]float64, 2*r+1)
+	var sum float64
+	for i := range kernel {
+		d := float64(i - r)
+		kernel[i] = math.Exp(-d * d / (2 * sigma * sigma))
+		sum += kernel[i]
+	}
+	for i := range kernel {
+		kernel[i] /= sum
+	}
+	tmp := make([]float64, len(plane))
+	out := make([]float64, len(plane))
+	parallel.Rows(h, func(startY, endY int) {
+		for y := startY; y < endY; y++ {
+			for x := range w {
+				var v float64
+				for i, k := range kernel {
+					sx := min(max(x+i-r, 0), w-1)
+					v += k * plane[y*w+sx]
+				}
+				tmp[y*w+x] = v
+			}
+		}
+	})
+	parallel.Rows(h, func(startY, endY int) {
+		for y := startY; y < endY; y++ {
+			for x := range w {
+				var v float64
+				for i, k := range kernel {
+					sy := min(max(y+i-r, 0), h-1)
+					v += k * tmp[sy*w+x]
+				}
+				out[y*w+x] = v
+			}
+		}
+	})
+	return out
+}
+
+// saddleResponse возвращает отклик седловой точки Ixy² - Ixx·Iyy (0 вне седловых точек
+// и на краю изображения).
+func saddleResponse(plane []float64, w, h int) []float64 {
+	out := make([]float64, len(plane))
+	parallel.Rows(h, func(startY, endY int) {
+		for y := max(startY, 1); y < min(endY, h-1); y++ {
+			for x := 1; x < w-1; x++ {
+				i := y*w + x
+				ixx := plane[i-1] - 2*plane[i] + plane[i+1]
+				iyy := plane[i-w] - 2*plane[i] + plane[i+w]
+				ixy := (plane[i-w-1] + plane[i+w+1] - plane[i-w+1] - plane[i+w-1]) / 4
+				out[i] = max(ixy*ixy-ixx*iyy, 0)
+			}
+		}
+	})
+	return out
+}
+
+// localMaxima возвращает индексы положительных локальных максимумов отклика в окрестности
+// радиуса radius в порядке убывания отклика.
+func localMaxima(response []float64, w, h, radius int) []int {
+	var peak float64
+	for _, v := range response {
+		peak = max(peak, v)
+	}
+	// Слабые максимумы (шум однородных областей) не рассматриваются.
+	threshold := 0.01 * peak
+	var maxima []int
+	for y := radius; y < h-radius; y++ {
+		for x := radius; x < w-radius; x++ {
+			v := response[y*w+x]
+			if v <= threshold {
+				continue
+			}
+			isMax := true
+			for dy := -radius; dy <= radius && isMax; dy++ {
+				for dx := -radius; dx <= radius; dx++ {
+					u := response[(y+dy)*w+x+dx]
+					// При равных значениях максимумом считается первый по порядку обхода пиксель.
+					if u > v || (u == v && (dy < 0 || (dy == 0 && dx < 0))) {
+						isMax = false
+						break
+					}
+				}
+			}
+			if isMax {
+				maxima = append(maxima, y*w+x)
+			}
+		}
+	}
+	sort.SliceStable(maxima, func(i, j int) bool {
+		return response[maxima[i]] > response[maxima[j]]
+	})
+	return maxima
+}
+
+// refine уточняет положение максимума отклика idx до долей пикселя
+// по квадратичной аппроксимации в окрестности 3x3.
+func refine(response []float64, w, h, idx int) Point {
+	x, y := idx%w, idx/w
+	p := Point{float64(x), float64(y)}
+	if x < 1 || y < 1 || x >= w-1 || y >= h-1 {
+		return p
+	}
+	at := func(dx, dy int) float64 { return response[(y+dy)*w+x+dx] }
+	gx := (at(1, 0) - at(-1, 0)) / 2
+	gy := (at(0, 1) - at(0, -1)) / 2
+	hxx := at(1, 0) - 2*at(0, 0) + at(-1, 0)
+	hyy := at(0, 1) - 2*at(0, 0) + at(0, -1)
+	hxy := (at(1, 1) + at(-1, -1) - at(1, -1) - at(-1, 1)) / 4
+	det := hxx*hyy - hxy*hxy
+	if det <= 0 || hxx >= 0 {
+		return p
+	}
+	ox := -(hyy*gx - hxy*gy) / det
+	oy := -(hxx*gy - hxy*gx) / det
+	p.X += min(max(ox, -1), 1)
+	p.Y += min(max(oy, -1), 1)
+	return p
+}
+
+// orderGrid упорядочивает углы сетки columns x rows построчно. Направления сетки
+// определяются по векторам до четырех ближайших соседей каждого угла (углы векторов
+// приводятся к периоду 90°); углы разбиваются на строки по проекции на направление
+// поперек строк и сортируются внутри строки по проекции вдоль строки.
+func orderGrid(corners []Point, columns, rows int) ([]Point, error) {
+	var sumSin, sumCos float64
+	spacings := make([]float64, len(corners))
+	for i, p := range corners {
+		for k, j := range nearest(corners, i, 4) {
+			angle := math.Atan2(corners[j].Y-p.Y, corners[j].X-p.X)
+			sumSin += math.Sin(4 * angle)
+			sumCos += math.Cos(4 * angle)
+			if k == 0 {
+				spacings[i] = math.Hypot(corners[j].X-p.X, corners[j].Y-p.Y)
+			}
+		}
+	}
+	sort.Float64s(spacings)
+	// Шаг сетки - медианное расстояние до ближайшего соседа.
+	spacing := spacings[len(spacings)/2]
+	// Направление сетки, ближайшее к горизонтали кадра (|alpha| <= 45°), и перпендикуляр к нему.
+	alpha := math.Atan2(sumSin, sumCos) / 4
+	along := Point{math.Cos(alpha), math.Sin(alpha)}
+	across := Point{-along.Y, along.X}
+
+	order := func(along, across Point) ([]Point, bool) {
+		sorted := append([]Point(nil), corners...)
+		sort.Slice(sorted, func(i, j int) bool {
+			return dotPoint(sorted[i], across) < dotPoint(sorted[j], across)
+		})
+		for r := range rows {
+			row := sorted[r*columns : (r+1)*columns]
+			if r > 0 {
+				// Соседние строки должны быть разделены промежутком порядка шага сетки:
+				// при неверном числе углов в строке граница проходит внутри строки.
+				prev := sorted[(r-1)*columns : r*columns]
+				if minProjection(row, across)-maxProjection(prev, across) < spacing/2 {
+					return nil, false
+				}
+			}
+			sort.Slice(row, func(i, j int) bool {
+				return dotPoint(row[i], along) < dotPoint(row[j], along)
+			})
+		}
+		return sorted, true
+	}
+	// Строки мишени (columns углов) ожидаются вдоль горизонтального направления;
+	// если по нему углы не разбиваются на строки, мишень считается повернутой на 90°.
+	if sorted, ok := order(along, across); ok {
+		return sorted, nil
+	}
+	if sorted, ok := order(across, along); ok {
+		return sorted, nil
+	}
+	return nil, fmt.Errorf("corners could not be ordered into a %dx%d grid; make sure the whole target is visible", columns, rows)
+}
+
+// nearest возвращает индексы k ближайших к corners[i] точек.
+func nearest(corners []Point, i, k int) []int {
+	idx := make([]int, 0, len(corners)-1)
+	for j := range corners {
+		if j != i {
+			idx = append(idx, j)
+		}
+	}
+	dist := func(j int) float64 {
+		return math.Hypot(corners[j].X-corners[i].X, corners[j].Y-corners[i].Y)
+	}
+	sort.Slice(idx, func(a, b int) bool { return dist(idx[a]) < dist(idx[b]) })
+	return idx[:min(k, len(idx))]
+}
+
+func dotPoint(p, d Point) float64 { return p.X*d.X + p.Y*d.Y }
+
+func minProjection(points []Point, d Point) float64 {
+	v := math.Inf(1)
+	for _, p := range points {
+		v = min(v, dotPoint(p, d))
+	}
+	return v
+}
+
+func maxProjection(points []Point, d Point) float64 {
+	v := math.Inf(-1)
+	for _, p := range points {
+		v = max(v, dotPoint(p, d))
+	}
+	return v
+}
+
+// Overlay строит изображение f в градациях серого (растянутых до максимума кадра)
+// с найденными углами corners: углы отмечаются красными крестами и соединяются
+// в порядке обхода зелеными линиями, что позволяет проверить распознавание мишени.
+func Overlay(f frame.Frame, corners []Point) *image.RGBA {
+	bounds := f.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	buf := frame.RowBuffer(f)
+	var peak uint16
+	for y := range h {
+		for _, v := range f.Row(bounds.Min.Y+y, buf) {
+			peak = max(peak, v)
+		}
+	}
+	for y := range h {
+		for x, v := range f.Row(bounds.Min.Y+y, buf) {
+			var level uint8
+			if peak > 0 {
+				level = uint8(uint32(v) * 255 / uint32(peak))
+			}
+			img.SetRGBA(x, y, color.RGBA{level, level, level, 255})
+		}
+	}
+	green := color.RGBA{G: 220, A: 255}
+	red := color.RGBA{R: 255, A: 255}
+	for i := 1; i < len(corners); i++ {
+		drawLine(img, corners[i-1], corners[i], green)
+	}
+	arm := max(3, min(w, h)/150)
+	for _, p := range corners {
+		x, y := int(math.Round(p.X)), int(math.Round(p.Y))
+		for d := -arm; d <= arm; d++ {
+			img.SetRGBA(x+d, y, red)
+			img.SetRGBA(x, y+d, red)
+		}
+	}
+	return img
+}
+
+// drawLine рисует отрезок от a до b.
+func drawLine(img *image.RGBA, a, b Point, c color.RGBA) {
+	steps := int(math.Ceil(max(math.Abs(b.X-a.X), math.Abs(b.Y-a.Y))))
+	for s := 0; s <= steps; s++ {
+		t := float64(s) / float64(max(steps, 1))
+		img.SetRGBA(int(math.Round(a.X+t*(b.X-a.X))), int(math.Round(a.Y+t*(b.Y-a.Y))), c)
+	}
+}
@@ -0,0 +1,135 @@
+package calibration
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/mascotmascot1/go-tlasca/pkg/frame"
+	"github.com/mascotmascot1/go-tlasca/pkg/mask"
+)
+
+// Способы интерполяции при исправлении дисторсии.
+const (
+	// InterpolationNearest берет значение ближайшего пикселя исходного кадра: временные
+	// статистики каждого пикселя сохраняются без изменений.
+	InterpolationNearest = "nearest"
+	// InterpolationBilinear интерполирует четыре соседних пикселя: геометрия точнее,
+	// но смешение соседних пикселей сглаживает спекл-картину и занижает контраст.
+	InterpolationBilinear = "bilinear"
+)
+
+// Remap исправляет дисторсию кадров по модели: значение пикселя p_u исправленного кадра
+// берется из точки Distort(p_u) исходного кадра. Положения исходных точек вычисляются
+// один раз при создании, поэтому один Remap применяется ко всем кадрам последовательности.
+// Remap безопасен для конкурентного использования.
+type Remap struct {
+	width, height int
+	bilinear      bool
+	// source - индекс исходного пикселя (для bilinear - левого верхнего из четырех) или -1,
+	// если исходная точка вне кадра; fx, fy - дробные части координат исходной точки.
+	source []int
+	fx, fy []float32
+}
+
+// NewRemap подготавливает исправление дисторсии кадров размера модели
+// со способом интерполяции interpolation.
+func NewRemap(model Model, interpolation string) (*Remap, error) {
+	r := &Remap{width: model.Width, height: model.Height}
+	switch interpolation {
+	case InterpolationNearest:
+	case InterpolationBilinear:
+		r.bilinear = true
+		r.fx = make([]float32, model.Width*model.Height)
+		r.fy = make([]float32, model.Width*model.Height)
+	default:
+		return nil, fmt.Errorf("unknown interpolation '%s', expected '%s' or '%s'",
+			interpolation, InterpolationNearest, InterpolationBilinear)
+	}
+	w, h := model.Width, model.Height
+	r.source = make([]int, w*h)
+	for y := range h {
+		for x := range w {
+			i := y*w + x
+			p := model.Distort(Point{float64(x), float64(y)})
+			if !r.bilinear {
+				sx, sy := int(math.Round(p.X)), int(math.Round(p.Y))
+				r.source[i] = -1
+				if sx >= 0 && sy >= 0 && sx < w && sy < h {
+					r.source[i] = sy*w + sx
+				}
+				continue
+			}
+			sx, sy := math.Floor(p.X), math.Floor(p.Y)
+			r.source[i] = -1
+			// Точка на последнем столбце или строке интерполируется с тем же пикселем.
+			if sx >= 0 && sy >= 0 && p.X <= float64(w-1) && p.Y <= float64(h-1) {
+				r.source[i] = int(sy)*w + int(sx)
+				r.fx[i], r.fy[i] = float32(p.X-sx), float32(p.Y-sy)
+			}
+		}
+	}
+	return r, nil
+}
+
+// Outside возвращает маску пикселей исправленного кадра, исходная точка которых
+// лежит вне кадра (для подушкообразной дисторсии - углы кадра); значения таких пикселей
+// не определены и равны 0. Возвращает nil, если таких пикселей нет.
+func (r *Remap) Outside() *mask.Mask {
+	m := &mask.Mask{Width: r.width, Height: r.height, Set: make([]bool, len(r.source))}
+	outside := false
+	for i, s := range r.source {
+		if s < 0 {
+			m.Set[i] = true
+			outside = true
+		}
+	}
+	if !outside {
+		return nil
+	}
+	return m
+}
+
+// Apply возвращает кадр f с исправленной дисторсией. Размер кадра должен совпадать
+// с размером модели.
+func (r *Remap) Apply(f frame.Frame) (frame.Frame, error) {
+	bounds := f.Bounds()
+	if bounds.Dx() != r.width || bounds.Dy() != r.height {
+		return nil, fmt.Errorf("frame size %dx%d does not match calibration size %dx%d",
+			bounds.Dx(), bounds.Dy(), r.width, r.height)
+	}
+	w, h := r.width, r.height
+	src := make([]uint16, w*h)
+	buf := frame.RowBuffer(f)
+	for y := range h {
+		copy(src[y*w:], f.Row(bounds.Min.Y+y, buf))
+	}
+	out := frame.NewRaw(w, h)
+	for y := range h {
+		row := out.MutableRow(y)
+		for x := range w {
+			i := y*w + x
+			s := r.source[i]
+			if s < 0 {
+				continue
+			}
+			if !r.bilinear {
+				row[x] = src[s]
+				continue
+			}
+			// Соседи справа и снизу берутся в пределах кадра.
+			right, down := s+1, s+w
+			if s%w == w-1 {
+				right = s
+			}
+			if s/w == h-1 {
+				down = s
+			}
+			diagonal := down + (right - s)
+			fx, fy := float64(r.fx[i]), float64(r.fy[i])
+			top := float64(src[s])*(1-fx) + float64(src[right])*fx
+			bottom := float64(src[down])*(1-fx) + float64(src[diagonal])*fx
+			row[x] = uint16(math.Round(top*(1-fy) + bottom*fy))
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,202 @@
+// Package camera оценивает профиль шума камеры по стеку темновых кадров (снятых
+// при закрытом объективе с теми же экспозицией и усилением, что и запись) и применяет его:
+// попиксельное темновое смещение (fixed-pattern noise - постоянная составляющая сигнала
+// каждого пикселя) вычитается из кадров, а попиксельная дисперсия темнового шума -
+// из временной дисперсии интенсивности, чтобы шум камеры не завышал контраст.
+package camera
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+
+	"github.com/mascotmascot1/go-tlasca/internal/atomicfile"
+	"github.com/mascotmascot1/go-tlasca/pkg/frame"
+)
+
+// profileMagic - сигнатура и версия формата файла профиля камеры.
+const profileMagic = "TLCAM001"
+
+// Profile - профиль шума камеры: попиксельные среднее (смещение) и выборочная дисперсия
+// отсчетов темновых кадров в исходных единицах отсчетов, построчно (y*Width + x).
+type Profile struct {
+	Width, Height int
+	// Frames - число темновых кадров, по которым оценен профиль.
+	Frames   int
+	Offset   []float64
+	Variance []float64
+}
+
+// profileHeader - заголовок файла профиля (все поля - int64, little-endian).
+type profileHeader struct {
+	Width, Height, Frames int64
+}
+
+// Summary - сводка профиля камеры для отчета о запуске.
+type Summary struct {
+	// Path - путь к файлу профиля.
+	Path string `json:"path"`
+	// Frames - число темновых кадров профиля.
+	Frames int `json:"frames"`
+	// MeanOffset - среднее темновое смещение по кадру в единицах отсчетов.
+	MeanOffset float64 `json:"mean_offset"`
+	// OffsetSpread - стандартное отклонение смещения по пикселям (пространственный шум).
+	OffsetSpread float64 `json:"offset_spread"`
+	// ReadNoise - медианное по пикселям стандартное отклонение темнового шума.
+	ReadNoise float64 `json:"read_noise"`
+}
+
+// Estimate оценивает профиль по total темновым кадрам, которые загружаются по одному
+// через load. Среднее и дисперсия накапливаются алгоритмом Уэлфорда, поэтому в памяти
+// находится только текущий кадр. Все кадры должны иметь одинаковый размер.
+func Estimate(total int, load func(i int) (frame.Frame, error)) (*Profile, error) {
+	if total < 2 {
+		return nil, fmt.Errorf("at least 2 dark frames are required, got %d", total)
+	}
+	var p *Profile
+	var m2 []float64
+	for i := range total {
+		f, err := load(i)
+		if err != nil {
+			return nil, err
+		}
+		bounds := f.Bounds()
+		if p == nil {
+			p = &Profile{
+				Width:    bounds.Dx(),
+				Height:   bounds.Dy(),
+				Offset:   make([]float64, bounds.Dx()*bounds.Dy()),
+				Variance: make([]float64, bounds.Dx()*bounds.Dy()),
+			}
+			m2 = p.Variance
+		} else if bounds.Dx() != p.Width || bounds.Dy() != p.Height {
+			return nil, fmt.Errorf("dark frame %d has size %dx%d, expected %dx%d",
+				i+1, bounds.Dx(), bounds.Dy(), p.Width, p.Height)
+		}
+		p.Frames++
+		n := float64(p.Frames)
+		buf := frame.RowBuffer(f)
+		for y := range p.Height {
+			offset := p.Offset[y*p.Width : (y+1)*p.Width]
+			sums := m2[y*p.Width : (y+1)*p.Width]
+			for x, v := range f.Row(bounds.Min.Y+y, buf) {
+				delta := float64(v) - offset[x]
+				offset[x] += delta / n
+				sums[x] += delta * (float64(v) - offset[x])
+			}
+		}
+	}
+	for i := range m2 {
+		m2[i] /= float64(p.Frames - 1)
+	}
+	return p, nil
+}
+
+// Summarize возвращает сводку профиля, сохраненного в файле path.
+func (p *Profile) Summarize(path string) Summary {
+	s := Summary{Path: path, Frames: p.Frames}
+	for _, v := range p.Offset {
+		s.MeanOffset += v
+	}
+	s.MeanOffset /= float64(len(p.Offset))
+	var spread float64
+	for _, v := range p.Offset {
+		spread += (v - s.MeanOffset) * (v - s.MeanOffset)
+	}
+	s.OffsetSpread = math.Sqrt(spread / float64(len(p.Offset)))
+	variance := append([]float64(nil), p.Variance...)
+	sort.Float64s(variance)
+	s.ReadNoise = math.Sqrt(variance[len(variance)/2])
+	return s
+}
+
+// Subtract возвращает кадр f за вычетом темнового смещения: из каждого отсчета вычитается
+// смещение пикселя, округленное до целого; отрицательные значения заменяются нулем.
+// Размер кадра должен совпадать с размером профиля.
+func (p *Profile) Subtract(f frame.Frame) (frame.Frame, error) {
+	bounds := f.Bounds()
+	if bounds.Dx() != p.Width || bounds.Dy() != p.Height {
+		return nil, fmt.Errorf("frame size %dx%d does not match camera profile size %dx%d",
+			bounds.Dx(), bounds.Dy(), p.Width, p.Height)
+	}
+	out := frame.NewRaw(p.Width, p.Height)
+	buf := frame.RowBuffer(f)
+	for y := range p.Height {
+		row := out.MutableRow(y)
+		offset := p.Offset[y*p.Width : (y+1)*p.Width]
+		for x, v := range f.Row(bounds.Min.Y+y, buf) {
+			row[x] = uint16(max(float64(v)-math.Round(offset[x]), 0))
+		}
+	}
+	return out, nil
+}
+
+// NoiseVariance возвращает дисперсию темнового шума пикселей, умноженную на scale
+// (квадрат коэффициента, на который умножается интенсивность кадров перед расчетом).
+func (p *Profile) NoiseVariance(scale float64) []float64 {
+	out := make([]float64, len(p.Variance))
+	for i, v := range p.Variance {
+		out[i] = v * scale
+	}
+	return out
+}
+
+// Save сохраняет профиль в файл path: сигнатура, заголовок и плоскости смещения
+// и дисперсии в формате float64 little-endian.
+func (p *Profile) Save(path string) error {
+	return atomicfile.Write(path, func(w io.Writer) error {
+		if _, err := io.WriteString(w, profileMagic); err != nil {
+			return err
+		}
+		header := profileHeader{Width: int64(p.Width), Height: int64(p.Height), Frames: int64(p.Frames)}
+		if err := binary.Write(w, binary.LittleEndian, header); err != nil {
+			return err
+		}
+		for _, plane := range [][]float64{p.Offset, p.Variance} {
+			if err := binary.Write(w, binary.LittleEndian, plane); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Load загружает профиль, сохраненный Save.
+func Load(path string) (*Profile, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	r := bufio.NewReader(file)
+	magic := make([]byte, len(profileMagic))
+	if _, err = io.ReadFull(r, magic); err != nil || string(magic) != profileMagic {
+		return nil, fmt.Errorf("'%s' is not a camera profile file", path)
+	}
+	var header profileHeader
+	if err = binary.Read(r, binary.LittleEndian, &header); err != nil {
+		return nil, fmt.Errorf("failed to read header: %w", err)
+	}
+	if header.Width <= 0 || header.Height <= 0 || header.Width*header.Height > math.MaxInt32 || header.Frames < 2 {
+		return nil, fmt.Errorf("invalid header: size %dx%d, %d frames", header.Width, header.Height, header.Frames)
+	}
+	size := int(header.Width * header.Height)
+	p := &Profile{
+		Width:    int(header.Width),
+		Height:   int(header.Height),
+		Frames:   int(header.Frames),
+		Offset:   make([]float64, size),
+		Variance: make([]float64, size),
+	}
+	for _, plane := range [][]float64{p.Offset, p.Variance} {
+		if err = binary.Read(r, binary.LittleEndian, plane); err != nil {
+			return nil, fmt.Errorf("failed to read profile planes: %w", err)
+		}
+	}
+	return p, nil
+}
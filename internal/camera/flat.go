@@ -0,0 +1,75 @@
+package camera
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/mascotmascot1/go-tlasca/pkg/frame"
+)
+
+// FlatField - поправка неоднородности чувствительности пикселей сенсора (и виньетирования
+// объектива) по изображению равномерно освещенного поля: отсчет каждого пикселя кадра
+// умножается на отношение среднего уровня поля к уровню поля в этом пикселе.
+// FlatField безопасен для конкурентного использования.
+type FlatField struct {
+	width, height int
+	gain          []float64
+}
+
+// NewFlatField подготавливает поправку по изображению поля flat. Если задан профиль шума
+// dark, из поля предварительно вычитается темновое смещение. Возвращает ошибку,
+// если в поле есть пиксели с неположительным уровнем (поправка для них не определена).
+func NewFlatField(flat frame.Frame, dark *Profile) (*FlatField, error) {
+	bounds := flat.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	if dark != nil && (dark.Width != w || dark.Height != h) {
+		return nil, fmt.Errorf("flat field size %dx%d does not match camera profile size %dx%d", w, h, dark.Width, dark.Height)
+	}
+	ff := &FlatField{width: w, height: h, gain: make([]float64, w*h)}
+	buf := frame.RowBuffer(flat)
+	var sum float64
+	dead := 0
+	for y := range h {
+		for x, v := range flat.Row(bounds.Min.Y+y, buf) {
+			i := y*w + x
+			level := float64(v)
+			if dark != nil {
+				level -= dark.Offset[i]
+			}
+			if level <= 0 {
+				dead++
+			}
+			ff.gain[i] = level
+			sum += level
+		}
+	}
+	if dead > 0 {
+		return nil, fmt.Errorf("flat field has %d pixels without signal; use a brighter flat field or mark them in the bad pixel map", dead)
+	}
+	mean := sum / float64(len(ff.gain))
+	for i, level := range ff.gain {
+		ff.gain[i] = mean / level
+	}
+	return ff, nil
+}
+
+// Apply возвращает кадр f с поправкой неоднородности: отсчеты умножаются на поправку
+// пикселя и округляются до целого (значения выше 65535 ограничиваются).
+// Размер кадра должен совпадать с размером поля.
+func (ff *FlatField) Apply(f frame.Frame) (frame.Frame, error) {
+	bounds := f.Bounds()
+	if bounds.Dx() != ff.width || bounds.Dy() != ff.height {
+		return nil, fmt.Errorf("frame size %dx%d does not match flat field size %dx%d",
+			bounds.Dx(), bounds.Dy(), ff.width, ff.height)
+	}
+	out := frame.NewRaw(ff.width, ff.height)
+	buf := frame.RowBuffer(f)
+	for y := range ff.height {
+		row := out.MutableRow(y)
+		gain := ff.gain[y*ff.width : (y+1)*ff.width]
+		for x, v := range f.Row(bounds.Min.Y+y, buf) {
+			row[x] = uint16(min(math.Round(float64(v)*gain[x]), math.MaxUint16))
+		}
+	}
+	return out, nil
+}
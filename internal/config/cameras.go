@@ -0,0 +1,82 @@
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// CameraConfig описывает камеру реестра cameras: параметры, которые определяются камерой
+// (сенсором и оптикой), а не записью. Пустые (нулевые) поля не применяются.
+type CameraConfig struct {
+	// BitDepth - фактическая разрядность данных камеры (см. InputConfig.BitDepth).
+	BitDepth int `json:"bit_depth"`
+	// NoiseProfile - файл профиля шума камеры (см. PathsConfig.CameraProfile).
+	NoiseProfile string `json:"noise_profile"`
+	// BadPixelMap - маска дефектных пикселей сенсора (см. PathsConfig.BadPixelMap).
+	BadPixelMap string `json:"bad_pixel_map"`
+	// FlatField - изображение равномерно освещенного поля (см. PathsConfig.FlatField).
+	FlatField string `json:"flat_field"`
+	// CalibrationImage - изображение шахматной мишени для исправления дисторсии объектива
+	// (см. PathsConfig.CalibrationImage).
+	CalibrationImage string `json:"calibration_image"`
+}
+
+// cameraSource возвращает источник для значений камеры name.
+func cameraSource(name string) Source {
+	return Source("camera:" + name)
+}
+
+// applyCamera применяет параметры камеры, выбранной ключом camera, к параметрам,
+// которые не заданы явно в файлах конфигурации (значения по умолчанию и пресета
+// заменяются, явно указанные - нет). Относительные пути камеры, описанной в файле
+// конфигурации набора данных, отсчитываются от директории данных.
+func (c *Config) applyCamera() error {
+	if c.Camera == "" {
+		return nil
+	}
+	camera, ok := c.Cameras[c.Camera]
+	if !ok {
+		names := make([]string, 0, len(c.Cameras))
+		for name := range c.Cameras {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		if len(names) == 0 {
+			return fmt.Errorf("unknown camera '%s': no cameras are configured", c.Camera)
+		}
+		return fmt.Errorf("unknown camera '%s' (configured: %s)", c.Camera, strings.Join(names, ", "))
+	}
+	source := cameraSource(c.Camera)
+	explicit := func(key string) bool {
+		return c.sources[key] == SourceFile || c.sources[key] == SourceDataset
+	}
+
+	if camera.BitDepth != 0 && !explicit("input.bit_depth") {
+		c.Input.BitDepth = camera.BitDepth
+		c.setSource("input.bit_depth", source)
+	}
+	prefix := "cameras." + c.Camera + "."
+	for _, p := range []struct {
+		key, field string
+		target     *string
+		value      string
+	}{
+		{"paths.camera_profile", "noise_profile", &c.Paths.CameraProfile, camera.NoiseProfile},
+		{"paths.bad_pixel_map", "bad_pixel_map", &c.Paths.BadPixelMap, camera.BadPixelMap},
+		{"paths.flat_field", "flat_field", &c.Paths.FlatField, camera.FlatField},
+		{"paths.calibration_image", "calibration_image", &c.Paths.CalibrationImage, camera.CalibrationImage},
+	} {
+		if p.value == "" || explicit(p.key) {
+			continue
+		}
+		path := p.value
+		if c.sources[prefix+p.field] == SourceDataset && !filepath.IsAbs(path) {
+			path = filepath.Join(c.Paths.DataDir, path)
+		}
+		*p.target = path
+		c.setSource(p.key, source)
+	}
+	return nil
+}
@@ -4,68 +4,1046 @@ package config
 
 import (
 	"encoding/json"
+	"fmt"
 	"log"
 	"os"
+	"path/filepath"
+
+	"github.com/mascotmascot1/go-tlasca/internal/pathutil"
+	"github.com/mascotmascot1/go-tlasca/pkg/tlasca"
 )
 
 // PathsConfig содержит настройки, связанные с путями файловой системы.
 type PathsConfig struct {
 	// DataDir указывает директорию, содержащую входную последовательность изображений.
 	DataDir string `json:"data_dir"`
+	// Video указывает необязательный видеофайл (MP4, AVI и др.) с последовательностью кадров.
+	// Если файл задан, кадры декодируются программой ffmpeg во временную директорию
+	// и используются вместо DataDir.
+	Video string `json:"video"`
+	// FFmpeg и FFprobe задают пути (или имена в PATH) программ ffmpeg и ffprobe для чтения Video.
+	FFmpeg  string `json:"ffmpeg"`
+	FFprobe string `json:"ffprobe"`
+	// Patterns задает шаблоны имен входных файлов (синтаксис filepath.Match, без учета регистра).
+	Patterns []string `json:"patterns"`
+	// Exclude задает шаблоны имен файлов, исключаемых из последовательности.
+	Exclude []string `json:"exclude"`
+	// TimestampsFile указывает необязательный CSV-файл с временами регистрации кадров
+	// (номер кадра из имени файла -> время в секундах). Пустая строка означает
+	// равномерную съемку без явных временных меток.
+	TimestampsFile string `json:"timestamps_file"`
+	// ExposureFile указывает необязательный CSV-файл с экспозициями кадров
+	// (номер кадра -> время экспозиции). Если файл задан, интенсивность каждого кадра
+	// приводится к медианной экспозиции перед вычислением статистик. Экспозиции из тегов
+	// TIFF читаются при InputConfig.ExposureSource = "tiff".
+	ExposureFile string `json:"exposure_file"`
+	// ExclusionMask указывает необязательное изображение-маску размера кадра: пиксели
+	// с ненулевой яркостью (блики, маркеры) исключаются из расчета вместе со всеми
+	// положениями окна, которые их задевают.
+	ExclusionMask string `json:"exclusion_mask"`
+	// CalibrationImage указывает необязательное изображение шахматной мишени размера кадра.
+	// Если оно задано, по нему оценивается дисторсия объектива, и все кадры исправляются
+	// перед анализом (см. CalibrationConfig).
+	CalibrationImage string `json:"calibration_image"`
+	// CameraProfile указывает необязательный файл профиля шума камеры, оцененного по стеку
+	// темновых кадров подкомандой camera. Если он задан, темновое смещение вычитается
+	// из кадров, а дисперсия темнового шума - из временной дисперсии.
+	CameraProfile string `json:"camera_profile"`
+	// BadPixelMap указывает необязательное изображение-маску дефектных пикселей сенсора
+	// (горячих, мертвых) размера кадра: отмеченные пиксели исключаются так же, как ExclusionMask.
+	BadPixelMap string `json:"bad_pixel_map"`
+	// TissueMask указывает необязательное бинарное изображение-маску размера кадра с обратным
+	// ExclusionMask смыслом: пиксели с нулевой яркостью (вне ткани, зеркальные блики)
+	// исключаются из расчета, ненулевые - учитываются.
+	TissueMask string `json:"tissue_mask"`
+	// FlatField указывает необязательное изображение равномерно освещенного поля размера кадра:
+	// кадры умножаются на попиксельную поправку неоднородности чувствительности сенсора.
+	FlatField string `json:"flat_field"`
+	// RegionsFile указывает необязательный файл областей ImageJ (.roi или RoiSet.zip):
+	// каждая его область добавляется к Config.Regions под своим именем в ImageJ.
+	RegionsFile string `json:"regions_file"`
 	// ResultsDir указывает директорию, куда будет сохранено выходное изображение.
 	ResultsDir string `json:"results_dir"`
 	// OutputFilename указывает имя файла для сгенерированной карты контраста.
 	OutputFilename string `json:"output_filename"`
+	// ReportFilename указывает имя JSON-файла с отчетом о запуске (параметры, телеметрия этапов).
+	// Пустая строка отключает сохранение отчета.
+	ReportFilename string `json:"report_filename"`
+	// ReportTemplate указывает необязательный файл шаблона Go (text/template, для HTML -
+	// html/template), который заполняется данными отчета о запуске для отчетов в формате
+	// лаборатории (HTML, Markdown). Пустая строка отключает отчет по шаблону.
+	ReportTemplate string `json:"report_template"`
+	// TemplateReportFilename указывает имя файла отчета по шаблону в ResultsDir; по умолчанию -
+	// имя файла шаблона без расширения ".tmpl". Расширение ".html" или ".htm" выбирает html/template.
+	TemplateReportFilename string `json:"template_report_filename"`
+	// EffectiveConfigFilename указывает имя JSON-файла с итоговой конфигурацией запуска
+	// и источником каждого значения. Пустая строка отключает сохранение.
+	EffectiveConfigFilename string `json:"effective_config_filename"`
+}
+
+// InputConfig содержит параметры интерпретации входных кадров.
+type InputConfig struct {
+	// BitDepth задает фактическую разрядность данных (8, 10, 12, 14 или 16 бит).
+	// Значение 0 означает автоматическое определение по первому кадру.
+	BitDepth int `json:"bit_depth"`
+	// SaturationLevel задает порог насыщения как долю полной шкалы разрядности (2^BitDepth - 1).
+	SaturationLevel float64 `json:"saturation_level"`
+	// SaturationWarnFraction задает долю насыщенных пикселей кадра, при превышении
+	// которой кадр считается пересвеченным и выводится предупреждение.
+	SaturationWarnFraction float64 `json:"saturation_warn_fraction"`
+	// UnreadableFrames задает реакцию на поврежденный или нечитаемый кадр:
+	// "strict" - прервать запуск, "tolerant" - пропустить кадр с предупреждением.
+	UnreadableFrames string `json:"unreadable_frames"`
+	// ExposureSource задает источник экспозиций кадров для нормировки интенсивности:
+	// "file" (по умолчанию) - CSV-файл Paths.ExposureFile (без файла нормировка не выполняется),
+	// "tiff" - теги кадров TIFF (ExposureTime EXIF или "Exposure-ms" Micro-Manager).
+	ExposureSource string `json:"exposure_source"`
+	// Interleave задает схему чередования состояний освещения по кадрам: символ i (по модулю
+	// длины строки) - состояние кадра i, "-" - кадр не используется. Например, "AB" - нечетные
+	// и четные кадры при разном освещении. Пустая строка (по умолчанию) - одно состояние.
+	Interleave string `json:"interleave"`
+	// CoPolarizedSuffix и CrossPolarizedSuffix задают окончания имен файлов (без расширения)
+	// кадров параллельной и скрещенной поляризации, записанных парами ("0001_co.png"
+	// и "0001_cross.png" для "_co" и "_cross"). Пустые строки (по умолчанию) - один канал.
+	CoPolarizedSuffix    string `json:"co_polarized_suffix"`
+	CrossPolarizedSuffix string `json:"cross_polarized_suffix"`
+	// Raw задает формат кадров без заголовка, сохраненных напрямую из SDK камеры.
+	Raw RawConfig `json:"raw"`
+	// PlaybackFPS задает частоту кадров, с которой записанная последовательность подается
+	// в потоковый расчет (compute_mode "streaming"), имитируя съемку в реальном времени;
+	// 0 (по умолчанию) - кадры подаются без ожидания.
+	PlaybackFPS float64 `json:"playback_fps"`
+}
+
+// RawConfig описывает кадры без заголовка: файл содержит только отсчеты одного кадра
+// построчно, поэтому размеры кадра, разрядность и порядок байтов задаются здесь.
+type RawConfig struct {
+	// Width, Height - размеры кадра в пикселях. Width = 0 (по умолчанию) отключает
+	// чтение кадров без заголовка.
+	Width  int `json:"width"`
+	Height int `json:"height"`
+	// BitDepth - разрядность отсчетов (8..16): до 8 бит отсчет занимает один байт, иначе два.
+	BitDepth int `json:"bit_depth"`
+	// ByteOrder - порядок байтов двухбайтовых отсчетов: "little" (по умолчанию) или "big".
+	ByteOrder string `json:"byte_order"`
+	// HeaderBytes - число пропускаемых байтов служебного заголовка в начале файла.
+	HeaderBytes int64 `json:"header_bytes"`
+	// Extension - расширение файлов, которые читаются как кадры без заголовка (по умолчанию ".raw").
+	Extension string `json:"extension"`
 }
 
 // AlgorithmConfig содержит параметры, специфичные для алгоритма tLASCA.
 type AlgorithmConfig struct {
+	// Preset задает имя набора параметров, подобранного для типичного применения
+	// ("cerebral-bloodflow", "skin-perfusion", "biospeckle-seed"): вид контраста, окно,
+	// временное окно, нормировку и цветовую карту (см. presets). Значения пресета
+	// применяются поверх значений по умолчанию; любое поле, явно указанное в файле,
+	// переопределяет значение пресета.
+	Preset string `json:"preset,omitempty"`
+	// Mode задает вид контраста: "temporal" (по умолчанию) - временной контраст по всей
+	// последовательности, "spatial" - пространственный контраст по окну каждого кадра
+	// (одна карта на каждый входной кадр), "spatiotemporal" - контраст по окну всех кадров
+	// группы из TemporalDepth последовательных кадров (одна карта на группу).
+	Mode string `json:"mode"`
+	// TemporalDepth задает число кадров в группе пространственно-временного режима.
+	TemporalDepth int `json:"temporal_depth"`
+	// TemporalWindow задает число кадров окна, сдвигаемого по последовательности во временном
+	// режиме: для каждого положения окна сохраняется отдельная карта (временной ряд карт).
+	// Значение 0 (по умолчанию) означает одну карту по всей последовательности.
+	TemporalWindow int `json:"temporal_window"`
+	// TemporalStep задает шаг сдвига окна TemporalWindow в кадрах (0 - шаг, равный окну).
+	TemporalStep int `json:"temporal_step"`
 	// WindowSize определяет размер стороны (в пикселях) квадратного скользящего окна,
 	// используемого для пространственного усреднения при вычислении контраста.
 	WindowSize int `json:"window_size"`
+	// ChunkSize задает число кадров в одной порции при обработке длинных записей.
+	// Кадры загружаются и обрабатываются порциями, а их статистики объединяются точно,
+	// поэтому в памяти одновременно хранится не более одной порции.
+	// Значение 0 означает обработку всей последовательности целиком.
+	ChunkSize int `json:"chunk_size"`
+	// StackLayout задает раскладку порции кадров в памяти при расчете временных статистик:
+	// "frames" - покадрово (по умолчанию), "planar" - отсчеты каждого пикселя по всем
+	// кадрам подряд. Раскладка "planar" требует транспонирования порции (и вдвое больше
+	// памяти на порцию), но ускоряет циклы статистик за счет последовательного доступа.
+	StackLayout string `json:"stack_layout"`
+	// ComputeMode задает способ расчета временных статистик: "deterministic" (по умолчанию) -
+	// два прохода (среднее, затем сумма квадратов отклонений) в фиксированном порядке
+	// суммирования, "fast" - один проход по сумме и сумме квадратов с FMA. Оба режима
+	// не зависят от числа рабочих горутин; "fast" быстрее, но менее точен при малом контрасте.
+	// "streaming" - один проход по алгоритму Уэлфорда с загрузкой кадров по одному: в памяти
+	// хранятся только текущий кадр и плоскости статистик, независимо от длины записи.
+	ComputeMode string `json:"compute_mode"`
+	// Workers задает число рабочих горутин расчета и подготовки выходных изображений.
+	// Значение 0 (по умолчанию) означает число логических ядер CPU (не больше ограничения
+	// CPU cgroup); 1 - однопоточный расчет (например, для отладки).
+	Workers int `json:"workers"`
+	// Kernel задает реализацию ядер накопления временных статистик: "auto" (по умолчанию) -
+	// самая быстрая из доступных на процессоре, "avx2" или "generic" (на Go) - принудительно,
+	// например для отладки, "opencl" - расчет на GPU (сборка с тегом opencl).
+	// Реализации дают одинаковый результат.
+	Kernel string `json:"kernel"`
+	// Output задает величину итоговой карты: "contrast" (по умолчанию) - контраст спеклов K,
+	// "perfusion" - индекс кровотока 1/K² (1/(2T·K²) при заданной ExposureTime),
+	// пропорциональный скорости рассеивателей. Анализы, основанные на контрасте
+	// (сегментация, границы контраста, производные карты), используют контраст.
+	Output string `json:"output"`
+	// ExposureTime - экспозиция кадра T, мс, для индекса кровотока 1/(2T·K²) в 1/s;
+	// 0 (по умолчанию) - экспозиция не задана, индекс 1/K² в произвольных единицах.
+	ExposureTime float64 `json:"exposure_time"`
+}
+
+// Params возвращает параметры алгоритма для tlasca.NewRunner.
+func (a AlgorithmConfig) Params() tlasca.Params {
+	return tlasca.Params{
+		Mode:           a.Mode,
+		WindowSize:     a.WindowSize,
+		StackLayout:    a.StackLayout,
+		ComputeMode:    a.ComputeMode,
+		TemporalDepth:  a.TemporalDepth,
+		TemporalWindow: a.TemporalWindow,
+		TemporalStep:   a.TemporalStep,
+	}
+}
+
+// OutputConfig содержит параметры преобразования карты контраста в выходное изображение.
+type OutputConfig struct {
+	// Normalization задает способ выбора шкалы отображения карты в яркость [0, 255]:
+	// "fixed" (диапазон ContrastMin..ContrastMax), "minmax", "percentile", "zscore"
+	// или "calibration". Шкала едина для всех выходных изображений карты.
+	Normalization string `json:"normalization"`
+	// ContrastMin и ContrastMax задают диапазон значений контраста для нормировки "fixed";
+	// значения вне диапазона ограничиваются.
+	ContrastMin float64 `json:"contrast_min"`
+	ContrastMax float64 `json:"contrast_max"`
+	// PercentileLow и PercentileHigh задают процентили (в процентах) для нормировки "percentile".
+	PercentileLow  float64 `json:"percentile_low"`
+	PercentileHigh float64 `json:"percentile_high"`
+	// ZScore задает полуширину диапазона в стандартных отклонениях для нормировки "zscore".
+	ZScore float64 `json:"zscore"`
+	// CalibrationCurve задает узлы [значение, уровень] кусочно-линейной кривой для нормировки
+	// "calibration"; уровень 0 соответствует черному, 1 - белому.
+	CalibrationCurve [][]float64 `json:"calibration_curve"`
+	// OutOfRangeMask указывает имя PNG-файла с маской пикселей, вышедших за диапазон
+	// (255 - выше ContrastMax, 128 - ниже ContrastMin). Пустая строка отключает сохранение.
+	OutOfRangeMask string `json:"out_of_range_mask"`
+	// ResultBitDepth задает разрядность итоговой карты: 8 (по умолчанию) или 16 бит.
+	// Шкала отображения одна и та же; 16 бит сохраняют тонкие различия контраста,
+	// которые теряются при квантовании в 256 уровней.
+	ResultBitDepth int `json:"result_bit_depth"`
+	// FloatFilename указывает имя TIFF-файла с картой контраста без квантования (отсчеты
+	// float32, исключенные положения - NaN). Пустая строка отключает сохранение.
+	FloatFilename string `json:"float_filename"`
+	// CSVFilename и NPYFilename указывают имена файлов с картой контраста без квантования
+	// в виде матрицы CSV и массива NumPy .npy (float64, исключенные положения - NaN).
+	// Пустая строка отключает сохранение.
+	CSVFilename string `json:"csv_filename"`
+	NPYFilename string `json:"npy_filename"`
+	// ValidityMaskFilename указывает имя PNG-файла с маской рассчитанных положений карты
+	// (255 - рассчитано, 0 - нет), которая сохраняется вместе с частичным результатом
+	// прерванного расчета. Пустая строка отключает сохранение.
+	ValidityMaskFilename string `json:"validity_mask_filename"`
+	// Background задает уровень шкалы отображения от 0 (черный, по умолчанию) до 1 (белый),
+	// которым выводятся исключенные из расчета положения карты (маски, дефектные пиксели).
+	Background float64 `json:"background"`
+	// ColormapFilename указывает имя PNG-файла псевдоцветной (RGB) карты контраста в той же
+	// шкале отображения. Пустая строка отключает сохранение.
+	ColormapFilename string `json:"colormap_filename"`
+	// Colormap задает цветовую карту псевдоцветной карты: "viridis" (по умолчанию),
+	// "inferno", "jet" или "grayscale".
+	Colormap string `json:"colormap"`
+	// OverlayFilename указывает имя PNG-файла с псевдоцветной картой контраста, наложенной
+	// на временное среднее интенсивности (анатомия под картой кровотока).
+	// Пустая строка отключает сохранение.
+	OverlayFilename string `json:"overlay_filename"`
+	// OverlayAlpha задает непрозрачность карты при наложении: от 0 (только среднее)
+	// до 1 (только карта); по умолчанию 0.5.
+	OverlayAlpha float64 `json:"overlay_alpha"`
+	// RatioFilename указывает имя PNG-файла с картой отношения контрастов первых двух
+	// состояний чередования (input.interleave). Пустая строка отключает сохранение.
+	RatioFilename string `json:"ratio_filename"`
+	// RatioMax задает отношение, отображаемое белым на карте отношения (0 - черный).
+	RatioMax float64 `json:"ratio_max"`
+	// PolarizationFilename указывает имя PNG-файла с картой контраста каналов поляризации,
+	// взвешенной по деполяризации (input.co_polarized_suffix). Пустая строка отключает сохранение.
+	PolarizationFilename string `json:"polarization_filename"`
+	// DepolarizationFilename указывает имя PNG-файла с картой степени деполяризации
+	// I_cross / (I_co + I_cross) в диапазоне [0, 1]. Пустая строка отключает сохранение.
+	DepolarizationFilename string `json:"depolarization_filename"`
+	// FigureFilename указывает имя PNG-файла сводной иллюстрации эксперимента (среднее изображение,
+	// карта контраста, индекс кровотока, гистограмма, параметры). Пустая строка отключает сохранение.
+	FigureFilename string `json:"figure_filename"`
+	// MeanFilename и StdDevFilename указывают имена 16-битных PNG-файлов с попиксельным
+	// временным средним и стандартным отклонением интенсивности (до усреднения окном).
+	// Пустая строка отключает сохранение.
+	MeanFilename   string `json:"mean_filename"`
+	StdDevFilename string `json:"stddev_filename"`
+	// Quantiles задает квантили (в процентах, [0, 100]) временного ряда интенсивности,
+	// карты которых сохраняются как 16-битные PNG-файлы <QuantilePrefix><квантиль>.png
+	// в геометрии кадра. Пустой список отключает расчет.
+	Quantiles      []float64 `json:"quantiles"`
+	QuantilePrefix string    `json:"quantile_prefix"`
+	// DeepZoomName указывает базовое имя тайловой пирамиды Deep Zoom (name.dzi и name_files/)
+	// для просмотра больших карт в веб-просмотрщиках. Пустая строка отключает экспорт.
+	DeepZoomName string `json:"deepzoom_name"`
+	// DeepZoomTileSize и DeepZoomOverlap задают размер тайла и перекрытие соседних тайлов, пикселей.
+	DeepZoomTileSize int `json:"deepzoom_tile_size"`
+	DeepZoomOverlap  int `json:"deepzoom_overlap"`
+}
+
+// QuantileFilename возвращает имя файла карты квантиля q (например, "quantile_p10.png").
+func (o OutputConfig) QuantileFilename(q float64) string {
+	return fmt.Sprintf("%s%g.png", o.QuantilePrefix, q)
+}
+
+// DenoiseConfig содержит параметры подавления шума итоговой карты контраста.
+type DenoiseConfig struct {
+	// Method задает фильтр: "none" (по умолчанию), "nlm" - нелокальные средние
+	// или "anisotropic" - анизотропная диффузия Перона-Малика.
+	Method string `json:"method"`
+	// PatchRadius и SearchRadius задают радиусы сравниваемых окрестностей и области поиска
+	// фильтра "nlm" в пикселях карты.
+	PatchRadius  int `json:"patch_radius"`
+	SearchRadius int `json:"search_radius"`
+	// Strength задает порог схожести окрестностей фильтра "nlm" в единицах ожидаемого
+	// шума оценки контраста: большие значения сильнее сглаживают карту.
+	Strength float64 `json:"strength"`
+	// Iterations задает число шагов диффузии фильтра "anisotropic".
+	Iterations int `json:"iterations"`
+	// Kappa задает порог границ фильтра "anisotropic" - относительную разность контраста
+	// соседних пикселей, выше которой сглаживание затухает.
+	Kappa float64 `json:"kappa"`
+}
+
+// CompareConfig содержит параметры сравнения двух эпох записи (например, до и после окклюзии).
+type CompareConfig struct {
+	// EpochA и EpochB задают эпохи как пары [первый, последний] порядковых номеров кадров
+	// в отсортированной последовательности (с единицы, включительно).
+	// Сравнение выполняется, только если заданы обе эпохи.
+	EpochA []int `json:"epoch_a"`
+	EpochB []int `json:"epoch_b"`
+	// LabelA и LabelB задают подписи панелей эпох.
+	LabelA string `json:"label_a"`
+	LabelB string `json:"label_b"`
+	// FigureFilename указывает имя PNG-файла иллюстрации сравнения.
+	FigureFilename string `json:"figure_filename"`
+}
+
+// DiagnosticsConfig содержит параметры диагностики вклада отдельных кадров в контраст.
+type DiagnosticsConfig struct {
+	// FrameContributions включает расчет вклада каждого кадра методом исключения по одному.
+	FrameContributions bool `json:"frame_contributions"`
+	// ReferenceROI задает опорную область [x, y, ширина, высота] в координатах кадра;
+	// пустое значение означает весь кадр.
+	ReferenceROI []int `json:"reference_roi"`
+	// FlagThreshold - порог робастной z-оценки вклада, выше которого кадр отмечается как артефакт.
+	FlagThreshold float64 `json:"flag_threshold"`
+	// ContributionsFilename указывает имя CSV-файла с вкладами кадров.
+	ContributionsFilename string `json:"contributions_filename"`
+	// Convergence включает расчет контраста опорной области в зависимости от числа кадров.
+	Convergence bool `json:"convergence"`
+	// ConvergenceTolerance - допустимое относительное отклонение контраста от значения
+	// по всем кадрам, в пределах которого оценка считается установившейся.
+	ConvergenceTolerance float64 `json:"convergence_tolerance"`
+	// ConvergenceFilename и ConvergencePlotFilename указывают имена CSV-файла с кривой
+	// сходимости и PNG-файла с ее графиком; пустая строка отключает сохранение файла.
+	ConvergenceFilename     string `json:"convergence_filename"`
+	ConvergencePlotFilename string `json:"convergence_plot_filename"`
+	// FrameCorrelation включает расчет корреляции кадров между собой (см. пакет framecorr).
+	FrameCorrelation bool `json:"frame_correlation"`
+	// FrameCorrelationSize - наибольшая сторона сигнатуры кадра (уменьшенной копии), пикселей.
+	FrameCorrelationSize int `json:"frame_correlation_size"`
+	// FrameCorrelationMaxFrames - наибольшее число кадров, для которого строится матрица
+	// попарных корреляций (ее размер и время расчета растут квадратично).
+	FrameCorrelationMaxFrames int `json:"frame_correlation_max_frames"`
+	// FrameCorrelationFilename и FrameCorrelationMatrixFilename указывают имена CSV-файла
+	// с корреляциями соседних кадров и PNG-файла с матрицей; пустая строка отключает сохранение файла.
+	FrameCorrelationFilename       string `json:"frame_correlation_filename"`
+	FrameCorrelationMatrixFilename string `json:"frame_correlation_matrix_filename"`
+	// Focus включает контроль фокусировки: оценку резкости среднего кадра по тайлам.
+	Focus bool `json:"focus"`
+	// FocusTileSize - сторона тайла оценки резкости, пикселей.
+	FocusTileSize int `json:"focus_tile_size"`
+	// FocusThreshold - доля медианной резкости тайлов, ниже которой тайл считается расфокусированным.
+	FocusThreshold float64 `json:"focus_threshold"`
+	// FocusFilename указывает имя CSV-файла с резкостью тайлов. Пустая строка отключает сохранение.
+	FocusFilename string `json:"focus_filename"`
+}
+
+// RegionConfig описывает именованную область интереса.
+type RegionConfig struct {
+	// Name - имя области в отчете; пустое имя заменяется на "roi<номер>".
+	Name string `json:"name"`
+	// ROI задает область [x, y, ширина, высота] в координатах кадра.
+	ROI []int `json:"roi"`
+}
+
+// DerivedMapConfig описывает производную карту, вычисляемую выражением над картами запуска
+// (см. пакет expr).
+type DerivedMapConfig struct {
+	// Name - имя карты: по нему на карту ссылаются следующие выражения.
+	Name string `json:"name"`
+	// Expression - выражение карты, например "1/(k*k)".
+	Expression string `json:"expression"`
+	// Filename указывает имя 16-битного PNG-файла карты; пустое имя заменяется на "<name>.png".
+	Filename string `json:"filename"`
+	// Range задает значения [min, max], отображаемые в 0 и 65535; пустое значение -
+	// 1-й и 99-й процентили карты.
+	Range []float64 `json:"range"`
+	// Unit - единица значений карты для отчета и подписей (например, "PU" или "1/s");
+	// пустое значение означает произвольные единицы "a.u.".
+	Unit string `json:"unit"`
+}
+
+// SegmentationConfig содержит параметры автоматического разделения карты на сосуды и ткань.
+type SegmentationConfig struct {
+	// Enabled включает подгонку смеси двух нормальных распределений к гистограмме контраста
+	// и выбор порога между классами (см. пакет segment).
+	Enabled bool `json:"enabled"`
+	// MaskFilename указывает имя PNG-файла с маской сосудов (255 - контраст ниже порога).
+	// Пустая строка отключает сохранение.
+	MaskFilename string `json:"mask_filename"`
+}
+
+// ContrastLimitsConfig содержит параметры проверки карты на теоретические границы контраста
+// (см. пакет speckle).
+type ContrastLimitsConfig struct {
+	// Enabled включает проверку; требуется ExposureTime.
+	Enabled bool `json:"enabled"`
+	// ExposureTime - экспозиция кадра, мс.
+	ExposureTime float64 `json:"exposure_time"`
+	// StaticFraction - предполагаемая доля статического рассеяния [0, 1].
+	StaticFraction float64 `json:"static_fraction"`
+	// Beta - коэффициент когерентности (0, 1] (отношение размеров спекла и пикселя, поляризация).
+	Beta float64 `json:"beta"`
+	// CorrelationTimeMin и CorrelationTimeMax задают диапазон физически возможных времен
+	// корреляции, мс; 0 означает отсутствие соответствующей границы.
+	CorrelationTimeMin float64 `json:"correlation_time_min"`
+	CorrelationTimeMax float64 `json:"correlation_time_max"`
+	// Tolerance - допустимое относительное отклонение контраста от границ.
+	Tolerance float64 `json:"tolerance"`
+	// MaskFilename указывает имя PNG-файла с маской выхода за границы (255 - выше верхней,
+	// 128 - ниже нижней). Пустая строка отключает сохранение.
+	MaskFilename string `json:"mask_filename"`
+	// OverlayFilename указывает имя PNG-файла с картой, на которой положения выше верхней
+	// границы отмечены красным, ниже нижней - синим. Пустая строка отключает сохранение.
+	OverlayFilename string `json:"overlay_filename"`
+}
+
+// StaticScatteringConfig содержит параметры разделения статического и динамического рассеяния
+// (см. speckle.Separate).
+type StaticScatteringConfig struct {
+	// Enabled включает оценку; требуется временной расчет (как минимум 2 кадра).
+	Enabled bool `json:"enabled"`
+	// Beta - коэффициент когерентности (0, 1].
+	Beta float64 `json:"beta"`
+	// StaticFilename указывает имя 16-битного PNG-файла с картой доли статического рассеяния
+	// 1-ρ (0 - только подвижные рассеиватели, 65535 - только неподвижные).
+	// Пустая строка отключает сохранение.
+	StaticFilename string `json:"static_filename"`
+	// FlowFilename указывает имя 16-битного PNG-файла с картой индекса движения T/τc,
+	// исправленного на статическое рассеяние. Пустая строка отключает сохранение.
+	FlowFilename string `json:"flow_filename"`
+	// FlowMax - индекс движения, отображаемый в 65535; 0 - 99-й процентиль карты.
+	FlowMax float64 `json:"flow_max"`
+}
+
+// TrendConfig содержит параметры карты наклона линейного тренда во времени
+// (см. tlasca.Runner.IntensityTrend и tlasca.Runner.ContrastTrend).
+type TrendConfig struct {
+	// Enabled включает расчет карты; требуется временной расчет (как минимум 2 кадра).
+	Enabled bool `json:"enabled"`
+	// Signal задает величину, тренд которой рассчитывается: "intensity" (интенсивность
+	// каждого пикселя кадра) или "contrast" (временной контраст окна по сегментам записи).
+	Signal string `json:"signal"`
+	// SegmentFrames - число кадров сегмента, по которому рассчитывается контраст
+	// для Signal = "contrast" (не меньше 2).
+	SegmentFrames int `json:"segment_frames"`
+	// Filename указывает имя 16-битного PNG-файла карты наклона: 0 соответствует -Range,
+	// 65535 - +Range, середина шкалы - отсутствию тренда. Пустая строка отключает сохранение.
+	Filename string `json:"filename"`
+	// FloatFilename указывает имя TIFF-файла карты наклона без квантования (float32).
+	// Пустая строка (по умолчанию) отключает сохранение.
+	FloatFilename string `json:"float_filename"`
+	// Range - модуль наклона, отображаемый в 0 и 65535; 0 - 99-й процентиль модуля наклона.
+	Range float64 `json:"range"`
+}
+
+// BiospeckleConfig содержит параметры индексов активности биоспеклов IM и AVD (см. пакет biospeckle).
+type BiospeckleConfig struct {
+	// Enabled включает расчет индексов; требуется как минимум 2 соседних читаемых кадра.
+	Enabled bool `json:"enabled"`
+	// IMFilename и AVDFilename указывают имена 16-битных PNG-файлов карт индексов
+	// (0 - нет изменений, 65535 - 99-й процентиль карты). Пустая строка отключает сохранение.
+	IMFilename  string `json:"im_filename"`
+	AVDFilename string `json:"avd_filename"`
+}
+
+// CorrelationConfig содержит параметры взаимной корреляции временных рядов областей интереса.
+type CorrelationConfig struct {
+	// Enabled включает анализ; требуется не менее двух областей в Config.Regions.
+	Enabled bool `json:"enabled"`
+	// Signal задает величину временного ряда: "contrast" (пространственный контраст области)
+	// или "intensity" (средняя интенсивность).
+	Signal string `json:"signal"`
+	// MaxLag - максимальная задержка в кадрах (при заданных временных метках - в шагах
+	// равномерной сетки с медианным межкадровым интервалом), для которой вычисляется корреляция.
+	MaxLag int `json:"max_lag"`
+	// Filename указывает имя CSV-файла с корреляционными функциями пар областей.
+	Filename string `json:"filename"`
+}
+
+// VasomotionConfig содержит параметры частотного анализа вазомоций в областях интереса.
+type VasomotionConfig struct {
+	// Enabled включает анализ для всех областей Config.Regions.
+	Enabled bool `json:"enabled"`
+	// FrameRate - частота кадров в Гц; 0 означает оценку по временным меткам кадров.
+	// При заданных временных метках ряды приводятся к равномерной сетке с этой частотой.
+	FrameRate float64 `json:"frame_rate"`
+	// BandMin и BandMax задают частотную полосу вазомоций в Гц, в которой ищется пик спектра.
+	BandMin float64 `json:"band_min"`
+	BandMax float64 `json:"band_max"`
+	// Filename указывает имя CSV-файла со спектрами мощности областей.
+	Filename string `json:"filename"`
+}
+
+// QuickLookConfig содержит параметры быстрого предварительного анализа по представительному
+// подмножеству кадров записи.
+type QuickLookConfig struct {
+	// Frames задает число выбираемых кадров; 0 отключает выбор (используются все кадры).
+	Frames int `json:"frames"`
+	// Method задает способ выбора: "uniform" (равномерно во времени) или "intensity"
+	// (стратификация по средней интенсивности кадров).
+	Method string `json:"method"`
+}
+
+// NotificationsConfig содержит параметры уведомлений о завершении запуска (успешном
+// или с ошибкой) для длительных пакетных расчетов. Уведомление содержит основные
+// показатели отчета о запуске; письмо - и сводную иллюстрацию (output.figure_filename).
+type NotificationsConfig struct {
+	// On задает, о каких запусках уведомлять: "always" (по умолчанию), "failure" или "success".
+	On string `json:"on"`
+	// MinDurationS задает наименьшую длительность запуска в секундах, о котором
+	// отправляется уведомление (0 - о всех запусках).
+	MinDurationS int `json:"min_duration_s"`
+	// TimeoutS ограничивает время отправки одного уведомления в секундах.
+	TimeoutS int `json:"timeout_s"`
+	// SMTP - отправка по электронной почте.
+	SMTP SMTPConfig `json:"smtp"`
+	// Slack - отправка во входящий веб-хук Slack.
+	Slack SlackConfig `json:"slack"`
+}
+
+// SMTPConfig содержит параметры отправки уведомлений по электронной почте.
+type SMTPConfig struct {
+	// Addr - адрес SMTP-сервера "host:port"; пустая строка отключает отправку.
+	Addr string `json:"addr"`
+	// Username - имя пользователя для аутентификации (пустая строка - без аутентификации).
+	Username string `json:"username"`
+	// PasswordEnv - имя переменной окружения с паролем: пароль не хранится в конфигурации
+	// и не попадает в отчет о запуске.
+	PasswordEnv string `json:"password_env"`
+	// From и To - адрес отправителя и адреса получателей.
+	From string   `json:"from"`
+	To   []string `json:"to"`
+}
+
+// SlackConfig содержит параметры отправки уведомлений в Slack.
+type SlackConfig struct {
+	// WebhookURLEnv - имя переменной окружения с адресом входящего веб-хука (адрес дает
+	// право публикации и поэтому не хранится в конфигурации); пустая строка отключает отправку.
+	WebhookURLEnv string `json:"webhook_url_env"`
+}
+
+// PartialConfig содержит параметры распределенного (тайлового) расчета: исполнитель
+// рассчитывает только участок кадра и диапазон кадров и сохраняет достаточные статистики
+// для последующего объединения (см. cmd/tlasca-merge).
+type PartialConfig struct {
+	// Tile задает участок кадра [x, y, ширина, высота]; пустое значение отключает режим.
+	Tile []int `json:"tile"`
+	// Frames задает диапазон кадров [первый, последний] (с единицы, включительно);
+	// пустое значение означает всю последовательность. Разбиение по кадрам требует
+	// AlgorithmConfig.ChunkSize > 0 и диапазонов, начинающихся на границе порции.
+	Frames []int `json:"frames"`
+	// Filename указывает имя файла частичного результата в директории результатов.
+	Filename string `json:"filename"`
+}
+
+// StageConfig задает положение кадра на столике микроскопа для привязки карт
+// к физическим координатам (например, при съемке нескольких положений для мозаики).
+type StageConfig struct {
+	// PixelSize - размер пикселя кадра в единицах координат столика (например, мкм).
+	// 0 (по умолчанию) отключает запись файлов привязки.
+	PixelSize float64 `json:"pixel_size"`
+	// PositionX и PositionY - координаты столика, соответствующие центру
+	// верхнего левого пикселя кадра.
+	PositionX float64 `json:"position_x"`
+	PositionY float64 `json:"position_y"`
+}
+
+// CalibrationConfig содержит параметры геометрической калибровки по шахматной мишени
+// (paths.calibration_image).
+type CalibrationConfig struct {
+	// Columns, Rows - число внутренних углов мишени (точек касания клеток) по горизонтали
+	// и вертикали; мишень 10x7 клеток имеет 9x6 внутренних углов.
+	Columns int `json:"columns"`
+	Rows    int `json:"rows"`
+	// Interpolation задает способ выборки исправленного кадра: "nearest" (ближайший пиксель,
+	// сохраняет временные статистики пикселей) или "bilinear".
+	Interpolation string `json:"interpolation"`
+	// ModelFilename указывает имя JSON-файла с оцененной моделью дисторсии.
+	// Пустая строка отключает сохранение.
+	ModelFilename string `json:"model_filename"`
+	// CornersFilename указывает имя PNG-файла с изображением мишени и найденными углами.
+	// Пустая строка отключает сохранение.
+	CornersFilename string `json:"corners_filename"`
+}
+
+// RegistrationConfig содержит параметры совмещения кадров относительно первого (опорного) кадра.
+type RegistrationConfig struct {
+	// Enabled включает совмещение кадров перед вычислением статистик.
+	Enabled bool `json:"enabled"`
+	// MaxShift задает максимальное искомое смещение кадра в пикселях по каждой оси.
+	MaxShift int `json:"max_shift"`
+	// DriftWarnFraction задает порог предупреждения о накопленном дрейфе
+	// как долю размера окна усреднения (window_size).
+	DriftWarnFraction float64 `json:"drift_warn_fraction"`
+	// ShiftsFilename указывает имя CSV-файла с оценками смещений (dx, dy) по кадрам.
+	ShiftsFilename string `json:"shifts_filename"`
+	// DriftPlotFilename указывает имя PNG-файла с графиком дрейфа по кадрам.
+	DriftPlotFilename string `json:"drift_plot_filename"`
+}
+
+// LimitsConfig содержит бюджеты ресурсов, которые проверяются до начала загрузки данных.
+type LimitsConfig struct {
+	// MemoryLimitMB задает бюджет памяти в мегабайтах. Значение 0 означает
+	// автоматический бюджет (75% доступной памяти системы, если ее удается определить).
+	MemoryLimitMB int `json:"memory_limit_mb"`
+	// TimeLimitS задает бюджет оценочного времени вычислений в секундах (0 - без ограничения).
+	TimeLimitS int `json:"time_limit_s"`
+	// DisableAutoAdjust отключает автоматическую корректировку плана обработки
+	// (порционная загрузка, прореживание кадров) при выходе за бюджеты;
+	// в этом случае выводятся только предупреждения.
+	DisableAutoAdjust bool `json:"disable_auto_adjust"`
+	// Nice понижает приоритет процесса для планировщика CPU: 1-19, как у команды nice
+	// (0 - без изменений), чтобы фоновый расчет не мешал программе записи кадров.
+	Nice int `json:"nice"`
+	// IOPriority понижает приоритет ввода-вывода процесса: "low" или "idle" (как ionice);
+	// пустая строка - без изменений.
+	IOPriority string `json:"io_priority"`
+	// MaxReaders ограничивает число одновременно читаемых файлов кадров (0 - без ограничения).
+	MaxReaders int `json:"max_readers"`
 }
 
 // Config является корневой структурой конфигурации, включающей все остальные секции.
 type Config struct {
+	// Version - версия схемы файла конфигурации (см. CurrentVersion). Файлы более ранних
+	// версий обновляются при загрузке.
+	Version int `json:"version"`
+	// StrictKeys включает строгий разбор (по умолчанию): неизвестный параметр в файле
+	// конфигурации (например, опечатка в имени) - ошибка, а не молчаливое использование
+	// значения по умолчанию. При false неизвестные параметры пропускаются с предупреждением.
+	StrictKeys bool `json:"strict_keys"`
+
 	Paths     PathsConfig     `json:"paths"`
+	Input     InputConfig     `json:"input"`
 	Algorithm AlgorithmConfig `json:"algorithm"`
+	Output    OutputConfig    `json:"output"`
+	Limits    LimitsConfig    `json:"limits"`
+	// ROI задает участок кадра [x, y, ширина, высота], по которому выполняется расчет;
+	// пустое значение (по умолчанию) - весь кадр.
+	ROI []int `json:"roi"`
+	// Camera задает имя (серийный номер) камеры из реестра Cameras, параметры которой
+	// применяются к запуску; пустая строка (по умолчанию) - без профиля камеры.
+	Camera string `json:"camera"`
+	// Cameras - реестр камер: параметры, определяемые камерой, а не записью, по имени камеры.
+	Cameras map[string]CameraConfig `json:"cameras"`
+	// Denoise содержит параметры подавления шума карты контраста.
+	Denoise DenoiseConfig `json:"denoise"`
+	// Calibration содержит параметры геометрической калибровки оптики.
+	Calibration CalibrationConfig `json:"calibration"`
+	// Registration содержит параметры совмещения кадров.
+	Registration RegistrationConfig `json:"registration"`
+	// Compare содержит параметры сравнения двух эпох записи.
+	Compare CompareConfig `json:"compare"`
+	// Partial содержит параметры распределенного (тайлового) расчета.
+	Partial PartialConfig `json:"partial"`
+	// Stage содержит положение кадра на столике микроскопа.
+	Stage StageConfig `json:"stage"`
+	// Diagnostics содержит параметры диагностики кадров.
+	Diagnostics DiagnosticsConfig `json:"diagnostics"`
+	// Segmentation содержит параметры разделения карты на сосуды и ткань.
+	Segmentation SegmentationConfig `json:"segmentation"`
+	// ContrastLimits содержит параметры проверки карты на теоретические границы контраста.
+	ContrastLimits ContrastLimitsConfig `json:"contrast_limits"`
+	// StaticScattering содержит параметры разделения статического и динамического рассеяния.
+	StaticScattering StaticScatteringConfig `json:"static_scattering"`
+	// Trend содержит параметры карты наклона линейного тренда во времени.
+	Trend TrendConfig `json:"trend"`
+	// Biospeckle содержит параметры индексов активности биоспеклов.
+	Biospeckle BiospeckleConfig `json:"biospeckle"`
+	// DerivedMaps задает производные карты в порядке вычисления.
+	DerivedMaps []DerivedMapConfig `json:"derived_maps"`
+	// Regions задает именованные области интереса для анализа временных рядов.
+	Regions []RegionConfig `json:"regions"`
+	// Correlation содержит параметры взаимной корреляции областей интереса.
+	Correlation CorrelationConfig `json:"correlation"`
+	// Vasomotion содержит параметры частотного анализа вазомоций.
+	Vasomotion VasomotionConfig `json:"vasomotion"`
+	// QuickLook содержит параметры выбора подмножества кадров для быстрого анализа.
+	QuickLook QuickLookConfig `json:"quick_look"`
+	// Notifications содержит параметры уведомлений о завершении запуска.
+	Notifications NotificationsConfig `json:"notifications"`
+
+	// sources хранит источники значений, отличных от значений по умолчанию (см. Effective).
+	sources map[string]Source
 }
 
+// DatasetConfigName - имя файла конфигурации набора данных в директории данных.
+const DatasetConfigName = "go-tlasca.json"
+
 // NewConfig пытается загрузить конфигурацию из указанного JSON-файла.
 // Если файл не существует, логируется предупреждение и возвращается конфигурация по умолчанию.
+// Если в директории данных есть собственный файл конфигурации набора данных
+// (DatasetConfigName), он применяется поверх основного (см. loadDataset).
+// Файлы прежних версий схемы обновляются при загрузке (см. Migrate). Источник каждого
+// значения (по умолчанию, пресет, файл) сохраняется в конфигурации (см. Effective).
 // Возвращает ошибку, если файл существует, но не может быть прочитан или распарсен,
 // или при любых других ошибках файловой системы.
 func NewConfig(path string, logger *log.Logger) (*Config, error) {
+	return Load(path, nil, logger)
+}
+
+// Load загружает конфигурацию, как NewConfig, и применяет поверх файлов документ
+// конфигурации override (JSON той же схемы, например параметры запроса внешней
+// программы), если он не пуст. Его значения отмечаются источником SourceRequest
+// и имеют приоритет над файлами; заданная в нем директория данных используется
+// и для поиска файла конфигурации набора данных.
+func Load(path string, override []byte, logger *log.Logger) (*Config, error) {
 	// Инициализация значениями по умолчанию, которые будут использованы, если файл не найден.
 	var cfg = Config{
+		Version:    CurrentVersion,
+		StrictKeys: true,
 		Paths: PathsConfig{
 			DataDir:        "data",
+			FFmpeg:         "ffmpeg",
+			FFprobe:        "ffprobe",
+			Patterns:       []string{"*.png", "*.tif", "*.tiff"},
 			ResultsDir:     "results",
 			OutputFilename: "result.png",
+			ReportFilename: "report.json",
+			// Итоговая конфигурация сохраняется в начале запуска, до расчета.
+			EffectiveConfigFilename: "effective_config.json",
+		},
+		Input: InputConfig{
+			SaturationLevel:        1,
+			SaturationWarnFraction: 0.01,
+			UnreadableFrames:       "strict",
+			ExposureSource:         "file",
+			Raw: RawConfig{
+				BitDepth:  16,
+				ByteOrder: "little",
+				Extension: ".raw",
+			},
 		},
 		Algorithm: AlgorithmConfig{
 			// WindowSize: 1 по умолчанию означает отсутствие пространственного усреднения.
 			// Контраст рассчитывается только по временным изменениям каждого пикселя.
-			WindowSize: 1,
+			Mode:          "temporal",
+			WindowSize:    1,
+			StackLayout:   "frames",
+			ComputeMode:   "deterministic",
+			Kernel:        "auto",
+			TemporalDepth: 5,
+			Output:        "contrast",
+		},
+		Output: OutputConfig{
+			// Диапазон [0, 1] соответствует полному теоретическому диапазону контраста.
+			ContrastMin: 0,
+			ContrastMax: 1,
+			// Остальные способы нормировки используются, только если выбраны явно.
+			Normalization:          "fixed",
+			PercentileLow:          1,
+			PercentileHigh:         99,
+			ZScore:                 3,
+			ResultBitDepth:         8,
+			Colormap:               "viridis",
+			OverlayAlpha:           0.5,
+			ValidityMaskFilename:   "validity_mask.png",
+			RatioFilename:          "ratio.png",
+			RatioMax:               2,
+			PolarizationFilename:   "polarization.png",
+			DepolarizationFilename: "depolarization.png",
+			// Размер 254 с перекрытием 1 дает тайлы 256x256 - стандартные параметры Deep Zoom.
+			DeepZoomTileSize: 254,
+			DeepZoomOverlap:  1,
+			QuantilePrefix:   "quantile_p",
+		},
+		Calibration: CalibrationConfig{
+			Columns:         9,
+			Rows:            6,
+			Interpolation:   "nearest",
+			ModelFilename:   "calibration.json",
+			CornersFilename: "calibration_corners.png",
+		},
+		Registration: RegistrationConfig{
+			MaxShift:          10,
+			DriftWarnFraction: 0.5,
+			ShiftsFilename:    "shifts.csv",
+			DriftPlotFilename: "drift.png",
+		},
+		Compare: CompareConfig{
+			LabelA:         "BEFORE",
+			LabelB:         "AFTER",
+			FigureFilename: "comparison.png",
+		},
+		Partial: PartialConfig{
+			Filename: "partial.tpart",
+		},
+		Diagnostics: DiagnosticsConfig{
+			FlagThreshold:                  5,
+			ContributionsFilename:          "contributions.csv",
+			ConvergenceTolerance:           0.05,
+			ConvergenceFilename:            "convergence.csv",
+			ConvergencePlotFilename:        "convergence.png",
+			FrameCorrelationSize:           64,
+			FrameCorrelationMaxFrames:      2000,
+			FrameCorrelationFilename:       "frame_correlation.csv",
+			FrameCorrelationMatrixFilename: "frame_correlation.png",
+			FocusTileSize:                  64,
+			FocusThreshold:                 0.3,
+			FocusFilename:                  "focus.csv",
+		},
+		Correlation: CorrelationConfig{
+			Signal:   "contrast",
+			MaxLag:   10,
+			Filename: "cross_correlation.csv",
 		},
+		Denoise: DenoiseConfig{
+			Method:       "none",
+			PatchRadius:  1,
+			SearchRadius: 5,
+			Strength:     1,
+			Iterations:   10,
+			Kappa:        0.1,
+		},
+		Segmentation: SegmentationConfig{
+			MaskFilename: "vessel_mask.png",
+		},
+		ContrastLimits: ContrastLimitsConfig{
+			Beta: 1,
+			// Время корреляции около 1 мкс соответствует самому быстрому кровотоку в крупных сосудах.
+			CorrelationTimeMin: 0.001,
+			Tolerance:          0.05,
+			OverlayFilename:    "contrast_limits.png",
+		},
+		StaticScattering: StaticScatteringConfig{
+			Beta:           1,
+			StaticFilename: "static_fraction.png",
+			FlowFilename:   "flow_index.png",
+		},
+		Trend: TrendConfig{
+			Signal:        "intensity",
+			SegmentFrames: 10,
+			Filename:      "slope.png",
+		},
+		Biospeckle: BiospeckleConfig{
+			IMFilename:  "im.png",
+			AVDFilename: "avd.png",
+		},
+		Vasomotion: VasomotionConfig{
+			// Типичная полоса вазомоций (медленных колебаний тонуса сосудов).
+			BandMin:  0.01,
+			BandMax:  0.3,
+			Filename: "vasomotion.csv",
+		},
+		QuickLook: QuickLookConfig{
+			Method: "uniform",
+		},
+		Notifications: NotificationsConfig{
+			On:       "always",
+			TimeoutS: 30,
+		},
+	}
+
+	found, err := cfg.load(path, SourceFile, logger)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		// Отсутствие файла не считается фатальной ошибкой: используются значения по умолчанию.
+		logger.Printf("warn: config file '%s' not found, using default settings.\n", path)
+	}
+	if len(override) > 0 {
+		var probe struct {
+			Paths struct {
+				DataDir *string `json:"data_dir"`
+			} `json:"paths"`
+		}
+		if err = json.Unmarshal(override, &probe); err != nil {
+			return nil, fmt.Errorf("request settings: %w", err)
+		}
+		if probe.Paths.DataDir != nil {
+			cfg.Paths.DataDir = *probe.Paths.DataDir
+		}
+	}
+	if err = cfg.loadDataset(path, logger); err != nil {
+		return nil, err
+	}
+	if len(override) > 0 {
+		if err = cfg.apply("request settings", override, SourceRequest, logger); err != nil {
+			return nil, err
+		}
 	}
+	if err = cfg.applyCamera(); err != nil {
+		return nil, err
+	}
+	cfg.Paths.normalize()
+	return &cfg, nil
+}
 
+// load применяет к конфигурации файл path (пресет, затем явно указанные поля), отмечая
+// заданные в нем параметры источником source. Возвращает false, если файл не существует.
+func (c *Config) load(path string, source Source, logger *log.Logger) (bool, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
 		if os.IsNotExist(err) {
-			logger.Printf("warn: config file '%s' not found, using default settings.\n", path)
-			// Возвращаем конфиг по умолчанию; отсутствие файла не считается фатальной ошибкой.
-			return &cfg, nil
+			return false, nil
 		}
 		// Все другие ошибки (например, нет прав) считаются фатальными.
-		return nil, err
+		return false, err
 	}
+	return true, c.apply(path, data, source, logger)
+}
 
-	// Если файл успешно прочитан, пытаемся десериализовать JSON
-	// поверх значений по умолчанию.
-	if err = json.Unmarshal(data, &cfg); err != nil {
-		return nil, err
+// apply применяет к конфигурации документ data (пресет, затем явно указанные поля),
+// отмечая заданные в нем параметры источником source; path используется в сообщениях.
+func (c *Config) apply(path string, data []byte, source Source, logger *log.Logger) error {
+	// Блокнот Windows сохраняет UTF-8 с меткой порядка байтов, которую не принимает encoding/json.
+	data = pathutil.TrimBOM(data)
+	// Файлы прежних версий схемы обновляются до разбора: устаревшие параметры
+	// заменяются актуальными с предупреждением. Параметры запроса задаются
+	// в текущей версии схемы, и поле version в них не требуется.
+	var migrated bool
+	var err error
+	if source != SourceRequest {
+		if data, migrated, err = upgrade(path, data, logger); err != nil {
+			return err
+		}
+	}
+
+	// Сначала извлекаем только имя пресета, чтобы применить его значения
+	// до основных полей файла: так явно указанные поля переопределяют пресет.
+	// Так же заранее извлекается режим строгого разбора, чтобы проверить ключи файла до применения.
+	var probe struct {
+		StrictKeys *bool `json:"strict_keys"`
+		Algorithm  struct {
+			Preset string `json:"preset"`
+		} `json:"algorithm"`
+	}
+	if err = json.Unmarshal(data, &probe); err != nil {
+		return err
+	}
+	if probe.StrictKeys != nil {
+		c.StrictKeys = *probe.StrictKeys
+	}
+	if err = c.checkKeys(path, data, logger); err != nil {
+		return err
+	}
+	before, err := flatten(c)
+	if err != nil {
+		return err
+	}
+	if err = applyPreset(c, probe.Algorithm.Preset); err != nil {
+		return err
+	}
+	if err = c.trackChanges(before, presetSource(probe.Algorithm.Preset)); err != nil {
+		return err
+	}
+
+	// Десериализуем JSON поверх текущих значений (по умолчанию, пресета, предыдущих файлов).
+	if err = json.Unmarshal(data, c); err != nil {
+		return err
+	}
+	versionSource, hadVersion := c.sources["version"]
+	if err = c.trackKeys(data, source); err != nil {
+		return err
+	}
+	if migrated {
+		// Поле version добавлено при обновлении документа, а не задано в файле.
+		if hadVersion {
+			c.setSource("version", versionSource)
+		} else {
+			delete(c.sources, "version")
+		}
+	}
+	return nil
+}
+
+// loadDataset применяет поверх конфигурации файл DatasetConfigName из директории данных
+// (если он есть и не совпадает с основным файлом path). Относительные пути, указанные
+// в файле набора данных, отсчитываются от директории данных. Директорию данных
+// файл набора данных изменить не может.
+func (c *Config) loadDataset(path string, logger *log.Logger) error {
+	dataDir := c.Paths.DataDir
+	datasetPath := filepath.Join(pathutil.Native(dataDir), DatasetConfigName)
+	if same, err := sameFile(path, datasetPath); err != nil || same {
+		return err
+	}
+	found, err := c.load(datasetPath, SourceDataset, logger)
+	if err != nil {
+		return fmt.Errorf("dataset config '%s': %w", datasetPath, err)
+	}
+	if !found {
+		return nil
+	}
+	if c.sources["paths.data_dir"] == SourceDataset {
+		return fmt.Errorf("dataset config '%s' must not set paths.data_dir", datasetPath)
+	}
+	for key, path := range map[string]*string{
+		"paths.video":             &c.Paths.Video,
+		"paths.timestamps_file":   &c.Paths.TimestampsFile,
+		"paths.exposure_file":     &c.Paths.ExposureFile,
+		"paths.exclusion_mask":    &c.Paths.ExclusionMask,
+		"paths.calibration_image": &c.Paths.CalibrationImage,
+		"paths.camera_profile":    &c.Paths.CameraProfile,
+		"paths.bad_pixel_map":     &c.Paths.BadPixelMap,
+		"paths.tissue_mask":       &c.Paths.TissueMask,
+		"paths.flat_field":        &c.Paths.FlatField,
+		"paths.regions_file":      &c.Paths.RegionsFile,
+		"paths.results_dir":       &c.Paths.ResultsDir,
+	} {
+		if c.sources[key] == SourceDataset && *path != "" && !filepath.IsAbs(*path) {
+			*path = filepath.Join(dataDir, *path)
+		}
+	}
+	logger.Printf("dataset config '%s' applied over '%s'.\n", datasetPath, path)
+	return nil
+}
+
+// sameFile сообщает, указывают ли пути a и b на один и тот же существующий файл.
+func sameFile(a, b string) (bool, error) {
+	infoA, err := os.Stat(a)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	infoB, err := os.Stat(b)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return os.SameFile(infoA, infoB), nil
+}
+
+// normalize приводит пути к файлам и директориям к виду, пригодному для текущей платформы
+// (на Windows - к абсолютным путям, для которых поддерживаются длинные пути и UNC).
+// Имена выходных файлов не изменяются: они объединяются с ResultsDir.
+func (p *PathsConfig) normalize() {
+	for _, path := range []*string{&p.DataDir, &p.Video, &p.TimestampsFile, &p.ExposureFile, &p.ExclusionMask, &p.CalibrationImage, &p.CameraProfile, &p.BadPixelMap, &p.TissueMask, &p.FlatField, &p.RegionsFile, &p.ResultsDir} {
+		*path = pathutil.Native(*path)
 	}
-	// Возвращаем загруженную из файла конфигурацию.
-	return &cfg, nil
 }
@@ -0,0 +1,143 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+)
+
+// CurrentVersion - текущая версия схемы файла конфигурации. Файлы без поля version
+// относятся к версии 1 (до введения версий схемы).
+const CurrentVersion = 2
+
+// migration обновляет документ конфигурации с версии схемы from до from+1
+// и возвращает описания устаревших параметров, которые были заменены.
+type migration struct {
+	from  int
+	apply func(doc map[string]any) ([]string, error)
+}
+
+// migrations содержит миграции схемы в порядке версий.
+var migrations = []migration{
+	// Версия 2: имя файла карты стандартного отклонения согласовано с именем поля.
+	{from: 1, apply: func(doc map[string]any) ([]string, error) {
+		return renameKey(doc, "output", "std_filename", "stddev_filename")
+	}},
+}
+
+// Migration описывает результат обновления файла конфигурации до текущей версии схемы.
+type Migration struct {
+	// From - версия схемы исходного файла.
+	From int
+	// Notes - описания замененных устаревших параметров.
+	Notes []string
+	// Data - документ в текущей версии схемы (исходные данные, если обновление не требовалось).
+	Data []byte
+}
+
+// Migrate обновляет документ конфигурации data до текущей версии схемы. Если документ
+// уже в текущей версии, Data совпадает с data. Обновленный документ форматируется
+// с отступами, ключи упорядочиваются по алфавиту.
+// Возвращает ошибку, если документ не является объектом JSON, его версия новее
+// поддерживаемой или устаревший и новый параметры заданы одновременно.
+func Migrate(data []byte) (*Migration, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	// Числа сохраняются в исходной записи, чтобы обновление не меняло их представление.
+	dec.UseNumber()
+	var doc map[string]any
+	if err := dec.Decode(&doc); err != nil {
+		return nil, err
+	}
+	if doc == nil {
+		return nil, fmt.Errorf("config must be a JSON object")
+	}
+
+	version, err := schemaVersion(doc)
+	if err != nil {
+		return nil, err
+	}
+	m := &Migration{From: version, Data: data}
+	if version == CurrentVersion {
+		return m, nil
+	}
+	for _, step := range migrations {
+		if step.from < version {
+			continue
+		}
+		notes, err := step.apply(doc)
+		if err != nil {
+			return nil, fmt.Errorf("migrating config from version %d: %w", step.from, err)
+		}
+		m.Notes = append(m.Notes, notes...)
+	}
+	doc["version"] = CurrentVersion
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "    ")
+	if err = enc.Encode(doc); err != nil {
+		return nil, err
+	}
+	m.Data = buf.Bytes()
+	return m, nil
+}
+
+// schemaVersion возвращает версию схемы документа (1, если поле version отсутствует).
+func schemaVersion(doc map[string]any) (int, error) {
+	raw, ok := doc["version"]
+	if !ok {
+		return 1, nil
+	}
+	number, ok := raw.(json.Number)
+	if !ok {
+		return 0, fmt.Errorf("config version must be an integer, got %v", raw)
+	}
+	version, err := number.Int64()
+	if err != nil || version < 1 {
+		return 0, fmt.Errorf("config version must be a positive integer, got %s", number)
+	}
+	if version > CurrentVersion {
+		return 0, fmt.Errorf("config version %d is newer than the supported version %d; update go-tlasca", version, CurrentVersion)
+	}
+	return int(version), nil
+}
+
+// renameKey переносит значение параметра section.oldKey в section.newKey
+// и возвращает описание замены (nil, если устаревший параметр не задан).
+func renameKey(doc map[string]any, section, oldKey, newKey string) ([]string, error) {
+	values, ok := doc[section].(map[string]any)
+	if !ok {
+		return nil, nil
+	}
+	value, ok := values[oldKey]
+	if !ok {
+		return nil, nil
+	}
+	if _, ok := values[newKey]; ok {
+		return nil, fmt.Errorf("both deprecated '%s.%s' and '%s.%s' are set", section, oldKey, section, newKey)
+	}
+	delete(values, oldKey)
+	values[newKey] = value
+	return []string{fmt.Sprintf("'%s.%s' is deprecated, use '%s.%s'", section, oldKey, section, newKey)}, nil
+}
+
+// upgrade обновляет прочитанный из path документ до текущей версии схемы перед разбором,
+// выводя предупреждения о каждом устаревшем параметре. Возвращает документ и признак того,
+// что он был обновлен.
+func upgrade(path string, data []byte, logger *log.Logger) ([]byte, bool, error) {
+	m, err := Migrate(data)
+	if err != nil {
+		return nil, false, err
+	}
+	if m.From == CurrentVersion {
+		return data, false, nil
+	}
+	for _, note := range m.Notes {
+		logger.Printf("warn: config '%s': %s\n", path, note)
+	}
+	logger.Printf("warn: config '%s' uses schema version %d (current %d); run 'go-tlasca config migrate %s' to update it\n",
+		path, m.From, CurrentVersion, path)
+	return m.Data, true, nil
+}
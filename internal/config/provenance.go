@@ -0,0 +1,135 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+)
+
+// Source описывает происхождение значения параметра итоговой конфигурации.
+type Source string
+
+const (
+	// SourceDefault - значение по умолчанию.
+	SourceDefault Source = "default"
+	// SourceFile - значение, явно заданное в файле конфигурации.
+	SourceFile Source = "file"
+	// SourceDataset - значение, заданное в файле конфигурации набора данных (в директории данных).
+	SourceDataset Source = "dataset"
+	// SourceRequest - значение, переданное в параметрах запроса (см. Load), например
+	// внешней программой в режиме run --machine.
+	SourceRequest Source = "request"
+)
+
+// presetSource возвращает источник для значений пресета name.
+func presetSource(name string) Source {
+	return Source("preset:" + name)
+}
+
+// Setting - параметр итоговой конфигурации: ключ в виде пути через точку
+// ("algorithm.window_size"), значение в записи JSON и источник значения.
+type Setting struct {
+	Key    string          `json:"key"`
+	Value  json.RawMessage `json:"value"`
+	Source Source          `json:"source"`
+}
+
+// Effective возвращает все параметры итоговой конфигурации с источником каждого значения,
+// упорядоченные по ключу. Массивы (шаблоны, области интереса, кривые) считаются одним параметром.
+func (c *Config) Effective() ([]Setting, error) {
+	values, err := flatten(c)
+	if err != nil {
+		return nil, err
+	}
+	settings := make([]Setting, 0, len(values))
+	for key, value := range values {
+		source, ok := c.sources[key]
+		if !ok {
+			source = SourceDefault
+		}
+		settings = append(settings, Setting{Key: key, Value: value, Source: source})
+	}
+	sort.Slice(settings, func(i, j int) bool { return settings[i].Key < settings[j].Key })
+	return settings, nil
+}
+
+// trackChanges отмечает источником source параметры, значения которых в cfg
+// отличаются от before (снимка flatten до применения источника).
+func (c *Config) trackChanges(before map[string]json.RawMessage, source Source) error {
+	after, err := flatten(c)
+	if err != nil {
+		return err
+	}
+	for key, value := range after {
+		if !bytes.Equal(value, before[key]) {
+			c.setSource(key, source)
+		}
+	}
+	return nil
+}
+
+// trackKeys отмечает источником source параметры, заданные в документе data
+// (независимо от того, совпадают ли значения с прежними).
+func (c *Config) trackKeys(data []byte, source Source) error {
+	var doc map[string]any
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return err
+	}
+	known, err := flatten(c)
+	if err != nil {
+		return err
+	}
+	var walk func(prefix string, doc map[string]any)
+	walk = func(prefix string, doc map[string]any) {
+		for key, value := range doc {
+			key = prefix + key
+			if _, ok := known[key]; ok {
+				c.setSource(key, source)
+			} else if nested, ok := value.(map[string]any); ok {
+				walk(key+".", nested)
+			}
+		}
+	}
+	walk("", doc)
+	return nil
+}
+
+// setSource задает источник значения параметра key.
+func (c *Config) setSource(key string, source Source) {
+	if c.sources == nil {
+		c.sources = make(map[string]Source)
+	}
+	c.sources[key] = source
+}
+
+// flatten возвращает параметры конфигурации cfg с ключами в виде пути через точку.
+// Вложенные объекты раскрываются, остальные значения (включая массивы) сохраняются как есть.
+func flatten(cfg *Config) (map[string]json.RawMessage, error) {
+	// Символы <, > и & сохраняются как есть, чтобы значения в логе совпадали с записью в файле.
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(cfg); err != nil {
+		return nil, err
+	}
+	data := bytes.TrimSpace(buf.Bytes())
+	values := make(map[string]json.RawMessage)
+	var walk func(prefix string, data json.RawMessage) error
+	walk = func(prefix string, data json.RawMessage) error {
+		var object map[string]json.RawMessage
+		if prefix != "" && (len(data) == 0 || data[0] != '{') {
+			values[prefix[:len(prefix)-1]] = data
+			return nil
+		}
+		if err := json.Unmarshal(data, &object); err != nil {
+			return err
+		}
+		for key, value := range object {
+			if err := walk(prefix+key+".", value); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return values, walk("", data)
+}
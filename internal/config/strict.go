@@ -0,0 +1,155 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// checkKeys проверяет, что документ data из файла path не содержит неизвестных параметров.
+// В строгом режиме (StrictKeys) неизвестный параметр - ошибка, иначе выводится предупреждение.
+func (c *Config) checkKeys(path string, data []byte, logger *log.Logger) error {
+	var doc map[string]any
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return err
+	}
+	problems := unknownKeys(doc)
+	if len(problems) == 0 {
+		return nil
+	}
+	if !c.StrictKeys {
+		for _, problem := range problems {
+			logger.Printf("warn: config '%s': ignoring %s\n", path, problem)
+		}
+		return nil
+	}
+	return fmt.Errorf("config '%s': %s; fix the key or set \"strict_keys\": false to ignore unknown keys",
+		path, strings.Join(problems, "; "))
+}
+
+// unknownKeys возвращает описания параметров документа doc, которых нет в конфигурации
+// (например, опечаток вроде "windwo_size"), с подсказкой ближайшего известного имени.
+// Проверяются вложенные секции и элементы массивов секций (области интереса).
+func unknownKeys(doc map[string]any) []string {
+	var problems []string
+	walkUnknown(doc, reflect.TypeFor[Config](), "", &problems)
+	sort.Strings(problems)
+	return problems
+}
+
+// walkUnknown сверяет значение value с типом t и добавляет в problems описания
+// неизвестных ключей с путем prefix.
+func walkUnknown(value any, t reflect.Type, prefix string, problems *[]string) {
+	switch t.Kind() {
+	case reflect.Pointer:
+		walkUnknown(value, t.Elem(), prefix, problems)
+	case reflect.Slice:
+		items, ok := value.([]any)
+		if !ok || t.Elem().Kind() != reflect.Struct {
+			return
+		}
+		for i, item := range items {
+			walkUnknown(item, t.Elem(), fmt.Sprintf("%s[%d]", prefix, i), problems)
+		}
+	case reflect.Map:
+		items, ok := value.(map[string]any)
+		if !ok || t.Elem().Kind() != reflect.Struct {
+			return
+		}
+		for key, item := range items {
+			path := key
+			if prefix != "" {
+				path = prefix + "." + key
+			}
+			walkUnknown(item, t.Elem(), path, problems)
+		}
+	case reflect.Struct:
+		object, ok := value.(map[string]any)
+		if !ok {
+			return
+		}
+		fields := jsonFields(t)
+		for key, nested := range object {
+			path := key
+			if prefix != "" {
+				path = prefix + "." + key
+			}
+			field, ok := fields[key]
+			if !ok {
+				problem := fmt.Sprintf("unknown key '%s'", path)
+				if suggestion := closest(key, fields); suggestion != "" {
+					if prefix != "" {
+						suggestion = prefix + "." + suggestion
+					}
+					problem += fmt.Sprintf(" (did you mean '%s'?)", suggestion)
+				}
+				*problems = append(*problems, problem)
+				continue
+			}
+			walkUnknown(nested, field, path, problems)
+		}
+	}
+}
+
+// jsonFields возвращает имена полей JSON экспортируемых полей структуры t и их типы.
+func jsonFields(t reflect.Type) map[string]reflect.Type {
+	fields := make(map[string]reflect.Type, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		fields[name] = field.Type
+	}
+	return fields
+}
+
+// closest возвращает известное имя, ближайшее к key по расстоянию Левенштейна
+// (не более трети длины key), или пустую строку, если похожих имен нет.
+func closest(key string, fields map[string]reflect.Type) string {
+	best, bestDistance := "", max(len(key)/3, 1)+1
+	for name := range fields {
+		d := levenshtein(strings.ToLower(key), name)
+		if d < bestDistance || (d == bestDistance && name < best) {
+			best, bestDistance = name, d
+		}
+	}
+	return best
+}
+
+// levenshtein возвращает расстояние Левенштейна между строками a и b
+// (перестановка соседних символов считается одной правкой).
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev2 := make([]int, len(rb)+1)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				cur[j] = min(cur[j], prev2[j-2]+1)
+			}
+		}
+		prev2, prev, cur = prev, cur, prev2
+	}
+	return prev[len(rb)]
+}
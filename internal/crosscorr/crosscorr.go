@@ -0,0 +1,141 @@
+// Package crosscorr вычисляет взаимную корреляцию временных рядов областей интереса (ROI)
+// с задержками. По задержке максимума корреляции можно оценить, с каким запаздыванием
+// изменения перфузии распространяются от одной области к другой.
+package crosscorr
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+
+	"github.com/mascotmascot1/go-tlasca/internal/atomicfile"
+	"github.com/mascotmascot1/go-tlasca/pkg/units"
+)
+
+// Pair - результат взаимной корреляции двух областей.
+type Pair struct {
+	// A и B - имена областей. Положительная задержка означает, что ряд B запаздывает относительно A.
+	A string `json:"a"`
+	B string `json:"b"`
+	// PeakLag - задержка максимума |r| в отсчетах рядов: в кадрах или, если ряды приведены
+	// к равномерной сетке по временным меткам, в шагах сетки.
+	PeakLag int `json:"peak_lag_frames"`
+	// PeakLagSeconds - та же задержка в секундах, если известен межкадровый интервал.
+	PeakLagSeconds *float64 `json:"peak_lag_s,omitempty"`
+	// PeakCorrelation - коэффициент корреляции Пирсона при задержке PeakLag.
+	PeakCorrelation float64 `json:"peak_correlation"`
+	// Correlation - коэффициенты корреляции для задержек -maxLag..maxLag.
+	Correlation []float64 `json:"-"`
+}
+
+// CrossCorrelate вычисляет коэффициент корреляции Пирсона r(k) между a[t] и b[t+k]
+// для задержек k = -maxLag..maxLag по перекрывающейся части рядов.
+// Результат имеет длину 2*maxLag+1; задержкам с перекрытием меньше 3 отсчетов
+// или с постоянным рядом соответствует 0.
+func CrossCorrelate(a, b []float64, maxLag int) []float64 {
+	result := make([]float64, 2*maxLag+1)
+	for k := -maxLag; k <= maxLag; k++ {
+		start, end := max(0, -k), min(len(a), len(b)-k)
+		if end-start < 3 {
+			continue
+		}
+		result[k+maxLag] = pearson(a[start:end], b[start+k:end+k])
+	}
+	return result
+}
+
+// Analyze вычисляет взаимную корреляцию для каждой пары областей (i < j).
+// series[i] - временной ряд области names[i] с постоянным шагом. interval - шаг рядов
+// в секундах для пересчета задержек (0 - неизвестен).
+func Analyze(names []string, series [][]float64, maxLag int, interval float64) []Pair {
+	var pairs []Pair
+	for i := range series {
+		for j := i + 1; j < len(series); j++ {
+			corr := CrossCorrelate(series[i], series[j], maxLag)
+			peak := 0
+			for k := range corr {
+				if math.Abs(corr[k]) > math.Abs(corr[peak]) {
+					peak = k
+				}
+			}
+			p := Pair{
+				A:               names[i],
+				B:               names[j],
+				PeakLag:         peak - maxLag,
+				PeakCorrelation: corr[peak],
+				Correlation:     corr,
+			}
+			if interval > 0 {
+				seconds := float64(p.PeakLag) * interval
+				p.PeakLagSeconds = &seconds
+			}
+			pairs = append(pairs, p)
+		}
+	}
+	return pairs
+}
+
+// WriteCSV сохраняет полные корреляционные функции пар в CSV-файл со столбцами
+// a, b, lag_frames, correlation (и lag_s, если известен межкадровый интервал).
+func WriteCSV(path string, pairs []Pair, maxLag int, interval float64) error {
+	return atomicfile.Write(path, func(file io.Writer) error {
+		w := csv.NewWriter(file)
+		header := []string{"a", "b", "lag_frames", "correlation"}
+		if interval > 0 {
+			header = append(header, units.Column("lag", units.Second))
+		}
+		if err := w.Write(header); err != nil {
+			return err
+		}
+		for _, p := range pairs {
+			for k, r := range p.Correlation {
+				lag := k - maxLag
+				record := []string{p.A, p.B, strconv.Itoa(lag), strconv.FormatFloat(r, 'f', 4, 64)}
+				if interval > 0 {
+					record = append(record, strconv.FormatFloat(float64(lag)*interval, 'g', 6, 64))
+				}
+				if err := w.Write(record); err != nil {
+					return err
+				}
+			}
+		}
+		w.Flush()
+		return w.Error()
+	})
+}
+
+// String возвращает краткое описание пары для лога.
+func (p Pair) String() string {
+	s := fmt.Sprintf("%s -> %s: peak r=%.3f at lag %d frames", p.A, p.B, p.PeakCorrelation, p.PeakLag)
+	if p.PeakLagSeconds != nil {
+		s += fmt.Sprintf(" (%.4gs)", *p.PeakLagSeconds)
+	}
+	return s
+}
+
+// pearson возвращает коэффициент корреляции Пирсона двух рядов одинаковой длины
+// (0, если один из рядов постоянен).
+func pearson(a, b []float64) float64 {
+	n := float64(len(a))
+	var meanA, meanB float64
+	for i := range a {
+		meanA += a[i]
+		meanB += b[i]
+	}
+	meanA /= n
+	meanB /= n
+
+	var cov, varA, varB float64
+	for i := range a {
+		da, db := a[i]-meanA, b[i]-meanB
+		cov += da * db
+		varA += da * da
+		varB += db * db
+	}
+	if varA == 0 || varB == 0 {
+		return 0
+	}
+	return cov / math.Sqrt(varA*varB)
+}
@@ -0,0 +1,91 @@
+// Package decimate выбирает представительное подмножество кадров последовательности
+// для быстрого предварительного анализа (quick-look): равномерно во времени
+// или со стратификацией по общей интенсивности кадров.
+package decimate
+
+import (
+	"math"
+	"sort"
+)
+
+// Method задает способ выбора кадров.
+type Method string
+
+const (
+	// Uniform - кадры, равномерно распределенные во времени записи.
+	Uniform Method = "uniform"
+	// Intensity - кадры, стратифицированные по средней интенсивности: последовательность
+	// делится на страты равной численности по возрастанию интенсивности, из каждой
+	// выбирается кадр с медианной интенсивностью. Подмножество сохраняет распределение
+	// яркости записи (например, при дрейфе освещения или вспышках).
+	Intensity Method = "intensity"
+)
+
+// Selection описывает выбранное подмножество кадров для отчета о запуске.
+type Selection struct {
+	// Method - способ выбора.
+	Method Method `json:"method"`
+	// Total - число кадров исходной последовательности.
+	Total int `json:"total"`
+	// Frames - номера выбранных кадров (из имен файлов) в порядке последовательности.
+	Frames []int `json:"frames"`
+}
+
+// UniformIndices выбирает n из total кадров, равномерно распределенных во времени,
+// включая первый и последний. times задает времена регистрации кадров (nil - кадры
+// следуют с постоянным интервалом); при неравномерной съемке выбираются кадры,
+// ближайшие к равноотстоящим моментам времени. Возвращает возрастающие индексы.
+func UniformIndices(times []float64, total, n int) []int {
+	if n >= total {
+		return all(total)
+	}
+	indices := make([]int, 0, n)
+	for i := 0; i < n; i++ {
+		var idx int
+		if times == nil {
+			idx = int(math.Round(float64(i) * float64(total-1) / float64(n-1)))
+		} else {
+			target := times[0] + float64(i)*(times[total-1]-times[0])/float64(n-1)
+			idx = sort.SearchFloat64s(times, target)
+			if idx == total || (idx > 0 && target-times[idx-1] < times[idx]-target) {
+				idx--
+			}
+		}
+		// Индексы строго возрастают: при сгущении кадров берется следующий непосредственно
+		// за предыдущим, оставляя место для оставшихся выборок.
+		if len(indices) > 0 {
+			idx = max(idx, indices[len(indices)-1]+1)
+		}
+		idx = min(idx, total-(n-i))
+		indices = append(indices, idx)
+	}
+	return indices
+}
+
+// StratifiedIndices выбирает n кадров, стратифицированных по значениям values
+// (средней интенсивности каждого кадра), см. Intensity. Возвращает возрастающие индексы.
+func StratifiedIndices(values []float64, n int) []int {
+	total := len(values)
+	if n >= total {
+		return all(total)
+	}
+	order := all(total)
+	sort.SliceStable(order, func(i, j int) bool { return values[order[i]] < values[order[j]] })
+
+	indices := make([]int, n)
+	for k := 0; k < n; k++ {
+		stratum := order[k*total/n : (k+1)*total/n]
+		indices[k] = stratum[len(stratum)/2]
+	}
+	sort.Ints(indices)
+	return indices
+}
+
+// all возвращает индексы 0..total-1.
+func all(total int) []int {
+	indices := make([]int, total)
+	for i := range indices {
+		indices[i] = i
+	}
+	return indices
+}
@@ -0,0 +1,148 @@
+// Package deepzoom экспортирует изображение в виде тайловой пирамиды формата
+// Deep Zoom (DZI), которую веб-просмотрщики (например, OpenSeadragon) загружают
+// по частям: при панорамировании и масштабировании запрашиваются только видимые тайлы
+// нужного уровня, а не все изображение целиком.
+package deepzoom
+
+import (
+	"fmt"
+	"image"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/mascotmascot1/go-tlasca/internal/atomicfile"
+	"github.com/mascotmascot1/go-tlasca/internal/imageutils"
+	"github.com/mascotmascot1/go-tlasca/internal/parallel"
+)
+
+// Options задает параметры пирамиды.
+type Options struct {
+	// TileSize - размер стороны тайла без учета перекрытия, пикселей.
+	TileSize int
+	// Overlap - перекрытие соседних тайлов, пикселей (обычно 1), устраняющее
+	// видимые швы при интерполяции в просмотрщике.
+	Overlap int
+}
+
+// descriptor - XML-описание пирамиды в формате Deep Zoom.
+const descriptor = `<?xml version="1.0" encoding="UTF-8"?>
+<Image xmlns="http://schemas.microsoft.com/deepzoom/2008" TileSize="%d" Overlap="%d" Format="png">
+  <Size Width="%d" Height="%d"/>
+</Image>
+`
+
+// Write сохраняет изображение img как пирамиду Deep Zoom в директорию dir:
+// описание name.dzi и тайлы name_files/<уровень>/<столбец>_<строка>.png.
+//
+// Уровень L имеет размер исходного изображения, уменьшенного в 2^(L_max-L) раз
+// (с округлением вверх), где L_max = ceil(log2(max(ширина, высота))); уровень 0
+// состоит из одного пикселя. Каждый следующий уровень вниз получается усреднением
+// блоков 2x2 предыдущего.
+//
+// Возвращает путь к файлу описания .dzi.
+func Write(dir, name string, img *image.Gray, opts Options) (string, error) {
+	if opts.TileSize <= 0 || opts.Overlap < 0 {
+		return "", fmt.Errorf("invalid tile size %d or overlap %d", opts.TileSize, opts.Overlap)
+	}
+	bounds := img.Bounds()
+	tilesDir := filepath.Join(dir, name+"_files")
+	if err := os.RemoveAll(tilesDir); err != nil {
+		return "", fmt.Errorf("failed to clear tiles directory '%s': %w", tilesDir, err)
+	}
+
+	level := maxLevel(bounds.Dx(), bounds.Dy())
+	for current := img; ; current = downsample(current) {
+		if err := writeLevel(filepath.Join(tilesDir, strconv.Itoa(level)), current, opts); err != nil {
+			return "", fmt.Errorf("failed to write level %d: %w", level, err)
+		}
+		if level == 0 {
+			break
+		}
+		level--
+	}
+
+	path := filepath.Join(dir, name+".dzi")
+	xml := fmt.Sprintf(descriptor, opts.TileSize, opts.Overlap, bounds.Dx(), bounds.Dy())
+	if err := atomicfile.WriteFile(path, []byte(xml)); err != nil {
+		return "", fmt.Errorf("failed to write descriptor '%s': %w", path, err)
+	}
+	return path, nil
+}
+
+// MaxSize возвращает верхнюю оценку суммарного размера файлов пирамиды для 8-битного
+// изображения width x height: тайлы всех уровней с перекрытиями и описание .dzi.
+func MaxSize(width, height int, opts Options) uint64 {
+	size := uint64(len(descriptor)) + 64
+	if opts.TileSize <= 0 {
+		return size
+	}
+	for level := maxLevel(width, height); level >= 0; level-- {
+		for y := 0; y < height; y += opts.TileSize {
+			for x := 0; x < width; x += opts.TileSize {
+				tileW := min(x+opts.TileSize+opts.Overlap, width) - max(x-opts.Overlap, 0)
+				tileH := min(y+opts.TileSize+opts.Overlap, height) - max(y-opts.Overlap, 0)
+				size += imageutils.MaxPNGSize(tileW, tileH, 1)
+			}
+		}
+		width, height = (width+1)/2, (height+1)/2
+	}
+	return size
+}
+
+// maxLevel возвращает номер уровня полного разрешения: ceil(log2(max(width, height))).
+func maxLevel(width, height int) int {
+	size := max(width, height)
+	level := 0
+	for (1 << level) < size {
+		level++
+	}
+	return level
+}
+
+// writeLevel нарезает изображение одного уровня на тайлы и сохраняет их в dir.
+// Тайл (col, row) покрывает область [col*TileSize, (col+1)*TileSize) с перекрытием
+// Overlap пикселей с каждой стороны, обрезанную границами изображения.
+// Тайлы независимы, поэтому кодируются параллельно.
+func writeLevel(dir string, img *image.Gray, opts Options) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	bounds := img.Bounds()
+	cols := (bounds.Dx() + opts.TileSize - 1) / opts.TileSize
+	rows := (bounds.Dy() + opts.TileSize - 1) / opts.TileSize
+	return parallel.Each(cols*rows, func(i int) error {
+		col, row := i%cols, i/cols
+		tile := image.Rect(
+			col*opts.TileSize-opts.Overlap, row*opts.TileSize-opts.Overlap,
+			(col+1)*opts.TileSize+opts.Overlap, (row+1)*opts.TileSize+opts.Overlap,
+		).Add(bounds.Min).Intersect(bounds)
+
+		path := filepath.Join(dir, fmt.Sprintf("%d_%d.png", col, row))
+		return imageutils.SaveImage(path, img.SubImage(tile).(*image.Gray))
+	})
+}
+
+// downsample уменьшает изображение вдвое по каждой оси (с округлением вверх),
+// усредняя блоки 2x2; на нечетной границе усредняются только существующие пиксели.
+func downsample(img *image.Gray) *image.Gray {
+	bounds := img.Bounds()
+	width, height := (bounds.Dx()+1)/2, (bounds.Dy()+1)/2
+	out := image.NewGray(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			var sum, count int
+			for dy := 0; dy < 2; dy++ {
+				for dx := 0; dx < 2; dx++ {
+					sx, sy := 2*x+dx, 2*y+dy
+					if sx < bounds.Dx() && sy < bounds.Dy() {
+						sum += int(img.Pix[img.PixOffset(bounds.Min.X+sx, bounds.Min.Y+sy)])
+						count++
+					}
+				}
+			}
+			out.Pix[y*out.Stride+x] = uint8((sum + count/2) / count)
+		}
+	}
+	return out
+}
@@ -0,0 +1,69 @@
+// Package denoise содержит фильтры подавления шума итоговой карты контраста - альтернативу
+// увеличению окна усреднения, которое снижает пространственное разрешение.
+package denoise
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/mascotmascot1/go-tlasca/internal/config"
+	"github.com/mascotmascot1/go-tlasca/pkg/mask"
+)
+
+// Filter подавляет шум карты контраста.
+type Filter interface {
+	// Apply возвращает отфильтрованную копию карты contrast размера width x height.
+	// Положения, отмеченные в skip (может быть nil), и неположительные значения не изменяются
+	// и не влияют на соседей. noise - относительный шум оценки контраста σ_K/K (см. RelativeNoise).
+	Apply(contrast []float64, width, height int, skip *mask.Mask, noise float64) []float64
+	// String возвращает краткое описание фильтра для логов.
+	String() string
+}
+
+// New создает фильтр по параметрам cfg. Для метода "none" возвращает nil.
+func New(cfg config.DenoiseConfig) (Filter, error) {
+	switch cfg.Method {
+	case "", "none":
+		return nil, nil
+	case "nlm":
+		if cfg.PatchRadius < 0 || cfg.SearchRadius < 1 {
+			return nil, fmt.Errorf("nlm requires patch_radius >= 0 and search_radius >= 1, got %d and %d",
+				cfg.PatchRadius, cfg.SearchRadius)
+		}
+		if cfg.Strength <= 0 {
+			return nil, fmt.Errorf("nlm strength must be positive, got %g", cfg.Strength)
+		}
+		return NonLocalMeans{PatchRadius: cfg.PatchRadius, SearchRadius: cfg.SearchRadius, Strength: cfg.Strength}, nil
+	case "anisotropic":
+		if cfg.Iterations < 1 {
+			return nil, fmt.Errorf("anisotropic diffusion requires at least 1 iteration, got %d", cfg.Iterations)
+		}
+		if cfg.Kappa <= 0 {
+			return nil, fmt.Errorf("anisotropic diffusion kappa must be positive, got %g", cfg.Kappa)
+		}
+		return AnisotropicDiffusion{Iterations: cfg.Iterations, Kappa: cfg.Kappa}, nil
+	default:
+		return nil, fmt.Errorf("unknown denoise method '%s', expected none, nlm or anisotropic", cfg.Method)
+	}
+}
+
+// RelativeNoise оценивает относительный шум σ_K/K карты контраста, рассчитанной
+// по samples отсчетам на пиксель (кадров во временном режиме, отсчетов окна или объема
+// в покадровых режимах) и усредненной окном из averaged пикселей. Для оценки контраста
+// по n независимым отсчетам σ_K/K ≈ 1/sqrt(2(n-1)); усреднение по averaged пикселям
+// снижает шум в sqrt(averaged) раз.
+func RelativeNoise(samples, averaged int) float64 {
+	if samples < 2 {
+		return 0
+	}
+	return 1 / math.Sqrt(2*float64(samples-1)*float64(max(averaged, 1)))
+}
+
+// valid отмечает положения карты, участвующие в фильтрации.
+func valid(contrast []float64, skip *mask.Mask) []bool {
+	ok := make([]bool, len(contrast))
+	for i, k := range contrast {
+		ok[i] = k > 0 && !math.IsInf(k, 0) && (skip == nil || !skip.Set[i])
+	}
+	return ok
+}
@@ -0,0 +1,96 @@
+package denoise
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/mascotmascot1/go-tlasca/internal/parallel"
+	"github.com/mascotmascot1/go-tlasca/pkg/mask"
+)
+
+// NonLocalMeans - фильтр нелокальных средних (NLM): значение положения заменяется
+// взвешенным средним положений области поиска радиуса SearchRadius, вес которых
+// убывает с различием их окрестностей (патчей) радиуса PatchRadius. В отличие от
+// усреднения окном, фильтр усредняет только схожие участки и сохраняет границы сосудов.
+//
+// Фильтрация выполняется над ln K: шум оценки контраста мультипликативен (σ_K ∝ K),
+// а после логарифмирования примерно одинаков для всех значений и равен noise (см. Filter).
+// Поэтому порог схожести патчей h = Strength·noise подбирается по статистике контраста,
+// а не по абсолютным значениям карты. Результат - среднее геометрическое схожих значений.
+type NonLocalMeans struct {
+	PatchRadius, SearchRadius int
+	Strength                  float64
+}
+
+// Apply реализует Filter. Строки карты обрабатываются параллельно.
+func (f NonLocalMeans) Apply(contrast []float64, width, height int, skip *mask.Mask, noise float64) []float64 {
+	out := make([]float64, len(contrast))
+	copy(out, contrast)
+	if noise <= 0 {
+		return out
+	}
+	ok := valid(contrast, skip)
+	logK := make([]float64, len(contrast))
+	for i, k := range contrast {
+		if ok[i] {
+			logK[i] = math.Log(k)
+		}
+	}
+	h2 := f.Strength * f.Strength * noise * noise
+	// Ожидаемое расстояние между патчами одинаковых участков (2σ²) вычитается из расстояния,
+	// чтобы патчи, различающиеся только шумом, получали вес, близкий к 1.
+	bias := 2 * noise * noise
+	pr, sr := f.PatchRadius, f.SearchRadius
+
+	parallel.Rows(height, func(startY, endY int) {
+		for y := startY; y < endY; y++ {
+			for x := range width {
+				p := y*width + x
+				if !ok[p] {
+					continue
+				}
+				var sum, weights float64
+				for qy := max(y-sr, 0); qy <= min(y+sr, height-1); qy++ {
+					for qx := max(x-sr, 0); qx <= min(x+sr, width-1); qx++ {
+						q := qy*width + qx
+						if !ok[q] {
+							continue
+						}
+						var d2 float64
+						var n int
+						for dy := -pr; dy <= pr; dy++ {
+							py, ny := y+dy, qy+dy
+							if py < 0 || ny < 0 || py >= height || ny >= height {
+								continue
+							}
+							for dx := -pr; dx <= pr; dx++ {
+								px, nx := x+dx, qx+dx
+								if px < 0 || nx < 0 || px >= width || nx >= width {
+									continue
+								}
+								a, b := py*width+px, ny*width+nx
+								if ok[a] && ok[b] {
+									d := logK[a] - logK[b]
+									d2 += d * d
+									n++
+								}
+							}
+						}
+						if n == 0 {
+							continue
+						}
+						w := math.Exp(-max(d2/float64(n)-bias, 0) / h2)
+						sum += w * logK[q]
+						weights += w
+					}
+				}
+				out[p] = math.Exp(sum / weights)
+			}
+		}
+	})
+	return out
+}
+
+func (f NonLocalMeans) String() string {
+	return fmt.Sprintf("nlm (patch radius %d, search radius %d, strength %g)", f.PatchRadius, f.SearchRadius, f.Strength)
+}
@@ -0,0 +1,83 @@
+package diagnostics
+
+import (
+	"encoding/csv"
+	"image"
+	"image/color"
+	"io"
+	"math"
+	"strconv"
+
+	"github.com/mascotmascot1/go-tlasca/internal/atomicfile"
+	"github.com/mascotmascot1/go-tlasca/internal/plot"
+)
+
+// ConvergenceSummary описывает сходимость контраста опорной области по числу кадров.
+type ConvergenceSummary struct {
+	// Frames - полное число использованных кадров.
+	Frames int `json:"frames"`
+	// Final - контраст области по всем кадрам.
+	Final float64 `json:"final"`
+	// Tolerance - допустимое относительное отклонение от Final.
+	Tolerance float64 `json:"tolerance"`
+	// StableAfter - наименьшее число кадров, начиная с которого контраст по первым
+	// n кадрам при любом большем n отличается от Final не более чем на Tolerance.
+	StableAfter int `json:"stable_after"`
+}
+
+// Converged сообщает, устойчива ли оценка контраста: StableAfter не превышает 90%
+// числа кадров, т.е. последние 10% записи уже не меняют оценку больше чем на Tolerance.
+func (s ConvergenceSummary) Converged() bool {
+	return float64(s.StableAfter) <= 0.9*float64(s.Frames)
+}
+
+// SummarizeConvergence вычисляет сводку сходимости по кривой curve,
+// где curve[i] - контраст по первым i+2 кадрам (см. tlasca.Runner.Convergence).
+func SummarizeConvergence(curve []float64, tolerance float64) ConvergenceSummary {
+	final := curve[len(curve)-1]
+	s := ConvergenceSummary{Frames: len(curve) + 1, Final: final, Tolerance: tolerance, StableAfter: len(curve) + 1}
+	for i := len(curve) - 1; i >= 0; i-- {
+		if math.Abs(curve[i]-final) > tolerance*math.Abs(final) {
+			break
+		}
+		s.StableAfter = i + 2
+	}
+	return s
+}
+
+// WriteConvergenceCSV сохраняет кривую сходимости в CSV-файл со столбцами
+// frames, contrast, relative_deviation (относительное отклонение от контраста по всем кадрам).
+func WriteConvergenceCSV(path string, curve []float64) error {
+	final := curve[len(curve)-1]
+	return atomicfile.Write(path, func(file io.Writer) error {
+		w := csv.NewWriter(file)
+		if err := w.Write([]string{"frames", "contrast", "relative_deviation"}); err != nil {
+			return err
+		}
+		for i, k := range curve {
+			var deviation float64
+			if final != 0 {
+				deviation = (k - final) / final
+			}
+			record := []string{
+				strconv.Itoa(i + 2),
+				strconv.FormatFloat(k, 'g', 6, 64),
+				strconv.FormatFloat(deviation, 'g', 4, 64),
+			}
+			if err := w.Write(record); err != nil {
+				return err
+			}
+		}
+		w.Flush()
+		return w.Error()
+	})
+}
+
+// ConvergencePlot строит график контраста по числу кадров; границы допуска
+// final·(1 ± tolerance) отображаются пунктирными линиями.
+func ConvergencePlot(curve []float64, tolerance float64) *image.RGBA {
+	final := curve[len(curve)-1]
+	return plot.LineChart(640, 320, []plot.Series{
+		{Values: curve, Color: color.RGBA{B: 220, A: 255}},
+	}, []float64{final * (1 - tolerance), final * (1 + tolerance)})
+}
@@ -0,0 +1,108 @@
+// Package diagnostics выявляет кадры-артефакты по их вкладу в итоговый контраст,
+// оценивает сходимость контраста по числу кадров и сохраняет результаты диагностики.
+package diagnostics
+
+import (
+	"encoding/csv"
+	"io"
+	"math"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"github.com/mascotmascot1/go-tlasca/internal/atomicfile"
+)
+
+// madScale приводит медианное абсолютное отклонение к стандартному отклонению
+// для нормального распределения.
+const madScale = 1.4826
+
+// Contribution описывает вклад одного кадра в контраст опорной области.
+type Contribution struct {
+	// Frame - индекс кадра в обработанной (отсортированной) последовательности.
+	Frame int
+	// DeltaK - изменение среднего контраста области при исключении кадра (K - K_без_кадра).
+	DeltaK float64
+	// Score - робастная z-оценка DeltaK: отклонение от медианы в единицах MAD*1.4826.
+	Score float64
+	// Flagged отмечает кадры, у которых |Score| превышает порог.
+	Flagged bool
+}
+
+// Flag вычисляет робастные z-оценки вкладов кадров и отмечает кадры,
+// у которых |z| > threshold. Медиана и MAD устойчивы к самим выбросам,
+// поэтому единичные доминирующие кадры не маскируют друг друга.
+// Если MAD равно нулю (все вклады одинаковы), кадры не отмечаются.
+func Flag(deltas []float64, threshold float64) []Contribution {
+	center := median(deltas)
+	deviations := make([]float64, len(deltas))
+	for i, d := range deltas {
+		deviations[i] = math.Abs(d - center)
+	}
+	spread := madScale * median(deviations)
+
+	contributions := make([]Contribution, len(deltas))
+	for i, d := range deltas {
+		c := Contribution{Frame: i, DeltaK: d}
+		if spread > 0 {
+			c.Score = (d - center) / spread
+			c.Flagged = math.Abs(c.Score) > threshold
+		}
+		contributions[i] = c
+	}
+	return contributions
+}
+
+// Flagged возвращает только отмеченные кадры.
+func Flagged(contributions []Contribution) []Contribution {
+	var flagged []Contribution
+	for _, c := range contributions {
+		if c.Flagged {
+			flagged = append(flagged, c)
+		}
+	}
+	return flagged
+}
+
+// WriteCSV сохраняет вклады кадров в CSV-файл со столбцами
+// frame, file, delta_k, score, flagged. files задает пути к кадрам для столбца file.
+func WriteCSV(path string, contributions []Contribution, files []string) error {
+	return atomicfile.Write(path, func(file io.Writer) error {
+		w := csv.NewWriter(file)
+		if err := w.Write([]string{"frame", "file", "delta_k", "score", "flagged"}); err != nil {
+			return err
+		}
+		for _, c := range contributions {
+			var name string
+			if c.Frame < len(files) {
+				name = filepath.Base(files[c.Frame])
+			}
+			record := []string{
+				strconv.Itoa(c.Frame),
+				name,
+				strconv.FormatFloat(c.DeltaK, 'g', 6, 64),
+				strconv.FormatFloat(c.Score, 'f', 2, 64),
+				strconv.FormatBool(c.Flagged),
+			}
+			if err := w.Write(record); err != nil {
+				return err
+			}
+		}
+		w.Flush()
+		return w.Error()
+	})
+}
+
+// median возвращает медиану значений (0 для пустого среза); исходный срез не изменяется.
+func median(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[mid-1] + sorted[mid]) / 2
+	}
+	return sorted[mid]
+}
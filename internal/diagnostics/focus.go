@@ -0,0 +1,137 @@
+package diagnostics
+
+import (
+	"encoding/csv"
+	"image"
+	"io"
+	"strconv"
+
+	"github.com/mascotmascot1/go-tlasca/internal/atomicfile"
+	"github.com/mascotmascot1/go-tlasca/pkg/units"
+)
+
+// FocusTile описывает резкость одного тайла среднего кадра.
+type FocusTile struct {
+	// Bounds - границы тайла в координатах кадра.
+	Bounds image.Rectangle
+	// Sharpness - дисперсия лапласиана средней интенсивности тайла, нормированная
+	// на квадрат средней интенсивности (не зависит от яркости освещения).
+	Sharpness float64
+	// Relative - отношение Sharpness к медиане по всем тайлам.
+	Relative float64
+	// Defocused отмечает тайлы, у которых Relative ниже порога.
+	Defocused bool
+}
+
+// FocusSummary - сводка контроля фокусировки среднего кадра.
+type FocusSummary struct {
+	// TileSize - сторона тайла в пикселях; Tiles - число оцененных тайлов.
+	TileSize int `json:"tile_size"`
+	Tiles    int `json:"tiles"`
+	// MedianSharpness - медианная резкость тайлов; Threshold - порог относительной резкости.
+	MedianSharpness float64 `json:"median_sharpness"`
+	Threshold       float64 `json:"threshold"`
+	// Defocused - границы тайлов, отмеченных как расфокусированные.
+	Defocused []image.Rectangle `json:"defocused"`
+}
+
+// Focus оценивает резкость среднего кадра mean (построчно, width x height) по тайлам
+// tileSize x tileSize и отмечает тайлы, резкость которых ниже доли threshold медианной.
+// Расфокусировка размывает спекл-картину и снижает контраст так же, как быстрый кровоток,
+// поэтому такие области карты нельзя интерпретировать как области высокой перфузии.
+// Крайние тайлы могут быть меньше tileSize; тайлы меньше 3 пикселей по любой оси
+// и тайлы нулевой яркости не оцениваются.
+func Focus(mean []float64, width, height, tileSize int, threshold float64) []FocusTile {
+	var tiles []FocusTile
+	for y0 := 0; y0 < height; y0 += tileSize {
+		for x0 := 0; x0 < width; x0 += tileSize {
+			bounds := image.Rect(x0, y0, min(x0+tileSize, width), min(y0+tileSize, height))
+			if bounds.Dx() < 3 || bounds.Dy() < 3 {
+				continue
+			}
+			if sharpness, ok := tileSharpness(mean, width, bounds); ok {
+				tiles = append(tiles, FocusTile{Bounds: bounds, Sharpness: sharpness})
+			}
+		}
+	}
+	sharpness := make([]float64, len(tiles))
+	for i, t := range tiles {
+		sharpness[i] = t.Sharpness
+	}
+	if center := median(sharpness); center > 0 {
+		for i := range tiles {
+			tiles[i].Relative = tiles[i].Sharpness / center
+			tiles[i].Defocused = tiles[i].Relative < threshold
+		}
+	}
+	return tiles
+}
+
+// tileSharpness возвращает нормированную дисперсию лапласиана (по четырем соседям)
+// внутренних пикселей тайла bounds; false - для тайла нулевой яркости.
+func tileSharpness(mean []float64, width int, bounds image.Rectangle) (float64, bool) {
+	var sum, sumLap, sumLap2 float64
+	var n int
+	for y := bounds.Min.Y + 1; y < bounds.Max.Y-1; y++ {
+		for x := bounds.Min.X + 1; x < bounds.Max.X-1; x++ {
+			i := y*width + x
+			lap := mean[i-width] + mean[i+width] + mean[i-1] + mean[i+1] - 4*mean[i]
+			sum += mean[i]
+			sumLap += lap
+			sumLap2 += lap * lap
+			n++
+		}
+	}
+	intensity := sum / float64(n)
+	if intensity <= 0 {
+		return 0, false
+	}
+	lapMean := sumLap / float64(n)
+	return (sumLap2/float64(n) - lapMean*lapMean) / (intensity * intensity), true
+}
+
+// SummarizeFocus вычисляет сводку контроля фокусировки по тайлам tiles.
+func SummarizeFocus(tiles []FocusTile, tileSize int, threshold float64) FocusSummary {
+	s := FocusSummary{TileSize: tileSize, Tiles: len(tiles), Threshold: threshold}
+	sharpness := make([]float64, len(tiles))
+	for i, t := range tiles {
+		sharpness[i] = t.Sharpness
+		if t.Defocused {
+			s.Defocused = append(s.Defocused, t.Bounds)
+		}
+	}
+	s.MedianSharpness = median(sharpness)
+	return s
+}
+
+// WriteFocusCSV сохраняет резкость тайлов в CSV-файл со столбцами
+// x_px, y_px, width_px, height_px, sharpness, relative, defocused.
+func WriteFocusCSV(path string, tiles []FocusTile) error {
+	return atomicfile.Write(path, func(file io.Writer) error {
+		w := csv.NewWriter(file)
+		header := []string{
+			units.Column("x", units.Pixel), units.Column("y", units.Pixel),
+			units.Column("width", units.Pixel), units.Column("height", units.Pixel),
+			"sharpness", "relative", "defocused",
+		}
+		if err := w.Write(header); err != nil {
+			return err
+		}
+		for _, t := range tiles {
+			record := []string{
+				strconv.Itoa(t.Bounds.Min.X),
+				strconv.Itoa(t.Bounds.Min.Y),
+				strconv.Itoa(t.Bounds.Dx()),
+				strconv.Itoa(t.Bounds.Dy()),
+				strconv.FormatFloat(t.Sharpness, 'g', 6, 64),
+				strconv.FormatFloat(t.Relative, 'f', 4, 64),
+				strconv.FormatBool(t.Defocused),
+			}
+			if err := w.Write(record); err != nil {
+				return err
+			}
+		}
+		w.Flush()
+		return w.Error()
+	})
+}
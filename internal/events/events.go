@@ -0,0 +1,97 @@
+// Package events - шина событий конвейера обработки: начало и завершение этапов,
+// обработка кадров, выполнение этапов расчета, предупреждения и завершение запуска.
+// Журнал, индикатор выполнения, телеметрия и уведомления получают сведения о запуске
+// подпиской на события, поэтому новый получатель подключается без изменения кода расчета.
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+// Kind - вид события.
+type Kind string
+
+// Виды событий.
+const (
+	// StageStarted и StageFinished - начало и завершение этапа Stage (см. telemetry.Recorder);
+	// для завершения задана длительность Duration. Этапы, выполняемые для каждого кадра
+	// (например, декодирование), сообщают о каждом выполнении и могут выполняться одновременно.
+	StageStarted  Kind = "stage_started"
+	StageFinished Kind = "stage_finished"
+	// FrameProcessed - кадр Frame (файл Path) загружен и подготовлен к расчету.
+	FrameProcessed Kind = "frame_processed"
+	// Progress - выполнение этапа расчета Stage: Done из Total единиц работы (см. tlasca.Progress).
+	Progress Kind = "progress"
+	// Warning - предупреждение контроля качества Message; предупреждения попадают в отчет о запуске.
+	Warning Kind = "warning"
+	// RunFinished - завершение запуска длительностью Duration с ошибкой Err (nil - успешно).
+	RunFinished Kind = "run_finished"
+)
+
+// Event - событие конвейера. Поля, кроме Kind и Time, заполняются в зависимости от вида события.
+type Event struct {
+	Kind Kind
+	// Time - время события (заполняется при публикации, если не задано).
+	Time time.Time
+	// Stage - этап (StageStarted, StageFinished, Progress).
+	Stage string
+	// Duration - длительность этапа (StageFinished) или запуска (RunFinished).
+	Duration time.Duration
+	// Frame и Path - индекс кадра в последовательности и его файл (FrameProcessed).
+	Frame int
+	Path  string
+	// Done и Total - выполненные и общее число единиц работы этапа (Progress).
+	Done  int
+	Total int
+	// Message - текст предупреждения (Warning).
+	Message string
+	// Err - ошибка завершения запуска (RunFinished).
+	Err error
+}
+
+// Handler получает события шины.
+type Handler func(Event)
+
+// Bus передает события подписчикам. Методы безопасны для конкурентного вызова.
+// Нулевой указатель (*Bus)(nil) допустим: события в этом случае никуда не передаются,
+// что позволяет использовать код, публикующий события, без шины.
+type Bus struct {
+	mu       sync.Mutex
+	handlers []Handler
+}
+
+// New является конструктором для Bus.
+func New() *Bus {
+	return &Bus{}
+}
+
+// Subscribe подписывает h на все события шины; подписчик сам отбирает нужные ему виды событий.
+func (b *Bus) Subscribe(h Handler) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.handlers = append(b.handlers, h)
+}
+
+// Publish передает событие e всем подписчикам в порядке подписки. Публикации упорядочены:
+// подписчик получает события по одному (он может не быть безопасным для конкурентного
+// использования), поэтому подписчики должны быть быстрыми (кроме получателей RunFinished -
+// последнего события запуска, например отправки уведомлений) и не публиковать события сами.
+func (b *Bus) Publish(e Event) {
+	if b == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, h := range b.handlers {
+		h(e)
+	}
+}
+
+// Warn публикует предупреждение message.
+func (b *Bus) Warn(message string) {
+	b.Publish(Event{Kind: Warning, Message: message})
+}
@@ -0,0 +1,97 @@
+// Package exposure выполняет нормировку интенсивности кадров по времени экспозиции.
+// При включенной автоэкспозиции камеры яркость кадров меняется не из-за объекта,
+// а из-за экспозиции, и без нормировки эти изменения искажают временные статистики.
+package exposure
+
+import (
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/mascotmascot1/go-tlasca/internal/framedata"
+	"github.com/mascotmascot1/go-tlasca/internal/tiff"
+)
+
+// Summary содержит сводку по экспозициям кадров последовательности.
+type Summary struct {
+	// Min, Max - минимальная и максимальная экспозиция кадров.
+	Min float64 `json:"min"`
+	Max float64 `json:"max"`
+	// Reference - экспозиция, к которой приводятся все кадры (медиана экспозиций).
+	Reference float64 `json:"reference"`
+}
+
+// Load читает экспозиции кадров из CSV-файла (номер кадра -> экспозиция в любых
+// единицах, одинаковых для всех кадров) и возвращает их для кадров frames.
+// Формат файла описан в framedata.Load.
+// Возвращает ошибку, если экспозиция какого-либо кадра отсутствует или не положительна.
+func Load(path string, frames []int) ([]float64, error) {
+	values, err := framedata.Load(path)
+	if err != nil {
+		return nil, err
+	}
+	exposures, err := values.Lookup(frames)
+	if err != nil {
+		return nil, err
+	}
+	for i, e := range exposures {
+		if e <= 0 {
+			return nil, fmt.Errorf("exposure of frame %d must be positive, got %g", frames[i], e)
+		}
+	}
+	return exposures, nil
+}
+
+// FromTIFF читает экспозиции кадров files (в секундах) из тегов TIFF: ExposureTime (EXIF)
+// или "Exposure-ms" метаданных Micro-Manager (см. tiff.ExposureTime). Возвращает ошибку,
+// если какой-либо кадр не является TIFF или не содержит положительной экспозиции.
+func FromTIFF(files []string) ([]float64, error) {
+	exposures := make([]float64, len(files))
+	for i, file := range files {
+		e, err := tiffExposure(file)
+		if err != nil {
+			return nil, fmt.Errorf("frame '%s': %w", file, err)
+		}
+		exposures[i] = e
+	}
+	return exposures, nil
+}
+
+// tiffExposure возвращает экспозицию одного файла TIFF.
+func tiffExposure(path string) (float64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	e, ok, err := tiff.ExposureTime(f)
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		return 0, fmt.Errorf("no ExposureTime or Micro-Manager Exposure-ms tag")
+	}
+	if e <= 0 {
+		return 0, fmt.Errorf("exposure must be positive, got %g", e)
+	}
+	return e, nil
+}
+
+// Gains вычисляет попадровые коэффициенты нормировки gain_i = reference / exposure_i,
+// приводящие интенсивность каждого кадра к опорной экспозиции (медиане экспозиций).
+// Медиана выбрана опорной, чтобы нормированные значения оставались в диапазоне исходных.
+func Gains(exposures []float64) ([]float64, Summary) {
+	sorted := append([]float64(nil), exposures...)
+	sort.Float64s(sorted)
+	s := Summary{
+		Min:       sorted[0],
+		Max:       sorted[len(sorted)-1],
+		Reference: sorted[len(sorted)/2],
+	}
+
+	gains := make([]float64, len(exposures))
+	for i, e := range exposures {
+		gains[i] = s.Reference / e
+	}
+	return gains, s
+}
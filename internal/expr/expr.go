@@ -0,0 +1,501 @@
+// Package expr разбирает и вычисляет арифметические выражения над именованными картами
+// (например, "(k_b - k_a) / k_a" или "1/(k*k)"). Выражение вычисляется поэлементно: значение
+// каждой карты - число в том же положении, что и результат.
+//
+// Грамматика (в порядке возрастания приоритета):
+//
+//	сравнение:     a < b, a <= b, a > b, a >= b, a == b, a != b (1 - истина, 0 - ложь)
+//	сумма:         a + b, a - b
+//	произведение:  a * b, a / b
+//	унарный минус: -a
+//	степень:       a ^ b (правоассоциативна: 2^3^2 = 2^9, -2^2 = -4)
+//	операнд:       число (1, 0.5, 1e-3), имя карты, вызов функции f(a, ...), (выражение)
+//
+// Функции: abs, sqrt, exp, log, log10 (один аргумент), min, max, pow (два аргумента),
+// clamp(x, lo, hi) и if(условие, a, b) (a, если условие не равно 0, иначе b).
+// Деление на ноль и функции вне области определения дают ±Inf и NaN, как в пакете math.
+package expr
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/mascotmascot1/go-tlasca/internal/parallel"
+)
+
+// Expression - разобранное выражение.
+type Expression struct {
+	source string
+	root   node
+	vars   []string
+}
+
+// node - узел дерева выражения.
+type node interface {
+	// compile возвращает функцию значения узла в положении i по картам maps
+	// (индексы карт - в порядке Expression.vars).
+	compile(maps [][]float64) func(i int) float64
+}
+
+// functions - функции выражений с числом аргументов.
+var functions = map[string]struct {
+	args int
+	fn   func(a []float64) float64
+}{
+	"abs":   {1, func(a []float64) float64 { return math.Abs(a[0]) }},
+	"sqrt":  {1, func(a []float64) float64 { return math.Sqrt(a[0]) }},
+	"exp":   {1, func(a []float64) float64 { return math.Exp(a[0]) }},
+	"log":   {1, func(a []float64) float64 { return math.Log(a[0]) }},
+	"log10": {1, func(a []float64) float64 { return math.Log10(a[0]) }},
+	"min":   {2, func(a []float64) float64 { return math.Min(a[0], a[1]) }},
+	"max":   {2, func(a []float64) float64 { return math.Max(a[0], a[1]) }},
+	"pow":   {2, func(a []float64) float64 { return math.Pow(a[0], a[1]) }},
+	"clamp": {3, func(a []float64) float64 { return math.Min(math.Max(a[0], a[1]), a[2]) }},
+	"if": {3, func(a []float64) float64 {
+		if a[0] != 0 {
+			return a[1]
+		}
+		return a[2]
+	}},
+}
+
+// IsFunction сообщает, является ли name именем встроенной функции (и поэтому не может
+// быть именем карты).
+func IsFunction(name string) bool {
+	_, ok := functions[name]
+	return ok
+}
+
+// IsIdentifier сообщает, может ли name быть именем карты: буквы латинского алфавита,
+// цифры и '_', не начиная с цифры.
+func IsIdentifier(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, r := range name {
+		if !isIdentRune(r) || (i == 0 && unicode.IsDigit(r)) {
+			return false
+		}
+	}
+	return true
+}
+
+// isIdentRune сообщает, может ли r входить в имя.
+func isIdentRune(r rune) bool {
+	return r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9')
+}
+
+// Parse разбирает выражение source.
+func Parse(source string) (*Expression, error) {
+	tokens, err := tokenize(source)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	root, err := p.comparison()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokenEnd {
+		return nil, fmt.Errorf("unexpected '%s' at position %d", t.text, t.pos+1)
+	}
+	return &Expression{source: source, root: root, vars: p.vars}, nil
+}
+
+// String возвращает исходный текст выражения.
+func (e *Expression) String() string {
+	return e.source
+}
+
+// Variables возвращает имена карт, на которые ссылается выражение, в порядке первого упоминания.
+func (e *Expression) Variables() []string {
+	return slices.Clone(e.vars)
+}
+
+// Eval вычисляет выражение для n положений по картам maps (каждая из n значений).
+// Возвращает ошибку, если какой-либо карты выражения нет в maps или ее размер не равен n.
+// Положения вычисляются параллельно.
+func (e *Expression) Eval(maps map[string][]float64, n int) ([]float64, error) {
+	planes := make([][]float64, len(e.vars))
+	for k, name := range e.vars {
+		plane, ok := maps[name]
+		if !ok {
+			available := make([]string, 0, len(maps))
+			for name := range maps {
+				available = append(available, name)
+			}
+			slices.Sort(available)
+			return nil, fmt.Errorf("unknown map '%s' (available: %s)", name, strings.Join(available, ", "))
+		}
+		if len(plane) != n {
+			return nil, fmt.Errorf("map '%s' has %d values, expected %d", name, len(plane), n)
+		}
+		planes[k] = plane
+	}
+	value := e.root.compile(planes)
+	out := make([]float64, n)
+	parallel.Rows(n, func(start, end int) {
+		for i := start; i < end; i++ {
+			out[i] = value(i)
+		}
+	})
+	return out, nil
+}
+
+// --- Узлы ---
+
+type numberNode float64
+
+func (n numberNode) compile([][]float64) func(int) float64 {
+	v := float64(n)
+	return func(int) float64 { return v }
+}
+
+type variableNode struct {
+	name  string
+	index int
+}
+
+func (n variableNode) compile(maps [][]float64) func(int) float64 {
+	plane := maps[n.index]
+	return func(i int) float64 { return plane[i] }
+}
+
+type unaryNode struct {
+	operand node
+}
+
+func (n unaryNode) compile(maps [][]float64) func(int) float64 {
+	operand := n.operand.compile(maps)
+	return func(i int) float64 { return -operand(i) }
+}
+
+type binaryNode struct {
+	op          string
+	left, right node
+}
+
+// boolValue возвращает 1 для истины и 0 для лжи.
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func (n binaryNode) compile(maps [][]float64) func(int) float64 {
+	left, right := n.left.compile(maps), n.right.compile(maps)
+	switch n.op {
+	case "+":
+		return func(i int) float64 { return left(i) + right(i) }
+	case "-":
+		return func(i int) float64 { return left(i) - right(i) }
+	case "*":
+		return func(i int) float64 { return left(i) * right(i) }
+	case "/":
+		return func(i int) float64 { return left(i) / right(i) }
+	case "^":
+		return func(i int) float64 { return math.Pow(left(i), right(i)) }
+	case "<":
+		return func(i int) float64 { return boolValue(left(i) < right(i)) }
+	case "<=":
+		return func(i int) float64 { return boolValue(left(i) <= right(i)) }
+	case ">":
+		return func(i int) float64 { return boolValue(left(i) > right(i)) }
+	case ">=":
+		return func(i int) float64 { return boolValue(left(i) >= right(i)) }
+	case "==":
+		return func(i int) float64 { return boolValue(left(i) == right(i)) }
+	default: // "!="
+		return func(i int) float64 { return boolValue(left(i) != right(i)) }
+	}
+}
+
+type callNode struct {
+	name string
+	args []node
+}
+
+func (n callNode) compile(maps [][]float64) func(int) float64 {
+	fn := functions[n.name].fn
+	args := make([]func(int) float64, len(n.args))
+	for k, arg := range n.args {
+		args[k] = arg.compile(maps)
+	}
+	return func(i int) float64 {
+		// Буфер аргументов на стеке: вызов выполняется для каждого положения.
+		var values [3]float64
+		for k, arg := range args {
+			values[k] = arg(i)
+		}
+		return fn(values[:len(args)])
+	}
+}
+
+// --- Лексический разбор ---
+
+type tokenKind int
+
+const (
+	tokenEnd tokenKind = iota
+	tokenNumber
+	tokenIdent
+	tokenOperator
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+// operators - операторы и скобки (двухсимвольные проверяются первыми).
+var operators = []string{"<=", ">=", "==", "!=", "+", "-", "*", "/", "^", "<", ">", "(", ")", ","}
+
+// tokenize разбивает source на лексемы.
+func tokenize(source string) ([]token, error) {
+	var tokens []token
+	for pos := 0; pos < len(source); {
+		r := rune(source[pos])
+		switch {
+		case r == ' ' || r == '\t' || r == '\n' || r == '\r':
+			pos++
+		case unicode.IsDigit(r) || r == '.':
+			end := pos
+			for end < len(source) && (unicode.IsDigit(rune(source[end])) || source[end] == '.') {
+				end++
+			}
+			// Показатель степени: 1e-3, 2.5E+4.
+			if end < len(source) && (source[end] == 'e' || source[end] == 'E') {
+				next := end + 1
+				if next < len(source) && (source[next] == '+' || source[next] == '-') {
+					next++
+				}
+				if next < len(source) && unicode.IsDigit(rune(source[next])) {
+					end = next
+					for end < len(source) && unicode.IsDigit(rune(source[end])) {
+						end++
+					}
+				}
+			}
+			tokens = append(tokens, token{tokenNumber, source[pos:end], pos})
+			pos = end
+		case isIdentRune(r):
+			end := pos
+			for end < len(source) && isIdentRune(rune(source[end])) {
+				end++
+			}
+			tokens = append(tokens, token{tokenIdent, source[pos:end], pos})
+			pos = end
+		default:
+			matched := false
+			for _, op := range operators {
+				if strings.HasPrefix(source[pos:], op) {
+					tokens = append(tokens, token{tokenOperator, op, pos})
+					pos += len(op)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, fmt.Errorf("unexpected character '%c' at position %d", source[pos], pos+1)
+			}
+		}
+	}
+	return append(tokens, token{tokenEnd, "end of expression", len(source)}), nil
+}
+
+// --- Синтаксический разбор (рекурсивный спуск) ---
+
+type parser struct {
+	tokens []token
+	pos    int
+	// vars - имена карт в порядке первого упоминания (индексы variableNode).
+	vars []string
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEnd {
+		p.pos++
+	}
+	return t
+}
+
+// accept пропускает оператор op, если он следующий, и сообщает, был ли он.
+func (p *parser) accept(op string) bool {
+	if t := p.peek(); t.kind == tokenOperator && t.text == op {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+// expect пропускает оператор op или возвращает ошибку.
+func (p *parser) expect(op string) error {
+	if p.accept(op) {
+		return nil
+	}
+	t := p.peek()
+	return fmt.Errorf("expected '%s' at position %d, got '%s'", op, t.pos+1, t.text)
+}
+
+func (p *parser) comparison() (node, error) {
+	left, err := p.sum()
+	if err != nil {
+		return nil, err
+	}
+	for _, op := range []string{"<=", ">=", "==", "!=", "<", ">"} {
+		if p.accept(op) {
+			right, err := p.sum()
+			if err != nil {
+				return nil, err
+			}
+			return binaryNode{op, left, right}, nil
+		}
+	}
+	return left, nil
+}
+
+func (p *parser) sum() (node, error) {
+	left, err := p.product()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op := ""
+		switch {
+		case p.accept("+"):
+			op = "+"
+		case p.accept("-"):
+			op = "-"
+		default:
+			return left, nil
+		}
+		right, err := p.product()
+		if err != nil {
+			return nil, err
+		}
+		left = binaryNode{op, left, right}
+	}
+}
+
+func (p *parser) product() (node, error) {
+	left, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op := ""
+		switch {
+		case p.accept("*"):
+			op = "*"
+		case p.accept("/"):
+			op = "/"
+		default:
+			return left, nil
+		}
+		right, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		left = binaryNode{op, left, right}
+	}
+}
+
+func (p *parser) unary() (node, error) {
+	if p.accept("-") {
+		operand, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return unaryNode{operand}, nil
+	}
+	return p.power()
+}
+
+func (p *parser) power() (node, error) {
+	base, err := p.operand()
+	if err != nil {
+		return nil, err
+	}
+	if p.accept("^") {
+		exponent, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return binaryNode{"^", base, exponent}, nil
+	}
+	return base, nil
+}
+
+func (p *parser) operand() (node, error) {
+	t := p.next()
+	switch {
+	case t.kind == tokenNumber:
+		v, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number '%s' at position %d", t.text, t.pos+1)
+		}
+		return numberNode(v), nil
+	case t.kind == tokenIdent && p.accept("("):
+		return p.call(t)
+	case t.kind == tokenIdent:
+		if IsFunction(t.text) {
+			return nil, fmt.Errorf("function '%s' at position %d must be called with arguments", t.text, t.pos+1)
+		}
+		index := slices.Index(p.vars, t.text)
+		if index < 0 {
+			index = len(p.vars)
+			p.vars = append(p.vars, t.text)
+		}
+		return variableNode{t.text, index}, nil
+	case t.kind == tokenOperator && t.text == "(":
+		inner, err := p.comparison()
+		if err != nil {
+			return nil, err
+		}
+		if err = p.expect(")"); err != nil {
+			return nil, err
+		}
+		return inner, nil
+	default:
+		return nil, fmt.Errorf("unexpected '%s' at position %d", t.text, t.pos+1)
+	}
+}
+
+// call разбирает аргументы вызова функции name (открывающая скобка уже пропущена).
+func (p *parser) call(name token) (node, error) {
+	fn, ok := functions[name.text]
+	if !ok {
+		return nil, fmt.Errorf("unknown function '%s' at position %d", name.text, name.pos+1)
+	}
+	var args []node
+	if !p.accept(")") {
+		for {
+			arg, err := p.comparison()
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+			if p.accept(")") {
+				break
+			}
+			if err = p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if len(args) != fn.args {
+		return nil, fmt.Errorf("function '%s' takes %d arguments, got %d", name.text, fn.args, len(args))
+	}
+	return callNode{name.text, args}, nil
+}
@@ -0,0 +1,257 @@
+// Package figure собирает сводную многопанельную иллюстрацию эксперимента
+// ("одно изображение на эксперимент"): среднее исходное изображение, карта контраста,
+// карта индекса кровотока, гистограмма контраста и подпись с параметрами запуска.
+package figure
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+
+	"github.com/mascotmascot1/go-tlasca/internal/plot"
+	"github.com/mascotmascot1/go-tlasca/internal/render"
+	"github.com/mascotmascot1/go-tlasca/pkg/mask"
+	"github.com/mascotmascot1/go-tlasca/pkg/tlasca"
+	"github.com/mascotmascot1/go-tlasca/pkg/units"
+)
+
+const (
+	// panelSize - размер стороны квадратной области панели в пикселях.
+	panelSize = 320
+	// gap - отступ между панелями и от краев иллюстрации.
+	gap = 16
+	// titleScale - масштаб шрифта заголовков панелей; captionScale - масштаб шрифта подписи.
+	titleScale   = 2
+	captionScale = 2
+	// barHeight - высота шкалы под панелями карт; barGap - отступ шкалы от панели и подписей.
+	barHeight, barGap = 12, 4
+	// histogramBins - число столбцов гистограммы контраста.
+	histogramBins = 64
+	// columns, rows - сетка панелей.
+	columns, rows = 3, 2
+)
+
+var (
+	background = color.RGBA{R: 255, G: 255, B: 255, A: 255}
+	textColor  = color.RGBA{A: 255}
+	barColor   = color.RGBA{R: 60, G: 90, B: 160, A: 255}
+)
+
+// Build строит сводную иллюстрацию по результату расчета res.
+// scale - шкала отображения карты контраста (ее границы задают и диапазон гистограммы),
+// caption - строки подписи с параметрами запуска. Заголовки панелей карт содержат единицы
+// величин (см. Result.Units), под панелями выводятся шкалы с границами диапазона отображения.
+func Build(res *tlasca.Result, scale render.Scale, caption []string) *image.RGBA {
+	titleH := plot.CharHeight*titleScale + gap/2
+	barH := barGap + barHeight + barGap + plot.CharHeight*captionScale
+	cellW, cellH := panelSize+gap, titleH+panelSize+barH+gap
+	fig := image.NewRGBA(image.Rect(0, 0, gap+columns*cellW, gap+rows*cellH))
+	draw.Draw(fig, fig.Bounds(), &image.Uniform{C: background}, image.Point{}, draw.Src)
+
+	// origin возвращает левый верхний угол области панели (под заголовком) в ячейке (col, row).
+	origin := func(col, row int) image.Point {
+		return image.Pt(gap+col*cellW, gap+row*cellH+titleH)
+	}
+	title := func(col, row int, text string) {
+		p := origin(col, row)
+		plot.DrawText(fig, p.X, p.Y-titleH, text, textColor, titleScale)
+	}
+
+	// --- Среднее исходное изображение ---
+	meanRange := render.Range{
+		Min: render.Percentile(res.Mean, nil, 0.5),
+		Max: render.Percentile(res.Mean, nil, 99.5),
+	}
+	if meanRange.Max <= meanRange.Min {
+		meanRange.Max = meanRange.Min + 1
+	}
+	title(0, 0, units.Label("MEAN INTENSITY", res.Units.Intensity))
+	drawScaled(fig, origin(0, 0), render.GrayPlane(res.Mean, res.FrameWidth, res.FrameHeight, nil, meanRange))
+	colorBar(fig, origin(0, 0), meanRange)
+
+	// --- Карта контраста ---
+	title(1, 0, units.Label("SPECKLE CONTRAST K", res.Units.Contrast))
+	drawScaled(fig, origin(1, 0), render.Gray(res, scale))
+	colorBar(fig, origin(1, 0), scale)
+
+	// --- Индекс кровотока 1/K^2 ---
+	flow := FlowIndex(res)
+	flowRange := render.Range{Min: 0, Max: render.Percentile(flow, res.Excluded, 99)}
+	if flowRange.Max <= 0 {
+		flowRange.Max = 1
+	}
+	title(2, 0, units.Label("FLOW INDEX 1/K^2", units.Arbitrary))
+	drawScaled(fig, origin(2, 0), render.GrayPlane(flow, res.Width, res.Height, res.Excluded, flowRange))
+	colorBar(fig, origin(2, 0), flowRange)
+
+	// --- Гистограмма контраста ---
+	var values []float64
+	for i, v := range res.Contrast {
+		if res.Excluded == nil || !res.Excluded.Set[i] {
+			values = append(values, v)
+		}
+	}
+	title(0, 1, "HISTOGRAM OF K")
+	bounds := scale.Bounds()
+	hist := plot.Histogram(panelSize, panelSize, values, bounds.Min, bounds.Max, histogramBins, barColor)
+	p := origin(0, 1)
+	draw.Draw(fig, image.Rectangle{Min: p, Max: p.Add(hist.Rect.Size())}, hist, image.Point{}, draw.Src)
+
+	// --- Подпись с параметрами ---
+	title(1, 1, "PARAMETERS")
+	p = origin(1, 1)
+	for i, line := range caption {
+		plot.DrawText(fig, p.X, p.Y+i*(plot.CharHeight*captionScale+4), line, textColor, captionScale)
+	}
+	return fig
+}
+
+// FlowIndex вычисляет карту индекса кровотока 1/K^2 размера карты контраста.
+// Для исключенных положений окна и положений, где индекс не определен (контраст
+// не положителен или NaN), значение равно NaN: такие положения не отображаются
+// и не учитываются в статистиках.
+func FlowIndex(res *tlasca.Result) []float64 {
+	flow := make([]float64, len(res.Contrast))
+	for i, k := range res.Contrast {
+		if k > 0 && (res.Excluded == nil || !res.Excluded.Set[i]) {
+			flow[i] = 1 / (k * k)
+		} else {
+			flow[i] = math.NaN()
+		}
+	}
+	return flow
+}
+
+// colorBar выводит под панелью с верхним левым углом at шкалу отображения scale:
+// градиент от нижней до верхней границы и значения границ.
+func colorBar(fig *image.RGBA, at image.Point, scale render.Scale) {
+	bounds := scale.Bounds()
+	gradient := make([]float64, panelSize*barHeight)
+	for x := range panelSize {
+		v := bounds.Min + (float64(x)+0.5)/panelSize*(bounds.Max-bounds.Min)
+		for y := range barHeight {
+			gradient[y*panelSize+x] = v
+		}
+	}
+	top := at.Y + panelSize + barGap
+	bar := render.GrayPlane(gradient, panelSize, barHeight, nil, scale)
+	draw.Draw(fig, image.Rect(at.X, top, at.X+panelSize, top+barHeight), bar, image.Point{}, draw.Src)
+
+	y := top + barHeight + barGap
+	low, high := fmt.Sprintf("%.3g", bounds.Min), fmt.Sprintf("%.3g", bounds.Max)
+	plot.DrawText(fig, at.X, y, low, textColor, captionScale)
+	plot.DrawText(fig, at.X+panelSize-plot.TextWidth(high, captionScale), y, high, textColor, captionScale)
+}
+
+// drawScaled вписывает изображение произвольной цветовой модели в квадрат panelSize x panelSize
+// с верхним левым углом в точке at, сохраняя пропорции (метод ближайшего соседа).
+func drawScaled(fig *image.RGBA, at image.Point, img image.Image) {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	scale := min(float64(panelSize)/float64(w), float64(panelSize)/float64(h))
+	outW, outH := max(int(float64(w)*scale), 1), max(int(float64(h)*scale), 1)
+	for y := 0; y < outH; y++ {
+		srcY := min(int(float64(y)/scale), h-1)
+		for x := 0; x < outW; x++ {
+			srcX := min(int(float64(x)/scale), w-1)
+			fig.Set(at.X+x, at.Y+y, img.At(b.Min.X+srcX, b.Min.Y+srcY))
+		}
+	}
+}
+
+// Comparison строит стандартизированную иллюстрацию сравнения двух карт контраста
+// одинакового размера (например, до и после окклюзии): обе карты в общей шкале,
+// которую norm строит по значениям обеих карт, и панель разности B - A
+// в расходящейся палитре (синий - уменьшение, красный - увеличение контраста).
+// labelA, labelB - заголовки панелей; caption - дополнительные строки подписи.
+func Comparison(a, b *tlasca.Result, norm render.Normalizer, labelA, labelB string, caption []string) *image.RGBA {
+	titleH := plot.CharHeight*titleScale + gap/2
+	lineH := plot.CharHeight*captionScale + 4
+	cellW := panelSize + gap
+	captionTop := gap + titleH + panelSize + gap
+	fig := image.NewRGBA(image.Rect(0, 0, gap+3*cellW, captionTop+(len(caption)+2)*lineH+gap))
+	draw.Draw(fig, fig.Bounds(), &image.Uniform{C: background}, image.Point{}, draw.Src)
+
+	// Общая шкала для обеих карт: нормировка по объединению их значений.
+	values := append(append([]float64(nil), a.Contrast...), b.Contrast...)
+	var skip *mask.Mask
+	if a.Excluded != nil || b.Excluded != nil {
+		skip = &mask.Mask{Width: a.Width, Height: a.Height + b.Height, Set: make([]bool, len(values))}
+		if a.Excluded != nil {
+			copy(skip.Set, a.Excluded.Set)
+		}
+		if b.Excluded != nil {
+			copy(skip.Set[len(a.Contrast):], b.Excluded.Set)
+		}
+	}
+	shared := norm.Fit(values, skip)
+	sharedBounds := shared.Bounds()
+
+	// Разность B - A; исключенные хотя бы в одной карте положения не учитываются.
+	diff := make([]float64, len(a.Contrast))
+	absDiff := make([]float64, len(a.Contrast))
+	var sumA, sumB float64
+	var count int
+	for i := range diff {
+		if (a.Excluded != nil && a.Excluded.Set[i]) || (b.Excluded != nil && b.Excluded.Set[i]) {
+			continue
+		}
+		diff[i] = b.Contrast[i] - a.Contrast[i]
+		absDiff[i] = math.Abs(diff[i])
+		sumA += a.Contrast[i]
+		sumB += b.Contrast[i]
+		count++
+	}
+	limit := render.Percentile(absDiff, nil, 99)
+	if limit <= 0 {
+		limit = 1
+	}
+
+	panels := []struct {
+		title string
+		img   image.Image
+	}{
+		{labelA, render.Gray(a, shared)},
+		{labelB, render.Gray(b, shared)},
+		{"DIFFERENCE B-A", diverging(diff, a.Width, a.Height, limit)},
+	}
+	for i, p := range panels {
+		x := gap + i*cellW
+		plot.DrawText(fig, x, gap, p.title, textColor, titleScale)
+		drawScaled(fig, image.Pt(x, gap+titleH), p.img)
+	}
+
+	lines := []string{
+		fmt.Sprintf("shared K scale (%s): [%.3f, %.3f]", norm, sharedBounds.Min, sharedBounds.Max),
+		fmt.Sprintf("difference scale: +/-%.3f (blue: decrease, red: increase)", limit),
+	}
+	if count > 0 && sumA > 0 {
+		meanA, meanB := sumA/float64(count), sumB/float64(count)
+		lines = append(lines, fmt.Sprintf("mean K: %.4f -> %.4f (%+.1f%%)", meanA, meanB, 100*(meanB-meanA)/meanA))
+	}
+	lines = append(lines, caption...)
+	for i, line := range lines {
+		plot.DrawText(fig, gap, captionTop+i*lineH, line, textColor, captionScale)
+	}
+	return fig
+}
+
+// diverging отображает плоскость значений в расходящуюся палитру: -limit - синий,
+// 0 - белый, +limit - красный; значения за пределами ограничиваются.
+func diverging(values []float64, width, height int, limit float64) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			t := math.Max(-1, math.Min(1, values[y*width+x]/limit))
+			fade := uint8(255 * (1 - math.Abs(t)))
+			c := color.RGBA{R: 255, G: fade, B: fade, A: 255}
+			if t < 0 {
+				c = color.RGBA{R: fade, G: fade, B: 255, A: 255}
+			}
+			img.SetRGBA(x, y, c)
+		}
+	}
+	return img
+}
@@ -0,0 +1,201 @@
+// Package framecorr вычисляет корреляцию кадров последовательности между собой:
+// матрицу попарных корреляций и ряд корреляций соседних кадров. Периодическое движение
+// проявляется на матрице полосами, параллельными диагонали, а пропущенные кадры,
+// скачки освещенности и сдвиги - провалами корреляции соседних кадров.
+//
+// Для ограничения объема вычислений кадры сравниваются по сигнатурам - уменьшенным
+// усреднением блоков копиям размером не более size x size.
+package framecorr
+
+import (
+	"encoding/csv"
+	"fmt"
+	"image"
+	"io"
+	"math"
+	"path/filepath"
+	"strconv"
+
+	"github.com/mascotmascot1/go-tlasca/internal/atomicfile"
+	"github.com/mascotmascot1/go-tlasca/internal/parallel"
+	"github.com/mascotmascot1/go-tlasca/internal/render"
+	"github.com/mascotmascot1/go-tlasca/pkg/frame"
+	"github.com/mascotmascot1/go-tlasca/pkg/tlasca"
+)
+
+// Summary - сводка корреляции соседних кадров.
+type Summary struct {
+	// Frames - число сравниваемых кадров.
+	Frames int `json:"frames"`
+	// MeanAdjacent - средняя корреляция соседних кадров.
+	MeanAdjacent float64 `json:"mean_adjacent"`
+	// MinAdjacent - наименьшая корреляция соседних кадров и индекс первого кадра пары.
+	MinAdjacent      float64 `json:"min_adjacent"`
+	MinAdjacentFrame int     `json:"min_adjacent_frame"`
+	// Matrix сообщает, рассчитывалась ли матрица попарных корреляций.
+	Matrix bool `json:"matrix"`
+}
+
+// Signature возвращает сигнатуру кадра f: кадр, уменьшенный усреднением блоков до сетки
+// не более size x size, умноженный на gain, с вычтенным средним и нормированный
+// к единичной длине. Скалярное произведение сигнатур равно коэффициенту корреляции Пирсона
+// уменьшенных кадров. Для кадра постоянной яркости возвращаются нули.
+func Signature(f frame.Frame, gain float64, size int) []float64 {
+	bounds := f.Bounds()
+	block := max((max(bounds.Dx(), bounds.Dy())+size-1)/size, 1)
+	cols, rows := (bounds.Dx()+block-1)/block, (bounds.Dy()+block-1)/block
+	sums := make([]float64, cols*rows)
+	counts := make([]int, cols*rows)
+	buf := frame.RowBuffer(f)
+	for y := 0; y < bounds.Dy(); y++ {
+		for x, v := range f.Row(bounds.Min.Y+y, buf) {
+			i := (y/block)*cols + x/block
+			sums[i] += float64(v) * gain
+			counts[i]++
+		}
+	}
+	var mean float64
+	for i := range sums {
+		sums[i] /= float64(counts[i])
+		mean += sums[i]
+	}
+	mean /= float64(len(sums))
+	var norm float64
+	for i := range sums {
+		sums[i] -= mean
+		norm += sums[i] * sums[i]
+	}
+	if norm > 0 {
+		norm = math.Sqrt(norm)
+		for i := range sums {
+			sums[i] /= norm
+		}
+	}
+	return sums
+}
+
+// Signatures читает последовательность из total кадров через load порциями по chunkSize
+// (chunkSize <= 0 - одной порцией) и возвращает сигнатуры кадров в порядке последовательности.
+// gains задает попадровые коэффициенты (nil - единичные). Пропущенные (nil) кадры не учитываются.
+func Signatures(load tlasca.ChunkLoader, total, chunkSize int, gains []float64, size int) ([][]float64, error) {
+	if size < 2 {
+		return nil, fmt.Errorf("signature size must be at least 2, got %d", size)
+	}
+	if chunkSize <= 0 {
+		chunkSize = total
+	}
+	var signatures [][]float64
+	for start := 0; start < total; start += chunkSize {
+		end := min(start+chunkSize, total)
+		images, err := load(start, end)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load chunk [%d, %d): %w", start, end, err)
+		}
+		chunk := make([][]float64, len(images))
+		err = parallel.Each(len(images), func(i int) error {
+			if images[i] == nil {
+				return nil
+			}
+			gain := 1.0
+			if gains != nil {
+				gain = gains[start+i]
+			}
+			chunk[i] = Signature(images[i], gain, size)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range chunk {
+			if s != nil {
+				signatures = append(signatures, s)
+			}
+		}
+	}
+	if len(signatures) < 2 {
+		return nil, fmt.Errorf("at least 2 readable frames are required")
+	}
+	return signatures, nil
+}
+
+// Adjacent возвращает корреляции соседних кадров: элемент t - корреляция кадров t и t+1.
+func Adjacent(signatures [][]float64) []float64 {
+	r := make([]float64, len(signatures)-1)
+	for t := range r {
+		r[t] = dot(signatures[t], signatures[t+1])
+	}
+	return r
+}
+
+// Matrix возвращает симметричную матрицу попарных корреляций кадров
+// построчно (i*n + j, n = len(signatures)). Строки вычисляются параллельно.
+func Matrix(signatures [][]float64) []float64 {
+	n := len(signatures)
+	m := make([]float64, n*n)
+	parallel.Rows(n, func(startY, endY int) {
+		for i := startY; i < endY; i++ {
+			for j := range n {
+				m[i*n+j] = dot(signatures[i], signatures[j])
+			}
+		}
+	})
+	return m
+}
+
+// Summarize вычисляет сводку ряда корреляций соседних кадров adjacent.
+func Summarize(adjacent []float64, matrix bool) Summary {
+	s := Summary{Frames: len(adjacent) + 1, MinAdjacent: math.Inf(1), Matrix: matrix}
+	for t, r := range adjacent {
+		s.MeanAdjacent += r
+		if r < s.MinAdjacent {
+			s.MinAdjacent, s.MinAdjacentFrame = r, t
+		}
+	}
+	s.MeanAdjacent /= float64(len(adjacent))
+	return s
+}
+
+// Heatmap строит изображение матрицы корреляций n x n в градациях серого:
+// наименьшая корреляция матрицы отображается черным, 1 - белым.
+func Heatmap(matrix []float64, n int) *image.Gray {
+	lo := 1.0
+	for _, r := range matrix {
+		lo = math.Min(lo, r)
+	}
+	if lo >= 1 {
+		lo = 0
+	}
+	return render.GrayPlane(matrix, n, n, nil, render.Range{Min: lo, Max: 1})
+}
+
+// WriteCSV сохраняет корреляции соседних кадров в CSV-файл со столбцами
+// frame, file, next_file, correlation. files задает пути к кадрам.
+func WriteCSV(path string, adjacent []float64, files []string) error {
+	return atomicfile.Write(path, func(file io.Writer) error {
+		w := csv.NewWriter(file)
+		if err := w.Write([]string{"frame", "file", "next_file", "correlation"}); err != nil {
+			return err
+		}
+		for t, r := range adjacent {
+			var name, next string
+			if t+1 < len(files) {
+				name, next = filepath.Base(files[t]), filepath.Base(files[t+1])
+			}
+			record := []string{strconv.Itoa(t), name, next, strconv.FormatFloat(r, 'f', 6, 64)}
+			if err := w.Write(record); err != nil {
+				return err
+			}
+		}
+		w.Flush()
+		return w.Error()
+	})
+}
+
+// dot возвращает скалярное произведение a и b.
+func dot(a, b []float64) float64 {
+	var sum float64
+	for i, v := range a {
+		sum += v * b[i]
+	}
+	return sum
+}
@@ -0,0 +1,84 @@
+// Package framedata читает файлы с попадровыми метаданными в формате CSV
+// (номер кадра -> числовое значение), например временные метки или экспозиции кадров.
+package framedata
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/mascotmascot1/go-tlasca/internal/pathutil"
+)
+
+// Values сопоставляет номер кадра (число из имени файла, например 10 для "10.png")
+// с числовым значением метаданных.
+type Values map[int]float64
+
+// Load читает CSV-файл, в каждой строке которого указаны номер кадра и значение
+// (например, "10,0.125"). Первая строка может быть заголовком - она пропускается,
+// если ее первое поле не является числом. Пустые строки и строки, начинающиеся с '#', игнорируются.
+//
+// Возвращает ошибку при неверном формате строки или повторяющемся номере кадра.
+func Load(path string) (Values, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// Excel и Блокнот Windows добавляют в начало UTF-8 файлов метку порядка байтов,
+	// из-за которой номер кадра в первой строке не распознавался бы как число.
+	r := csv.NewReader(bytes.NewReader(pathutil.TrimBOM(data)))
+	r.Comment = '#'
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+
+	values := make(Values)
+	for line := 1; ; line++ {
+		record, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(record) < 2 {
+			return nil, fmt.Errorf("line %d: expected 'frame,value', got %q", line, strings.Join(record, ","))
+		}
+
+		frame, err := strconv.Atoi(strings.TrimSpace(record[0]))
+		if err != nil {
+			if line == 1 {
+				continue // заголовок
+			}
+			return nil, fmt.Errorf("line %d: invalid frame number: %w", line, err)
+		}
+		v, err := strconv.ParseFloat(strings.TrimSpace(record[1]), 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid value: %w", line, err)
+		}
+		if _, dup := values[frame]; dup {
+			return nil, fmt.Errorf("line %d: duplicate frame %d", line, frame)
+		}
+		values[frame] = v
+	}
+	return values, nil
+}
+
+// Lookup возвращает значения для кадров frames (в том же порядке).
+// Возвращает ошибку, если для какого-либо кадра нет значения.
+func (v Values) Lookup(frames []int) ([]float64, error) {
+	result := make([]float64, len(frames))
+	for i, frame := range frames {
+		value, ok := v[frame]
+		if !ok {
+			return nil, fmt.Errorf("no value for frame %d", frame)
+		}
+		result[i] = value
+	}
+	return result, nil
+}
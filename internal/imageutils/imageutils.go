@@ -3,16 +3,29 @@
 package imageutils
 
 import (
+	"encoding/binary"
+	"fmt"
 	"image"
+	"image/color"
 	"image/draw"
 	"image/png"
+	"io"
+	"math"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
+
+	"github.com/mascotmascot1/go-tlasca/internal/atomicfile"
+	"github.com/mascotmascot1/go-tlasca/internal/raw"
+	// Импорт пакета также регистрирует формат TIFF для image.Decode.
+	"github.com/mascotmascot1/go-tlasca/internal/tiff"
+	"github.com/mascotmascot1/go-tlasca/pkg/frame"
 )
 
 // ExtractNumber извлекает числовое значение из имени файла (например, "10.png").
+// Если имя без расширения не является числом, используются цифры в его конце
+// ("img_0001.tif" - 1, "frame-12.png" - 12).
 //
 // Принимает:
 //
@@ -21,18 +34,41 @@ import (
 // Возвращает:
 //
 //	int: числовое значение, извлеченное из имени файла.
-//	error: ошибку, если имя файла имеет неверный формат или не содержит числа.
+//	error: ошибку, если имя файла не является числом и не оканчивается цифрами.
 func ExtractNumber(filename string) (int, error) {
 	filename = filepath.Base(filename)
-	filename = strings.TrimSuffix(filename, ".png")
-	number, err := strconv.Atoi(filename)
+	// Расширение отбрасывается без учета регистра ("10.PNG" на Windows).
+	stem := strings.TrimSuffix(filename, filepath.Ext(filename))
+	if number, err := strconv.Atoi(stem); err == nil {
+		return number, nil
+	}
+	prefix := strings.TrimRightFunc(stem, func(r rune) bool { return r >= '0' && r <= '9' })
+	number, err := strconv.Atoi(stem[len(prefix):])
 	if err != nil {
-		return 0, err
+		return 0, fmt.Errorf("file name '%s' does not end with a frame number", filename)
 	}
 	return number, nil
 }
 
-// LoadImage загружает изображение из файла.
+// rawFormats - форматы кадров без заголовка по расширению файла в нижнем регистре (см. RegisterRaw).
+var rawFormats = map[string]raw.Format{}
+
+// RegisterRaw регистрирует формат кадров без заголовка для файлов с расширением ext
+// (например, ".raw"; регистр не учитывается): такие файлы LoadImage и LoadImageConfig
+// читают как кадры формата format, а не по содержимому. Регистрация выполняется
+// до загрузки изображений.
+func RegisterRaw(ext string, format raw.Format) {
+	rawFormats[strings.ToLower(ext)] = format
+}
+
+// rawFormat возвращает зарегистрированный формат кадров без заголовка для файла filename.
+func rawFormat(filename string) (raw.Format, bool) {
+	format, ok := rawFormats[strings.ToLower(filepath.Ext(filename))]
+	return format, ok
+}
+
+// LoadImage загружает изображение из файла. Число одновременных чтений ограничивается
+// SetMaxReaders.
 //
 // Принимает:
 // filename string: путь к изображению.
@@ -41,6 +77,7 @@ func ExtractNumber(filename string) (int, error) {
 // image.Image: загруженное изображение.
 // error: ошибку, если не удалось загрузить изображение.
 func LoadImage(filename string) (img image.Image, err error) {
+	defer acquireReader()()
 	file, err := os.Open(filename)
 	if err != nil {
 		return nil, err
@@ -52,6 +89,9 @@ func LoadImage(filename string) (img image.Image, err error) {
 			}
 		}
 	}()
+	if format, ok := rawFormat(filename); ok {
+		return format.Decode(file)
+	}
 	img, _, err = image.Decode(file)
 	if err != nil {
 		return nil, err
@@ -59,6 +99,48 @@ func LoadImage(filename string) (img image.Image, err error) {
 	return img, nil
 }
 
+// LoadImageConfig читает только заголовок изображения (размеры и цветовую модель),
+// не декодируя пиксельные данные.
+//
+// Принимает:
+// filename string: путь к изображению.
+//
+// Возвращает:
+// image.Config: размеры и цветовая модель изображения.
+// error: ошибку, если не удалось прочитать заголовок.
+func LoadImageConfig(filename string) (cfg image.Config, err error) {
+	defer acquireReader()()
+	// Размеры кадра без заголовка заданы форматом; размер файла проверяется сразу,
+	// чтобы неверные параметры формата обнаруживались до загрузки кадров.
+	if format, ok := rawFormat(filename); ok {
+		info, err := os.Stat(filename)
+		if err != nil {
+			return image.Config{}, err
+		}
+		if info.Size() != format.FileSize() {
+			return image.Config{}, fmt.Errorf("file size %d bytes does not match %d bytes of a %dx%d raw frame with %d-byte samples and a %d-byte header",
+				info.Size(), format.FileSize(), format.Width, format.Height, format.BytesPerSample(), format.HeaderBytes)
+		}
+		return format.Config(), nil
+	}
+	file, err := os.Open(filename)
+	if err != nil {
+		return image.Config{}, err
+	}
+	defer func() {
+		if closeErr := file.Close(); closeErr != nil {
+			if err == nil {
+				err = closeErr
+			}
+		}
+	}()
+	cfg, _, err = image.DecodeConfig(file)
+	if err != nil {
+		return image.Config{}, err
+	}
+	return cfg, nil
+}
+
 // СonvertToGray преобразует изображение в градации серого.
 //
 // Принимает:
@@ -73,29 +155,367 @@ func ConvertToGray(img image.Image) *image.Gray {
 	return grayImg
 }
 
-// saveImage сохраняет изображение в формате PNG.
+// ConvertToFrame преобразует декодированное изображение в кадр с исходными значениями
+// отсчетов без масштабирования. 8- и 16-битные изображения в градациях серого используются
+// без копирования (8-битные кадры не расширяются до 16 бит, что вдвое экономит память);
+// 16-битные цветные форматы приводятся к 16-битным градациям серого, остальные - к 8-битным.
+//
+// Принимает:
+// img image.Image: входное изображение.
+//
+// Возвращает:
+// frame.Frame: кадр с исходными значениями отсчетов.
+// int: разрядность контейнера источника (8 или 16 бит).
+func ConvertToFrame(img image.Image) (frame.Frame, int) {
+	switch src := img.(type) {
+	case *image.Gray16:
+		return frame.NewGray16(src), 16
+	case *image.Gray:
+		return frame.NewGray(src), 8
+	}
+
+	bounds := img.Bounds()
+	switch img.ColorModel() {
+	case color.RGBA64Model, color.NRGBA64Model, color.Gray16Model:
+		grayImg := image.NewGray16(bounds)
+		draw.Draw(grayImg, bounds, img, bounds.Min, draw.Src)
+		return frame.NewGray16(grayImg), 16
+	default:
+		return frame.NewGray(ConvertToGray(img)), 8
+	}
+}
+
+// DetectBitDepth определяет фактическую разрядность данных по выборке кадров.
+// Для 8-битного контейнера разрядность равна 8. Для 16-битного контейнера выбирается
+// наименьшая из разрядностей 10, 12, 14, 16, вмещающая максимальное значение кадров выборки:
+// камеры с 10- и 12-битными сенсорами часто сохраняют данные в 16-битные файлы без сдвига.
+// Оценка снизу: кадр вне выборки может оказаться ярче.
+//
+// Принимает:
+// frames []frame.Frame: кадры выборки с исходными значениями отсчетов.
+// containerDepth int: разрядность контейнера (см. ConvertToFrame).
+//
+// Возвращает:
+// int: оценку фактической разрядности данных.
+func DetectBitDepth(frames []frame.Frame, containerDepth int) int {
+	if containerDepth <= 8 {
+		return 8
+	}
+	var maxValue uint16
+	for _, f := range frames {
+		bounds := f.Bounds()
+		buf := frame.RowBuffer(f)
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			for _, v := range f.Row(y, buf) {
+				maxValue = max(maxValue, v)
+			}
+		}
+	}
+	for _, depth := range []int{10, 12, 14} {
+		if int(maxValue) < 1<<depth {
+			return depth
+		}
+	}
+	return 16
+}
+
+// CountAtLeast возвращает число пикселей кадра со значением не меньше level
+// (например, число насыщенных пикселей).
+func CountAtLeast(f frame.Frame, level uint16) int {
+	var count int
+	bounds := f.Bounds()
+	buf := frame.RowBuffer(f)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for _, v := range f.Row(y, buf) {
+			if v >= level {
+				count++
+			}
+		}
+	}
+	return count
+}
+
+// MaxPNGSize возвращает верхнюю оценку размера PNG-файла изображения width x height
+// с bytesPerPixel байтами на пиксель: несжатые строки с байтом фильтра и служебные
+// данные формата. Используется для проверки свободного места до записи результатов.
+func MaxPNGSize(width, height, bytesPerPixel int) uint64 {
+	raw := uint64(height) * (1 + uint64(width)*uint64(bytesPerPixel))
+	return raw + raw/100 + 1024
+}
+
+// SaveImage сохраняет изображение в формате, выбранном по расширению имени файла:
+// ".tif"/".tiff" - TIFF без сжатия, ".pgm" - двоичный PGM (P5), остальные - PNG.
+// Изображения *image.Gray сохраняются с 8-битными отсчетами, *image.Gray16 - с 16-битными,
+// *Float - в TIFF без квантования (float32), в PNG и PGM - в 16-битной шкале [0, 1].
+// В TIFF и PGM сохраняются только изображения в оттенках серого; цветные (например,
+// *image.RGBA) сохраняются только в PNG.
 //
 // Принимает:
 // filename string: путь для сохранения.
-// img *image.Gray: изображение в градациях серого.
+// img image.Image: изображение.
 //
 // Возвращает:
-// error: ошибку, если не удалось сохранить файл.
-func SaveImage(filename string, img *image.Gray) (err error) {
-	file, err := os.Create(filename)
-	if err != nil {
-		return err
+// error: ошибку, если формат не поддерживает изображение или не удалось сохранить файл.
+func SaveImage(filename string, img image.Image) error {
+	var encode func(w io.Writer, img image.Image) error
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".tif", ".tiff":
+		encode = encodeTIFF
+	case ".pgm":
+		encode = encodePGM
+	default:
+		return SavePNG(filename, img)
 	}
-	defer func() {
-		if closeErr := file.Close(); closeErr != nil {
-			if err == nil {
-				err = closeErr
+	if !isGray(img) {
+		return fmt.Errorf("%s images cannot be saved as '%s', use a .png file", colorModelName(img), filepath.Ext(filename))
+	}
+	return atomicfile.Write(filename, func(w io.Writer) error {
+		return encode(w, img)
+	})
+}
+
+// isGray сообщает, является ли img изображением в оттенках серого.
+func isGray(img image.Image) bool {
+	switch img.(type) {
+	case *image.Gray, *image.Gray16, *Float:
+		return true
+	}
+	model := img.ColorModel()
+	return model == color.GrayModel || model == color.Gray16Model
+}
+
+// colorModelName возвращает название цветовой модели img для сообщений об ошибках.
+func colorModelName(img image.Image) string {
+	switch img.(type) {
+	case *image.RGBA, *image.RGBA64, *image.NRGBA, *image.NRGBA64:
+		return "color"
+	case *image.Paletted:
+		return "palette"
+	}
+	return fmt.Sprintf("%T", img)
+}
+
+// encodeTIFF записывает изображение в оттенках серого img в формате TIFF.
+func encodeTIFF(w io.Writer, img image.Image) error {
+	switch img := img.(type) {
+	case *image.Gray:
+		return tiff.EncodeGray(w, img, "")
+	case *Float:
+		b := img.Bounds()
+		values := make([]float64, 0, b.Dx()*b.Dy())
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			i := img.PixOffset(b.Min.X, y)
+			values = append(values, img.Pix[i:i+b.Dx()]...)
+		}
+		return tiff.EncodeFloat32(w, values, b.Dx(), b.Dy(), img.Description)
+	}
+	return tiff.EncodeGray16(w, toGray16(img), "")
+}
+
+// encodePGM записывает изображение в оттенках серого img в двоичном формате PGM (P5):
+// 8-битные отсчеты для *image.Gray, иначе 16-битные (big-endian, как требует формат).
+func encodePGM(w io.Writer, img image.Image) error {
+	b := img.Bounds()
+	if gray, ok := img.(*image.Gray); ok {
+		if _, err := fmt.Fprintf(w, "P5\n%d %d\n255\n", b.Dx(), b.Dy()); err != nil {
+			return err
+		}
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			i := gray.PixOffset(b.Min.X, y)
+			if _, err := w.Write(gray.Pix[i : i+b.Dx()]); err != nil {
+				return err
 			}
 		}
-	}()
-	err = png.Encode(file, img)
-	if err != nil {
+		return nil
+	}
+	if _, err := fmt.Fprintf(w, "P5\n%d %d\n65535\n", b.Dx(), b.Dy()); err != nil {
 		return err
 	}
+	gray16 := toGray16(img)
+	row := make([]byte, 0, 2*b.Dx())
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		row = row[:0]
+		for x := b.Min.X; x < b.Max.X; x++ {
+			row = binary.BigEndian.AppendUint16(row, gray16.Gray16At(x, y).Y)
+		}
+		if _, err := w.Write(row); err != nil {
+			return err
+		}
+	}
 	return nil
 }
+
+// toGray16 возвращает img как *image.Gray16 (копию для других типов изображений).
+func toGray16(img image.Image) *image.Gray16 {
+	if gray16, ok := img.(*image.Gray16); ok {
+		return gray16
+	}
+	gray16 := image.NewGray16(img.Bounds())
+	draw.Draw(gray16, gray16.Rect, img, img.Bounds().Min, draw.Src)
+	return gray16
+}
+
+// Float - изображение в оттенках серого с отсчетами float64 (например, карта контраста
+// без квантования), по строкам с шагом Stride. В TIFF сохраняется без квантования
+// (SaveImage); как image.Image возвращает отсчеты, ограниченные диапазоном [0, 1],
+// в 16-битной шкале (NaN - как 0).
+type Float struct {
+	Pix    []float64
+	Stride int
+	Rect   image.Rectangle
+	// Description - описание содержимого (тег ImageDescription TIFF).
+	Description string
+}
+
+// NewFloat создает изображение Float размером width x height по значениям values
+// (по строкам, без копирования).
+func NewFloat(values []float64, width, height int) *Float {
+	return &Float{Pix: values, Stride: width, Rect: image.Rect(0, 0, width, height)}
+}
+
+// ColorModel возвращает 16-битную модель оттенков серого.
+func (f *Float) ColorModel() color.Model {
+	return color.Gray16Model
+}
+
+// Bounds возвращает границы изображения.
+func (f *Float) Bounds() image.Rectangle {
+	return f.Rect
+}
+
+// At возвращает отсчет (x, y) в 16-битной шкале [0, 1].
+func (f *Float) At(x, y int) color.Color {
+	if !image.Pt(x, y).In(f.Rect) {
+		return color.Gray16{}
+	}
+	v := f.Pix[f.PixOffset(x, y)]
+	if math.IsNaN(v) {
+		return color.Gray16{}
+	}
+	return color.Gray16{Y: uint16(math.Round(min(max(v, 0), 1) * math.MaxUint16))}
+}
+
+// PixOffset возвращает индекс отсчета (x, y) в Pix.
+func (f *Float) PixOffset(x, y int) int {
+	return (y-f.Rect.Min.Y)*f.Stride + (x - f.Rect.Min.X)
+}
+
+// SavePNG сохраняет изображение произвольной цветовой модели (например, цветные графики) в формате PNG.
+//
+// Принимает:
+// filename string: путь для сохранения.
+// img image.Image: изображение.
+//
+// Возвращает:
+// error: ошибку, если не удалось сохранить файл.
+func SavePNG(filename string, img image.Image) error {
+	return atomicfile.Write(filename, func(w io.Writer) error {
+		return png.Encode(w, img)
+	})
+}
+
+// SaveFloatTIFF сохраняет плоскость значений values размером width x height в формате
+// TIFF с отсчетами float32 (см. tiff.EncodeFloat32), без квантования.
+//
+// Принимает:
+// filename string: путь для сохранения.
+// values []float64: значения по строкам.
+// width, height int: размеры плоскости.
+// description string: описание содержимого (тег ImageDescription).
+//
+// Возвращает:
+// error: ошибку, если не удалось сохранить файл.
+func SaveFloatTIFF(filename string, values []float64, width, height int, description string) error {
+	return atomicfile.Write(filename, func(w io.Writer) error {
+		return tiff.EncodeFloat32(w, values, width, height, description)
+	})
+}
+
+// SaveMatrixCSV сохраняет плоскость значений values размером width x height в CSV-файл
+// в виде матрицы: строка файла - строка плоскости, без заголовка, значения с полной
+// точностью (NaN - как "NaN"). Файл читается numpy.loadtxt(..., delimiter=",")
+// и pandas.read_csv(..., header=None).
+//
+// Принимает:
+// filename string: путь для сохранения.
+// values []float64: значения по строкам.
+// width, height int: размеры плоскости.
+//
+// Возвращает:
+// error: ошибку, если размеры не соответствуют значениям или не удалось сохранить файл.
+func SaveMatrixCSV(filename string, values []float64, width, height int) error {
+	if len(values) != width*height {
+		return fmt.Errorf("%d values do not match matrix size %dx%d", len(values), width, height)
+	}
+	return atomicfile.Write(filename, func(w io.Writer) error {
+		line := make([]byte, 0, 24*width)
+		for y := 0; y < height; y++ {
+			line = line[:0]
+			for x, v := range values[y*width : (y+1)*width] {
+				if x > 0 {
+					line = append(line, ',')
+				}
+				line = strconv.AppendFloat(line, v, 'g', -1, 64)
+			}
+			line = append(line, '\n')
+			if _, err := w.Write(line); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// SaveNPY сохраняет плоскость значений values размером width x height в файл формата
+// NumPy .npy (версия 1.0): массив float64 ('<f8') формы (height, width) в порядке строк.
+// Файл читается numpy.load без дополнительных параметров.
+//
+// Принимает:
+// filename string: путь для сохранения.
+// values []float64: значения по строкам.
+// width, height int: размеры плоскости.
+//
+// Возвращает:
+// error: ошибку, если размеры не соответствуют значениям или не удалось сохранить файл.
+func SaveNPY(filename string, values []float64, width, height int) error {
+	if len(values) != width*height {
+		return fmt.Errorf("%d values do not match matrix size %dx%d", len(values), width, height)
+	}
+	return atomicfile.Write(filename, func(w io.Writer) error {
+		// Заголовок дополняется пробелами так, чтобы данные начинались с границы 64 байт.
+		header := fmt.Sprintf("{'descr': '<f8', 'fortran_order': False, 'shape': (%d, %d), }", height, width)
+		const prefixSize = 10
+		padding := 64 - (prefixSize+len(header)+1)%64
+		if padding == 64 {
+			padding = 0
+		}
+		header += strings.Repeat(" ", padding) + "\n"
+		prefix := []byte("\x93NUMPY\x01\x00")
+		prefix = binary.LittleEndian.AppendUint16(prefix, uint16(len(header)))
+		if _, err := w.Write(append(prefix, header...)); err != nil {
+			return err
+		}
+		buf := make([]byte, 0, 8*width)
+		for y := 0; y < height; y++ {
+			buf = buf[:0]
+			for _, v := range values[y*width : (y+1)*width] {
+				buf = binary.LittleEndian.AppendUint64(buf, math.Float64bits(v))
+			}
+			if _, err := w.Write(buf); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// MaxNPYSize возвращает верхнюю оценку размера файла SaveNPY для плоскости width x height.
+func MaxNPYSize(width, height int) uint64 {
+	return 128 + 8*uint64(width)*uint64(height)
+}
+
+// MaxMatrixCSVSize возвращает верхнюю оценку размера файла SaveMatrixCSV для плоскости
+// width x height: не более 25 символов на значение с разделителем.
+func MaxMatrixCSVSize(width, height int) uint64 {
+	return 25 * uint64(width) * uint64(height)
+}
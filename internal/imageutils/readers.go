@@ -0,0 +1,31 @@
+package imageutils
+
+import "sync/atomic"
+
+// readers - семафор, ограничивающий число одновременно читаемых файлов изображений
+// (см. SetMaxReaders); nil означает отсутствие ограничения.
+var readers atomic.Pointer[chan struct{}]
+
+// SetMaxReaders ограничивает число файлов изображений, одновременно читаемых
+// LoadImage и LoadImageConfig, значением n; n <= 0 снимает ограничение. Ограничение
+// снижает нагрузку на диск, который используется одновременно с другими программами
+// (например, программой записи кадров). Вызовы, уже ожидающие чтения, используют
+// прежнее ограничение.
+func SetMaxReaders(n int) {
+	if n <= 0 {
+		readers.Store(nil)
+		return
+	}
+	sem := make(chan struct{}, n)
+	readers.Store(&sem)
+}
+
+// acquireReader ожидает разрешения на чтение файла и возвращает функцию его освобождения.
+func acquireReader() func() {
+	sem := readers.Load()
+	if sem == nil {
+		return func() {}
+	}
+	*sem <- struct{}{}
+	return func() { <-*sem }
+}
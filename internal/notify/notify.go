@@ -0,0 +1,88 @@
+// Package notify отправляет уведомления о завершении запуска (по электронной почте
+// и во входящий веб-хук Slack), чтобы о результате длительного пакетного расчета
+// можно было узнать, не следя за логом.
+package notify
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/mascotmascot1/go-tlasca/internal/config"
+)
+
+// Message - уведомление о запуске.
+type Message struct {
+	// Subject - краткая строка итога (тема письма, заголовок сообщения).
+	Subject string
+	// Text - текст уведомления (основные показатели запуска или текст ошибки).
+	Text string
+	// Image - необязательное PNG-изображение (сводная иллюстрация); ImageName - имя
+	// его файла. Вкладывается в письмо; в Slack передается только текст.
+	Image     []byte
+	ImageName string
+}
+
+// Sink отправляет уведомления одним способом.
+type Sink interface {
+	// Send отправляет сообщение msg; ctx ограничивает время отправки.
+	Send(ctx context.Context, msg Message) error
+	// String возвращает краткое описание способа отправки для логов.
+	String() string
+}
+
+// New создает способы отправки по параметрам cfg: пустой срез, если ни один
+// способ не настроен. Секреты (пароль SMTP, адрес веб-хука) читаются из переменных
+// окружения, указанных в cfg.
+func New(cfg config.NotificationsConfig) ([]Sink, error) {
+	switch cfg.On {
+	case "always", "failure", "success":
+	default:
+		return nil, fmt.Errorf("unknown notifications on '%s', expected always, failure or success", cfg.On)
+	}
+	if cfg.TimeoutS <= 0 {
+		return nil, fmt.Errorf("invalid notifications timeout_s %d, expected a positive value", cfg.TimeoutS)
+	}
+	var sinks []Sink
+	if cfg.SMTP.Addr != "" {
+		s := cfg.SMTP
+		switch {
+		case s.From == "":
+			return nil, fmt.Errorf("smtp notifications require a from address")
+		case len(s.To) == 0:
+			return nil, fmt.Errorf("smtp notifications require at least one to address")
+		case strings.ContainsAny(s.From+strings.Join(s.To, ""), "\r\n"):
+			return nil, fmt.Errorf("smtp addresses must not contain line breaks")
+		}
+		password, err := secret(s.PasswordEnv, s.Username != "")
+		if err != nil {
+			return nil, fmt.Errorf("smtp password: %w", err)
+		}
+		sinks = append(sinks, &SMTP{Addr: s.Addr, Username: s.Username, Password: password, From: s.From, To: s.To})
+	}
+	if cfg.Slack.WebhookURLEnv != "" {
+		url, err := secret(cfg.Slack.WebhookURLEnv, true)
+		if err != nil {
+			return nil, fmt.Errorf("slack webhook url: %w", err)
+		}
+		sinks = append(sinks, &Slack{WebhookURL: url})
+	}
+	return sinks, nil
+}
+
+// secret возвращает значение переменной окружения name. Если required, переменная
+// должна быть задана и не пуста.
+func secret(name string, required bool) (string, error) {
+	if name == "" {
+		if required {
+			return "", fmt.Errorf("no environment variable is configured")
+		}
+		return "", nil
+	}
+	value := os.Getenv(name)
+	if value == "" && required {
+		return "", fmt.Errorf("environment variable %s is not set", name)
+	}
+	return value, nil
+}
@@ -0,0 +1,52 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+// Slack отправляет уведомления во входящий веб-хук Slack (incoming webhook).
+// Веб-хук принимает только текст, поэтому изображение не передается.
+type Slack struct {
+	// WebhookURL - адрес веб-хука.
+	WebhookURL string
+}
+
+// String возвращает описание способа отправки (без адреса веб-хука: он дает право публикации).
+func (s *Slack) String() string {
+	return "slack webhook"
+}
+
+// Send публикует тему и текст msg одним сообщением.
+func (s *Slack) Send(ctx context.Context, msg Message) error {
+	payload, err := json.Marshal(map[string]string{"text": "*" + msg.Subject + "*\n" + msg.Text})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.WebhookURL, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("invalid webhook url")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		// Ошибка запроса содержит адрес веб-хука, который не должен попадать в лог.
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			return urlErr.Err
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("webhook responded %s: %s", resp.Status, bytes.TrimSpace(body))
+	}
+	return nil
+}
@@ -0,0 +1,5 @@
+// Package opencl рассчитывает временные статистики пикселей на GPU через OpenCL.
+// Пакет собирается только с тегом opencl при включенном cgo; библиотека OpenCL
+// (ICD loader) загружается при первом обращении через dlopen, поэтому сборка
+// не требует заголовков и библиотек OpenCL, а без драйвера Open возвращает ошибку.
+package opencl
@@ -0,0 +1,339 @@
+//go:build opencl && cgo && unix
+
+package opencl
+
+/*
+#cgo LDFLAGS: -ldl
+#include <dlfcn.h>
+#include <stdint.h>
+#include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
+
+typedef int32_t cl_int;
+typedef uint32_t cl_uint;
+typedef uint64_t cl_bitfield;
+
+#define CL_SUCCESS 0
+#define CL_DEVICE_TYPE_GPU ((cl_bitfield)1 << 2)
+#define CL_DEVICE_TYPE_ALL ((cl_bitfield)0xFFFFFFFF)
+#define CL_DEVICE_MAX_MEM_ALLOC_SIZE 0x1010
+#define CL_DEVICE_NAME 0x102B
+#define CL_DEVICE_EXTENSIONS 0x1030
+#define CL_MEM_WRITE_ONLY ((cl_bitfield)1 << 1)
+#define CL_MEM_READ_ONLY ((cl_bitfield)1 << 2)
+#define CL_MEM_COPY_HOST_PTR ((cl_bitfield)1 << 5)
+#define CL_PROGRAM_BUILD_LOG 0x1183
+#define CL_TRUE 1
+
+// Функции OpenCL 1.2, используемые расчетом; объекты OpenCL передаются как void *.
+static struct {
+	cl_int (*GetPlatformIDs)(cl_uint, void **, cl_uint *);
+	cl_int (*GetDeviceIDs)(void *, cl_bitfield, cl_uint, void **, cl_uint *);
+	cl_int (*GetDeviceInfo)(void *, cl_uint, size_t, void *, size_t *);
+	void *(*CreateContext)(const intptr_t *, cl_uint, void *const *, void *, void *, cl_int *);
+	void *(*CreateCommandQueue)(void *, void *, cl_bitfield, cl_int *);
+	void *(*CreateProgramWithSource)(void *, cl_uint, const char **, const size_t *, cl_int *);
+	cl_int (*BuildProgram)(void *, cl_uint, void *const *, const char *, void *, void *);
+	cl_int (*GetProgramBuildInfo)(void *, void *, cl_uint, size_t, void *, size_t *);
+	void *(*CreateKernel)(void *, const char *, cl_int *);
+	void *(*CreateBuffer)(void *, cl_bitfield, size_t, void *, cl_int *);
+	cl_int (*SetKernelArg)(void *, cl_uint, size_t, const void *);
+	cl_int (*EnqueueNDRangeKernel)(void *, void *, cl_uint, const size_t *, const size_t *, const size_t *, cl_uint, const void *, void *);
+	cl_int (*EnqueueReadBuffer)(void *, void *, cl_uint, size_t, size_t, void *, cl_uint, const void *, void *);
+	cl_int (*ReleaseMemObject)(void *);
+} cl;
+
+static void *tl_context, *tl_queue, *tl_kernel;
+
+// Ядро повторяет операции ядер процессора (accumulate.go) в том же порядке: умножение
+// и сложение не объединяются (FP_CONTRACT OFF), FMA используется только явно.
+static const char *tl_source =
+	"#pragma OPENCL EXTENSION cl_khr_fp64 : enable\n"
+	"#pragma OPENCL FP_CONTRACT OFF\n"
+	"__kernel void temporal_stats(__global const ushort *frames, __global const double *gains,\n"
+	"		const int count, const int pixels, const int fast,\n"
+	"		__global double *mean, __global double *m2) {\n"
+	"	const size_t i = get_global_id(0);\n"
+	"	if (i >= (size_t)pixels) return;\n"
+	"	const double n = (double)count;\n"
+	"	double sum = 0.0;\n"
+	"	if (fast) {\n"
+	"		double sumSq = 0.0;\n"
+	"		for (int t = 0; t < count; t++) {\n"
+	"			const double v = (double)frames[(size_t)t * pixels + i] * gains[t];\n"
+	"			sum += v;\n"
+	"			sumSq = fma(v, v, sumSq);\n"
+	"		}\n"
+	"		const double m = sum / n;\n"
+	"		const double d = sumSq - sum * m;\n"
+	"		mean[i] = m;\n"
+	"		m2[i] = (isnan(d) || d > 0.0) ? d : 0.0;\n"
+	"		return;\n"
+	"	}\n"
+	"	for (int t = 0; t < count; t++) {\n"
+	"		sum += (double)frames[(size_t)t * pixels + i] * gains[t];\n"
+	"	}\n"
+	"	const double m = sum / n;\n"
+	"	double acc = 0.0;\n"
+	"	for (int t = 0; t < count; t++) {\n"
+	"		const double diff = (double)frames[(size_t)t * pixels + i] * gains[t] - m;\n"
+	"		acc += diff * diff;\n"
+	"	}\n"
+	"	mean[i] = m;\n"
+	"	m2[i] = acc;\n"
+	"}\n";
+
+static int tl_error(char *msg, size_t len, const char *what, cl_int code) {
+	snprintf(msg, len, "%s failed with error %d", what, (int)code);
+	return -1;
+}
+
+// tl_load загружает библиотеку OpenCL и разрешает используемые функции.
+static int tl_load(char *msg, size_t len) {
+	static const char *names[] = {
+		"libOpenCL.so.1", "libOpenCL.so", "/System/Library/Frameworks/OpenCL.framework/OpenCL",
+	};
+	void *lib = NULL;
+	for (size_t i = 0; i < sizeof names / sizeof names[0] && lib == NULL; i++) {
+		lib = dlopen(names[i], RTLD_NOW | RTLD_LOCAL);
+	}
+	if (lib == NULL) {
+		snprintf(msg, len, "OpenCL library (libOpenCL.so.1) not found");
+		return -1;
+	}
+#define TL_SYMBOL(name) \
+	if ((*(void **)&cl.name = dlsym(lib, "cl" #name)) == NULL) { \
+		snprintf(msg, len, "OpenCL library lacks cl" #name); \
+		return -1; \
+	}
+	TL_SYMBOL(GetPlatformIDs)
+	TL_SYMBOL(GetDeviceIDs)
+	TL_SYMBOL(GetDeviceInfo)
+	TL_SYMBOL(CreateContext)
+	TL_SYMBOL(CreateCommandQueue)
+	TL_SYMBOL(CreateProgramWithSource)
+	TL_SYMBOL(BuildProgram)
+	TL_SYMBOL(GetProgramBuildInfo)
+	TL_SYMBOL(CreateKernel)
+	TL_SYMBOL(CreateBuffer)
+	TL_SYMBOL(SetKernelArg)
+	TL_SYMBOL(EnqueueNDRangeKernel)
+	TL_SYMBOL(EnqueueReadBuffer)
+	TL_SYMBOL(ReleaseMemObject)
+#undef TL_SYMBOL
+	return 0;
+}
+
+// tl_fp64 сообщает, поддерживает ли устройство double (расширение cl_khr_fp64).
+static int tl_fp64(void *device) {
+	size_t size = 0;
+	if (cl.GetDeviceInfo(device, CL_DEVICE_EXTENSIONS, 0, NULL, &size) != CL_SUCCESS || size == 0) {
+		return 0;
+	}
+	char *extensions = malloc(size + 1);
+	if (extensions == NULL) {
+		return 0;
+	}
+	int ok = cl.GetDeviceInfo(device, CL_DEVICE_EXTENSIONS, size, extensions, NULL) == CL_SUCCESS;
+	extensions[size] = 0;
+	ok = ok && strstr(extensions, "cl_khr_fp64") != NULL;
+	free(extensions);
+	return ok;
+}
+
+// tl_device возвращает первое устройство GPU с поддержкой double, а без него -
+// первое такое устройство любого типа.
+static void *tl_device(char *msg, size_t len) {
+	void *platforms[16];
+	cl_uint platformCount = 0;
+	cl_int err = cl.GetPlatformIDs(16, platforms, &platformCount);
+	if (err != CL_SUCCESS || platformCount == 0) {
+		snprintf(msg, len, "no OpenCL platforms found (error %d)", (int)err);
+		return NULL;
+	}
+	if (platformCount > 16) {
+		platformCount = 16;
+	}
+	const cl_bitfield types[] = {CL_DEVICE_TYPE_GPU, CL_DEVICE_TYPE_ALL};
+	for (int k = 0; k < 2; k++) {
+		for (cl_uint p = 0; p < platformCount; p++) {
+			void *devices[16];
+			cl_uint deviceCount = 0;
+			if (cl.GetDeviceIDs(platforms[p], types[k], 16, devices, &deviceCount) != CL_SUCCESS) {
+				continue;
+			}
+			for (cl_uint d = 0; d < deviceCount && d < 16; d++) {
+				if (tl_fp64(devices[d])) {
+					return devices[d];
+				}
+			}
+		}
+	}
+	snprintf(msg, len, "no OpenCL device supports double precision (cl_khr_fp64)");
+	return NULL;
+}
+
+// tl_init выбирает устройство, компилирует ядро и возвращает имя устройства и наибольший
+// размер буфера на нем. При ошибке возвращает -1 и описание в msg.
+static int tl_init(char *name, size_t nameLen, uint64_t *maxAlloc, char *msg, size_t len) {
+	if (tl_load(msg, len) != 0) {
+		return -1;
+	}
+	void *device = tl_device(msg, len);
+	if (device == NULL) {
+		return -1;
+	}
+	memset(name, 0, nameLen);
+	cl.GetDeviceInfo(device, CL_DEVICE_NAME, nameLen - 1, name, NULL);
+	cl_int err = cl.GetDeviceInfo(device, CL_DEVICE_MAX_MEM_ALLOC_SIZE, sizeof *maxAlloc, maxAlloc, NULL);
+	if (err != CL_SUCCESS) {
+		return tl_error(msg, len, "clGetDeviceInfo", err);
+	}
+	tl_context = cl.CreateContext(NULL, 1, &device, NULL, NULL, &err);
+	if (tl_context == NULL) {
+		return tl_error(msg, len, "clCreateContext", err);
+	}
+	tl_queue = cl.CreateCommandQueue(tl_context, device, 0, &err);
+	if (tl_queue == NULL) {
+		return tl_error(msg, len, "clCreateCommandQueue", err);
+	}
+	void *program = cl.CreateProgramWithSource(tl_context, 1, &tl_source, NULL, &err);
+	if (program == NULL) {
+		return tl_error(msg, len, "clCreateProgramWithSource", err);
+	}
+	if ((err = cl.BuildProgram(program, 1, &device, "", NULL, NULL)) != CL_SUCCESS) {
+		int n = snprintf(msg, len, "kernel build failed with error %d: ", (int)err);
+		if (n > 0 && (size_t)n < len) {
+			memset(msg + n, 0, len - n);
+			cl.GetProgramBuildInfo(program, device, CL_PROGRAM_BUILD_LOG, len - n - 1, msg + n, NULL);
+		}
+		return -1;
+	}
+	tl_kernel = cl.CreateKernel(program, "temporal_stats", &err);
+	if (tl_kernel == NULL) {
+		return tl_error(msg, len, "clCreateKernel", err);
+	}
+	return 0;
+}
+
+// tl_stats рассчитывает среднее и M2 pixels пикселей по count кадрам frames
+// (кадр за кадром, по pixels отсчетов) с коэффициентами gains.
+static int tl_stats(const uint16_t *frames, const double *gains, int count, int pixels, int fast,
+		double *mean, double *m2, char *msg, size_t len) {
+	void *buffers[4] = {NULL, NULL, NULL, NULL};
+	cl_int err;
+	int status = -1;
+	size_t frameBytes = (size_t)count * pixels * sizeof *frames, planeBytes = (size_t)pixels * sizeof *mean;
+	buffers[0] = cl.CreateBuffer(tl_context, CL_MEM_READ_ONLY | CL_MEM_COPY_HOST_PTR, frameBytes, (void *)frames, &err);
+	if (buffers[0] == NULL) {
+		tl_error(msg, len, "clCreateBuffer (frames)", err);
+		goto done;
+	}
+	buffers[1] = cl.CreateBuffer(tl_context, CL_MEM_READ_ONLY | CL_MEM_COPY_HOST_PTR, count * sizeof *gains, (void *)gains, &err);
+	if (buffers[1] == NULL) {
+		tl_error(msg, len, "clCreateBuffer (gains)", err);
+		goto done;
+	}
+	for (int i = 2; i < 4; i++) {
+		buffers[i] = cl.CreateBuffer(tl_context, CL_MEM_WRITE_ONLY, planeBytes, NULL, &err);
+		if (buffers[i] == NULL) {
+			tl_error(msg, len, "clCreateBuffer (statistics)", err);
+			goto done;
+		}
+	}
+	cl_int args[3] = {count, pixels, fast};
+	if ((err = cl.SetKernelArg(tl_kernel, 0, sizeof buffers[0], &buffers[0])) != CL_SUCCESS ||
+		(err = cl.SetKernelArg(tl_kernel, 1, sizeof buffers[1], &buffers[1])) != CL_SUCCESS ||
+		(err = cl.SetKernelArg(tl_kernel, 2, sizeof args[0], &args[0])) != CL_SUCCESS ||
+		(err = cl.SetKernelArg(tl_kernel, 3, sizeof args[1], &args[1])) != CL_SUCCESS ||
+		(err = cl.SetKernelArg(tl_kernel, 4, sizeof args[2], &args[2])) != CL_SUCCESS ||
+		(err = cl.SetKernelArg(tl_kernel, 5, sizeof buffers[2], &buffers[2])) != CL_SUCCESS ||
+		(err = cl.SetKernelArg(tl_kernel, 6, sizeof buffers[3], &buffers[3])) != CL_SUCCESS) {
+		tl_error(msg, len, "clSetKernelArg", err);
+		goto done;
+	}
+	size_t global = (size_t)pixels;
+	if ((err = cl.EnqueueNDRangeKernel(tl_queue, tl_kernel, 1, NULL, &global, NULL, 0, NULL, NULL)) != CL_SUCCESS) {
+		tl_error(msg, len, "clEnqueueNDRangeKernel", err);
+		goto done;
+	}
+	if ((err = cl.EnqueueReadBuffer(tl_queue, buffers[2], CL_TRUE, 0, planeBytes, mean, 0, NULL, NULL)) != CL_SUCCESS ||
+		(err = cl.EnqueueReadBuffer(tl_queue, buffers[3], CL_TRUE, 0, planeBytes, m2, 0, NULL, NULL)) != CL_SUCCESS) {
+		tl_error(msg, len, "clEnqueueReadBuffer", err);
+		goto done;
+	}
+	status = 0;
+done:
+	for (int i = 0; i < 4; i++) {
+		if (buffers[i] != NULL) {
+			cl.ReleaseMemObject(buffers[i]);
+		}
+	}
+	return status;
+}
+*/
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"unsafe"
+)
+
+// device - устройство OpenCL процесса, выбираемое при первом обращении (см. Open).
+var device struct {
+	once     sync.Once
+	err      error
+	name     string
+	maxAlloc uint64
+	// mu упорядочивает расчеты: контекст, очередь и ядро общие для процесса.
+	mu sync.Mutex
+}
+
+// Open загружает библиотеку OpenCL, выбирает устройство и компилирует ядро при первом
+// вызове. Выбирается первое устройство GPU с поддержкой double (cl_khr_fp64), а при его
+// отсутствии - любое такое устройство OpenCL. Возвращает имя устройства и наибольший
+// размер буфера на нем в байтах или ошибку, если библиотека или устройство не найдены.
+func Open() (name string, maxAlloc uint64, err error) {
+	device.once.Do(func() {
+		var name [256]C.char
+		var msg [1024]C.char
+		var maxAlloc C.uint64_t
+		if C.tl_init(&name[0], C.size_t(len(name)), &maxAlloc, &msg[0], C.size_t(len(msg))) != 0 {
+			device.err = fmt.Errorf("opencl: %s", C.GoString(&msg[0]))
+			return
+		}
+		device.name = C.GoString(&name[0])
+		device.maxAlloc = uint64(maxAlloc)
+	})
+	return device.name, device.maxAlloc, device.err
+}
+
+// Stats рассчитывает на устройстве среднее mean и M2 m2 для pixels пикселей по кадрам
+// frames, записанным кадр за кадром (по pixels отсчетов), с коэффициентами кадров gains:
+// двухпроходным расчетом или, при fast, по сумме и сумме квадратов. Операции и их порядок
+// совпадают с ядрами процессора пакета tlasca. Open должен быть успешно вызван заранее.
+func Stats(frames []uint16, gains []float64, pixels int, fast bool, mean, m2 []float64) error {
+	if _, _, err := Open(); err != nil {
+		return err
+	}
+	if pixels <= 0 || len(gains) == 0 || len(frames) != len(gains)*pixels || len(mean) < pixels || len(m2) < pixels {
+		return errors.New("opencl: statistics buffers do not match the frame count and size")
+	}
+	var cFast C.int
+	if fast {
+		cFast = 1
+	}
+	var msg [1024]C.char
+	device.mu.Lock()
+	defer device.mu.Unlock()
+	if C.tl_stats((*C.uint16_t)(unsafe.Pointer(&frames[0])), (*C.double)(unsafe.Pointer(&gains[0])),
+		C.int(len(gains)), C.int(pixels), cFast,
+		(*C.double)(unsafe.Pointer(&mean[0])), (*C.double)(unsafe.Pointer(&m2[0])),
+		&msg[0], C.size_t(len(msg))) != 0 {
+		return fmt.Errorf("opencl: %s", C.GoString(&msg[0]))
+	}
+	return nil
+}
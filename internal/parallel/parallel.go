@@ -0,0 +1,182 @@
+// Package parallel содержит примитивы распараллеливания по ядрам CPU,
+// общие для расчета и подготовки выходных изображений.
+package parallel
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+)
+
+// workers - число горутин для Rows и Each; 0 означает число логических ядер CPU.
+var workers atomic.Int64
+
+// SetWorkers задает число горутин, используемых Rows и Each; n <= 0 означает
+// число логических ядер CPU. Возвращает прежнее значение (0, если оно не задавалось).
+func SetWorkers(n int) int {
+	return int(workers.Swap(int64(max(n, 0))))
+}
+
+// Workers возвращает число горутин, используемых Rows и Each.
+func Workers() int {
+	if n := workers.Load(); n > 0 {
+		return int(n)
+	}
+	return runtime.NumCPU()
+}
+
+// PanicError - паника рабочей горутины Rows или Each, перехваченная и преобразованная
+// в ошибку, чтобы ошибка в расчете одного блока строк не завершала весь процесс.
+type PanicError struct {
+	// StartY, EndY - блок строк [StartY, EndY) вызова Rows (для Each и паники
+	// вне рабочих горутин - 0, 0).
+	StartY, EndY int
+	// Index - индекс задания Each (в остальных случаях -1).
+	Index int
+	// Value - значение, переданное panic.
+	Value any
+	// Stack - стек горутины в момент паники.
+	Stack []byte
+}
+
+// Error описывает панику и место, где она произошла.
+func (e *PanicError) Error() string {
+	switch {
+	case e.Index >= 0:
+		return fmt.Sprintf("panic in task %d: %v", e.Index, e.Value)
+	case e.EndY > e.StartY:
+		return fmt.Sprintf("panic in rows [%d, %d): %v", e.StartY, e.EndY, e.Value)
+	}
+	return fmt.Sprintf("panic: %v", e.Value)
+}
+
+// Unwrap возвращает значение паники, если это ошибка (например, runtime.Error).
+func (e *PanicError) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
+
+// TryRows выполняет fn так же, как Rows, но паника в вызове fn не завершает процесс:
+// она перехватывается и возвращается ошибкой *PanicError с блоком строк, а горутина
+// продолжает обработку следующих блоков. Паники нескольких блоков объединяются
+// (errors.Join); без паник возвращает nil.
+func TryRows(height int, fn func(startY, endY int)) error {
+	var mu sync.Mutex
+	var panics []error
+	rows(height, func(startY, endY int) {
+		defer func() {
+			if v := recover(); v != nil {
+				mu.Lock()
+				panics = append(panics, &PanicError{StartY: startY, EndY: endY, Index: -1, Value: v, Stack: debug.Stack()})
+				mu.Unlock()
+			}
+		}()
+		fn(startY, endY)
+	})
+	return errors.Join(panics...)
+}
+
+// Rows делит диапазон строк [0, height) на небольшие блоки строк и вызывает fn для каждого
+// блока; блоки раздаются горутинам (см. Workers) из общей очереди по мере освобождения,
+// поэтому горутина, быстро обработавшая свои блоки (например, строки исключенной области),
+// забирает работу у остальных, и ядра не простаивают до завершения самой медленной полосы.
+// Одна горутина может вызвать fn для нескольких блоков, а блоки обрабатываются
+// в произвольном порядке. Возвращает управление после завершения всех горутин. Паника
+// в горутине блока не завершает процесс: после завершения остальных горутин она повторяется
+// в вызывающей горутине со значением - ошибкой TryRows, поэтому ее можно перехватить через recover.
+func Rows(height int, fn func(startY, endY int)) {
+	if err := TryRows(height, fn); err != nil {
+		panic(err)
+	}
+}
+
+// blocksPerWorker - число блоков строк Rows на одну горутину: чем больше блоков, тем
+// равномернее загрузка ядер, но тем больше вызовов fn (и их подготовки, например буферов строк).
+const blocksPerWorker = 8
+
+// rows выполняет разбиение на блоки и запуск горутин для TryRows.
+func rows(height int, fn func(startY, endY int)) {
+	if height <= 0 {
+		return
+	}
+	numWorkers := Workers() // По умолчанию используем все доступные логические ядра CPU.
+	blockRows := max((height+numWorkers*blocksPerWorker-1)/(numWorkers*blocksPerWorker), 1)
+
+	// Очередь заполняется всеми блоками заранее и закрывается: горутины забирают блоки,
+	// пока очередь не опустеет.
+	numBlocks := (height + blockRows - 1) / blockRows
+	blocks := make(chan int, numBlocks)
+	for startY := 0; startY < height; startY += blockRows {
+		blocks <- startY
+	}
+	close(blocks)
+
+	var wg sync.WaitGroup
+	wg.Add(min(numWorkers, numBlocks)) // Сообщаем WaitGroup, сколько горутин ожидать.
+	for range min(numWorkers, numBlocks) {
+		go func() {
+			defer wg.Done() // Сообщаем WaitGroup о завершении работы при выходе из горутины.
+			for startY := range blocks {
+				fn(startY, min(startY+blockRows, height))
+			}
+		}()
+	}
+	wg.Wait() // Ожидаем завершения всех горутин.
+}
+
+// Each вызывает fn для каждого индекса [0, n), выполняя одновременно не более
+// Workers() вызовов (например, кодирование тайлов или выходных файлов).
+// Возвращает первую по индексу ошибку; после ошибки оставшиеся задания не запускаются.
+// Паника в fn перехватывается и возвращается ошибкой *PanicError с индексом задания.
+func Each(n int, fn func(i int) error) error {
+	errs := make([]error, n)
+	next := make(chan int)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	failed := false
+
+	for w := 0; w < min(Workers(), n); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				if errs[i] = try(i, fn); errs[i] != nil {
+					mu.Lock()
+					failed = true
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		mu.Lock()
+		stop := failed
+		mu.Unlock()
+		if stop {
+			break
+		}
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// try вызывает fn(i), преобразуя панику в ошибку *PanicError.
+func try(i int, fn func(i int) error) (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			err = &PanicError{Index: i, Value: v, Stack: debug.Stack()}
+		}
+	}()
+	return fn(i)
+}
@@ -0,0 +1,9 @@
+//go:build !windows
+
+package pathutil
+
+// native возвращает путь без изменений: на платформах, отличных от Windows,
+// ограничения длины пути и особые префиксы не применяются.
+func native(path string) string {
+	return path
+}
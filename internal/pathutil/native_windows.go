@@ -0,0 +1,15 @@
+package pathutil
+
+import "path/filepath"
+
+// native приводит путь к абсолютному. Пакет os на Windows автоматически добавляет
+// префикс длинных путей (\\?\, для сетевых ресурсов - \\?\UNC\) только к абсолютным путям,
+// поэтому относительные пути длиннее MAX_PATH (260 символов) без этого не открываются.
+// Пути, уже содержащие префикс \\?\, и пути UNC (\\server\share) остаются абсолютными.
+func native(path string) string {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+	return abs
+}
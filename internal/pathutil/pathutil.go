@@ -0,0 +1,77 @@
+// Package pathutil приводит пути из конфигурации к виду, корректно обрабатываемому
+// на всех поддерживаемых платформах (длинные пути и сетевые ресурсы Windows,
+// имена файлов не в ASCII, метка порядка байтов UTF-8 в текстовых файлах).
+package pathutil
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// utf8BOM - метка порядка байтов, которую Блокнот Windows и Excel добавляют
+// в начало текстовых файлов в кодировке UTF-8.
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// Native возвращает путь path в виде, пригодном для файловых операций на текущей платформе.
+// Пустой путь возвращается без изменений. Подробности - в реализации для платформы (native).
+func Native(path string) string {
+	if path == "" {
+		return path
+	}
+	return native(filepath.Clean(path))
+}
+
+// TrimBOM удаляет метку порядка байтов UTF-8 из начала данных, если она есть.
+func TrimBOM(data []byte) []byte {
+	return bytes.TrimPrefix(data, utf8BOM)
+}
+
+// TrimBOMString удаляет метку порядка байтов UTF-8 из начала строки, если она есть.
+func TrimBOMString(s string) string {
+	return strings.TrimPrefix(s, string(utf8BOM))
+}
+
+// ListFiles возвращает пути к файлам директории dir (без рекурсии), имена которых
+// соответствуют хотя бы одному шаблону include и ни одному шаблону exclude.
+// Шаблоны имеют синтаксис filepath.Match ("*.png", "frame_*.tif") и сравниваются
+// с именем файла без учета регистра ("*.png" соответствует "10.PNG").
+//
+// В отличие от filepath.Glob, путь директории не интерпретируется как шаблон,
+// поэтому квадратные скобки и другие специальные символы в именах папок ("data [2024]")
+// не нарушают поиск; имена в любой кодировке Unicode сравниваются посимвольно.
+// Возвращает ошибку, если директорию не удалось прочитать или шаблон некорректен.
+func ListFiles(dir string, include, exclude []string) ([]string, error) {
+	for _, pattern := range append(append([]string(nil), include...), exclude...) {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern '%s': %w", pattern, err)
+		}
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		if matchAny(include, entry.Name()) && !matchAny(exclude, entry.Name()) {
+			files = append(files, filepath.Join(dir, entry.Name()))
+		}
+	}
+	return files, nil
+}
+
+// matchAny сообщает, соответствует ли имя name хотя бы одному из шаблонов (без учета регистра).
+func matchAny(patterns []string, name string) bool {
+	name = strings.ToLower(name)
+	for _, pattern := range patterns {
+		if ok, _ := filepath.Match(strings.ToLower(pattern), name); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,182 @@
+// Package plot строит простые диагностические графики (линейные графики временных рядов)
+// в виде растровых изображений без внешних зависимостей.
+package plot
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+// margin - отступ области построения от краев изображения в пикселях.
+const margin = 12
+
+var (
+	// Background - цвет фона графика.
+	Background = color.RGBA{R: 255, G: 255, B: 255, A: 255}
+	// Axis - цвет рамки области построения и нулевой линии.
+	Axis = color.RGBA{R: 0, G: 0, B: 0, A: 255}
+	// Guide - цвет горизонтальных опорных линий (пороги, уровни).
+	Guide = color.RGBA{R: 160, G: 160, B: 160, A: 255}
+)
+
+// Series описывает один временной ряд на графике.
+type Series struct {
+	// Values - значения ряда; по горизонтали откладывается индекс значения.
+	Values []float64
+	// Color - цвет линии ряда.
+	Color color.RGBA
+}
+
+// LineChart строит линейный график рядов series в изображении размером width x height.
+// Для каждого значения из guides рисуется пунктирная горизонтальная опорная линия,
+// а если диапазон значений включает ноль - сплошная нулевая линия.
+// Масштаб по вертикали выбирается так, чтобы вместить все ряды и опорные линии.
+func LineChart(width, height int, series []Series, guides []float64) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	fill(img, Background)
+
+	// Определяем диапазоны по осям.
+	lo, hi := math.Inf(1), math.Inf(-1)
+	points := 0
+	for _, s := range series {
+		points = max(points, len(s.Values))
+		for _, v := range s.Values {
+			lo, hi = math.Min(lo, v), math.Max(hi, v)
+		}
+	}
+	for _, g := range guides {
+		lo, hi = math.Min(lo, g), math.Max(hi, g)
+	}
+	if math.IsInf(lo, 0) {
+		lo, hi = 0, 1
+	}
+	if hi == lo {
+		lo, hi = lo-1, hi+1
+	}
+
+	plotW, plotH := width-2*margin, height-2*margin
+	toX := func(i int) int {
+		if points < 2 {
+			return margin
+		}
+		return margin + i*(plotW-1)/(points-1)
+	}
+	toY := func(v float64) int {
+		return margin + int(math.Round((hi-v)/(hi-lo)*float64(plotH-1)))
+	}
+
+	// Рамка, нулевая и опорные линии.
+	rect(img, margin-1, margin-1, margin+plotW, margin+plotH, Axis)
+	if lo < 0 && hi > 0 {
+		line(img, margin, toY(0), margin+plotW-1, toY(0), Axis)
+	}
+	for _, g := range guides {
+		y := toY(g)
+		for x := margin; x < margin+plotW; x++ {
+			if (x/4)%2 == 0 {
+				img.SetRGBA(x, y, Guide)
+			}
+		}
+	}
+
+	// Линии рядов.
+	for _, s := range series {
+		for i := 1; i < len(s.Values); i++ {
+			line(img, toX(i-1), toY(s.Values[i-1]), toX(i), toY(s.Values[i]), s.Color)
+		}
+		if len(s.Values) == 1 {
+			img.SetRGBA(toX(0), toY(s.Values[0]), s.Color)
+		}
+	}
+	return img
+}
+
+// fill заливает изображение цветом c.
+func fill(img *image.RGBA, c color.RGBA) {
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			img.SetRGBA(x, y, c)
+		}
+	}
+}
+
+// rect рисует контур прямоугольника с углами (x0, y0) и (x1, y1).
+func rect(img *image.RGBA, x0, y0, x1, y1 int, c color.RGBA) {
+	line(img, x0, y0, x1, y0, c)
+	line(img, x0, y1, x1, y1, c)
+	line(img, x0, y0, x0, y1, c)
+	line(img, x1, y0, x1, y1, c)
+}
+
+// line рисует отрезок от (x0, y0) до (x1, y1) алгоритмом Брезенхэма.
+func line(img *image.RGBA, x0, y0, x1, y1 int, c color.RGBA) {
+	dx, dy := abs(x1-x0), -abs(y1-y0)
+	sx, sy := 1, 1
+	if x0 > x1 {
+		sx = -1
+	}
+	if y0 > y1 {
+		sy = -1
+	}
+	e := dx + dy
+	for {
+		img.SetRGBA(x0, y0, c)
+		if x0 == x1 && y0 == y1 {
+			return
+		}
+		e2 := 2 * e
+		if e2 >= dy {
+			e += dy
+			x0 += sx
+		}
+		if e2 <= dx {
+			e += dx
+			y0 += sy
+		}
+	}
+}
+
+// abs возвращает модуль целого числа.
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// Histogram строит гистограмму значений values в диапазоне [lo, hi] из bins столбцов
+// в изображении размером width x height. Значения вне диапазона не учитываются.
+func Histogram(width, height int, values []float64, lo, hi float64, bins int, c color.RGBA) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	fill(img, Background)
+
+	counts := make([]int, bins)
+	var peak int
+	for _, v := range values {
+		if v < lo || v > hi || math.IsNaN(v) {
+			continue
+		}
+		bin := min(int((v-lo)/(hi-lo)*float64(bins)), bins-1)
+		counts[bin]++
+		peak = max(peak, counts[bin])
+	}
+
+	plotW, plotH := width-2*margin, height-2*margin
+	rect(img, margin-1, margin-1, margin+plotW, margin+plotH, Axis)
+	if peak == 0 {
+		return img
+	}
+	for i, count := range counts {
+		x0 := margin + i*plotW/bins
+		x1 := margin + (i+1)*plotW/bins
+		barH := int(math.Round(float64(count) / float64(peak) * float64(plotH)))
+		for y := margin + plotH - barH; y < margin+plotH; y++ {
+			for x := x0; x < x1; x++ {
+				img.SetRGBA(x, y, c)
+			}
+		}
+	}
+	return img
+}
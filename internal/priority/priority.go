@@ -0,0 +1,56 @@
+// Package priority понижает приоритет процесса go-tlasca для фоновых (ночных) запусков
+// на рабочих станциях, где одновременно работает программа записи кадров: расчет
+// получает процессорное время и доступ к диску только тогда, когда они не нужны ей.
+package priority
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Приоритеты ввода-вывода (параметр io функции Lower).
+const (
+	// IOLow - наименьший приоритет обычного класса (best-effort, уровень 7 в Linux).
+	IOLow = "low"
+	// IOIdle - ввод-вывод только при простое диска (класс idle в Linux).
+	IOIdle = "idle"
+)
+
+// MaxNice - наибольшее значение nice (наименьший приоритет планировщика CPU).
+const MaxNice = 19
+
+// errUnsupported - ошибка для возможностей, недоступных на текущей системе.
+var errUnsupported = errors.New("not supported on this platform")
+
+// Validate проверяет параметры Lower, не изменяя приоритет.
+func Validate(nice int, io string) error {
+	if nice < 0 || nice > MaxNice {
+		return fmt.Errorf("nice %d is out of range [0, %d]", nice, MaxNice)
+	}
+	if io != "" && io != IOLow && io != IOIdle {
+		return fmt.Errorf("unknown io priority '%s', expected '%s' or '%s'", io, IOLow, IOIdle)
+	}
+	return nil
+}
+
+// Lower понижает приоритет процесса: nice (1-19, как у команды nice; 0 - без изменений)
+// задает приоритет планировщика CPU, io (IOLow или IOIdle; пустая строка - без изменений) -
+// приоритет ввода-вывода. Приоритет только понижается: повысить его обратно без прав
+// администратора нельзя. Возвращает ошибку, если параметры неверны или приоритет
+// не удалось изменить (в том числе если система не поддерживает эту возможность).
+func Lower(nice int, io string) error {
+	if err := Validate(nice, io); err != nil {
+		return err
+	}
+	if nice > 0 {
+		if err := setNice(nice); err != nil {
+			return fmt.Errorf("error setting nice %d: %w", nice, err)
+		}
+	}
+	if io != "" {
+		if err := setIO(io); err != nil {
+			return fmt.Errorf("error setting io priority '%s': %w", io, err)
+		}
+	}
+	return nil
+}
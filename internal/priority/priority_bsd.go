@@ -0,0 +1,15 @@
+//go:build darwin || freebsd
+
+package priority
+
+import "syscall"
+
+// setNice задает nice процесса.
+func setNice(nice int) error {
+	return syscall.Setpriority(syscall.PRIO_PROCESS, 0, nice)
+}
+
+// setIO не поддерживается на этой системе.
+func setIO(string) error {
+	return errUnsupported
+}
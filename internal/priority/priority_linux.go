@@ -0,0 +1,57 @@
+package priority
+
+import (
+	"os"
+	"strconv"
+	"syscall"
+)
+
+// Классы и параметры ioprio_set (linux/ioprio.h).
+const (
+	ioprioWhoProcess = 1
+	ioprioClassShift = 13
+	ioprioClassBE    = 2
+	ioprioClassIdle  = 3
+	ioprioLowestBE   = 7
+)
+
+// setNice задает nice всем потокам процесса: в Linux приоритет относится к потоку,
+// а новые потоки наследуют приоритет создавшего их потока.
+func setNice(nice int) error {
+	return eachThread(func(tid int) error {
+		return syscall.Setpriority(syscall.PRIO_PROCESS, tid, nice)
+	})
+}
+
+// setIO задает приоритет ввода-вывода всем потокам процесса (аналог ionice).
+func setIO(io string) error {
+	prio := ioprioClassIdle << ioprioClassShift
+	if io == IOLow {
+		prio = ioprioClassBE<<ioprioClassShift | ioprioLowestBE
+	}
+	return eachThread(func(tid int) error {
+		if _, _, errno := syscall.Syscall(syscall.SYS_IOPRIO_SET, ioprioWhoProcess, uintptr(tid), uintptr(prio)); errno != 0 {
+			return errno
+		}
+		return nil
+	})
+}
+
+// eachThread вызывает fn для каждого потока процесса (/proc/self/task).
+func eachThread(fn func(tid int) error) error {
+	entries, err := os.ReadDir("/proc/self/task")
+	if err != nil {
+		return fn(0)
+	}
+	for _, entry := range entries {
+		tid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		// Поток мог завершиться после чтения списка.
+		if err := fn(tid); err != nil && err != syscall.ESRCH {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,13 @@
+//go:build !linux && !darwin && !freebsd && !windows
+
+package priority
+
+// setNice не поддерживается на этой системе.
+func setNice(int) error {
+	return errUnsupported
+}
+
+// setIO не поддерживается на этой системе.
+func setIO(string) error {
+	return errUnsupported
+}
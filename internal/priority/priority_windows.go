@@ -0,0 +1,40 @@
+package priority
+
+import "syscall"
+
+// Классы приоритета процесса Windows (SetPriorityClass).
+const (
+	belowNormalPriorityClass   = 0x00004000
+	idlePriorityClass          = 0x00000040
+	processModeBackgroundBegin = 0x00100000
+)
+
+var (
+	kernel32              = syscall.NewLazyDLL("kernel32.dll")
+	procGetCurrentProcess = kernel32.NewProc("GetCurrentProcess")
+	procSetPriorityClass  = kernel32.NewProc("SetPriorityClass")
+)
+
+// setNice задает класс приоритета процесса: nice до 9 - ниже обычного, от 10 - фоновый (idle).
+func setNice(nice int) error {
+	class := uintptr(belowNormalPriorityClass)
+	if nice >= 10 {
+		class = idlePriorityClass
+	}
+	return setPriorityClass(class)
+}
+
+// setIO переводит процесс в фоновый режим (PROCESS_MODE_BACKGROUND_BEGIN), в котором
+// понижены приоритеты ввода-вывода, памяти и CPU. Оба значения io действуют одинаково.
+func setIO(string) error {
+	return setPriorityClass(processModeBackgroundBegin)
+}
+
+// setPriorityClass вызывает SetPriorityClass для текущего процесса.
+func setPriorityClass(class uintptr) error {
+	process, _, _ := procGetCurrentProcess.Call()
+	if ok, _, err := procSetPriorityClass.Call(process, class); ok == 0 {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+// Package raw декодирует кадры без заголовка ("сырые" дампы буфера камеры, которые
+// сохраняют SDK камер): файл содержит только отсчеты одного кадра построчно, без сжатия,
+// поэтому размеры кадра, разрядность и порядок байтов задаются извне (см. Format).
+package raw
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+)
+
+// Порядок байтов 16-битных отсчетов.
+const (
+	LittleEndian = "little"
+	BigEndian    = "big"
+)
+
+// Format описывает раскладку кадра в файле без заголовка.
+type Format struct {
+	// Width, Height - размеры кадра в пикселях.
+	Width, Height int
+	// BitDepth - разрядность отсчетов (8..16 бит): отсчеты до 8 бит занимают один байт,
+	// остальные - два байта.
+	BitDepth int
+	// BigEndian задает порядок байтов двухбайтовых отсчетов (по умолчанию little-endian).
+	BigEndian bool
+	// HeaderBytes - число байтов в начале файла, предшествующих отсчетам (служебный
+	// заголовок SDK), которые пропускаются.
+	HeaderBytes int64
+}
+
+// NewFormat проверяет параметры и возвращает формат кадров width x height разрядности
+// bitDepth с порядком байтов byteOrder (LittleEndian или BigEndian) и заголовком
+// headerBytes байт.
+func NewFormat(width, height, bitDepth int, byteOrder string, headerBytes int64) (Format, error) {
+	if width <= 0 || height <= 0 {
+		return Format{}, fmt.Errorf("invalid frame size %dx%d, expected positive width and height", width, height)
+	}
+	if bitDepth < 8 || bitDepth > 16 {
+		return Format{}, fmt.Errorf("invalid bit depth %d, expected 8..16", bitDepth)
+	}
+	if headerBytes < 0 {
+		return Format{}, fmt.Errorf("invalid header size %d, expected a non-negative value", headerBytes)
+	}
+	f := Format{Width: width, Height: height, BitDepth: bitDepth, HeaderBytes: headerBytes}
+	switch byteOrder {
+	case LittleEndian:
+	case BigEndian:
+		f.BigEndian = true
+	default:
+		return Format{}, fmt.Errorf("unknown byte order '%s', expected '%s' or '%s'", byteOrder, LittleEndian, BigEndian)
+	}
+	return f, nil
+}
+
+// BytesPerSample возвращает число байтов одного отсчета.
+func (f Format) BytesPerSample() int {
+	if f.BitDepth > 8 {
+		return 2
+	}
+	return 1
+}
+
+// FileSize возвращает ожидаемый размер файла кадра в байтах.
+func (f Format) FileSize() int64 {
+	return f.HeaderBytes + int64(f.Width)*int64(f.Height)*int64(f.BytesPerSample())
+}
+
+// Config возвращает размеры и цветовую модель кадров формата.
+func (f Format) Config() image.Config {
+	model := color.GrayModel
+	if f.BytesPerSample() == 2 {
+		model = color.Gray16Model
+	}
+	return image.Config{ColorModel: model, Width: f.Width, Height: f.Height}
+}
+
+// Decode читает кадр формата f из r: *image.Gray для отсчетов до 8 бит, *image.Gray16 -
+// для остальных. Возвращает ошибку, если данных меньше или больше одного кадра
+// или отсчет превышает разрядность BitDepth (признак неверно заданных разрядности
+// или порядка байтов).
+func (f Format) Decode(r io.Reader) (image.Image, error) {
+	if _, err := io.CopyN(io.Discard, r, f.HeaderBytes); err != nil {
+		return nil, fmt.Errorf("file is shorter than the %d-byte header: %w", f.HeaderBytes, err)
+	}
+	rect := image.Rect(0, 0, f.Width, f.Height)
+	var img image.Image
+	var pix []byte
+	if f.BytesPerSample() == 2 {
+		gray := image.NewGray16(rect)
+		img, pix = gray, gray.Pix
+	} else {
+		gray := image.NewGray(rect)
+		img, pix = gray, gray.Pix
+	}
+	if n, err := io.ReadFull(r, pix); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, fmt.Errorf("file holds %d of %d pixel bytes of a %dx%d frame; check raw width, height and bit_depth",
+				n, len(pix), f.Width, f.Height)
+		}
+		return nil, err
+	}
+	// Лишние данные означают, что размеры кадра заданы неверно (или в файле несколько кадров).
+	if n, _ := io.ReadFull(r, make([]byte, 1)); n > 0 {
+		return nil, fmt.Errorf("file is larger than a %dx%d frame of %d-byte samples; check raw width, height and bit_depth",
+			f.Width, f.Height, f.BytesPerSample())
+	}
+
+	if f.BytesPerSample() == 1 {
+		return img, nil
+	}
+	// Отсчеты image.Gray16 хранятся в порядке big-endian.
+	if !f.BigEndian {
+		for i := 0; i < len(pix); i += 2 {
+			pix[i], pix[i+1] = pix[i+1], pix[i]
+		}
+	}
+	if f.BitDepth < 16 {
+		limit := uint16(1)<<f.BitDepth - 1
+		for i := 0; i < len(pix); i += 2 {
+			if v := uint16(pix[i])<<8 | uint16(pix[i+1]); v > limit {
+				p := i / 2
+				return nil, fmt.Errorf("sample %d at (%d, %d) exceeds the %d-bit range; check raw bit_depth and byte_order",
+					v, p%f.Width, p/f.Width, f.BitDepth)
+			}
+		}
+	}
+	return img, nil
+}
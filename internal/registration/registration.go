@@ -0,0 +1,205 @@
+// Package registration выполняет совмещение (регистрацию) кадров последовательности
+// относительно опорного кадра, компенсируя смещения объекта и камеры во времени.
+package registration
+
+import (
+	"encoding/csv"
+	"image"
+	"image/color"
+	"io"
+	"math"
+	"path/filepath"
+	"strconv"
+
+	"github.com/mascotmascot1/go-tlasca/internal/atomicfile"
+	"github.com/mascotmascot1/go-tlasca/internal/plot"
+	"github.com/mascotmascot1/go-tlasca/pkg/frame"
+	"github.com/mascotmascot1/go-tlasca/pkg/units"
+)
+
+// sampleStep задает шаг прореживания пикселей при оценке смещения.
+// Оценка по каждому второму пикселю в обоих направлениях заметно ускоряет поиск
+// и практически не влияет на точность для целочисленных смещений.
+const sampleStep = 2
+
+// Shift описывает оценку смещения одного кадра относительно опорного.
+type Shift struct {
+	// Frame - порядковый номер кадра в последовательности (с нуля).
+	Frame int
+	// DX, DY - смещение кадра относительно опорного в пикселях.
+	DX, DY int
+}
+
+// Magnitude возвращает модуль смещения в пикселях.
+func (s Shift) Magnitude() float64 {
+	return math.Hypot(float64(s.DX), float64(s.DY))
+}
+
+// Aligner совмещает кадры с опорным кадром (первым переданным кадром)
+// и накапливает оценки смещений всех обработанных кадров.
+// Aligner не безопасен для конкурентного использования: кадры должны
+// передаваться последовательно, в порядке времени.
+type Aligner struct {
+	maxShift  int
+	reference frame.Frame
+	shifts    []Shift
+}
+
+// NewAligner является конструктором для Aligner.
+// maxShift задает максимальное искомое смещение в пикселях по каждой оси.
+func NewAligner(maxShift int) *Aligner {
+	return &Aligner{maxShift: maxShift}
+}
+
+// Align оценивает смещение img относительно опорного кадра и возвращает
+// совмещенный кадр. Первый переданный кадр становится опорным и возвращается без изменений.
+func (a *Aligner) Align(img frame.Frame) frame.Frame {
+	index := len(a.shifts)
+	if a.reference == nil {
+		a.reference = img
+		a.shifts = append(a.shifts, Shift{Frame: index})
+		return img
+	}
+
+	dx, dy := EstimateShift(a.reference, img, a.maxShift)
+	a.shifts = append(a.shifts, Shift{Frame: index, DX: dx, DY: dy})
+	if dx == 0 && dy == 0 {
+		return img
+	}
+	return Translate(img, -dx, -dy)
+}
+
+// Fork возвращает новый Aligner с тем же опорным кадром и пустым списком смещений.
+// Используется для повторной загрузки части последовательности (например, отдельной эпохи)
+// с совмещением относительно того же опорного кадра.
+func (a *Aligner) Fork() *Aligner {
+	return &Aligner{maxShift: a.maxShift, reference: a.reference}
+}
+
+// Shifts возвращает оценки смещений всех обработанных кадров.
+func (a *Aligner) Shifts() []Shift {
+	return a.shifts
+}
+
+// EstimateShift находит целочисленное смещение (dx, dy) кадра img относительно ref,
+// при котором img(x+dx, y+dy) наилучшим образом совпадает с ref(x, y).
+//
+// Поиск выполняется полным перебором в диапазоне [-maxShift, maxShift] по каждой оси
+// с критерием минимума средней абсолютной разности (MAD) по центральной области кадра,
+// которая остается внутри изображения при любом допустимом смещении.
+func EstimateShift(ref, img frame.Frame, maxShift int) (dx, dy int) {
+	bounds := ref.Bounds()
+	imgBounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	maxShift = min(maxShift, w/4, h/4)
+	refBuf, imgBuf := frame.RowBuffer(ref), frame.RowBuffer(img)
+
+	best := math.Inf(1)
+	for sy := -maxShift; sy <= maxShift; sy++ {
+		for sx := -maxShift; sx <= maxShift; sx++ {
+			var sum float64
+			var count int
+			for y := maxShift; y < h-maxShift; y += sampleStep {
+				refRow := ref.Row(bounds.Min.Y+y, refBuf)
+				imgRow := img.Row(imgBounds.Min.Y+y+sy, imgBuf)
+				for x := maxShift; x < w-maxShift; x += sampleStep {
+					d := int(refRow[x]) - int(imgRow[x+sx])
+					if d < 0 {
+						d = -d
+					}
+					sum += float64(d)
+					count++
+				}
+			}
+			mad := sum / float64(count)
+			// При равенстве предпочитаем меньшее по модулю смещение.
+			if mad < best || (mad == best && sx*sx+sy*sy < dx*dx+dy*dy) {
+				best, dx, dy = mad, sx, sy
+			}
+		}
+	}
+	return dx, dy
+}
+
+// Translate сдвигает изображение на (dx, dy) пикселей: результат(x, y) = img(x-dx, y-dy).
+// Области, оказавшиеся за границей исходного изображения, заполняются ближайшими краевыми пикселями.
+func Translate(img frame.Frame, dx, dy int) *frame.Raw {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	out := frame.NewRaw(w, h)
+	buf := frame.RowBuffer(img)
+	for y := 0; y < h; y++ {
+		srcY := min(max(y-dy, 0), h-1)
+		srcRow := img.Row(bounds.Min.Y+srcY, buf)
+		dstRow := out.MutableRow(y)
+		for x := range dstRow {
+			dstRow[x] = srcRow[min(max(x-dx, 0), w-1)]
+		}
+	}
+	return out
+}
+
+// MaxDrift возвращает смещение с наибольшим модулем среди shifts.
+// Поскольку все смещения отсчитываются от опорного кадра, это максимальный накопленный дрейф.
+func MaxDrift(shifts []Shift) Shift {
+	var worst Shift
+	for _, s := range shifts {
+		if s.Magnitude() > worst.Magnitude() {
+			worst = s
+		}
+	}
+	return worst
+}
+
+// WriteCSV сохраняет оценки смещений в CSV-файл с колонками frame, file, dx_px, dy_px, drift_px.
+// files содержит имена файлов кадров в том же порядке, что и shifts.
+// Если times не nil, добавляется колонка time_s с временем регистрации кадра.
+func WriteCSV(path string, shifts []Shift, files []string, times []float64) error {
+	return atomicfile.Write(path, func(file io.Writer) error {
+		w := csv.NewWriter(file)
+		header := []string{"frame", "file", units.Column("dx", units.Pixel), units.Column("dy", units.Pixel), units.Column("drift", units.Pixel)}
+		if times != nil {
+			header = append(header, units.Column("time", units.Second))
+		}
+		if err := w.Write(header); err != nil {
+			return err
+		}
+		for _, s := range shifts {
+			var name string
+			if s.Frame < len(files) {
+				name = filepath.Base(files[s.Frame])
+			}
+			record := []string{
+				strconv.Itoa(s.Frame),
+				name,
+				strconv.Itoa(s.DX),
+				strconv.Itoa(s.DY),
+				strconv.FormatFloat(s.Magnitude(), 'f', 3, 64),
+			}
+			if times != nil && s.Frame < len(times) {
+				record = append(record, strconv.FormatFloat(times[s.Frame], 'f', -1, 64))
+			}
+			if err := w.Write(record); err != nil {
+				return err
+			}
+		}
+		w.Flush()
+		return w.Error()
+	})
+}
+
+// DriftPlot строит график смещений по кадрам: dx - красным, dy - синим, модуль дрейфа - черным.
+// Порог предупреждения о дрейфе отображается пунктирными линиями ±threshold.
+func DriftPlot(shifts []Shift, threshold float64) *image.RGBA {
+	dx := make([]float64, len(shifts))
+	dy := make([]float64, len(shifts))
+	drift := make([]float64, len(shifts))
+	for i, s := range shifts {
+		dx[i], dy[i], drift[i] = float64(s.DX), float64(s.DY), s.Magnitude()
+	}
+	return plot.LineChart(640, 320, []plot.Series{
+		{Values: dx, Color: color.RGBA{R: 220, A: 255}},
+		{Values: dy, Color: color.RGBA{B: 220, A: 255}},
+		{Values: drift, Color: color.RGBA{A: 255}},
+	}, []float64{threshold, -threshold})
+}
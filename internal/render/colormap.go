@@ -0,0 +1,145 @@
+package render
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"slices"
+	"strings"
+
+	"github.com/mascotmascot1/go-tlasca/internal/parallel"
+	"github.com/mascotmascot1/go-tlasca/pkg/tlasca"
+)
+
+// colorStop - опорная точка цветовой карты: цвет (r, g, b) в [0, 255] на уровне at.
+type colorStop struct {
+	at      float64
+	r, g, b float64
+}
+
+// uniformStops возвращает опорные точки цветов colors, равномерно распределенные по [0, 1].
+func uniformStops(colors ...uint32) []colorStop {
+	stops := make([]colorStop, len(colors))
+	for i, c := range colors {
+		stops[i] = colorStop{float64(i) / float64(len(colors)-1), float64(c >> 16), float64(c >> 8 & 0xff), float64(c & 0xff)}
+	}
+	return stops
+}
+
+// colormaps - опорные точки поддерживаемых цветовых карт. viridis и inferno - отсчеты
+// одноименных перцептивно равномерных карт matplotlib через 0.1, jet - классическая
+// радужная карта MATLAB.
+var colormaps = map[string][]colorStop{
+	"viridis": uniformStops(0x440154, 0x482475, 0x414487, 0x355f8d, 0x2a788e, 0x21918c,
+		0x22a884, 0x44bf70, 0x7ad151, 0xbddf26, 0xfde725),
+	"inferno": uniformStops(0x000004, 0x160b39, 0x420a68, 0x6a176e, 0x932667, 0xbc3754,
+		0xdd513a, 0xf37819, 0xfca50a, 0xf6d746, 0xfcffa4),
+	"jet": {
+		{0, 0, 0, 127.5}, {0.125, 0, 0, 255}, {0.375, 0, 255, 255},
+		{0.625, 255, 255, 0}, {0.875, 255, 0, 0}, {1, 127.5, 0, 0},
+	},
+	"grayscale": uniformStops(0x000000, 0xffffff),
+}
+
+// Colormap - цветовая карта: отображение уровня шкалы [0, 1] в цвет. Цвет
+// интерполируется между опорными точками непрерывно, поэтому псевдоцветное изображение
+// строится по исходным значениям карты, а не по ее 8-битному изображению.
+type Colormap struct {
+	name  string
+	stops []colorStop
+}
+
+// ColormapNames возвращает имена поддерживаемых цветовых карт.
+func ColormapNames() []string {
+	names := make([]string, 0, len(colormaps))
+	for name := range colormaps {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// NewColormap возвращает цветовую карту name.
+func NewColormap(name string) (*Colormap, error) {
+	stops, ok := colormaps[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown colormap '%s', expected %s", name, strings.Join(ColormapNames(), ", "))
+	}
+	return &Colormap{name: name, stops: stops}, nil
+}
+
+// String возвращает имя цветовой карты.
+func (c *Colormap) String() string {
+	return c.name
+}
+
+// At возвращает цвет уровня level; уровни вне [0, 1] ограничиваются, нечисловые
+// отображаются в цвет уровня 0.
+func (c *Colormap) At(level float64) color.RGBA {
+	if !(level > 0) {
+		level = 0
+	}
+	level = math.Min(level, 1)
+	i := 1
+	for i < len(c.stops)-1 && c.stops[i].at < level {
+		i++
+	}
+	lo, hi := c.stops[i-1], c.stops[i]
+	t := (level - lo.at) / (hi.at - lo.at)
+	mix := func(a, b float64) uint8 { return uint8(math.Round(a + t*(b-a))) }
+	return color.RGBA{mix(lo.r, hi.r), mix(lo.g, hi.g), mix(lo.b, hi.b), 255}
+}
+
+// Colorize преобразует карту контраста в псевдоцветное изображение по шкале scale
+// и цветовой карте cmap. Исключенные положения окна выводятся цветом уровня background
+// (см. FillBackground). Строки обрабатываются параллельно.
+func Colorize(res *tlasca.Result, scale Scale, cmap *Colormap, background float64) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, res.Width, res.Height))
+	excluded := cmap.At(background)
+	parallel.Rows(res.Height, func(startY, endY int) {
+		for y := startY; y < endY; y++ {
+			for x := 0; x < res.Width; x++ {
+				c := excluded
+				if !res.IsExcluded(x, y) {
+					c = cmap.At(scale.Level(res.Contrast[y*res.Width+x]))
+				}
+				img.SetRGBA(x, y, c)
+			}
+		}
+	})
+	return img
+}
+
+// Overlay накладывает псевдоцветную карту контраста (шкала scale, цветовая карта cmap)
+// на временное среднее интенсивности res.Mean с непрозрачностью alpha в [0, 1], чтобы
+// под картой кровотока была видна анатомия. Изображение имеет геометрию карты: пикселю
+// (x, y) соответствует среднее в центре окна, то есть в пикселе кадра (x, y), смещенном
+// на (WindowSize-1)/2. Среднее отображается в оттенках серого по процентилям 0.5-99.5%;
+// на исключенных положениях окна карта не накладывается. Строки обрабатываются параллельно.
+func Overlay(res *tlasca.Result, scale Scale, cmap *Colormap, alpha float64) *image.RGBA {
+	meanRange := Range{Min: Percentile(res.Mean, nil, 0.5), Max: Percentile(res.Mean, nil, 99.5)}
+	if meanRange.Max <= meanRange.Min {
+		meanRange.Max = meanRange.Min + 1
+	}
+	// Размер окна восстанавливается по размерам кадра и карты.
+	offset := (res.FrameWidth - res.Width) / 2
+	img := image.NewRGBA(image.Rect(0, 0, res.Width, res.Height))
+	parallel.Rows(res.Height, func(startY, endY int) {
+		for y := startY; y < endY; y++ {
+			for x := 0; x < res.Width; x++ {
+				level := math.Min(math.Max(meanRange.Level(res.Mean[(y+offset)*res.FrameWidth+x+offset]), 0), 1)
+				gray := 255 * level
+				if res.IsExcluded(x, y) {
+					g := uint8(math.Round(gray))
+					img.SetRGBA(x, y, color.RGBA{g, g, g, 255})
+					continue
+				}
+				c := cmap.At(scale.Level(res.Contrast[y*res.Width+x]))
+				mix := func(v uint8) uint8 { return uint8(math.Round(gray + alpha*(float64(v)-gray))) }
+				img.SetRGBA(x, y, color.RGBA{mix(c.R), mix(c.G), mix(c.B), 255})
+			}
+		}
+	})
+	return img
+}
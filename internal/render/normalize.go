@@ -0,0 +1,229 @@
+package render
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/mascotmascot1/go-tlasca/internal/config"
+	"github.com/mascotmascot1/go-tlasca/pkg/mask"
+)
+
+// Scale отображает значения карты в уровни яркости: уровень 0 соответствует черному,
+// 1 - белому. Значения вне шкалы дают уровни вне [0, 1] и при отображении ограничиваются.
+type Scale interface {
+	// Level возвращает уровень яркости значения v (без ограничения диапазоном [0, 1]).
+	Level(v float64) float64
+	// Bounds возвращает значения, отображаемые в уровни 0 и 1.
+	Bounds() Range
+}
+
+// Normalizer выбирает шкалу отображения по значениям карты. Один и тот же Normalizer
+// используется всеми путями отображения (карта, маски, иллюстрации), поэтому
+// все выходные изображения масштабируются согласованно.
+type Normalizer interface {
+	// Fit строит шкалу для значений values, пропуская отмеченные в skip (может быть nil).
+	Fit(values []float64, skip *mask.Mask) Scale
+	// String возвращает краткое описание способа нормировки для логов и подписей.
+	String() string
+}
+
+// Level реализует Scale: линейное отображение [Min, Max] в [0, 1].
+func (r Range) Level(v float64) float64 {
+	return (v - r.Min) / (r.Max - r.Min)
+}
+
+// Bounds реализует Scale.
+func (r Range) Bounds() Range {
+	return r
+}
+
+// FixedRange - нормировка фиксированным диапазоном [Min, Max], не зависящим от данных.
+type FixedRange struct {
+	Min, Max float64
+}
+
+// Fit реализует Normalizer.
+func (f FixedRange) Fit([]float64, *mask.Mask) Scale {
+	return Range{Min: f.Min, Max: f.Max}
+}
+
+func (f FixedRange) String() string {
+	return fmt.Sprintf("fixed [%g, %g]", f.Min, f.Max)
+}
+
+// MinMax - нормировка по минимальному и максимальному значениям карты.
+type MinMax struct{}
+
+// Fit реализует Normalizer.
+func (MinMax) Fit(values []float64, skip *mask.Mask) Scale {
+	return nonEmpty(Range{Min: Percentile(values, skip, 0), Max: Percentile(values, skip, 100)})
+}
+
+func (MinMax) String() string {
+	return "min-max"
+}
+
+// PercentileRange - нормировка по процентилям значений карты [Low, High] (в процентах),
+// устойчивая к единичным выбросам.
+type PercentileRange struct {
+	Low, High float64
+}
+
+// Fit реализует Normalizer.
+func (p PercentileRange) Fit(values []float64, skip *mask.Mask) Scale {
+	return nonEmpty(Range{Min: Percentile(values, skip, p.Low), Max: Percentile(values, skip, p.High)})
+}
+
+func (p PercentileRange) String() string {
+	return fmt.Sprintf("percentile [%g, %g]", p.Low, p.High)
+}
+
+// ZScore - нормировка диапазоном [μ - Z·σ, μ + Z·σ] по среднему и стандартному
+// отклонению значений карты.
+type ZScore struct {
+	Z float64
+}
+
+// Fit реализует Normalizer.
+func (z ZScore) Fit(values []float64, skip *mask.Mask) Scale {
+	var sum, sumSq float64
+	var n int
+	for i, v := range values {
+		if (skip != nil && skip.Set[i]) || math.IsNaN(v) || math.IsInf(v, 0) {
+			continue
+		}
+		sum += v
+		sumSq += v * v
+		n++
+	}
+	if n == 0 {
+		return nonEmpty(Range{})
+	}
+	mean := sum / float64(n)
+	std := math.Sqrt(max(sumSq/float64(n)-mean*mean, 0))
+	return nonEmpty(Range{Min: mean - z.Z*std, Max: mean + z.Z*std})
+}
+
+func (z ZScore) String() string {
+	return fmt.Sprintf("z-score ±%g", z.Z)
+}
+
+// CurvePoint - узел калибровочной кривой: значение карты и соответствующий уровень яркости.
+type CurvePoint struct {
+	Value, Level float64
+}
+
+// CalibrationCurve - нелинейная нормировка кусочно-линейной калибровочной кривой
+// (например, перевод контраста в относительную скорость по калибровке на фантоме).
+// Кривая не зависит от данных и сама является шкалой; за пределами узлов
+// продолжается крайними отрезками.
+type CalibrationCurve struct {
+	points []CurvePoint
+}
+
+// NewCalibrationCurve создает калибровочную кривую по узлам. Требуется не менее двух узлов
+// со строго возрастающими значениями и монотонными (неубывающими или невозрастающими) уровнями.
+func NewCalibrationCurve(points []CurvePoint) (*CalibrationCurve, error) {
+	if len(points) < 2 {
+		return nil, fmt.Errorf("calibration curve needs at least 2 points, got %d", len(points))
+	}
+	sorted := append([]CurvePoint(nil), points...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Value < sorted[j].Value })
+	rising := sorted[len(sorted)-1].Level >= sorted[0].Level
+	for i := 1; i < len(sorted); i++ {
+		if sorted[i].Value == sorted[i-1].Value {
+			return nil, fmt.Errorf("calibration curve has duplicate value %g", sorted[i].Value)
+		}
+		if (sorted[i].Level >= sorted[i-1].Level) != rising && sorted[i].Level != sorted[i-1].Level {
+			return nil, fmt.Errorf("calibration curve levels must be monotonic")
+		}
+	}
+	if sorted[0].Level == sorted[len(sorted)-1].Level {
+		return nil, fmt.Errorf("calibration curve levels must not be constant")
+	}
+	return &CalibrationCurve{points: sorted}, nil
+}
+
+// Fit реализует Normalizer: кривая не зависит от значений карты.
+func (c *CalibrationCurve) Fit([]float64, *mask.Mask) Scale {
+	return c
+}
+
+func (c *CalibrationCurve) String() string {
+	return fmt.Sprintf("calibration curve (%d points)", len(c.points))
+}
+
+// Level реализует Scale: кусочно-линейная интерполяция между узлами кривой.
+func (c *CalibrationCurve) Level(v float64) float64 {
+	i := sort.Search(len(c.points)-1, func(i int) bool { return c.points[i+1].Value >= v })
+	i = min(i, len(c.points)-2)
+	a, b := c.points[i], c.points[i+1]
+	return a.Level + (v-a.Value)*(b.Level-a.Level)/(b.Value-a.Value)
+}
+
+// Bounds реализует Scale: значения кривой, соответствующие уровням 0 и 1
+// (обратная интерполяция по узлам).
+func (c *CalibrationCurve) Bounds() Range {
+	return Range{Min: c.valueAt(0), Max: c.valueAt(1)}
+}
+
+// valueAt находит значение карты, которому соответствует уровень level.
+func (c *CalibrationCurve) valueAt(level float64) float64 {
+	p := c.points
+	for i := 0; i+1 < len(p); i++ {
+		a, b := p[i], p[i+1]
+		if a.Level == b.Level {
+			continue
+		}
+		t := (level - a.Level) / (b.Level - a.Level)
+		if (t >= 0 && t <= 1) || (i == 0 && t < 0) || (i == len(p)-2 && t > 1) {
+			return a.Value + t*(b.Value-a.Value)
+		}
+	}
+	return p[0].Value
+}
+
+// NewNormalizer создает Normalizer по параметрам вывода конфигурации:
+// fixed (диапазон contrast_min..contrast_max), minmax, percentile, zscore или calibration.
+func NewNormalizer(cfg config.OutputConfig) (Normalizer, error) {
+	switch cfg.Normalization {
+	case "", "fixed":
+		if cfg.ContrastMax <= cfg.ContrastMin {
+			return nil, fmt.Errorf("output contrast range [%g, %g] is empty", cfg.ContrastMin, cfg.ContrastMax)
+		}
+		return FixedRange{Min: cfg.ContrastMin, Max: cfg.ContrastMax}, nil
+	case "minmax":
+		return MinMax{}, nil
+	case "percentile":
+		if cfg.PercentileLow < 0 || cfg.PercentileHigh > 100 || cfg.PercentileHigh <= cfg.PercentileLow {
+			return nil, fmt.Errorf("percentile range [%g, %g] must be increasing within [0, 100]", cfg.PercentileLow, cfg.PercentileHigh)
+		}
+		return PercentileRange{Low: cfg.PercentileLow, High: cfg.PercentileHigh}, nil
+	case "zscore":
+		if cfg.ZScore <= 0 {
+			return nil, fmt.Errorf("zscore must be positive, got %g", cfg.ZScore)
+		}
+		return ZScore{Z: cfg.ZScore}, nil
+	case "calibration":
+		points := make([]CurvePoint, len(cfg.CalibrationCurve))
+		for i, p := range cfg.CalibrationCurve {
+			if len(p) != 2 {
+				return nil, fmt.Errorf("calibration curve point %d must be [value, level], got %v", i+1, p)
+			}
+			points[i] = CurvePoint{Value: p[0], Level: p[1]}
+		}
+		return NewCalibrationCurve(points)
+	default:
+		return nil, fmt.Errorf("unknown normalization '%s', expected fixed, minmax, percentile, zscore or calibration", cfg.Normalization)
+	}
+}
+
+// nonEmpty расширяет вырожденный диапазон (например, для постоянной карты) до единичного,
+// чтобы отображение оставалось определенным.
+func nonEmpty(r Range) Range {
+	if !(r.Max > r.Min) {
+		r.Max = r.Min + 1
+	}
+	return r
+}
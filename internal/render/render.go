@@ -0,0 +1,229 @@
+// Package render преобразует результаты расчета (карты в исходных значениях)
+// в изображения для сохранения и анализирует потери динамического диапазона при этом.
+package render
+
+import (
+	"image"
+	"math"
+	"sort"
+	"sync"
+
+	"github.com/mascotmascot1/go-tlasca/internal/parallel"
+	"github.com/mascotmascot1/go-tlasca/pkg/mask"
+	"github.com/mascotmascot1/go-tlasca/pkg/tlasca"
+)
+
+// Значения пикселей маски выхода за диапазон (см. ClippingMask).
+const (
+	maskInRange = 0
+	maskLow     = 128
+	maskHigh    = 255
+)
+
+// Range задает диапазон значений [Min, Max], который отображается в полную шкалу яркости [0, 255].
+// Значения вне диапазона ограничиваются (clipping) его границами.
+type Range struct {
+	Min, Max float64
+}
+
+// Clipping содержит статистику значений карты, вышедших за диапазон отображения.
+type Clipping struct {
+	// Pixels - число учтенных (не исключенных) пикселей карты.
+	Pixels int `json:"pixels"`
+	// Low, High - число пикселей со значениями ниже Min и выше Max соответственно.
+	Low  int `json:"low"`
+	High int `json:"high"`
+	// Bounds - ограничивающий прямоугольник всех вышедших за диапазон пикселей
+	// (пустой, если таких пикселей нет).
+	Bounds image.Rectangle `json:"bounds"`
+}
+
+// Fraction возвращает долю пикселей, вышедших за диапазон.
+func (c Clipping) Fraction() float64 {
+	if c.Pixels == 0 {
+		return 0
+	}
+	return float64(c.Low+c.High) / float64(c.Pixels)
+}
+
+// Gray преобразует карту контраста в изображение в градациях серого по шкале scale:
+// уровень 0 отображается в 0, уровень 1 - в 255, значения вне шкалы ограничиваются.
+// Исключенные положения окна выводятся со значением 0.
+func Gray(res *tlasca.Result, scale Scale) *image.Gray {
+	return GrayPlane(res.Contrast, res.Width, res.Height, res.Excluded, scale)
+}
+
+// Gray16 преобразует карту контраста в 16-битное изображение в градациях серого по шкале scale
+// аналогично Gray: уровень 0 отображается в 0, уровень 1 - в 65535.
+// Строки обрабатываются параллельно.
+func Gray16(res *tlasca.Result, scale Scale) *image.Gray16 {
+	img := image.NewGray16(image.Rect(0, 0, res.Width, res.Height))
+	parallel.Rows(res.Height, func(startY, endY int) {
+		for y := startY; y < endY; y++ {
+			for x := 0; x < res.Width; x++ {
+				if res.IsExcluded(x, y) {
+					continue
+				}
+				level := scale.Level(res.Contrast[y*res.Width+x])
+				if !(level > 0) {
+					continue
+				}
+				v := uint16(math.Round(math.Min(level, 1) * math.MaxUint16))
+				i := img.PixOffset(x, y)
+				img.Pix[i], img.Pix[i+1] = byte(v>>8), byte(v)
+			}
+		}
+	})
+	return img
+}
+
+// GrayPlane преобразует произвольную плоскость значений (построчно, ширина width)
+// в изображение в градациях серого аналогично Gray. Пиксели, отмеченные в skip
+// (может быть nil), выводятся со значением 0. Строки обрабатываются параллельно.
+func GrayPlane(values []float64, width, height int, skip *mask.Mask, scale Scale) *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	parallel.Rows(height, func(startY, endY int) {
+		for y := startY; y < endY; y++ {
+			row := img.Pix[y*img.Stride:]
+			for x := 0; x < width; x++ {
+				if skip != nil && skip.Set[y*width+x] {
+					continue
+				}
+				level := scale.Level(values[y*width+x])
+				if !(level > 0) {
+					continue
+				}
+				// Масштабируем уровень (float64) в яркость пикселя (byte [0-255]).
+				// math.Min используется для ограничения сверху значением 255.
+				row[x] = byte(math.Min(level*255, 255))
+			}
+		}
+	})
+	return img
+}
+
+// FillBackground выводит положения карты, отмеченные в skip (может быть nil), уровнем
+// шкалы level из [0, 1] в изображении img размера маски вместо значения 0, которое
+// им назначают Gray и Gray16. Возвращает img.
+func FillBackground[I *image.Gray | *image.Gray16](img I, skip *mask.Mask, level float64) I {
+	if skip == nil || !(level > 0) {
+		return img
+	}
+	level = math.Min(level, 1)
+	for i, set := range skip.Set {
+		if !set {
+			continue
+		}
+		x, y := i%skip.Width, i/skip.Width
+		switch img := any(img).(type) {
+		case *image.Gray:
+			img.Pix[img.PixOffset(x, y)] = byte(math.Round(level * 255))
+		case *image.Gray16:
+			v := uint16(math.Round(level * math.MaxUint16))
+			j := img.PixOffset(x, y)
+			img.Pix[j], img.Pix[j+1] = byte(v>>8), byte(v)
+		}
+	}
+	return img
+}
+
+// Gray16Plane преобразует плоскость значений в 16-битное изображение в градациях серого:
+// значение Min отображается в 0, Max - в 65535, значения вне диапазона ограничиваются,
+// нечисловые (NaN) отображаются в 0. Используется для количественных выходных данных, где 8 бит недостаточно.
+// Строки обрабатываются параллельно.
+func Gray16Plane(values []float64, width, height int, rng Range) *image.Gray16 {
+	img := image.NewGray16(image.Rect(0, 0, width, height))
+	scale := math.MaxUint16 / (rng.Max - rng.Min)
+	parallel.Rows(height, func(startY, endY int) {
+		for y := startY; y < endY; y++ {
+			for x := 0; x < width; x++ {
+				v := values[y*width+x]
+				if v <= rng.Min || math.IsNaN(v) {
+					continue
+				}
+				level := uint16(math.Round(math.Min((v-rng.Min)*scale, math.MaxUint16)))
+				i := img.PixOffset(x, y)
+				img.Pix[i], img.Pix[i+1] = byte(level>>8), byte(level)
+			}
+		}
+	})
+	return img
+}
+
+// Percentile возвращает p-й процентиль (p в [0, 100]) значений values,
+// пропуская отмеченные в skip (может быть nil) и нечисловые значения.
+// Для пустого набора возвращает 0.
+func Percentile(values []float64, skip *mask.Mask, p float64) float64 {
+	sorted := make([]float64, 0, len(values))
+	for i, v := range values {
+		if (skip != nil && skip.Set[i]) || math.IsNaN(v) || math.IsInf(v, 0) {
+			continue
+		}
+		sorted = append(sorted, v)
+	}
+	if len(sorted) == 0 {
+		return 0
+	}
+	sort.Float64s(sorted)
+	return sorted[int(math.Round(p/100*float64(len(sorted)-1)))]
+}
+
+// AnalyzeClipping подсчитывает, сколько пикселей карты выходит за шкалу scale
+// и где они расположены. Исключенные положения окна не учитываются.
+// Полосы строк анализируются параллельно, их статистики затем объединяются.
+func AnalyzeClipping(res *tlasca.Result, scale Scale) Clipping {
+	var total Clipping
+	var mu sync.Mutex
+	parallel.Rows(res.Height, func(startY, endY int) {
+		var c Clipping
+		for y := startY; y < endY; y++ {
+			for x := 0; x < res.Width; x++ {
+				if res.IsExcluded(x, y) {
+					continue
+				}
+				c.Pixels++
+				switch level := scale.Level(res.Contrast[y*res.Width+x]); {
+				case level < 0:
+					c.Low++
+				case level > 1:
+					c.High++
+				default:
+					continue
+				}
+				c.Bounds = c.Bounds.Union(image.Rect(x, y, x+1, y+1))
+			}
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		total.Pixels += c.Pixels
+		total.Low += c.Low
+		total.High += c.High
+		total.Bounds = total.Bounds.Union(c.Bounds)
+	})
+	return total
+}
+
+// ClippingMask строит маску выхода за шкалу: 255 - значение выше верхней границы,
+// 128 - ниже нижней, 0 - в пределах шкалы или исключено из расчета.
+// Строки обрабатываются параллельно.
+func ClippingMask(res *tlasca.Result, scale Scale) *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, res.Width, res.Height))
+	parallel.Rows(res.Height, func(startY, endY int) {
+		for y := startY; y < endY; y++ {
+			for x := 0; x < res.Width; x++ {
+				if res.IsExcluded(x, y) {
+					continue
+				}
+				switch level := scale.Level(res.Contrast[y*res.Width+x]); {
+				case level < 0:
+					img.Pix[y*img.Stride+x] = maskLow
+				case level > 1:
+					img.Pix[y*img.Stride+x] = maskHigh
+				default:
+					img.Pix[y*img.Stride+x] = maskInRange
+				}
+			}
+		}
+	})
+	return img
+}
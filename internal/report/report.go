@@ -0,0 +1,226 @@
+// Package report формирует итоговый отчет о запуске (run report) и сохраняет его в формате JSON.
+// Отчет фиксирует параметры запуска, входные данные, выходные файлы и телеметрию этапов,
+// что позволяет воспроизвести и проанализировать результат позже. Отчет можно также
+// заполнить в пользовательский шаблон (см. Template).
+package report
+
+import (
+	"encoding/json"
+	"math"
+	"time"
+
+	"github.com/mascotmascot1/go-tlasca/internal/atomicfile"
+	"github.com/mascotmascot1/go-tlasca/internal/biospeckle"
+	"github.com/mascotmascot1/go-tlasca/internal/calibration"
+	"github.com/mascotmascot1/go-tlasca/internal/camera"
+	"github.com/mascotmascot1/go-tlasca/internal/config"
+	"github.com/mascotmascot1/go-tlasca/internal/crosscorr"
+	"github.com/mascotmascot1/go-tlasca/internal/decimate"
+	"github.com/mascotmascot1/go-tlasca/internal/diagnostics"
+	"github.com/mascotmascot1/go-tlasca/internal/exposure"
+	"github.com/mascotmascot1/go-tlasca/internal/framecorr"
+	"github.com/mascotmascot1/go-tlasca/internal/render"
+	"github.com/mascotmascot1/go-tlasca/internal/segment"
+	"github.com/mascotmascot1/go-tlasca/internal/speckle"
+	"github.com/mascotmascot1/go-tlasca/internal/telemetry"
+	"github.com/mascotmascot1/go-tlasca/internal/timestamps"
+	"github.com/mascotmascot1/go-tlasca/internal/vasomotion"
+	"github.com/mascotmascot1/go-tlasca/pkg/mask"
+	"github.com/mascotmascot1/go-tlasca/pkg/units"
+)
+
+// Report описывает один запуск программы.
+type Report struct {
+	// StartedAt - время начала запуска.
+	StartedAt time.Time `json:"started_at"`
+	// Duration - полная длительность запуска.
+	Duration time.Duration `json:"duration_ns"`
+	// Config - конфигурация, с которой выполнялся запуск.
+	Config *config.Config `json:"config"`
+	// Frames - число обработанных кадров.
+	Frames int `json:"frames"`
+	// Kernel - реализация ядер накопления временных статистик (см. algorithm.kernel).
+	Kernel string `json:"kernel,omitempty"`
+	// BitDepth - разрядность входных данных, к полной шкале которой нормированы интенсивности.
+	BitDepth int `json:"bit_depth"`
+	// QuickLook - подмножество кадров, выбранное для быстрого анализа (если выбор включен).
+	QuickLook *decimate.Selection `json:"quick_look,omitempty"`
+	// Timing - сводка межкадровых интервалов, если заданы временные метки кадров.
+	Timing *timestamps.Summary `json:"timing,omitempty"`
+	// Exposure - сводка экспозиций кадров, если выполнялась нормировка по экспозиции.
+	Exposure *exposure.Summary `json:"exposure,omitempty"`
+	// Adjustments - автоматические корректировки плана обработки, внесенные проверкой ресурсов.
+	Adjustments []string `json:"adjustments,omitempty"`
+	// DisplayRange - значения контраста [min, max], отображенные в черный и белый уровни
+	// итоговой карты (только для линейной шкалы отображения): уровень v из maxLevel
+	// соответствует min + v/maxLevel·(max - min).
+	DisplayRange []float64 `json:"display_range,omitempty"`
+	// Clipping - статистика значений карты, вышедших за диапазон отображения.
+	Clipping *render.Clipping `json:"clipping,omitempty"`
+	// Contrast - сводные статистики итоговой карты контраста (без квантования).
+	Contrast *MapStats `json:"contrast,omitempty"`
+	// Perfusion - статистики индекса кровотока итоговой карты при algorithm.output = "perfusion"
+	// (DisplayRange и Clipping в этом случае относятся к индексу кровотока).
+	Perfusion *MapStats `json:"perfusion,omitempty"`
+	// Convergence - сходимость контраста опорной области по числу кадров (если расчет включен).
+	Convergence *diagnostics.ConvergenceSummary `json:"convergence,omitempty"`
+	// Calibration - модель дисторсии и точность калибровки (если задано изображение мишени).
+	Calibration *calibration.Summary `json:"calibration,omitempty"`
+	// Camera - сводка профиля шума камеры (если задан профиль).
+	Camera *camera.Summary `json:"camera,omitempty"`
+	// Focus - сводка контроля фокусировки среднего кадра (если контроль включен).
+	Focus *diagnostics.FocusSummary `json:"focus,omitempty"`
+	// Segmentation - порог и статистики классов сосуды/ткань (если разделение включено).
+	Segmentation *segment.Summary `json:"segmentation,omitempty"`
+	// ContrastLimits - теоретические границы контраста и число положений карты вне их
+	// (если проверка включена).
+	ContrastLimits *speckle.Summary `json:"contrast_limits,omitempty"`
+	// StaticScattering - сводка разделения статического и динамического рассеяния
+	// (если оценка включена).
+	StaticScattering *speckle.SeparationSummary `json:"static_scattering,omitempty"`
+	// Trend - сводка карты наклона линейного тренда во времени (если расчет включен).
+	Trend *Trend `json:"trend,omitempty"`
+	// Biospeckle - индексы активности биоспеклов (если расчет включен).
+	Biospeckle *biospeckle.Summary `json:"biospeckle,omitempty"`
+	// DerivedMaps - производные карты, вычисленные выражениями (derived_maps).
+	DerivedMaps []DerivedMap `json:"derived_maps,omitempty"`
+	// Units - единицы карт запуска по их именам в выражениях производных карт
+	// ("k", "mean", "std", ...), включая сами производные карты.
+	Units map[string]units.Unit `json:"units,omitempty"`
+	// FrameCorrelation - сводка корреляции соседних кадров (если расчет включен).
+	FrameCorrelation *framecorr.Summary `json:"frame_correlation,omitempty"`
+	// Correlations - пики взаимной корреляции временных рядов областей интереса.
+	Correlations []crosscorr.Pair `json:"correlations,omitempty"`
+	// Vasomotion - пики спектров индекса кровотока областей интереса в полосе вазомоций.
+	Vasomotion []vasomotion.Peak `json:"vasomotion,omitempty"`
+	// Skipped - кадры, пропущенные из-за ошибок чтения (политика unreadable_frames = tolerant).
+	Skipped []SkippedFrame `json:"skipped_frames,omitempty"`
+	// Warnings - предупреждения контроля качества, выявленные в ходе запуска.
+	Warnings []string `json:"warnings,omitempty"`
+	// Outputs - пути к сохраненным выходным файлам.
+	Outputs []string `json:"outputs"`
+	// Stages - телеметрия этапов конвейера.
+	Stages []telemetry.StageStats `json:"stages"`
+}
+
+// DerivedMap описывает сохраненную производную карту.
+type DerivedMap struct {
+	// Name и Expression - имя и выражение карты.
+	Name       string `json:"name"`
+	Expression string `json:"expression"`
+	// Unit - единица значений карты.
+	Unit units.Unit `json:"unit"`
+	// File - путь к 16-битному PNG-файлу карты.
+	File string `json:"file"`
+	// Range - значения, отображенные в 0 и 65535: значение пикселя v соответствует
+	// Range[0] + v/65535·(Range[1] - Range[0]).
+	Range [2]float64 `json:"range"`
+	// Mean - среднее значение карты (без исключенных и нечисловых положений).
+	Mean float64 `json:"mean"`
+	// Invalid - число положений с нечисловым значением (сохранены как 0).
+	Invalid int `json:"invalid,omitempty"`
+}
+
+// Trend описывает карту наклона линейного тренда во времени.
+type Trend struct {
+	// Signal - величина, тренд которой рассчитан ("intensity" или "contrast").
+	Signal string `json:"signal"`
+	// SegmentFrames - число кадров сегмента для тренда контраста.
+	SegmentFrames int `json:"segment_frames,omitempty"`
+	// Range - значения, отображенные в 0 и 65535 PNG-файла карты.
+	Range [2]float64 `json:"range"`
+	// Slope - статистики наклона (единица - единица величины за секунду или за кадр).
+	Slope *MapStats `json:"slope"`
+}
+
+// MapStats - сводные статистики значений карты по учитываемым положениям
+// (без исключенных из расчета и нечисловых).
+type MapStats struct {
+	// Positions - число учтенных положений карты.
+	Positions int `json:"positions"`
+	// Mean и StdDev - среднее и стандартное отклонение значений.
+	Mean   float64 `json:"mean"`
+	StdDev float64 `json:"std_dev"`
+	// Min, Median и Max - наименьшее, медианное и наибольшее значения.
+	Min    float64 `json:"min"`
+	Median float64 `json:"median"`
+	Max    float64 `json:"max"`
+	// Unit - единица значений карты.
+	Unit units.Unit `json:"unit"`
+}
+
+// NewMapStats рассчитывает статистики значений values в единицах unit, пропуская
+// положения, отмеченные в excluded (может быть nil), и нечисловые значения.
+func NewMapStats(values []float64, excluded *mask.Mask, unit units.Unit) *MapStats {
+	s := &MapStats{Unit: unit, Min: math.Inf(1), Max: math.Inf(-1)}
+	var sum, sumSq float64
+	for i, v := range values {
+		if (excluded != nil && excluded.Set[i]) || math.IsNaN(v) || math.IsInf(v, 0) {
+			continue
+		}
+		s.Positions++
+		sum += v
+		sumSq += v * v
+		s.Min, s.Max = min(s.Min, v), max(s.Max, v)
+	}
+	if s.Positions == 0 {
+		return &MapStats{Unit: unit}
+	}
+	n := float64(s.Positions)
+	s.Mean = sum / n
+	if s.Positions > 1 {
+		s.StdDev = math.Sqrt(max((sumSq-n*s.Mean*s.Mean)/(n-1), 0))
+	}
+	s.Median = render.Percentile(values, excluded, 50)
+	return s
+}
+
+// SkippedFrame описывает кадр, пропущенный из-за ошибки чтения.
+type SkippedFrame struct {
+	// Index - номер кадра в исходной последовательности (с 1).
+	Index int `json:"index"`
+	// File - путь к файлу кадра.
+	File string `json:"file"`
+	// Error - текст ошибки чтения.
+	Error string `json:"error"`
+}
+
+// Save сохраняет отчет в файл path в формате JSON с отступами.
+func (rep *Report) Save(path string) error {
+	data, err := json.MarshalIndent(rep, "", "    ")
+	if err != nil {
+		return err
+	}
+	return atomicfile.WriteFile(path, data)
+}
+
+// EffectiveConfig - итоговая конфигурация запуска и источник каждого ее параметра.
+// Секция Config пригодна для использования в качестве go-tlasca.json при повторном запуске.
+type EffectiveConfig struct {
+	// Config - итоговая конфигурация (значения по умолчанию, пресета и файла).
+	Config *config.Config `json:"config"`
+	// Sources - источник значения каждого параметра (ключи в виде "algorithm.window_size").
+	Sources map[string]config.Source `json:"sources"`
+}
+
+// NewEffectiveConfig формирует описание итоговой конфигурации cfg.
+func NewEffectiveConfig(cfg *config.Config) (*EffectiveConfig, error) {
+	settings, err := cfg.Effective()
+	if err != nil {
+		return nil, err
+	}
+	effective := &EffectiveConfig{Config: cfg, Sources: make(map[string]config.Source, len(settings))}
+	for _, s := range settings {
+		effective.Sources[s.Key] = s.Source
+	}
+	return effective, nil
+}
+
+// Save сохраняет итоговую конфигурацию в файл path в формате JSON с отступами.
+func (e *EffectiveConfig) Save(path string) error {
+	data, err := json.MarshalIndent(e, "", "    ")
+	if err != nil {
+		return err
+	}
+	return atomicfile.WriteFile(path, data)
+}
@@ -0,0 +1,97 @@
+package report
+
+import (
+	"encoding/json"
+	htmltemplate "html/template"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/mascotmascot1/go-tlasca/internal/atomicfile"
+)
+
+// imageExtensions - расширения выходных файлов, попадающих в TemplateData.Images.
+var imageExtensions = []string{".png", ".jpg", ".jpeg", ".svg", ".tif", ".tiff"}
+
+// Template - пользовательский шаблон отчета о запуске: шаблон html/template для
+// HTML-отчетов (с экранированием подставляемых значений) или text/template для
+// остальных форматов (Markdown, текст, LaTeX), заполняемый данными TemplateData.
+type Template struct {
+	execute func(w io.Writer, data any) error
+}
+
+// TemplateData - данные, доступные шаблону отчета: поля Report (например, .Frames,
+// .Config.Algorithm.WindowSize, .Clipping) и пути к изображениям.
+type TemplateData struct {
+	*Report
+	// Images - сохраненные изображения (PNG, JPEG, SVG, TIFF) с путями относительно
+	// директории отчета, пригодными для ссылок из отчета.
+	Images []string
+	// dir - директория сохраняемого отчета.
+	dir string
+}
+
+// Rel возвращает путь path относительно директории отчета с разделителями '/'
+// ({{.Rel .Config.Paths.ResultsDir}}).
+func (d TemplateData) Rel(path string) string {
+	if rel, err := filepath.Rel(d.dir, path); err == nil {
+		path = rel
+	}
+	return filepath.ToSlash(path)
+}
+
+// LoadTemplate разбирает шаблон отчета из файла path; html выбирает html/template.
+// Кроме встроенных функций шаблонов доступны:
+//
+//	base    - имя файла без директории;
+//	ext     - расширение имени файла;
+//	json    - значение в формате JSON с отступами;
+//	seconds - длительность в секундах ({{seconds .Duration | printf "%.1f"}}).
+func LoadTemplate(path string, html bool) (*Template, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	funcs := map[string]any{
+		"base": filepath.Base,
+		"ext":  filepath.Ext,
+		"json": func(v any) (string, error) {
+			data, err := json.MarshalIndent(v, "", "  ")
+			return string(data), err
+		},
+		"seconds": func(d time.Duration) float64 { return d.Seconds() },
+	}
+	t := &Template{}
+	name := filepath.Base(path)
+	if html {
+		tmpl, err := htmltemplate.New(name).Funcs(funcs).Parse(string(data))
+		if err != nil {
+			return nil, err
+		}
+		t.execute = tmpl.Execute
+	} else {
+		tmpl, err := template.New(name).Funcs(funcs).Parse(string(data))
+		if err != nil {
+			return nil, err
+		}
+		t.execute = tmpl.Execute
+	}
+	return t, nil
+}
+
+// Save заполняет шаблон отчетом rep и сохраняет результат в файл path.
+func (t *Template) Save(path string, rep *Report) error {
+	data := TemplateData{Report: rep, dir: filepath.Dir(path)}
+	for _, output := range rep.Outputs {
+		if slices.Contains(imageExtensions, strings.ToLower(filepath.Ext(output))) {
+			data.Images = append(data.Images, data.Rel(output))
+		}
+	}
+	return atomicfile.Write(path, func(w io.Writer) error {
+		return t.execute(w, data)
+	})
+}
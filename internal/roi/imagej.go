@@ -0,0 +1,230 @@
+package roi
+
+import (
+	"archive/zip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf16"
+)
+
+// Типы областей ImageJ (байт 6 заголовка файла .roi).
+const (
+	imagejPolygon  = 0
+	imagejRect     = 1
+	imagejOval     = 2
+	imagejFreehand = 7
+	imagejTraced   = 8
+)
+
+// imagejSubPixel - флаг options: после целочисленных координат вершин записаны
+// координаты с плавающей точкой (абсолютные).
+const imagejSubPixel = 128
+
+// imagejHeaderSize - размер основного заголовка файла .roi; за ним следуют координаты вершин.
+const imagejHeaderSize = 64
+
+// Shape - область интереса ImageJ (Fiji) в координатах кадра: прямоугольник, эллипс
+// или многоугольник (в том числе нарисованный от руки или обведенный волшебной палочкой).
+type Shape struct {
+	// Name - имя области в ImageJ (в RoiSet.zip - имя элемента архива без ".roi").
+	Name string
+	// Bounds - ограничивающий прямоугольник области.
+	Bounds image.Rectangle
+	// kind - тип области ImageJ (imagejRect, imagejOval или тип многоугольника).
+	kind int
+	// xs, ys - вершины многоугольника в координатах кадра (углы пикселей).
+	xs, ys []float64
+}
+
+// ReadImageJ читает области интереса ImageJ из файла path: одиночного файла .roi
+// или архива RoiSet.zip (менеджер областей Fiji, "More > Save"). Поддерживаются
+// площадные области: прямоугольник, эллипс, многоугольник, свободный контур и контур
+// волшебной палочки. Линии, точки и составные области (ShapeRoi) не ограничивают
+// площадь и возвращают ошибку.
+func ReadImageJ(path string) ([]Shape, error) {
+	if strings.EqualFold(filepath.Ext(path), ".zip") {
+		return readImageJZip(path)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	shape, err := DecodeImageJ(data)
+	if err != nil {
+		return nil, err
+	}
+	if shape.Name == "" {
+		shape.Name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	return []Shape{shape}, nil
+}
+
+// IsImageJ сообщает, указывает ли path на файл областей ImageJ (.roi или .zip).
+func IsImageJ(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	return ext == ".roi" || ext == ".zip"
+}
+
+// readImageJZip читает все области архива RoiSet.zip в порядке элементов архива.
+func readImageJZip(path string) ([]Shape, error) {
+	archive, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, err
+	}
+	defer archive.Close()
+	var shapes []Shape
+	for _, file := range archive.File {
+		if !strings.EqualFold(filepath.Ext(file.Name), ".roi") {
+			continue
+		}
+		data, err := readZipFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file.Name, err)
+		}
+		shape, err := DecodeImageJ(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file.Name, err)
+		}
+		if shape.Name == "" {
+			shape.Name = strings.TrimSuffix(filepath.Base(file.Name), filepath.Ext(file.Name))
+		}
+		shapes = append(shapes, shape)
+	}
+	if len(shapes) == 0 {
+		return nil, errors.New("archive contains no .roi files")
+	}
+	return shapes, nil
+}
+
+// readZipFile возвращает содержимое элемента архива.
+func readZipFile(file *zip.File) ([]byte, error) {
+	r, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// DecodeImageJ разбирает содержимое файла .roi (формат RoiEncoder ImageJ, порядок
+// байтов big-endian).
+func DecodeImageJ(data []byte) (Shape, error) {
+	if len(data) < imagejHeaderSize || string(data[:4]) != "Iout" {
+		return Shape{}, errors.New("not an ImageJ roi file")
+	}
+	be := binary.BigEndian
+	version := int(be.Uint16(data[4:]))
+	kind := int(data[6])
+	top, left := int(int16(be.Uint16(data[8:]))), int(int16(be.Uint16(data[10:])))
+	bottom, right := int(int16(be.Uint16(data[12:]))), int(int16(be.Uint16(data[14:])))
+	n := int(be.Uint16(data[16:]))
+	shapeSize := be.Uint32(data[36:])
+	options := int(be.Uint16(data[50:]))
+
+	s := Shape{kind: kind, Bounds: image.Rect(left, top, right, bottom), Name: imagejName(data)}
+	if shapeSize > 0 {
+		return Shape{}, errors.New("composite (shape) rois are not supported, split the roi in ImageJ first")
+	}
+	switch kind {
+	case imagejRect, imagejOval:
+	case imagejPolygon, imagejFreehand, imagejTraced:
+		if n < 3 {
+			return Shape{}, fmt.Errorf("polygon roi has %d vertices, at least 3 are required", n)
+		}
+		end := imagejHeaderSize + 4*n
+		subPixel := version >= 222 && options&imagejSubPixel != 0
+		if subPixel {
+			end += 8 * n
+		}
+		if len(data) < end {
+			return Shape{}, errors.New("roi vertex coordinates are truncated")
+		}
+		s.xs, s.ys = make([]float64, n), make([]float64, n)
+		for i := range n {
+			if subPixel {
+				base := imagejHeaderSize + 4*n
+				s.xs[i] = float64(math.Float32frombits(be.Uint32(data[base+4*i:])))
+				s.ys[i] = float64(math.Float32frombits(be.Uint32(data[base+4*n+4*i:])))
+			} else {
+				s.xs[i] = float64(left + int(int16(be.Uint16(data[imagejHeaderSize+2*i:]))))
+				s.ys[i] = float64(top + int(int16(be.Uint16(data[imagejHeaderSize+2*n+2*i:]))))
+			}
+		}
+	default:
+		return Shape{}, fmt.Errorf("roi type %d is not an area roi, expected a rectangle, oval or polygon", kind)
+	}
+	if s.Bounds.Empty() {
+		return Shape{}, fmt.Errorf("roi bounds %v are empty", s.Bounds)
+	}
+	return s, nil
+}
+
+// imagejName возвращает имя области из дополнительного заголовка (header2) файла .roi
+// или пустую строку, если имя не записано.
+func imagejName(data []byte) string {
+	be := binary.BigEndian
+	header2 := int(be.Uint32(data[60:]))
+	if header2 < imagejHeaderSize || header2+24 > len(data) {
+		return ""
+	}
+	offset := int(be.Uint32(data[header2+16:]))
+	length := int(be.Uint32(data[header2+20:]))
+	if offset <= 0 || length <= 0 || offset+2*length > len(data) {
+		return ""
+	}
+	chars := make([]uint16, length)
+	for i := range chars {
+		chars[i] = be.Uint16(data[offset+2*i:])
+	}
+	return string(utf16.Decode(chars))
+}
+
+// Contains сообщает, принадлежит ли пиксель (x, y) области: как и в ImageJ, пиксель
+// принадлежит области, если ей принадлежит его центр.
+func (s Shape) Contains(x, y int) bool {
+	if !image.Pt(x, y).In(s.Bounds) {
+		return false
+	}
+	px, py := float64(x)+0.5, float64(y)+0.5
+	switch s.kind {
+	case imagejRect:
+		return true
+	case imagejOval:
+		rx, ry := float64(s.Bounds.Dx())/2, float64(s.Bounds.Dy())/2
+		dx := (px - float64(s.Bounds.Min.X) - rx) / rx
+		dy := (py - float64(s.Bounds.Min.Y) - ry) / ry
+		return dx*dx+dy*dy <= 1
+	}
+	// Правило четности пересечений луча, направленного вдоль строки.
+	inside := false
+	for i, j := 0, len(s.xs)-1; i < len(s.xs); j, i = i, i+1 {
+		if (s.ys[i] > py) != (s.ys[j] > py) &&
+			px < s.xs[j]+(py-s.ys[j])*(s.xs[i]-s.xs[j])/(s.ys[i]-s.ys[j]) {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+// Region возвращает область анализа временных рядов, соответствующую фигуре:
+// ограничивающий прямоугольник с отметкой пикселей фигуры (для прямоугольника - без отметки).
+func (s Shape) Region() Region {
+	r := Region{Name: s.Name, Rect: s.Bounds}
+	if s.kind == imagejRect {
+		return r
+	}
+	r.Inside = make([]bool, s.Bounds.Dx()*s.Bounds.Dy())
+	for y := s.Bounds.Min.Y; y < s.Bounds.Max.Y; y++ {
+		for x := s.Bounds.Min.X; x < s.Bounds.Max.X; x++ {
+			r.Inside[(y-s.Bounds.Min.Y)*s.Bounds.Dx()+x-s.Bounds.Min.X] = s.Contains(x, y)
+		}
+	}
+	return r
+}
@@ -0,0 +1,134 @@
+// Package roi описывает области интереса (ROI) кадра и построение их временных рядов
+// (средней интенсивности или пространственного спекл-контраста по кадрам). Области
+// задаются прямоугольниками конфигурации или импортируются из файлов ImageJ (Fiji).
+package roi
+
+import (
+	"fmt"
+	"image"
+	"math"
+
+	"github.com/mascotmascot1/go-tlasca/pkg/frame"
+)
+
+// Signal задает величину временного ряда области.
+type Signal string
+
+const (
+	// Intensity - средняя интенсивность области в кадре.
+	Intensity Signal = "intensity"
+	// Contrast - пространственный спекл-контраст области в кадре (σ/μ по пикселям области).
+	Contrast Signal = "contrast"
+)
+
+// Region - именованная область интереса в координатах кадра.
+type Region struct {
+	Name string
+	Rect image.Rectangle
+	// Inside отмечает (по строкам Rect) пиксели области произвольной формы, например
+	// эллипса ImageJ (см. Shape.Region); nil означает весь прямоугольник Rect.
+	Inside []bool
+}
+
+// Contains сообщает, принадлежит ли пиксель (x, y) области.
+func (r Region) Contains(x, y int) bool {
+	if !image.Pt(x, y).In(r.Rect) {
+		return false
+	}
+	return r.Inside == nil || r.Inside[(y-r.Rect.Min.Y)*r.Rect.Dx()+x-r.Rect.Min.X]
+}
+
+// Pixels возвращает число пикселей области.
+func (r Region) Pixels() int {
+	if r.Inside == nil {
+		return r.Rect.Dx() * r.Rect.Dy()
+	}
+	var n int
+	for _, inside := range r.Inside {
+		if inside {
+			n++
+		}
+	}
+	return n
+}
+
+// Parse преобразует область [x, y, ширина, высота] в прямоугольник, проверяя,
+// что она лежит внутри кадра frame. Пустое значение означает весь кадр.
+func Parse(values []int, frame image.Rectangle) (image.Rectangle, error) {
+	if len(values) == 0 {
+		return frame, nil
+	}
+	if len(values) != 4 || values[2] <= 0 || values[3] <= 0 {
+		return image.Rectangle{}, fmt.Errorf("region must be [x, y, width, height] with positive size, got %v", values)
+	}
+	rect := image.Rect(values[0], values[1], values[0]+values[2], values[1]+values[3])
+	if !rect.In(frame) {
+		return image.Rectangle{}, fmt.Errorf("region %v is outside the frame %v", rect, frame)
+	}
+	return rect, nil
+}
+
+// Value вычисляет значение сигнала signal области region кадра img,
+// интенсивность которого умножается на gain.
+func Value(img frame.Frame, gain float64, region Region, signal Signal) float64 {
+	rect := region.Rect
+	cropped := frame.Crop(img, rect)
+	buf := frame.RowBuffer(cropped)
+	var sum, sumSq, n float64
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for i, value := range cropped.Row(y, buf) {
+			if region.Inside != nil && !region.Inside[(y-rect.Min.Y)*rect.Dx()+i] {
+				continue
+			}
+			v := float64(value) * gain
+			sum += v
+			sumSq += v * v
+			n++
+		}
+	}
+	if n == 0 {
+		return 0
+	}
+	mean := sum / n
+	if signal == Intensity {
+		return mean
+	}
+	if mean <= 0 || n < 2 {
+		return 0
+	}
+	// Выборочная дисперсия по пикселям области, как и во временном контрасте.
+	variance := max((sumSq-n*mean*mean)/(n-1), 0)
+	return math.Sqrt(variance) / mean
+}
+
+// Series строит временные ряды сигнала signal для областей regions по последовательности
+// из total кадров, загружаемой через load порциями по chunkSize (chunkSize <= 0 - одной порцией).
+// gains задает попадровые коэффициенты интенсивности (nil - единичные).
+// Возвращает ряды в порядке областей.
+func Series(load func(start, end int) ([]frame.Frame, error), total, chunkSize int,
+	regions []Region, signal Signal, gains []float64) ([][]float64, error) {
+	if signal != Contrast && signal != Intensity {
+		return nil, fmt.Errorf("unknown region signal '%s', expected '%s' or '%s'", signal, Contrast, Intensity)
+	}
+	if chunkSize <= 0 {
+		chunkSize = total
+	}
+	series := make([][]float64, len(regions))
+	for start := 0; start < total; start += chunkSize {
+		end := min(start+chunkSize, total)
+		images, err := load(start, end)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load chunk [%d, %d): %w", start, end, err)
+		}
+		for i, img := range images {
+			gain := 1.0
+			if gains != nil {
+				gain = gains[start+i]
+			}
+			for r, region := range regions {
+				series[r] = append(series[r], Value(img, gain, region, signal))
+			}
+		}
+	}
+	return series, nil
+}
@@ -0,0 +1,87 @@
+package safeguard
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// cgroupRoot - точка монтирования иерархии cgroup v2.
+const cgroupRoot = "/sys/fs/cgroup"
+
+// cgroupDir возвращает директорию cgroup v2 процесса (по /proc/self/cgroup) или ошибку,
+// если система не использует cgroup v2 (не Linux или только cgroup v1).
+func cgroupDir() (string, error) {
+	data, err := os.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		if path, ok := strings.CutPrefix(line, "0::"); ok {
+			return filepath.Join(cgroupRoot, path), nil
+		}
+	}
+	return "", errors.New("cgroup v2 is not in use")
+}
+
+// CPULimit возвращает ограничение процессорного времени cgroup процесса (cpu.max)
+// в числе логических ядер, например 2.5, и true или 0 и false, если ограничения нет
+// или его не удается определить. Пакетные запуски, которым выделена часть ядер рабочей
+// станции (systemd-run -p CPUQuota=..., контейнеры), не должны запускать больше рабочих
+// горутин, чем ядер в ограничении: лишние горутины только вытесняют друг друга.
+func CPULimit() (float64, bool) {
+	dir, err := cgroupDir()
+	if err != nil {
+		return 0, false
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "cpu.max"))
+	if err != nil {
+		return 0, false
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) != 2 || fields[0] == "max" {
+		return 0, false
+	}
+	quota, err1 := strconv.ParseFloat(fields[0], 64)
+	period, err2 := strconv.ParseFloat(fields[1], 64)
+	if err1 != nil || err2 != nil || quota <= 0 || period <= 0 {
+		return 0, false
+	}
+	return quota / period, true
+}
+
+// cgroupMemoryAvailable возвращает объем памяти, остающийся до ограничения cgroup процесса
+// (memory.max - memory.current), или ошибку, если ограничения нет или его не удается определить.
+func cgroupMemoryAvailable() (uint64, error) {
+	dir, err := cgroupDir()
+	if err != nil {
+		return 0, err
+	}
+	limit, err := readCgroupValue(filepath.Join(dir, "memory.max"))
+	if err != nil {
+		return 0, err
+	}
+	current, err := readCgroupValue(filepath.Join(dir, "memory.current"))
+	if err != nil {
+		return 0, err
+	}
+	if current >= limit {
+		return 0, nil
+	}
+	return limit - current, nil
+}
+
+// readCgroupValue читает числовое значение файла cgroup; значение "max" - ошибка (нет ограничения).
+func readCgroupValue(path string) (uint64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	text := strings.TrimSpace(string(data))
+	if text == "max" {
+		return 0, errors.New("no limit")
+	}
+	return strconv.ParseUint(text, 10, 64)
+}
@@ -0,0 +1,43 @@
+package safeguard
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// CheckDisk проверяет, что на томе директории dir достаточно свободного места
+// для required байт выходных файлов. Директория может еще не существовать:
+// тогда проверяется ближайшая существующая родительская директория.
+//
+// Если свободное место определить не удалось, выводится предупреждение и проверка
+// пропускается. Возвращает ошибку, если оценка превышает свободное место.
+func CheckDisk(dir string, required uint64, logger *log.Logger) error {
+	existing, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	for {
+		if _, err := os.Stat(existing); err == nil {
+			break
+		}
+		parent := filepath.Dir(existing)
+		if parent == existing {
+			break
+		}
+		existing = parent
+	}
+
+	free, err := freeDiskSpace(existing)
+	if err != nil {
+		logger.Printf("warn: cannot determine free disk space (%v), disk space check is inactive.\n", err)
+		return nil
+	}
+	logger.Printf("estimated output size %s, free disk space %s.\n", formatMB(required), formatMB(free))
+	if required > free {
+		return fmt.Errorf("estimated output size %s exceeds free disk space %s in '%s'; free up space or choose another results_dir",
+			formatMB(required), formatMB(free), dir)
+	}
+	return nil
+}
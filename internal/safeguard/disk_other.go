@@ -0,0 +1,10 @@
+//go:build !linux && !darwin && !freebsd && !windows
+
+package safeguard
+
+import "errors"
+
+// freeDiskSpace не поддерживается на этой системе.
+func freeDiskSpace(string) (uint64, error) {
+	return 0, errors.New("not supported on this platform")
+}
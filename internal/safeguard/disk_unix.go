@@ -0,0 +1,14 @@
+//go:build linux || darwin || freebsd
+
+package safeguard
+
+import "syscall"
+
+// freeDiskSpace возвращает объем места на томе директории dir, доступного непривилегированному пользователю.
+func freeDiskSpace(dir string) (uint64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return 0, err
+	}
+	return uint64(st.Bavail) * uint64(st.Bsize), nil
+}
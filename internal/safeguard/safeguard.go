@@ -0,0 +1,219 @@
+// Package safeguard оценивает требования запуска к памяти и времени до начала
+// загрузки данных и при необходимости автоматически корректирует план обработки
+// (порционная загрузка, прореживание кадров), чтобы процесс не завершился
+// нехваткой памяти спустя десятки минут после старта.
+package safeguard
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mascotmascot1/go-tlasca/internal/config"
+	"github.com/mascotmascot1/go-tlasca/internal/parallel"
+)
+
+const (
+	// autoMemoryFraction - доля доступной памяти системы, используемая как бюджет,
+	// если memory_limit_mb не задан явно.
+	autoMemoryFraction = 0.75
+	// planeBytesPerPixel - приблизительный объем служебных плоскостей на один пиксель:
+	// статистики (mean, M2) текущей порции и накопленные, попиксельный контраст,
+	// строки результата и выходное изображение.
+	planeBytesPerPixel = 49
+	// frameBytesPerPixel - объем одного загруженного кадра на пиксель. Оценка рассчитана
+	// на 16-битные отсчеты: 8-битные кадры хранятся без расширения и занимают вдвое меньше.
+	frameBytesPerPixel = 2
+	// decodeBytesPerPixel - приблизительный объем временного буфера декодера на пиксель одного кадра.
+	decodeBytesPerPixel = 4
+	// opsPerSecondPerCore - консервативная оценка пропускной способности вычислительного ядра
+	// (элементарных операций накопления в секунду на одно логическое ядро).
+	opsPerSecondPerCore = 2e8
+)
+
+// Input описывает параметры запуска, необходимые для оценки ресурсов.
+type Input struct {
+	// Width, Height - размеры кадра в пикселях.
+	Width, Height int
+	// Frames - число кадров в последовательности.
+	Frames int
+}
+
+// Plan описывает итоговый план обработки после проверки ресурсов.
+type Plan struct {
+	// ChunkSize - число кадров в порции (0 - вся последовательность целиком).
+	ChunkSize int
+	// FrameStride - шаг прореживания кадров (1 - используются все кадры).
+	FrameStride int
+	// Adjustments - описание автоматически внесенных изменений (для лога и отчета).
+	Adjustments []string
+}
+
+// Check проверяет, укладывается ли запуск в бюджеты памяти и времени, и возвращает план обработки.
+//
+// При превышении бюджета памяти включается порционная загрузка кадров (chunk_size),
+// при превышении бюджета времени - прореживание кадров. Каждая корректировка
+// сопровождается заметным предупреждением в логе. Если автоматическая корректировка
+// отключена (limits.disable_auto_adjust), выводятся только предупреждения.
+//
+// Возвращает ошибку, если конфигурация заведомо невыполнима: окно больше кадра
+// или запуск не помещается в память даже при минимальной порции.
+func Check(limits config.LimitsConfig, algo config.AlgorithmConfig, in Input, logger *log.Logger) (Plan, error) {
+	plan := Plan{ChunkSize: algo.ChunkSize, FrameStride: 1}
+
+	if algo.WindowSize < 1 || algo.WindowSize > in.Width || algo.WindowSize > in.Height {
+		return plan, fmt.Errorf("window size %d does not fit frame size %dx%d", algo.WindowSize, in.Width, in.Height)
+	}
+
+	pixels := uint64(in.Width) * uint64(in.Height)
+
+	// --- Бюджет памяти ---
+	budget, source := memoryBudget(limits, logger)
+	if budget > 0 {
+		framesInMemory := in.Frames
+		if plan.ChunkSize > 0 {
+			framesInMemory = min(plan.ChunkSize, in.Frames)
+		}
+		if algo.ComputeMode == "streaming" {
+			// Кадры загружаются по одному и сразу учитываются в статистиках.
+			framesInMemory = 1
+		}
+		fixed := pixels * (planeBytesPerPixel + decodeBytesPerPixel)
+		frameBytes := pixels * frameBytesPerPixel
+		if algo.StackLayout == "planar" {
+			// Транспонированная копия порции занимает столько же, сколько сами кадры.
+			frameBytes *= 2
+		}
+		estimate := uint64(framesInMemory)*frameBytes + fixed
+
+		if estimate > budget {
+			msg := fmt.Sprintf("estimated memory %s exceeds budget %s (%s)",
+				formatMB(estimate), formatMB(budget), source)
+			switch {
+			case limits.DisableAutoAdjust:
+				warn(logger, msg+"; auto adjustment is disabled, continuing as configured")
+			case budget <= fixed+2*frameBytes:
+				return plan, errors.New(msg + "; the run does not fit even with chunked loading")
+			default:
+				plan.ChunkSize = int((budget - fixed) / frameBytes)
+				adjustment := fmt.Sprintf("%s; chunked loading engaged with chunk_size=%d", msg, plan.ChunkSize)
+				plan.Adjustments = append(plan.Adjustments, adjustment)
+				warn(logger, adjustment)
+			}
+		}
+	}
+
+	// --- Бюджет времени ---
+	if limits.TimeLimitS > 0 {
+		outW, outH := in.Width-algo.WindowSize+1, in.Height-algo.WindowSize+1
+		rate := opsPerSecondPerCore * float64(parallel.Workers())
+		statsSeconds := 2 * float64(in.Frames) * float64(pixels) / rate
+		windowSeconds := float64(outW) * float64(outH) * float64(algo.WindowSize*algo.WindowSize) / rate
+		budgetSeconds := float64(limits.TimeLimitS)
+
+		if statsSeconds+windowSeconds > budgetSeconds {
+			msg := fmt.Sprintf("estimated compute time %s exceeds time_limit_s=%d",
+				seconds(statsSeconds+windowSeconds), limits.TimeLimitS)
+			stride := 0
+			if windowSeconds < budgetSeconds {
+				stride = int(statsSeconds/(budgetSeconds-windowSeconds)) + 1
+			}
+			switch {
+			case limits.DisableAutoAdjust:
+				warn(logger, msg+"; auto adjustment is disabled, continuing as configured")
+			case stride == 0 || in.Frames/stride < 2:
+				warn(logger, msg+"; frame subsampling cannot help, consider a smaller window_size")
+			default:
+				plan.FrameStride = stride
+				adjustment := fmt.Sprintf("%s; frame subsampling engaged, using every %d-th frame (%d of %d)",
+					msg, stride, (in.Frames+stride-1)/stride, in.Frames)
+				plan.Adjustments = append(plan.Adjustments, adjustment)
+				warn(logger, adjustment)
+			}
+		}
+	}
+
+	return plan, nil
+}
+
+// memoryBudget возвращает бюджет памяти в байтах и описание его источника.
+// Явно заданный memory_limit_mb имеет приоритет; иначе используется доля
+// доступной памяти системы. Если ее определить не удалось, возвращается 0 (без ограничения).
+func memoryBudget(limits config.LimitsConfig, logger *log.Logger) (uint64, string) {
+	if limits.MemoryLimitMB > 0 {
+		return uint64(limits.MemoryLimitMB) << 20, "memory_limit_mb"
+	}
+	available, err := availableMemory()
+	if err != nil {
+		logger.Printf("warn: cannot determine available memory (%v), memory safeguard is inactive.\n", err)
+		return 0, ""
+	}
+	return uint64(float64(available) * autoMemoryFraction), "75% of available system memory"
+}
+
+// availableMemory возвращает объем доступной памяти: MemAvailable из /proc/meminfo (Linux),
+// но не больше остатка до ограничения памяти cgroup процесса, если оно задано.
+// На других системах возвращает ошибку.
+func availableMemory() (uint64, error) {
+	mem, err := systemMemory()
+	if err != nil {
+		return 0, err
+	}
+	if limit, err := cgroupMemoryAvailable(); err == nil {
+		mem = min(mem, limit)
+	}
+	return mem, nil
+}
+
+// systemMemory читает объем доступной памяти системы из /proc/meminfo.
+func systemMemory() (mem uint64, err error) {
+	file, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if closeErr := file.Close(); closeErr != nil {
+			if err == nil {
+				err = closeErr
+			}
+		}
+	}()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "MemAvailable:" {
+			kb, err := strconv.ParseUint(fields[1], 10, 64)
+			if err != nil {
+				return 0, err
+			}
+			return kb << 10, nil
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return 0, err
+	}
+	return 0, errors.New("MemAvailable not found in /proc/meminfo")
+}
+
+// warn выводит заметное предупреждение о выходе за бюджет ресурсов.
+func warn(logger *log.Logger, msg string) {
+	logger.Println("warn: ============================================================")
+	logger.Printf("warn: RESOURCE SAFEGUARD: %s\n", msg)
+	logger.Println("warn: ============================================================")
+}
+
+// formatMB форматирует объем памяти в мегабайтах.
+func formatMB(b uint64) string {
+	return fmt.Sprintf("%.1f MiB", float64(b)/(1<<20))
+}
+
+// seconds форматирует оценку длительности в секундах.
+func seconds(s float64) string {
+	return time.Duration(s * float64(time.Second)).Round(time.Second).String()
+}
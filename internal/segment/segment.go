@@ -0,0 +1,236 @@
+// Package segment разделяет карту контраста на сосуды и ткань без ручного выбора порога:
+// к гистограмме контраста подгоняется смесь двух нормальных распределений, и порог
+// выбирается там, где апостериорные вероятности классов равны.
+package segment
+
+import (
+	"fmt"
+	"image"
+	"math"
+
+	"github.com/mascotmascot1/go-tlasca/pkg/mask"
+)
+
+// Параметры подгонки смеси.
+const (
+	// bins - число интервалов гистограммы, по которой выполняется EM-алгоритм.
+	bins = 1024
+	// maxIterations и tolerance ограничивают EM-алгоритм: итерации прекращаются,
+	// когда логарифм правдоподобия на отсчет меняется меньше чем на tolerance.
+	maxIterations = 500
+	tolerance     = 1e-9
+)
+
+// Class описывает один класс смеси.
+type Class struct {
+	// Fraction - доля положений карты, отнесенных к классу порогом.
+	Fraction float64 `json:"fraction"`
+	// Weight, Mean, StdDev - вес, среднее и стандартное отклонение компоненты смеси.
+	Weight float64 `json:"weight"`
+	Mean   float64 `json:"mean"`
+	StdDev float64 `json:"std_dev"`
+}
+
+// Summary - результат разделения карты: порог контраста и статистики классов.
+// Быстрый кровоток снижает контраст, поэтому сосуды - класс с меньшим средним.
+type Summary struct {
+	Threshold float64 `json:"threshold"`
+	Vessel    Class   `json:"vessel"`
+	Tissue    Class   `json:"tissue"`
+	// Iterations - число выполненных итераций EM-алгоритма.
+	Iterations int `json:"iterations"`
+}
+
+// Separation возвращает безразмерную разделимость классов - расстояние между средними
+// в единицах суммы стандартных отклонений. Значения меньше 1 означают сильно
+// перекрывающиеся классы, для которых порог ненадежен.
+func (s Summary) Separation() float64 {
+	return (s.Tissue.Mean - s.Vessel.Mean) / (s.Vessel.StdDev + s.Tissue.StdDev)
+}
+
+// Fit подгоняет смесь двух нормальных распределений к значениям контраста contrast,
+// пропуская отмеченные в skip (может быть nil), нечисловые и неположительные значения.
+// Подгонка выполняется EM-алгоритмом по гистограмме значений, поэтому ее время не зависит
+// от размера карты, а результат детерминирован. Возвращает ошибку, если значений меньше двух
+// или все они одинаковы.
+func Fit(contrast []float64, skip *mask.Mask) (Summary, error) {
+	lo, hi := math.Inf(1), math.Inf(-1)
+	for i, k := range contrast {
+		if usable(contrast, skip, i) {
+			lo, hi = math.Min(lo, k), math.Max(hi, k)
+		}
+	}
+	if !(hi > lo) {
+		return Summary{}, fmt.Errorf("at least 2 distinct contrast values are required")
+	}
+	width := (hi - lo) / bins
+	counts := make([]float64, bins)
+	var total float64
+	for i, k := range contrast {
+		if usable(contrast, skip, i) {
+			counts[min(int((k-lo)/width), bins-1)]++
+			total++
+		}
+	}
+	centers := make([]float64, bins)
+	for b := range centers {
+		centers[b] = lo + (float64(b)+0.5)*width
+	}
+
+	// Начальное приближение: классы ниже и выше медианы.
+	var acc float64
+	split := 0
+	for b, c := range counts {
+		acc += c
+		if acc >= total/2 {
+			split = b + 1
+			break
+		}
+	}
+	w, mu, sigma := initial(counts, centers, split, width)
+
+	resp := make([]float64, bins)
+	previous := math.Inf(-1)
+	iterations := 0
+	for iterations < maxIterations {
+		iterations++
+		// E-шаг: вероятность принадлежности каждого интервала первой компоненте.
+		var logLikelihood float64
+		for b, x := range centers {
+			p0 := w[0] * density(x, mu[0], sigma[0])
+			p1 := w[1] * density(x, mu[1], sigma[1])
+			if p0+p1 > 0 {
+				resp[b] = p0 / (p0 + p1)
+				logLikelihood += counts[b] * math.Log(p0+p1)
+			} else {
+				resp[b] = 0.5
+			}
+		}
+		// M-шаг: веса, средние и дисперсии компонент по взвешенным интервалам.
+		var n [2]float64
+		var sum [2]float64
+		for b, x := range centers {
+			n[0] += counts[b] * resp[b]
+			n[1] += counts[b] * (1 - resp[b])
+			sum[0] += counts[b] * resp[b] * x
+			sum[1] += counts[b] * (1 - resp[b]) * x
+		}
+		for c := range 2 {
+			if n[c] == 0 {
+				return Summary{}, fmt.Errorf("mixture component %d became empty", c+1)
+			}
+			mu[c] = sum[c] / n[c]
+			w[c] = n[c] / total
+		}
+		var sq [2]float64
+		for b, x := range centers {
+			sq[0] += counts[b] * resp[b] * (x - mu[0]) * (x - mu[0])
+			sq[1] += counts[b] * (1 - resp[b]) * (x - mu[1]) * (x - mu[1])
+		}
+		for c := range 2 {
+			// Дисперсия не меньше дисперсии равномерного распределения в интервале гистограммы.
+			sigma[c] = math.Sqrt(math.Max(sq[c]/n[c], width*width/12))
+		}
+		logLikelihood /= total
+		if math.Abs(logLikelihood-previous) < tolerance {
+			break
+		}
+		previous = logLikelihood
+	}
+
+	if mu[0] > mu[1] {
+		w[0], w[1] = w[1], w[0]
+		mu[0], mu[1] = mu[1], mu[0]
+		sigma[0], sigma[1] = sigma[1], sigma[0]
+	}
+	s := Summary{
+		Threshold:  threshold(w, mu, sigma),
+		Vessel:     Class{Weight: w[0], Mean: mu[0], StdDev: sigma[0]},
+		Tissue:     Class{Weight: w[1], Mean: mu[1], StdDev: sigma[1]},
+		Iterations: iterations,
+	}
+	var below float64
+	for i, k := range contrast {
+		if usable(contrast, skip, i) && k < s.Threshold {
+			below++
+		}
+	}
+	s.Vessel.Fraction = below / total
+	s.Tissue.Fraction = 1 - s.Vessel.Fraction
+	return s, nil
+}
+
+// Mask возвращает изображение маски сосудов карты размера width x height:
+// 255 - положения с контрастом ниже порога, 0 - ткань, исключенные и нулевые положения.
+func Mask(contrast []float64, width, height int, skip *mask.Mask, threshold float64) *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	for i := range contrast {
+		if usable(contrast, skip, i) && contrast[i] < threshold {
+			img.Pix[(i/width)*img.Stride+i%width] = 255
+		}
+	}
+	return img
+}
+
+// usable сообщает, участвует ли положение i карты в подгонке.
+func usable(contrast []float64, skip *mask.Mask, i int) bool {
+	k := contrast[i]
+	return k > 0 && !math.IsInf(k, 0) && (skip == nil || !skip.Set[i])
+}
+
+// initial возвращает начальные веса, средние и стандартные отклонения компонент
+// по интервалам гистограммы ниже и выше интервала split.
+func initial(counts, centers []float64, split int, width float64) (w, mu, sigma [2]float64) {
+	for c, part := range [2][2]int{{0, split}, {split, len(counts)}} {
+		var n, sum, sq float64
+		for b := part[0]; b < part[1]; b++ {
+			n += counts[b]
+			sum += counts[b] * centers[b]
+		}
+		if n == 0 {
+			// Все значения в одной половине: компоненты разводятся на краях диапазона.
+			mu[c] = centers[min(part[0], len(centers)-1)]
+			w[c], sigma[c] = 0.5, width*float64(len(counts))/4
+			continue
+		}
+		mu[c] = sum / n
+		for b := part[0]; b < part[1]; b++ {
+			sq += counts[b] * (centers[b] - mu[c]) * (centers[b] - mu[c])
+		}
+		w[c] = n
+		sigma[c] = math.Sqrt(math.Max(sq/n, width*width/12))
+	}
+	total := w[0] + w[1]
+	w[0], w[1] = w[0]/total, w[1]/total
+	return w, mu, sigma
+}
+
+// threshold возвращает значение между средними mu[0] < mu[1], в котором взвешенные
+// плотности компонент равны; если такого значения нет, возвращает середину между средними.
+func threshold(w, mu, sigma [2]float64) float64 {
+	// w0·N(x; mu0, s0) = w1·N(x; mu1, s1) сводится к квадратному уравнению a·x² + b·x + c = 0.
+	v0, v1 := sigma[0]*sigma[0], sigma[1]*sigma[1]
+	a := 1/v0 - 1/v1
+	b := 2 * (mu[1]/v1 - mu[0]/v0)
+	c := mu[0]*mu[0]/v0 - mu[1]*mu[1]/v1 + 2*math.Log(w[1]*sigma[0]/(w[0]*sigma[1]))
+	var roots []float64
+	if math.Abs(a) < 1e-12*(1/v0+1/v1) {
+		if b != 0 {
+			roots = append(roots, -c/b)
+		}
+	} else if d := b*b - 4*a*c; d >= 0 {
+		roots = append(roots, (-b+math.Sqrt(d))/(2*a), (-b-math.Sqrt(d))/(2*a))
+	}
+	for _, x := range roots {
+		if x > mu[0] && x < mu[1] {
+			return x
+		}
+	}
+	return (mu[0] + mu[1]) / 2
+}
+
+// density возвращает плотность нормального распределения N(mu, sigma²) в точке x.
+func density(x, mu, sigma float64) float64 {
+	z := (x - mu) / sigma
+	return math.Exp(-z*z/2) / (sigma * math.Sqrt(2*math.Pi))
+}
@@ -0,0 +1,113 @@
+// Package selfbench измеряет масштабирование расчета по числу рабочих горутин
+// на небольшой фиксированной нагрузке (синтетическая последовательность кадров).
+// На NUMA-серверах и виртуальных машинах эффективность часто падает задолго
+// до числа логических ядер; замер показывает, начиная с какого числа горутин
+// добавление ядер перестает ускорять расчет.
+package selfbench
+
+import (
+	"context"
+	"math/rand/v2"
+	"time"
+
+	"github.com/mascotmascot1/go-tlasca/internal/parallel"
+	"github.com/mascotmascot1/go-tlasca/pkg/frame"
+	"github.com/mascotmascot1/go-tlasca/pkg/tlasca"
+)
+
+const (
+	// frameSize, frameCount, windowSize задают нагрузку: достаточно большую, чтобы накладные
+	// расходы запуска горутин были малы, и достаточно малую для замера за секунды.
+	frameSize  = 1024
+	frameCount = 32
+	windowSize = 7
+	// scalingTolerance - доля лучшего времени, в пределах которой число горутин
+	// считается достаточным (см. Recommend).
+	scalingTolerance = 1.05
+)
+
+// Result - время расчета нагрузки при заданном числе горутин.
+type Result struct {
+	// Workers - число рабочих горутин.
+	Workers int `json:"workers"`
+	// Duration - лучшее из повторных измерений.
+	Duration time.Duration `json:"duration_ns"`
+	// Speedup - ускорение относительно одной горутины.
+	Speedup float64 `json:"speedup"`
+	// Efficiency - эффективность масштабирования (Speedup / Workers).
+	Efficiency float64 `json:"efficiency"`
+}
+
+// WorkerCounts возвращает ряд чисел горутин 1, 2, 4, ... до maxWorkers включительно
+// (maxWorkers добавляется, если не является степенью двойки).
+func WorkerCounts(maxWorkers int) []int {
+	var counts []int
+	for n := 1; n < maxWorkers; n *= 2 {
+		counts = append(counts, n)
+	}
+	return append(counts, max(maxWorkers, 1))
+}
+
+// Run выполняет нагрузку при каждом числе горутин из counts (repeats раз, учитывается
+// лучшее время) и возвращает результаты в том же порядке. Ускорение отсчитывается
+// от первого элемента counts, который должен быть равен 1.
+// После замера восстанавливается прежнее число горутин пакета parallel.
+func Run(counts []int, repeats int) []Result {
+	frames := syntheticFrames()
+	runner := tlasca.NewRunner(tlasca.Params{WindowSize: windowSize}, nil, nil)
+
+	previous := parallel.SetWorkers(0)
+	defer parallel.SetWorkers(previous)
+
+	results := make([]Result, len(counts))
+	for i, n := range counts {
+		parallel.SetWorkers(n)
+		best := time.Duration(0)
+		for r := 0; r < max(repeats, 1); r++ {
+			start := time.Now()
+			runner.Run(context.Background(), frames, tlasca.Options{})
+			if elapsed := time.Since(start); best == 0 || elapsed < best {
+				best = elapsed
+			}
+		}
+		results[i] = Result{Workers: n, Duration: best}
+	}
+	for i := range results {
+		results[i].Speedup = float64(results[0].Duration) / float64(results[i].Duration)
+		results[i].Efficiency = results[i].Speedup / float64(results[i].Workers)
+	}
+	return results
+}
+
+// Recommend возвращает наименьшее число горутин, время расчета при котором
+// не более чем на 5% хуже лучшего: дальнейшее увеличение почти не ускоряет расчет.
+func Recommend(results []Result) int {
+	if len(results) == 0 {
+		return 0
+	}
+	best := results[0].Duration
+	for _, r := range results {
+		best = min(best, r.Duration)
+	}
+	for _, r := range results {
+		if float64(r.Duration) <= scalingTolerance*float64(best) {
+			return r.Workers
+		}
+	}
+	return results[len(results)-1].Workers
+}
+
+// syntheticFrames создает фиксированную последовательность 12-битных кадров
+// со случайными флуктуациями интенсивности (одинаковую при каждом запуске).
+func syntheticFrames() []frame.Frame {
+	rng := rand.New(rand.NewPCG(1, 2))
+	frames := make([]frame.Frame, frameCount)
+	for t := range frames {
+		f := frame.NewRaw(frameSize, frameSize)
+		for i := range f.Pix {
+			f.Pix[i] = uint16(rng.IntN(4096))
+		}
+		frames[t] = f
+	}
+	return frames
+}
@@ -0,0 +1,190 @@
+// Package speckle вычисляет теоретические границы спекл-контраста по модели
+// многоэкспозиционной визуализации (Parthasarathy et al., 2008) и отмечает положения
+// карты, контраст которых выходит за физически возможный диапазон. Такие положения
+// указывают не на кровоток, а на ошибки калибровки или предобработки: неверную
+// нормировку интенсивности, насыщение, остаточное темновое смещение, сдвиг кадров.
+// Та же модель позволяет разделить статическое и динамическое рассеяние (см. Separate).
+package speckle
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+
+	"github.com/mascotmascot1/go-tlasca/internal/parallel"
+	"github.com/mascotmascot1/go-tlasca/pkg/tlasca"
+)
+
+// Значения маски выхода за теоретические границы (как в render.ClippingMask).
+const (
+	maskAbove = 255
+	maskBelow = 128
+)
+
+// Цвета отметок на наложении: выше верхней границы - красный, ниже нижней - синий.
+var (
+	overlayAbove = color.RGBA{R: 255, A: 255}
+	overlayBelow = color.RGBA{B: 255, A: 255}
+)
+
+// Model - модель спекл-контраста при экспозиции Exposure:
+//
+//	K²(x) = β·[ρ²·(e^{-2x} - 1 + 2x)/(2x²) + 4ρ(1-ρ)·(e^{-x} - 1 + x)/x² + (1-ρ)²],  x = T/τc
+//
+// где T - экспозиция, τc - время корреляции, ρ = 1 - StaticFraction - доля света,
+// рассеянного движущимися частицами, β = Beta - коэффициент когерентности (спекл/пиксель,
+// поляризация). Контраст монотонно убывает с ростом x: от √β для неподвижного объекта
+// (τc → ∞) до √β·(1-ρ) для бесконечно быстрого движения (τc → 0).
+type Model struct {
+	// Exposure - экспозиция кадра; единицы те же, что у времен корреляции.
+	Exposure float64 `json:"exposure"`
+	// StaticFraction - доля статического рассеяния [0, 1].
+	StaticFraction float64 `json:"static_fraction"`
+	// Beta - коэффициент когерентности (0, 1].
+	Beta float64 `json:"beta"`
+}
+
+// NewModel проверяет параметры модели и возвращает ее.
+func NewModel(exposure, staticFraction, beta float64) (Model, error) {
+	if !(exposure > 0) || math.IsInf(exposure, 0) {
+		return Model{}, fmt.Errorf("exposure time must be positive, got %g", exposure)
+	}
+	if !(staticFraction >= 0 && staticFraction <= 1) {
+		return Model{}, fmt.Errorf("static fraction must be in [0, 1], got %g", staticFraction)
+	}
+	if !(beta > 0 && beta <= 1) {
+		return Model{}, fmt.Errorf("beta must be in (0, 1], got %g", beta)
+	}
+	return Model{Exposure: exposure, StaticFraction: staticFraction, Beta: beta}, nil
+}
+
+// Contrast возвращает контраст модели при времени корреляции tau; tau <= 0 означает
+// бесконечно быстрое движение, +Inf - неподвижный объект.
+func (m Model) Contrast(tau float64) float64 {
+	rho := 1 - m.StaticFraction
+	var dynamic, mixed float64
+	if x := m.Exposure / tau; tau > 0 {
+		dynamic, mixed = motionTerms(x)
+	}
+	k2 := m.Beta * (rho*rho*dynamic + 4*rho*(1-rho)*mixed + (1-rho)*(1-rho))
+	return math.Sqrt(k2)
+}
+
+// motionTerms возвращает слагаемые модели, зависящие от движения, при x = T/τc:
+// (e^{-2x} - 1 + 2x)/(2x²) и (e^{-x} - 1 + x)/x². Оба убывают от 1 и 1/2 при x = 0
+// до 0 при x → ∞ (как 1/x).
+func motionTerms(x float64) (dynamic, mixed float64) {
+	switch {
+	case math.IsInf(x, 1):
+		return 0, 0
+	case x < 1e-4:
+		// Разложение в ряд: прямая формула теряет точность при вычитании близких величин.
+		return 1 - 2*x/3, 0.5 - x/6
+	default:
+		return (math.Exp(-2*x) - 1 + 2*x) / (2 * x * x), (math.Exp(-x) - 1 + x) / (x * x)
+	}
+}
+
+// Limits - теоретический диапазон контраста [Min, Max].
+type Limits struct {
+	Min float64 `json:"min"`
+	Max float64 `json:"max"`
+}
+
+// Limits возвращает диапазон контраста модели для времен корреляции [tauMin, tauMax];
+// tauMin <= 0 означает отсутствие нижней границы времени корреляции (сколь угодно быстрое
+// движение), tauMax <= 0 - отсутствие верхней (неподвижный объект).
+func (m Model) Limits(tauMin, tauMax float64) Limits {
+	if tauMax <= 0 {
+		tauMax = math.Inf(1)
+	}
+	return Limits{Min: m.Contrast(tauMin), Max: m.Contrast(tauMax)}
+}
+
+// Summary - результат проверки карты контраста на теоретические границы.
+type Summary struct {
+	Model Model `json:"model"`
+	// Limits - теоретический диапазон контраста.
+	Limits Limits `json:"limits"`
+	// Tolerance - допустимое относительное отклонение от границ (погрешность оценки контраста).
+	Tolerance float64 `json:"tolerance"`
+	// Above, Below - число положений карты выше верхней и ниже нижней границы с учетом допуска.
+	Above int `json:"above"`
+	Below int `json:"below"`
+	// Checked - число проверенных положений (без исключенных и нулевых).
+	Checked int `json:"checked"`
+}
+
+// Fraction возвращает долю проверенных положений карты вне границ.
+func (s Summary) Fraction() float64 {
+	if s.Checked == 0 {
+		return 0
+	}
+	return float64(s.Above+s.Below) / float64(s.Checked)
+}
+
+// Check сравнивает карту res с диапазоном limits модели model: положение выходит за границы,
+// если контраст больше Max·(1+tolerance) или меньше Min·(1-tolerance). Исключенные положения
+// и положения с нулевым контрастом не проверяются. Возвращает сводку и маску карты:
+// 255 - выше верхней границы, 128 - ниже нижней, 0 - в пределах или не проверено.
+func Check(res *tlasca.Result, model Model, limits Limits, tolerance float64) (Summary, *image.Gray) {
+	img := image.NewGray(image.Rect(0, 0, res.Width, res.Height))
+	high, low := limits.Max*(1+tolerance), limits.Min*(1-tolerance)
+	parallel.Rows(res.Height, func(startY, endY int) {
+		for y := startY; y < endY; y++ {
+			for x := 0; x < res.Width; x++ {
+				k := res.Contrast[y*res.Width+x]
+				if res.IsExcluded(x, y) || k == 0 {
+					continue
+				}
+				switch {
+				case k > high:
+					img.Pix[y*img.Stride+x] = maskAbove
+				case k < low:
+					img.Pix[y*img.Stride+x] = maskBelow
+				}
+			}
+		}
+	})
+
+	s := Summary{Model: model, Limits: limits, Tolerance: tolerance}
+	for y := 0; y < res.Height; y++ {
+		for x := 0; x < res.Width; x++ {
+			if res.IsExcluded(x, y) || res.Contrast[y*res.Width+x] == 0 {
+				continue
+			}
+			s.Checked++
+			switch img.Pix[y*img.Stride+x] {
+			case maskAbove:
+				s.Above++
+			case maskBelow:
+				s.Below++
+			}
+		}
+	}
+	return s, img
+}
+
+// Overlay возвращает изображение карты mapImage (в оттенках серого), на котором положения
+// маски mask выше верхней границы закрашены красным, ниже нижней - синим.
+func Overlay(mapImage, mask *image.Gray) *image.RGBA {
+	bounds := mapImage.Bounds()
+	img := image.NewRGBA(bounds)
+	parallel.Rows(bounds.Dy(), func(startY, endY int) {
+		for y := bounds.Min.Y + startY; y < bounds.Min.Y+endY; y++ {
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				switch mask.GrayAt(x, y).Y {
+				case maskAbove:
+					img.SetRGBA(x, y, overlayAbove)
+				case maskBelow:
+					img.SetRGBA(x, y, overlayBelow)
+				default:
+					v := mapImage.GrayAt(x, y).Y
+					img.SetRGBA(x, y, color.RGBA{R: v, G: v, B: v, A: 255})
+				}
+			}
+		}
+	})
+	return img
+}
@@ -0,0 +1,177 @@
+package speckle
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/mascotmascot1/go-tlasca/internal/parallel"
+	"github.com/mascotmascot1/go-tlasca/pkg/mask"
+	"github.com/mascotmascot1/go-tlasca/pkg/tlasca"
+)
+
+// Границы поиска индекса движения x = T/τc при обращении модели.
+const (
+	flowIndexMin = 1e-6
+	flowIndexMax = 1e6
+)
+
+// Separation - разделение статического и динамического рассеяния по совместным
+// временным и пространственным статистикам последовательности (в геометрии карты).
+//
+// Временной контраст пикселя не содержит вклада неподвижных рассеивателей (их поле в пикселе
+// постоянно), а пространственный контраст временного среднего - наоборот, содержит только его
+// (флуктуации подвижных рассеивателей усредняются по кадрам):
+//
+//	Kt² = β·[ρ²·(e^{-2x} - 1 + 2x)/(2x²) + 4ρ(1-ρ)·(e^{-x} - 1 + x)/x²]
+//	Km² = β·(1-ρ)² + Kt²/N
+//
+// где Kt² - средний по окну квадрат временного контраста пикселей, Km² - квадрат
+// пространственного контраста временного среднего в окне, N - число кадров.
+// По Km² оценивается доля статического рассеяния 1-ρ, затем при известном ρ из Kt²
+// находится индекс движения x = T/τc (исправленный на статическое рассеяние).
+type Separation struct {
+	// Width, Height - размеры карты.
+	Width, Height int
+	// Static хранит построчно (y*Width + x) долю статического рассеяния 1-ρ окна [0, 1].
+	Static []float64
+	// Flow хранит построчно индекс движения T/τc окна (0 - движение не обнаружено).
+	Flow []float64
+	// Skip отмечает положения окна без оценки: исключенные из карты и с нулевой интенсивностью.
+	Skip *mask.Mask
+}
+
+// Separate оценивает долю статического рассеяния и индекс движения для каждого положения
+// окна windowSize карты res, рассчитанной по временной статистике (нужны Mean, StdDev и Frames),
+// при коэффициенте когерентности beta.
+func Separate(res *tlasca.Result, windowSize int, beta float64) (*Separation, error) {
+	if res.StdDev == nil || res.Frames < 2 {
+		return nil, fmt.Errorf("static scattering estimation requires temporal statistics of at least 2 frames")
+	}
+	if windowSize < 3 {
+		return nil, fmt.Errorf("static scattering estimation requires window size of at least 3, got %d", windowSize)
+	}
+	if !(beta > 0 && beta <= 1) {
+		return nil, fmt.Errorf("beta must be in (0, 1], got %g", beta)
+	}
+
+	fw := res.FrameWidth
+	// Квадрат временного контраста пикселей; NaN - пиксель без сигнала.
+	temporal := make([]float64, len(res.Mean))
+	for i, m := range res.Mean {
+		if m <= 0 {
+			temporal[i] = math.NaN()
+			continue
+		}
+		k := res.StdDev[i] / m
+		temporal[i] = k * k
+	}
+
+	s := &Separation{
+		Width:  res.Width,
+		Height: res.Height,
+		Static: make([]float64, res.Width*res.Height),
+		Flow:   make([]float64, res.Width*res.Height),
+		Skip:   &mask.Mask{Width: res.Width, Height: res.Height, Set: make([]bool, res.Width*res.Height)},
+	}
+	n := float64(windowSize * windowSize)
+	residual := 1 / float64(res.Frames)
+	parallel.Rows(res.Height, func(startY, endY int) {
+		for y := startY; y < endY; y++ {
+			for x := 0; x < res.Width; x++ {
+				i := y*res.Width + x
+				if res.IsExcluded(x, y) {
+					s.Skip.Set[i] = true
+					continue
+				}
+				var sum, sumSq, sumTemporal float64
+				for wy := y; wy < y+windowSize; wy++ {
+					for wx := x; wx < x+windowSize; wx++ {
+						m := res.Mean[wy*fw+wx]
+						sum += m
+						sumSq += m * m
+						sumTemporal += temporal[wy*fw+wx]
+					}
+				}
+				if math.IsNaN(sumTemporal) {
+					s.Skip.Set[i] = true
+					continue
+				}
+				mean := sum / n
+				spatial := max(sumSq-sum*sum/n, 0) / (n - 1) / (mean * mean)
+				kt2 := sumTemporal / n
+				static := math.Sqrt(min(max(spatial-kt2*residual, 0)/beta, 1))
+				s.Static[i] = static
+				s.Flow[i] = flowIndex(kt2/beta, 1-static)
+			}
+		}
+	})
+	return s, nil
+}
+
+// flowIndex обращает модель временного контраста: возвращает x = T/τc, при котором
+// ρ²·f2(x) + 4ρ(1-ρ)·f1(x) = target (target - квадрат временного контраста, деленный на β).
+// Левая часть монотонно убывает с ростом x, поэтому x находится делением отрезка
+// пополам в логарифмической шкале. Если контраст не ниже значения для неподвижного объекта
+// или рассеяние полностью статическое (ρ = 0), возвращает 0; если контраст ниже значения
+// на верхней границе поиска - flowIndexMax.
+func flowIndex(target, rho float64) float64 {
+	if rho <= 0 || target >= 1-(1-rho)*(1-rho) {
+		return 0
+	}
+	model := func(x float64) float64 {
+		dynamic, mixed := motionTerms(x)
+		return rho*rho*dynamic + 4*rho*(1-rho)*mixed
+	}
+	if target <= model(flowIndexMax) {
+		return flowIndexMax
+	}
+	lo, hi := math.Log(flowIndexMin), math.Log(flowIndexMax)
+	for range 60 {
+		mid := (lo + hi) / 2
+		if model(math.Exp(mid)) > target {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	return math.Exp((lo + hi) / 2)
+}
+
+// SeparationSummary - сводка разделения статического и динамического рассеяния для отчета.
+type SeparationSummary struct {
+	// Beta - коэффициент когерентности модели.
+	Beta float64 `json:"beta"`
+	// Frames - число кадров временных статистик.
+	Frames int `json:"frames"`
+	// Windows - число положений окна с оценкой.
+	Windows int `json:"windows"`
+	// MeanStatic - средняя доля статического рассеяния 1-ρ.
+	MeanStatic float64 `json:"mean_static_fraction"`
+	// MedianFlow - медиана индекса движения T/τc.
+	MedianFlow float64 `json:"median_flow_index"`
+	// FlowMax - индекс движения, соответствующий максимуму шкалы изображения индекса
+	// (0, если изображение не сохранялось).
+	FlowMax float64 `json:"flow_max,omitempty"`
+}
+
+// Summarize возвращает сводку разделения s, выполненного с параметрами beta и frames.
+func (s *Separation) Summarize(beta float64, frames int) SeparationSummary {
+	summary := SeparationSummary{Beta: beta, Frames: frames}
+	flow := make([]float64, 0, len(s.Flow))
+	for i, static := range s.Static {
+		if s.Skip.Set[i] {
+			continue
+		}
+		summary.MeanStatic += static
+		flow = append(flow, s.Flow[i])
+	}
+	summary.Windows = len(flow)
+	if len(flow) == 0 {
+		return summary
+	}
+	summary.MeanStatic /= float64(len(flow))
+	sort.Float64s(flow)
+	summary.MedianFlow = flow[len(flow)/2]
+	return summary
+}
@@ -0,0 +1,187 @@
+// Package synthetic моделирует последовательности спекл-кадров с известными параметрами
+// движения: горизонтальная полоса по центру кадра ("сосуд") декоррелирует быстрее
+// окружающей "ткани". Такие последовательности позволяют проверить настройки расчета
+// и сравнить оценки (например, speckle.Separate) с заданными значениями.
+//
+// Поле в каждом пикселе кадра - сумма статической и динамической комплексных гауссовых
+// составляющих, пространственно коррелированных на масштабе спекла. Динамическая
+// составляющая меняется авторегрессией с корреляцией между шагами exp(-Δt/τc), так что
+// поле теряет корреляцию за время τc. Интенсивность кадра усредняется по шагам
+// экспозиции: чем быстрее движение, тем ниже контраст спеклов.
+package synthetic
+
+import (
+	"fmt"
+	"math"
+	"math/rand/v2"
+
+	"github.com/mascotmascot1/go-tlasca/internal/parallel"
+)
+
+// Params - параметры синтетической последовательности.
+type Params struct {
+	// Width, Height - размеры кадра.
+	Width, Height int
+	// Frames - число кадров.
+	Frames int
+	// SpeckleSize - размер спекла, пикселей (ширина окна усреднения поля).
+	SpeckleSize int
+	// VesselWidth - высота полосы сосуда по центру кадра, пикселей (0 - без сосуда).
+	VesselWidth int
+	// VesselFlow, TissueFlow - отношение экспозиции к времени корреляции T/τc
+	// в сосуде и ткани (0 - неподвижный объект).
+	VesselFlow, TissueFlow float64
+	// StaticFraction - доля интенсивности, рассеянной неподвижными рассеивателями [0, 1].
+	StaticFraction float64
+	// Substeps - число шагов моделирования за экспозицию кадра.
+	Substeps int
+	// Seed - начальное значение генератора случайных чисел (одинаковые параметры
+	// дают одинаковую последовательность).
+	Seed uint64
+}
+
+// Validate проверяет параметры последовательности.
+func (p Params) Validate() error {
+	switch {
+	case p.Width < 1 || p.Height < 1:
+		return fmt.Errorf("frame size must be positive, got %dx%d", p.Width, p.Height)
+	case p.Frames < 1:
+		return fmt.Errorf("frame count must be positive, got %d", p.Frames)
+	case p.SpeckleSize < 1 || p.SpeckleSize > min(p.Width, p.Height):
+		return fmt.Errorf("speckle size must be within [1, %d], got %d", min(p.Width, p.Height), p.SpeckleSize)
+	case p.VesselWidth < 0 || p.VesselWidth > p.Height:
+		return fmt.Errorf("vessel width must be within [0, %d], got %d", p.Height, p.VesselWidth)
+	case !(p.VesselFlow >= 0) || !(p.TissueFlow >= 0) || math.IsInf(p.VesselFlow, 0) || math.IsInf(p.TissueFlow, 0):
+		return fmt.Errorf("flow must be non-negative, got vessel %g, tissue %g", p.VesselFlow, p.TissueFlow)
+	case !(p.StaticFraction >= 0 && p.StaticFraction <= 1):
+		return fmt.Errorf("static fraction must be in [0, 1], got %g", p.StaticFraction)
+	case p.Substeps < 1:
+		return fmt.Errorf("substeps must be positive, got %d", p.Substeps)
+	}
+	return nil
+}
+
+// InVessel сообщает, находится ли строка кадра y в полосе сосуда.
+func (p Params) InVessel(y int) bool {
+	top := (p.Height - p.VesselWidth) / 2
+	return y >= top && y < top+p.VesselWidth
+}
+
+// Generate моделирует кадры последовательности по порядку и передает в emit интенсивность
+// кадра t построчно (среднее значение 1, распределение экспоненциальное для неподвижного
+// объекта). Срез intensity переиспользуется между вызовами. Ошибка emit прерывает
+// моделирование и возвращается.
+func Generate(p Params, emit func(t int, intensity []float64) error) error {
+	if err := p.Validate(); err != nil {
+		return err
+	}
+	rng := rand.New(rand.NewPCG(p.Seed, p.Seed^0x9e3779b97f4a7c15))
+	n := p.Width * p.Height
+
+	// Статическая составляющая не меняется между кадрами.
+	staticField := newField(n)
+	staticField.fill(rng)
+	staticField.blur(p.Width, p.Height, p.SpeckleSize)
+
+	// Корреляция динамического поля между шагами и амплитуда обновления в каждом пикселе.
+	corr, update := make([]float64, n), make([]float64, n)
+	for y := range p.Height {
+		flow := p.TissueFlow
+		if p.InVessel(y) {
+			flow = p.VesselFlow
+		}
+		r := math.Exp(-flow / float64(p.Substeps))
+		for x := range p.Width {
+			corr[y*p.Width+x], update[y*p.Width+x] = r, math.Sqrt(1-r*r)
+		}
+	}
+	state := newField(n)
+	state.fill(rng)
+	noise, dynamic := newField(n), newField(n)
+
+	static, moving := math.Sqrt(p.StaticFraction), math.Sqrt(1-p.StaticFraction)
+	intensity := make([]float64, n)
+	for t := range p.Frames {
+		clear(intensity)
+		for range p.Substeps {
+			// Случайные числа генерируются последовательно: последовательность воспроизводима.
+			noise.fill(rng)
+			for i := range state.re {
+				state.re[i] = corr[i]*state.re[i] + update[i]*noise.re[i]
+				state.im[i] = corr[i]*state.im[i] + update[i]*noise.im[i]
+			}
+			copy(dynamic.re, state.re)
+			copy(dynamic.im, state.im)
+			dynamic.blur(p.Width, p.Height, p.SpeckleSize)
+			for i := range intensity {
+				re := static*staticField.re[i] + moving*dynamic.re[i]
+				im := static*staticField.im[i] + moving*dynamic.im[i]
+				intensity[i] += re*re + im*im
+			}
+		}
+		for i := range intensity {
+			intensity[i] /= float64(p.Substeps)
+		}
+		if err := emit(t, intensity); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// field - комплексное поле кадра (построчно).
+type field struct {
+	re, im []float64
+}
+
+// newField создает нулевое поле из n пикселей.
+func newField(n int) *field {
+	return &field{re: make([]float64, n), im: make([]float64, n)}
+}
+
+// fill заполняет поле независимыми комплексными гауссовыми значениями с E|z|² = 1.
+func (f *field) fill(rng *rand.Rand) {
+	for i := range f.re {
+		f.re[i] = rng.NormFloat64() * math.Sqrt2 / 2
+		f.im[i] = rng.NormFloat64() * math.Sqrt2 / 2
+	}
+}
+
+// blur заменяет каждое значение суммой значений окна size x size (с периодическим
+// продолжением кадра), деленной на size: для независимых значений E|z|² сохраняется,
+// а соседние пиксели становятся коррелированными на масштабе size.
+func (f *field) blur(width, height, size int) {
+	if size == 1 {
+		return
+	}
+	norm := 1 / math.Sqrt(float64(size))
+	for _, plane := range [][]float64{f.re, f.im} {
+		tmp := make([]float64, len(plane))
+		// По строкам, затем по столбцам (окно - прямоугольник, поэтому разделимо).
+		parallel.Rows(height, func(startY, endY int) {
+			for y := startY; y < endY; y++ {
+				row := plane[y*width : (y+1)*width]
+				var sum float64
+				for k := range size {
+					sum += row[k%width]
+				}
+				for x := range width {
+					tmp[y*width+x] = sum * norm
+					sum += row[(x+size)%width] - row[x]
+				}
+			}
+		})
+		parallel.Rows(width, func(startX, endX int) {
+			for x := startX; x < endX; x++ {
+				var sum float64
+				for k := range size {
+					sum += tmp[(k%height)*width+x]
+				}
+				for y := range height {
+					plane[y*width+x] = sum * norm
+					sum += tmp[((y+size)%height)*width+x] - tmp[y*width+x]
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,177 @@
+// Package telemetry собирает сведения о длительности и потреблении памяти
+// отдельных этапов конвейера обработки (загрузка, подготовка, вычисления и т.д.).
+package telemetry
+
+import (
+	"fmt"
+	"log"
+	"runtime/metrics"
+	"sync"
+	"time"
+
+	"github.com/mascotmascot1/go-tlasca/internal/events"
+)
+
+// sampleInterval определяет, как часто во время этапа опрашивается объем занятой кучи.
+const sampleInterval = 10 * time.Millisecond
+
+// heapMetric - имя метрики runtime с объемом памяти, занятой живыми и еще не собранными объектами кучи.
+const heapMetric = "/memory/classes/heap/objects:bytes"
+
+// StageStats содержит агрегированную статистику по одному этапу конвейера.
+// Этап может выполняться многократно (например, декодирование каждого кадра),
+// тогда длительности суммируются, а пиковое потребление памяти берется максимальным.
+type StageStats struct {
+	// Name - имя этапа.
+	Name string `json:"name"`
+	// Calls - сколько раз этап выполнялся.
+	Calls int `json:"calls"`
+	// Duration - суммарная длительность всех выполнений этапа.
+	Duration time.Duration `json:"duration_ns"`
+	// PeakHeapBytes - максимальный объем занятой кучи, наблюдавшийся во время этапа.
+	PeakHeapBytes uint64 `json:"peak_heap_bytes"`
+}
+
+// Recorder накапливает статистику этапов. Методы безопасны для конкурентного вызова.
+// Нулевой указатель (*Recorder)(nil) допустим: все методы в этом случае ничего не делают,
+// что позволяет отключать телеметрию без дополнительных проверок в вызывающем коде.
+// О начале и завершении каждого выполнения этапа сообщается в шину событий
+// (events.StageStarted и events.StageFinished).
+type Recorder struct {
+	logger *log.Logger
+	bus    *events.Bus
+
+	mu     sync.Mutex
+	order  []string
+	stages map[string]*StageStats
+}
+
+// NewRecorder является конструктором для Recorder. События этапов публикуются в bus
+// (nil - без публикации).
+func NewRecorder(logger *log.Logger, bus *events.Bus) *Recorder {
+	return &Recorder{
+		logger: logger,
+		bus:    bus,
+		stages: make(map[string]*StageStats),
+	}
+}
+
+// Start начинает измерение этапа name и возвращает функцию, завершающую измерение.
+// Типичное использование:
+//
+//	defer rec.Start("decode")()
+func (rec *Recorder) Start(name string) (stop func()) {
+	if rec == nil {
+		return func() {}
+	}
+
+	started := time.Now()
+	rec.bus.Publish(events.Event{Kind: events.StageStarted, Time: started, Stage: name})
+	peak := readHeap()
+	done := make(chan struct{})
+	sampled := make(chan uint64, 1)
+
+	// Фоновая горутина периодически опрашивает объем кучи, чтобы зафиксировать
+	// пик потребления памяти внутри этапа, а не только на его границах.
+	go func() {
+		ticker := time.NewTicker(sampleInterval)
+		defer ticker.Stop()
+		localPeak := peak
+		for {
+			select {
+			case <-ticker.C:
+				localPeak = max(localPeak, readHeap())
+			case <-done:
+				sampled <- localPeak
+				return
+			}
+		}
+	}()
+
+	return func() {
+		elapsed := time.Since(started)
+		close(done)
+		peak = max(<-sampled, readHeap())
+		rec.add(name, elapsed, peak)
+		rec.bus.Publish(events.Event{Kind: events.StageFinished, Stage: name, Duration: elapsed})
+	}
+}
+
+// add добавляет одно выполнение этапа в агрегированную статистику.
+func (rec *Recorder) add(name string, elapsed time.Duration, peak uint64) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	s, ok := rec.stages[name]
+	if !ok {
+		s = &StageStats{Name: name}
+		rec.stages[name] = s
+		rec.order = append(rec.order, name)
+	}
+	s.Calls++
+	s.Duration += elapsed
+	s.PeakHeapBytes = max(s.PeakHeapBytes, peak)
+}
+
+// Stages возвращает копию статистики этапов в порядке их первого выполнения.
+func (rec *Recorder) Stages() []StageStats {
+	if rec == nil {
+		return nil
+	}
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	stages := make([]StageStats, 0, len(rec.order))
+	for _, name := range rec.order {
+		stages = append(stages, *rec.stages[name])
+	}
+	return stages
+}
+
+// LogSummary выводит в лог сводку по всем этапам: число выполнений,
+// суммарную длительность, долю от общего времени и пиковое потребление памяти.
+func (rec *Recorder) LogSummary() {
+	if rec == nil {
+		return
+	}
+	stages := rec.Stages()
+
+	var total time.Duration
+	for _, s := range stages {
+		total += s.Duration
+	}
+
+	rec.logger.Println("stage telemetry:")
+	for _, s := range stages {
+		var share float64
+		if total > 0 {
+			share = 100 * float64(s.Duration) / float64(total)
+		}
+		rec.logger.Printf("  %-12s calls=%-6d time=%-12s (%5.1f%%) peak_heap=%s\n",
+			s.Name, s.Calls, s.Duration.Round(time.Microsecond), share, FormatBytes(s.PeakHeapBytes))
+	}
+}
+
+// FormatBytes форматирует объем памяти в человекочитаемом виде (B, KiB, MiB, GiB).
+func FormatBytes(b uint64) string {
+	const unit = 1024
+	if b < unit {
+		return fmt.Sprintf("%d B", b)
+	}
+	div, exp := uint64(unit), 0
+	for n := b / unit; n >= unit && exp < 2; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(b)/float64(div), "KMG"[exp])
+}
+
+// readHeap возвращает текущий объем памяти, занятой объектами кучи.
+func readHeap() uint64 {
+	sample := []metrics.Sample{{Name: heapMetric}}
+	metrics.Read(sample)
+	if sample[0].Value.Kind() != metrics.KindUint64 {
+		return 0
+	}
+	return sample[0].Value.Uint64()
+}
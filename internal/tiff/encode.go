@@ -0,0 +1,168 @@
+package tiff
+
+import (
+	"encoding/binary"
+	"fmt"
+	"image"
+	"io"
+	"math"
+)
+
+// Дополнительные теги TIFF, записываемые кодировщиком.
+const (
+	tagImageDescription = 270
+	tagResolutionUnit   = 296
+)
+
+// Значения тега SampleFormat: целые без знака и с плавающей точкой IEEE.
+const (
+	sampleFormatUint  = 1
+	sampleFormatFloat = 3
+)
+
+// Типы значений записей каталога.
+const (
+	typeASCII = 2
+	typeShort = 3
+	typeLong  = 4
+)
+
+// EncodeFloat32 записывает в w одностраничный TIFF с плоскостью values размером
+// width x height (по строкам) в отсчетах float32 с порядком байтов little-endian,
+// без сжатия, одной полосой. Значения NaN сохраняются как есть, поэтому ими удобно
+// отмечать положения без данных. Непустое описание description записывается в тег
+// ImageDescription. Такие файлы открывают ImageJ/Fiji, tifffile и GDAL.
+func EncodeFloat32(w io.Writer, values []float64, width, height int, description string) error {
+	if width <= 0 || height <= 0 || len(values) != width*height {
+		return fmt.Errorf("tiff: %d values do not match image size %dx%d", len(values), width, height)
+	}
+	data := make([]byte, 0, 4*len(values))
+	for _, v := range values {
+		data = binary.LittleEndian.AppendUint32(data, math.Float32bits(float32(v)))
+	}
+	return encode(w, data, width, height, 32, sampleFormatFloat, description)
+}
+
+// EncodeGray записывает в w изображение img в оттенках серого как одностраничный TIFF
+// с 8-битными отсчетами, без сжатия, одной полосой (см. EncodeFloat32).
+func EncodeGray(w io.Writer, img *image.Gray, description string) error {
+	b := img.Bounds()
+	if b.Empty() {
+		return fmt.Errorf("tiff: image %v is empty", b)
+	}
+	data := make([]byte, 0, b.Dx()*b.Dy())
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		i := img.PixOffset(b.Min.X, y)
+		data = append(data, img.Pix[i:i+b.Dx()]...)
+	}
+	return encode(w, data, b.Dx(), b.Dy(), 8, sampleFormatUint, description)
+}
+
+// EncodeGray16 записывает в w изображение img в оттенках серого как одностраничный TIFF
+// с 16-битными отсчетами (little-endian), без сжатия, одной полосой (см. EncodeFloat32).
+func EncodeGray16(w io.Writer, img *image.Gray16, description string) error {
+	b := img.Bounds()
+	if b.Empty() {
+		return fmt.Errorf("tiff: image %v is empty", b)
+	}
+	data := make([]byte, 0, 2*b.Dx()*b.Dy())
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			data = binary.LittleEndian.AppendUint16(data, img.Gray16At(x, y).Y)
+		}
+	}
+	return encode(w, data, b.Dx(), b.Dy(), 16, sampleFormatUint, description)
+}
+
+// encode записывает в w одностраничный TIFF (порядок байтов little-endian) с отсчетами
+// data изображения width x height по bits бит в формате sampleFormat, без сжатия,
+// одной полосой, с описанием description (пустое - без тега ImageDescription).
+func encode(w io.Writer, data []byte, width, height, bits int, sampleFormat uint16, description string) error {
+	order := binary.LittleEndian
+	type entry struct {
+		tag, typ uint16
+		count    uint32
+		value    uint32
+	}
+	const headerSize = 8
+	dataSize := uint32(len(data))
+	dataOffset := uint32(headerSize)
+	// Описание (с завершающим нулем) размещается после данных и выравнивается по слову.
+	descOffset := dataOffset + dataSize
+	desc := []byte(description)
+	if len(desc) > 0 {
+		desc = append(desc, 0)
+	}
+	// Значения до 4 байт размещаются в самой записи каталога.
+	inline := len(desc) <= 4
+	ifdOffset := descOffset
+	if !inline {
+		ifdOffset += uint32(len(desc))
+	}
+	ifdOffset += ifdOffset & 1
+
+	entries := []entry{
+		{tagImageWidth, typeLong, 1, uint32(width)},
+		{tagImageLength, typeLong, 1, uint32(height)},
+		{tagBitsPerSample, typeShort, 1, uint32(bits)},
+		{tagCompression, typeShort, 1, compressionNone},
+		{tagPhotometric, typeShort, 1, photometricBlackIsZero},
+	}
+	if len(desc) > 0 {
+		entries = append(entries, entry{tagImageDescription, typeASCII, uint32(len(desc)), descOffset})
+	}
+	entries = append(entries,
+		entry{tagStripOffsets, typeLong, 1, dataOffset},
+		entry{tagSamplesPerPixel, typeShort, 1, 1},
+		entry{tagRowsPerStrip, typeLong, 1, uint32(height)},
+		entry{tagStripByteCounts, typeLong, 1, dataSize},
+		entry{tagPlanarConfig, typeShort, 1, 1},
+		entry{tagResolutionUnit, typeShort, 1, 1},
+		entry{tagSampleFormat, typeShort, 1, uint32(sampleFormat)},
+	)
+
+	head := make([]byte, 0, headerSize)
+	head = append(head, 'I', 'I')
+	head = order.AppendUint16(head, 42)
+	head = order.AppendUint32(head, ifdOffset)
+	if _, err := w.Write(head); err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	buf := make([]byte, 0, int(ifdOffset-descOffset)+2+12*len(entries)+4)
+	if !inline {
+		buf = append(buf, desc...)
+	}
+	for descOffset+uint32(len(buf)) < ifdOffset {
+		buf = append(buf, 0)
+	}
+	buf = order.AppendUint16(buf, uint16(len(entries)))
+	for _, e := range entries {
+		buf = order.AppendUint16(buf, e.tag)
+		buf = order.AppendUint16(buf, e.typ)
+		buf = order.AppendUint32(buf, e.count)
+		// Значения SHORT и короткие строки размещаются в начале поля значения.
+		switch {
+		case e.typ == typeASCII && inline:
+			buf = append(buf, desc...)
+			buf = append(buf, make([]byte, 4-len(desc))...)
+		case e.typ == typeShort:
+			buf = order.AppendUint16(buf, uint16(e.value))
+			buf = order.AppendUint16(buf, 0)
+		default:
+			buf = order.AppendUint32(buf, e.value)
+		}
+	}
+	// Следующего каталога нет.
+	buf = order.AppendUint32(buf, 0)
+	_, err := w.Write(buf)
+	return err
+}
+
+// MaxFloat32Size возвращает размер в байтах файла EncodeFloat32 для изображения
+// width x height с описанием длиной не более descriptionLen байт.
+func MaxFloat32Size(width, height, descriptionLen int) uint64 {
+	return 8 + 4*uint64(width)*uint64(height) + uint64(descriptionLen) + 2 + 2 + 12*13 + 4
+}
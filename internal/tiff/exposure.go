@@ -0,0 +1,148 @@
+package tiff
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// Теги с экспозицией кадра.
+const (
+	// tagExposureTime - ExposureTime (EXIF), RATIONAL, секунды.
+	tagExposureTime = 33434
+	// tagExifIFD - указатель на каталог EXIF, в котором камеры обычно хранят ExposureTime.
+	tagExifIFD = 34665
+	// tagMicroManager - метаданные Micro-Manager (JSON), экспозиция в поле "Exposure-ms".
+	tagMicroManager = 51123
+)
+
+// maxMetadataBytes ограничивает размер читаемых метаданных Micro-Manager.
+const maxMetadataBytes = 1 << 20
+
+// ExposureTime возвращает экспозицию первой страницы файла TIFF в секундах из тега
+// ExposureTime (33434) первого каталога или каталога EXIF, а при его отсутствии - из поля
+// "Exposure-ms" метаданных Micro-Manager (тег 51123). Читаются только заголовок и каталоги,
+// данные изображения не загружаются. ok = false, если экспозиция в файле не записана.
+func ExposureTime(r io.ReaderAt) (seconds float64, ok bool, err error) {
+	var head [8]byte
+	if _, err := r.ReadAt(head[:], 0); err != nil {
+		return 0, false, fmt.Errorf("tiff: reading header: %w", err)
+	}
+	var order binary.ByteOrder
+	switch string(head[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0, false, errors.New("tiff: invalid byte order mark")
+	}
+	if order.Uint16(head[2:]) != 42 {
+		return 0, false, errors.New("tiff: invalid header (BigTIFF is not supported)")
+	}
+
+	entries, err := readIFD(r, order, int64(order.Uint32(head[4:])))
+	if err != nil {
+		return 0, false, err
+	}
+	var exif, metadata []byte
+	for _, entry := range entries {
+		switch order.Uint16(entry) {
+		case tagExposureTime:
+			return rational(r, order, entry)
+		case tagExifIFD:
+			exif = entry
+		case tagMicroManager:
+			metadata = entry
+		}
+	}
+	if exif != nil {
+		sub, err := readIFD(r, order, int64(order.Uint32(exif[8:])))
+		if err != nil {
+			return 0, false, fmt.Errorf("tiff: EXIF directory: %w", err)
+		}
+		for _, entry := range sub {
+			if order.Uint16(entry) == tagExposureTime {
+				return rational(r, order, entry)
+			}
+		}
+	}
+	if metadata != nil {
+		return microManagerExposure(r, order, metadata)
+	}
+	return 0, false, nil
+}
+
+// readIFD читает записи каталога по смещению offset (по 12 байт на запись).
+func readIFD(r io.ReaderAt, order binary.ByteOrder, offset int64) ([][]byte, error) {
+	if offset < 8 {
+		return nil, errors.New("tiff: image directory is outside the file")
+	}
+	var count [2]byte
+	if _, err := r.ReadAt(count[:], offset); err != nil {
+		return nil, fmt.Errorf("tiff: reading image directory: %w", err)
+	}
+	data := make([]byte, 12*int(order.Uint16(count[:])))
+	if _, err := r.ReadAt(data, offset+2); err != nil {
+		return nil, fmt.Errorf("tiff: image directory is truncated: %w", err)
+	}
+	entries := make([][]byte, len(data)/12)
+	for i := range entries {
+		entries[i] = data[12*i : 12*(i+1)]
+	}
+	return entries, nil
+}
+
+// rational возвращает первое значение записи entry типа RATIONAL (5).
+func rational(r io.ReaderAt, order binary.ByteOrder, entry []byte) (float64, bool, error) {
+	if typ := order.Uint16(entry[2:]); typ != 5 || order.Uint32(entry[4:]) == 0 {
+		return 0, false, fmt.Errorf("tiff: tag %d: expected a RATIONAL value, got type %d", order.Uint16(entry), typ)
+	}
+	var value [8]byte
+	if _, err := r.ReadAt(value[:], int64(order.Uint32(entry[8:]))); err != nil {
+		return 0, false, fmt.Errorf("tiff: tag %d: %w", order.Uint16(entry), err)
+	}
+	numerator, denominator := order.Uint32(value[:]), order.Uint32(value[4:])
+	if denominator == 0 {
+		return 0, false, fmt.Errorf("tiff: tag %d: zero denominator", order.Uint16(entry))
+	}
+	return float64(numerator) / float64(denominator), true, nil
+}
+
+// microManagerExposure возвращает экспозицию из метаданных Micro-Manager (запись entry
+// типа ASCII с объектом JSON), переведенную из миллисекунд в секунды.
+func microManagerExposure(r io.ReaderAt, order binary.ByteOrder, entry []byte) (float64, bool, error) {
+	count := int64(order.Uint32(entry[4:]))
+	if order.Uint16(entry[2:]) != 2 || count <= 4 || count > maxMetadataBytes {
+		return 0, false, nil
+	}
+	data := make([]byte, count)
+	if _, err := r.ReadAt(data, int64(order.Uint32(entry[8:]))); err != nil {
+		return 0, false, fmt.Errorf("tiff: Micro-Manager metadata: %w", err)
+	}
+	for len(data) > 0 && data[len(data)-1] == 0 {
+		data = data[:len(data)-1]
+	}
+	var metadata struct {
+		Exposure json.RawMessage `json:"Exposure-ms"`
+	}
+	if err := json.Unmarshal(data, &metadata); err != nil {
+		return 0, false, fmt.Errorf("tiff: Micro-Manager metadata: %w", err)
+	}
+	if metadata.Exposure == nil {
+		return 0, false, nil
+	}
+	// Разные версии Micro-Manager записывают значение числом или строкой.
+	value := string(metadata.Exposure)
+	if unquoted, err := strconv.Unquote(value); err == nil {
+		value = unquoted
+	}
+	ms, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("tiff: Micro-Manager metadata: invalid Exposure-ms %s", metadata.Exposure)
+	}
+	return ms / 1000, true, nil
+}
@@ -0,0 +1,452 @@
+// Package tiff декодирует изображения TIFF (только стандартная библиотека) и регистрирует
+// формат в пакете image, так что image.Decode и image.DecodeConfig распознают файлы TIFF
+// по содержимому после импорта пакета. Для сохранения карт без квантования пакет
+// также записывает плоскости значений в TIFF с отсчетами float32 (EncodeFloat32).
+//
+// Поддерживается подмножество, которое сохраняют программы регистрации спекл-изображений:
+// первая страница файла, полосы (strips), 8 и 16 бит на отсчет без знака, оттенки серого
+// (BlackIsZero, WhiteIsZero) и RGB/RGBA с покомпонентным чередованием, сжатие отсутствует,
+// PackBits или Deflate (с горизонтальным предсказанием или без). Сжатие LZW, тайловая
+// организация, палитра и отсчеты с плавающей точкой не поддерживаются: для них
+// возвращается ошибка с описанием неподдерживаемого параметра.
+package tiff
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+)
+
+// Теги TIFF, используемые декодером.
+const (
+	tagImageWidth      = 256
+	tagImageLength     = 257
+	tagBitsPerSample   = 258
+	tagCompression     = 259
+	tagPhotometric     = 262
+	tagStripOffsets    = 273
+	tagSamplesPerPixel = 277
+	tagRowsPerStrip    = 278
+	tagStripByteCounts = 279
+	tagPlanarConfig    = 284
+	tagPredictor       = 317
+	tagTileWidth       = 322
+	tagSampleFormat    = 339
+)
+
+// Способы сжатия (тег Compression).
+const (
+	compressionNone     = 1
+	compressionLZW      = 5
+	compressionDeflate  = 8
+	compressionDeflate2 = 32946
+	compressionPackBits = 32773
+)
+
+// Цветовые модели (тег PhotometricInterpretation).
+const (
+	photometricWhiteIsZero = 0
+	photometricBlackIsZero = 1
+	photometricRGB         = 2
+)
+
+// maxImageBytes ограничивает размер несжатых данных изображения: размеры из заголовка
+// поврежденного файла иначе приводят к выделению памяти, которое завершает процесс.
+const maxImageBytes = 1 << 30
+
+func init() {
+	image.RegisterFormat("tiff", "II*\x00", Decode, DecodeConfig)
+	image.RegisterFormat("tiff", "MM\x00*", Decode, DecodeConfig)
+}
+
+// header - разобранный каталог (IFD) первой страницы файла.
+type header struct {
+	order         binary.ByteOrder
+	width, height int
+	bits          int
+	samples       int
+	compression   int
+	photometric   int
+	predictor     int
+	rowsPerStrip  int
+	offsets       []int
+	counts        []int
+}
+
+// Decode декодирует первую страницу файла TIFF. Оттенки серого возвращаются как
+// *image.Gray или *image.Gray16, RGB - как *image.NRGBA или *image.NRGBA64.
+func Decode(r io.Reader) (image.Image, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	h, err := parse(data)
+	if err != nil {
+		return nil, err
+	}
+	rowBytes := h.width * h.samples * h.bits / 8
+	// Буфер растет по мере распаковки полос, а не по размерам из заголовка.
+	var pix []byte
+	for i, offset := range h.offsets {
+		if offset < 0 || h.counts[i] < 0 || offset+h.counts[i] > len(data) {
+			return nil, fmt.Errorf("tiff: strip %d is outside the file", i)
+		}
+		rows := min(h.rowsPerStrip, h.height-i*h.rowsPerStrip)
+		if rows <= 0 {
+			break
+		}
+		strip, err := decompress(data[offset:offset+h.counts[i]], h.compression, rows*rowBytes)
+		if err != nil {
+			return nil, fmt.Errorf("tiff: strip %d: %w", i, err)
+		}
+		if h.predictor == 2 {
+			undoPredictor(strip, rowBytes, h.samples, h.bits, h.order)
+		}
+		pix = append(pix, strip...)
+	}
+	if len(pix) < rowBytes*h.height {
+		return nil, fmt.Errorf("tiff: image data is truncated: %d of %d bytes", len(pix), rowBytes*h.height)
+	}
+	return h.toImage(pix, rowBytes), nil
+}
+
+// DecodeConfig возвращает размеры и цветовую модель первой страницы файла TIFF.
+func DecodeConfig(r io.Reader) (image.Config, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return image.Config{}, err
+	}
+	h, err := parse(data)
+	if err != nil {
+		return image.Config{}, err
+	}
+	model := color.Model(color.GrayModel)
+	switch {
+	case h.photometric == photometricRGB && h.bits == 16:
+		model = color.NRGBA64Model
+	case h.photometric == photometricRGB:
+		model = color.NRGBAModel
+	case h.bits == 16:
+		model = color.Gray16Model
+	}
+	return image.Config{ColorModel: model, Width: h.width, Height: h.height}, nil
+}
+
+// parse разбирает заголовок и первый каталог файла и проверяет, что изображение поддерживается.
+func parse(data []byte) (*header, error) {
+	if len(data) < 8 {
+		return nil, errors.New("tiff: file is too short")
+	}
+	h := &header{samples: 1, compression: compressionNone, photometric: photometricBlackIsZero, predictor: 1}
+	switch string(data[:2]) {
+	case "II":
+		h.order = binary.LittleEndian
+	case "MM":
+		h.order = binary.BigEndian
+	default:
+		return nil, errors.New("tiff: invalid byte order mark")
+	}
+	if h.order.Uint16(data[2:]) != 42 {
+		return nil, errors.New("tiff: invalid header (BigTIFF is not supported)")
+	}
+	ifd := int(h.order.Uint32(data[4:]))
+	if ifd < 8 || ifd+2 > len(data) {
+		return nil, errors.New("tiff: image directory is outside the file")
+	}
+	entries := int(h.order.Uint16(data[ifd:]))
+	if ifd+2+entries*12 > len(data) {
+		return nil, errors.New("tiff: image directory is truncated")
+	}
+
+	sampleFormat, planar := 1, 1
+	h.rowsPerStrip = -1
+	var bits []int
+	for e := range entries {
+		entry := data[ifd+2+e*12 : ifd+2+(e+1)*12]
+		tag := int(h.order.Uint16(entry))
+		values, err := h.values(data, entry)
+		if err != nil {
+			return nil, fmt.Errorf("tiff: tag %d: %w", tag, err)
+		}
+		if len(values) == 0 {
+			continue
+		}
+		switch tag {
+		case tagImageWidth:
+			h.width = values[0]
+		case tagImageLength:
+			h.height = values[0]
+		case tagBitsPerSample:
+			bits = values
+		case tagCompression:
+			h.compression = values[0]
+		case tagPhotometric:
+			h.photometric = values[0]
+		case tagStripOffsets:
+			h.offsets = values
+		case tagSamplesPerPixel:
+			h.samples = values[0]
+		case tagRowsPerStrip:
+			h.rowsPerStrip = values[0]
+		case tagStripByteCounts:
+			h.counts = values
+		case tagPlanarConfig:
+			planar = values[0]
+		case tagPredictor:
+			h.predictor = values[0]
+		case tagTileWidth:
+			return nil, errors.New("tiff: tiled images are not supported")
+		case tagSampleFormat:
+			sampleFormat = values[0]
+		}
+	}
+
+	if h.width <= 0 || h.height <= 0 {
+		return nil, fmt.Errorf("tiff: invalid image size %dx%d", h.width, h.height)
+	}
+	h.bits = 1
+	if len(bits) > 0 {
+		h.bits = bits[0]
+	}
+	for _, b := range bits {
+		if b != h.bits {
+			return nil, fmt.Errorf("tiff: samples of different bit depth %v are not supported", bits)
+		}
+	}
+	if h.bits != 8 && h.bits != 16 {
+		return nil, fmt.Errorf("tiff: %d bits per sample are not supported, expected 8 or 16", h.bits)
+	}
+	if sampleFormat != 1 {
+		return nil, fmt.Errorf("tiff: sample format %d is not supported, expected unsigned integers", sampleFormat)
+	}
+	if planar != 1 {
+		return nil, errors.New("tiff: planar sample layout is not supported")
+	}
+	switch h.photometric {
+	case photometricWhiteIsZero, photometricBlackIsZero:
+		if h.samples != 1 {
+			return nil, fmt.Errorf("tiff: grayscale image with %d samples per pixel is not supported", h.samples)
+		}
+	case photometricRGB:
+		if h.samples != 3 && h.samples != 4 {
+			return nil, fmt.Errorf("tiff: RGB image with %d samples per pixel is not supported", h.samples)
+		}
+	default:
+		return nil, fmt.Errorf("tiff: photometric interpretation %d is not supported", h.photometric)
+	}
+	switch h.compression {
+	case compressionNone, compressionDeflate, compressionDeflate2, compressionPackBits:
+	case compressionLZW:
+		return nil, errors.New("tiff: LZW compression is not supported, save the frames uncompressed or with Deflate")
+	default:
+		return nil, fmt.Errorf("tiff: compression %d is not supported", h.compression)
+	}
+	if h.predictor != 1 && h.predictor != 2 {
+		return nil, fmt.Errorf("tiff: predictor %d is not supported", h.predictor)
+	}
+	if len(h.offsets) == 0 || len(h.offsets) != len(h.counts) {
+		return nil, errors.New("tiff: strip offsets and byte counts are missing or inconsistent")
+	}
+	if h.rowsPerStrip <= 0 || h.rowsPerStrip > h.height {
+		h.rowsPerStrip = h.height
+	}
+	// Размеры проверяются по отдельности, чтобы произведение не переполнилось.
+	rowBytes := h.width * h.samples * h.bits / 8
+	if h.width > maxImageBytes || h.height > maxImageBytes || rowBytes > maxImageBytes/h.height {
+		return nil, fmt.Errorf("tiff: image size %dx%d exceeds the %d MiB limit", h.width, h.height, maxImageBytes>>20)
+	}
+	if h.compression == compressionNone {
+		var total int
+		for _, count := range h.counts {
+			total += count
+		}
+		if total < rowBytes*h.height {
+			return nil, fmt.Errorf("tiff: strips hold %d bytes, image size %dx%d needs %d", total, h.width, h.height, rowBytes*h.height)
+		}
+	}
+	return h, nil
+}
+
+// values возвращает целочисленные значения записи каталога entry (типы BYTE, SHORT, LONG);
+// значения других типов не используются декодером и пропускаются.
+func (h *header) values(data, entry []byte) ([]int, error) {
+	typ := h.order.Uint16(entry[2:])
+	count := int(h.order.Uint32(entry[4:]))
+	var size int
+	switch typ {
+	case 1:
+		size = 1
+	case 3:
+		size = 2
+	case 4:
+		size = 4
+	default:
+		return nil, nil
+	}
+	if count < 0 || count > len(data)/size {
+		return nil, fmt.Errorf("invalid value count %d", count)
+	}
+	raw := entry[8:12]
+	if count*size > 4 {
+		offset := int(h.order.Uint32(entry[8:]))
+		if offset < 0 || offset+count*size > len(data) {
+			return nil, errors.New("values are outside the file")
+		}
+		raw = data[offset : offset+count*size]
+	}
+	values := make([]int, count)
+	for i := range values {
+		switch size {
+		case 1:
+			values[i] = int(raw[i])
+		case 2:
+			values[i] = int(h.order.Uint16(raw[2*i:]))
+		case 4:
+			values[i] = int(h.order.Uint32(raw[4*i:]))
+		}
+	}
+	return values, nil
+}
+
+// decompress распаковывает полосу data, ожидая size байт несжатых данных; распакованные
+// данные сверх size не читаются.
+func decompress(data []byte, compression, size int) ([]byte, error) {
+	switch compression {
+	case compressionDeflate, compressionDeflate2:
+		zr, err := zlib.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		out, err := io.ReadAll(io.LimitReader(zr, int64(size)))
+		if err != nil {
+			return nil, err
+		}
+		if len(out) < size {
+			return nil, fmt.Errorf("deflate data has %d bytes, expected %d", len(out), size)
+		}
+		return out, nil
+	case compressionPackBits:
+		return unpackBits(data, size)
+	}
+	if len(data) < size {
+		return nil, fmt.Errorf("strip has %d bytes, expected %d", len(data), size)
+	}
+	return data[:size], nil
+}
+
+// unpackBits распаковывает данные, сжатые PackBits: байт n в [0, 127] - далее n+1 байт
+// без сжатия, n в [-127, -1] - повтор следующего байта 1-n раз, -128 - пропуск.
+func unpackBits(data []byte, size int) ([]byte, error) {
+	var out []byte
+	for i := 0; i < len(data) && len(out) < size; {
+		n := int(int8(data[i]))
+		i++
+		switch {
+		case n >= 0:
+			if i+n+1 > len(data) {
+				return nil, errors.New("packbits literal run is truncated")
+			}
+			out = append(out, data[i:i+n+1]...)
+			i += n + 1
+		case n != -128:
+			if i >= len(data) {
+				return nil, errors.New("packbits repeat run is truncated")
+			}
+			for range 1 - n {
+				out = append(out, data[i])
+			}
+			i++
+		}
+	}
+	if len(out) < size {
+		return nil, fmt.Errorf("packbits data has %d bytes, expected %d", len(out), size)
+	}
+	return out[:size], nil
+}
+
+// undoPredictor восстанавливает отсчеты после горизонтального предсказания (Predictor = 2):
+// каждый отсчет строки хранится как разность с тем же компонентом предыдущего пикселя.
+func undoPredictor(strip []byte, rowBytes, samples, bits int, order binary.ByteOrder) {
+	for row := 0; row+rowBytes <= len(strip); row += rowBytes {
+		line := strip[row : row+rowBytes]
+		if bits == 8 {
+			for i := samples; i < len(line); i++ {
+				line[i] += line[i-samples]
+			}
+			continue
+		}
+		for i := 2 * samples; i+1 < len(line); i += 2 {
+			order.PutUint16(line[i:], order.Uint16(line[i:])+order.Uint16(line[i-2*samples:]))
+		}
+	}
+}
+
+// toImage создает изображение из несжатых строк pix длиной rowBytes.
+func (h *header) toImage(pix []byte, rowBytes int) image.Image {
+	rect := image.Rect(0, 0, h.width, h.height)
+	invert := h.photometric == photometricWhiteIsZero
+	switch {
+	case h.photometric == photometricRGB && h.bits == 8:
+		img := image.NewNRGBA(rect)
+		for y := range h.height {
+			line := pix[y*rowBytes:]
+			for x := range h.width {
+				s := line[x*h.samples:]
+				a := uint8(255)
+				if h.samples == 4 {
+					a = s[3]
+				}
+				img.SetNRGBA(x, y, color.NRGBA{R: s[0], G: s[1], B: s[2], A: a})
+			}
+		}
+		return img
+	case h.photometric == photometricRGB:
+		img := image.NewNRGBA64(rect)
+		for y := range h.height {
+			line := pix[y*rowBytes:]
+			for x := range h.width {
+				s := line[2*x*h.samples:]
+				a := uint16(0xffff)
+				if h.samples == 4 {
+					a = h.order.Uint16(s[6:])
+				}
+				img.SetNRGBA64(x, y, color.NRGBA64{
+					R: h.order.Uint16(s), G: h.order.Uint16(s[2:]), B: h.order.Uint16(s[4:]), A: a,
+				})
+			}
+		}
+		return img
+	case h.bits == 8:
+		img := image.NewGray(rect)
+		for y := range h.height {
+			row := img.Pix[y*img.Stride : y*img.Stride+h.width]
+			copy(row, pix[y*rowBytes:])
+			if invert {
+				for x := range row {
+					row[x] = 255 - row[x]
+				}
+			}
+		}
+		return img
+	default:
+		img := image.NewGray16(rect)
+		for y := range h.height {
+			line := pix[y*rowBytes:]
+			row := img.Pix[y*img.Stride:]
+			for x := range h.width {
+				v := h.order.Uint16(line[2*x:])
+				if invert {
+					v = 0xffff - v
+				}
+				binary.BigEndian.PutUint16(row[2*x:], v)
+			}
+		}
+		return img
+	}
+}
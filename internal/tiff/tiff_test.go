@@ -0,0 +1,71 @@
+package tiff
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"testing"
+)
+
+// encodeFrame возвращает кадр 7x5 с 16-битными отсчетами, записанный EncodeGray16.
+func encodeFrame(t *testing.T) []byte {
+	t.Helper()
+	img := image.NewGray16(image.Rect(0, 0, 7, 5))
+	for i := range img.Pix {
+		img.Pix[i] = byte(i)
+	}
+	var buf bytes.Buffer
+	if err := EncodeGray16(&buf, img, ""); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// setTag заменяет значение записи tag первого каталога файла data на value.
+func setTag(t *testing.T, data []byte, tag uint16, value uint32) {
+	t.Helper()
+	order := binary.LittleEndian
+	ifd := int(order.Uint32(data[4:]))
+	for e := range int(order.Uint16(data[ifd:])) {
+		entry := data[ifd+2+e*12:]
+		if order.Uint16(entry) == tag {
+			order.PutUint32(entry[8:], value)
+			return
+		}
+	}
+	t.Fatalf("tag %d not found", tag)
+}
+
+// TestDecodeRejectsCorruptSize проверяет, что размеры и полосы из поврежденного заголовка
+// отклоняются с ошибкой до выделения памяти под изображение.
+func TestDecodeRejectsCorruptSize(t *testing.T) {
+	if _, err := Decode(bytes.NewReader(encodeFrame(t))); err != nil {
+		t.Fatalf("valid frame: %v", err)
+	}
+	for _, c := range []struct {
+		name   string
+		change func(data []byte)
+	}{
+		{"huge size", func(data []byte) {
+			setTag(t, data, tagImageWidth, 0xFFFFFFFF)
+			setTag(t, data, tagImageLength, 0xFFFFFFFF)
+		}},
+		{"size beyond strips", func(data []byte) {
+			setTag(t, data, tagImageLength, 50000)
+		}},
+		{"truncated strip", func(data []byte) {
+			setTag(t, data, tagStripByteCounts, 7*5)
+		}},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			data := encodeFrame(t)
+			c.change(data)
+			if _, err := Decode(bytes.NewReader(data)); err == nil {
+				t.Error("Decode accepted a corrupt header")
+			}
+			if _, err := DecodeConfig(bytes.NewReader(data)); err == nil {
+				t.Error("DecodeConfig accepted a corrupt header")
+			}
+		})
+	}
+}
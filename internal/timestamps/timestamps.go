@@ -0,0 +1,132 @@
+// Package timestamps загружает и проверяет реальные времена регистрации кадров.
+// Для запусков с внешним триггером кадры идут неравномерно, и предположение
+// о постоянном межкадровом интервале приводит к ошибкам во временном анализе.
+package timestamps
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/mascotmascot1/go-tlasca/internal/framedata"
+)
+
+// gapFactor задает, во сколько раз интервал должен превышать медианный,
+// чтобы считаться пропуском кадров.
+const gapFactor = 1.5
+
+// Timeline сопоставляет номер кадра (число из имени файла, например 10 для "10.png")
+// с временем его регистрации в секундах.
+type Timeline struct {
+	times framedata.Values
+}
+
+// Summary содержит сводку по межкадровым интервалам последовательности.
+type Summary struct {
+	// Frames - число кадров с временными метками.
+	Frames int `json:"frames"`
+	// Duration - длительность записи от первого до последнего кадра в секундах.
+	Duration float64 `json:"duration_s"`
+	// MeanInterval, MedianInterval, MinInterval, MaxInterval - статистика интервалов в секундах.
+	MeanInterval   float64 `json:"mean_interval_s"`
+	MedianInterval float64 `json:"median_interval_s"`
+	MinInterval    float64 `json:"min_interval_s"`
+	MaxInterval    float64 `json:"max_interval_s"`
+	// Jitter - коэффициент вариации интервалов (std/mean); 0 для равномерной съемки.
+	Jitter float64 `json:"jitter"`
+	// Gaps - число интервалов, превышающих медианный в gapFactor раз (вероятные пропуски кадров).
+	Gaps int `json:"gaps"`
+}
+
+// Load читает файл временных меток в формате CSV: в каждой строке номер кадра и время в секундах
+// (например, "10,0.125"). Формат файла описан в framedata.Load.
+func Load(path string) (*Timeline, error) {
+	values, err := framedata.Load(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Timeline{times: values}, nil
+}
+
+// Times возвращает времена кадров frames (в том же порядке).
+// Возвращает ошибку, если для какого-либо кадра нет метки или времена
+// не возрастают строго вдоль последовательности.
+func (tl *Timeline) Times(frames []int) ([]float64, error) {
+	times, err := tl.times.Lookup(frames)
+	if err != nil {
+		return nil, err
+	}
+	for i := 1; i < len(times); i++ {
+		if times[i] <= times[i-1] {
+			return nil, fmt.Errorf("timestamps are not strictly increasing at frame %d (%g <= %g)", frames[i], times[i], times[i-1])
+		}
+	}
+	return times, nil
+}
+
+// Summarize вычисляет сводку межкадровых интервалов для строго возрастающих времен.
+func Summarize(times []float64) Summary {
+	s := Summary{Frames: len(times)}
+	if len(times) < 2 {
+		return s
+	}
+
+	intervals := make([]float64, len(times)-1)
+	var sum float64
+	for i := 1; i < len(times); i++ {
+		intervals[i-1] = times[i] - times[i-1]
+		sum += intervals[i-1]
+	}
+	s.Duration = times[len(times)-1] - times[0]
+	s.MeanInterval = sum / float64(len(intervals))
+
+	var sumDiff2 float64
+	for _, d := range intervals {
+		diff := d - s.MeanInterval
+		sumDiff2 += diff * diff
+	}
+	s.Jitter = math.Sqrt(sumDiff2/float64(len(intervals))) / s.MeanInterval
+
+	sorted := append([]float64(nil), intervals...)
+	sort.Float64s(sorted)
+	s.MinInterval, s.MaxInterval = sorted[0], sorted[len(sorted)-1]
+	s.MedianInterval = sorted[len(sorted)/2]
+	if len(sorted)%2 == 0 {
+		s.MedianInterval = (sorted[len(sorted)/2-1] + sorted[len(sorted)/2]) / 2
+	}
+	for _, d := range intervals {
+		if d > gapFactor*s.MedianInterval {
+			s.Gaps++
+		}
+	}
+	return s
+}
+
+// Resample приводит ряд values, измеренный в строго возрастающие моменты times, к равномерной
+// сетке times[0] + k·step (k = 0, 1, ..., пока момент не превышает последний из times) линейной
+// интерполяцией между соседними отсчетами. Так анализы, предполагающие постоянный шаг
+// (спектр, взаимная корреляция), учитывают реальные моменты регистрации кадров.
+func Resample(times, values []float64, step float64) []float64 {
+	if len(times) == 0 || step <= 0 {
+		return nil
+	}
+	span := times[len(times)-1] - times[0]
+	// Допуск на погрешность округления, чтобы последний момент равномерной записи не терялся.
+	n := int(math.Floor(span/step+1e-9)) + 1
+	out := make([]float64, n)
+	j := 0
+	for k := range out {
+		t := times[0] + float64(k)*step
+		for j+1 < len(times)-1 && times[j+1] <= t {
+			j++
+		}
+		if j+1 >= len(times) {
+			out[k] = values[j]
+			continue
+		}
+		frac := (t - times[j]) / (times[j+1] - times[j])
+		frac = min(max(frac, 0), 1)
+		out[k] = values[j] + frac*(values[j+1]-values[j])
+	}
+	return out
+}
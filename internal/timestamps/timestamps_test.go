@@ -0,0 +1,26 @@
+package timestamps
+
+import (
+	"math"
+	"testing"
+)
+
+// TestResample проверяет, что линейный ряд, измеренный в неравномерные моменты,
+// переносится на равномерную сетку без искажений, включая последний момент записи.
+func TestResample(t *testing.T) {
+	times := []float64{1, 1.1, 1.35, 1.4, 1.7, 2}
+	values := make([]float64, len(times))
+	for i, v := range times {
+		values[i] = 3*v - 1
+	}
+	got := Resample(times, values, 0.1)
+	if len(got) != 11 {
+		t.Fatalf("len = %d, want 11", len(got))
+	}
+	for k, v := range got {
+		want := 3*(1+0.1*float64(k)) - 1
+		if math.Abs(v-want) > 1e-9 {
+			t.Errorf("value %d = %g, want %g", k, v, want)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+// Package vasomotion выполняет частотный анализ временных рядов индекса кровотока:
+// рассчитывает спектр мощности и находит пик в полосе вазомоций - медленных
+// (обычно около 0.1 Гц) колебаний тонуса сосудов.
+package vasomotion
+
+import (
+	"encoding/csv"
+	"io"
+	"math"
+	"math/cmplx"
+	"strconv"
+
+	"github.com/mascotmascot1/go-tlasca/internal/atomicfile"
+	"github.com/mascotmascot1/go-tlasca/pkg/units"
+)
+
+// Spectrum - односторонний амплитудный спектр временного ряда.
+type Spectrum struct {
+	// Frequencies - частоты отсчетов спектра в Гц (от 0 до частоты Найквиста).
+	Frequencies []float64
+	// Amplitude - амплитуда синусоидальной составляющей на каждой частоте
+	// в единицах исходного ряда.
+	Amplitude []float64
+}
+
+// Power возвращает мощность (средний квадрат) составляющей i: A²/2.
+func (s Spectrum) Power(i int) float64 {
+	return s.Amplitude[i] * s.Amplitude[i] / 2
+}
+
+// Peak описывает пик спектра области в полосе вазомоций.
+type Peak struct {
+	// Region - имя области интереса.
+	Region string `json:"region"`
+	// Frequency - частота пика в Гц.
+	Frequency float64 `json:"frequency_hz"`
+	// Amplitude - амплитуда колебаний на частоте пика в единицах индекса кровотока.
+	Amplitude float64 `json:"amplitude"`
+	// BandFraction - доля мощности полосы в полной мощности колебаний ряда (без постоянной составляющей).
+	BandFraction float64 `json:"band_fraction"`
+}
+
+// PowerSpectrum вычисляет спектр ряда series, снятого с частотой sampleRate Гц.
+// Перед преобразованием из ряда вычитается среднее и применяется окно Ханна,
+// ослабляющее утечку спектра; амплитуды нормированы на сумму весов окна,
+// так что синусоида амплитуды A дает пик высотой около A.
+// Разрешение по частоте равно sampleRate / len(series).
+func PowerSpectrum(series []float64, sampleRate float64) Spectrum {
+	n := len(series)
+	var mean float64
+	for _, v := range series {
+		mean += v
+	}
+	mean /= float64(n)
+
+	windowed := make([]float64, n)
+	var windowSum float64
+	for i, v := range series {
+		w := 0.5
+		if n > 1 {
+			w = 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/float64(n-1))
+		}
+		windowed[i] = (v - mean) * w
+		windowSum += w
+	}
+
+	bins := n/2 + 1
+	spec := Spectrum{Frequencies: make([]float64, bins), Amplitude: make([]float64, bins)}
+	for k := 0; k < bins; k++ {
+		// Прямое ДПФ: длины рядов (сотни - тысячи кадров) позволяют обойтись без БПФ.
+		var sum complex128
+		for i, v := range windowed {
+			sum += complex(v, 0) * cmplx.Exp(complex(0, -2*math.Pi*float64(k*i)/float64(n)))
+		}
+		amplitude := cmplx.Abs(sum) / windowSum
+		if k > 0 && !(n%2 == 0 && k == n/2) {
+			// Односторонний спектр: мощность отрицательных частот переносится на положительные.
+			amplitude *= 2
+		}
+		spec.Frequencies[k] = float64(k) * sampleRate / float64(n)
+		spec.Amplitude[k] = amplitude
+	}
+	return spec
+}
+
+// FindPeak находит составляющую с наибольшей амплитудой в полосе [bandMin, bandMax] Гц.
+// Возвращает false, если в полосу не попадает ни одного отсчета спектра
+// (запись слишком коротка для заданной полосы).
+func FindPeak(region string, spec Spectrum, bandMin, bandMax float64) (Peak, bool) {
+	peak := Peak{Region: region}
+	best := -1
+	var bandPower, totalPower float64
+	for i, f := range spec.Frequencies {
+		if i == 0 {
+			continue
+		}
+		totalPower += spec.Power(i)
+		if f < bandMin || f > bandMax {
+			continue
+		}
+		bandPower += spec.Power(i)
+		if best < 0 || spec.Amplitude[i] > spec.Amplitude[best] {
+			best = i
+		}
+	}
+	if best < 0 {
+		return peak, false
+	}
+	peak.Frequency = spec.Frequencies[best]
+	peak.Amplitude = spec.Amplitude[best]
+	if totalPower > 0 {
+		peak.BandFraction = bandPower / totalPower
+	}
+	return peak, true
+}
+
+// FlowIndex преобразует ряд контраста K в ряд индекса кровотока 1/K²
+// (0 для отсчетов с нулевым контрастом).
+func FlowIndex(contrast []float64) []float64 {
+	flow := make([]float64, len(contrast))
+	for i, k := range contrast {
+		if k > 0 {
+			flow[i] = 1 / (k * k)
+		}
+	}
+	return flow
+}
+
+// WriteCSV сохраняет спектры областей в CSV-файл со столбцами region, frequency_hz, amplitude, power.
+func WriteCSV(path string, names []string, spectra []Spectrum) error {
+	return atomicfile.Write(path, func(file io.Writer) error {
+		w := csv.NewWriter(file)
+		if err := w.Write([]string{"region", units.Column("frequency", units.Hertz), "amplitude", "power"}); err != nil {
+			return err
+		}
+		for r, spec := range spectra {
+			for i, f := range spec.Frequencies {
+				record := []string{
+					names[r],
+					strconv.FormatFloat(f, 'g', 6, 64),
+					strconv.FormatFloat(spec.Amplitude[i], 'g', 6, 64),
+					strconv.FormatFloat(spec.Power(i), 'g', 6, 64),
+				}
+				if err := w.Write(record); err != nil {
+					return err
+				}
+			}
+		}
+		w.Flush()
+		return w.Error()
+	})
+}
@@ -0,0 +1,165 @@
+// Package video читает кадры видеофайла (MP4, AVI и других форматов, поддерживаемых ffmpeg)
+// через внешние программы ffprobe и ffmpeg: ffprobe определяет размеры и формат пикселей
+// видеопотока, а ffmpeg декодирует поток и выдает кадры в стандартный вывод в виде
+// несжатых отсчетов яркости, которые читаются кадр за кадром.
+package video
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/mascotmascot1/go-tlasca/internal/imageutils"
+)
+
+// Info описывает видеопоток файла.
+type Info struct {
+	// Width, Height - размеры кадра.
+	Width, Height int
+	// PixelFormat - формат пикселей потока в обозначениях ffmpeg ("gray", "yuv420p", "gray12le").
+	PixelFormat string
+	// BitDepth - разрядность выдаваемых кадров: 8 или 16 бит (для потоков с отсчетами больше 8 бит).
+	BitDepth int
+}
+
+// depthPattern выделяет разрядность отсчета из обозначения формата пикселей ("yuv420p10le" - 10).
+var depthPattern = regexp.MustCompile(`(\d+)(le|be)$`)
+
+// Probe определяет размеры и формат пикселей первого видеопотока файла path
+// с помощью программы ffprobe (путь или имя в PATH).
+func Probe(ffprobe, path string) (Info, error) {
+	cmd := exec.Command(ffprobe, "-v", "error", "-select_streams", "v:0",
+		"-show_entries", "stream=width,height,pix_fmt", "-of", "default=noprint_wrappers=1", path)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return Info{}, fmt.Errorf("ffprobe failed for '%s': %w%s", path, err, details(stderr.String()))
+	}
+	info := Info{BitDepth: 8}
+	for _, line := range strings.Split(string(out), "\n") {
+		key, value, ok := strings.Cut(strings.TrimSpace(line), "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "width":
+			info.Width, err = strconv.Atoi(value)
+		case "height":
+			info.Height, err = strconv.Atoi(value)
+		case "pix_fmt":
+			info.PixelFormat = value
+		}
+		if err != nil {
+			return Info{}, fmt.Errorf("invalid ffprobe %s '%s' for '%s': %w", key, value, path, err)
+		}
+	}
+	if info.Width <= 0 || info.Height <= 0 {
+		return Info{}, fmt.Errorf("no video stream found in '%s'", path)
+	}
+	if m := depthPattern.FindStringSubmatch(info.PixelFormat); m != nil {
+		if depth, _ := strconv.Atoi(m[1]); depth > 8 {
+			info.BitDepth = 16
+		}
+	}
+	return info, nil
+}
+
+// Extract декодирует видеопоток файла path программой ffmpeg (путь или имя в PATH)
+// и сохраняет кадры в директорию dir как PNG-файлы "1.png", "2.png", ... в оттенках серого
+// разрядности info.BitDepth (цветные кадры приводятся к яркости). Кадры читаются из вывода
+// ffmpeg по одному, поэтому в памяти находится только текущий кадр. progress (если не nil)
+// вызывается после каждого сохраненного кадра с числом сохраненных кадров.
+// Возвращает число сохраненных кадров.
+func Extract(ffmpeg, path string, info Info, dir string, progress func(frames int)) (int, error) {
+	pixFmt := "gray"
+	if info.BitDepth == 16 {
+		// Порядок байтов big-endian совпадает с порядком отсчетов image.Gray16.
+		pixFmt = "gray16be"
+	}
+	cmd := exec.Command(ffmpeg, "-v", "error", "-nostdin", "-i", path,
+		"-map", "0:v:0", "-f", "rawvideo", "-pix_fmt", pixFmt, "-")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return 0, err
+	}
+	if err = cmd.Start(); err != nil {
+		return 0, fmt.Errorf("failed to start ffmpeg: %w", err)
+	}
+
+	frames, readErr := readFrames(bufio.NewReaderSize(stdout, 1<<20), info, dir, progress)
+	if readErr != nil {
+		// Прерываем декодирование, чтобы ffmpeg не блокировался на записи в закрытый канал.
+		_ = cmd.Process.Kill()
+	}
+	waitErr := cmd.Wait()
+	if readErr != nil {
+		return frames, readErr
+	}
+	if waitErr != nil {
+		return frames, fmt.Errorf("ffmpeg failed for '%s': %w%s", path, waitErr, details(stderr.String()))
+	}
+	if frames == 0 {
+		return 0, fmt.Errorf("no frames decoded from '%s'%s", path, details(stderr.String()))
+	}
+	return frames, nil
+}
+
+// readFrames читает из r кадры размера info и сохраняет их в dir.
+func readFrames(r io.Reader, info Info, dir string, progress func(frames int)) (int, error) {
+	rect := image.Rect(0, 0, info.Width, info.Height)
+	var img image.Image
+	var pix []byte
+	if info.BitDepth == 16 {
+		gray := image.NewGray16(rect)
+		img, pix = gray, gray.Pix
+	} else {
+		gray := image.NewGray(rect)
+		img, pix = gray, gray.Pix
+	}
+	frames := 0
+	for {
+		if _, err := io.ReadFull(r, pix); err != nil {
+			if err == io.EOF {
+				return frames, nil
+			}
+			if err == io.ErrUnexpectedEOF {
+				return frames, fmt.Errorf("truncated frame %d in ffmpeg output", frames+1)
+			}
+			return frames, fmt.Errorf("error reading ffmpeg output: %w", err)
+		}
+		frames++
+		framePath := filepath.Join(dir, strconv.Itoa(frames)+".png")
+		if err := imageutils.SavePNG(framePath, img); err != nil {
+			return frames, fmt.Errorf("error saving frame %d to '%s': %w", frames, framePath, err)
+		}
+		if progress != nil {
+			progress(frames)
+		}
+	}
+}
+
+// details возвращает последнюю строку диагностического вывода программы для сообщения об ошибке.
+func details(stderr string) string {
+	lines := strings.Split(strings.TrimSpace(stderr), "\n")
+	if last := strings.TrimSpace(lines[len(lines)-1]); last != "" {
+		return ": " + last
+	}
+	return ""
+}
+
+// TempDir создает временную директорию для кадров видеофайла path.
+func TempDir(path string) (string, error) {
+	stem := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	return os.MkdirTemp("", "tlasca-video-"+stem+"-*")
+}
@@ -0,0 +1,62 @@
+// Package worldfile записывает файлы привязки (world files, как у GeoTIFF/ESRI) -
+// текстовые спутники изображений, задающие соответствие пикселей координатам столика
+// микроскопа. По ним карты, снятые в разных положениях, можно импортировать и совместить
+// в просмотрщиках с учетом реального физического положения.
+package worldfile
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/mascotmascot1/go-tlasca/internal/atomicfile"
+)
+
+// Transform задает аффинное преобразование пиксель -> координаты столика без поворота:
+//
+//	X = OriginX + x * PixelSize
+//	Y = OriginY + y * PixelSize
+//
+// где (x, y) - координаты центра пикселя изображения (центр верхнего левого пикселя - (0, 0)).
+type Transform struct {
+	// PixelSize - размер пикселя в единицах координат столика.
+	PixelSize float64
+	// OriginX и OriginY - координаты центра верхнего левого пикселя.
+	OriginX, OriginY float64
+}
+
+// Offset возвращает преобразование для изображения, начало которого смещено
+// на (dx, dy) пикселей исходного (например, карта окон относительно кадра).
+func (t Transform) Offset(dx, dy float64) Transform {
+	return Transform{
+		PixelSize: t.PixelSize,
+		OriginX:   t.OriginX + dx*t.PixelSize,
+		OriginY:   t.OriginY + dy*t.PixelSize,
+	}
+}
+
+// SidecarPath возвращает путь к файлу привязки для изображения по принятому соглашению:
+// расширение образуется из первой и последней букв расширения изображения и буквы "w"
+// (result.png -> result.pgw, map.tif -> map.tfw).
+func SidecarPath(imagePath string) string {
+	ext := filepath.Ext(imagePath)
+	base := strings.TrimSuffix(imagePath, ext)
+	ext = strings.TrimPrefix(ext, ".")
+	if len(ext) < 2 {
+		return base + ".wld"
+	}
+	return base + "." + ext[:1] + ext[len(ext)-1:] + "w"
+}
+
+// Write сохраняет преобразование в файл привязки path в стандартном формате
+// из шести строк: A (размер пикселя по X), D и B (поворот, здесь 0),
+// E (размер пикселя по Y), C и F (координаты центра верхнего левого пикселя).
+//
+// Ось Y столика считается сонаправленной с осью строк изображения, поэтому E положителен.
+func Write(path string, t Transform) error {
+	content := fmt.Sprintf("%.10g\n0\n0\n%.10g\n%.10g\n%.10g\n", t.PixelSize, t.PixelSize, t.OriginX, t.OriginY)
+	if err := atomicfile.WriteFile(path, []byte(content)); err != nil {
+		return fmt.Errorf("failed to write world file '%s': %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+// Package frame описывает кадр последовательности с построчным доступом к исходным
+// значениям отсчетов. Вычислительные ядра читают кадр строками, а не по пикселю через
+// интерфейс image.Image, что убирает вызов интерфейса и проверку границ на каждый пиксель
+// и позволяет хранить 8-битные кадры без расширения до 16 бит.
+package frame
+
+import "image"
+
+// Frame - кадр с исходными (немасштабированными) значениями отсчетов.
+type Frame interface {
+	// Bounds возвращает границы кадра.
+	Bounds() image.Rectangle
+	// Row возвращает отсчеты строки y (в координатах Bounds) для столбцов Bounds().Min.X..Max.X-1.
+	// Если отсчеты хранятся в другом формате, они преобразуются в buf (длиной не меньше
+	// ширины кадра), иначе возвращается срез внутреннего буфера без копирования.
+	// Результат действителен до следующего вызова с тем же buf и не должен изменяться.
+	Row(y int, buf []uint16) []uint16
+}
+
+// gray16Frame - кадр на основе 16-битного изображения (отсчеты big-endian).
+type gray16Frame struct {
+	img *image.Gray16
+}
+
+// NewGray16 возвращает кадр, читающий отсчеты 16-битного изображения img без копирования.
+func NewGray16(img *image.Gray16) Frame {
+	return gray16Frame{img: img}
+}
+
+func (f gray16Frame) Bounds() image.Rectangle {
+	return f.img.Rect
+}
+
+func (f gray16Frame) Row(y int, buf []uint16) []uint16 {
+	width := f.img.Rect.Dx()
+	src := f.img.Pix[f.img.PixOffset(f.img.Rect.Min.X, y):]
+	src = src[:2*width]
+	buf = buf[:width]
+	for x := range buf {
+		buf[x] = uint16(src[2*x])<<8 | uint16(src[2*x+1])
+	}
+	return buf
+}
+
+// grayFrame - кадр на основе 8-битного изображения: отсчеты хранятся в одном байте.
+type grayFrame struct {
+	img *image.Gray
+}
+
+// NewGray возвращает кадр, читающий отсчеты 8-битного изображения img без копирования.
+// Значения отсчетов не масштабируются (0..255).
+func NewGray(img *image.Gray) Frame {
+	return grayFrame{img: img}
+}
+
+func (f grayFrame) Bounds() image.Rectangle {
+	return f.img.Rect
+}
+
+func (f grayFrame) Row(y int, buf []uint16) []uint16 {
+	width := f.img.Rect.Dx()
+	src := f.img.Pix[f.img.PixOffset(f.img.Rect.Min.X, y):]
+	src = src[:width]
+	buf = buf[:width]
+	for x := range buf {
+		buf[x] = uint16(src[x])
+	}
+	return buf
+}
+
+// Raw - кадр в собственном буфере 16-битных отсчетов: отсчет (x, y) хранится
+// в Pix[(y-Rect.Min.Y)*Stride + (x-Rect.Min.X)]. Строки возвращаются без копирования.
+type Raw struct {
+	Rect   image.Rectangle
+	Pix    []uint16
+	Stride int
+}
+
+// NewRaw создает кадр width x height с нулевыми отсчетами.
+func NewRaw(width, height int) *Raw {
+	return &Raw{Rect: image.Rect(0, 0, width, height), Pix: make([]uint16, width*height), Stride: width}
+}
+
+func (f *Raw) Bounds() image.Rectangle {
+	return f.Rect
+}
+
+func (f *Raw) Row(y int, _ []uint16) []uint16 {
+	start := (y - f.Rect.Min.Y) * f.Stride
+	return f.Pix[start : start+f.Rect.Dx()]
+}
+
+// MutableRow возвращает строку y для записи.
+func (f *Raw) MutableRow(y int) []uint16 {
+	start := (y - f.Rect.Min.Y) * f.Stride
+	return f.Pix[start : start+f.Rect.Dx()]
+}
+
+// Crop возвращает участок rect кадра f без копирования отсчетов
+// (rect должен лежать внутри f.Bounds()).
+func Crop(f Frame, rect image.Rectangle) Frame {
+	switch src := f.(type) {
+	case gray16Frame:
+		return NewGray16(src.img.SubImage(rect).(*image.Gray16))
+	case grayFrame:
+		return NewGray(src.img.SubImage(rect).(*image.Gray))
+	case *Raw:
+		offset := (rect.Min.Y-src.Rect.Min.Y)*src.Stride + (rect.Min.X - src.Rect.Min.X)
+		return &Raw{Rect: rect, Pix: src.Pix[offset:], Stride: src.Stride}
+	}
+	return cropped{f: f, rect: rect}
+}
+
+// Copy возвращает копию участка rect кадра f в собственном буфере; координаты участка
+// сохраняются (Bounds() == rect). В отличие от Crop копия не удерживает в памяти
+// отсчеты всего кадра. rect должен лежать внутри f.Bounds().
+func Copy(f Frame, rect image.Rectangle) *Raw {
+	out := &Raw{Rect: rect, Pix: make([]uint16, rect.Dx()*rect.Dy()), Stride: rect.Dx()}
+	region := Crop(f, rect)
+	buf := RowBuffer(f)
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		copy(out.MutableRow(y), region.Row(y, buf))
+	}
+	return out
+}
+
+// cropped - участок кадра произвольной реализации Frame.
+type cropped struct {
+	f    Frame
+	rect image.Rectangle
+}
+
+func (c cropped) Bounds() image.Rectangle {
+	return c.rect
+}
+
+func (c cropped) Row(y int, buf []uint16) []uint16 {
+	offset := c.rect.Min.X - c.f.Bounds().Min.X
+	if len(buf) < c.f.Bounds().Dx() {
+		buf = make([]uint16, c.f.Bounds().Dx())
+	}
+	return c.f.Row(y, buf)[offset : offset+c.rect.Dx()]
+}
+
+// RowBuffer возвращает буфер строки, достаточный для кадра f.
+func RowBuffer(f Frame) []uint16 {
+	return make([]uint16, f.Bounds().Dx())
+}
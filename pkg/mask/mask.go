@@ -0,0 +1,151 @@
+// Package mask описывает бинарные маски областей кадра (например, области исключения
+// с бликами или маркерами) и их влияние на окна пространственного усреднения.
+package mask
+
+import (
+	"fmt"
+	"image"
+
+	"github.com/mascotmascot1/go-tlasca/internal/imageutils"
+	"github.com/mascotmascot1/go-tlasca/internal/roi"
+)
+
+// Mask - бинарная маска размера Width x Height. Set[y*Width+x] == true означает,
+// что пиксель (x, y) отмечен маской.
+type Mask struct {
+	Width, Height int
+	Set           []bool
+}
+
+// Load загружает маску из изображения: пиксели с ненулевой яркостью считаются отмеченными.
+// Размер маски должен совпадать с размером кадров width x height. Файл областей ImageJ
+// (.roi или RoiSet.zip) задает маску размера кадра, в которой отмечены пиксели всех его областей.
+func Load(path string, width, height int) (*Mask, error) {
+	if roi.IsImageJ(path) {
+		shapes, err := roi.ReadImageJ(path)
+		if err != nil {
+			return nil, err
+		}
+		return FromShapes(shapes, width, height), nil
+	}
+	img, err := imageutils.LoadImage(path)
+	if err != nil {
+		return nil, err
+	}
+	gray := imageutils.ConvertToGray(img)
+	bounds := gray.Bounds()
+	if bounds.Dx() != width || bounds.Dy() != height {
+		return nil, fmt.Errorf("mask size %dx%d does not match frame size %dx%d", bounds.Dx(), bounds.Dy(), width, height)
+	}
+	return FromGray(gray), nil
+}
+
+// FromGray создает маску из изображения в градациях серого: ненулевые пиксели считаются отмеченными.
+func FromGray(img *image.Gray) *Mask {
+	bounds := img.Bounds()
+	m := &Mask{Width: bounds.Dx(), Height: bounds.Dy(), Set: make([]bool, bounds.Dx()*bounds.Dy())}
+	for y := 0; y < m.Height; y++ {
+		row := img.Pix[img.PixOffset(bounds.Min.X, bounds.Min.Y+y):]
+		for x := 0; x < m.Width; x++ {
+			m.Set[y*m.Width+x] = row[x] != 0
+		}
+	}
+	return m
+}
+
+// FromShapes создает маску размера width x height, в которой отмечены пиксели областей
+// shapes; части областей за пределами маски не учитываются.
+func FromShapes(shapes []roi.Shape, width, height int) *Mask {
+	m := &Mask{Width: width, Height: height, Set: make([]bool, width*height)}
+	for _, s := range shapes {
+		bounds := s.Bounds.Intersect(image.Rect(0, 0, width, height))
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				if s.Contains(x, y) {
+					m.Set[y*width+x] = true
+				}
+			}
+		}
+	}
+	return m
+}
+
+// Count возвращает число отмеченных пикселей.
+func (m *Mask) Count() int {
+	var count int
+	for _, set := range m.Set {
+		if set {
+			count++
+		}
+	}
+	return count
+}
+
+// Union возвращает маску пикселей, отмеченных в a или b (одного размера). Если одна
+// из масок nil, возвращается другая; иначе результат записывается в a.
+func Union(a, b *Mask) *Mask {
+	if a == nil {
+		return b
+	}
+	if b != nil {
+		for i, set := range b.Set {
+			a.Set[i] = a.Set[i] || set
+		}
+	}
+	return a
+}
+
+// Invert снимает отметку с отмеченных пикселей и отмечает остальные; возвращает m.
+// Используется для масок, в которых отмечены учитываемые области (например, ткань).
+func (m *Mask) Invert() *Mask {
+	for i, set := range m.Set {
+		m.Set[i] = !set
+	}
+	return m
+}
+
+// Crop возвращает маску участка rect (размера rect.Dx() x rect.Dy(), с началом
+// координат в rect.Min); rect должен лежать внутри маски. Для nil возвращает nil.
+func (m *Mask) Crop(rect image.Rectangle) *Mask {
+	if m == nil {
+		return nil
+	}
+	out := &Mask{Width: rect.Dx(), Height: rect.Dy(), Set: make([]bool, rect.Dx()*rect.Dy())}
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		copy(out.Set[(y-rect.Min.Y)*out.Width:], m.Set[y*m.Width+rect.Min.X:y*m.Width+rect.Max.X])
+	}
+	return out
+}
+
+// WindowsTouching возвращает маску положений окна windowSize x windowSize
+// (размера (Width-windowSize+1) x (Height-windowSize+1), индексируемую верхним левым углом окна),
+// в которых окно содержит хотя бы один отмеченный пиксель.
+//
+// Это эквивалентно расширению (дилатации) маски на размер окна: результат усреднения
+// по такому окну "загрязнен" отмеченными пикселями и должен быть исключен целиком.
+// Подсчет выполняется через таблицу сумм (summed-area table) за O(1) на положение окна.
+func (m *Mask) WindowsTouching(windowSize int) *Mask {
+	w, h := m.Width, m.Height
+	// sat[(y)*(w+1)+x] - число отмеченных пикселей в прямоугольнике [0, x) x [0, y).
+	sat := make([]int, (w+1)*(h+1))
+	for y := 0; y < h; y++ {
+		var rowSum int
+		for x := 0; x < w; x++ {
+			if m.Set[y*w+x] {
+				rowSum++
+			}
+			sat[(y+1)*(w+1)+x+1] = sat[y*(w+1)+x+1] + rowSum
+		}
+	}
+
+	outW, outH := w-windowSize+1, h-windowSize+1
+	out := &Mask{Width: outW, Height: outH, Set: make([]bool, outW*outH)}
+	for y := 0; y < outH; y++ {
+		for x := 0; x < outW; x++ {
+			x1, y1 := x+windowSize, y+windowSize
+			count := sat[y1*(w+1)+x1] - sat[y*(w+1)+x1] - sat[y1*(w+1)+x] + sat[y*(w+1)+x]
+			out.Set[y*outW+x] = count > 0
+		}
+	}
+	return out
+}
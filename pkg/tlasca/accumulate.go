@@ -0,0 +1,46 @@
+package tlasca
+
+import "math"
+
+// Ядра накопления временных статистик строки кадра. Каждое ядро обрабатывает одну строку
+// одного кадра для всех пикселей строки сразу: статистики пикселя по-прежнему накапливаются
+// по кадрам в порядке кадров, поэтому результат не зависит от реализации ядра, а цикл
+// по пикселям строки векторизуется (см. accumulate_amd64.s). Реализации на Go ниже
+// используются на остальных архитектурах, при сборке с тегом purego и для хвоста строки,
+// не кратного ширине вектора.
+//
+// Компилятор Go вправе объединять умножение и следующее за ним сложение в одну инструкцию
+// FMA (на arm64 и других архитектурах он так и делает), что меняет округление. Явное
+// преобразование float64(...) округляет произведение и запрещает такое объединение,
+// поэтому ядра дают одинаковые биты на всех архитектурах; FMA используется только явно
+// (math.FMA), как и в векторных реализациях.
+
+// accumulateRowGeneric прибавляет к sum и sumSq отсчеты row, умноженные на gain,
+// и их квадраты (однопроходный расчет, см. fastMoments).
+func accumulateRowGeneric(sum, sumSq []float64, row []uint16, gain float64) {
+	sum, sumSq = sum[:len(row)], sumSq[:len(row)]
+	for x, value := range row {
+		v := float64(float64(value) * gain)
+		sum[x] += v
+		sumSq[x] = math.FMA(v, v, sumSq[x])
+	}
+}
+
+// addRowGeneric прибавляет к sum отсчеты row, умноженные на gain (первый проход
+// двухпроходного расчета - сумма для среднего).
+func addRowGeneric(sum []float64, row []uint16, gain float64) {
+	sum = sum[:len(row)]
+	for x, value := range row {
+		sum[x] += float64(float64(value) * gain)
+	}
+}
+
+// addSquaredDeviationsGeneric прибавляет к m2 квадраты отклонений отсчетов row,
+// умноженных на gain, от средних mean (второй проход двухпроходного расчета).
+func addSquaredDeviationsGeneric(m2, mean []float64, row []uint16, gain float64) {
+	m2, mean = m2[:len(row)], mean[:len(row)]
+	for x, value := range row {
+		diff := float64(float64(value)*gain) - mean[x]
+		m2[x] += float64(diff * diff)
+	}
+}
@@ -0,0 +1,135 @@
+//go:build amd64 && !purego
+
+package tlasca
+
+import "sync/atomic"
+
+// vectorWidth - число отсчетов строки, обрабатываемых ядрами AVX2 за одну итерацию.
+const vectorWidth = 4
+
+// features - возможности процессора, обнаруженные при запуске (см. detectFeatures).
+var features = detectFeatures()
+
+// useAVX2 сообщает, выбрана ли реализация ядер AVX2 (по умолчанию - если ее поддерживают
+// процессор и операционная система, см. SetKernel).
+var useAVX2 atomic.Bool
+
+func init() {
+	useAVX2.Store(features.avx2)
+}
+
+// Ядра AVX2 (accumulate_amd64.s) обрабатывают строку длиной, кратной vectorWidth.
+//
+//go:noescape
+func accumulateRowAVX2(sum, sumSq []float64, row []uint16, gain float64)
+
+//go:noescape
+func addRowAVX2(sum []float64, row []uint16, gain float64)
+
+//go:noescape
+func addSquaredDeviationsAVX2(m2, mean []float64, row []uint16, gain float64)
+
+// cpuid выполняет инструкцию CPUID для листа eaxArg и подлиста ecxArg.
+func cpuid(eaxArg, ecxArg uint32) (eax, ebx, ecx, edx uint32)
+
+// xgetbv возвращает регистр XCR0 - состояния регистров, сохраняемые операционной системой.
+func xgetbv() (eax, edx uint32)
+
+// cpuFeatureSet - возможности процессора, существенные для ядер. Каждая возможность
+// учитывается, только если ее регистры сохраняет операционная система.
+type cpuFeatureSet struct {
+	avx2, fma, avx512f bool
+}
+
+// detectFeatures проверяет поддержку AVX2, FMA и AVX-512F процессором и сохранение
+// регистров YMM (и ZMM для AVX-512) операционной системой (OSXSAVE и биты регистра XCR0).
+func detectFeatures() cpuFeatureSet {
+	var f cpuFeatureSet
+	maxLeaf, _, _, _ := cpuid(0, 0)
+	if maxLeaf < 7 {
+		return f
+	}
+	_, _, ecx1, _ := cpuid(1, 0)
+	const fma, osxsave, avx = 1 << 12, 1 << 27, 1 << 28
+	if ecx1&(osxsave|avx) != osxsave|avx {
+		return f
+	}
+	xcr0, _ := xgetbv()
+	if xcr0&6 != 6 {
+		return f
+	}
+	_, ebx7, _, _ := cpuid(7, 0)
+	const avx2, avx512f = 1 << 5, 1 << 16
+	f.fma = ecx1&fma != 0
+	f.avx2 = f.fma && ebx7&avx2 != 0
+	// Биты 5-7 XCR0 - регистры масок и верхние половины ZMM.
+	f.avx512f = xcr0&0xe0 == 0xe0 && ebx7&avx512f != 0
+	return f
+}
+
+// cpuFeatures возвращает названия обнаруженных возможностей процессора.
+func cpuFeatures() []string {
+	var names []string
+	if features.avx2 {
+		names = append(names, "avx2")
+	}
+	if features.fma {
+		names = append(names, "fma")
+	}
+	if features.avx512f {
+		names = append(names, "avx512f")
+	}
+	return names
+}
+
+// availableKernels возвращает реализации ядер, доступные на процессоре, от самой быстрой.
+// Реализации для AVX-512 нет: на процессорах с AVX-512 используется реализация AVX2.
+func availableKernels() []string {
+	if features.avx2 {
+		return []string{KernelAVX2, KernelGeneric}
+	}
+	return []string{KernelGeneric}
+}
+
+// activeKernel возвращает выбранную реализацию ядер.
+func activeKernel() string {
+	if useAVX2.Load() {
+		return KernelAVX2
+	}
+	return KernelGeneric
+}
+
+// setKernel выбирает доступную реализацию ядер kernel.
+func setKernel(kernel string) {
+	useAVX2.Store(kernel == KernelAVX2)
+}
+
+// accumulateRow - векторная реализация accumulateRowGeneric.
+func accumulateRow(sum, sumSq []float64, row []uint16, gain float64) {
+	n := 0
+	if useAVX2.Load() {
+		n = len(row) &^ (vectorWidth - 1)
+		accumulateRowAVX2(sum[:n], sumSq[:n], row[:n], gain)
+	}
+	accumulateRowGeneric(sum[n:], sumSq[n:], row[n:], gain)
+}
+
+// addRow - векторная реализация addRowGeneric.
+func addRow(sum []float64, row []uint16, gain float64) {
+	n := 0
+	if useAVX2.Load() {
+		n = len(row) &^ (vectorWidth - 1)
+		addRowAVX2(sum[:n], row[:n], gain)
+	}
+	addRowGeneric(sum[n:], row[n:], gain)
+}
+
+// addSquaredDeviations - векторная реализация addSquaredDeviationsGeneric.
+func addSquaredDeviations(m2, mean []float64, row []uint16, gain float64) {
+	n := 0
+	if useAVX2.Load() {
+		n = len(row) &^ (vectorWidth - 1)
+		addSquaredDeviationsAVX2(m2[:n], mean[:n], row[:n], gain)
+	}
+	addSquaredDeviationsGeneric(m2[n:], mean[n:], row[n:], gain)
+}
@@ -0,0 +1,106 @@
+//go:build amd64 && !purego
+
+#include "textflag.h"
+
+// Ядра обрабатывают по 4 отсчета строки за итерацию: 4 отсчета uint16 расширяются
+// до int32 (VPMOVZXWD), преобразуются в float64 (VCVTDQ2PD) и умножаются на коэффициент.
+// Порядок и вид операций над каждым отсчетом совпадают с реализациями на Go
+// (accumulate.go): умножения и сложения округляются по отдельности (VMULPD, VADDPD),
+// как и произведения в явных преобразованиях float64, а FMA (VFMADD231PD) используется
+// там же, где math.FMA. Поэтому результаты совпадают побитово
+// (см. TestAVX2MatchesGeneric). Длина строки кратна 4.
+
+// func accumulateRowAVX2(sum, sumSq []float64, row []uint16, gain float64)
+TEXT ·accumulateRowAVX2(SB), NOSPLIT, $0-80
+	MOVQ         sum_base+0(FP), DI
+	MOVQ         sumSq_base+24(FP), DX
+	MOVQ         row_base+48(FP), SI
+	MOVQ         row_len+56(FP), CX
+	VBROADCASTSD gain+72(FP), Y0
+	XORQ         AX, AX
+
+accumulateLoop:
+	CMPQ        AX, CX
+	JGE         accumulateDone
+	VPMOVZXWD   (SI)(AX*2), X1
+	VCVTDQ2PD   X1, Y1
+	VMULPD      Y0, Y1, Y1
+	VADDPD      (DI)(AX*8), Y1, Y2
+	VMOVUPD     Y2, (DI)(AX*8)
+	VMOVUPD     (DX)(AX*8), Y3
+	VFMADD231PD Y1, Y1, Y3
+	VMOVUPD     Y3, (DX)(AX*8)
+	ADDQ        $4, AX
+	JMP         accumulateLoop
+
+accumulateDone:
+	VZEROUPPER
+	RET
+
+// func addRowAVX2(sum []float64, row []uint16, gain float64)
+TEXT ·addRowAVX2(SB), NOSPLIT, $0-56
+	MOVQ         sum_base+0(FP), DI
+	MOVQ         row_base+24(FP), SI
+	MOVQ         row_len+32(FP), CX
+	VBROADCASTSD gain+48(FP), Y0
+	XORQ         AX, AX
+
+addLoop:
+	CMPQ      AX, CX
+	JGE       addDone
+	VPMOVZXWD (SI)(AX*2), X1
+	VCVTDQ2PD X1, Y1
+	VMULPD    Y0, Y1, Y1
+	VADDPD    (DI)(AX*8), Y1, Y2
+	VMOVUPD   Y2, (DI)(AX*8)
+	ADDQ      $4, AX
+	JMP       addLoop
+
+addDone:
+	VZEROUPPER
+	RET
+
+// func addSquaredDeviationsAVX2(m2, mean []float64, row []uint16, gain float64)
+TEXT ·addSquaredDeviationsAVX2(SB), NOSPLIT, $0-80
+	MOVQ         m2_base+0(FP), DI
+	MOVQ         mean_base+24(FP), DX
+	MOVQ         row_base+48(FP), SI
+	MOVQ         row_len+56(FP), CX
+	VBROADCASTSD gain+72(FP), Y0
+	XORQ         AX, AX
+
+deviationLoop:
+	CMPQ      AX, CX
+	JGE       deviationDone
+	VPMOVZXWD (SI)(AX*2), X1
+	VCVTDQ2PD X1, Y1
+	VMULPD    Y0, Y1, Y1
+	VSUBPD    (DX)(AX*8), Y1, Y1
+	VMULPD    Y1, Y1, Y1
+	VADDPD    (DI)(AX*8), Y1, Y2
+	VMOVUPD   Y2, (DI)(AX*8)
+	ADDQ      $4, AX
+	JMP       deviationLoop
+
+deviationDone:
+	VZEROUPPER
+	RET
+
+// func cpuid(eaxArg, ecxArg uint32) (eax, ebx, ecx, edx uint32)
+TEXT ·cpuid(SB), NOSPLIT, $0-24
+	MOVL eaxArg+0(FP), AX
+	MOVL ecxArg+4(FP), CX
+	CPUID
+	MOVL AX, eax+8(FP)
+	MOVL BX, ebx+12(FP)
+	MOVL CX, ecx+16(FP)
+	MOVL DX, edx+20(FP)
+	RET
+
+// func xgetbv() (eax, edx uint32)
+TEXT ·xgetbv(SB), NOSPLIT, $0-8
+	MOVL $0, CX
+	XGETBV
+	MOVL AX, eax+0(FP)
+	MOVL DX, edx+4(FP)
+	RET
@@ -0,0 +1,76 @@
+//go:build amd64 && !purego
+
+package tlasca
+
+import (
+	"math"
+	"math/rand/v2"
+	"testing"
+)
+
+// randomRows возвращает frames строк длины width со случайными 16-битными отсчетами
+// и случайные коэффициенты кадров.
+func randomRows(rng *rand.Rand, frames, width int) ([][]uint16, []float64) {
+	rows := make([][]uint16, frames)
+	gains := make([]float64, frames)
+	for t := range rows {
+		rows[t] = make([]uint16, width)
+		for x := range rows[t] {
+			rows[t][x] = uint16(rng.IntN(1 << 16))
+		}
+		gains[t] = 0.5 + 20*rng.Float64()
+	}
+	return rows, gains
+}
+
+// sameBits сообщает о первом элементе got, биты которого отличаются от want.
+func sameBits(t *testing.T, name string, got, want []float64) {
+	t.Helper()
+	for x := range want {
+		if math.Float64bits(got[x]) != math.Float64bits(want[x]) {
+			t.Fatalf("%s[%d] = %v, generic kernel gives %v", name, x, got[x], want[x])
+		}
+	}
+}
+
+// TestAVX2MatchesGeneric проверяет, что ядра AVX2 (вместе с обработкой хвоста строки
+// ядрами на Go) дают побитово тот же результат, что и ядра на Go, на случайных строках.
+func TestAVX2MatchesGeneric(t *testing.T) {
+	if !features.avx2 {
+		t.Skip("cpu does not support avx2 and fma")
+	}
+	defer setKernel(activeKernel())
+	setKernel(KernelAVX2)
+
+	rng := rand.New(rand.NewPCG(1, 2))
+	for _, width := range []int{1, 4, 7, 64, 1001} {
+		rows, gains := randomRows(rng, 16, width)
+		sum, sumSq := make([]float64, width), make([]float64, width)
+		wantSum, wantSumSq := make([]float64, width), make([]float64, width)
+		for t, row := range rows {
+			accumulateRow(sum, sumSq, row, gains[t])
+			accumulateRowGeneric(wantSum, wantSumSq, row, gains[t])
+		}
+		sameBits(t, "accumulateRow sum", sum, wantSum)
+		sameBits(t, "accumulateRow sumSq", sumSq, wantSumSq)
+
+		clear(sum)
+		clear(wantSum)
+		for t, row := range rows {
+			addRow(sum, row, gains[t])
+			addRowGeneric(wantSum, row, gains[t])
+		}
+		sameBits(t, "addRow", sum, wantSum)
+
+		mean := make([]float64, width)
+		for x := range mean {
+			mean[x] = wantSum[x] / float64(len(rows))
+		}
+		m2, wantM2 := make([]float64, width), make([]float64, width)
+		for t, row := range rows {
+			addSquaredDeviations(m2, mean, row, gains[t])
+			addSquaredDeviationsGeneric(wantM2, mean, row, gains[t])
+		}
+		sameBits(t, "addSquaredDeviations", m2, wantM2)
+	}
+}
@@ -0,0 +1,43 @@
+//go:build !amd64 || purego
+
+package tlasca
+
+import "runtime"
+
+// cpuFeatures возвращает названия обнаруженных возможностей процессора: на arm64
+// векторные инструкции NEON (ASIMD) входят в базовую архитектуру ARMv8. Отдельной
+// реализации ядер для NEON нет, используются ядра на Go.
+func cpuFeatures() []string {
+	if runtime.GOARCH == "arm64" {
+		return []string{"neon"}
+	}
+	return nil
+}
+
+// availableKernels возвращает реализации ядер, доступные в сборке: только ядра на Go.
+func availableKernels() []string {
+	return []string{KernelGeneric}
+}
+
+// activeKernel возвращает выбранную реализацию ядер.
+func activeKernel() string {
+	return KernelGeneric
+}
+
+// setKernel выбирает реализацию ядер; в сборке доступны только ядра на Go.
+func setKernel(string) {}
+
+// accumulateRow накапливает сумму и сумму квадратов строки (см. accumulateRowGeneric).
+func accumulateRow(sum, sumSq []float64, row []uint16, gain float64) {
+	accumulateRowGeneric(sum, sumSq, row, gain)
+}
+
+// addRow накапливает сумму строки (см. addRowGeneric).
+func addRow(sum []float64, row []uint16, gain float64) {
+	addRowGeneric(sum, row, gain)
+}
+
+// addSquaredDeviations накапливает квадраты отклонений строки (см. addSquaredDeviationsGeneric).
+func addSquaredDeviations(m2, mean []float64, row []uint16, gain float64) {
+	addSquaredDeviationsGeneric(m2, mean, row, gain)
+}
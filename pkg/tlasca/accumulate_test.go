@@ -0,0 +1,85 @@
+package tlasca
+
+import (
+	"math"
+	"testing"
+)
+
+// Строки двух кадров и коэффициенты нормировки к полной шкале (12 бит -> 16 бит),
+// при которых объединение умножения и сложения в FMA меняет младшие биты результата.
+var (
+	kernelRows = [][]uint16{
+		{1, 4095, 1234, 65535, 7, 3000, 17, 12345, 999},
+		{3, 4000, 1300, 65000, 11, 2900, 19, 12000, 1001},
+	}
+	kernelGains = []float64{65535.0 / 4095, 65535.0 / 4093}
+)
+
+// Ожидаемые биты рассчитаны независимо с округлением каждого умножения и сложения
+// (без FMA) и должны совпадать на всех архитектурах.
+var (
+	wantSumBits = []uint64{
+		0x405008d6d3fa7a23, 0x40ffa2d21cff096b, 0x40e3ce77f035357c, 0x413fe24ad9ea4d05, 0x40720a6e67929970,
+		0x40f70ece3c7dc4bc, 0x408207d7f559ce94, 0x4117c91f439a24be, 0x40df4403722be0a2,
+	}
+	wantM2Bits = []uint64{
+		0x40871b21a940ac51, 0x41cbd575dc50e1b2, 0x4195edcf22279aa7, 0x424c3e04a9ab72f4, 0x40b9eb59bf7bc6c8,
+		0x41bd9c244c9cd814, 0x40d26f5ad8e699b2, 0x41ff7c42758d579a, 0x418b2656a462cf44,
+	}
+)
+
+// kernelSums возвращает суммы строк kernelRows, накопленные add поверх начальных значений 0.1·(x+1).
+func kernelSums(add func(sum []float64, row []uint16, gain float64)) []float64 {
+	sum := make([]float64, len(kernelRows[0]))
+	for x := range sum {
+		sum[x] = 0.1 * float64(x+1)
+	}
+	for t, row := range kernelRows {
+		add(sum, row, kernelGains[t])
+	}
+	return sum
+}
+
+// kernelM2 возвращает суммы квадратов отклонений строк kernelRows от средних mean, накопленные add.
+func kernelM2(mean []float64, add func(m2, mean []float64, row []uint16, gain float64)) []float64 {
+	m2 := make([]float64, len(mean))
+	for t, row := range kernelRows {
+		add(m2, mean, row, kernelGains[t])
+	}
+	return m2
+}
+
+// checkBits сообщает об элементах got, биты которых отличаются от want.
+func checkBits(t *testing.T, name string, got []float64, want []uint64) {
+	t.Helper()
+	for x, v := range got {
+		if math.Float64bits(v) != want[x] {
+			t.Errorf("%s[%d] = %#016x (%v), want %#016x (%v)", name, x,
+				math.Float64bits(v), v, want[x], math.Float64frombits(want[x]))
+		}
+	}
+}
+
+// TestKernelsFixedBits проверяет, что ядра двухпроходного расчета (режим deterministic)
+// дают фиксированные биты независимо от архитектуры и выбранной реализации.
+func TestKernelsFixedBits(t *testing.T) {
+	for _, k := range []struct {
+		name          string
+		add           func(sum []float64, row []uint16, gain float64)
+		addDeviations func(m2, mean []float64, row []uint16, gain float64)
+	}{
+		{"generic", addRowGeneric, addSquaredDeviationsGeneric},
+		{"dispatched", addRow, addSquaredDeviations},
+	} {
+		t.Run(k.name, func(t *testing.T) {
+			sum := kernelSums(k.add)
+			checkBits(t, "sum", sum, wantSumBits)
+
+			mean := make([]float64, len(sum))
+			for x := range mean {
+				mean[x] = math.Float64frombits(wantSumBits[x]) / 3
+			}
+			checkBits(t, "m2", kernelM2(mean, k.addDeviations), wantM2Bits)
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package tlasca
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"math"
+
+	"github.com/mascotmascot1/go-tlasca/pkg/frame"
+)
+
+// FrameContributions оценивает вклад каждого кадра в итоговый контраст опорной области roi
+// методом исключения по одному (leave-one-out):
+//
+//	ΔK_t = K̄ - K̄_(-t)
+//
+// где K̄ - средний по области попиксельный контраст по всей последовательности,
+// а K̄_(-t) - тот же контраст без кадра t. Кадр, сильно увеличивающий дисперсию
+// (вспышка, сдвиг, пропуск), дает большой положительный ΔK_t.
+//
+// Последовательность из total кадров читается через load порциями по chunkSize
+// (chunkSize <= 0 - одной порцией) дважды: сначала накапливаются статистики области,
+// затем для каждого кадра вычисляется ΔK_t. Статистики без кадра t получаются
+// из полных вычитанием его вклада:
+//
+//	mean_(-t) = (n*mean - v) / (n-1)
+//	M2_(-t)   = M2 - (v - mean)² * n / (n-1)
+//
+// Возвращает ΔK_t в порядке кадров или ошибку, если область пуста, кадров меньше трех
+// загрузка порции завершилась неудачно или ctx отменен.
+func (r *Runner) FrameContributions(ctx context.Context, roi image.Rectangle, total, chunkSize int, load ChunkLoader, opts Options) (_ []float64, err error) {
+	defer recoverPanic(&err)
+	defer r.telemetry.Start("contributions")()
+	if roi.Empty() {
+		return nil, fmt.Errorf("reference region %v is empty", roi)
+	}
+	if total < 3 {
+		return nil, fmt.Errorf("leave-one-out contributions need at least 3 frames, got %d", total)
+	}
+	if chunkSize <= 0 {
+		chunkSize = total
+	}
+
+	// Обход последовательности порциями с обрезкой кадров до опорной области.
+	forEachChunk := func(fn func(start int, images []frame.Frame, gains []float64) error) error {
+		for start := 0; start < total; start += chunkSize {
+			if err := cancelled(ctx); err != nil {
+				return err
+			}
+			end := min(start+chunkSize, total)
+			images, err := load(start, end)
+			if err != nil {
+				return fmt.Errorf("failed to load chunk [%d, %d): %w", start, end, err)
+			}
+			cropped := make([]frame.Frame, len(images))
+			for i, img := range images {
+				if !roi.In(img.Bounds()) {
+					return fmt.Errorf("reference region %v is outside the frame %v", roi, img.Bounds())
+				}
+				cropped[i] = frame.Crop(img, roi)
+			}
+			var gains []float64
+			if opts.Gains != nil {
+				gains = opts.Gains[start:end]
+			}
+			if err := fn(start, cropped, gains); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// Первый проход: статистики области по всей последовательности. Вклад кадра - малая
+	// разность контрастов, поэтому статистики всегда считаются точным двухпроходным способом.
+	stats := newTemporalStats(roi.Dx(), roi.Dy())
+	if err := forEachChunk(func(_ int, images []frame.Frame, gains []float64) error {
+		chunk, err := computeChunkStats(ctx, images, gains, false, nil)
+		if err != nil {
+			return err
+		}
+		stats.merge(chunk)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	fullContrast := meanOf(stats.contrastPlane())
+
+	// Второй проход: контраст области без каждого кадра.
+	n := float64(stats.n)
+	deltas := make([]float64, total)
+	buf := make([]uint16, roi.Dx())
+	err = forEachChunk(func(start int, images []frame.Frame, gains []float64) error {
+		for i, img := range images {
+			gain := 1.0
+			if gains != nil {
+				gain = gains[i]
+			}
+			var sum float64
+			for y := 0; y < stats.height; y++ {
+				row := img.Row(roi.Min.Y+y, buf)
+				for x, value := range row {
+					j := y*stats.width + x
+					// Преобразования float64 запрещают объединение в FMA (см. accumulate.go).
+					v := float64(float64(value) * gain)
+					mean := (float64(n*stats.mean[j]) - v) / (n - 1)
+					diff := v - stats.mean[j]
+					m2 := max(stats.m2[j]-diff*diff*n/(n-1), 0)
+					if mean > 0 {
+						sum += math.Sqrt(m2/(n-2)) / mean
+					}
+				}
+			}
+			deltas[start+i] = fullContrast - sum/float64(len(stats.mean))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return deltas, nil
+}
+
+// meanOf возвращает среднее значение среза (0 для пустого среза).
+func meanOf(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	return sum / float64(len(values))
+}
@@ -0,0 +1,84 @@
+package tlasca
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"math"
+
+	"github.com/mascotmascot1/go-tlasca/pkg/frame"
+)
+
+// Convergence рассчитывает средний попиксельный контраст опорной области roi
+// в зависимости от числа использованных кадров: элемент i результата - контраст
+// по первым i+2 читаемым кадрам последовательности (для одного кадра контраст не определен).
+// По кривой видно, с какого числа кадров оценка контраста перестает заметно меняться,
+// т.е. какая длина записи достаточна для устойчивой оценки.
+//
+// Статистики пикселей области обновляются кадр за кадром (алгоритм Уэлфорда), поэтому
+// последовательность из total кадров читается через load порциями по chunkSize
+// (chunkSize <= 0 - одной порцией) один раз. Пропущенные (nil) кадры не учитываются.
+// Возвращает ошибку, если область пуста или вне кадра, загрузка порции завершилась
+// неудачно, читаемых кадров меньше двух или ctx отменен.
+func (r *Runner) Convergence(ctx context.Context, roi image.Rectangle, total, chunkSize int, load ChunkLoader, opts Options) (_ []float64, err error) {
+	defer recoverPanic(&err)
+	defer r.telemetry.Start("convergence")()
+	if roi.Empty() {
+		return nil, fmt.Errorf("reference region %v is empty", roi)
+	}
+	if chunkSize <= 0 {
+		chunkSize = total
+	}
+
+	pixels := roi.Dx() * roi.Dy()
+	mean := make([]float64, pixels)
+	m2 := make([]float64, pixels)
+	buf := make([]uint16, roi.Dx())
+	var n int
+	var curve []float64
+	for start := 0; start < total; start += chunkSize {
+		if err := cancelled(ctx); err != nil {
+			return nil, err
+		}
+		end := min(start+chunkSize, total)
+		images, err := load(start, end)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load chunk [%d, %d): %w", start, end, err)
+		}
+		for i, img := range images {
+			if img == nil {
+				continue
+			}
+			if !roi.In(img.Bounds()) {
+				return nil, fmt.Errorf("reference region %v is outside the frame %v", roi, img.Bounds())
+			}
+			gain := 1.0
+			if opts.Gains != nil {
+				gain = opts.Gains[start+i]
+			}
+			n++
+			cropped := frame.Crop(img, roi)
+			var sum float64
+			for y := 0; y < roi.Dy(); y++ {
+				row := cropped.Row(roi.Min.Y+y, buf)
+				for x, value := range row {
+					j := y*roi.Dx() + x
+					v := float64(value) * gain
+					delta := v - mean[j]
+					mean[j] += delta / float64(n)
+					m2[j] += delta * (v - mean[j])
+					if n > 1 && mean[j] > 0 {
+						sum += math.Sqrt(m2[j]/float64(n-1)) / mean[j]
+					}
+				}
+			}
+			if n > 1 {
+				curve = append(curve, sum/float64(pixels))
+			}
+		}
+	}
+	if len(curve) == 0 {
+		return nil, fmt.Errorf("at least 2 readable frames are required")
+	}
+	return curve, nil
+}
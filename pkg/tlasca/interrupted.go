@@ -0,0 +1,72 @@
+package tlasca
+
+import (
+	"context"
+
+	"github.com/mascotmascot1/go-tlasca/pkg/mask"
+)
+
+// InterruptedError - ошибка расчета, прерванного отменой ctx или ошибкой чтения кадров,
+// для которого удалось сохранить часть результата. Для многочасовых записей, прерванных
+// незадолго до завершения, это позволяет не терять уже выполненную работу:
+//
+//	res, err := runner.RunChunked(ctx, total, chunkSize, load, opts)
+//	var interrupted *tlasca.InterruptedError
+//	if errors.As(err, &interrupted) {
+//		res = interrupted.Result // неполная карта, см. Result.Interrupted
+//	}
+//
+// Частичный результат может быть неполным по кадрам (статистики рассчитаны по кадрам,
+// прочитанным до прерывания, см. Result.Frames) и по положениям окна (нерассчитанные
+// положения отмечены в Result.Incomplete).
+type InterruptedError struct {
+	// Result - частичный результат; Result.Interrupted истинно.
+	Result *Result
+	// Total - число кадров последовательности (0, если оно неизвестно).
+	Total int
+	// Err - причина прерывания.
+	Err error
+}
+
+// Error возвращает текст причины прерывания.
+func (e *InterruptedError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap возвращает причину прерывания, поэтому errors.Is(err, context.Canceled) истинно
+// и для прерванного расчета с частичным результатом.
+func (e *InterruptedError) Unwrap() error {
+	return e.Err
+}
+
+// salvage строит частичный результат по статистикам stats, накопленным до прерывания
+// расчета с ошибкой cause, и возвращает его в InterruptedError. Карта рассчитывается без
+// учета отмены ctx (она уже отменена, а расчет карты занимает малую долю времени); строки
+// кадра без статистик исключаются из карты. Если статистик недостаточно (нет статистик или
+// учтено меньше двух кадров), возвращает cause.
+func (r *Runner) salvage(ctx context.Context, stats *temporalStats, cause error, total int, opts Options) error {
+	if stats == nil || stats.n < 2 {
+		return cause
+	}
+	opts.Progress = nil
+	res, err := r.calculateContrastMap(context.WithoutCancel(ctx), stats, opts)
+	if err != nil {
+		return cause
+	}
+	return r.interrupted(res, cause, total)
+}
+
+// interrupted отмечает res как частичный результат и возвращает InterruptedError с причиной cause.
+// Если карта не построена (res - nil), возвращает cause.
+func (r *Runner) interrupted(res *Result, cause error, total int) error {
+	if res == nil {
+		return cause
+	}
+	res.Interrupted = true
+	if res.Incomplete == nil {
+		res.Incomplete = &mask.Mask{Width: res.Width, Height: res.Height, Set: make([]bool, res.Width*res.Height)}
+	}
+	r.logger.Printf("calculation interrupted, partial result kept: %d frames, %d of %d map positions.\n",
+		res.Frames, res.Width*res.Height-res.Incomplete.Count(), res.Width*res.Height)
+	return &InterruptedError{Result: res, Total: total, Err: cause}
+}
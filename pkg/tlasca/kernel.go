@@ -0,0 +1,94 @@
+package tlasca
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync/atomic"
+)
+
+// Реализации ядер накопления временных статистик строки (см. accumulate.go и SetKernel).
+const (
+	// KernelAuto - самая быстрая реализация, доступная на процессоре.
+	KernelAuto = "auto"
+	// KernelGeneric - реализация на Go; доступна всегда.
+	KernelGeneric = "generic"
+	// KernelAVX2 - векторная реализация AVX2 (amd64 с AVX2 и FMA, кроме сборки с тегом purego).
+	KernelAVX2 = "avx2"
+	// KernelOpenCL - расчет статистик порции на GPU через OpenCL (сборка с тегом opencl,
+	// см. opencl.go). Выбирается только явно: KernelAuto выбирает ядра процессора.
+	KernelOpenCL = "opencl"
+)
+
+// useOpenCL сообщает, выбран ли расчет статистик порции через OpenCL (см. SetKernel).
+var useOpenCL atomic.Bool
+
+// Kernels возвращает реализации ядер, доступные на процессоре и в сборке: ядра процессора
+// от самой быстрой до KernelGeneric, затем KernelOpenCL, если сборка с тегом opencl нашла
+// устройство OpenCL с поддержкой double.
+func Kernels() []string {
+	kernels := availableKernels()
+	if openclAvailable() == nil {
+		kernels = append(kernels, KernelOpenCL)
+	}
+	return kernels
+}
+
+// CPUFeatures возвращает обнаруженные возможности процессора, существенные для ядер
+// (например, "avx2", "fma", "avx512f" на amd64 и "neon" на arm64). Наличие возможности
+// не означает наличия реализации ядра для нее (см. Kernels).
+func CPUFeatures() []string {
+	return cpuFeatures()
+}
+
+// Kernel возвращает реализацию ядер, используемую в расчетах.
+func Kernel() string {
+	if useOpenCL.Load() {
+		return KernelOpenCL
+	}
+	return activeKernel()
+}
+
+// CheckKernel возвращает реализацию ядер, которую выберет SetKernel для name: для ""
+// и KernelAuto - самую быструю доступную на процессоре. Возвращает ошибку, если реализация
+// name неизвестна или недоступна на процессоре (в сборке, для KernelOpenCL - на устройстве).
+func CheckKernel(name string) (string, error) {
+	available := availableKernels()
+	switch name {
+	case "", KernelAuto:
+		return available[0], nil
+	case KernelGeneric, KernelAVX2:
+		if !slices.Contains(available, name) {
+			return "", fmt.Errorf("kernel '%s' is not available on this cpu or build, available: %s", name, strings.Join(available, ", "))
+		}
+		return name, nil
+	case KernelOpenCL:
+		if err := openclAvailable(); err != nil {
+			return "", fmt.Errorf("kernel '%s' is not available: %w", name, err)
+		}
+		return name, nil
+	default:
+		return "", fmt.Errorf("unknown kernel '%s', expected '%s', '%s', '%s' or '%s'",
+			name, KernelAuto, KernelGeneric, KernelAVX2, KernelOpenCL)
+	}
+}
+
+// SetKernel выбирает реализацию ядер накопления статистик для всех последующих расчетов
+// процесса (см. CheckKernel) и возвращает выбранную реализацию. Реализации дают побитово
+// одинаковый результат; принудительный выбор нужен для отладки и сравнения скорости.
+// При KernelOpenCL на устройстве рассчитываются статистики порций при раскладке
+// LayoutFrames, а остальные расчеты (LayoutPlanar, RunStream) используют самые быстрые
+// ядра процессора.
+func SetKernel(name string) (string, error) {
+	kernel, err := CheckKernel(name)
+	if err != nil {
+		return "", err
+	}
+	useOpenCL.Store(kernel == KernelOpenCL)
+	if kernel == KernelOpenCL {
+		setKernel(availableKernels()[0])
+	} else {
+		setKernel(kernel)
+	}
+	return kernel, nil
+}
@@ -0,0 +1,69 @@
+//go:build opencl && cgo && unix
+
+package tlasca
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/mascotmascot1/go-tlasca/internal/opencl"
+	"github.com/mascotmascot1/go-tlasca/pkg/frame"
+)
+
+// openclMaxBand ограничивает объем кадров (в байтах), передаваемых на устройство за один
+// вызов ядра, если наибольший буфер устройства больше.
+const openclMaxBand = 256 << 20
+
+// openclAvailable проверяет, что библиотека OpenCL и устройство с поддержкой double
+// найдены, а ядро скомпилировано (см. opencl.Open).
+func openclAvailable() error {
+	_, _, err := opencl.Open()
+	return err
+}
+
+// openclChunkStats - реализация computeChunkStats на устройстве OpenCL. Кадры передаются
+// на устройство полосами строк, объем которых не превышает наибольшего буфера устройства;
+// ядро рассчитывает статистики каждого пикселя полосы по кадрам в порядке кадров теми же
+// операциями, что и ядра процессора, поэтому результат побитово совпадает с ними на
+// устройствах с арифметикой double по IEEE 754. Отмена ctx проверяется перед каждой полосой.
+func openclChunkStats(ctx context.Context, images []frame.Frame, gains []float64, fast bool, done *progress) (*temporalStats, error) {
+	_, maxAlloc, err := opencl.Open()
+	if err != nil {
+		return nil, err
+	}
+	bounds := images[0].Bounds()
+	s := newTemporalStats(bounds.Dx(), bounds.Dy())
+	s.n = len(images)
+	band := max(1, min(s.height, int(min(maxAlloc, openclMaxBand))/(2*s.width*len(images))))
+	pix := make([]uint16, band*s.width*len(images))
+	bufs := make([][]uint16, len(images))
+	for t, img := range images {
+		bufs[t] = frame.RowBuffer(img)
+	}
+
+	rows := make([]bool, s.height)
+	for y0 := 0; y0 < s.height; y0 += band {
+		if err := cancelled(ctx); err != nil {
+			s.rows = rows
+			return s, err
+		}
+		height := min(band, s.height-y0)
+		pixels := height * s.width
+		// Полоса хранится кадр за кадром: pixels отсчетов каждого кадра подряд.
+		for t, img := range images {
+			for y := range height {
+				copy(pix[t*pixels+y*s.width:], img.Row(bounds.Min.Y+y0+y, bufs[t]))
+			}
+		}
+		offset := y0 * s.width
+		err := opencl.Stats(pix[:len(images)*pixels], gains, pixels, fast, s.mean[offset:offset+pixels], s.m2[offset:offset+pixels])
+		if err != nil {
+			return nil, fmt.Errorf("rows %d-%d: %w", y0+1, y0+height, err)
+		}
+		for y := y0; y < y0+height; y++ {
+			rows[y] = true
+		}
+		done.add(height)
+	}
+	return s, nil
+}
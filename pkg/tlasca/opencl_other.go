@@ -0,0 +1,21 @@
+//go:build !opencl || !cgo || !unix
+
+package tlasca
+
+import (
+	"context"
+	"errors"
+
+	"github.com/mascotmascot1/go-tlasca/pkg/frame"
+)
+
+// openclAvailable сообщает, что ядро OpenCL в сборке отсутствует: оно собирается
+// с тегом opencl при включенном cgo (см. opencl.go).
+func openclAvailable() error {
+	return errors.New("opencl support is not built in, rebuild with -tags opencl (requires cgo)")
+}
+
+// openclChunkStats не вызывается: без ядра OpenCL реализация KernelOpenCL не выбирается.
+func openclChunkStats(context.Context, []frame.Frame, []float64, bool, *progress) (*temporalStats, error) {
+	return nil, openclAvailable()
+}
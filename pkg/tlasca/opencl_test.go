@@ -0,0 +1,52 @@
+//go:build opencl && cgo && unix
+
+package tlasca
+
+import (
+	"context"
+	"math/rand/v2"
+	"testing"
+
+	"github.com/mascotmascot1/go-tlasca/pkg/frame"
+)
+
+// TestOpenCLMatchesCPU проверяет, что статистики, рассчитанные на устройстве OpenCL,
+// побитово совпадают с ядрами процессора в обоих способах расчета. Без библиотеки OpenCL
+// или подходящего устройства тест пропускается.
+func TestOpenCLMatchesCPU(t *testing.T) {
+	if err := openclAvailable(); err != nil {
+		t.Skip(err)
+	}
+	t.Cleanup(func() { SetKernel(KernelAuto) })
+	rng := rand.New(rand.NewPCG(7, 8))
+	frames := randomFrames(rng, 12, 37, 29)
+	gains := make([]float64, len(frames))
+	for i := range gains {
+		gains[i] = 1 / (4095 + rng.Float64())
+	}
+	load := func(start, end int) ([]frame.Frame, error) {
+		return frames[start:end], nil
+	}
+	ctx := context.Background()
+
+	for _, mode := range []string{ModeDeterministic, ModeFast} {
+		t.Run(mode, func(t *testing.T) {
+			runner := NewRunner(Params{WindowSize: 5, ComputeMode: mode}, nil, nil)
+			if _, err := SetKernel(KernelGeneric); err != nil {
+				t.Fatal(err)
+			}
+			want, err := runner.RunChunked(ctx, len(frames), 5, load, Options{Gains: gains})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := SetKernel(KernelOpenCL); err != nil {
+				t.Fatal(err)
+			}
+			got, err := runner.RunChunked(ctx, len(frames), 5, load, Options{Gains: gains})
+			if err != nil {
+				t.Fatal(err)
+			}
+			sameResult(t, got, want)
+		})
+	}
+}
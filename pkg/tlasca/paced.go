@@ -0,0 +1,71 @@
+package tlasca
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/mascotmascot1/go-tlasca/pkg/frame"
+)
+
+// PacedSource выдает кадры источника с постоянной частотой, имитируя съемку камерой
+// в реальном времени: кадр i выдается не раньше, чем через i/fps после первого кадра.
+// Записанная на диск последовательность, переданная в RunStream через PacedSource,
+// обрабатывается так же, как поток с камеры, поэтому режимы для съемки в реальном
+// времени можно разрабатывать и показывать без камеры.
+//
+// Если обработка не успевает за частотой, кадры не пропускаются: расписание сохраняется,
+// а наибольшее отставание выдачи кадра от него сообщает MaxLag.
+type PacedSource struct {
+	ctx      context.Context
+	src      FrameSource
+	interval time.Duration
+	start    time.Time
+	next     int
+	maxLag   time.Duration
+}
+
+// NewPacedSource возвращает источник кадров src с частотой fps кадров в секунду (fps > 0).
+// Ожидание очередного кадра прерывается отменой ctx: Next возвращает ошибку отмены.
+func NewPacedSource(ctx context.Context, src FrameSource, fps float64) (*PacedSource, error) {
+	if !(fps > 0) {
+		return nil, fmt.Errorf("playback rate must be positive, got %g fps", fps)
+	}
+	return &PacedSource{ctx: ctx, src: src, interval: time.Duration(float64(time.Second) / fps)}, nil
+}
+
+// Next дожидается времени очередного кадра по расписанию и возвращает кадр источника.
+func (p *PacedSource) Next() (frame.Frame, error) {
+	now := time.Now()
+	if p.next == 0 {
+		p.start = now
+	}
+	due := p.start.Add(time.Duration(p.next) * p.interval)
+	if wait := due.Sub(now); wait > 0 {
+		timer := time.NewTimer(wait)
+		select {
+		case <-p.ctx.Done():
+			timer.Stop()
+			return nil, cancelled(p.ctx)
+		case <-timer.C:
+		}
+	} else {
+		p.maxLag = max(p.maxLag, -wait)
+	}
+	p.next++
+	return p.src.Next()
+}
+
+// Len возвращает число кадров источника src, если оно известно, и 0 иначе.
+func (p *PacedSource) Len() int {
+	if sized, ok := p.src.(sizedSource); ok {
+		return sized.Len()
+	}
+	return 0
+}
+
+// MaxLag возвращает наибольшее отставание выдачи кадра от расписания: 0, если обработка
+// успевала за частотой кадров.
+func (p *PacedSource) MaxLag() time.Duration {
+	return p.maxLag
+}
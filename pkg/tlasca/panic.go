@@ -0,0 +1,42 @@
+package tlasca
+
+import (
+	"errors"
+	"fmt"
+	"runtime/debug"
+
+	"github.com/mascotmascot1/go-tlasca/internal/parallel"
+)
+
+// PanicError - паника рабочей горутины расчета, преобразованная в ошибку: блок строк
+// (StartY, EndY) или индекс задания, значение паники и стек. Методы Runner возвращают
+// такие ошибки (объединенные через errors.Join, если паника произошла в нескольких
+// горутинах) вместо завершения процесса вызывающей программы; их можно найти через errors.As.
+type PanicError = parallel.PanicError
+
+// withFrames дополняет ошибку err, вызванную паникой рабочих горутин, диапазоном кадров
+// [start, end), при обработке которого она произошла; остальные ошибки возвращаются без изменений.
+func withFrames(err error, start, end int) error {
+	var p *PanicError
+	if errors.As(err, &p) {
+		return fmt.Errorf("frames %d-%d: %w", start+1, end, err)
+	}
+	return err
+}
+
+// recoverPanic перехватывает панику метода Runner (в том числе повторенную parallel.Rows
+// панику рабочей горутины) и записывает ее в *err. Вызывается через defer в публичных методах.
+func recoverPanic(err *error) {
+	v := recover()
+	if v == nil {
+		return
+	}
+	if e, ok := v.(error); ok {
+		var p *PanicError
+		if errors.As(e, &p) {
+			*err = fmt.Errorf("calculation failed: %w", e)
+			return
+		}
+	}
+	*err = fmt.Errorf("calculation failed: %w", &PanicError{Index: -1, Value: v, Stack: debug.Stack()})
+}
@@ -0,0 +1,124 @@
+package tlasca
+
+import "fmt"
+
+// Виды контраста (Params.Mode).
+const (
+	// ContrastTemporal - временной контраст (tLASCA): одна карта по всей последовательности.
+	ContrastTemporal = "temporal"
+	// ContrastSpatial - пространственный контраст (sLASCA): карта для каждого кадра (см. RunSpatial).
+	ContrastSpatial = "spatial"
+	// ContrastSpatiotemporal - пространственно-временной контраст (stLASCA): карта для каждой
+	// группы из TemporalDepth кадров (см. RunSpatiotemporal).
+	ContrastSpatiotemporal = "spatiotemporal"
+)
+
+// Раскладки порции кадров в памяти (Params.StackLayout).
+const (
+	// LayoutFrames - покадровая раскладка (кадры используются так, как загружены).
+	LayoutFrames = "frames"
+	// LayoutPlanar - раскладка "время - быстрый индекс" (см. planarStack).
+	LayoutPlanar = "planar"
+)
+
+// Способы расчета временных статистик (Params.ComputeMode).
+const (
+	// ModeDeterministic - двухпроходный расчет в фиксированном порядке суммирования.
+	ModeDeterministic = "deterministic"
+	// ModeFast - однопроходный расчет по сумме и сумме квадратов (см. fastMoments).
+	ModeFast = "fast"
+	// ModeStreaming - однопроходный расчет по алгоритму Уэлфорда с учетом кадров по одному
+	// (см. RunStream); в порционных расчетах используется двухпроходный расчет.
+	ModeStreaming = "streaming"
+)
+
+// Params содержит параметры алгоритма tLASCA.
+// Пустые строки Mode, StackLayout и ComputeMode означают значения по умолчанию
+// (ContrastTemporal, LayoutFrames и ModeDeterministic).
+type Params struct {
+	// Mode - вид контраста: временной (Run, RunChunked), пространственный (RunSpatial)
+	// или пространственно-временной (RunSpatiotemporal).
+	Mode string
+	// WindowSize - сторона (в пикселях) квадратного окна пространственного усреднения
+	// попиксельного временного контраста; 1 означает отсутствие усреднения.
+	WindowSize int
+	// StackLayout - раскладка порции кадров в памяти при расчете статистик.
+	StackLayout string
+	// ComputeMode - способ расчета временных статистик.
+	ComputeMode string
+	// TemporalDepth - число последовательных кадров в группе пространственно-временного
+	// контраста; для других видов контраста не используется.
+	TemporalDepth int
+	// TemporalWindow - число кадров окна, сдвигаемого по последовательности в RunSliding
+	// (0 - одна карта по всей последовательности); TemporalStep - шаг сдвига окна в кадрах
+	// (0 - шаг, равный окну). Используются только для временного контраста.
+	TemporalWindow int
+	TemporalStep   int
+}
+
+// Validate проверяет параметры: размер окна должен быть положительным (для пространственного
+// контраста - не меньше 2), глубина группы пространственно-временного контраста -
+// не меньше 2, скользящее окно - пустым или не меньше 2 кадров (только для временного
+// контраста), вид контраста, раскладка и способ расчета - одними из известных значений.
+func (p Params) Validate() error {
+	if p.WindowSize < 1 {
+		return fmt.Errorf("window_size must be positive, got %d", p.WindowSize)
+	}
+	switch p.Mode {
+	case "", ContrastTemporal:
+	case ContrastSpatial:
+		if p.WindowSize < 2 {
+			return fmt.Errorf("spatial mode requires window_size of at least 2, got %d", p.WindowSize)
+		}
+	case ContrastSpatiotemporal:
+		if p.TemporalDepth < 2 {
+			return fmt.Errorf("spatiotemporal mode requires temporal_depth of at least 2, got %d", p.TemporalDepth)
+		}
+	default:
+		return fmt.Errorf("unknown mode '%s', expected '%s', '%s' or '%s'",
+			p.Mode, ContrastTemporal, ContrastSpatial, ContrastSpatiotemporal)
+	}
+	if p.TemporalWindow != 0 {
+		if p.TemporalWindow < 2 {
+			return fmt.Errorf("temporal_window must be at least 2 frames, got %d", p.TemporalWindow)
+		}
+		if p.Mode != "" && p.Mode != ContrastTemporal {
+			return fmt.Errorf("temporal_window requires '%s' mode, got '%s'", ContrastTemporal, p.Mode)
+		}
+	}
+	if p.TemporalStep < 0 {
+		return fmt.Errorf("temporal_step must not be negative, got %d", p.TemporalStep)
+	}
+	switch p.StackLayout {
+	case "", LayoutFrames, LayoutPlanar:
+	default:
+		return fmt.Errorf("unknown stack_layout '%s', expected '%s' or '%s'", p.StackLayout, LayoutFrames, LayoutPlanar)
+	}
+	switch p.ComputeMode {
+	case "", ModeDeterministic, ModeFast, ModeStreaming:
+	default:
+		return fmt.Errorf("unknown compute_mode '%s', expected '%s', '%s' or '%s'", p.ComputeMode, ModeDeterministic, ModeFast, ModeStreaming)
+	}
+	return nil
+}
+
+// Telemetry фиксирует длительность этапов расчета ("statistics", "transpose", "contrast_map",
+// "spatial", "st_contrast", "contributions", "quantiles", "convergence"); ей удовлетворяет
+// *telemetry.Recorder программы go-tlasca.
+// Start начинает измерение этапа и возвращает функцию, завершающую его.
+type Telemetry interface {
+	Start(stage string) (stop func())
+}
+
+// noTelemetry - Telemetry, не выполняющая измерений.
+type noTelemetry struct{}
+
+func (noTelemetry) Start(string) func() { return func() {} }
+
+// checkFrame возвращает ошибку, если окно WindowSize не помещается в кадр width x height.
+func (p Params) checkFrame(width, height int) error {
+	if p.WindowSize > width || p.WindowSize > height {
+		return fmt.Errorf("window_size %d does not fit the %dx%d frame", p.WindowSize, width, height)
+	}
+	return nil
+}
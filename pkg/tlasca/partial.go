@@ -0,0 +1,346 @@
+package tlasca
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"io"
+	"math"
+	"os"
+	"sort"
+
+	"github.com/mascotmascot1/go-tlasca/internal/atomicfile"
+	"github.com/mascotmascot1/go-tlasca/pkg/frame"
+	"github.com/mascotmascot1/go-tlasca/pkg/mask"
+)
+
+// partialMagic - сигнатура и версия формата файла частичного результата.
+const partialMagic = "TLPART02"
+
+// Partial - частичный результат распределенного (тайлового) расчета: достаточные
+// статистики (n, mean, M2) пикселей прямоугольного участка кадра Tile по кадрам
+// последовательности [FrameStart, FrameEnd), накопленные отдельно для каждой порции
+// из ChunkSize кадров.
+//
+// Статистики каждого пикселя вычисляются независимо от соседних, а границы порций
+// отсчитываются от начала последовательности (как в RunChunked), поэтому частичные
+// результаты, покрывающие весь кадр и всю последовательность, объединяются в точности
+// (побитово) в те же статистики, что и при расчете на одной машине с тем же размером
+// порции: статистики порций объединяются в том же порядке. Окно усреднения применяется
+// уже после объединения. Перекрывающиеся участки допускаются: их значения обязаны
+// совпадать, что служит проверкой согласованности входных данных разных исполнителей.
+type Partial struct {
+	// FrameWidth, FrameHeight - размеры полного кадра.
+	FrameWidth, FrameHeight int
+	// Tile - участок кадра, к которому относятся статистики.
+	Tile image.Rectangle
+	// FrameStart, FrameEnd - диапазон кадров последовательности [FrameStart, FrameEnd).
+	FrameStart, FrameEnd int
+	// ChunkSize - число кадров в порции (0 - весь диапазон одной порцией).
+	ChunkSize int
+
+	chunks []partialChunk
+}
+
+// partialChunk - статистики участка по одной порции кадров [start, end) последовательности.
+// Порции без читаемых кадров не сохраняются.
+type partialChunk struct {
+	start, end int
+	stats      *temporalStats
+}
+
+// partialHeader - заголовок файла частичного результата (все поля - int64, little-endian).
+// За ним следуют Chunks порций: заголовок порции и плоскости mean и M2 участка.
+type partialHeader struct {
+	FrameWidth, FrameHeight int64
+	MinX, MinY, MaxX, MaxY  int64
+	FrameStart, FrameEnd    int64
+	ChunkSize, Chunks       int64
+}
+
+// chunkHeader - заголовок порции в файле частичного результата.
+type chunkHeader struct {
+	Start, End, N int64
+}
+
+// RunPartial рассчитывает частичный результат для участка tile кадров с индексами
+// [frameStart, frameStart+total) последовательности. Кадры загружаются через load порциями
+// по chunkSize (chunkSize <= 0 - одной порцией) с индексами относительно frameStart;
+// opts.Gains задаются для этих total кадров; пропущенные загрузчиком (nil) кадры не учитываются.
+// Статистики порций сохраняются раздельно, поэтому chunkSize должен совпадать у всех
+// исполнителей и с расчетом на одной машине, а frameStart - быть кратным chunkSize.
+// Маска исключения применяется при объединении. После отмены ctx расчет прерывается с ошибкой.
+func (r *Runner) RunPartial(ctx context.Context, tile image.Rectangle, frameStart, total, chunkSize int, load ChunkLoader, opts Options) (_ *Partial, err error) {
+	defer recoverPanic(&err)
+	r.logger.Printf("starting partial calculation for tile %v, frames %d-%d...\n", tile, frameStart+1, frameStart+total)
+	if tile.Empty() {
+		return nil, fmt.Errorf("tile %v is empty", tile)
+	}
+	part := &Partial{Tile: tile, FrameStart: frameStart, FrameEnd: frameStart + total, ChunkSize: max(chunkSize, 0)}
+	if chunkSize <= 0 {
+		chunkSize = total
+	} else if frameStart%chunkSize != 0 {
+		return nil, fmt.Errorf("frame range must start at a chunk boundary: first frame %d does not start a chunk of %d frames", frameStart+1, chunkSize)
+	}
+
+	n := 0
+	frames := newProgress(opts.Progress, ProgressFrames, total)
+	for start := 0; start < total; start += chunkSize {
+		if err := cancelled(ctx); err != nil {
+			return nil, err
+		}
+		end := min(start+chunkSize, total)
+		images, err := load(start, end)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load chunk [%d, %d): %w", start, end, err)
+		}
+		var chunkGains []float64
+		if opts.Gains != nil {
+			chunkGains = opts.Gains[start:end]
+		}
+		images, chunkGains = readable(images, chunkGains)
+		if len(images) == 0 {
+			frames.add(end - start)
+			continue
+		}
+		bounds := images[0].Bounds()
+		if !tile.In(bounds) {
+			return nil, fmt.Errorf("tile %v is outside the frame %v", tile, bounds)
+		}
+		if part.chunks == nil {
+			if err := r.algorithm.checkFrame(bounds.Dx(), bounds.Dy()); err != nil {
+				return nil, err
+			}
+			part.FrameWidth, part.FrameHeight = bounds.Dx(), bounds.Dy()
+		} else if bounds.Dx() != part.FrameWidth || bounds.Dy() != part.FrameHeight {
+			return nil, fmt.Errorf("chunk [%d, %d) has frame size %dx%d, expected %dx%d",
+				frameStart+start, frameStart+end, bounds.Dx(), bounds.Dy(), part.FrameWidth, part.FrameHeight)
+		}
+		cropped := make([]frame.Frame, len(images))
+		for i, img := range images {
+			cropped[i] = frame.Crop(img, tile)
+		}
+
+		chunk, err := r.computeStats(ctx, cropped, chunkGains, nil)
+		if err != nil {
+			return nil, withFrames(err, frameStart+start, frameStart+end)
+		}
+		frames.add(end - start)
+		part.chunks = append(part.chunks, partialChunk{start: frameStart + start, end: frameStart + end, stats: chunk})
+		n += chunk.n
+	}
+	if n < 2 {
+		return nil, fmt.Errorf("at least 2 readable frames are required")
+	}
+	return part, nil
+}
+
+// MergePartials объединяет частичные результаты и рассчитывает по ним карту контраста.
+//
+// Порядок частей в parts не важен: перед объединением они упорядочиваются по диапазону
+// кадров и положению участка, поэтому результат не зависит от порядка поступления частей.
+// Диапазоны кадров должны непрерывно покрывать последовательность с первого кадра, а все
+// части - иметь одинаковый размер порции. Для каждой порции участки собираются в статистики
+// полного кадра (каждый пиксель должен быть покрыт, значения в перекрытиях должны совпадать),
+// после чего порции объединяются по формуле Чана в порядке кадров, как в RunChunked. Поэтому
+// результат побитово совпадает с RunChunked с тем же размером порции (при ChunkSize = 0 -
+// с Run, и тогда диапазон кадров должен быть один). Возвращает ошибку, если разбиение
+// по кадрам не совпадает с границами порций. После отмены ctx расчет карты прерывается с ошибкой.
+func (r *Runner) MergePartials(ctx context.Context, parts []*Partial, exclusion *mask.Mask) (_ *Result, err error) {
+	defer recoverPanic(&err)
+	if len(parts) == 0 {
+		return nil, fmt.Errorf("no partial results to merge")
+	}
+	sorted := append([]*Partial(nil), parts...)
+	sort.Slice(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		if a.FrameStart != b.FrameStart {
+			return a.FrameStart < b.FrameStart
+		}
+		if a.Tile.Min.Y != b.Tile.Min.Y {
+			return a.Tile.Min.Y < b.Tile.Min.Y
+		}
+		return a.Tile.Min.X < b.Tile.Min.X
+	})
+	if sorted[0].FrameStart != 0 {
+		return nil, fmt.Errorf("frame ranges must start at frame 1, got %d", sorted[0].FrameStart+1)
+	}
+
+	width, height := sorted[0].FrameWidth, sorted[0].FrameHeight
+	if err := r.algorithm.checkFrame(width, height); err != nil {
+		return nil, err
+	}
+	chunkSize := sorted[0].ChunkSize
+	var total *temporalStats
+	for i := 0; i < len(sorted); {
+		group := sorted[i]
+		j := i
+		for j < len(sorted) && sorted[j].FrameStart == group.FrameStart {
+			if sorted[j].ChunkSize != chunkSize {
+				return nil, fmt.Errorf("tile %v of frames %d-%d has chunk size %d, expected %d",
+					sorted[j].Tile, sorted[j].FrameStart+1, sorted[j].FrameEnd, sorted[j].ChunkSize, chunkSize)
+			}
+			j++
+		}
+		if i > 0 {
+			if group.FrameStart != sorted[i-1].FrameEnd {
+				return nil, fmt.Errorf("frame ranges are not contiguous: expected frame %d, got %d", sorted[i-1].FrameEnd+1, group.FrameStart+1)
+			}
+			// Порции на одной машине не разрываются на границах диапазонов исполнителей.
+			if chunkSize == 0 || group.FrameStart%chunkSize != 0 {
+				return nil, fmt.Errorf("frame range starting at frame %d does not start at a chunk boundary (chunk size %d); "+
+					"split frames at multiples of algorithm.chunk_size", group.FrameStart+1, chunkSize)
+			}
+		}
+		for c := range group.chunks {
+			stats, err := assemble(sorted[i:j], c, width, height)
+			if err != nil {
+				return nil, fmt.Errorf("frames %d-%d: %w", group.chunks[c].start+1, group.chunks[c].end, err)
+			}
+			if total == nil {
+				total = newTemporalStats(width, height)
+			}
+			total.merge(stats)
+		}
+		r.logger.Printf("merged %d tiles for frames %d-%d.\n", j-i, group.FrameStart+1, group.FrameEnd)
+		i = j
+	}
+	res, err := r.calculateContrastMap(ctx, total, Options{Exclusion: exclusion})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// assemble собирает статистики полного кадра width x height по порции chunk участков
+// с одинаковым диапазоном кадров, проверяя совпадение порций, полноту покрытия
+// и совпадение значений в перекрытиях.
+func assemble(parts []*Partial, chunk, width, height int) (*temporalStats, error) {
+	first := parts[0].chunks[chunk]
+	stats := newTemporalStats(width, height)
+	stats.n = first.stats.n
+	covered := make([]bool, width*height)
+	for _, p := range parts {
+		if p.FrameWidth != width || p.FrameHeight != height {
+			return nil, fmt.Errorf("tile %v has frame size %dx%d, expected %dx%d", p.Tile, p.FrameWidth, p.FrameHeight, width, height)
+		}
+		if p.FrameEnd != parts[0].FrameEnd || len(p.chunks) != len(parts[0].chunks) {
+			return nil, fmt.Errorf("tile %v covers frames %d-%d in %d chunks, expected %d-%d in %d chunks",
+				p.Tile, p.FrameStart+1, p.FrameEnd, len(p.chunks), parts[0].FrameStart+1, parts[0].FrameEnd, len(parts[0].chunks))
+		}
+		c := p.chunks[chunk]
+		if c.start != first.start || c.end != first.end || c.stats.n != stats.n {
+			return nil, fmt.Errorf("tile %v has %d readable frames in chunk %d-%d, expected %d in chunk %d-%d",
+				p.Tile, c.stats.n, c.start+1, c.end, stats.n, first.start+1, first.end)
+		}
+		for y := p.Tile.Min.Y; y < p.Tile.Max.Y; y++ {
+			for x := p.Tile.Min.X; x < p.Tile.Max.X; x++ {
+				src := (y-p.Tile.Min.Y)*c.stats.width + (x - p.Tile.Min.X)
+				dst := y*width + x
+				mean, m2 := c.stats.mean[src], c.stats.m2[src]
+				if covered[dst] {
+					if stats.mean[dst] != mean || stats.m2[dst] != m2 {
+						return nil, fmt.Errorf("overlapping tiles disagree at pixel (%d, %d)", x, y)
+					}
+					continue
+				}
+				stats.mean[dst], stats.m2[dst] = mean, m2
+				covered[dst] = true
+			}
+		}
+	}
+	for i, ok := range covered {
+		if !ok {
+			return nil, fmt.Errorf("pixel (%d, %d) is not covered by any tile", i%width, i/width)
+		}
+	}
+	return stats, nil
+}
+
+// WritePartial сохраняет частичный результат в файл path: сигнатура, заголовок и для каждой
+// порции - ее заголовок и плоскости mean и M2 участка в формате float64 little-endian
+// (значения сохраняются точно).
+func WritePartial(path string, p *Partial) error {
+	return atomicfile.Write(path, func(w io.Writer) error {
+		header := partialHeader{
+			FrameWidth: int64(p.FrameWidth), FrameHeight: int64(p.FrameHeight),
+			MinX: int64(p.Tile.Min.X), MinY: int64(p.Tile.Min.Y), MaxX: int64(p.Tile.Max.X), MaxY: int64(p.Tile.Max.Y),
+			FrameStart: int64(p.FrameStart), FrameEnd: int64(p.FrameEnd),
+			ChunkSize: int64(p.ChunkSize), Chunks: int64(len(p.chunks)),
+		}
+		if _, err := io.WriteString(w, partialMagic); err != nil {
+			return err
+		}
+		if err := binary.Write(w, binary.LittleEndian, header); err != nil {
+			return err
+		}
+		for _, c := range p.chunks {
+			if err := binary.Write(w, binary.LittleEndian, chunkHeader{int64(c.start), int64(c.end), int64(c.stats.n)}); err != nil {
+				return err
+			}
+			for _, plane := range [][]float64{c.stats.mean, c.stats.m2} {
+				if err := binary.Write(w, binary.LittleEndian, plane); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// ReadPartial загружает частичный результат, сохраненный WritePartial.
+func ReadPartial(path string) (*Partial, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	r := bufio.NewReader(file)
+	magic := make([]byte, len(partialMagic))
+	if _, err = io.ReadFull(r, magic); err != nil || string(magic) != partialMagic {
+		return nil, fmt.Errorf("'%s' is not a partial result file of the current format", path)
+	}
+	var header partialHeader
+	if err = binary.Read(r, binary.LittleEndian, &header); err != nil {
+		return nil, fmt.Errorf("failed to read header: %w", err)
+	}
+
+	tile := image.Rect(int(header.MinX), int(header.MinY), int(header.MaxX), int(header.MaxY))
+	frames := header.FrameEnd - header.FrameStart
+	if tile.Empty() || frames < 2 || header.Chunks < 1 || header.Chunks > frames || header.ChunkSize < 0 ||
+		tile.Dx()*tile.Dy() > math.MaxInt32 {
+		return nil, fmt.Errorf("invalid header: tile %v, %d frames in %d chunks", tile, frames, header.Chunks)
+	}
+	p := &Partial{
+		FrameWidth:  int(header.FrameWidth),
+		FrameHeight: int(header.FrameHeight),
+		Tile:        tile,
+		FrameStart:  int(header.FrameStart),
+		FrameEnd:    int(header.FrameEnd),
+		ChunkSize:   int(header.ChunkSize),
+	}
+	if !tile.In(image.Rect(0, 0, p.FrameWidth, p.FrameHeight)) {
+		return nil, fmt.Errorf("tile %v is outside the frame %dx%d", tile, p.FrameWidth, p.FrameHeight)
+	}
+	for range header.Chunks {
+		var ch chunkHeader
+		if err = binary.Read(r, binary.LittleEndian, &ch); err != nil {
+			return nil, fmt.Errorf("failed to read chunk header: %w", err)
+		}
+		if ch.Start < header.FrameStart || ch.End > header.FrameEnd || ch.N < 1 || ch.N > ch.End-ch.Start {
+			return nil, fmt.Errorf("invalid chunk: frames %d-%d, %d readable", ch.Start+1, ch.End, ch.N)
+		}
+		c := partialChunk{start: int(ch.Start), end: int(ch.End), stats: newTemporalStats(tile.Dx(), tile.Dy())}
+		c.stats.n = int(ch.N)
+		for _, plane := range [][]float64{c.stats.mean, c.stats.m2} {
+			if err = binary.Read(r, binary.LittleEndian, plane); err != nil {
+				return nil, fmt.Errorf("failed to read statistics: %w", err)
+			}
+		}
+		p.chunks = append(p.chunks, c)
+	}
+	return p, nil
+}
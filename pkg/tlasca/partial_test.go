@@ -0,0 +1,160 @@
+package tlasca
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"math"
+	"math/rand/v2"
+	"path/filepath"
+	"testing"
+
+	"github.com/mascotmascot1/go-tlasca/pkg/frame"
+)
+
+// randomFrames возвращает count кадров width x height со случайными 16-битными отсчетами.
+func randomFrames(rng *rand.Rand, count, width, height int) []frame.Frame {
+	frames := make([]frame.Frame, count)
+	for t := range frames {
+		img := image.NewGray16(image.Rect(0, 0, width, height))
+		for i := 0; i < len(img.Pix); i += 2 {
+			v := 1000 + rng.IntN(3000)
+			img.Pix[i], img.Pix[i+1] = byte(v>>8), byte(v)
+		}
+		frames[t] = frame.NewGray16(img)
+	}
+	return frames
+}
+
+// partialJob - участок кадра и диапазон кадров [first, last) одного исполнителя.
+type partialJob struct {
+	tile        image.Rectangle
+	first, last int
+}
+
+// runJobs рассчитывает частичные результаты заданий jobs в перемешанном порядке, сохраняет
+// их в файлы и загружает обратно, как это делают исполнители и tlasca-merge.
+func runJobs(t *testing.T, rng *rand.Rand, runner *Runner, frames []frame.Frame, gains []float64,
+	chunkSize int, jobs []partialJob) []*Partial {
+	t.Helper()
+	dir := t.TempDir()
+	parts := make([]*Partial, len(jobs))
+	for i, j := range rng.Perm(len(jobs)) {
+		job := jobs[j]
+		part, err := runner.RunPartial(context.Background(), job.tile, job.first, job.last-job.first, chunkSize,
+			func(start, end int) ([]frame.Frame, error) {
+				return frames[job.first+start : job.first+end], nil
+			}, Options{Gains: gains[job.first:job.last]})
+		if err != nil {
+			t.Fatalf("RunPartial(%v, %d-%d): %v", job.tile, job.first, job.last, err)
+		}
+		path := filepath.Join(dir, fmt.Sprintf("%d.tpart", i))
+		if err = WritePartial(path, part); err != nil {
+			t.Fatalf("WritePartial: %v", err)
+		}
+		if parts[i], err = ReadPartial(path); err != nil {
+			t.Fatalf("ReadPartial: %v", err)
+		}
+	}
+	return parts
+}
+
+// sameResult сообщает о первом расхождении битов карты, среднего и стандартного отклонения.
+func sameResult(t *testing.T, got, want *Result) {
+	t.Helper()
+	for _, plane := range []struct {
+		name      string
+		got, want []float64
+	}{
+		{"contrast", got.Contrast, want.Contrast},
+		{"mean", got.Mean, want.Mean},
+		{"stddev", got.StdDev, want.StdDev},
+	} {
+		if len(plane.got) != len(plane.want) {
+			t.Fatalf("%s: len = %d, want %d", plane.name, len(plane.got), len(plane.want))
+		}
+		for i := range plane.want {
+			if math.Float64bits(plane.got[i]) != math.Float64bits(plane.want[i]) {
+				t.Fatalf("%s[%d] = %v, want %v (bitwise)", plane.name, i, plane.got[i], plane.want[i])
+			}
+		}
+	}
+}
+
+// TestMergePartialsBitwise проверяет, что объединение перемешанных частичных результатов
+// по участкам и диапазонам кадров побитово совпадает с расчетом на одной машине.
+func TestMergePartialsBitwise(t *testing.T) {
+	const width, height, count = 23, 17, 40
+	rng := rand.New(rand.NewPCG(1, 2))
+	frames := randomFrames(rng, count, width, height)
+	gains := make([]float64, count)
+	for i := range gains {
+		gains[i] = 16 + rng.Float64()
+	}
+	tiles := []image.Rectangle{
+		image.Rect(0, 0, 10, 9), image.Rect(10, 0, width, 9),
+		image.Rect(0, 9, 15, height), image.Rect(12, 7, width, height),
+	}
+	jobs := func(bounds ...int) []partialJob {
+		var out []partialJob
+		for i := 1; i < len(bounds); i++ {
+			for _, tile := range tiles {
+				out = append(out, partialJob{tile, bounds[i-1], bounds[i]})
+			}
+		}
+		return out
+	}
+	runner := NewRunner(Params{WindowSize: 3}, nil, nil)
+	ctx := context.Background()
+
+	t.Run("tiles", func(t *testing.T) {
+		want, err := runner.Run(ctx, frames, Options{Gains: gains})
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := runner.MergePartials(ctx, runJobs(t, rng, runner, frames, gains, 0, jobs(0, count)), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sameResult(t, got, want)
+	})
+
+	t.Run("tiles and frame ranges", func(t *testing.T) {
+		const chunkSize = 8
+		want, err := runner.RunChunked(ctx, count, chunkSize, func(start, end int) ([]frame.Frame, error) {
+			return frames[start:end], nil
+		}, Options{Gains: gains})
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := runner.MergePartials(ctx, runJobs(t, rng, runner, frames, gains, chunkSize, jobs(0, 16, 24, count)), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sameResult(t, got, want)
+	})
+
+	t.Run("single chunk matches Run", func(t *testing.T) {
+		want, err := runner.Run(ctx, frames, Options{Gains: gains})
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := runner.MergePartials(ctx, runJobs(t, rng, runner, frames, gains, count, jobs(0, count)), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sameResult(t, got, want)
+	})
+
+	t.Run("misaligned frame ranges", func(t *testing.T) {
+		parts := runJobs(t, rng, runner, frames, gains, 0, jobs(0, 20, count))
+		if _, err := runner.MergePartials(ctx, parts, nil); err == nil {
+			t.Fatal("frame ranges without chunks were merged")
+		}
+		if _, err := runner.RunPartial(ctx, tiles[0], 12, 8, 8, func(start, end int) ([]frame.Frame, error) {
+			return frames[12+start : 12+end], nil
+		}, Options{}); err == nil {
+			t.Fatal("frame range starting inside a chunk was accepted")
+		}
+	})
+}
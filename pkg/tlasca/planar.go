@@ -0,0 +1,114 @@
+package tlasca
+
+import (
+	"context"
+	"math"
+
+	"github.com/mascotmascot1/go-tlasca/internal/parallel"
+	"github.com/mascotmascot1/go-tlasca/pkg/frame"
+)
+
+// planarStack хранит порцию кадров в раскладке "время - быстрый индекс": отсчеты всех
+// кадров порции для одного пикселя лежат в памяти подряд (pix[(y*width+x)*frames + t]).
+// Построение стоит одного прохода транспонирования, зато циклы временных статистик
+// читают память последовательно, а не по одному отсчету из каждого кадра.
+type planarStack struct {
+	width, height, frames int
+	pix                   []uint16
+}
+
+// newPlanarStack транспонирует кадры images (одного размера) в раскладку planarStack.
+// Строки обрабатываются параллельно; возвращает ошибку, если ctx отменен до завершения.
+func newPlanarStack(ctx context.Context, images []frame.Frame) (*planarStack, error) {
+	bounds := images[0].Bounds()
+	p := &planarStack{
+		width:  bounds.Dx(),
+		height: bounds.Dy(),
+		frames: len(images),
+		pix:    make([]uint16, bounds.Dx()*bounds.Dy()*len(images)),
+	}
+	failed := parallel.TryRows(p.height, func(startY, endY int) {
+		buf := frame.RowBuffer(images[0])
+		for y := startY; y < endY; y++ {
+			if ctx.Err() != nil {
+				return
+			}
+			for t, img := range images {
+				dst := p.pix[y*p.width*p.frames+t:]
+				for x, v := range img.Row(bounds.Min.Y+y, buf) {
+					dst[x*p.frames] = v
+				}
+			}
+		}
+	})
+	if failed != nil {
+		return nil, failed
+	}
+	if err := cancelled(ctx); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// stats вычисляет статистики порции так же, как computeChunkStats (в два прохода,
+// в том же порядке суммирования по кадрам), поэтому результаты совпадают побитово.
+// gains задает попадровые коэффициенты; nil означает единичные коэффициенты.
+// При fast статистики вычисляются за один проход, как в computeChunkStats.
+// Отмена ctx и учет обработанных строк в done выполняются так же, как в computeChunkStats.
+func (p *planarStack) stats(ctx context.Context, gains []float64, fast bool, done *progress) (*temporalStats, error) {
+	s := newTemporalStats(p.width, p.height)
+	s.n = p.frames
+	n := float64(p.frames)
+	if gains == nil {
+		gains = make([]float64, p.frames)
+		for i := range gains {
+			gains[i] = 1
+		}
+	}
+
+	rows := make([]bool, p.height)
+	failed := parallel.TryRows(p.height, func(startY, endY int) {
+		for i := startY * p.width; i < endY*p.width; i++ {
+			if i%p.width == 0 && ctx.Err() != nil {
+				return
+			}
+			samples := p.pix[i*p.frames : (i+1)*p.frames]
+			if fast {
+				var sum, sumSq float64
+				for t, v := range samples {
+					value := float64(float64(v) * gains[t])
+					sum += value
+					sumSq = math.FMA(value, value, sumSq)
+				}
+				s.mean[i], s.m2[i] = fastMoments(sum, sumSq, n)
+			} else {
+				var mean float64
+				for t, v := range samples {
+					// Преобразования float64 запрещают объединение в FMA (см. accumulate.go).
+					mean += float64(float64(v) * gains[t])
+				}
+				mean /= n
+
+				var sumDiff2 float64
+				for t, v := range samples {
+					diff := float64(float64(v)*gains[t]) - mean
+					sumDiff2 += float64(diff * diff)
+				}
+				s.mean[i] = mean
+				s.m2[i] = sumDiff2
+			}
+			if (i+1)%p.width == 0 {
+				rows[i/p.width] = true
+				done.add(1)
+			}
+		}
+	})
+	if failed != nil {
+		return nil, failed
+	}
+	if err := cancelled(ctx); err != nil {
+		s.rows = rows
+		return s, err
+	}
+	return s, nil
+}
@@ -0,0 +1,79 @@
+package tlasca
+
+import "sync"
+
+// Этапы расчета, о выполнении которых сообщается через Options.Progress.
+const (
+	// ProgressStatistics - временные статистики последовательности в памяти (Run), в строках кадра.
+	ProgressStatistics = "statistics"
+	// ProgressFrames - обработка кадров последовательности порциями или по одному
+	// (RunChunked, RunStream, RunPartial), в кадрах.
+	ProgressFrames = "frames"
+	// ProgressWindows - карты временного ряда (RunSliding), в положениях окна.
+	ProgressWindows = "windows"
+	// ProgressContrastMap - усреднение контраста окном (Run, RunChunked, RunStream), в строках карты.
+	ProgressContrastMap = "contrast_map"
+)
+
+// Progress описывает выполнение этапа расчета.
+type Progress struct {
+	// Stage - этап расчета (см. ProgressStatistics и другие константы).
+	Stage string `json:"stage"`
+	// Done и Total - число выполненных и общее число единиц работы этапа
+	// (строк, кадров или положений окна, см. описание этапа). Total = 0, если общее
+	// число неизвестно (источник RunStream без метода Len).
+	Done  int `json:"done"`
+	Total int `json:"total"`
+}
+
+// Percent возвращает долю выполненной работы этапа в процентах (0, если Total неизвестно).
+func (p Progress) Percent() float64 {
+	if p.Total == 0 {
+		return 0
+	}
+	return 100 * float64(p.Done) / float64(p.Total)
+}
+
+// ProgressFunc получает сведения о выполнении этапов расчета. Вызовы последовательны
+// (функция может не быть безопасной для конкурентного использования), выполняются
+// в рабочих горутинах расчета и поэтому должны быть быстрыми. О каждом этапе сообщается
+// не чаще, чем при изменении выполненной доли на целый процент, и всегда по завершении;
+// если общее число единиц работы неизвестно - о каждой единице.
+type ProgressFunc func(Progress)
+
+// progress подсчитывает выполненную работу этапа и сообщает о ней в ProgressFunc.
+// Нулевой указатель (отчеты не нужны) допустим и ничего не делает.
+type progress struct {
+	mu      sync.Mutex
+	fn      ProgressFunc
+	stage   string
+	done    int
+	total   int
+	percent int
+}
+
+// newProgress возвращает счетчик этапа stage из total единиц или nil, если fn - nil.
+func newProgress(fn ProgressFunc, stage string, total int) *progress {
+	if fn == nil {
+		return nil
+	}
+	return &progress{fn: fn, stage: stage, total: total, percent: -1}
+}
+
+// add учитывает n выполненных единиц работы. Безопасен для конкурентного использования.
+func (p *progress) add(n int) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.done += n
+	percent := p.done
+	if p.total > 0 {
+		percent = p.done * 100 / p.total
+	}
+	if percent > p.percent {
+		p.percent = percent
+		p.fn(Progress{Stage: p.stage, Done: p.done, Total: p.total})
+	}
+}
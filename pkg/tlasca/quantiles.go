@@ -0,0 +1,159 @@
+package tlasca
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"slices"
+
+	"github.com/mascotmascot1/go-tlasca/internal/parallel"
+	"github.com/mascotmascot1/go-tlasca/pkg/frame"
+)
+
+// Quantiles вычисляет карты временных квантилей интенсивности: для каждого квантиля
+// из quantiles (в процентах, [0, 100]) возвращает плоскость в геометрии кадра
+// (y*FrameWidth + x) с p-м процентилем ряда интенсивности пикселя по всем кадрам,
+// в тех же единицах, что и Result.Mean. Процентиль выбирается, как в render.Percentile:
+// значение ранга round(p/100 * (n-1)) упорядоченного ряда.
+//
+// В отличие от среднего и дисперсии, квантили не объединяются по порциям, поэтому
+// для каждого пикселя нужны все его отсчеты. Если вся последовательность помещается
+// в одну порцию (chunkSize <= 0 или chunkSize >= total), она загружается через load один раз.
+// Иначе кадр делится на полосы строк, отсчеты которых по всем кадрам занимают
+// столько же памяти, сколько порция из chunkSize кадров, и последовательность
+// читается заново для каждой полосы (примерно total/chunkSize раз).
+//
+// Пропущенные (nil) кадры не учитываются. Возвращает ошибку, если квантиль вне [0, 100],
+// загрузка порции завершилась неудачно, читаемых кадров меньше двух или ctx отменен.
+func (r *Runner) Quantiles(ctx context.Context, quantiles []float64, total, chunkSize int, load ChunkLoader, opts Options) (_ [][]float64, err error) {
+	defer recoverPanic(&err)
+	defer r.telemetry.Start("quantiles")()
+	for _, q := range quantiles {
+		if q < 0 || q > 100 || math.IsNaN(q) {
+			return nil, fmt.Errorf("quantile %g is outside [0, 100]", q)
+		}
+	}
+	if chunkSize <= 0 || chunkSize >= total {
+		images, err := load(0, total)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load frames: %w", err)
+		}
+		images, gains := readable(images, opts.Gains)
+		if len(images) < 2 {
+			return nil, fmt.Errorf("at least 2 readable frames are required")
+		}
+		bounds := images[0].Bounds()
+		planes := newPlanes(len(quantiles), bounds.Dx()*bounds.Dy())
+		quantileRows(ctx, images, gains, quantiles, planes, 0)
+		if err := cancelled(ctx); err != nil {
+			return nil, err
+		}
+		return planes, nil
+	}
+
+	var planes [][]float64
+	var width, height, bandHeight int
+	for y0 := 0; planes == nil || y0 < height; y0 += bandHeight {
+		// Полоса [y0, y0+bandHeight) всех кадров копируется из каждой порции,
+		// чтобы загруженная порция освобождалась до чтения следующей.
+		var band []frame.Frame
+		var bandGains []float64
+		for start := 0; start < total; start += chunkSize {
+			if err := cancelled(ctx); err != nil {
+				return nil, err
+			}
+			end := min(start+chunkSize, total)
+			images, err := load(start, end)
+			if err != nil {
+				return nil, fmt.Errorf("failed to load chunk [%d, %d): %w", start, end, err)
+			}
+			for i, img := range images {
+				if img == nil {
+					continue
+				}
+				bounds := img.Bounds()
+				if planes == nil {
+					width, height = bounds.Dx(), bounds.Dy()
+					bandHeight = max(chunkSize*height/total, 1)
+					planes = newPlanes(len(quantiles), width*height)
+				} else if bounds.Dx() != width || bounds.Dy() != height {
+					return nil, fmt.Errorf("chunk [%d, %d) has frame size %dx%d, expected %dx%d",
+						start, end, bounds.Dx(), bounds.Dy(), width, height)
+				}
+				band = append(band, copyRows(img, y0, min(y0+bandHeight, height)))
+				gain := 1.0
+				if opts.Gains != nil {
+					gain = opts.Gains[start+i]
+				}
+				bandGains = append(bandGains, gain)
+			}
+		}
+		if len(band) < 2 {
+			return nil, fmt.Errorf("at least 2 readable frames are required")
+		}
+		quantileRows(ctx, band, bandGains, quantiles, planes, y0)
+		if err := cancelled(ctx); err != nil {
+			return nil, err
+		}
+	}
+	return planes, nil
+}
+
+// newPlanes создает count плоскостей по size значений.
+func newPlanes(count, size int) [][]float64 {
+	planes := make([][]float64, count)
+	for i := range planes {
+		planes[i] = make([]float64, size)
+	}
+	return planes
+}
+
+// copyRows копирует строки [startY, endY) кадра img (в координатах от верхнего края кадра)
+// в собственный буфер.
+func copyRows(img frame.Frame, startY, endY int) *frame.Raw {
+	bounds := img.Bounds()
+	dst := frame.NewRaw(bounds.Dx(), endY-startY)
+	buf := frame.RowBuffer(img)
+	for y := startY; y < endY; y++ {
+		copy(dst.MutableRow(y-startY), img.Row(bounds.Min.Y+y, buf))
+	}
+	return dst
+}
+
+// quantileRows записывает в planes квантили рядов интенсивности пикселей кадров images
+// (одного размера), умноженных на коэффициенты gains; nil означает единичные коэффициенты.
+// Строка y кадров соответствует строке offsetY+y плоскостей. Строки обрабатываются параллельно;
+// после отмены ctx обработка прекращается, и плоскости остаются неполными.
+func quantileRows(ctx context.Context, images []frame.Frame, gains, quantiles []float64, planes [][]float64, offsetY int) {
+	bounds := images[0].Bounds()
+	width := bounds.Dx()
+	parallel.Rows(bounds.Dy(), func(startY, endY int) {
+		bufs := make([][]uint16, len(images))
+		rows := make([][]uint16, len(images))
+		for t, img := range images {
+			bufs[t] = frame.RowBuffer(img)
+		}
+		series := make([]float64, len(images))
+		for y := startY; y < endY; y++ {
+			if ctx.Err() != nil {
+				return
+			}
+			for t, img := range images {
+				rows[t] = img.Row(bounds.Min.Y+y, bufs[t])
+			}
+			for x := 0; x < width; x++ {
+				for t, row := range rows {
+					series[t] = float64(row[x])
+					if gains != nil {
+						series[t] *= gains[t]
+					}
+				}
+				slices.Sort(series)
+				i := (offsetY+y)*width + x
+				for k, q := range quantiles {
+					planes[k][i] = series[int(math.Round(q/100*float64(len(series)-1)))]
+				}
+			}
+		}
+	})
+}
@@ -0,0 +1,64 @@
+package tlasca
+
+import (
+	"github.com/mascotmascot1/go-tlasca/pkg/mask"
+	"github.com/mascotmascot1/go-tlasca/pkg/units"
+)
+
+// Result содержит результат расчета - карту усредненного временного (или пространственного,
+// см. RunSpatial) контраста в исходных (неквантованных) значениях. Преобразование в изображение выполняется
+// отдельно (см. пакет render), поэтому один результат можно сохранить в разных видах.
+type Result struct {
+	// Width, Height - размеры карты: (ширина кадра - WindowSize + 1) x (высота кадра - WindowSize + 1).
+	Width, Height int
+	// Contrast хранит построчно (y*Width + x) средний контраст окна с верхним левым углом (x, y).
+	Contrast []float64
+	// FrameWidth, FrameHeight - размеры исходных кадров.
+	FrameWidth, FrameHeight int
+	// Mean хранит построчно (y*FrameWidth + x) временное среднее интенсивности каждого пикселя кадра
+	// (в долях полной шкалы, если интенсивности были нормированы).
+	Mean []float64
+	// StdDev хранит построчно (y*FrameWidth + x) временное стандартное отклонение интенсивности
+	// каждого пикселя кадра (выборочное, с N-1 в знаменателе) в тех же единицах, что и Mean.
+	StdDev []float64
+	// Frames - число кадров, по которым рассчитаны временные статистики Mean и StdDev
+	// (0, если карта рассчитана по пространственной статистике).
+	Frames int
+	// Excluded отмечает положения окна, исключенные из расчета маской исключения
+	// (их значение в Contrast равно 0); nil, если исключений нет.
+	Excluded *mask.Mask
+	// Interrupted отмечает частичный результат прерванного расчета (см. InterruptedError).
+	Interrupted bool
+	// Incomplete отмечает положения окна, не рассчитанные из-за прерывания расчета
+	// (их значение в Contrast равно 0); nil, если результат полный.
+	Incomplete *mask.Mask
+	// Units - единицы величин результата.
+	Units Units
+}
+
+// Units описывает единицы величин результата расчета.
+type Units struct {
+	// Contrast - единица карты контраста (units.Dimensionless).
+	Contrast units.Unit
+	// Intensity - единица Mean и StdDev: opts.IntensityUnit расчета (units.Arbitrary,
+	// если единица не задана).
+	Intensity units.Unit
+}
+
+// newUnits возвращает единицы результата с интенсивностью в единицах intensity.
+func newUnits(intensity units.Unit) Units {
+	if intensity == "" {
+		intensity = units.Arbitrary
+	}
+	return Units{Contrast: units.Dimensionless, Intensity: intensity}
+}
+
+// IsExcluded сообщает, исключено ли положение окна (x, y) из расчета.
+func (res *Result) IsExcluded(x, y int) bool {
+	return res.Excluded != nil && res.Excluded.Set[y*res.Width+x]
+}
+
+// IsIncomplete сообщает, осталось ли положение окна (x, y) нерассчитанным из-за прерывания расчета.
+func (res *Result) IsIncomplete(x, y int) bool {
+	return res.Incomplete != nil && res.Incomplete.Set[y*res.Width+x]
+}
@@ -0,0 +1,89 @@
+package tlasca
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/mascotmascot1/go-tlasca/pkg/frame"
+)
+
+// RunSliding рассчитывает временной ряд карт контраста: окно из TemporalWindow
+// последовательных кадров сдвигается по последовательности из total кадров с шагом
+// TemporalStep (0 - шаг, равный окну), и для каждого положения окна рассчитывается
+// карта временного контраста, совпадающая с Run для кадров окна. Карта передается
+// в emit вместе с индексом первого кадра окна; ошибка emit прерывает расчет.
+// Неполное последнее окно не рассчитывается.
+//
+// Кадры загружаются через load по мере сдвига окна, и в памяти одновременно хранится
+// не более одного окна: перекрывающиеся кадры соседних окон повторно не загружаются,
+// а кадры между окнами (при шаге больше окна) не загружаются вовсе. Пропущенные (nil)
+// кадры не учитываются; окно, в котором читаемых кадров меньше двух, пропускается.
+// После отмены ctx расчет прерывается с ошибкой (уже переданные в emit карты остаются в силе).
+func (r *Runner) RunSliding(ctx context.Context, total int, load ChunkLoader, opts Options, emit func(start int, res *Result) error) (err error) {
+	defer recoverPanic(&err)
+	window, step := r.algorithm.TemporalWindow, r.algorithm.TemporalStep
+	if step <= 0 {
+		step = window
+	}
+	r.logger.Printf("starting sliding contrast map calculation (%d frames, window %d, step %d)...\n", total, window, step)
+
+	windows := 0
+	if total >= window {
+		windows = (total-window)/step + 1
+	}
+	progress := newProgress(opts.Progress, ProgressWindows, windows)
+	// Сведения о выполнении сообщаются по окнам, а не по строкам каждой карты.
+	mapOpts := opts
+	mapOpts.Progress = nil
+
+	// buffer хранит загруженные кадры с индексами [bufferStart, bufferStart+len(buffer)).
+	var buffer []frame.Frame
+	bufferStart := 0
+	for start := 0; start+window <= total; start += step {
+		if err := cancelled(ctx); err != nil {
+			return err
+		}
+		if start >= bufferStart+len(buffer) {
+			buffer, bufferStart = nil, start
+		} else {
+			// Копия освобождает кадры, вышедшие из окна.
+			buffer = slices.Clone(buffer[start-bufferStart:])
+			bufferStart = start
+		}
+		if loadStart := bufferStart + len(buffer); loadStart < start+window {
+			images, err := load(loadStart, start+window)
+			if err != nil {
+				return fmt.Errorf("failed to load frames [%d, %d): %w", loadStart, start+window, err)
+			}
+			buffer = append(buffer, images...)
+		}
+
+		var gains []float64
+		if opts.Gains != nil {
+			gains = opts.Gains[start : start+window]
+		}
+		if err := r.checkWindow(buffer); err != nil {
+			return err
+		}
+		stats, err := r.computeStats(ctx, buffer, gains, nil)
+		if err != nil {
+			return withFrames(err, start, start+window)
+		}
+		if stats == nil || stats.n < 2 {
+			r.logger.Printf("skipped window %d-%d of %d: less than 2 readable frames.\n", start+1, start+window, total)
+			progress.add(1)
+			continue
+		}
+		res, err := r.calculateContrastMap(ctx, stats, mapOpts)
+		if err != nil {
+			return err
+		}
+		if err := emit(start, res); err != nil {
+			return err
+		}
+		progress.add(1)
+	}
+	r.logger.Println("calculation finished.")
+	return nil
+}
@@ -0,0 +1,119 @@
+package tlasca
+
+import (
+	"math"
+
+	"github.com/mascotmascot1/go-tlasca/internal/parallel"
+	"github.com/mascotmascot1/go-tlasca/pkg/frame"
+	"github.com/mascotmascot1/go-tlasca/pkg/mask"
+	"github.com/mascotmascot1/go-tlasca/pkg/units"
+)
+
+// RunSpatial вычисляет карту пространственного контраста (sLASCA) одного кадра img:
+// для каждого положения окна WindowSize x WindowSize контраст K = σ/μ рассчитывается
+// по отсчетам окна, где σ - выборочное стандартное отклонение (с N-1 в знаменателе),
+// как и во временном режиме. Интенсивность кадра умножается на gain (нормировка
+// к полной шкале, по экспозиции). Положения окна, задевающие пиксели маски exclusion
+// (может быть nil), не рассчитываются и выводятся со значением 0.
+//
+// Mean результата содержит интенсивность кадра (после умножения на gain), StdDev - nil:
+// временных статистик у одного кадра нет. Единица интенсивности в Units - units.Arbitrary:
+// вызывающий код, которому известна нормировка gain, может ее уточнить.
+func (r *Runner) RunSpatial(img frame.Frame, gain float64, exclusion *mask.Mask) *Result {
+	defer r.telemetry.Start("spatial")()
+	return r.volumeContrast([]frame.Frame{img}, []float64{gain}, exclusion)
+}
+
+// RunSpatiotemporal вычисляет карту пространственно-временного контраста (stLASCA)
+// группы последовательных кадров images: для каждого положения окна контраст K = σ/μ
+// рассчитывается по всем отсчетам объема WindowSize x WindowSize x len(images), т.е.
+// по окну каждого кадра группы вместе. По сравнению с RunSpatial это дает в len(images)
+// раз больше отсчетов на оценку при том же пространственном разрешении, а по сравнению
+// с временным режимом - устойчивую оценку по короткой группе кадров.
+//
+// Интенсивность кадра i умножается на gains[i]; маска exclusion обрабатывается как
+// в RunSpatial. Mean результата содержит среднюю по группе интенсивность, StdDev - nil.
+// Все кадры группы должны иметь одинаковый размер и не быть nil.
+func (r *Runner) RunSpatiotemporal(images []frame.Frame, gains []float64, exclusion *mask.Mask) *Result {
+	defer r.telemetry.Start("st_contrast")()
+	return r.volumeContrast(images, gains, exclusion)
+}
+
+// volumeContrast вычисляет контраст по отсчетам окна WindowSize x WindowSize всех кадров
+// images (для RunSpatial - одного кадра).
+func (r *Runner) volumeContrast(images []frame.Frame, gains []float64, exclusion *mask.Mask) *Result {
+	bounds := images[0].Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	planes := make([][]float64, len(images))
+	buf := frame.RowBuffer(images[0])
+	for i, img := range images {
+		planes[i] = make([]float64, width*height)
+		for y := 0; y < height; y++ {
+			for x, v := range img.Row(bounds.Min.Y+y, buf) {
+				planes[i][y*width+x] = float64(v) * gains[i]
+			}
+		}
+	}
+	intensity := planes[0]
+	if len(planes) > 1 {
+		intensity = make([]float64, width*height)
+		for _, plane := range planes {
+			for j, v := range plane {
+				intensity[j] += v
+			}
+		}
+		for j := range intensity {
+			intensity[j] /= float64(len(planes))
+		}
+	}
+
+	ws := r.algorithm.WindowSize
+	widthNew, heightNew := width-ws+1, height-ws+1
+	res := &Result{
+		Width:       widthNew,
+		Height:      heightNew,
+		Contrast:    make([]float64, widthNew*heightNew),
+		FrameWidth:  width,
+		FrameHeight: height,
+		Mean:        intensity,
+		Units:       newUnits(units.Arbitrary),
+	}
+	if exclusion != nil {
+		res.Excluded = exclusion.WindowsTouching(ws)
+	}
+
+	n := float64(ws * ws * len(planes))
+	parallel.Rows(heightNew, func(startY, endY int) {
+		for y := startY; y < endY; y++ {
+			row := res.Contrast[y*widthNew : (y+1)*widthNew]
+			for x := range row {
+				if res.IsExcluded(x, y) {
+					continue
+				}
+				// Два прохода по окну (среднее, затем сумма квадратов отклонений),
+				// как в computeChunkStats.
+				var mean float64
+				for _, plane := range planes {
+					for dy := 0; dy < ws; dy++ {
+						for _, v := range plane[(y+dy)*width+x : (y+dy)*width+x+ws] {
+							mean += v
+						}
+					}
+				}
+				mean /= n
+				var sumDiff2 float64
+				for _, plane := range planes {
+					for dy := 0; dy < ws; dy++ {
+						for _, v := range plane[(y+dy)*width+x : (y+dy)*width+x+ws] {
+							sumDiff2 += float64((v - mean) * (v - mean))
+						}
+					}
+				}
+				if mean > 0 {
+					row[x] = math.Sqrt(sumDiff2/(n-1)) / mean
+				}
+			}
+		}
+	})
+	return res
+}
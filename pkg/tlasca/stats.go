@@ -0,0 +1,224 @@
+package tlasca
+
+import (
+	"context"
+	"math"
+
+	"github.com/mascotmascot1/go-tlasca/internal/parallel"
+	"github.com/mascotmascot1/go-tlasca/pkg/frame"
+)
+
+// temporalStats хранит достаточные статистики временного ряда интенсивности
+// для каждого пикселя кадра: число отсчетов n, среднее mean и сумму квадратов
+// отклонений от среднего M2.
+//
+// Этих трех величин достаточно, чтобы вычислить выборочную дисперсию, а также
+// точно объединить статистики, посчитанные по разным (непересекающимся) частям
+// последовательности кадров. Благодаря этому длинную запись можно обрабатывать
+// порциями (chunks), не удерживая в памяти весь стек изображений.
+type temporalStats struct {
+	width, height int
+	// n - число кадров, учтенных в статистике (одинаково для всех пикселей).
+	n int
+	// mean и m2 хранят построчно (y*width + x) среднее и M2 для каждого пикселя.
+	mean []float64
+	m2   []float64
+	// rows отмечает строки кадра, статистики которых рассчитаны, если расчет прерван
+	// (см. calculateContrastMap); nil означает, что рассчитаны все строки.
+	rows []bool
+}
+
+// newTemporalStats создает пустую статистику (n = 0) для кадров размера width x height.
+func newTemporalStats(width, height int) *temporalStats {
+	return &temporalStats{
+		width:  width,
+		height: height,
+		mean:   make([]float64, width*height),
+		m2:     make([]float64, width*height),
+	}
+}
+
+// computeChunkStats вычисляет статистики для одной порции кадров.
+// Расчет ведется в два прохода по порции (сначала среднее, затем M2),
+// что совпадает с классической формулой выборочной дисперсии.
+// Строки изображения обрабатываются параллельно; каждая строка всех кадров
+// читается один раз через frame.Frame.Row, а статистики строки накапливаются
+// покадрово ядрами строки (см. accumulate.go), векторизованными на amd64.
+//
+// gains задает попадровые коэффициенты, на которые умножается интенсивность кадра
+// перед расчетом (например, нормировка по экспозиции); nil означает единичные коэффициенты.
+// При fast статистики вычисляются за один проход (см. fastMoments).
+// Если ctx отменен до завершения расчета (отмена проверяется перед каждой строкой),
+// возвращает вместе с ошибкой статистики с отметкой рассчитанных строк (см. temporalStats.rows).
+// Обработанные строки учитываются в done (может быть nil).
+// При выбранной реализации KernelOpenCL статистики рассчитываются на устройстве
+// (см. openclChunkStats).
+func computeChunkStats(ctx context.Context, images []frame.Frame, gains []float64, fast bool, done *progress) (*temporalStats, error) {
+	if gains == nil {
+		gains = make([]float64, len(images))
+		for i := range gains {
+			gains[i] = 1
+		}
+	}
+	if useOpenCL.Load() {
+		return openclChunkStats(ctx, images, gains, fast, done)
+	}
+	bounds := images[0].Bounds()
+	s := newTemporalStats(bounds.Dx(), bounds.Dy())
+	s.n = len(images)
+	n := float64(len(images))
+
+	rows := make([]bool, s.height)
+	failed := parallel.TryRows(s.height, func(startY, endY int) {
+		bufs := make([][]uint16, len(images))
+		frameRows := make([][]uint16, len(images))
+		for t, img := range images {
+			bufs[t] = frame.RowBuffer(img)
+		}
+		for y := startY; y < endY; y++ {
+			if ctx.Err() != nil {
+				return
+			}
+			for t, img := range images {
+				frameRows[t] = img.Row(bounds.Min.Y+y, bufs[t])
+			}
+			row := y * s.width
+			mean, m2 := s.mean[row:row+s.width], s.m2[row:row+s.width]
+			if fast {
+				// mean и m2 сначала накапливают сумму и сумму квадратов.
+				for t, frameRow := range frameRows {
+					accumulateRow(mean, m2, frameRow, gains[t])
+				}
+				for x := range mean {
+					mean[x], m2[x] = fastMoments(mean[x], m2[x], n)
+				}
+			} else {
+				for t, frameRow := range frameRows {
+					addRow(mean, frameRow, gains[t])
+				}
+				// среднее по времени
+				for x := range mean {
+					mean[x] /= n
+				}
+				for t, frameRow := range frameRows {
+					addSquaredDeviations(m2, mean, frameRow, gains[t])
+				}
+			}
+			rows[y] = true
+			done.add(1)
+		}
+	})
+	if failed != nil {
+		return nil, failed
+	}
+	if err := cancelled(ctx); err != nil {
+		s.rows = rows
+		return s, err
+	}
+	return s, nil
+}
+
+// fastMoments возвращает среднее и M2 по сумме sum и сумме квадратов sumSq
+// n отсчетов: M2 = Σv² - (Σv)²/n. Однопроходная формула быстрее двухпроходной
+// (кадры читаются один раз), но теряет точность при вычитании близких величин,
+// если контраст мал; отрицательный из-за округления M2 заменяется нулем.
+func fastMoments(sum, sumSq, n float64) (mean, m2 float64) {
+	mean = sum / n
+	return mean, max(sumSq-sum*mean, 0)
+}
+
+// merge объединяет статистику other с текущей (параллельный алгоритм Чана и др.):
+//
+//	n    = n_a + n_b
+//	δ    = mean_b - mean_a
+//	mean = mean_a + δ * n_b / n
+//	M2   = M2_a + M2_b + δ² * n_a * n_b / n
+//
+// Результат объединения не зависит от того, как последовательность была разбита
+// на порции (с точностью до ошибок округления).
+func (s *temporalStats) merge(other *temporalStats) {
+	if other.n == 0 {
+		return
+	}
+	if s.n == 0 {
+		s.n = other.n
+		copy(s.mean, other.mean)
+		copy(s.m2, other.m2)
+		return
+	}
+
+	na, nb := float64(s.n), float64(other.n)
+	n := na + nb
+	for i := range s.mean {
+		delta := other.mean[i] - s.mean[i]
+		s.mean[i] += delta * nb / n
+		s.m2[i] += other.m2[i] + delta*delta*na*nb/n
+	}
+	s.n += other.n
+}
+
+// subtractNoise вычитает из выборочной дисперсии каждого пикселя дисперсию аддитивного
+// шума noise (той же длины, что и плоскости статистик); отрицательная разность
+// (шум оценки превысил дисперсию сигнала) заменяется нулем.
+func (s *temporalStats) subtractNoise(noise []float64) {
+	scale := float64(s.n - 1)
+	for i, v := range noise {
+		s.m2[i] = max(s.m2[i]-float64(v*scale), 0)
+	}
+}
+
+// stdDevPlane вычисляет попиксельное выборочное стандартное отклонение sqrt(M2 / (n-1)).
+func (s *temporalStats) stdDevPlane() []float64 {
+	stdDev := make([]float64, len(s.m2))
+	for i, m2 := range s.m2 {
+		stdDev[i] = math.Sqrt(m2 / float64(s.n-1))
+	}
+	return stdDev
+}
+
+// contrastPlane вычисляет попиксельный временной контраст K = σ/μ, где σ - корень
+// из выборочной дисперсии M2 / (n-1). Для пикселей с нулевым средним контраст равен 0.
+func (s *temporalStats) contrastPlane() []float64 {
+	contrast := make([]float64, len(s.mean))
+	for i, mean := range s.mean {
+		variance := s.m2[i] / float64(s.n-1)
+		stdDev := math.Sqrt(variance)
+		if mean > 0 {
+			contrast[i] = stdDev / mean
+		}
+	}
+	return contrast
+}
+
+// summedArea - таблица сумм (summed-area table, интегральное изображение) плоскости
+// значений: sum[y*(width+1)+x] - сумма значений прямоугольника [0, x) x [0, y).
+// Сумма значений любого прямоугольника вычисляется по четырем элементам таблицы за O(1).
+type summedArea struct {
+	width int
+	sum   []float64
+}
+
+// newSummedArea строит таблицу сумм плоскости values размером width x height (построчно).
+// Таблица строится последовательно в фиксированном порядке, поэтому ее значения
+// не зависят от числа ядер. Значения плоскости должны быть конечными: NaN или бесконечность
+// исказили бы суммы всех прямоугольников, которые правее и ниже такого значения.
+func newSummedArea(values []float64, width, height int) *summedArea {
+	s := &summedArea{width: width, sum: make([]float64, (width+1)*(height+1))}
+	for y := 0; y < height; y++ {
+		var rowSum float64
+		above := s.sum[y*(width+1) : (y+1)*(width+1)]
+		row := s.sum[(y+1)*(width+1) : (y+2)*(width+1)]
+		for x, v := range values[y*width : (y+1)*width] {
+			rowSum += v
+			row[x+1] = above[x+1] + rowSum
+		}
+	}
+	return s
+}
+
+// rect возвращает сумму значений прямоугольника w x h с верхним левым углом (x, y).
+func (s *summedArea) rect(x, y, w, h int) float64 {
+	stride := s.width + 1
+	top, bottom := y*stride, (y+h)*stride
+	return s.sum[bottom+x+w] - s.sum[bottom+x] - s.sum[top+x+w] + s.sum[top+x]
+}
@@ -0,0 +1,128 @@
+package tlasca
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/mascotmascot1/go-tlasca/internal/parallel"
+	"github.com/mascotmascot1/go-tlasca/pkg/frame"
+)
+
+// FrameSource последовательно выдает кадры последовательности для RunStream.
+type FrameSource interface {
+	// Next возвращает следующий кадр последовательности или io.EOF после последнего кадра.
+	// Вместо нечитаемого кадра может вернуть nil без ошибки: такой кадр пропускается
+	// вместе со своим коэффициентом.
+	Next() (frame.Frame, error)
+}
+
+// sizedSource - источник кадров, знающий их общее число. Если источник RunStream реализует
+// этот интерфейс, число кадров используется как Progress.Total этапа ProgressFrames.
+type sizedSource interface {
+	Len() int
+}
+
+// RunStream выполняет расчет временного контраста за один проход по кадрам источника src:
+// каждый кадр сразу учитывается в попиксельных среднем и M2 (алгоритм Уэлфорда) и больше
+// не нужен, поэтому в памяти, помимо текущего кадра, хранятся только две плоскости
+// статистик независимо от длины последовательности. Алгоритм Уэлфорда устойчив к ошибкам
+// округления, и результат совпадает с Run с точностью до них (но не побитово).
+//
+// opts.Gains задаются в порядке кадров источника. Возвращает ошибку, если чтение источника
+// завершилось неудачно, кадры имеют разный размер, читаемых кадров меньше двух
+// или ctx отменен (отмена проверяется перед чтением каждого кадра). О прочитанных кадрах
+// сообщается в opts.Progress (этап ProgressFrames); общее число кадров известно,
+// если источник реализует метод Len() int. Если до ошибки или отмены учтено не меньше
+// двух кадров, ошибка содержит частичный результат (*InterruptedError) по этим кадрам.
+func (r *Runner) RunStream(ctx context.Context, src FrameSource, opts Options) (_ *Result, err error) {
+	defer recoverPanic(&err)
+	r.logger.Println("starting streaming contrast map calculation...")
+	var stats *temporalStats
+	total := 0
+	if sized, ok := src.(sizedSource); ok {
+		total = sized.Len()
+	}
+	frames := newProgress(opts.Progress, ProgressFrames, total)
+	index := 0
+	for ; ; index++ {
+		if err := cancelled(ctx); err != nil {
+			return nil, r.salvage(ctx, stats, err, total, opts)
+		}
+		img, err := src.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, r.salvage(ctx, stats, fmt.Errorf("failed to read frame %d: %w", index+1, err), total, opts)
+		}
+		if img == nil {
+			frames.add(1)
+			continue
+		}
+		gain := 1.0
+		if opts.Gains != nil {
+			if index >= len(opts.Gains) {
+				return nil, r.salvage(ctx, stats, fmt.Errorf("frame %d has no gain: %d gains for the sequence", index+1, len(opts.Gains)), total, opts)
+			}
+			gain = opts.Gains[index]
+		}
+		bounds := img.Bounds()
+		if stats == nil {
+			if err := r.algorithm.checkFrame(bounds.Dx(), bounds.Dy()); err != nil {
+				return nil, err
+			}
+			stats = newTemporalStats(bounds.Dx(), bounds.Dy())
+		} else if bounds.Dx() != stats.width || bounds.Dy() != stats.height {
+			return nil, r.salvage(ctx, stats, fmt.Errorf("frame %d has size %dx%d, expected %dx%d",
+				index+1, bounds.Dx(), bounds.Dy(), stats.width, stats.height), total, opts)
+		}
+		stopStats := r.telemetry.Start("statistics")
+		err = stats.accumulate(img, gain)
+		stopStats()
+		if err != nil {
+			// Статистики прерванного кадра неполны, поэтому частичный результат не строится.
+			return nil, fmt.Errorf("frame %d: %w", index+1, err)
+		}
+		frames.add(1)
+	}
+	if stats == nil || stats.n < 2 {
+		return nil, fmt.Errorf("at least 2 readable frames are required")
+	}
+	r.logger.Printf("processed %d frames.\n", index)
+	res, err := r.calculateContrastMap(ctx, stats, opts)
+	if err != nil {
+		return nil, r.interrupted(res, err, total)
+	}
+	r.logger.Println("calculation finished.")
+	return res, nil
+}
+
+// accumulate учитывает кадр img с коэффициентом gain в статистиках (шаг алгоритма Уэлфорда):
+//
+//	n    = n + 1
+//	δ    = v - mean
+//	mean = mean + δ / n
+//	M2   = M2 + δ · (v - mean)
+//
+// Строки кадра обрабатываются параллельно. Паника в обработке строк возвращается
+// ошибкой *PanicError; статистики кадра при этом учтены не полностью.
+func (s *temporalStats) accumulate(img frame.Frame, gain float64) error {
+	s.n++
+	n := float64(s.n)
+	bounds := img.Bounds()
+	return parallel.TryRows(s.height, func(startY, endY int) {
+		buf := frame.RowBuffer(img)
+		for y := startY; y < endY; y++ {
+			mean := s.mean[y*s.width : (y+1)*s.width]
+			m2 := s.m2[y*s.width : (y+1)*s.width]
+			for x, raw := range img.Row(bounds.Min.Y+y, buf) {
+				v := float64(raw) * gain
+				delta := v - mean[x]
+				mean[x] += delta / n
+				m2[x] += delta * (v - mean[x])
+			}
+		}
+	})
+}
@@ -0,0 +1,379 @@
+// Package tlasca содержит основную логику для вычисления
+// карты контраста на основе алгоритма Temporal Laser Speckle Contrast Analysis.
+//
+// Пакет не зависит от конфигурации и ввода-вывода программы go-tlasca и может
+// использоваться в других программах: кадры передаются через интерфейс frame.Frame,
+// параметры алгоритма - через Params.
+//
+//	runner := tlasca.NewRunner(tlasca.Params{WindowSize: 7}, nil, nil)
+//	res, err := runner.Run(ctx, frames, tlasca.Options{})
+//
+// Методы расчета принимают контекст: после его отмены рабочие горутины прекращают
+// обработку строк, и метод возвращает ошибку, для которой errors.Is(err, context.Canceled)
+// (или context.DeadlineExceeded) истинно. Run, RunChunked и RunStream при этом сохраняют
+// уже выполненную работу: ошибка имеет тип *InterruptedError и содержит частичный результат
+// с отметкой нерассчитанных положений окна (так же - при ошибке чтения кадров в RunChunked
+// и RunStream, если до нее прочитано не меньше двух кадров).
+//
+// Паника в рабочей горутине расчета не завершает процесс: методы Runner, возвращающие
+// ошибку, возвращают ее как *PanicError с блоком строк и диапазоном кадров (паники
+// нескольких горутин объединяются). В RunSpatial и RunSpatiotemporal паника повторяется
+// в вызывающей горутине со значением - такой же ошибкой.
+package tlasca
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"slices"
+
+	"github.com/mascotmascot1/go-tlasca/internal/parallel"
+	"github.com/mascotmascot1/go-tlasca/pkg/frame"
+	"github.com/mascotmascot1/go-tlasca/pkg/mask"
+	"github.com/mascotmascot1/go-tlasca/pkg/units"
+)
+
+// ChunkLoader загружает кадры последовательности с индексами [start, end).
+// Используется в RunChunked, чтобы в памяти одновременно находилась только одна порция кадров.
+// Вместо нечитаемого кадра загрузчик может вернуть nil: такой кадр пропускается.
+type ChunkLoader func(start, end int) ([]frame.Frame, error)
+
+// Options содержит необязательные данные запуска, дополняющие последовательность кадров.
+// Нулевое значение Options означает обработку кадров без дополнительных преобразований.
+type Options struct {
+	// Gains задает попадровые коэффициенты, на которые умножается интенсивность кадра
+	// перед расчетом (нормировка к полной шкале, по экспозиции), в порядке кадров
+	// всей последовательности; nil означает единичные коэффициенты.
+	Gains []float64
+	// Times задает времена регистрации кадров в секундах в порядке кадров всей
+	// последовательности (строго возрастающие); nil означает равномерную съемку, при которой
+	// время - индекс кадра. Используется в IntensityTrend и ContrastTrend.
+	Times []float64
+	// Exclusion задает маску исключаемых пикселей кадра (блики, маркеры).
+	// Положения окна, содержащие хотя бы один исключенный пиксель, не рассчитываются
+	// и выводятся со значением 0; nil означает отсутствие исключений.
+	Exclusion *mask.Mask
+	// NoiseVariance задает попиксельную дисперсию аддитивного шума камеры (в единицах
+	// интенсивности после умножения на Gains, построчно для всего кадра), которая вычитается
+	// из временной дисперсии перед расчетом контраста; nil означает отсутствие поправки.
+	// Используется только при расчете временного контраста.
+	NoiseVariance []float64
+	// Progress получает сведения о выполнении этапов расчета (см. ProgressFunc); nil означает,
+	// что сведения не нужны. Используется в Run, RunChunked, RunStream, RunSliding и RunPartial.
+	Progress ProgressFunc
+	// IntensityUnit задает единицу интенсивности после умножения на Gains (например,
+	// units.FullScale при нормировке к полной шкале), которая указывается в Result.Units;
+	// пустое значение означает units.Arbitrary.
+	IntensityUnit units.Unit
+}
+
+// Runner инкапсулирует основную логику и зависимости (конфигурацию, логгер, телеметрию)
+// для выполнения алгоритма tLASCA.
+type Runner struct {
+	algorithm Params
+	logger    *log.Logger
+	telemetry Telemetry
+}
+
+// NewRunner является конструктором для Runner. Он создает и инициализирует
+// новый экземпляр со всеми необходимыми зависимостями.
+// Параметры params должны быть проверены (см. Params.Validate). Параметры logger и rec
+// могут быть nil, если журнал расчета и телеметрия этапов не нужны.
+func NewRunner(params Params, logger *log.Logger, rec Telemetry) *Runner {
+	if logger == nil {
+		logger = log.New(io.Discard, "", 0)
+	}
+	if rec == nil {
+		rec = noTelemetry{}
+	}
+	return &Runner{
+		algorithm: params,
+		logger:    logger,
+		telemetry: rec,
+	}
+}
+
+// Run является главной публичной точкой входа для запуска вычислений.
+// Он оркестрирует весь процесс анализа, вызывая внутренние методы для расчетов.
+// Вся последовательность кадров обрабатывается как одна порция.
+// Возвращает ошибку, если окно не помещается в кадр или ctx отменен до завершения
+// расчета; во втором случае ошибка содержит частичный результат (*InterruptedError)
+// по строкам кадра, статистики которых рассчитаны до отмены.
+func (r *Runner) Run(ctx context.Context, grayImages []frame.Frame, opts Options) (_ *Result, err error) {
+	defer recoverPanic(&err)
+	r.logger.Println("starting contrast map calculation...")
+	if err := r.checkWindow(grayImages); err != nil {
+		return nil, err
+	}
+	stats, err := r.computeStats(ctx, grayImages, opts.Gains, opts.Progress)
+	if err != nil {
+		return nil, r.salvage(ctx, stats, withFrames(err, 0, len(grayImages)), len(grayImages), opts)
+	}
+	res, err := r.calculateContrastMap(ctx, stats, opts)
+	if err != nil {
+		return nil, r.interrupted(res, err, len(grayImages))
+	}
+	r.logger.Println("calculation finished.")
+	return res, nil
+}
+
+// RunChunked выполняет расчет для последовательности из total кадров, загружая ее
+// порциями по chunkSize кадров через load. Статистики каждой порции (n, mean, M2)
+// точно объединяются с накопленными, поэтому результат совпадает с Run для всего стека,
+// а в памяти одновременно хранится не более одной порции.
+//
+// Загрузчик может вернуть nil вместо нечитаемого кадра: такой кадр пропускается
+// вместе со своим коэффициентом. Возвращает ошибку, если загрузка какой-либо порции
+// завершилась неудачно, окно не помещается в кадр, порции имеют разный размер кадров,
+// читаемых кадров меньше двух или ctx отменен (отмена проверяется и между порциями, и внутри расчета порции).
+// Если до ошибки или отмены (в том числе паники рабочей горутины, см. PanicError) объединены
+// статистики не меньше двух кадров, ошибка содержит частичный результат (*InterruptedError)
+// по этим кадрам; прерванная порция не учитывается.
+func (r *Runner) RunChunked(ctx context.Context, total, chunkSize int, load ChunkLoader, opts Options) (_ *Result, err error) {
+	defer recoverPanic(&err)
+	r.logger.Printf("starting chunked contrast map calculation (%d frames, %d per chunk)...\n", total, chunkSize)
+
+	var stats *temporalStats
+	frames := newProgress(opts.Progress, ProgressFrames, total)
+	for start := 0; start < total; start += chunkSize {
+		if err := cancelled(ctx); err != nil {
+			return nil, r.salvage(ctx, stats, err, total, opts)
+		}
+		end := min(start+chunkSize, total)
+		images, err := load(start, end)
+		if err != nil {
+			return nil, r.salvage(ctx, stats, fmt.Errorf("failed to load chunk [%d, %d): %w", start, end, err), total, opts)
+		}
+		if stats == nil {
+			if err := r.checkWindow(images); err != nil {
+				return nil, err
+			}
+		}
+
+		var chunkGains []float64
+		if opts.Gains != nil {
+			chunkGains = opts.Gains[start:end]
+		}
+		chunk, err := r.computeStats(ctx, images, chunkGains, nil)
+		if err != nil {
+			return nil, r.salvage(ctx, stats, withFrames(err, start, end), total, opts)
+		}
+		frames.add(end - start)
+		if chunk == nil {
+			r.logger.Printf("skipped frames %d-%d of %d: no readable frames.\n", start+1, end, total)
+			continue
+		}
+		if stats == nil {
+			stats = newTemporalStats(chunk.width, chunk.height)
+		} else if chunk.width != stats.width || chunk.height != stats.height {
+			return nil, r.salvage(ctx, stats, fmt.Errorf("chunk [%d, %d) has frame size %dx%d, expected %dx%d",
+				start, end, chunk.width, chunk.height, stats.width, stats.height), total, opts)
+		}
+		stats.merge(chunk)
+		r.logger.Printf("processed frames %d-%d of %d.\n", start+1, end, total)
+	}
+
+	if stats == nil || stats.n < 2 {
+		return nil, fmt.Errorf("at least 2 readable frames are required")
+	}
+	res, err := r.calculateContrastMap(ctx, stats, opts)
+	if err != nil {
+		return nil, r.interrupted(res, err, total)
+	}
+	r.logger.Println("calculation finished.")
+	return res, nil
+}
+
+// checkWindow возвращает ошибку, если окно не помещается в первый читаемый кадр images.
+// Размеры остальных кадров сверяются с ним при расчете статистик.
+func (r *Runner) checkWindow(images []frame.Frame) error {
+	for _, img := range images {
+		if img != nil {
+			return r.algorithm.checkFrame(img.Bounds().Dx(), img.Bounds().Dy())
+		}
+	}
+	return nil
+}
+
+// cancelled возвращает ошибку отмены расчета, если ctx отменен, и nil иначе.
+// Ошибка оборачивает ctx.Err(), поэтому ее причину можно проверить через errors.Is.
+func cancelled(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("calculation cancelled: %w", err)
+	}
+	return nil
+}
+
+// computeStats вычисляет временные статистики порции кадров, фиксируя этап в телеметрии.
+// При раскладке stack_layout = "planar" порция предварительно транспонируется (см. planarStack).
+// При compute_mode = "fast" используется однопроходный расчет (см. fastMoments).
+// Пропущенные (nil) кадры не учитываются; если читаемых кадров в порции нет, возвращает nil
+// без ошибки. Возвращает ошибку, если ctx отменен до завершения расчета.
+// О выполнении расчета по строкам сообщается в progressFn (этап ProgressStatistics), если он задан.
+func (r *Runner) computeStats(ctx context.Context, images []frame.Frame, gains []float64, progressFn ProgressFunc) (*temporalStats, error) {
+	images, gains = readable(images, gains)
+	if len(images) == 0 {
+		return nil, nil
+	}
+	done := newProgress(progressFn, ProgressStatistics, images[0].Bounds().Dy())
+	fast := r.algorithm.ComputeMode == ModeFast
+	if r.algorithm.StackLayout == LayoutPlanar {
+		stopTranspose := r.telemetry.Start("transpose")
+		stack, err := newPlanarStack(ctx, images)
+		stopTranspose()
+		if err != nil {
+			return nil, err
+		}
+		defer r.telemetry.Start("statistics")()
+		return stack.stats(ctx, gains, fast, done)
+	}
+	defer r.telemetry.Start("statistics")()
+	return computeChunkStats(ctx, images, gains, fast, done)
+}
+
+// readable возвращает кадры порции без пропущенных (nil) и соответствующие им коэффициенты.
+// Если пропущенных кадров нет, срезы возвращаются без копирования.
+func readable(images []frame.Frame, gains []float64) ([]frame.Frame, []float64) {
+	if !slices.Contains(images, nil) {
+		return images, gains
+	}
+	var keptImages []frame.Frame
+	var keptGains []float64
+	for i, img := range images {
+		if img == nil {
+			continue
+		}
+		keptImages = append(keptImages, img)
+		if gains != nil {
+			keptGains = append(keptGains, gains[i])
+		}
+	}
+	return keptImages, keptGains
+}
+
+// windowContrast вычисляет средний временной контраст в окне размером windowSize x windowSize.
+//
+// Принимает:
+//
+//	sums *summedArea: таблица сумм попиксельного временного контраста.
+//	x, y int: координаты верхнего левого угла окна в изображении.
+//
+// Возвращает:
+//
+//	float64: усреднённый временной контраст в пределах окна.
+//
+// Попиксельный контраст рассчитывается заранее по временным статистикам (см. temporalStats):
+// для временного ряда каждого пикселя используется **выборочная дисперсия (sample variance)**
+// с (N-1) в знаменателе. Это критически важно, так как мы работаем с ограниченной выборкой
+// кадров, а не со всей генеральной совокупностью возможных спекл-паттернов.
+// Сумма по окну берется из таблицы сумм за O(1), поэтому время расчета карты
+// не зависит от размера окна.
+func (r *Runner) windowContrast(sums *summedArea, x, y int) float64 {
+	pixelCount := float64(r.algorithm.WindowSize * r.algorithm.WindowSize)
+	// усреднение по всем пикселям окна (относительное измерение изменчивости)
+	return sums.rect(x, y, r.algorithm.WindowSize, r.algorithm.WindowSize) / pixelCount
+}
+
+// calculateContrastMap вычисляет карту контраста изображения параллельно,
+// используя скользящее окно.
+//
+// Принимает:
+//
+//	stats *temporalStats: временные статистики каждого пикселя по всем кадрам
+//	                      (дисперсия уменьшается на opts.NoiseVariance, если она задана).
+//	opts Options: маска исключаемых пикселей и дисперсия шума камеры (могут быть nil).
+//
+// Возвращает:
+//
+//	*Result: карту, где значение соответствует усредненному временному контрасту
+//	         в соответствующей области исходных изображений.
+//
+// Алгоритм:
+// 1. По статистикам вычисляется попиксельный контраст `stdDev / mean` и его таблица сумм (summedArea).
+// 2. Строки карты делятся на небольшие блоки, которые горутины забирают из очереди (parallel.Rows).
+// 3. Для каждого блока строк:
+//   - Для каждого возможного положения окна (верхнего левого угла) размером WindowSize x WindowSize
+//     вычисляется усредненный временной контраст с помощью windowContrast.
+//   - Положения окна, задевающие исключенные пиксели, пропускаются (значение 0), так что
+//     исключенные пиксели не влияют на усреднение в соседних окнах.
+//   - Результат записывается в общий срез карты; запись безопасна, так как каждая
+//     горутина пишет только в строки своего блока.
+//   - Перед каждой строкой проверяется отмена ctx; после отмены горутины завершаются,
+//     и вместе с ошибкой возвращается неполная карта, в которой нерассчитанные строки
+//     отмечены в Result.Incomplete. О выполненных строках сообщается
+//     в opts.Progress (этап ProgressContrastMap).
+//
+// Если статистики рассчитаны не для всех строк кадра (stats.rows), положения окна,
+// задевающие строки без статистик, не рассчитываются и отмечаются в Result.Incomplete.
+func (r *Runner) calculateContrastMap(ctx context.Context, stats *temporalStats, opts Options) (*Result, error) {
+	defer r.telemetry.Start("contrast_map")()
+	if opts.NoiseVariance != nil {
+		stats.subtractNoise(opts.NoiseVariance)
+	}
+	sums := newSummedArea(stats.contrastPlane(), stats.width, stats.height)
+	// Вычисляем размеры итоговой карты контраста.
+	widthNew, heightNew := stats.width-r.algorithm.WindowSize+1, stats.height-r.algorithm.WindowSize+1
+	res := &Result{
+		Width:       widthNew,
+		Height:      heightNew,
+		Contrast:    make([]float64, widthNew*heightNew),
+		FrameWidth:  stats.width,
+		FrameHeight: stats.height,
+		Mean:        stats.mean,
+		StdDev:      stats.stdDevPlane(),
+		Frames:      stats.n,
+		Units:       newUnits(opts.IntensityUnit),
+	}
+
+	// Исключенные пиксели расширяются на размер окна: пропускается любое окно, которое их задевает.
+	if opts.Exclusion != nil {
+		res.Excluded = opts.Exclusion.WindowsTouching(r.algorithm.WindowSize)
+	}
+	// Строки карты, окна которых задевают строки кадра без статистик, не рассчитываются.
+	computed := make([]bool, heightNew)
+	for y := range computed {
+		computed[y] = stats.rows == nil || !slices.Contains(stats.rows[y:y+r.algorithm.WindowSize], false)
+	}
+
+	// --- Параллельное вычисление контраста для каждой строки ---
+	done := newProgress(opts.Progress, ProgressContrastMap, heightNew)
+	failed := parallel.TryRows(heightNew, func(startY, endY int) {
+		// Итерируемся по строкам (y), назначенным этой горутине.
+		for y := startY; y < endY; y++ {
+			if ctx.Err() != nil {
+				computed[y] = false
+				continue
+			}
+			if !computed[y] {
+				done.add(1)
+				continue
+			}
+			row := res.Contrast[y*widthNew : (y+1)*widthNew]
+			for x := range row {
+				if res.IsExcluded(x, y) {
+					continue
+				}
+				row[x] = r.windowContrast(sums, x, y)
+			}
+			done.add(1)
+		}
+	})
+	if failed != nil {
+		return nil, fmt.Errorf("contrast map: %w", failed)
+	}
+	if slices.Contains(computed, false) {
+		res.Incomplete = &mask.Mask{Width: widthNew, Height: heightNew, Set: make([]bool, widthNew*heightNew)}
+		for y, ok := range computed {
+			if !ok {
+				for x := range widthNew {
+					res.Incomplete.Set[y*widthNew+x] = true
+				}
+			}
+		}
+	}
+	if err := cancelled(ctx); err != nil {
+		return res, err
+	}
+	return res, nil
+}
@@ -0,0 +1,191 @@
+package tlasca
+
+import (
+	"context"
+	"fmt"
+	"math"
+
+	"github.com/mascotmascot1/go-tlasca/internal/parallel"
+	"github.com/mascotmascot1/go-tlasca/pkg/frame"
+)
+
+// lineFit накапливает для каждого из нескольких рядов значений, измеренных в общие
+// моменты времени t, статистики линейной регрессии по методу наименьших квадратов:
+// средние времени и значений, сумму квадратов отклонений времени M2t и сумму
+// произведений отклонений C. Обновление однопроходное (алгоритм Уэлфорда для ковариации):
+//
+//	n += 1, δt = t - mean_t, mean_t += δt/n, M2t += δt·(t - mean_t)
+//	mean_v += (v - mean_v)/n, C += δt·(v - mean_v)
+//
+// поэтому ряды любой длины не хранятся в памяти, а результат не зависит от сдвига
+// начала отсчета времени. Наклон прямой - C/M2t.
+type lineFit struct {
+	n          int
+	meanT, m2T float64
+	mean, cov  []float64
+}
+
+// newLineFit создает статистики для size рядов.
+func newLineFit(size int) *lineFit {
+	return &lineFit{mean: make([]float64, size), cov: make([]float64, size)}
+}
+
+// next учитывает момент времени t и возвращает отклонение δt, с которым в add
+// добавляются значения рядов в этот момент.
+func (f *lineFit) next(t float64) float64 {
+	f.n++
+	dt := t - f.meanT
+	f.meanT += dt / float64(f.n)
+	f.m2T += dt * (t - f.meanT)
+	return dt
+}
+
+// add добавляет значение v ряда i в момент времени с отклонением dt (см. next).
+// Вызовы для разных рядов могут выполняться одновременно.
+func (f *lineFit) add(i int, v, dt float64) {
+	f.mean[i] += (v - f.mean[i]) / float64(f.n)
+	f.cov[i] += dt * (v - f.mean[i])
+}
+
+// slopes возвращает наклоны рядов (изменение значения на единицу времени)
+// или ошибку, если учтено меньше двух моментов времени.
+func (f *lineFit) slopes() ([]float64, error) {
+	if f.n < 2 || f.m2T == 0 {
+		return nil, fmt.Errorf("at least 2 time points are required for a linear fit, got %d", f.n)
+	}
+	out := make([]float64, len(f.cov))
+	for i, c := range f.cov {
+		out[i] = c / f.m2T
+	}
+	return out, nil
+}
+
+// frameTime возвращает время кадра i: times[i], если времена заданы, иначе индекс кадра.
+func frameTime(times []float64, i int) float64 {
+	if times != nil {
+		return times[i]
+	}
+	return float64(i)
+}
+
+// IntensityTrend рассчитывает карту наклона линейного тренда интенсивности: для каждого
+// пикселя кадра - наклон прямой, приближающей ряд интенсивности по методу наименьших
+// квадратов, в единицах Result.Mean за кадр (время - индекс кадра в последовательности,
+// поэтому пропуски сохраняют временные интервалы) или за секунду, если заданы
+// Options.Times. Результат - плоскость в геометрии кадра (y*FrameWidth + x). Монотонное
+// изменение (высыхание, деградация образца в задачах биоспеклов) дает наклон,
+// а флуктуации спеклов около среднего - нет.
+//
+// Последовательность из total кадров читается через load порциями по chunkSize
+// (chunkSize <= 0 - одной порцией) один раз. Пропущенные (nil) кадры не учитываются.
+// Возвращает ошибку, если загрузка порции завершилась неудачно, читаемых кадров меньше
+// двух или ctx отменен.
+func (r *Runner) IntensityTrend(ctx context.Context, total, chunkSize int, load ChunkLoader, opts Options) (_ []float64, err error) {
+	defer recoverPanic(&err)
+	defer r.telemetry.Start("trend")()
+	if chunkSize <= 0 {
+		chunkSize = total
+	}
+
+	var fit *lineFit
+	for start := 0; start < total; start += chunkSize {
+		if err := cancelled(ctx); err != nil {
+			return nil, err
+		}
+		end := min(start+chunkSize, total)
+		images, err := load(start, end)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load chunk [%d, %d): %w", start, end, err)
+		}
+		for i, img := range images {
+			if img == nil {
+				continue
+			}
+			bounds := img.Bounds()
+			width := bounds.Dx()
+			if fit == nil {
+				fit = newLineFit(width * bounds.Dy())
+			} else if width*bounds.Dy() != len(fit.mean) {
+				return nil, fmt.Errorf("frame %d size %v differs from the first frame", start+i, bounds.Size())
+			}
+			gain := 1.0
+			if opts.Gains != nil {
+				gain = opts.Gains[start+i]
+			}
+			dt := fit.next(frameTime(opts.Times, start+i))
+			parallel.Rows(bounds.Dy(), func(startY, endY int) {
+				buf := frame.RowBuffer(img)
+				for y := startY; y < endY; y++ {
+					for x, value := range img.Row(bounds.Min.Y+y, buf) {
+						fit.add(y*width+x, float64(value)*gain, dt)
+					}
+				}
+			})
+		}
+	}
+	if fit == nil {
+		return nil, fmt.Errorf("at least 2 readable frames are required")
+	}
+	return fit.slopes()
+}
+
+// ContrastTrend рассчитывает карту наклона линейного тренда контраста: последовательность
+// из total кадров делится на сегменты по segment кадров, для каждого сегмента рассчитывается
+// карта временного контраста (как RunSliding с окном и шагом segment), и для каждого
+// положения окна - наклон прямой, приближающей ряд контраста сегментов, в единицах контраста
+// за кадр (время сегмента - индекс его среднего кадра) или за секунду, если заданы
+// Options.Times (время сегмента - среднее время его кадров). Результат - плоскость
+// в геометрии карты (y*Width + x); исключенные положения равны NaN. В отличие от IntensityTrend,
+// наклон отражает изменение подвижности рассеивателей, а не яркости освещения.
+//
+// В памяти одновременно хранится не более одного сегмента. Возвращает ошибку, если segment
+// меньше 2, рассчитано меньше двух сегментов, загрузка кадров завершилась неудачно или ctx отменен.
+func (r *Runner) ContrastTrend(ctx context.Context, total, segment int, load ChunkLoader, opts Options) (_ []float64, err error) {
+	defer recoverPanic(&err)
+	defer r.telemetry.Start("trend")()
+	if segment < 2 {
+		return nil, fmt.Errorf("trend segment must be at least 2 frames, got %d", segment)
+	}
+	segments := *r
+	segments.algorithm.TemporalWindow = segment
+	segments.algorithm.TemporalStep = segment
+
+	var fit *lineFit
+	var excluded []bool
+	opts.Progress = nil
+	err = segments.RunSliding(ctx, total, load, opts, func(start int, res *Result) error {
+		if fit == nil {
+			fit = newLineFit(len(res.Contrast))
+			if res.Excluded != nil {
+				excluded = res.Excluded.Set
+			}
+		}
+		var t float64
+		for i := start; i < start+segment; i++ {
+			t += frameTime(opts.Times, i)
+		}
+		dt := fit.next(t / float64(segment))
+		parallel.Rows(res.Height, func(startY, endY int) {
+			for i := startY * res.Width; i < endY*res.Width; i++ {
+				fit.add(i, res.Contrast[i], dt)
+			}
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if fit == nil {
+		return nil, fmt.Errorf("no trend segments of %d frames in %d frames", segment, total)
+	}
+	slopes, err := fit.slopes()
+	if err != nil {
+		return nil, err
+	}
+	for i, skip := range excluded {
+		if skip {
+			slopes[i] = math.NaN()
+		}
+	}
+	return slopes, nil
+}
@@ -0,0 +1,52 @@
+package tlasca
+
+import (
+	"context"
+	"errors"
+	"image"
+	"math/rand/v2"
+	"strings"
+	"testing"
+
+	"github.com/mascotmascot1/go-tlasca/pkg/frame"
+)
+
+// TestWindowLargerThanFrame проверяет, что окно больше кадра отклоняется с описанием
+// причины до расчета, а не паникой рабочих горутин.
+func TestWindowLargerThanFrame(t *testing.T) {
+	frames := randomFrames(rand.New(rand.NewPCG(5, 6)), 6, 4, 4)
+	load := func(start, end int) ([]frame.Frame, error) {
+		return frames[start:end], nil
+	}
+	runner := NewRunner(Params{WindowSize: 7}, nil, nil)
+	ctx := context.Background()
+
+	for _, c := range []struct {
+		name string
+		run  func() error
+	}{
+		{"Run", func() error {
+			_, err := runner.Run(ctx, frames, Options{})
+			return err
+		}},
+		{"RunChunked", func() error {
+			_, err := runner.RunChunked(ctx, len(frames), 2, load, Options{})
+			return err
+		}},
+		{"RunPartial", func() error {
+			_, err := runner.RunPartial(ctx, image.Rect(0, 0, 4, 4), 0, len(frames), 2, load, Options{})
+			return err
+		}},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			err := c.run()
+			if err == nil {
+				t.Fatal("window larger than the frame was accepted")
+			}
+			var panicErr *PanicError
+			if errors.As(err, &panicErr) || !strings.Contains(err.Error(), "does not fit") {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+}
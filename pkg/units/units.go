@@ -0,0 +1,59 @@
+// Package units описывает единицы измерения величин, которые рассчитывает и сохраняет
+// go-tlasca: единица указывается в результатах расчета, отчете о запуске, заголовках
+// столбцов CSV-файлов и подписях шкал иллюстраций.
+package units
+
+import "strings"
+
+// Unit - обозначение единицы измерения (например, "µm" или "1/s").
+type Unit string
+
+const (
+	// Dimensionless - безразмерная величина (контраст спеклов, отношение T/τc, корреляция).
+	Dimensionless Unit = "1"
+	// Arbitrary - произвольные единицы: величина пропорциональна физической, но масштаб
+	// не определен (интенсивность без нормировки, индекс кровотока 1/K²).
+	Arbitrary Unit = "a.u."
+	// FullScale - доли полной шкалы разрядности входных данных (нормированная интенсивность).
+	FullScale Unit = "FS"
+	// Perfusion - единицы перфузии (perfusion units), принятые в лазерной допплеровской
+	// и спекл-флоуметрии для относительных оценок кровотока.
+	Perfusion Unit = "PU"
+	// Pixel - пиксели кадра.
+	Pixel Unit = "px"
+	// Micrometer - микрометры.
+	Micrometer Unit = "µm"
+	// Second и Millisecond - секунды и миллисекунды.
+	Second      Unit = "s"
+	Millisecond Unit = "ms"
+	// Hertz - герцы.
+	Hertz Unit = "Hz"
+	// PerSecond - обратные секунды (например, 1/τc).
+	PerSecond Unit = "1/s"
+)
+
+// ASCII возвращает обозначение единицы печатными символами ASCII (µm - "um"),
+// пригодное для шрифта иллюстраций и имен столбцов.
+func (u Unit) ASCII() string {
+	return strings.ReplaceAll(string(u), "µ", "u")
+}
+
+// Column возвращает имя столбца CSV-файла для величины name в единицах u: имя
+// с суффиксом единицы ("dx" в px - "dx_px", "rate" в 1/s - "rate_per_s").
+// Для безразмерных величин и пустой единицы суффикс не добавляется.
+func Column(name string, u Unit) string {
+	if u == "" || u == Dimensionless {
+		return name
+	}
+	suffix := strings.NewReplacer("1/", "per_", "/", "_per_", ".", "").Replace(strings.ToLower(u.ASCII()))
+	return name + "_" + suffix
+}
+
+// Label возвращает подпись величины name в единицах u для шкал иллюстраций
+// в виде "NAME [unit]"; для безразмерных величин и пустой единицы - только имя.
+func Label(name string, u Unit) string {
+	if u == "" || u == Dimensionless {
+		return name
+	}
+	return name + " [" + u.ASCII() + "]"
+}